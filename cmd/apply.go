@@ -0,0 +1,617 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/manifest"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Grant the roles declared in a YAML manifest, or of a plan file",
+	Long: `Grant the roles declared in a YAML manifest, e.g. to prepare the access of
+several engineers to several projects ahead of planned maintenance:
+
+  name: maintenance-2024-06
+  grants:
+    - project: my-project
+      roles: [roles/cloudsql.admin]
+      targets:
+        - resource: buckets/my-backups
+          roles: [roles/storage.objectAdmin]
+      members: [user:alice@example.com, group:dba@example.com]
+      ttl: 8h
+      reason: Database upgrade CHG-1234
+
+The whole manifest is validated before any API call. Each grant is then
+granted in a session of its own, on its project and targets as gta grant
+--target does, and left to expire by its condition like gta grant --keep.
+Roles a member still holds from an earlier apply of the manifest are kept
+rather than granted again.
+
+The bindings record a hash of the name of the manifest. With --prune, the
+unexpired bindings of earlier applies of the manifest that it no longer
+declares are revoked. They are looked up in the policies of the projects
+and resources the manifest declares, and of the sessions of the manifest
+recorded on this machine.
+
+With --dry-run, the plan is printed and nothing is changed.
+
+With --plan, the bindings of a plan file written by gta plan are granted
+instead, and left to expire when planned. The grant is planned again first:
+the plan is refused when it is older than plan.max_age in config, when it no
+longer passes validation or adds other bindings, and when the policies it
+changes were written since, unless --force-refresh applies the new plan.
+
+Example:
+  gta apply -f access.yaml --dry-run
+  gta apply -f access.yaml
+  gta apply -f access.yaml --prune
+  gta apply --plan plan.json`,
+	Args: cobra.NoArgs,
+	RunE: runApply,
+}
+
+// Actions of the steps of an apply plan
+const (
+	applyGrant = "grant"
+	applyKeep  = "keep"
+	applyPrune = "prune"
+)
+
+// applyStep is a binding an apply grants, keeps, or prunes
+type applyStep struct {
+	// Entry is the index of the grant declaring the binding, -1 for prunes
+	Entry    int
+	Action   string
+	Resource string
+	Role     string
+	Member   string
+	Expiry   time.Time
+	// BindingID and Project are those of the bindings pruned
+	BindingID string
+	Project   string
+}
+
+// applyEntry is a grant of the manifest along with the options granting it
+type applyEntry struct {
+	grant manifest.Grant
+	opts  *provider.GCPOptions
+	// result summarizes the outcome of the grant
+	result string
+}
+
+func init() {
+	flags := applyCmd.Flags()
+	flags.StringP("file", "f", "", "Manifest declaring the grants")
+	flags.Bool("prune", false, "Revoke the bindings of earlier applies of the manifest that it no longer declares")
+	flags.String("plan", "", "Plan file written by gta plan to execute instead of a manifest")
+	flags.Bool("force-refresh", false, "Apply a plan whose policies changed since it was made, planning it again")
+	flags.BoolP("dry-run", "d", false, "Print the plan without changing anything")
+	flags.BoolP("yes", "y", false, "Grant without asking for confirmation in an environment requiring it")
+	flags.Bool("allow-service-account-caller", false, "Allow running with service account credentials")
+	applyCmd.MarkFlagsOneRequired("file", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("file", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("prune", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("force-refresh", "file")
+	rootCmd.AddCommand(applyCmd)
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	path := flagString(cmd, "file")
+	prune := flagBool(cmd, "prune")
+	dryRun, err := boolOption(cmd, "dry-run")
+	if err != nil {
+		return err
+	}
+	allowSACaller, err := boolOption(cmd, "allow-service-account-caller")
+	if err != nil {
+		return err
+	}
+	allowSACaller = allowSACaller || cfg.AllowServiceAccountCaller
+	if planPath := flagString(cmd, "plan"); planPath != "" {
+		return runApplyPlan(cmd, planPath, dryRun, flagBool(cmd, "force-refresh"), allowSACaller)
+	}
+
+	m, err := manifest.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := checkManifest(path, m); err != nil {
+		return err
+	}
+	hash := m.Hash()
+
+	if dryRun {
+		logger.Info("Running in dry-run mode - no changes will be made")
+	}
+	if _, err := newEventSink(ctx, false); err != nil {
+		return err
+	}
+	p, err := newGCPProvider(ctx, true, provider.WithServiceAccountCaller(allowSACaller))
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+
+	entries := make([]*applyEntry, len(m.Grants))
+	var denied []string
+	for i, g := range m.Grants {
+		opts := &provider.GCPOptions{
+			Project:  g.Project,
+			Roles:    g.Roles,
+			Members:  g.Members,
+			TTL:      g.Duration,
+			Reason:   g.Reason,
+			Profile:  profile,
+			Targets:  g.GrantTargets(),
+			Manifest: hash,
+		}
+		entries[i] = &applyEntry{grant: g, opts: opts}
+		if err := checkApplyEntry(cmd, p, opts); err != nil {
+			denied = append(denied, fmt.Sprintf("grants[%d]: %v", i, err))
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("manifest %s cannot be applied:\n  %s", path, strings.Join(denied, "\n  "))
+	}
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	targets, err := applyTargets(store, m, hash, prune)
+	if err != nil {
+		return err
+	}
+	var remote []provider.TemporaryBinding
+	projects := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		bindings, err := p.PolicyBindings(target.name)
+		if err != nil {
+			return fmt.Errorf("failed to read the policy: %w", err)
+		}
+		for _, b := range bindings {
+			key := b.Resource + "\x00" + b.BindingID + "\x00" + b.Member
+			if b.Manifest != hash || seen[key] {
+				continue
+			}
+			seen[key] = true
+			remote = append(remote, b)
+			projects[b.Resource] = target.project
+		}
+	}
+
+	now := time.Now()
+	steps := planApply(m.Grants, remote, now)
+	var grants, kept, stale int
+	for i := range steps {
+		switch steps[i].Action {
+		case applyGrant:
+			grants++
+			steps[i].Expiry = now.Add(entries[steps[i].Entry].opts.TTL)
+		case applyKeep:
+			kept++
+		case applyPrune:
+			stale++
+			steps[i].Project = projects[steps[i].Resource]
+		}
+	}
+	if !prune {
+		if stale > 0 {
+			logger.Warn("%d binding(s) of earlier applies of manifest %s are no longer declared, rerun with --prune to revoke them", stale, m.Name)
+		}
+		steps = withoutPrunes(steps)
+		stale = 0
+	}
+	logger.Info("Plan for manifest %s: %d binding(s) to grant, %d to keep, %d to prune", m.Name, grants, kept, stale)
+
+	if dryRun {
+		return writeApplyPlan(os.Stdout, steps)
+	}
+	if grants > 0 {
+		for _, entry := range entries {
+			if err := confirmEnvironment(entry.opts, flagBool(cmd, "yes")); err != nil {
+				return err
+			}
+		}
+	}
+
+	failed := 0
+	for i, entry := range entries {
+		if !pendingEntry(steps, i) {
+			entry.result = "unchanged"
+			continue
+		}
+		if err := applyGrantEntry(cmd, entry, steps, i, allowSACaller); err != nil {
+			logger.Error("Failed to apply grants[%d]: %v", i, err)
+			failed++
+		}
+	}
+	pruneErr := pruneApply(cmd, store, hash, steps, allowSACaller)
+
+	if err := writeApplySummary(os.Stdout, entries); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to apply %d of %d grant(s) of manifest %s", failed, len(entries), path)
+	}
+	return pruneErr
+}
+
+// checkManifest checks a manifest against the config, before any API call,
+// expanding the identities among the members of its grants
+func checkManifest(path string, m *manifest.Manifest) error {
+	var problems []manifest.Problem
+	for i := range m.Grants {
+		g := &m.Grants[i]
+		field := fmt.Sprintf("grants[%d]", i)
+		g.Project = cfg.ProjectAlias(g.Project)
+		for j := range g.Targets {
+			g.Targets[j].Resource = cfg.ProjectAlias(g.Targets[j].Resource)
+		}
+		for j, member := range g.Members {
+			expanded, err := expandMember(member)
+			if err == nil {
+				err = provider.ValidateMember(expanded)
+			}
+			if err != nil {
+				problems = append(problems, manifest.Problem{Field: fmt.Sprintf("%s.members[%d]", field, j), Message: err.Error()})
+				continue
+			}
+			g.Members[j] = expanded
+		}
+		roles := append(append([]string{}, g.Roles...), targetRoles(g.GrantTargets())...)
+		if err := checkGrantPolicy(roles, g.Duration); err != nil {
+			problems = append(problems, manifest.Problem{Field: field, Message: err.Error()})
+		}
+		if cfg.ApprovalRequired(g.Project) {
+			problems = append(problems, manifest.Problem{Field: field + ".project", Message: fmt.Sprintf("project %s requires approval, ask for access with gta request instead", g.Project)})
+		}
+	}
+	if len(problems) > 0 {
+		return &manifest.ValidationError{Path: path, Problems: problems}
+	}
+	return nil
+}
+
+// checkApplyEntry enforces no_self_grant, role_granters, the environment,
+// allowed_granters, and the policy on the grant of opts, as gta grant does
+func checkApplyEntry(cmd *cobra.Command, p *provider.GCPProvider, opts *provider.GCPOptions) error {
+	if err := checkRoleRules(p.CallerIdentities, opts); err != nil {
+		return err
+	}
+	opts.Environment = resolveEnvironment(p, opts.Project)
+	if err := enforceEnvironment(opts); err != nil {
+		return err
+	}
+	if len(cfg.AllowedGranters) == 0 && cfg.Policy.Path == "" {
+		return nil
+	}
+	caller, err := p.Caller()
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+	if len(cfg.AllowedGranters) > 0 {
+		if err := checkGranter(caller, opts); err != nil {
+			return err
+		}
+	}
+	return enforcePolicy(cmd.Context(), p, opts, caller)
+}
+
+// applyTarget is a project ID or resource name whose policy an apply reads,
+// along with the project its bindings are recorded in
+type applyTarget struct {
+	name    string
+	project string
+}
+
+// applyTargets returns the targets the grants of m declare and, with prune,
+// those of the sessions of the manifest recorded on this machine
+func applyTargets(store *state.Store, m *manifest.Manifest, hash string, prune bool) ([]applyTarget, error) {
+	var targets []applyTarget
+	seen := make(map[string]bool)
+	add := func(name, project string) {
+		if !seen[name] {
+			seen[name] = true
+			targets = append(targets, applyTarget{name: name, project: project})
+		}
+	}
+	for _, g := range m.Grants {
+		add(g.Project, g.Project)
+		for _, target := range g.Targets {
+			add(target.Resource, g.Project)
+		}
+	}
+	if !prune {
+		return targets, nil
+	}
+	f, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range f.Sessions {
+		if s.Manifest != hash {
+			continue
+		}
+		for _, target := range s.Targets() {
+			add(target, s.Project)
+		}
+	}
+	return targets, nil
+}
+
+// planApply compares the bindings the grants declare with the unexpired
+// bindings of earlier applies of the manifest in remote: those members
+// still hold are kept, the others granted, and those no longer declared
+// pruned
+func planApply(grants []manifest.Grant, remote []provider.TemporaryBinding, now time.Time) []applyStep {
+	held := make(map[string]provider.TemporaryBinding)
+	for _, b := range remote {
+		if b.Expiry.After(now) {
+			held[b.Resource+"\x00"+b.Role+"\x00"+b.Member] = b
+		}
+	}
+
+	var steps []applyStep
+	declared := make(map[string]bool)
+	for i, g := range grants {
+		for _, b := range g.Bindings() {
+			resource := provider.RoleResource(b.Target, b.Role)
+			key := resource + "\x00" + b.Role + "\x00" + b.Member
+			declared[key] = true
+			step := applyStep{Entry: i, Action: applyGrant, Resource: resource, Role: b.Role, Member: b.Member}
+			if found, ok := held[key]; ok {
+				step.Action = applyKeep
+				step.Expiry = found.Expiry
+				step.BindingID = found.BindingID
+			}
+			steps = append(steps, step)
+		}
+	}
+	for _, b := range remote {
+		key := b.Resource + "\x00" + b.Role + "\x00" + b.Member
+		if declared[key] || !b.Expiry.After(now) {
+			continue
+		}
+		steps = append(steps, applyStep{Entry: -1, Action: applyPrune, Resource: b.Resource, Role: b.Role, Member: b.Member, Expiry: b.Expiry, BindingID: b.BindingID})
+	}
+	return steps
+}
+
+// withoutPrunes returns steps without the prunes
+func withoutPrunes(steps []applyStep) []applyStep {
+	var kept []applyStep
+	for _, step := range steps {
+		if step.Action != applyPrune {
+			kept = append(kept, step)
+		}
+	}
+	return kept
+}
+
+// pendingEntry reports whether the plan grants a binding of the grant at
+// index i
+func pendingEntry(steps []applyStep, i int) bool {
+	for _, step := range steps {
+		if step.Entry == i && step.Action == applyGrant {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingRoles returns the roles of the entry, the grant at index i, the plan
+// grants on the resource of target to a member at least
+func pendingRoles(steps []applyStep, i int, target string, roles []string) []string {
+	var pending []string
+	for _, role := range roles {
+		resource := provider.RoleResource(target, role)
+		for _, step := range steps {
+			if step.Entry == i && step.Action == applyGrant && step.Resource == resource && step.Role == provider.FormatRole(role) {
+				pending = append(pending, role)
+				break
+			}
+		}
+	}
+	return pending
+}
+
+// applyGrantEntry grants the roles of the entry, the grant at index i, that
+// the plan grants, in a session left to expire by its condition
+func applyGrantEntry(cmd *cobra.Command, entry *applyEntry, steps []applyStep, i int, allowSACaller bool) error {
+	opts := entry.opts
+	opts.SessionID = audit.NewID()
+	opts.Roles = pendingRoles(steps, i, opts.Project, entry.grant.Roles)
+	opts.Targets = nil
+	for _, target := range entry.grant.GrantTargets() {
+		if roles := pendingRoles(steps, i, target.Resource, target.Roles); len(roles) > 0 {
+			target.Roles = roles
+			opts.Targets = append(opts.Targets, target)
+		}
+	}
+
+	log := logger.With(slog.String(logger.SessionKey, opts.SessionID), slog.String("project", opts.Project), slog.String("provider", "gcp"))
+	providerOpts := append(confirmOptions(), provider.WithLogger(log), provider.WithServiceAccountCaller(allowSACaller), requestReasonOption(opts.Reason, ""))
+	p, err := newGCPProvider(cmd.Context(), false, providerOpts...)
+	if err != nil {
+		entry.result = "failed"
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	err = p.Grant(opts)
+	flushNotifications()
+	if granted := p.GrantedRoles(); len(granted) > 0 {
+		recordKeptSession(opts, granted)
+	}
+	if err != nil {
+		reportGrantOutcome(p, "left to expire")
+		entry.result = "failed"
+		if len(p.GrantedRoles()) > 0 {
+			entry.result = fmt.Sprintf("failed, %d role(s) granted", len(p.GrantedRoles()))
+		}
+		return err
+	}
+	entry.result = fmt.Sprintf("granted %d role(s) until %s", len(p.GrantedRoles()), sessionExpiry(p.GrantedRoles()).Format(time.RFC3339))
+	return nil
+}
+
+// pruneApply revokes the bindings the plan prunes, one policy write for each
+// resource and member, and forgets them in the sessions of the manifest
+// recorded on this machine
+func pruneApply(cmd *cobra.Command, store *state.Store, hash string, steps []applyStep, allowSACaller bool) error {
+	type group struct {
+		resource, project, member string
+		ids                       []string
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	for _, step := range steps {
+		if step.Action != applyPrune {
+			continue
+		}
+		key := step.Resource + "\x00" + step.Member
+		g, ok := byKey[key]
+		if !ok {
+			g = &group{resource: step.Resource, project: step.Project, member: step.Member}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		if !containsString(g.ids, step.BindingID) {
+			g.ids = append(g.ids, step.BindingID)
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	p, err := newGCPProvider(cmd.Context(), false, provider.WithServiceAccountCaller(allowSACaller))
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	pruned := make(map[string]bool)
+	var failed int
+	for _, g := range groups {
+		opts := &provider.GCPOptions{
+			Project:    g.project,
+			Members:    []string{g.member},
+			BindingIDs: g.ids,
+			AnyPrefix:  true,
+			Profile:    profile,
+		}
+		target := policyTarget(g.resource)
+		if target != g.project {
+			opts.Resource = target
+		}
+		if opts.Project == "" {
+			opts.Project = provider.ResourceProject(target)
+		}
+		logger.Info("Pruning %d binding(s) of %s on %s", len(g.ids), g.member, g.resource)
+		removed, err := p.CleanTemporaryBindings(opts)
+		for _, b := range removed {
+			pruned[b.BindingID+"\x00"+b.Member] = true
+		}
+		if err != nil {
+			logger.Error("Failed to prune the bindings of %s on %s: %v", g.member, g.resource, err)
+			failed++
+		}
+	}
+	flushNotifications()
+	forgetPruned(store, hash, steps, pruned)
+	if failed > 0 {
+		return fmt.Errorf("failed to prune the bindings of %d member(s), run gta apply --prune again to retry", failed)
+	}
+	return nil
+}
+
+// forgetPruned removes from the sessions of the manifest the bindings whose
+// every member was pruned, and the sessions left without bindings
+func forgetPruned(store *state.Store, hash string, steps []applyStep, pruned map[string]bool) {
+	gone := make(map[string]bool)
+	for _, step := range steps {
+		if step.Action != applyPrune {
+			continue
+		}
+		if _, ok := gone[step.BindingID]; !ok {
+			gone[step.BindingID] = true
+		}
+		gone[step.BindingID] = gone[step.BindingID] && pruned[step.BindingID+"\x00"+step.Member]
+	}
+	// Bindings keeping declared members are not forgotten
+	for _, step := range steps {
+		if step.Action == applyKeep {
+			gone[step.BindingID] = false
+		}
+	}
+	err := store.Update(func(f *state.File) error {
+		for _, s := range append([]state.Session(nil), f.Sessions...) {
+			if s.Manifest != hash {
+				continue
+			}
+			for id, ok := range gone {
+				if ok {
+					s.Bindings = removeBinding(s.Bindings, id)
+				}
+			}
+			if len(s.Bindings) == 0 {
+				f.Remove(s.ID)
+			} else {
+				f.Put(s)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Failed to update the session state: %v", err)
+	}
+}
+
+// policyTarget returns the project ID or resource name whose policy is named
+// resource, e.g. my-project for projects/my-project
+func policyTarget(resource string) string {
+	if project, ok := strings.CutPrefix(resource, "projects/"); ok && !strings.Contains(project, "/") {
+		return project
+	}
+	return resource
+}
+
+// writeApplyPlan prints the steps of an apply plan as a table
+func writeApplyPlan(w io.Writer, steps []applyStep) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tACTION\tRESOURCE\tROLE\tMEMBER\tEXPIRY")
+	for _, step := range steps {
+		entry := "-"
+		if step.Entry >= 0 {
+			entry = fmt.Sprintf("grants[%d]", step.Entry)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", entry, step.Action, step.Resource, step.Role, step.Member, orDash(formatExpiry(step.Expiry)))
+	}
+	return tw.Flush()
+}
+
+// writeApplySummary prints the outcome of each grant of an apply as a table
+func writeApplySummary(w io.Writer, entries []*applyEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tPROJECT\tMEMBERS\tTTL\tSESSION\tRESULT")
+	for i, entry := range entries {
+		session := "-"
+		if entry.opts.SessionID != "" {
+			session = entry.opts.SessionID
+		}
+		fmt.Fprintf(tw, "grants[%d]\t%s\t%s\t%s\t%s\t%s\n", i, entry.grant.Project, strings.Join(entry.grant.Members, ","), duration.Format(entry.grant.Duration), session, entry.result)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/approval"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+var approveCmd = &cobra.Command{
+	Use:   "approve <request-id>",
+	Short: "Approve an access request and grant the roles to the requester",
+	Long: `Approve a pending access request created with gta request. The request must be
+approved by someone other than the requester before it expires. The roles are
+granted to the requester with the request's TTL; the bindings expire on their
+own through their IAM condition, and both identities are recorded in the
+binding description and the audit log.
+
+Example:
+  gta approve 3f9a1c2b7d4e5f60`,
+	Args: cobra.ExactArgs(1),
+	RunE: runApprove,
+}
+
+// approveOptions are the options of gta approve
+type approveOptions struct {
+	commonOptions
+	AcceptBroad bool
+}
+
+func init() {
+	flags := approveCmd.Flags()
+	flags.BoolP("dry-run", "d", false, "Preview changes without applying them")
+	flags.Bool("accept-broad", false, "Grant broad roles even when narrower alternatives are recommended")
+	flags.Bool("allow-service-account-caller", false, "Allow running with service account credentials")
+}
+
+func runApprove(cmd *cobra.Command, args []string) error {
+	common, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return err
+	}
+	o := approveOptions{commonOptions: common, AcceptBroad: flagBool(cmd, "accept-broad")}
+
+	ctx := cmd.Context()
+
+	key, err := approvalSigningKey()
+	if err != nil {
+		return err
+	}
+	store, err := newRequestStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	r, err := store.Load(ctx, args[0])
+	if err != nil {
+		if errors.Is(err, approval.ErrNotFound) {
+			return fmt.Errorf("request %s not found", args[0])
+		}
+		return err
+	}
+	if err := r.Verify(key); err != nil {
+		return err
+	}
+	switch r.StatusAt(time.Now()) {
+	case approval.StatusExpired:
+		return fmt.Errorf("request %s expired at %s", r.ID, r.ExpiresAt.Local().Format(time.RFC3339))
+	case approval.StatusApproved:
+		return fmt.Errorf("request %s was already approved by %s", r.ID, r.Approver)
+	}
+	if err := checkGrantPolicy(r.Roles, r.TTL); err != nil {
+		return err
+	}
+
+	if o.DryRun {
+		logger.Info("Running in dry-run mode - no changes will be made")
+	}
+
+	sessionID := audit.NewID()
+	log := useSessionLogger(sessionID, r.Project)
+	p, err := newGCPProvider(ctx, o.DryRun, append(confirmOptions(), provider.WithLogger(log), provider.WithServiceAccountCaller(o.AllowServiceAccountCaller), requestReasonOption(r.Reason, ""))...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	approver, err := p.Caller()
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+	if strings.EqualFold(approver, r.Requester) {
+		return fmt.Errorf("request %s must be approved by someone other than the requester %s", r.ID, r.Requester)
+	}
+
+	opts := &provider.GCPOptions{
+		Project:     r.Project,
+		Roles:       r.Roles,
+		User:        r.Requester,
+		TTL:         r.TTL,
+		Reason:      r.Reason,
+		SessionID:   sessionID,
+		Profile:     profile,
+		RequestID:   r.ID,
+		Requester:   r.Requester,
+		Approver:    approver,
+		AcceptBroad: o.AcceptBroad,
+	}
+	if err := enforcePolicy(ctx, p, opts, approver); err != nil {
+		return err
+	}
+	logger.Info("Approving request %s from %s", r.ID, r.Requester)
+
+	err = p.Grant(opts)
+	flushNotifications()
+	if err != nil {
+		var broadErr *provider.BroadRoleError
+		if errors.As(err, &broadErr) {
+			return fmt.Errorf("%v, rerun with --accept-broad to grant them anyway", err)
+		}
+		rollbackGrant(p, opts)
+		return fmt.Errorf("failed to grant roles: %w", err)
+	}
+	if o.DryRun {
+		return nil
+	}
+
+	r.Status = approval.StatusApproved
+	r.Approver = approver
+	r.ApprovedAt = time.Now().UTC()
+	if err := r.Sign(key); err != nil {
+		return err
+	}
+	if err := store.Save(ctx, r); err != nil {
+		return fmt.Errorf("roles were granted but the approval could not be recorded: %w", err)
+	}
+
+	logger.Info("Granted %s to %s in project %s until %s",
+		strings.Join(r.Roles, ", "), r.Requester, r.Project, sessionExpiry(p.GrantedRoles()).Local().Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Manage the audit log of temporary access",
+}
+
+var auditReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Backfill the BigQuery audit table from the local audit log",
+	Long: `Backfill the BigQuery audit table configured under audit.bigquery from the
+local JSON lines audit log. Events already present in the table, found by
+their ID, are skipped.
+
+Example:
+  gta audit replay
+  gta audit replay --file ./audit.jsonl`,
+	Args: cobra.NoArgs,
+	RunE: runAuditReplay,
+}
+
+func init() {
+	auditReplayCmd.Flags().StringP("file", "f", "", "Audit log to replay (default is the configured audit log)")
+	auditCmd.AddCommand(auditReplayCmd)
+}
+
+func runAuditReplay(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if cfg.Audit.BigQuery == nil {
+		return fmt.Errorf("audit.bigquery is not configured")
+	}
+
+	path := flagString(cmd, "file")
+	if path == "" {
+		var err error
+		if path, err = cfg.AuditPath(); err != nil {
+			return err
+		}
+	}
+
+	cipher, err := fileCipher()
+	if err != nil {
+		return err
+	}
+	events, err := audit.ReadLog(path, cipher)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		logger.Info("No events found in %s", path)
+		return nil
+	}
+
+	exporter, err := newBigQueryExporter(ctx)
+	if err != nil {
+		return err
+	}
+	defer exporter.Close()
+
+	logger.Info("Replaying %d event(s) from %s", len(events), path)
+	appended, err := exporter.Replay(ctx, events)
+	if err != nil {
+		return fmt.Errorf("failed to replay events: %v", err)
+	}
+	logger.Info("Successfully replayed %d event(s), %d already in the table", appended, len(events)-appended)
+	return nil
+}
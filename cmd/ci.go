@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/ci"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// commandWaitDelay is how long a wrapped command may take to exit after it
+// was interrupted before it is killed
+const commandWaitDelay = 10 * time.Second
+
+// commandError is the failure of the command wrapped by gta grant, whose
+// exit code gta exits with
+type commandError struct {
+	name string
+	code int
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("%s exited with status %d", e.name, e.code)
+}
+
+// splitCommand splits the arguments of gta grant into the roles and the
+// command given after --
+func splitCommand(cmd *cobra.Command, args []string) (roles, command []string) {
+	dash := cmd.ArgsLenAtDash()
+	if dash < 0 {
+		return args, nil
+	}
+	return args[:dash], args[dash:]
+}
+
+// newCIReporter returns the reporter of a grant with --ci, nil without it
+func newCIReporter(o *grantOptions) *ci.Reporter {
+	if !o.CI {
+		return nil
+	}
+	return ci.New(os.Stdout, o.CISummary)
+}
+
+// maskMembers hides the emails of the members of a grant with --ci in the
+// logs and, in GitHub Actions, in the job log
+func maskMembers(reporter *ci.Reporter, members ...string) {
+	if reporter == nil {
+		return
+	}
+	for _, member := range members {
+		for _, principal := range sessionMembers(member) {
+			_, email, _ := strings.Cut(principal, ":")
+			logger.Mask(principal, email)
+			reporter.Mask(email)
+		}
+	}
+}
+
+// runWrapped runs the command wrapped by gta grant with the standard streams
+// of gta, interrupting it when ctx is done
+func runWrapped(ctx context.Context, command []string) error {
+	c := exec.CommandContext(ctx, command[0], command[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	c.Cancel = func() error {
+		return interruptProcess(c.Process)
+	}
+	c.WaitDelay = commandWaitDelay
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &commandError{name: command[0], code: exitErr.ExitCode()}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %v", command[0], err)
+	}
+	return nil
+}
+
+// writeCISummary appends the session to the job summary, warning on failure
+// as the grant itself succeeded
+func writeCISummary(reporter *ci.Reporter, opts *provider.GCPOptions, member string, granted []provider.GrantedRole, revoke string) {
+	summary := ci.Summary{
+		SessionID:   opts.SessionID,
+		Project:     opts.Project,
+		Environment: opts.Environment,
+		Member:      logger.Redact(member),
+		Revoke:      revoke,
+	}
+	for _, role := range granted {
+		resource := role.Resource()
+		if resource == "" {
+			resource = "projects/" + opts.Project
+		}
+		summary.Bindings = append(summary.Bindings, ci.Binding{Resource: resource, Role: role.Role, ID: role.BindingID, Expiry: role.Expiry, AddedFor: role.AddedFor})
+	}
+	if err := reporter.WriteSummary(summary); err != nil {
+		logger.Warn("%v", err)
+	}
+}
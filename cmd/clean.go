@@ -1,12 +1,27 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/checkpoint"
+	"github.com/yckao/gta/pkg/config"
 	"github.com/yckao/gta/pkg/logger"
 	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+	"github.com/yckao/gta/pkg/workerpool"
 )
 
 var cleanCmd = &cobra.Command{
@@ -15,6 +30,35 @@ var cleanCmd = &cobra.Command{
 	Long: `Clean up temporary IAM role bindings in a project. If a user is specified,
 only bindings for that user will be cleaned up.
 
+With --organization, --folder, or --all-projects, every active project in
+scope is cleaned, several at a time, optionally limited by a project filter.
+The projects are scanned first and the bindings found are reported by
+project; removing them needs the total count typed back, or --yes. A project
+that fails does not stop the others. Completed projects are recorded in a
+checkpoint file, so that re-running an interrupted clean with the same flags
+resumes where it stopped. The run ends with a report of the projects scanned,
+the ID of every binding removed, and the projects skipped for missing
+permissions or that failed.
+
+When another tool rewrote the condition title of a binding created by a
+session recorded on this machine, a single project or resource clean matches
+it by member, role, and an expiry within a minute of the recorded one instead.
+Only bindings whose condition is nothing but that expiry are matched, and
+they are removed with --fuzzy-match or once confirmed in a terminal.
+
+Members of principals deleted since they were granted, which policies list
+as deleted:user:alice@example.com?uid=..., are only cleaned with
+--include-deleted, on by default with --expired as their access is gone for
+good. They are then matched by --user and --member as the principal they
+were, and listed marked as deleted.
+
+With --compact, nothing is revoked: the active temporary bindings of a role
+whose expiries fall within --compact-window of each other are merged into the
+one expiring last, holding all their members, and the others are removed, in
+a single write of each policy. No member loses access earlier than before,
+some keep it a little longer. Only bindings whose condition is nothing but
+their expiry are merged.
+
 Example:
   # List all temporary bindings that would be cleaned
   gta clean --project=my-project --dry-run
@@ -23,39 +67,574 @@ Example:
   gta clean --project=my-project
 
   # Clean up temporary bindings for a specific user
-  gta clean --project=my-project --user=user@example.com`,
+  gta clean --project=my-project --user=user@example.com
+
+  # Clean up only bindings that have already expired
+  gta clean --project=my-project --expired
+
+  # Also clean up the bindings of principals deleted since
+  gta clean --project=my-project --include-deleted
+
+  # Also clean up the bindings of recorded sessions whose title was rewritten
+  gta clean --project=my-project --fuzzy-match
+
+  # Merge the bindings of a role expiring within 30 minutes of each other
+  gta clean --project=my-project --compact --compact-window=30m --dry-run
+
+  # Clean up the bindings granted on an instance and its IAP tunnel
+  gta clean --instance=my-project/europe-west1-b/bastion
+
+  # Preview cleaning expired bindings in every project of an organization
+  gta clean --organization=123456789 --expired --dry-run
+
+  # Clean the production projects of a folder without confirmation
+  gta clean --folder=987654321 --filter="labels.env:prod" --yes`,
 	RunE: runClean,
 }
 
+// cleanOptions are the options of gta clean
+type cleanOptions struct {
+	commonOptions
+	Expired     bool
+	CreatedBy   string
+	AnyPrefix   bool
+	AllProjects bool
+	Scope       provider.ProjectScope
+	Yes         bool
+	// IncludeDeleted also cleans the members of deleted principals
+	IncludeDeleted bool
+	// Resource is the resource cleaned instead of the project
+	Resource string
+	// Compact merges the bindings of a role expiring within CompactWindow
+	// of each other instead of removing them
+	Compact       bool
+	CompactWindow time.Duration
+}
+
 func init() {
 	flags := cleanCmd.Flags()
-	flags.StringVarP(&project, "project", "p", "", "Project ID")
-	flags.StringVarP(&user, "user", "u", "", "Filter bindings by user")
-	flags.BoolVarP(&dryRun, "dry-run", "d", false, "Preview bindings that would be cleaned without making any changes")
-
-	cleanCmd.MarkFlagRequired("project")
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID (required unless cleaning many projects)")
+	flags.StringP("user", "u", "", "Filter bindings by user, me, or @NAME of identities in config")
+	flags.StringArray("member", nil, "Filter bindings by exact principal instead of --user, e.g. group:team@example.com (repeatable)")
+	flags.String("instance", "", "Clean the bindings of this Compute Engine instance and its IAP tunnel, [PROJECT/]ZONE/INSTANCE, instead of the project")
+	flags.String("subnet", "", "Clean the bindings of this Shared VPC subnetwork, projects/HOST_PROJECT/regions/REGION/subnetworks/SUBNET, instead of the project")
+	flags.BoolP("dry-run", "d", false, "Preview bindings that would be cleaned without making any changes")
+	flags.Bool("expired", false, "Only clean up bindings whose expiry has passed")
+	flags.Bool("include-deleted", false, "Also clean up the bindings of principals deleted since they were granted (default true with --expired)")
+	flags.String("created-by", "", "Only clean up bindings created by this caller, as recorded in their description")
+	flags.Bool("any-prefix", false, "Also clean up the bindings of the prefixes in known_binding_prefixes and the default prefix")
+	flags.Bool("allow-service-account-caller", false, "Allow running with service account credentials")
+	flags.Bool("all-projects", false, "Clean every active project in scope instead of a single project")
+	flags.String("organization", "", "Clean every active project of this organization ID")
+	flags.String("folder", "", "Clean every active project of this folder ID, including subfolders")
+	flags.String("filter", "", "When cleaning many projects, only clean those matching this Resource Manager filter")
+	flags.BoolP("yes", "y", false, "Remove the bindings found in many projects without asking for confirmation")
+	flags.Bool("fuzzy-match", false, "Also remove the bindings of sessions recorded on this machine whose title was rewritten, matched by member, role, and expiry")
+	flags.Bool("compact", false, "Merge the active bindings of a role expiring close together into one instead of removing them")
+	addDurationFlag(cleanCmd, "compact-window", "", 15*time.Minute, "With --compact, merge the bindings of a role expiring within this duration of each other")
+	cleanCmd.MarkFlagsMutuallyExclusive("instance", "subnet")
+	registerMemberCompletion(cleanCmd)
+	memberFilters[cleanCmd] = true
 }
 
 func runClean(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	common, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return err
+	}
+	o := cleanOptions{
+		commonOptions: common,
+		Expired:       flagBool(cmd, "expired"),
+		CreatedBy:     stringOption(cmd, "created-by", ""),
+		AnyPrefix:     flagBool(cmd, "any-prefix"),
+		AllProjects:   flagBool(cmd, "all-projects"),
+		Scope: provider.ProjectScope{
+			Organization: stringOption(cmd, "organization", ""),
+			Folder:       stringOption(cmd, "folder", ""),
+			Filter:       stringOption(cmd, "filter", ""),
+		},
+		Yes:     flagBool(cmd, "yes"),
+		Compact: flagBool(cmd, "compact"),
+	}
+	// The binding of a deleted principal is stale by definition
+	o.IncludeDeleted = o.Expired
+	if _, ok := lookupOption(cmd, "include-deleted"); ok {
+		if o.IncludeDeleted, err = boolOption(cmd, "include-deleted"); err != nil {
+			return err
+		}
+	}
+	if o.CompactWindow, err = durationOption(cmd, "compact-window", 0); err != nil {
+		return err
+	}
+	if err := o.checkCompact(cmd); err != nil {
+		return err
+	}
+	if o.Resource, err = resourceOption(cmd, &o.commonOptions); err != nil {
+		return err
+	}
+	// An organization or folder selects many projects by itself
+	o.AllProjects = o.AllProjects || o.Scope.Organization != "" || o.Scope.Folder != ""
+	if o.AllProjects {
+		if _, ok := lookupOption(cmd, "project"); ok {
+			return fmt.Errorf("--project cannot be combined with --all-projects, --organization, or --folder")
+		}
+		if o.Scope.Organization != "" && o.Scope.Folder != "" {
+			return fmt.Errorf("--organization and --folder are mutually exclusive")
+		}
+		if o.Resource != "" {
+			return fmt.Errorf("--instance and --subnet cannot be combined with --all-projects, --organization, or --folder")
+		}
+	} else {
+		if o.Scope.Filter != "" {
+			return fmt.Errorf("--filter requires --all-projects, --organization, or --folder")
+		}
+		if o.Yes {
+			return fmt.Errorf("--yes only applies when cleaning many projects")
+		}
+		if err := o.requireProject(); err != nil {
+			return err
+		}
+	}
 
-	if dryRun {
+	ctx := cmd.Context()
+
+	if o.DryRun {
 		logger.Info("Running in dry-run mode - no changes will be made")
 	}
 
-	p, err := provider.NewGCPProvider(ctx, dryRun)
+	if o.AllProjects {
+		return runCleanAll(ctx, o)
+	}
+
+	log := useSessionLogger("", o.Project)
+	p, err := newGCPProvider(ctx, o.DryRun, provider.WithLogger(log), provider.WithServiceAccountCaller(o.AllowServiceAccountCaller))
 	if err != nil {
 		return fmt.Errorf("failed to create GCP provider: %v", err)
 	}
 
+	if o.Compact {
+		return runCompact(p, o)
+	}
+
 	opts := &provider.GCPOptions{
-		Project: project,
-		User:    user,
+		Project:        o.Project,
+		Resource:       o.Resource,
+		User:           o.User,
+		Members:        o.Members,
+		Expired:        o.Expired,
+		IncludeDeleted: o.IncludeDeleted,
+		CreatedBy:      o.CreatedBy,
+		AnyPrefix:      o.AnyPrefix,
+		Profile:        profile,
+	}
+
+	_, err = p.CleanTemporaryBindings(opts)
+	flushNotifications()
+	if err != nil {
+		return fmt.Errorf("failed to clean temporary bindings: %w", err)
+	}
+
+	return cleanFuzzyMatches(p, &o, flagBool(cmd, "fuzzy-match"))
+}
+
+// checkCompact rejects the flags that select bindings to remove along with
+// --compact, which removes none
+func (o *cleanOptions) checkCompact(cmd *cobra.Command) error {
+	if !o.Compact {
+		if cmd.Flags().Changed("compact-window") {
+			return fmt.Errorf("--compact-window requires --compact")
+		}
+		return nil
+	}
+	if o.CompactWindow <= 0 {
+		return fmt.Errorf("--compact-window must be positive")
+	}
+	for _, name := range []string{"user", "member", "expired", "include-deleted", "created-by", "any-prefix", "fuzzy-match", "all-projects", "organization", "folder", "filter", "yes"} {
+		if _, ok := lookupOption(cmd, name); ok {
+			return fmt.Errorf("--%s cannot be combined with --compact", name)
+		}
+	}
+	return nil
+}
+
+// runCompact merges the temporary bindings of the project or resource of o.
+// The local state stays locked meanwhile: the bindings of the sessions a
+// process attends or has yet to revoke are left alone, and the sessions
+// whose bindings are merged move to the binding kept.
+func runCompact(p *provider.GCPProvider, o cleanOptions) error {
+	store, err := newStateStore()
+	if err != nil {
+		return fmt.Errorf("failed to open local state: %v", err)
+	}
+	var groups []provider.CompactGroup
+	var compactErr error
+	err = store.Update(func(f *state.File) error {
+		groups, compactErr = p.CompactTemporaryBindings(&provider.GCPOptions{
+			Project:        o.Project,
+			Resource:       o.Resource,
+			Profile:        profile,
+			PinnedBindings: pinnedBindings(f),
+		}, o.CompactWindow)
+		if o.DryRun {
+			return nil
+		}
+		// Groups written before a failure are recorded all the same
+		moved, unknown := moveCompactedSessions(f, groups)
+		if moved > 0 {
+			logger.Info("Moved %d binding(s) of the sessions of this machine to the bindings they were merged into", moved)
+		}
+		for _, b := range unknown {
+			logger.Info("Merged binding %s belongs to no session of this machine; its session no longer matches it", b.BindingID)
+		}
+		return nil
+	})
+	flushNotifications()
+	if compactErr != nil {
+		return fmt.Errorf("failed to compact temporary bindings: %w", compactErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update local state: %v", err)
+	}
+	return nil
+}
+
+// pinnedBindings returns the IDs of the bindings of the sessions of f that
+// compaction must leave alone: those a process attends, which revokes them
+// by the ID it holds, and those pending revocation
+func pinnedBindings(f *state.File) map[string]bool {
+	pinned := make(map[string]bool)
+	for _, s := range f.Sessions {
+		if s.Kept && s.PendingRevocation == nil {
+			continue
+		}
+		for _, b := range s.Bindings {
+			pinned[b.BindingID] = true
+		}
+	}
+	return pinned
+}
+
+// moveCompactedSessions points the bindings of the sessions of f that groups
+// merged at the binding kept and its expiry, so that revoking a session
+// still removes its members. It returns the number of bindings moved, and
+// the merged bindings of no session of f.
+func moveCompactedSessions(f *state.File, groups []provider.CompactGroup) (int, []provider.TemporaryBinding) {
+	moved := 0
+	var unknown []provider.TemporaryBinding
+	for _, g := range groups {
+		seen := make(map[string]bool)
+		for _, merged := range g.Merged {
+			// Merged lists a binding once per member
+			if seen[merged.BindingID] {
+				continue
+			}
+			seen[merged.BindingID] = true
+			found := false
+			for i := range f.Sessions {
+				s := &f.Sessions[i]
+				for j := range s.Bindings {
+					b := &s.Bindings[j]
+					target := b.Target
+					if target == "" {
+						target = s.Target()
+					}
+					if b.Role != g.Role || b.BindingID != merged.BindingID || provider.RoleResource(target, b.Role) != g.Resource {
+						continue
+					}
+					b.BindingID, b.Expiry = g.BindingID, g.Expiry
+					moved++
+					found = true
+				}
+			}
+			if !found {
+				unknown = append(unknown, merged)
+			}
+		}
+	}
+	return moved, unknown
+}
+
+// cleanCheckpointFile is the name of the checkpoint of clean --all-projects
+// in the data directory
+const cleanCheckpointFile = "clean-checkpoint.json"
+
+// cleanReport collects the outcome of a clean of many projects. Its methods
+// are safe for concurrent use by the workers.
+type cleanReport struct {
+	mu      sync.Mutex
+	scanned int
+	// found are the bindings to remove by project, as scanned
+	found map[string][]provider.TemporaryBinding
+	// removed are the bindings removed by project
+	removed map[string][]provider.TemporaryBinding
+	// skipped are the projects whose policy the caller may not read or update
+	skipped []string
+	failed  map[string]error
+}
+
+// newCleanReport creates an empty report
+func newCleanReport() *cleanReport {
+	return &cleanReport{
+		found:   make(map[string][]provider.TemporaryBinding),
+		removed: make(map[string][]provider.TemporaryBinding),
+		failed:  make(map[string]error),
+	}
+}
+
+// scan records the bindings found in project, or why it could not be scanned.
+// It reports whether the project is done, i.e. there is nothing to remove
+// or it was skipped.
+func (r *cleanReport) scan(project string, bindings []provider.TemporaryBinding, err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scanned++
+	if err != nil {
+		return r.fail(project, err)
+	}
+	if len(bindings) > 0 {
+		r.found[project] = bindings
+	}
+	return len(bindings) == 0
+}
+
+// remove records the bindings removed from project, or why they could not
+// be. It reports whether the project is done.
+func (r *cleanReport) remove(project string, removed []provider.TemporaryBinding, err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		return r.fail(project, err)
+	}
+	if len(removed) > 0 {
+		r.removed[project] = removed
+	}
+	return true
+}
+
+// fail records err for project, skipping it when it is a permission error
+func (r *cleanReport) fail(project string, err error) bool {
+	if provider.PermissionDenied(err) {
+		r.skipped = append(r.skipped, project)
+		return true
+	}
+	r.failed[project] = err
+	return false
+}
+
+// countBindings returns the number of bindings in a set of bindings by project
+func countBindings(bindings map[string][]provider.TemporaryBinding) int {
+	n := 0
+	for _, b := range bindings {
+		n += len(b)
+	}
+	return n
+}
+
+// sortedProjects returns the projects of a set of bindings by project, sorted
+func sortedProjects(bindings map[string][]provider.TemporaryBinding) []string {
+	projects := make([]string, 0, len(bindings))
+	for project := range bindings {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+// logFound logs the bindings found by the scan, grouped by project
+func (r *cleanReport) logFound() {
+	for _, project := range sortedProjects(r.found) {
+		logger.Info("projects/%s: %d binding(s) to remove", project, len(r.found[project]))
+		for _, b := range r.found[project] {
+			logger.Info("  Role=%s, Member=%s, Expires=%s, ID=%s", b.Role, b.Member, b.Expiry.Format(time.RFC3339), b.BindingID)
+		}
+	}
+	logger.Info("Found %d temporary binding(s) to remove in %d of %d project(s) scanned",
+		countBindings(r.found), len(r.found), r.scanned)
+}
+
+// log logs the final report, resumed being the number of projects completed
+// by an earlier run. In dry-run mode the bindings found are reported as the
+// ones that would be removed.
+func (r *cleanReport) log(dryRun bool, resumed int) {
+	if dryRun {
+		logger.Info("Scanned %d project(s): %d temporary binding(s) would be removed", r.scanned, countBindings(r.found))
+	} else {
+		for _, project := range sortedProjects(r.removed) {
+			for _, b := range r.removed[project] {
+				logger.Info("Removed projects/%s %s (Role=%s, Member=%s)", project, b.BindingID, b.Role, b.Member)
+			}
+		}
+		logger.Info("Scanned %d project(s): %d temporary binding(s) removed from %d project(s)",
+			r.scanned, countBindings(r.removed), len(r.removed))
+	}
+	if resumed > 0 {
+		logger.Info("%d project(s) were completed by an earlier run and not scanned again", resumed)
+	}
+	if len(r.skipped) > 0 {
+		sort.Strings(r.skipped)
+		logger.Warn("Skipped %d project(s) for missing permissions: %s", len(r.skipped), strings.Join(r.skipped, ", "))
+	}
+	failed := make([]string, 0, len(r.failed))
+	for project := range r.failed {
+		failed = append(failed, project)
+	}
+	sort.Strings(failed)
+	for _, project := range failed {
+		if logFormat == string(logger.FormatJSON) {
+			details := provider.Details(r.failed[project])
+			if details.Resource == "" {
+				details.Resource = "projects/" + project
+			}
+			logger.ErrorAttrs("Failed to clean project", slog.Any("error", details))
+			continue
+		}
+		logger.Error("Failed to clean projects/%s: %v", project, r.failed[project])
+	}
+}
+
+// runCleanAll cleans every project in scope with a pool of workers, each
+// with its own provider sharing the rate limiter of the process. The
+// projects are scanned first, and the bindings found are removed once
+// confirmed. Outside dry-run mode, projects are checkpointed as they
+// complete and the checkpoint is removed once no project failed.
+func runCleanAll(ctx context.Context, o cleanOptions) error {
+	log := logger.With(slog.String("provider", "gcp"))
+	providerOpts := []provider.GCPProviderOption{
+		provider.WithLogger(log),
+		provider.WithServiceAccountCaller(o.AllowServiceAccountCaller),
+	}
+	lister, err := newGCPProvider(ctx, o.DryRun, providerOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	// Refuse a service account caller once rather than for every project
+	if _, err := lister.CheckCaller(); err != nil {
+		return err
 	}
 
-	if err := p.CleanTemporaryBindings(opts); err != nil {
-		return fmt.Errorf("failed to clean temporary bindings: %v", err)
+	logger.Info("Listing projects in %s", o.Scope)
+	projects, err := lister.ListProjects(o.Scope)
+	if err != nil {
+		return fmt.Errorf("failed to list projects: %w", err)
+	}
+
+	var cp *checkpoint.Checkpoint
+	if !o.DryRun {
+		dir, err := config.DataDir()
+		if err != nil {
+			return err
+		}
+		// Flags changing which bindings are removed make a different run
+		scope := fmt.Sprintf("%s user=%s members=%s expired=%t created-by=%s any-prefix=%t", o.Scope, o.User, strings.Join(o.Members, ","), o.Expired, o.CreatedBy, o.AnyPrefix)
+		if cp, err = checkpoint.Load(filepath.Join(dir, cleanCheckpointFile), scope); err != nil {
+			return err
+		}
+	}
+	complete := func(project string) {
+		if cp == nil {
+			return
+		}
+		if err := cp.Complete(project); err != nil {
+			logger.Warn("Failed to checkpoint projects/%s: %v", project, err)
+		}
+	}
+
+	pending := make([]string, 0, len(projects))
+	for _, project := range projects {
+		if cp == nil || !cp.Done(project) {
+			pending = append(pending, project)
+		}
+	}
+	resumed := len(projects) - len(pending)
+	if resumed > 0 {
+		logger.Info("Resuming the clean started at %s: %d of %d project(s) already done",
+			cp.StartedAt().Local().Format(time.RFC3339), resumed, len(projects))
 	}
+	workers := fanOutWorkers(len(pending))
+	logger.Info("Scanning %d project(s) with %d worker(s)", len(pending), workers)
 
+	providers := []*provider.GCPProvider{lister}
+	for len(providers) < workers {
+		p, err := newGCPProvider(ctx, o.DryRun, providerOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		providers = append(providers, p)
+	}
+	cleanOpts := func(project string) *provider.GCPOptions {
+		return &provider.GCPOptions{Project: project, User: o.User, Members: o.Members, Expired: o.Expired, IncludeDeleted: o.IncludeDeleted, CreatedBy: o.CreatedBy, AnyPrefix: o.AnyPrefix, Profile: profile}
+	}
+
+	report := newCleanReport()
+	workerpool.Run(ctx, workers, pending, func(worker int, project string) {
+		bindings, err := providers[worker].CleanableBindings(cleanOpts(project))
+		if report.scan(project, bindings, err) {
+			complete(project)
+		}
+	})
+	report.logFound()
+
+	found := countBindings(report.found)
+	if found > 0 && !o.DryRun {
+		if !o.Yes {
+			confirmed, err := confirmCount(found)
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				return fmt.Errorf("clean aborted, no binding was removed")
+			}
+		}
+
+		// Remove exactly the confirmed bindings, even if others appeared since
+		projects := sortedProjects(report.found)
+		workerpool.Run(ctx, workers, projects, func(worker int, project string) {
+			opts := cleanOpts(project)
+			for _, b := range report.found[project] {
+				opts.BindingIDs = append(opts.BindingIDs, b.BindingID)
+			}
+			removed, err := providers[worker].CleanTemporaryBindings(opts)
+			if report.remove(project, removed, err) {
+				complete(project)
+			}
+		})
+		flushNotifications()
+	}
+
+	report.log(o.DryRun, resumed)
+	if err := ctx.Err(); err != nil {
+		hint := ""
+		if cp != nil {
+			hint = "; run the same command again to resume it"
+		}
+		return fmt.Errorf("clean interrupted%s: %w", hint, err)
+	}
+	if len(report.failed) > 0 {
+		hint := ""
+		if cp != nil {
+			hint = "; run the same command again to retry them"
+		}
+		return fmt.Errorf("failed to clean %d of %d project(s)%s", len(report.failed), len(pending), hint)
+	}
+	if cp != nil {
+		if err := cp.Remove(); err != nil {
+			logger.Warn("%v", err)
+		}
+	}
 	return nil
 }
+
+// confirmCount asks for the number of bindings about to be removed to be
+// typed back, so that a large removal is not confirmed by habit
+func confirmCount(count int) (bool, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("removing %d binding(s) needs confirmation in a terminal, or --yes", count)
+	}
+	fmt.Fprintf(os.Stderr, "Type %d to remove the %d binding(s) above: ", count, count)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	return strings.TrimSpace(answer) == strconv.Itoa(count), nil
+}
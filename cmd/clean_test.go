@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// compactState holds a session attended by its process, one pending
+// revocation, and two left to expire by their condition, one of them on an
+// instance
+func compactState(expiry time.Time) *state.File {
+	return &state.File{Sessions: []state.Session{
+		{ID: "attended", Project: "p", PID: 42, Bindings: []state.Binding{{Role: "roles/viewer", BindingID: "gta_1", Expiry: expiry}}},
+		{ID: "pending", Project: "p", Kept: true, PendingRevocation: &state.Revocation{Attempts: 1}, Bindings: []state.Binding{{Role: "roles/viewer", BindingID: "gta_2", Expiry: expiry}}},
+		{ID: "kept", Project: "p", Kept: true, Bindings: []state.Binding{
+			{Role: "roles/viewer", BindingID: "gta_3", Expiry: expiry},
+			{Role: "roles/browser", BindingID: "gta_3", Expiry: expiry},
+		}},
+		{ID: "instance", Project: "p", Resource: "projects/p/zones/z/instances/vm", Kept: true, Bindings: []state.Binding{
+			{Role: "roles/iap.tunnelResourceAccessor", BindingID: "gta_4", Expiry: expiry, Target: "projects/p/iap_tunnel/zones/z/instances/vm"},
+		}},
+	}}
+}
+
+func TestPinnedBindings(t *testing.T) {
+	pinned := pinnedBindings(compactState(time.Now().Add(time.Hour)))
+	if len(pinned) != 2 || !pinned["gta_1"] || !pinned["gta_2"] {
+		t.Errorf("pinned %v, want the attended and pending bindings", pinned)
+	}
+}
+
+func TestMoveCompactedSessions(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	later := expiry.Add(10 * time.Minute)
+	f := compactState(expiry)
+	moved, unknown := moveCompactedSessions(f, []provider.CompactGroup{
+		{
+			Resource:  "projects/p",
+			Role:      "roles/viewer",
+			BindingID: "gta_9",
+			Expiry:    later,
+			// A binding of two members, and one of another machine
+			Merged: []provider.TemporaryBinding{
+				{BindingID: "gta_3", Member: "user:alice@example.com"},
+				{BindingID: "gta_3", Member: "user:bob@example.com"},
+				{BindingID: "gta_8", Member: "user:carol@example.com"},
+			},
+		},
+		{
+			Resource:  "projects/p/iap_tunnel/zones/z/instances/vm",
+			Role:      "roles/iap.tunnelResourceAccessor",
+			BindingID: "gta_7",
+			Expiry:    later,
+			Merged:    []provider.TemporaryBinding{{BindingID: "gta_4", Member: "user:alice@example.com"}},
+		},
+	})
+	if moved != 2 {
+		t.Errorf("moved %d bindings, want 2", moved)
+	}
+	if len(unknown) != 1 || unknown[0].BindingID != "gta_8" {
+		t.Errorf("unknown %v, want gta_8", unknown)
+	}
+
+	var got []string
+	for _, s := range f.Sessions {
+		for _, b := range s.Bindings {
+			at := "expiry"
+			if b.Expiry.Equal(later) {
+				at = "later"
+			}
+			got = append(got, s.ID+":"+b.Role+":"+b.BindingID+":"+at)
+		}
+	}
+	want := []string{
+		"attended:roles/viewer:gta_1:expiry",
+		"pending:roles/viewer:gta_2:expiry",
+		"kept:roles/viewer:gta_9:later",
+		// Another role of the same binding ID was not merged
+		"kept:roles/browser:gta_3:expiry",
+		"instance:roles/iap.tunnelResourceAccessor:gta_7:later",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("sessions\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
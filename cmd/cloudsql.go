@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// SQL scopes of roles/cloudsql.instanceUser given with --sql-scope
+const (
+	sqlScopeInstance = "instance"
+	sqlScopeProject  = "project"
+)
+
+// applySQLInstance sets the project from the Cloud SQL instance given with
+// --sql-instance and checks the grant can create its database user
+func (o *grantOptions) applySQLInstance(cmd *cobra.Command) error {
+	instance, err := provider.ParseSQLInstance(o.SQLInstance)
+	if err != nil {
+		return err
+	}
+	if _, ok := lookupOption(cmd, "project"); ok && o.Project != instance.Project {
+		return fmt.Errorf("Cloud SQL instance %s is in project %s, not %s", instance, instance.Project, o.Project)
+	}
+	o.Project = instance.Project
+	if o.Resource != "" {
+		return fmt.Errorf("--sql-instance grants in the project of the instance and cannot be combined with %s", o.Resource)
+	}
+	if len(o.Members) > 1 {
+		return fmt.Errorf("--sql-instance creates the database user of a single member, got %d", len(o.Members))
+	}
+	switch o.SQLScope {
+	case sqlScopeInstance, sqlScopeProject:
+	default:
+		return fmt.Errorf("invalid --sql-scope %q (expected %s or %s)", o.SQLScope, sqlScopeInstance, sqlScopeProject)
+	}
+	return nil
+}
+
+// sqlRoles returns roles with roles/cloudsql.instanceUser added unless it
+// is there already
+func sqlRoles(roles []string) []string {
+	for _, role := range roles {
+		if provider.FormatRole(role) == provider.CloudSQLInstanceUserRole {
+			return roles
+		}
+	}
+	return append([]string{provider.CloudSQLInstanceUserRole}, roles...)
+}
+
+// applySQLOptions sets the instance of opts, limiting the bindings to it
+// with --sql-scope=instance
+func (o *grantOptions) applySQLOptions(opts *provider.GCPOptions) error {
+	if o.SQLInstance == "" {
+		return nil
+	}
+	opts.SQLInstance = o.SQLInstance
+	if o.SQLScope == sqlScopeInstance {
+		instance, err := provider.ParseSQLInstance(o.SQLInstance)
+		if err != nil {
+			return err
+		}
+		if len(opts.Roles) > 1 {
+			return fmt.Errorf("--sql-scope=instance limits every role to the instance, grant the other roles separately or use --sql-scope=project")
+		}
+		opts.Condition = instance.Condition()
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/suggest"
+)
+
+// directoryCompletionLimit is the number of directory users offered at most
+const directoryCompletionLimit = 50
+
+// registerMemberCompletion completes the --user and --member flags of cmd
+// with the identities granted before and, when enabled, the directory
+func registerMemberCompletion(cmd *cobra.Command) {
+	for name, typed := range map[string]bool{"user": false, "member": true} {
+		if cmd.Flags().Lookup(name) == nil {
+			continue
+		}
+		_ = cmd.RegisterFlagCompletionFunc(name, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeMembers(toComplete, typed), cobra.ShellCompDirectiveNoFileComp
+		})
+	}
+}
+
+// registerProjectCompletion completes the --project flag of cmd and its
+// subcommands with the aliases of projects in config and the projects cached
+// by gta projects. Completion never lists the projects itself, as the search
+// may take longer than a prompt can wait.
+func registerProjectCompletion(cmd *cobra.Command) {
+	if cmd.Flags().Lookup("project") != nil {
+		_ = cmd.RegisterFlagCompletionFunc("project", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			loadCompletionConfig()
+			var completions []string
+			for _, name := range cfg.ProjectAliasNames() {
+				if strings.HasPrefix(name, toComplete) {
+					completions = append(completions, name)
+				}
+			}
+			return append(completions, cachedProjectIDs(toComplete)...), cobra.ShellCompDirectiveNoFileComp
+		})
+	}
+	for _, sub := range cmd.Commands() {
+		registerProjectCompletion(sub)
+	}
+}
+
+// loadCompletionConfig loads the config and resolves the profile for
+// completion, which runs without setup, keeping the defaults on errors
+func loadCompletionConfig() {
+	// Anything written to the terminal would garble the prompt
+	logger.SetLevel(logger.LevelError)
+	if loaded, err := config.Load(cfgFile); err == nil {
+		cfg = loaded
+		if resolved, err := cfg.ResolveProfile(profile); err == nil {
+			profile = resolved
+		}
+	}
+}
+
+// completeRoles returns the cached predefined roles at one of stages, all of
+// them when stages is nil, starting with toComplete, given with or without
+// roles/. Roles are never fetched for completion, which offers none until a
+// command such as gta roles has cached them.
+func completeRoles(toComplete string, stages []string) []string {
+	path, err := roleCatalogFile()
+	if err != nil {
+		return nil
+	}
+	short := !strings.HasPrefix(toComplete, "roles/") && !strings.HasPrefix("roles/", toComplete)
+	var completions []string
+	for _, role := range filterStages(suggest.CachedRoles(path), stages) {
+		name := role.Name
+		if short {
+			name = strings.TrimPrefix(name, "roles/")
+		}
+		if strings.HasPrefix(name, toComplete) {
+			completions = append(completions, name+"\t"+role.Title)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
+
+// completeMembers returns the deduplicated, sorted identities starting with
+// toComplete, as user: principals when typed is set and as emails otherwise.
+// Completion runs without setup and must neither fail nor hang, so any error
+// leaves out the source it came from.
+func completeMembers(toComplete string, typed bool) []string {
+	loadCompletionConfig()
+
+	seen := make(map[string]bool)
+	var completions []string
+	add := func(member string) {
+		if !typed {
+			email, ok := strings.CutPrefix(member, "user:")
+			if !ok && strings.Contains(member, ":") {
+				return
+			}
+			member = email
+		}
+		if member != "" && !seen[member] && strings.HasPrefix(member, toComplete) {
+			seen[member] = true
+			completions = append(completions, member)
+		}
+	}
+
+	if !typed {
+		add(selfIdentity)
+	}
+	for _, name := range cfg.IdentityNames() {
+		add(identityPrefix + name)
+	}
+	for _, member := range historyMembers() {
+		add(member)
+	}
+	if cfg.Completion.Directory {
+		prefix, ok := strings.CutPrefix(toComplete, "user:")
+		if ok || !typed {
+			for _, email := range directoryUsers(prefix) {
+				add("user:" + email)
+			}
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
+
+// historyMembers returns the principals of the sessions in the local state
+// and of the grants in the local audit log
+func historyMembers() []string {
+	// Completion cannot ask for a passphrase
+	if passphraseNeeded() {
+		return nil
+	}
+
+	var members []string
+	if store, err := newStateStore(); err == nil {
+		if f, err := store.Load(); err == nil {
+			for _, s := range f.Sessions {
+				members = append(members, sessionMembers(s.Member)...)
+			}
+		}
+	}
+	if cfg.Audit.Disabled {
+		return members
+	}
+	path, err := cfg.AuditPath()
+	if err != nil {
+		return members
+	}
+	cipher, err := fileCipher()
+	if err != nil {
+		return members
+	}
+	events, err := audit.ReadLog(path, cipher)
+	if err != nil {
+		return members
+	}
+	// Most recent first, so that the newest identities survive deduplication
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Action == audit.ActionGrant && events[i].Error == "" {
+			members = append(members, events[i].Member)
+		}
+	}
+	return members
+}
+
+// sessionMembers splits the member of a session, either an email or a comma
+// separated list of principals, into principals
+func sessionMembers(member string) []string {
+	if member == "" {
+		return nil
+	}
+	if !strings.Contains(member, ":") {
+		return []string{"user:" + member}
+	}
+	return strings.Split(member, ",")
+}
+
+// directoryUsers returns the emails in the directory starting with prefix,
+// or none when the directory cannot be searched within the completion timeout
+func directoryUsers(prefix string) []string {
+	// Listing the whole directory is neither useful nor quick
+	if prefix == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.CompletionTimeout())
+	defer cancel()
+
+	network, err := networkTransport()
+	if err != nil {
+		return nil
+	}
+	p, err := provider.NewGCPProvider(ctx, true, provider.WithTransport(network))
+	if err != nil {
+		return nil
+	}
+	emails, err := p.SearchDirectory(prefix, directoryCompletionLimit)
+	if err != nil {
+		return nil
+	}
+	return emails
+}
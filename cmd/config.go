@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the gta configuration file",
+	// Skip the root config loading so that invalid files can be reported in full
+	PersistentPreRunE: setupLogging,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration file",
+	Long: `Validate the configuration file, reporting every unknown field and invalid
+value with its line number. Exits non-zero if any problem is found.
+
+Example:
+  gta config validate
+  gta config validate --config ./team.gta.yaml`,
+	Args: cobra.NoArgs,
+	RunE: runConfigValidate,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	loaded, err := config.Load(cfgFile)
+	if err != nil {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, problem := range validationErr.Problems {
+				logger.Error("%s: %s", validationErr.Path, problem)
+			}
+			return fmt.Errorf("config file %s has %d problem(s)", validationErr.Path, len(validationErr.Problems))
+		}
+		return err
+	}
+
+	if loaded.Path() == "" {
+		logger.Info("No config file found, using defaults")
+		return nil
+	}
+	logger.Info("Config file %s is valid", loaded.Path())
+	return nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// reauthCommand logs in again with the Application Default Credentials gta uses
+const reauthCommand = "gcloud auth application-default login"
+
+// revokeWithReauth revokes the bindings of session id with revoke. When the
+// credentials of the caller no longer refresh, retry revokes them with
+// credentials read again, at once in case the user logged in again meanwhile,
+// then each time the user confirms on a terminal having logged in again. A nil
+// retry is revoke itself. Sessions left unrevoked are marked pending
+// revocation in the state, for later commands to retry.
+func revokeWithReauth(id string, revoke, retry func() error) error {
+	err := revoke()
+	if retry == nil {
+		retry = revoke
+	} else if provider.CredentialsExpired(err) {
+		logger.Debug("Credentials refused, reading them again: %v", err)
+		err = retry()
+	}
+	for provider.CredentialsExpired(err) {
+		reportExpiredCredentials(id, err)
+		if !stdinIsTerminal() {
+			break
+		}
+		ok, confirmErr := confirmStdin("Retry the revocation once logged in again in another terminal?")
+		if confirmErr != nil || !ok {
+			break
+		}
+		err = retry()
+	}
+	if err != nil {
+		markRevokePending(id, err)
+		return withReauthHint(err)
+	}
+	return nil
+}
+
+// freshRevoke returns a revocation of granted by a provider created anew, so
+// that the credentials are read again
+func freshRevoke(ctx context.Context, opts *provider.GCPOptions, granted []provider.GrantedRole, providerOpts ...provider.GCPProviderOption) func() error {
+	return func() error {
+		p, err := newGCPProvider(ctx, false, providerOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		p.AdoptGrantedRoles(granted)
+		err = p.Revoke(opts)
+		flushNotifications()
+		return err
+	}
+}
+
+// reportExpiredCredentials tells prominently that the roles of session id
+// are still bound as the credentials expired, and how to revoke them
+func reportExpiredCredentials(id string, err error) {
+	logger.Error("Your Google credentials are no longer valid, the roles of session %s were NOT revoked: %v", id, err)
+	logger.Error("Log in again with: %s", reauthCommand)
+	logger.Error("Then revoke them with: gta revoke --from-state --session=%s", id)
+}
+
+// withReauthHint adds how to log in again to an error of expired credentials
+func withReauthHint(err error) error {
+	if !provider.CredentialsExpired(err) {
+		return err
+	}
+	return fmt.Errorf("%w; the credentials are no longer valid, log in again with %s", err, reauthCommand)
+}
+
+// stdinIsTerminal reports whether stdin is a terminal to ask questions on
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
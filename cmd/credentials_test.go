@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+	"golang.org/x/oauth2"
+)
+
+// useState points the state at a file in a temporary home holding session
+// id, with stdin not a terminal, and returns the store
+func useState(t *testing.T, id string) *state.Store {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	useConfig(t, "")
+	stdin, err := os.CreateTemp(home, "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = stdin
+	t.Cleanup(func() {
+		os.Stdin = saved
+		stdin.Close()
+	})
+
+	store, err := newStateStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".gta"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	err = store.Update(func(f *state.File) error {
+		f.Put(state.Session{ID: id, Provider: "gcp", Project: "p", Member: "alice@example.com", StartedAt: time.Now(), Bindings: []state.Binding{{Role: "roles/viewer", BindingID: "gta_temporary_access_1", Expiry: time.Now().Add(time.Hour)}}})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRevokeWithReauth(t *testing.T) {
+	expired := &oauth2.RetrieveError{ErrorCode: "invalid_grant", ErrorDescription: "Token has been expired or revoked."}
+	unavailable := errors.New("connection reset")
+	for _, tc := range []struct {
+		name string
+		// revoke and retry are the results of the successive calls
+		revoke      []error
+		retry       []error
+		noRetry     bool
+		wantRevokes int
+		wantRetries int
+		wantErr     string
+		wantPending bool
+	}{
+		{name: "revoked", revoke: []error{nil}, wantRevokes: 1},
+		{name: "logged in again meanwhile", revoke: []error{expired}, retry: []error{nil}, wantRevokes: 1, wantRetries: 1},
+		{
+			name:        "still expired",
+			revoke:      []error{expired},
+			retry:       []error{expired},
+			wantRevokes: 1,
+			wantRetries: 1,
+			wantErr:     "log in again with " + reauthCommand,
+			wantPending: true,
+		},
+		{
+			name:        "another failure",
+			revoke:      []error{unavailable},
+			wantRevokes: 1,
+			wantErr:     "connection reset",
+			wantPending: true,
+		},
+		{
+			name:        "without a retry",
+			revoke:      []error{expired},
+			noRetry:     true,
+			wantRevokes: 1,
+			wantErr:     "log in again with " + reauthCommand,
+			wantPending: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := useState(t, "s1")
+			revokes, retries := 0, 0
+			revoke := func() error {
+				revokes++
+				return tc.revoke[revokes-1]
+			}
+			var retry func() error
+			if !tc.noRetry {
+				retry = func() error {
+					retries++
+					return tc.retry[retries-1]
+				}
+			}
+
+			err := revokeWithReauth("s1", revoke, retry)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("revokeWithReauth = %v, want %q", err, tc.wantErr)
+				}
+				if tc.wantErr == "connection reset" && strings.Contains(err.Error(), reauthCommand) {
+					t.Errorf("hint to log in again for %v", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if revokes != tc.wantRevokes || retries != tc.wantRetries {
+				t.Errorf("%d revocations and %d retries, want %d and %d", revokes, retries, tc.wantRevokes, tc.wantRetries)
+			}
+
+			f, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, ok := f.Session("s1")
+			if !ok {
+				t.Fatal("session removed")
+			}
+			if pending := s.PendingRevocation != nil; pending != tc.wantPending {
+				t.Fatalf("pending revocation %+v, want %v", s.PendingRevocation, tc.wantPending)
+			}
+			if tc.wantPending && (s.PendingRevocation.Attempts != 1 || s.PendingRevocation.LastError == "") {
+				t.Errorf("pending revocation %+v", s.PendingRevocation)
+			}
+		})
+	}
+}
+
+func TestRevokePartlyFailed(t *testing.T) {
+	unavailable := errors.New("connection reset")
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "one of two roles",
+			err:  fmt.Errorf("failed to revoke some roles: %w", provider.RoleErrors{{Action: "revoke", Role: "roles/browser", Project: "p", BindingID: "gta_temporary_access_1", Err: unavailable}}),
+			want: "roles/browser",
+		},
+		{name: "no role known", err: unavailable, want: "roles/viewer,roles/browser"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := useState(t, "s1")
+			err := store.Update(func(f *state.File) error {
+				s, _ := f.Session("s1")
+				s.Bindings = append(s.Bindings, state.Binding{Role: "roles/browser", BindingID: "gta_temporary_access_1", Expiry: time.Now().Add(time.Hour)})
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := revokeWithReauth("s1", func() error { return tc.err }, nil); err == nil {
+				t.Fatal("revokeWithReauth succeeded")
+			}
+			f, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, ok := f.Session("s1")
+			if !ok || s.PendingRevocation == nil {
+				t.Fatalf("session %+v, want it pending revocation", s)
+			}
+			var roles []string
+			for _, b := range s.Bindings {
+				roles = append(roles, b.Role)
+			}
+			if strings.Join(roles, ",") != tc.want {
+				t.Errorf("bindings of %v kept, want %s", roles, tc.want)
+			}
+		})
+	}
+}
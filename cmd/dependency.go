@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// Values of --dependencies
+const (
+	dependenciesPrompt = "prompt"
+	dependenciesAdd    = "add"
+	dependenciesSkip   = "skip"
+)
+
+// expandDependencies adds the companion roles of the role_dependencies rules
+// matching the requested roles to the targets of o. With prompt, each set
+// of companions is offered in a terminal and only suggested otherwise.
+func expandDependencies(o *grantOptions, roles []string) error {
+	switch o.Dependencies {
+	case dependenciesPrompt, dependenciesAdd:
+	case dependenciesSkip:
+		return nil
+	default:
+		return fmt.Errorf("invalid --dependencies %q (expected prompt, add, or skip)", o.Dependencies)
+	}
+	if len(cfg.RoleDependencies) == 0 {
+		return nil
+	}
+
+	main := o.Project
+	if o.Resource != "" {
+		main = o.Resource
+	}
+	requested := make([]provider.GrantTarget, 0, len(roles)+len(o.Targets))
+	for _, role := range roles {
+		requested = append(requested, provider.GrantTarget{Resource: main, Roles: []string{role}})
+	}
+	requested = append(requested, o.Targets...)
+	// held are the roles requested or offered so far, by role and target
+	held := make(map[string]bool)
+	for _, target := range requested {
+		for _, role := range target.Roles {
+			held[provider.FormatRole(role)+"|"+target.Resource] = true
+		}
+	}
+
+	var added []provider.GrantTarget
+	for _, target := range requested {
+		for _, role := range target.Roles {
+			role = provider.FormatRole(role)
+			for _, dep := range cfg.RoleDependencies {
+				if provider.FormatRole(dep.Role) != role || !dependencyApplies(dep.On, target.Resource) {
+					continue
+				}
+				companion := provider.GrantTarget{Resource: target.Resource, AddedFor: role}
+				if dep.AddOn == config.DependencyOnProject {
+					companion.Resource = targetProject(target.Resource, o.Project)
+				}
+				for _, r := range dep.Add {
+					r = provider.FormatRole(r)
+					if !held[r+"|"+companion.Resource] {
+						held[r+"|"+companion.Resource] = true
+						companion.Roles = append(companion.Roles, r)
+					}
+				}
+				if len(companion.Roles) == 0 {
+					continue
+				}
+
+				label := describeCompanions(companion, o.Project)
+				switch {
+				case o.Dependencies == dependenciesAdd:
+				case len(confirmOptions()) > 0:
+					confirmed, err := confirmStdin(fmt.Sprintf("%s is rarely useful without %s. Grant it too?", role, label))
+					if err != nil {
+						return err
+					}
+					if !confirmed {
+						continue
+					}
+				default:
+					logger.Info("%s is rarely useful without %s; grant it too with --dependencies=add", role, label)
+					continue
+				}
+				logger.Info("Adding %s for %s", label, role)
+				added = append(added, companion)
+			}
+		}
+	}
+	o.Targets = append(o.Targets, added...)
+	return nil
+}
+
+// dependencyApplies reports whether a rule for the kind on matches a role
+// granted on target, a project ID or resource name
+func dependencyApplies(on, target string) bool {
+	switch on {
+	case "":
+		return true
+	case config.DependencyOnProject:
+		return !strings.Contains(target, "/")
+	}
+	kind, err := provider.ParseResource(target)
+	return err == nil && kind == on
+}
+
+// targetProject returns the project of target, or fallback for resources
+// outside of projects such as buckets
+func targetProject(target, fallback string) string {
+	if !strings.Contains(target, "/") {
+		return target
+	}
+	if project := provider.ResourceProject(target); project != "" {
+		return project
+	}
+	return fallback
+}
+
+// describeCompanions names the roles of a target added by a dependency rule
+// for messages, e.g. "roles/run.developer in projects/p"
+func describeCompanions(target provider.GrantTarget, project string) string {
+	granted := make([]provider.GrantedRole, 0, len(target.Roles))
+	for _, role := range target.Roles {
+		granted = append(granted, provider.GrantedRole{Role: role, Target: target.Resource})
+	}
+	return describeGranted(granted, project)
+}
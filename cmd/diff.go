@@ -0,0 +1,400 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the sessions recorded on this machine with the remote policies",
+	Long: `Compare the bindings of the sessions recorded on this machine in a project
+with the temporary bindings found in its policy, and in the policies of the
+resources the sessions granted on, and report how they drifted apart:
+
+  missing   recorded bindings gone from the policy, e.g. revoked by gta clean
+            or in the console
+  orphan    unexpired gta bindings of yours, granted to you or by you, that no
+            recorded session holds, e.g. left by a crashed gta
+  changed   recorded bindings whose expiry differs from the policy, e.g.
+            extended by another tool
+
+With --fix, missing bindings are removed from the recorded sessions, changed
+expiries are recorded, and orphans are adopted as unattended sessions that gta
+revoke --from-state can revoke.
+
+Example:
+  gta diff --project=my-project
+  gta diff --session=20240101T000000-ab12cd
+  gta diff --project=my-project --fix
+  gta diff --project=my-project --output=json`,
+	Args: cobra.NoArgs,
+	RunE: runDiff,
+}
+
+// Kinds of drift reported by gta diff
+const (
+	driftMissing = "missing"
+	driftOrphan  = "orphan"
+	driftChanged = "changed"
+)
+
+// drift is a binding that differs between the local state and a policy
+type drift struct {
+	Kind      string `json:"-"`
+	SessionID string `json:"session_id,omitempty"`
+	// Target is the project ID or resource name whose policy holds the
+	// binding
+	Target         string     `json:"target"`
+	Role           string     `json:"role"`
+	Member         string     `json:"member"`
+	BindingID      string     `json:"binding_id"`
+	RecordedExpiry *time.Time `json:"recorded_expiry,omitempty"`
+	RemoteExpiry   *time.Time `json:"remote_expiry,omitempty"`
+	// Reason is the reason recorded in the description of an orphan
+	Reason string `json:"reason,omitempty"`
+}
+
+// diffReport holds the drifts of gta diff by kind
+type diffReport struct {
+	Missing []drift `json:"missing"`
+	Orphans []drift `json:"orphans"`
+	Changed []drift `json:"changed"`
+}
+
+// empty reports whether the state and the policies agree
+func (r *diffReport) empty() bool {
+	return len(r.Missing) == 0 && len(r.Orphans) == 0 && len(r.Changed) == 0
+}
+
+func init() {
+	flags := diffCmd.Flags()
+	flags.StringP("project", "p", "", "Project ID (default: the project of --session)")
+	flags.String("session", "", "Only compare this session, and the orphans granted in it")
+	flags.Bool("fix", false, "Update the local state: forget missing bindings, record changed expiries, and adopt orphans")
+	flags.StringP("output", "o", outputTable, "Print the differences as a table or json")
+	rootCmd.AddCommand(diffCmd)
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	project := stringOption(cmd, "project", cfg.ProjectFor(profile))
+	sessionID := flagString(cmd, "session")
+	fix := flagBool(cmd, "fix")
+	output := flagString(cmd, "output")
+	switch output {
+	case outputTable, outputJSON:
+	default:
+		return fmt.Errorf("invalid output format %q (expected table or json)", output)
+	}
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if sessionID != "" {
+		s, ok := f.Session(sessionID)
+		if !ok {
+			return fmt.Errorf("no session %s recorded on this machine", sessionID)
+		}
+		if _, set := lookupOption(cmd, "project"); !set {
+			project = s.Project
+		}
+	}
+	if project == "" {
+		return fmt.Errorf("project is required: use --project, --session, or set project in config")
+	}
+	var sessions []state.Session
+	for _, s := range f.Sessions {
+		if s.Project == project && (s.Provider == "" || s.Provider == provider.NameGCP) && (sessionID == "" || s.ID == sessionID) {
+			sessions = append(sessions, s)
+		}
+	}
+
+	p, err := newGCPProvider(cmd.Context(), true)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	caller, err := p.Caller()
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+	targets := []string{project}
+	for _, s := range sessions {
+		for _, target := range s.Targets() {
+			if !containsString(targets, target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	remote := make(map[string][]provider.TemporaryBinding)
+	for _, target := range targets {
+		bindings, err := p.PolicyBindings(target)
+		if err != nil {
+			return fmt.Errorf("failed to read the policy: %w", err)
+		}
+		remote[target] = bindings
+	}
+
+	report := diffState(f.Sessions, sessions, remote, caller, time.Now())
+	if sessionID != "" {
+		var orphans []drift
+		for _, d := range report.Orphans {
+			if d.SessionID == sessionID {
+				orphans = append(orphans, d)
+			}
+		}
+		report.Orphans = orphans
+	}
+	if err := writeDiff(os.Stdout, output, report); err != nil {
+		return err
+	}
+	if report.empty() {
+		logger.Info("The recorded sessions match the policies of project %s", project)
+		return nil
+	}
+	if !fix {
+		logger.Info("Run gta diff again with --fix to update the local state")
+		return nil
+	}
+	return fixState(store, project, report)
+}
+
+// diffState compares the bindings of sessions with the remote temporary
+// bindings by target. Orphans are the unexpired bindings granted to or by
+// caller that no session of all holds.
+func diffState(all, sessions []state.Session, remote map[string][]provider.TemporaryBinding, caller string, now time.Time) *diffReport {
+	report := &diffReport{}
+	recorded := make(map[string]bool)
+	for _, s := range all {
+		for _, b := range s.Bindings {
+			recorded[b.BindingID] = true
+		}
+	}
+
+	for _, s := range sessions {
+		for _, b := range s.Bindings {
+			target := b.Target
+			if target == "" {
+				target = s.Target()
+			}
+			expiry := b.Expiry
+			d := drift{SessionID: s.ID, Target: target, Role: b.Role, Member: strings.Join(sessionMembers(s.Member), ","), BindingID: b.BindingID, RecordedExpiry: &expiry}
+			found, ok := findBinding(remote[target], b.BindingID)
+			switch {
+			case !ok:
+				d.Kind = driftMissing
+				report.Missing = append(report.Missing, d)
+			case found.Expiry.Sub(b.Expiry).Abs() >= time.Second:
+				d.Kind = driftChanged
+				d.Member = found.Member
+				d.RemoteExpiry = &found.Expiry
+				report.Changed = append(report.Changed, d)
+			}
+		}
+	}
+
+	targets := make([]string, 0, len(remote))
+	for target := range remote {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		for _, b := range remote[target] {
+			if recorded[b.BindingID] {
+				continue
+			}
+			// A binding may be listed under several targets, such as the
+			// IAP policies related to a project
+			recorded[b.BindingID] = true
+			if !b.Expiry.After(now) || !grantedToOrBy(b, caller) {
+				continue
+			}
+			expiry := b.Expiry
+			orphanTarget := target
+			if b.Resource != "" && b.Resource != "projects/"+target {
+				orphanTarget = b.Resource
+			}
+			report.Orphans = append(report.Orphans, drift{
+				Kind:         driftOrphan,
+				SessionID:    b.SessionID,
+				Target:       orphanTarget,
+				Role:         b.Role,
+				Member:       b.Member,
+				BindingID:    b.BindingID,
+				RemoteExpiry: &expiry,
+				Reason:       b.Reason,
+			})
+		}
+	}
+	return report
+}
+
+// findBinding returns the binding of bindings with the given ID
+func findBinding(bindings []provider.TemporaryBinding, id string) (provider.TemporaryBinding, bool) {
+	for _, b := range bindings {
+		if b.BindingID == id {
+			return b, true
+		}
+	}
+	return provider.TemporaryBinding{}, false
+}
+
+// grantedToOrBy reports whether b grants to caller, or was created by caller
+func grantedToOrBy(b provider.TemporaryBinding, caller string) bool {
+	if strings.EqualFold(b.CreatedBy, caller) {
+		return true
+	}
+	_, email, _ := strings.Cut(b.Member, ":")
+	return strings.EqualFold(email, caller)
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDiff prints the drifts of report as a table or JSON
+func writeDiff(w io.Writer, output string, report *diffReport) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	if report.empty() {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tSESSION\tRESOURCE\tROLE\tMEMBER\tRECORDED EXPIRY\tREMOTE EXPIRY")
+	for _, drifts := range [][]drift{report.Missing, report.Orphans, report.Changed} {
+		for _, d := range drifts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.Kind, orDash(d.SessionID), d.Target, d.Role, d.Member, driftExpiry(d.RecordedExpiry), driftExpiry(d.RemoteExpiry))
+		}
+	}
+	return tw.Flush()
+}
+
+// orDash returns s, or a dash when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// driftExpiry formats an expiry of a drift, a dash when there is none
+func driftExpiry(expiry *time.Time) string {
+	if expiry == nil {
+		return "-"
+	}
+	return orDash(formatExpiry(*expiry))
+}
+
+// fixState updates the local state with the drifts of report: it forgets
+// the missing bindings, and the sessions left without bindings, records the
+// changed expiries, and adopts the orphans as unattended sessions of project,
+// one per session ID found in their description
+func fixState(store *state.Store, project string, report *diffReport) error {
+	forgotten := 0
+	var adopted []string
+	err := store.Update(func(f *state.File) error {
+		for _, d := range report.Missing {
+			s, ok := f.Session(d.SessionID)
+			if !ok {
+				continue
+			}
+			s.Bindings = removeBinding(s.Bindings, d.BindingID)
+			if len(s.Bindings) == 0 {
+				f.Remove(s.ID)
+				forgotten++
+			}
+		}
+		for _, d := range report.Changed {
+			if s, ok := f.Session(d.SessionID); ok {
+				for i := range s.Bindings {
+					if s.Bindings[i].BindingID == d.BindingID {
+						s.Bindings[i].Expiry = *d.RemoteExpiry
+					}
+				}
+			}
+		}
+		for _, d := range report.Orphans {
+			id := d.SessionID
+			if id == "" {
+				id = audit.NewID()
+			}
+			s, ok := f.Session(id)
+			if !ok {
+				f.Put(adoptedSession(id, project, d))
+				s, _ = f.Session(id)
+				adopted = append(adopted, id)
+			}
+			target := d.Target
+			if target == s.Target() {
+				target = ""
+			}
+			s.Bindings = append(s.Bindings, state.Binding{Role: d.Role, BindingID: d.BindingID, Expiry: *d.RemoteExpiry, Target: target})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Updated the local state: forgot %d missing binding(s) and %d session(s) left without bindings, recorded %d changed expiry(ies), adopted %d orphan(s) in %d new session(s)",
+		len(report.Missing), forgotten, len(report.Changed), len(report.Orphans), len(adopted))
+	if len(adopted) > 0 {
+		logger.Info("Revoke the adopted sessions with: gta revoke --from-state --session=%s", strings.Join(adopted, ","))
+	}
+	return nil
+}
+
+// removeBinding returns bindings without the binding with the given ID
+func removeBinding(bindings []state.Binding, id string) []state.Binding {
+	kept := bindings[:0]
+	for _, b := range bindings {
+		if b.BindingID != id {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// adoptedSession returns an unattended session of project holding no
+// bindings yet, for the orphan d
+func adoptedSession(id, project string, d drift) state.Session {
+	member := d.Member
+	if email, ok := strings.CutPrefix(member, "user:"); ok {
+		member = email
+	}
+	return state.Session{
+		ID:        id,
+		Provider:  provider.NameGCP,
+		Project:   project,
+		Member:    member,
+		Reason:    d.Reason,
+		Profile:   profile,
+		StartedAt: time.Now().UTC(),
+		Kept:      true,
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/ratelimit"
+	"github.com/yckao/gta/pkg/version"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the setup of gta and print its effective settings",
+	Long: `Print the version, config file, profile, and data directory of gta, check
+that the credentials resolve to a caller, and print the effective rate limit
+and retry policies of API calls, after the config file and --max-retries and
+--retry-max-elapsed. Exits non-zero if a check fails.
+
+Retries are tuned by category: conflicts retries writes of a policy changed
+concurrently, transient retries 429, 5xx, and network errors, and propagation
+polls a policy again while revoked bindings are still found in it.
+
+Example:
+  gta doctor
+  gta doctor --max-retries=0`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Version:\t%s\n", version.String())
+	configFile := cfg.Path()
+	if configFile == "" {
+		configFile = "none, using defaults"
+	}
+	fmt.Fprintf(tw, "Config file:\t%s\n", configFile)
+	if profile != "" {
+		fmt.Fprintf(tw, "Profile:\t%s\n", profile)
+	}
+	if dir, err := config.DataDir(); err == nil {
+		fmt.Fprintf(tw, "Data directory:\t%s\n", dir)
+	}
+
+	var checkErr error
+	p, err := newGCPProvider(cmd.Context(), true)
+	if err == nil {
+		var caller string
+		if caller, err = p.Caller(); err == nil {
+			fmt.Fprintf(tw, "Credentials:\t%s\n", caller)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(tw, "Credentials:\tFAILED: %v\n", withReauthHint(err))
+		checkErr = fmt.Errorf("the credentials cannot be used: %w", err)
+	}
+
+	rate, burst := ratelimit.DefaultRate, ratelimit.DefaultBurst
+	if cfg.RateLimit.QPS > 0 {
+		rate = cfg.RateLimit.QPS
+	}
+	if cfg.RateLimit.Burst > 0 {
+		burst = cfg.RateLimit.Burst
+	}
+	fmt.Fprintf(tw, "Rate limit:\t%.2f requests/s (burst %d)\n", rate, burst)
+	fmt.Fprintf(tw, "Concurrency:\t%d worker(s)\n", concurrency)
+	fmt.Fprintln(tw, "Retries:")
+	for _, category := range provider.RetryCategories {
+		fmt.Fprintf(tw, "  %s\t%s\n", category, apiRetries[category])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return checkErr
+}
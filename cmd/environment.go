@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/notify"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// resolveEnvironment returns the environment of project, read from its labels
+// with labels. A failed lookup is warned about and resolves to the
+// environment of environments.on_lookup_failure, the strictest by default.
+func resolveEnvironment(labels labelSource, project string) string {
+	env, err := cfg.EnvironmentFor(project, func() (map[string]string, error) {
+		return labels.ProjectLabels(project)
+	})
+	if err != nil {
+		logger.Warn("Failed to read the labels of project %s, treating it as environment %s: %v", project, env, err)
+	}
+	return env
+}
+
+// enforceEnvironment applies the policy of the environment of a grant: it
+// requires a reason, limits the TTL, and adds the notifications of the
+// environment
+func enforceEnvironment(opts *provider.GCPOptions) error {
+	class, ok := cfg.Environment(opts.Environment)
+	if !ok {
+		return nil
+	}
+	logger.Info("Project %s is in environment %s", opts.Project, opts.Environment)
+	if class.RequireReason && strings.TrimSpace(opts.Reason) == "" {
+		return fmt.Errorf("project %s is in environment %s, which requires a reason: give one with --reason", opts.Project, opts.Environment)
+	}
+	if class.MaxTTL > 0 && opts.TTL > time.Duration(class.MaxTTL) {
+		return fmt.Errorf("ttl %s exceeds the maximum of %s of environment %s", duration.Format(opts.TTL), duration.Format(time.Duration(class.MaxTTL)), opts.Environment)
+	}
+	if class.Notifications != nil && dispatcher != nil {
+		return addEnvironmentRoutes(opts.Environment, *class.Notifications)
+	}
+	return nil
+}
+
+var (
+	environmentRoutesMu sync.Mutex
+	// environmentRoutes are the environments whose routes were added
+	environmentRoutes = make(map[string]bool)
+)
+
+// addEnvironmentRoutes adds the notification routes of environment env to
+// the dispatcher once, confined to the events of env, so that a server
+// granting in several environments notifies each of its own
+func addEnvironmentRoutes(env string, settings config.NotificationsConfig) error {
+	environmentRoutesMu.Lock()
+	defer environmentRoutesMu.Unlock()
+	if environmentRoutes[env] {
+		return nil
+	}
+	routes, err := newRoutes(settings)
+	if err != nil {
+		return err
+	}
+	var confined []notify.Route
+	for _, route := range routes {
+		switch {
+		case len(route.Filter.Environments) == 0:
+			route.Filter.Environments = []string{env}
+		case !slices.Contains(route.Filter.Environments, env):
+			continue
+		}
+		confined = append(confined, route)
+	}
+	dispatcher.Add(confined...)
+	environmentRoutes[env] = true
+	return nil
+}
+
+// describeConfinement describes the service and tags the bindings of opts
+// are confined to for confirmation prompts, empty when they are not
+func describeConfinement(opts *provider.GCPOptions) string {
+	var parts []string
+	if opts.Service != "" {
+		parts = append(parts, "on the resources of "+opts.Service)
+	}
+	for _, tag := range opts.MatchTags {
+		parts = append(parts, "tagged "+tag.String())
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " " + strings.Join(parts, " ")
+}
+
+// confirmEnvironment asks for confirmation of a grant in an environment
+// requiring it, unless yes is set
+func confirmEnvironment(opts *provider.GCPOptions, yes bool) error {
+	class, ok := cfg.Environment(opts.Environment)
+	if !ok || !class.Confirm || yes {
+		return nil
+	}
+	if !stdinIsTerminal() {
+		return fmt.Errorf("project %s is in environment %s, which requires confirmation in a terminal, or --yes", opts.Project, opts.Environment)
+	}
+	member := sessionMember(opts)
+	if member == "" {
+		member = "you"
+	}
+	roles := append(append([]string{}, opts.Roles...), targetRoles(opts.Targets)...)
+	confirmed, err := confirmStdin(fmt.Sprintf("Grant %s to %s in project %s (environment %s)%s for %s?",
+		strings.Join(roles, ", "), member, opts.Project, strings.ToUpper(opts.Environment), describeConfinement(opts), duration.Format(opts.TTL)))
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("grant aborted")
+	}
+	return nil
+}
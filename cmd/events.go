@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Manage the lifecycle events published by the exporters",
+}
+
+var eventsFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Publish the events the Pub/Sub exporter failed to deliver",
+	Long: `Publish to the topic configured under audit.pubsub the events recorded in the
+local audit log as undelivered, oldest first. Each event published is recorded
+as delivered so that it is not published again; the first failure stops the
+flush to keep the events of a session in order.
+
+Example:
+  gta events flush`,
+	Args: cobra.NoArgs,
+	RunE: runEventsFlush,
+}
+
+func init() {
+	eventsCmd.AddCommand(eventsFlushCmd)
+	rootCmd.AddCommand(eventsCmd)
+}
+
+func runEventsFlush(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if cfg.Audit.PubSub == nil {
+		return fmt.Errorf("audit.pubsub is not configured")
+	}
+	if cfg.Audit.Disabled {
+		return fmt.Errorf("the audit log is disabled, no undelivered event is recorded")
+	}
+	path, err := cfg.AuditPath()
+	if err != nil {
+		return err
+	}
+	cipher, err := fileCipher()
+	if err != nil {
+		return err
+	}
+	events, err := audit.Undelivered(path, cipher, audit.ExporterPubSub)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		logger.Info("No undelivered event in %s", path)
+		return nil
+	}
+
+	log, err := audit.NewLog(path, cipher)
+	if err != nil {
+		return err
+	}
+	exporter, err := newPubSubExporter(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer exporter.Close()
+
+	logger.Info("Publishing %d undelivered event(s) to %s", len(events), cfg.Audit.PubSub.Topic)
+	for i, event := range events {
+		if err := exporter.Publish(ctx, []audit.Event{event}); err != nil {
+			return fmt.Errorf("failed to publish event %s, %d event(s) left undelivered: %v", event.ID, len(events)-i, err)
+		}
+		event.Delivery = &audit.Delivery{Exporter: audit.ExporterPubSub, Delivered: true}
+		log.Emit(event)
+	}
+	logger.Info("Successfully published %d event(s)", len(events))
+	return nil
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/consoleurl"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// applyConsoleURL sets the project, and the resource when the link names one
+// that can be granted on, from the console link given with --from-url
+func (o *grantOptions) applyConsoleURL(cmd *cobra.Command) error {
+	target, err := consoleurl.Parse(o.URL)
+	if err != nil {
+		return err
+	}
+	if target.Project != "" {
+		if _, ok := lookupOption(cmd, "project"); ok && o.Project != target.Project {
+			return fmt.Errorf("the console URL is in project %s, not %s", target.Project, o.Project)
+		}
+		o.Project = target.Project
+	}
+	if target.Resource != "" {
+		if _, err := provider.ParseResource(target.Resource); err != nil {
+			return err
+		}
+		o.Resource = target.Resource
+	}
+
+	switch {
+	case o.Resource == "" && o.Project == "":
+		// requireProject asks for the project
+	case o.Resource == "":
+		logger.Warn("Granting on the whole project %s: %s", o.Project, target.Note)
+	case target.Note != "":
+		logger.Info("Granting on %s: %s", o.Resource, target.Note)
+	default:
+		logger.Info("Granting on %s", o.Resource)
+	}
+	return nil
+}
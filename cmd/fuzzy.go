@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// expectedBindings returns the bindings the sessions recorded on this machine
+// hold in the policy of target, one per member, limited to the members of o
+// and, with --expired, to those that expired
+func expectedBindings(o *cleanOptions, target string) ([]provider.ExpectedBinding, error) {
+	store, err := newStateStore()
+	if err != nil {
+		return nil, err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	filter := make(map[string]bool)
+	for _, member := range o.Members {
+		filter[member] = true
+	}
+	if o.User != "" && len(o.Members) == 0 {
+		filter["user:"+o.User] = true
+	}
+
+	now := time.Now()
+	var expected []provider.ExpectedBinding
+	for _, s := range f.Sessions {
+		if s.Provider != "" && s.Provider != provider.NameGCP {
+			continue
+		}
+		for _, b := range s.Bindings {
+			bindingTarget := b.Target
+			if bindingTarget == "" {
+				bindingTarget = s.Target()
+			}
+			if bindingTarget != target || (o.Expired && b.Expiry.After(now)) {
+				continue
+			}
+			for _, member := range sessionMembers(s.Member) {
+				if len(filter) > 0 && !filter[member] {
+					continue
+				}
+				expected = append(expected, provider.ExpectedBinding{
+					Target:    target,
+					Role:      b.Role,
+					Member:    member,
+					BindingID: b.BindingID,
+					Expiry:    b.Expiry,
+				})
+			}
+		}
+	}
+	return expected, nil
+}
+
+// cleanFuzzyMatches finds the bindings of recorded sessions whose title was
+// rewritten by another tool, matching them by member, role, and expiry, and
+// removes them with --fuzzy-match or once confirmed in a terminal
+func cleanFuzzyMatches(p *provider.GCPProvider, o *cleanOptions, fuzzyMatch bool) error {
+	target := o.Project
+	if o.Resource != "" {
+		target = o.Resource
+	}
+	expected, err := expectedBindings(o, target)
+	if err != nil {
+		return err
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+	matches, err := p.FuzzyMatches(target, expected)
+	if err != nil {
+		return fmt.Errorf("failed to match the bindings of recorded sessions: %w", err)
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	for _, m := range matches {
+		logger.Warn("Not found by its title, matched by %s: %s", provider.MatchFuzzy, m)
+	}
+
+	if !fuzzyMatch && !o.DryRun {
+		confirmed := false
+		if len(confirmOptions()) > 0 {
+			if confirmed, err = confirmStdin(fmt.Sprintf("Remove the %d binding(s) above?", len(matches))); err != nil {
+				return err
+			}
+		}
+		if !confirmed {
+			logger.Info("Run gta clean again with --fuzzy-match to remove the %d binding(s) matched by %s", len(matches), provider.MatchFuzzy)
+			return nil
+		}
+	}
+	removed, err := p.RemoveFuzzyMatches(target, matches)
+	if err != nil {
+		return fmt.Errorf("failed to remove the bindings matched by %s: %w", provider.MatchFuzzy, err)
+	}
+	if o.DryRun {
+		logger.Info("[DRY-RUN] Would remove %d binding(s) matched by %s", len(removed), provider.MatchFuzzy)
+	}
+	return nil
+}
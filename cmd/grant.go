@@ -1,16 +1,28 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/duration"
 	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/notify"
+	"github.com/yckao/gta/pkg/policy"
 	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/session"
+	"github.com/yckao/gta/pkg/state"
 )
 
 var grantCmd = &cobra.Command{
@@ -27,59 +39,1097 @@ Example:
   gta grant roles/viewer roles/editor --project=my-project --user=user@example.com
 
   # Preview changes without applying them
-  gta grant roles/viewer --project=my-project --dry-run`,
-	Args: cobra.MinimumNArgs(1),
+  gta grant roles/viewer --project=my-project --dry-run
+
+  # Bypass approval during an incident
+  gta grant roles/editor --project=prod --break-glass --incident=INC-1234
+
+  # Grant again what was granted last, or the third grant of gta history
+  gta grant --last
+  gta grant --last=3 --ttl=30m
+
+  # Grant the roles a Terraform plan needs to be applied
+  terraform show -json plan.out > plan.json
+  gta grant --from-terraform-plan=plan.json --project=my-project
+
+  # Choose the project on the terminal among those whose ID or name contains pay
+  gta grant roles/viewer --pick-project --project-filter=pay
+
+  # Grant on the bucket, dataset, or secret of a console link, or on its project
+  gta grant roles/storage.objectViewer --from-url='https://console.cloud.google.com/storage/browser/my-bucket?project=my-project'
+
+  # Confine roles/viewer to the resources of Cloud Storage
+  gta grant roles/viewer --project=my-project --only-service=storage.googleapis.com
+
+  # Confine roles/viewer to the resources tagged env=prod
+  gta grant roles/viewer --project=my-project --match-tag env=prod
+
+  # Log in to a Cloud SQL instance with IAM database authentication, creating
+  # the database user for the session if it has none
+  gta grant --sql-instance=my-project:europe-west1:orders
+
+  # SSH into one VM through IAP for an hour
+  gta grant roles/iap.tunnelResourceAccessor roles/compute.osLogin --instance=my-project/europe-west1-b/bastion
+
+  # Deploy into a Shared VPC subnetwork of a host project
+  gta grant roles/compute.networkUser --subnet=projects/host/regions/us-central1/subnetworks/app -u dev@example.com
+
+  # Grant on the project, a bucket, and a secret in a single session
+  gta grant roles/viewer --project=my-project \
+    --target=buckets/my-logs=roles/storage.objectViewer \
+    --target=projects/my-project/secrets/db-password=roles/secretmanager.secretAccessor
+
+  # Grant roles for the duration of a command, revoking them however it ends
+  gta grant roles/run.developer --project=my-project --ci -- ./deploy.sh
+
+  # Grant from a script, capturing only when the access expires
+  expiry=$(gta grant roles/viewer --project=my-project --ttl=2h --print=expiry)`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		args, _ = splitCommand(cmd, args)
+		if cmd.Flags().Changed("last") {
+			if len(args) > 0 {
+				return fmt.Errorf("--last re-issues the roles of a past grant and takes no roles, select older grants with --last=N")
+			}
+			return nil
+		}
+		if cmd.Flags().Changed("from-terraform-plan") {
+			if len(args) > 0 {
+				return fmt.Errorf("--from-terraform-plan derives the roles from the plan and takes no roles")
+			}
+			return nil
+		}
+		if cmd.Flags().Changed("mirror") {
+			if len(args) > 0 {
+				return fmt.Errorf("--mirror grants the roles held by the principal mirrored and takes no roles")
+			}
+			return nil
+		}
+		if cmd.Flags().Changed("sql-instance") || cmd.Flags().Changed("target") {
+			// roles/cloudsql.instanceUser is added to the roles given, and
+			// --target carries its own roles
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	// Only GA roles are offered, see gta roles --stage for the others
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeRoles(toComplete, []string{provider.StageGA}), cobra.ShellCompDirectiveNoFileComp
+	},
 	RunE: runGrant,
 }
 
+// grantOptions are the options of gta grant
+type grantOptions struct {
+	commonOptions
+	BreakGlass  bool
+	Incident    string
+	AcceptBroad bool
+	// FailFast stops at the first role that fails, and Atomic revokes the
+	// roles granted before any failure
+	FailFast bool
+	Atomic   bool
+	// AutoCleanOwn removes the expired bindings of the members found before
+	// granting
+	AutoCleanOwn bool
+	// AutoCompact removes every expired binding from a policy the grant
+	// would push over the GCP limits
+	AutoCompact bool
+	// Keep leaves the bindings in place on exit, to expire by their condition
+	Keep bool
+	// ExportSession keeps the bindings and prints the session for the exit
+	// hook of gta shell-init to revoke
+	ExportSession bool
+	// Print keeps the bindings and prints only these values of the grant,
+	// see printGrant, failing when one is missing unless BestEffort
+	Print      string
+	BestEffort bool
+	// Dependencies decides whether the companion roles of role_dependencies
+	// are offered (prompt), added (add), or left out (skip)
+	Dependencies string
+	// RevokeEarly revokes the bindings this long before their condition
+	// expires
+	RevokeEarly time.Duration
+	// Watch checks the bindings during the session, granting the roles found
+	// removed or changed again with AutoRegrant
+	Watch       bool
+	AutoRegrant bool
+	// FuzzyMatch revokes bindings whose title was rewritten, matched by
+	// member, role, and expiry, without confirmation
+	FuzzyMatch bool
+	// TTLGiven is set when the TTL was given, or repeated by Last, rather
+	// than left to the defaults of the config
+	TTLGiven bool
+	// Last selects a past grant to re-issue by its index in gta history
+	Last int
+	// TerraformPlan is the Terraform JSON plan to derive the roles from
+	TerraformPlan string
+	// Mirror is the principal whose temporary bindings are granted again,
+	// with MirrorExpired including those that expired
+	Mirror        string
+	MirrorExpired bool
+	// Roles are the roles of the re-issued grant or of the Terraform plan
+	Roles []string
+	// URL is the console link to derive the project and resource from
+	URL string
+	// Resource is the resource to grant on instead of the project
+	Resource string
+	// SQLInstance is the connection name of the Cloud SQL instance to create
+	// the IAM database user on, and SQLScope whether roles/cloudsql.instanceUser
+	// is granted on the instance or the project
+	SQLInstance string
+	SQLScope    string
+	// OnlyService confines the bindings to the resources of a service,
+	// e.g. storage.googleapis.com
+	OnlyService string
+	// MatchTags confine the bindings to the resources carrying the tags,
+	// KEY=VALUE by name or ID
+	MatchTags []string
+	// Targets are further projects and resources granted roles on in the
+	// same session
+	Targets []provider.GrantTarget
+	Yes     bool
+	// CI reports the phases of the grant for CI systems and does not wait
+	// for the TTL when no command is wrapped
+	CI bool
+	// CISummary is the job summary file, defaulting to that of GitHub Actions
+	CISummary string
+}
+
+// resolveGrantOptions reads the grant options from the flags of cmd, which
+// may be a command defining only some of them such as gta suggest
+func resolveGrantOptions(cmd *cobra.Command) (*grantOptions, error) {
+	common, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return nil, err
+	}
+	last, _ := cmd.Flags().GetInt("last")
+	o := &grantOptions{
+		commonOptions: common,
+		BreakGlass:    flagBool(cmd, "break-glass"),
+		Incident:      flagString(cmd, "incident"),
+		AcceptBroad:   flagBool(cmd, "accept-broad"),
+		FailFast:      flagBool(cmd, "fail-fast"),
+		Atomic:        flagBool(cmd, "atomic"),
+		AutoCleanOwn:  flagBool(cmd, "auto-clean-own"),
+		AutoCompact:   flagBool(cmd, "auto-compact"),
+		Dependencies:  flagString(cmd, "dependencies"),
+		FuzzyMatch:    flagBool(cmd, "fuzzy-match"),
+		Keep:          flagBool(cmd, "keep"),
+		ExportSession: flagBool(cmd, "export-session"),
+		Print:         flagString(cmd, "print"),
+		BestEffort:    flagBool(cmd, "best-effort"),
+		Watch:         flagBool(cmd, "watch") || cfg.Watch.Enabled,
+		AutoRegrant:   flagBool(cmd, "auto-regrant") || cfg.Watch.AutoRegrant,
+		Last:          last,
+		TerraformPlan: flagString(cmd, "from-terraform-plan"),
+		Mirror:        flagString(cmd, "mirror"),
+		MirrorExpired: flagBool(cmd, "mirror-expired"),
+		URL:           flagString(cmd, "from-url"),
+		SQLInstance:   flagString(cmd, "sql-instance"),
+		SQLScope:      flagString(cmd, "sql-scope"),
+		OnlyService:   stringOption(cmd, "only-service", ""),
+		MatchTags:     flagStringArray(cmd, "match-tag"),
+		Yes:           flagBool(cmd, "yes"),
+		CI:            flagBool(cmd, "ci"),
+		CISummary:     flagString(cmd, "ci-summary"),
+	}
+	if o.Last < 0 {
+		return nil, fmt.Errorf("--last must be 1 or more")
+	}
+	_, o.TTLGiven = lookupOption(cmd, "ttl")
+	if o.Targets, err = targetsOption(cmd); err != nil {
+		return nil, err
+	}
+	if o.RevokeEarly, err = durationOption(cmd, "revoke-early", time.Duration(cfg.RevokeEarly)); err != nil {
+		return nil, err
+	}
+	if o.RevokeEarly < 0 {
+		return nil, fmt.Errorf("--revoke-early must be positive")
+	}
+	if o.OnlyService != "" {
+		known, err := provider.CheckService(o.OnlyService)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			logger.Warn("Service %s is not a known one, check its name: the roles grant nothing outside of it", o.OnlyService)
+		}
+	}
+	if o.Last > 0 {
+		if err := o.applyLast(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if o.URL != "" {
+		if err := o.applyConsoleURL(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if o.SQLInstance != "" {
+		if err := o.applySQLInstance(cmd); err != nil {
+			return nil, err
+		}
+	}
+	resource, err := resourceOption(cmd, &o.commonOptions)
+	if err != nil {
+		return nil, err
+	}
+	if resource != "" {
+		o.Resource = resource
+	}
+	if err := o.applyPickProject(cmd); err != nil {
+		return nil, err
+	}
+	if err := o.requireProject(); err != nil {
+		return nil, err
+	}
+	if o.TerraformPlan != "" {
+		if err := o.applyTerraformPlan(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if o.Mirror != "" {
+		if err := o.applyMirror(cmd); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
 func init() {
 	flags := grantCmd.Flags()
-	flags.StringVarP(&project, "project", "p", "", "Project ID (required)")
-	flags.StringVarP(&user, "user", "u", "", "User or service account to grant the role to (defaults to current user)")
-	flags.DurationVarP(&ttl, "ttl", "t", 1*time.Hour, "Time-to-live for the granted permission")
-	flags.BoolVarP(&dryRun, "dry-run", "d", false, "Preview changes without applying them")
-
-	grantCmd.MarkFlagRequired("project")
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID (required)")
+	flags.Bool("pick-project", false, "Choose the project among those you can see on the terminal instead of setting --project")
+	flags.String("project-filter", "", "With --pick-project, only offer the projects whose ID or name contains this")
+	flags.StringP("user", "u", "", "User or service account to grant the role to, me, or @NAME of identities in config (defaults to current user)")
+	flags.StringArray("member", nil, "Exact principal to grant the role to instead of --user, e.g. group:team@example.com (repeatable)")
+	addDurationFlag(grantCmd, "ttl", "t", 1*time.Hour, "Time-to-live for the granted permission, e.g. 30m, 8h, 2d, or 1w")
+	flags.BoolP("dry-run", "d", false, "Preview changes without applying them")
+	flags.StringP("reason", "r", "", "Reason for the access, recorded in the audit log")
+	flags.Bool("break-glass", false, "Bypass approval in an emergency, with a capped TTL and mandatory notifications")
+	flags.String("incident", "", "Incident reference required by --break-glass")
+	flags.Bool("accept-broad", false, "Grant broad roles even when narrower alternatives are recommended")
+	flags.Bool("fail-fast", false, "Stop granting at the first role that fails instead of trying the others")
+	flags.String("dependencies", dependenciesPrompt, "Companion roles of role_dependencies in config: prompt for them, add them, or skip them")
+	flags.Bool("auto-clean-own", false, "Remove your expired temporary bindings found in the policy along with the grant")
+	flags.Bool("auto-compact", false, "Remove the expired temporary bindings of a policy along with the grant when it would exceed the GCP size limits")
+	flags.Bool("atomic", false, "Fail the grant and revoke the roles already granted when any role fails")
+	addDurationFlag(grantCmd, "revoke-early", "", 0, "Revoke the roles this long before their condition expires, e.g. 5m (default from revoke_early in config)")
+	flags.Bool("keep", false, "Leave the bindings in place on exit to expire by their condition, still recording the session and notifying")
+	flags.Bool("export-session", false, "Keep the bindings and print export GTA_SESSION=ID, for the exit hook of gta shell-init to revoke them")
+	flags.String("print", "", "Keep the bindings and print only their expiry, binding-ids, or session-id on stdout, one per line in the order of the roles")
+	flags.Bool("best-effort", false, "With --print, print an empty line for the roles not granted instead of failing")
+	flags.Bool("watch", false, "Warn when another writer removes or changes the bindings during the session (default from watch.enabled)")
+	flags.Bool("auto-regrant", false, "Grant the roles removed or changed during the session again without asking, implies --watch")
+	flags.Bool("fuzzy-match", false, "Revoke bindings whose title was rewritten by another tool, matched by member, role, and expiry, without confirmation")
+	flags.Bool("allow-service-account-caller", false, "Allow running with service account credentials")
+	flags.Int("last", 0, "Re-issue the most recent grant of gta history, or the Nth with --last=N")
+	flags.Lookup("last").NoOptDefVal = "1"
+	flags.String("from-terraform-plan", "", "Grant the predefined roles the changes of a Terraform JSON plan need")
+	flags.String("mirror", "", "Grant the temporary roles this user, @NAME, or principal holds on the project or resource, e.g. at a shift change")
+	flags.Bool("mirror-expired", false, "Also grant the roles of the expired bindings of --mirror")
+	flags.String("from-url", "", "Grant on the project, and the bucket, dataset, or secret when there is one, of a Cloud Console URL")
+	flags.String("instance", "", "Grant IAP tunnel and OS Login roles on this Compute Engine instance, [PROJECT/]ZONE/INSTANCE, rather than the project")
+	flags.String("subnet", "", "Grant on this Shared VPC subnetwork, projects/HOST_PROJECT/regions/REGION/subnetworks/SUBNET, rather than the project")
+	flags.StringArray("target", nil, "Also grant roles on a resource or project in the same session, RESOURCE=ROLE[,ROLE] (repeatable)")
+	flags.String("sql-instance", "", "Grant roles/cloudsql.instanceUser and create the IAM database user on this Cloud SQL instance, PROJECT:REGION:INSTANCE")
+	flags.String("sql-scope", sqlScopeInstance, "Grant roles/cloudsql.instanceUser on the --sql-instance \"instance\" or the whole \"project\"")
+	flags.String("only-service", "", "Confine the roles to the resources of this service, e.g. storage.googleapis.com")
+	flags.StringArray("match-tag", nil, "Confine the roles to the resources carrying this tag, KEY=VALUE by short name, ORG_OR_PROJECT/KEY=VALUE, or tagKeys/ID=tagValues/ID (repeatable)")
+	flags.BoolP("yes", "y", false, "Grant without asking for confirmation the roles of --last, --from-terraform-plan, or --mirror, or in an environment requiring it")
+	flags.Bool("ci", false, "Report the phases for CI systems, and return once granted unless a command is given after --")
+	flags.String("ci-summary", "", "Job summary file written with --ci (default $GITHUB_STEP_SUMMARY in GitHub Actions)")
+	grantCmd.MarkFlagsMutuallyExclusive("last", "from-terraform-plan", "from-url")
+	grantCmd.MarkFlagsMutuallyExclusive("instance", "subnet", "from-url", "sql-instance")
+	grantCmd.MarkFlagsMutuallyExclusive("target", "last", "from-terraform-plan")
+	grantCmd.MarkFlagsMutuallyExclusive("mirror", "last", "from-terraform-plan", "target", "sql-instance")
+	grantCmd.MarkFlagsMutuallyExclusive("pick-project", "project")
+	grantCmd.MarkFlagsMutuallyExclusive("pick-project", "last")
+	grantCmd.MarkFlagsMutuallyExclusive("pick-project", "from-url")
+	grantCmd.MarkFlagsMutuallyExclusive("pick-project", "sql-instance")
+	grantCmd.MarkFlagsMutuallyExclusive("pick-project", "subnet")
+	grantCmd.MarkFlagsMutuallyExclusive("keep", "watch")
+	grantCmd.MarkFlagsMutuallyExclusive("keep", "auto-regrant")
+	grantCmd.MarkFlagsMutuallyExclusive("keep", "revoke-early")
+	grantCmd.MarkFlagsMutuallyExclusive("export-session", "watch")
+	grantCmd.MarkFlagsMutuallyExclusive("export-session", "auto-regrant")
+	grantCmd.MarkFlagsMutuallyExclusive("export-session", "revoke-early")
+	grantCmd.MarkFlagsMutuallyExclusive("export-session", "ci")
+	grantCmd.MarkFlagsMutuallyExclusive("print", "export-session")
+	grantCmd.MarkFlagsMutuallyExclusive("print", "ci")
+	grantCmd.MarkFlagsMutuallyExclusive("print", "watch")
+	grantCmd.MarkFlagsMutuallyExclusive("print", "auto-regrant")
+	grantCmd.MarkFlagsMutuallyExclusive("print", "revoke-early")
+	grantCmd.MarkFlagsMutuallyExclusive("print", "dry-run")
+	registerMemberCompletion(grantCmd)
 }
 
 func runGrant(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	o, err := resolveGrantOptions(cmd)
+	if err != nil {
+		return err
+	}
+	args, command := splitCommand(cmd, args)
+	if o.ExportSession && len(command) > 0 {
+		return fmt.Errorf("--export-session leaves the bindings to the shell and cannot wrap a command")
+	}
+	if err := o.checkPrint(len(command) > 0); err != nil {
+		return err
+	}
+	o.Keep = o.Keep || o.ExportSession || o.Print != ""
+	if o.Keep && len(command) > 0 {
+		return fmt.Errorf("--keep leaves the bindings in place and cannot wrap a command")
+	}
+	if o.Keep {
+		// A session left to expire is not waited on
+		o.Watch, o.AutoRegrant, o.RevokeEarly = false, false, 0
+	}
+	if o.CI && len(command) == 0 {
+		// Nothing revokes the bindings before they expire
+		o.RevokeEarly = 0
+	}
+	if o.Last > 0 || o.TerraformPlan != "" || o.Mirror != "" {
+		args = o.Roles
+	}
+	if o.SQLInstance != "" {
+		args = sqlRoles(args)
+	}
+
+	ctx := cmd.Context()
+	sessionID := audit.NewID()
+	reporter := newCIReporter(o)
+	maskMembers(reporter, o.User)
+	maskMembers(reporter, o.Members...)
+	log := useSessionLogger(sessionID, o.Project)
+
+	if err := checkBreakGlass(o); err != nil {
+		return err
+	}
+	if cfg.ApprovalRequired(o.Project) && !o.BreakGlass {
+		return fmt.Errorf("project %s requires approval, ask for access with gta request instead", o.Project)
+	}
+	if o.BreakGlass {
+		if limit := cfg.BreakGlassMaxTTL(); o.TTL > limit {
+			logger.Warn("Capping TTL to the break-glass maximum of %s", duration.Format(limit))
+			o.TTL = limit
+		}
+		logger.Warn("BREAK-GLASS: granting access to project %s for incident %s, this will be reported", o.Project, o.Incident)
+	}
+	if err := expandDependencies(o, args); err != nil {
+		return err
+	}
+	if err := checkGrantPolicy(append(targetRoles(o.Targets), args...), o.TTL); err != nil {
+		return err
+	}
 
-	if dryRun {
+	if o.DryRun {
 		logger.Info("Running in dry-run mode - no changes will be made")
 	}
 
-	p, err := provider.NewGCPProvider(ctx, dryRun)
+	// Create the event sink before the provider so break-glass grants notify
+	// the break-glass channels as well
+	if _, err := newEventSink(ctx, o.BreakGlass); err != nil {
+		return err
+	}
+	providerOpts := append(confirmOptions(), provider.WithLogger(log), provider.WithServiceAccountCaller(o.AllowServiceAccountCaller), requestReasonOption(o.Reason, o.Incident))
+	if o.Atomic {
+		providerOpts = append(providerOpts, provider.WithPartialFailurePolicy(provider.PartialFailureFail))
+	}
+	if o.FuzzyMatch {
+		providerOpts = append(providerOpts, provider.WithFuzzyMatch(true))
+	}
+	p, err := newGCPProvider(ctx, o.DryRun, providerOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to create GCP provider: %v", err)
 	}
 
 	opts := &provider.GCPOptions{
-		Project: project,
-		Roles:   args,
-		User:    user,
-		TTL:     ttl,
+		Project:      o.Project,
+		Roles:        args,
+		User:         o.User,
+		Members:      o.Members,
+		TTL:          o.TTL,
+		Reason:       o.Reason,
+		SessionID:    sessionID,
+		Profile:      profile,
+		BreakGlass:   o.BreakGlass,
+		Incident:     o.Incident,
+		AcceptBroad:  o.AcceptBroad,
+		FailFast:     o.FailFast,
+		Resource:     o.Resource,
+		AutoCleanOwn: o.AutoCleanOwn,
+		AutoCompact:  o.AutoCompact,
+		RevokeEarly:  o.RevokeEarly,
+		Targets:      o.Targets,
+		MirroredFrom: o.Mirror,
+		Service:      o.OnlyService,
+	}
+	if err := o.applySQLOptions(opts); err != nil {
+		return err
+	}
+	if opts.MatchTags, err = resolveTagMatches(p, o.Project, o.MatchTags); err != nil {
+		return err
+	}
+	if err := checkRoleRules(p.CallerIdentities, opts); err != nil {
+		return err
+	}
+	if err := checkRoleStages(p, opts); err != nil {
+		return err
+	}
+	opts.Environment = resolveEnvironment(p, o.Project)
+	if !o.TTLGiven {
+		resolveRoleTTLs(opts, o.BreakGlass)
+	}
+	if err := enforceEnvironment(opts); err != nil {
+		return err
+	}
+	member := sessionMember(opts)
+	if o.CI && member == "" {
+		caller, err := p.Caller()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		maskMembers(reporter, caller)
+		member = caller
+	}
+	if len(cfg.AllowedGranters) > 0 && member != "" {
+		caller, err := p.Caller()
+		if err != nil {
+			return fmt.Errorf("failed to get current user, needed to grant to others with allowed_granters: %w", err)
+		}
+		if err := checkGranter(caller, opts); err != nil {
+			return err
+		}
+	}
+	if cfg.Policy.Path != "" {
+		caller, err := p.Caller()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		if err := enforcePolicy(ctx, p, opts, caller); err != nil {
+			return err
+		}
+	}
+	if err := checkRevokeEarly(opts); err != nil {
+		return err
+	}
+	if !o.DryRun {
+		if err := confirmEnvironment(opts, o.Yes); err != nil {
+			return err
+		}
+	}
+	logger.Debug("Starting session %s", opts.SessionID)
+
+	var notifications []notify.Notification
+	err = reporter.Group("Grant roles", func() error {
+		err := p.Grant(opts)
+		notifications = flushNotifications()
+		if err != nil {
+			var broadErr *provider.BroadRoleError
+			if errors.As(err, &broadErr) {
+				return fmt.Errorf("%v, rerun with --accept-broad to grant them anyway", err)
+			}
+			reportGrantOutcome(p, "rolled back")
+			rollbackGrant(p, opts)
+			return fmt.Errorf("failed to grant roles: %w", err)
+		}
+		if o.Keep || (o.CI && len(command) == 0) {
+			reportGrantOutcome(p, "left to expire")
+		} else {
+			reportGrantOutcome(p, "revoked on exit")
+		}
+		return nil
+	})
+	if err != nil {
+		reporter.Error("Grant", "%s", logger.Redact(err.Error()))
+		return err
 	}
 
-	if err := p.Grant(opts); err != nil {
-		return fmt.Errorf("failed to grant roles: %v", err)
+	if o.DryRun {
+		return nil
 	}
 
-	if dryRun {
+	if o.Keep {
+		recordKeptSession(opts, p.GrantedRoles())
+	} else {
+		recordSession(opts, p.GrantedRoles())
+	}
+	if o.Print != "" {
+		return printGrant(os.Stdout, o, opts, p.RoleOutcomes(opts))
+	}
+	printSSHCommand(opts.Resource)
+	if o.Keep {
+		for _, role := range p.GrantedRoles() {
+			logger.Info("Keeping %s until %s", roleLabel(role.Role, role.Resource()), role.Expiry.Format(time.RFC3339))
+		}
+		if o.ExportSession {
+			logger.Info("The bindings are revoked when a shell set up with gta shell-init exits, or with gta revoke --from-state --session=%s", opts.SessionID)
+			fmt.Println(exportSession(opts.SessionID))
+			return nil
+		}
+		logger.Info("The bindings expire by their condition; remove them earlier with gta clean --project=%s", o.Project)
+		if o.CI {
+			reporter.Notice("Grant", "Granted %s, kept until they expire by their condition", describeGranted(p.GrantedRoles(), o.Project))
+			writeCISummary(reporter, opts, member, p.GrantedRoles(), "")
+		}
 		return nil
 	}
+	if o.CI && len(command) == 0 {
+		expiry := sessionExpiry(p.GrantedRoles())
+		reporter.Notice("Grant", "Granted %s until %s; the bindings expire on their own, or remove them earlier with gta clean in a step that always runs",
+			describeGranted(p.GrantedRoles(), o.Project), expiry.Format(time.RFC3339))
+		writeCISummary(reporter, opts, member, p.GrantedRoles(), "")
+		return nil
+	}
+
+	recorder := metricsRecorder()
+	recorder.SessionStarted()
+	defer recorder.SessionEnded()
+
+	timer := session.NewTimer(revokeTime(opts, p.GrantedRoles()))
+	scheduleReminder(timer, notifications)
+
+	stopWatch := func() {}
+	if o.Watch || o.AutoRegrant {
+		stopWatch = startWatch(&sessionWatch{
+			p:           p,
+			opts:        opts,
+			interval:    cfg.WatchInterval(),
+			autoRegrant: o.AutoRegrant,
+			prompt:      len(command) == 0 && len(confirmOptions()) > 0,
+		})
+	}
 
-	// Set up signal handling for cleanup
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	var commandErr error
+	if len(command) > 0 {
+		logger.Info("Running %s with the roles granted until %s...", strings.Join(command, " "), timer.Expiry().Format(time.RFC3339))
+		commandErr = runWrapped(ctx, command)
+		if commandErr != nil {
+			reporter.Error("Command", "%s", logger.Redact(commandErr.Error()))
+		}
+	} else {
+		if opts.RevokeEarly > 0 {
+			logger.Info("Revoking %v before the bindings expire at %s", opts.RevokeEarly, sessionExpiry(p.GrantedRoles()).Format(time.RFC3339))
+		}
+		logger.Info("Waiting until %s or interrupt signal to revoke roles (Ctrl+C to exit)...", timer.Expiry().Format(time.RFC3339))
+		if err := timer.Run(ctx); err == nil {
+			logger.Info("Session TTL expired")
+		}
+	}
 
-	logger.Info("Waiting for interrupt signal to revoke roles (Ctrl+C to exit)...")
-	<-sigChan
+	stopWatch()
 
-	logger.Info("Revoking roles...")
-	if err := p.Revoke(opts); err != nil {
-		return fmt.Errorf("failed to revoke roles: %v", err)
+	// The roles are revoked however the command ended, as a phase of its own
+	err = reporter.Group("Revoke roles", func() error {
+		logger.Info("Revoking roles...")
+		detach(p)
+		err := revokeWithReauth(opts.SessionID, func() error {
+			err := p.Revoke(opts)
+			flushNotifications()
+			return err
+		}, freshRevoke(context.Background(), opts, p.GrantedRoles(), providerOpts...))
+		if err != nil {
+			return fmt.Errorf("failed to revoke roles: %w", err)
+		}
+		forgetSession(opts.SessionID)
+		return nil
+	})
+	if err != nil {
+		reporter.Error("Revoke", "%s", logger.Redact(err.Error()))
+		writeCISummary(reporter, opts, member, p.GrantedRoles(), "failed, the bindings expire on their own")
+	} else {
+		reporter.Notice("Revoke", "Revoked %s", describeGranted(p.GrantedRoles(), o.Project))
+		writeCISummary(reporter, opts, member, p.GrantedRoles(), "revoked")
+	}
+	if commandErr != nil {
+		if err != nil {
+			logger.Error("%v", err)
+		}
+		return commandErr
 	}
+	return err
+}
 
+// newStateStore opens the local state file
+func newStateStore() (*state.Store, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := fileCipher()
+	if err != nil {
+		return nil, err
+	}
+	return state.NewStore(filepath.Join(dir, state.FileName), cipher), nil
+}
+
+// sessionMember describes the principals of a grant in the local state
+func sessionMember(opts *provider.GCPOptions) string {
+	if len(opts.Members) > 0 {
+		return strings.Join(opts.Members, ",")
+	}
+	return opts.User
+}
+
+// sessionGrant returns the roles of a session of the local state and the
+// options revoking them
+func sessionGrant(s state.Session) ([]provider.GrantedRole, *provider.GCPOptions) {
+	var granted []provider.GrantedRole
+	for _, b := range s.Bindings {
+		granted = append(granted, provider.GrantedRole{Role: b.Role, BindingID: b.BindingID, Expiry: b.Expiry, Target: b.Target})
+	}
+	opts := &provider.GCPOptions{Project: s.Project, SessionID: s.ID, Profile: s.Profile, Environment: s.Environment}
+	if strings.Contains(s.Member, ":") {
+		opts.Members = strings.Split(s.Member, ",")
+	} else {
+		opts.User = s.Member
+	}
+	return granted, opts
+}
+
+// recordSession saves a granted session to the local state so that its
+// bindings can still be found if this process dies before revoking them.
+// Failures are only warned about as the bindings expire on their own.
+func recordSession(opts *provider.GCPOptions, granted []provider.GrantedRole) {
+	recordScheduledSession(opts, granted, "")
+}
+
+// recordKeptSession saves a granted session whose bindings are left in place
+// on exit, to expire by their condition
+func recordKeptSession(opts *provider.GCPOptions, granted []provider.GrantedRole) {
+	saveSession(opts, granted, func(s *state.Session) { s.Kept = true })
+}
+
+// recordScheduledSession saves a granted session to the local state as the
+// window of a schedule, or of none if scheduleID is empty
+func recordScheduledSession(opts *provider.GCPOptions, granted []provider.GrantedRole, scheduleID string) {
+	saveSession(opts, granted, func(s *state.Session) { s.ScheduleID = scheduleID })
+}
+
+// saveSession saves a granted session to the local state, as adjusted by set
+func saveSession(opts *provider.GCPOptions, granted []provider.GrantedRole, set func(s *state.Session)) {
+	store, err := newStateStore()
+	if err != nil {
+		logger.Warn("Failed to record session: %v", err)
+		return
+	}
+	s := state.Session{
+		ID:          opts.SessionID,
+		Provider:    "gcp",
+		Project:     opts.Project,
+		Resource:    opts.Resource,
+		Member:      sessionMember(opts),
+		Reason:      opts.Reason,
+		Profile:     profile,
+		Environment: opts.Environment,
+		Manifest:    opts.Manifest,
+		PID:         os.Getpid(),
+		StartedAt:   time.Now().UTC(),
+	}
+	set(&s)
+	for _, role := range granted {
+		s.Bindings = append(s.Bindings, state.Binding{Role: role.Role, BindingID: role.BindingID, Expiry: role.Expiry, Target: role.Target})
+	}
+	err = store.Update(func(f *state.File) error {
+		f.Put(s)
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Failed to record session: %v", err)
+	}
+}
+
+// forgetSession removes a revoked session from the local state
+func forgetSession(id string) {
+	store, err := newStateStore()
+	if err != nil {
+		logger.Warn("Failed to update the session state: %v", err)
+		return
+	}
+	err = store.Update(func(f *state.File) error {
+		f.Remove(id)
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Failed to update the session state: %v", err)
+	}
+}
+
+// checkBreakGlass validates the break-glass flags. Break-glass only has an
+// effect on projects requiring approval and is turned off with a warning elsewhere.
+func checkBreakGlass(o *grantOptions) error {
+	if !o.BreakGlass {
+		if o.Incident != "" {
+			return fmt.Errorf("--incident can only be used with --break-glass")
+		}
+		return nil
+	}
+	if o.Incident == "" {
+		return fmt.Errorf("--break-glass requires an incident reference with --incident")
+	}
+	if !cfg.IncidentValid(o.Incident) {
+		return fmt.Errorf("incident reference %q does not match the pattern %s", o.Incident, cfg.IncidentPattern())
+	}
+	if !cfg.ApprovalRequired(o.Project) {
+		logger.Warn("--break-glass has no effect: project %s does not require approval", o.Project)
+		o.BreakGlass = false
+		o.Incident = ""
+	}
+	return nil
+}
+
+// checkGrantPolicy enforces the configured TTL limit and allowed roles
+func checkGrantPolicy(roles []string, ttl time.Duration) error {
+	if cfg.MaxTTL > 0 && ttl > time.Duration(cfg.MaxTTL) {
+		return fmt.Errorf("ttl %s exceeds the maximum of %s allowed by config", duration.Format(ttl), duration.Format(time.Duration(cfg.MaxTTL)))
+	}
+	for _, role := range roles {
+		if !cfg.RoleAllowed(provider.FormatRole(role)) {
+			return fmt.Errorf("role %s is not allowed by config", provider.FormatRole(role))
+		}
+	}
+	return nil
+}
+
+// checkRoleStages warns about the deprecated and disabled roles of opts, or
+// refuses them with forbid_deprecated_roles. A role whose stage cannot be
+// read is left to the grant, which fails if the role does not exist.
+func checkRoleStages(p roleSource, opts *provider.GCPOptions) error {
+	for _, name := range append(append([]string{}, opts.Roles...), targetRoles(opts.Targets)...) {
+		role, err := p.Role(name)
+		if err != nil {
+			logger.Debug("Not checking the launch stage of %s: %v", name, err)
+			continue
+		}
+		if !role.Retired() {
+			continue
+		}
+		instead := ""
+		if replacement := role.Replacement(); replacement != "" {
+			instead = ", grant " + replacement + " instead"
+		}
+		if cfg.ForbidDeprecatedRoles {
+			return fmt.Errorf("role %s is %s, which forbid_deprecated_roles in config refuses%s", role.Name, strings.ToLower(role.Stage), instead)
+		}
+		logger.Warn("Role %s is %s%s", role.Name, strings.ToLower(role.Stage), instead)
+	}
+	return nil
+}
+
+// checkGranter enforces allowed_granters, which the caller must match to
+// grant to members other than themselves
+func checkGranter(caller string, opts *provider.GCPOptions) error {
+	if cfg.GranterAllowed(caller) {
+		return nil
+	}
+	for _, principal := range sessionMembers(sessionMember(opts)) {
+		if _, email, _ := strings.Cut(principal, ":"); !strings.EqualFold(email, caller) {
+			return fmt.Errorf("%s may only grant to themselves: granting to %s is restricted to allowed_granters", caller, principal)
+		}
+	}
+	return nil
+}
+
+// checkRoleRules enforces no_self_grant and role_granters on a direct grant
+// of opts, by the caller whose emails identities returns. Approved requests
+// are not checked, the approval of someone else satisfying both.
+func checkRoleRules(identities func() ([]string, error), opts *provider.GCPOptions) error {
+	var ruled []string
+	for _, role := range append(append([]string{}, opts.Roles...), targetRoles(opts.Targets)...) {
+		if role = provider.FormatRole(role); cfg.HasRoleRules(role) {
+			ruled = append(ruled, role)
+		}
+	}
+	if len(ruled) == 0 {
+		return nil
+	}
+	callers, err := identities()
+	if err != nil {
+		return fmt.Errorf("failed to get current user, needed by no_self_grant and role_granters: %w", err)
+	}
+	caller := callers[0]
+	if len(callers) > 1 {
+		caller = fmt.Sprintf("%s impersonated by %s", callers[0], strings.Join(callers[1:], ", "))
+	}
+	// Without members the roles are granted to the caller
+	grantees := sessionMembers(sessionMember(opts))
+	for _, role := range ruled {
+		if rule, denied := cfg.RoleGranterDenied(role, callers); denied {
+			return fmt.Errorf("%s may not grant %s: %s restricts it to its allowed_granters", caller, role, rule)
+		}
+		rule, forbidden := cfg.SelfGrantForbidden(role)
+		if !forbidden {
+			continue
+		}
+		self := len(grantees) == 0
+		for _, principal := range grantees {
+			_, email, _ := strings.Cut(principal, ":")
+			for _, c := range callers {
+				self = self || strings.EqualFold(email, c)
+			}
+		}
+		if self {
+			return fmt.Errorf("%s may not grant %s to themselves by %s: have someone else grant it, or ask for it with gta request", caller, role, rule)
+		}
+	}
+	return nil
+}
+
+// roleSource looks up the launch stage of roles
+type roleSource interface {
+	Role(name string) (provider.PredefinedRole, error)
+}
+
+// labelSource fetches the project labels passed to the policy
+type labelSource interface {
+	ProjectLabels(project string) (map[string]string, error)
+}
+
+var (
+	policyOnce      sync.Once
+	policyEvaluator *policy.Evaluator
+	policyErr       error
+)
+
+// loadPolicy compiles the configured policy once
+func loadPolicy(ctx context.Context) (*policy.Evaluator, error) {
+	policyOnce.Do(func() {
+		policyEvaluator, policyErr = policy.Load(ctx, cfg.Policy.Path)
+	})
+	return policyEvaluator, policyErr
+}
+
+// enforcePolicy evaluates the configured policy for a grant by caller and
+// applies the TTL it sets. Policy failures deny grants in sensitive projects
+// and are ignored with a warning elsewhere.
+func enforcePolicy(ctx context.Context, labels labelSource, opts *provider.GCPOptions, caller string) error {
+	if cfg.Policy.Path == "" {
+		return nil
+	}
+	decision, err := evaluatePolicy(ctx, labels, opts, caller)
+	if err != nil {
+		if cfg.PolicySensitive(opts.Project) {
+			return fmt.Errorf("grant denied, policy failed for sensitive project %s: %v", opts.Project, err)
+		}
+		logger.Warn("Ignoring policy failure for project %s: %v", opts.Project, err)
+		return nil
+	}
+	if !decision.Allowed() {
+		return fmt.Errorf("grant denied by policy: %s", strings.Join(decision.Deny, "; "))
+	}
+	switch {
+	case decision.TTL == 0 || decision.TTL == opts.TTL:
+	case decision.TTL > opts.TTL:
+		logger.Warn("Ignoring policy TTL %s longer than the requested %s", duration.Format(decision.TTL), duration.Format(opts.TTL))
+	default:
+		logger.Info("Policy reduced the TTL to %s", duration.Format(decision.TTL))
+		opts.TTL = decision.TTL
+	}
 	return nil
 }
+
+// evaluatePolicy builds the policy input of a grant and evaluates it, once
+// for each member when exact members are given. Their denials add up and the
+// shortest TTL applies.
+func evaluatePolicy(ctx context.Context, labels labelSource, opts *provider.GCPOptions, caller string) (policy.Decision, error) {
+	evaluator, err := loadPolicy(ctx)
+	if err != nil {
+		return policy.Decision{}, err
+	}
+	projectLabels, err := labels.ProjectLabels(opts.Project)
+	if err != nil {
+		return policy.Decision{}, err
+	}
+
+	input := policy.NewInput(time.Now(), opts.TTL)
+	input.Caller = caller
+	input.Member = opts.User
+	if input.Member == "" {
+		input.Member = caller
+	}
+	for _, role := range append(append([]string{}, opts.Roles...), targetRoles(opts.Targets)...) {
+		input.Roles = append(input.Roles, provider.FormatRole(role))
+	}
+	input.Project = opts.Project
+	input.ProjectLabels = projectLabels
+	input.Environment = opts.Environment
+	if input.Environment == "" {
+		input.Environment, _ = cfg.EnvironmentFor(opts.Project, func() (map[string]string, error) { return projectLabels, nil })
+	}
+	input.Reason = opts.Reason
+	input.BreakGlass = opts.BreakGlass
+	input.Incident = opts.Incident
+	if len(opts.Members) == 0 {
+		return evaluator.Evaluate(ctx, input)
+	}
+
+	var decision policy.Decision
+	for _, member := range opts.Members {
+		input.Member = member
+		d, err := evaluator.Evaluate(ctx, input)
+		if err != nil {
+			return policy.Decision{}, err
+		}
+		decision.Deny = append(decision.Deny, d.Deny...)
+		if d.TTL > 0 && (decision.TTL == 0 || d.TTL < decision.TTL) {
+			decision.TTL = d.TTL
+		}
+	}
+	return decision, nil
+}
+
+// confirmOptions lets the user confirm broad roles when running in a terminal
+func confirmOptions() []provider.GCPProviderOption {
+	if !stdinIsTerminal() {
+		return nil
+	}
+	return []provider.GCPProviderOption{provider.WithConfirm(confirmStdin)}
+}
+
+// confirmStdin asks a yes/no question on the terminal, defaulting to no
+func confirmStdin(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// reportGrantOutcome summarizes a grant in which some roles failed, telling
+// the roles granted, those that failed, and those never attempted apart, as a
+// single record in JSON output. outcome tells what becomes of the granted roles.
+func reportGrantOutcome(p *provider.GCPProvider, outcome string) {
+	failed := p.GrantErrors()
+	notAttempted := p.NotAttemptedRoles()
+	if len(failed) == 0 && len(notAttempted) == 0 {
+		return
+	}
+	applied := make([]string, 0, len(p.GrantedRoles()))
+	for _, role := range p.GrantedRoles() {
+		applied = append(applied, roleLabel(role.Role, role.Resource()))
+	}
+	if logFormat == string(logger.FormatJSON) {
+		logger.WarnAttrs("Grant incomplete",
+			slog.Any("applied", applied),
+			slog.String("applied_outcome", outcome),
+			slog.Any("failed", failed.Details()),
+			slog.Any("not_attempted", append([]string{}, notAttempted...)))
+		return
+	}
+	logger.Warn("Grant incomplete:")
+	if len(applied) > 0 {
+		logger.Warn("  Applied, %s: %s", outcome, strings.Join(applied, ", "))
+	}
+	for _, roleErr := range failed {
+		logger.Warn("  Failed: %s: %v", roleLabel(roleErr.Role, roleErr.Resource), roleErr.Err)
+	}
+	if len(notAttempted) > 0 {
+		logger.Warn("  Not attempted: %s", strings.Join(notAttempted, ", "))
+	}
+}
+
+// rollbackGrant revokes the roles that were granted before a grant failed
+func rollbackGrant(p *provider.GCPProvider, opts *provider.GCPOptions) {
+	if len(p.GrantedRoles()) == 0 {
+		return
+	}
+	// Record the roles granted until the failure, so that they are still
+	// found if revoking them fails too
+	recordSession(opts, p.GrantedRoles())
+	logger.Info("Revoking roles granted before the failure...")
+	detach(p)
+	err := p.Revoke(opts)
+	flushNotifications()
+	if err != nil {
+		logger.Error("Failed to revoke roles: %v", err)
+		return
+	}
+	forgetSession(opts.SessionID)
+}
+
+// detach makes the following API calls of p use a fresh context, so that
+// roles are still revoked once the command is interrupted
+func detach(p *provider.GCPProvider) {
+	p.SetContext(context.Background())
+}
+
+// sessionExpiry returns the earliest expiry of the granted roles
+func sessionExpiry(granted []provider.GrantedRole) time.Time {
+	var expiry time.Time
+	for _, role := range granted {
+		if expiry.IsZero() || role.Expiry.Before(expiry) {
+			expiry = role.Expiry
+		}
+	}
+	return expiry
+}
+
+// revokeTime returns when the session revokes the granted roles, ahead of
+// their earliest expiry with --revoke-early
+func revokeTime(opts *provider.GCPOptions, granted []provider.GrantedRole) time.Time {
+	return sessionExpiry(granted).Add(-opts.RevokeEarly)
+}
+
+// resolveRoleTTLs sets the TTL of each role of opts, given without --ttl,
+// from the defaults of the config by the precedence of config.ResolveTTL,
+// capped by the max_ttl of the environment and for break-glass grants, and
+// the TTL of the session to the longest of them. The TTL of opts is the default of --ttl until then.
+func resolveRoleTTLs(opts *provider.GCPOptions, breakGlass bool) {
+	fallback := opts.TTL
+	opts.TTL, opts.RoleTTLs = 0, make(map[string]provider.RoleTTL)
+	for _, role := range append(append([]string{}, opts.Roles...), targetRoles(opts.Targets)...) {
+		role = provider.FormatRole(role)
+		ttl, rule := cfg.ResolveTTL(role, opts.Environment, 0, fallback)
+		if class, ok := cfg.Environment(opts.Environment); ok && class.MaxTTL > 0 && ttl > time.Duration(class.MaxTTL) {
+			ttl, rule = time.Duration(class.MaxTTL), "the max_ttl of environment "+opts.Environment
+		}
+		if breakGlass && ttl > cfg.BreakGlassMaxTTL() {
+			ttl, rule = cfg.BreakGlassMaxTTL(), "the break-glass maximum"
+		}
+		opts.RoleTTLs[role] = provider.RoleTTL{TTL: ttl, Rule: rule}
+		opts.TTL = max(opts.TTL, ttl)
+		logger.Debug("TTL of %s: %s by %s", role, duration.Format(ttl), rule)
+	}
+	if opts.TTL == 0 {
+		opts.TTL = fallback
+	}
+}
+
+// checkRevokeEarly rejects a grace period that leaves no time to use the
+// roles, checked once the TTL is final
+func checkRevokeEarly(opts *provider.GCPOptions) error {
+	if opts.RevokeEarly > 0 && opts.RevokeEarly >= opts.TTL {
+		return fmt.Errorf("--revoke-early %s must be shorter than the TTL %s", duration.Format(opts.RevokeEarly), duration.Format(opts.TTL))
+	}
+	return nil
+}
+
+// scheduleReminder sends a reminder notification before the session expires
+// when the active profile configures one and the TTL is long enough
+func scheduleReminder(timer *session.Timer, notifications []notify.Notification) {
+	remindBefore := time.Duration(cfg.NotificationsFor(profile).RemindBefore)
+	if dispatcher == nil || remindBefore <= 0 || len(notifications) == 0 {
+		return
+	}
+	if timer.Remaining() <= remindBefore {
+		logger.Debug("Session is shorter than the reminder offset %v, not scheduling a reminder", remindBefore)
+		return
+	}
+
+	timer.Before(remindBefore, func(remaining time.Duration) {
+		logger.Info("Temporary access expires in %v", remaining.Round(time.Second))
+		for _, n := range notifications {
+			if n.Action != audit.ActionGrant {
+				continue
+			}
+			n.Action = notify.ActionExpiring
+			dispatcher.Send(n)
+		}
+	})
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// fakeLabels returns fixed project labels, or err
+type fakeLabels struct {
+	labels map[string]string
+	err    error
+}
+
+func (f fakeLabels) ProjectLabels(project string) (map[string]string, error) {
+	return f.labels, f.err
+}
+
+// usePolicy configures rego as the policy, with sensitive projects matching
+// prod-.*, restoring the configuration when the test ends
+func usePolicy(t *testing.T, rego string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	if err := os.WriteFile(path, []byte(rego), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.Parse([]byte(fmt.Sprintf("policy:\n  path: %s\n  sensitive_projects: [\"prod-.*\"]\n", path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := cfg
+	cfg = c
+	policyOnce = sync.Once{}
+	t.Cleanup(func() {
+		cfg = saved
+		policyOnce = sync.Once{}
+	})
+}
+
+const reasonPolicy = `package gta
+
+import rego.v1
+
+deny contains "a reason is required" if input.reason == ""
+
+ttl := "15m" if input.project_labels.env == "prod"
+`
+
+func TestEnforcePolicyOutcomes(t *testing.T) {
+	usePolicy(t, reasonPolicy)
+	prod := fakeLabels{labels: map[string]string{"env": "prod"}}
+
+	opts := &provider.GCPOptions{Project: "dev-1", Roles: []string{"viewer"}, TTL: time.Hour}
+	if err := enforcePolicy(context.Background(), fakeLabels{}, opts, "alice@example.com"); err == nil || !strings.Contains(err.Error(), "a reason is required") {
+		t.Errorf("grant without a reason = %v, want denial", err)
+	}
+
+	opts.Reason = "debug"
+	if err := enforcePolicy(context.Background(), fakeLabels{}, opts, "alice@example.com"); err != nil {
+		t.Errorf("allowed grant = %v", err)
+	}
+	if opts.TTL != time.Hour {
+		t.Errorf("TTL = %v without a policy TTL, want 1h", opts.TTL)
+	}
+
+	if err := enforcePolicy(context.Background(), prod, opts, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if opts.TTL != 15*time.Minute {
+		t.Errorf("TTL = %v, want the policy's 15m", opts.TTL)
+	}
+
+	// The policy TTL never lengthens a grant
+	opts.TTL = 5 * time.Minute
+	if err := enforcePolicy(context.Background(), prod, opts, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if opts.TTL != 5*time.Minute {
+		t.Errorf("TTL = %v, want the requested 5m", opts.TTL)
+	}
+}
+
+func TestEnforcePolicyFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rego   string
+		labels fakeLabels
+	}{
+		{"broken policy", "package gta\ndeny contains", fakeLabels{}},
+		{"label lookup failure", reasonPolicy, fakeLabels{err: errors.New("permission denied")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			usePolicy(t, tc.rego)
+			for project, wantDenied := range map[string]bool{"prod-api": true, "dev-api": false} {
+				opts := &provider.GCPOptions{Project: project, Roles: []string{"viewer"}, TTL: time.Hour, Reason: "debug"}
+				err := enforcePolicy(context.Background(), tc.labels, opts, "alice@example.com")
+				if wantDenied && (err == nil || !strings.Contains(err.Error(), "policy failed for sensitive project")) {
+					t.Errorf("%s: %v, want the grant denied", project, err)
+				}
+				if !wantDenied && err != nil {
+					t.Errorf("%s: %v, want the failure ignored", project, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRoleRules(t *testing.T) {
+	useConfig(t, `
+no_self_grant:
+  - roles/iam\.securityAdmin
+role_granters:
+  - role: roles/owner
+    allowed_granters: ["admin@example\\.com"]
+  - role: roles/editor
+    allowed_granters: ["deployer@p\\.iam\\.gserviceaccount\\.com"]
+`)
+	// Alice impersonates the deployer service account
+	impersonating := func() ([]string, error) {
+		return []string{"deployer@p.iam.gserviceaccount.com", "alice@example.com"}, nil
+	}
+	for _, tc := range []struct {
+		name       string
+		identities func() ([]string, error)
+		opts       provider.GCPOptions
+		wantErr    string
+	}{
+		{name: "to the source account", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"iam.securityAdmin"}, User: "alice@example.com"}, wantErr: "deployer@p.iam.gserviceaccount.com impersonated by alice@example.com may not grant roles/iam.securityAdmin to themselves by no_self_grant[0]"},
+		{name: "to the service account", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"iam.securityAdmin"}, Members: []string{"serviceAccount:deployer@p.iam.gserviceaccount.com"}}, wantErr: "to themselves"},
+		{name: "to the caller", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"iam.securityAdmin"}}, wantErr: "to themselves"},
+		{name: "among others", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"iam.securityAdmin"}, Members: []string{"user:bob@example.com", "user:Alice@example.com"}}, wantErr: "to themselves"},
+		{name: "to someone else", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"iam.securityAdmin"}, User: "bob@example.com"}},
+		{name: "allowed service account", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"editor"}, User: "bob@example.com"}},
+		{name: "neither allowed", identities: impersonating, opts: provider.GCPOptions{Roles: []string{"owner"}, User: "bob@example.com"}, wantErr: "deployer@p.iam.gserviceaccount.com impersonated by alice@example.com may not grant roles/owner: role_granters[0]"},
+		{
+			name: "allowed source account",
+			identities: func() ([]string, error) {
+				return []string{"deployer@p.iam.gserviceaccount.com", "admin@example.com"}, nil
+			},
+			opts: provider.GCPOptions{Roles: []string{"owner"}, User: "bob@example.com"},
+		},
+		{
+			name:       "without impersonation",
+			identities: func() ([]string, error) { return []string{"alice@example.com"}, nil },
+			opts:       provider.GCPOptions{Roles: []string{"editor"}, User: "bob@example.com"},
+			wantErr:    "alice@example.com may not grant roles/editor",
+		},
+		{
+			name:       "unknown caller",
+			identities: func() ([]string, error) { return nil, errors.New("no credentials") },
+			opts:       provider.GCPOptions{Roles: []string{"owner"}, User: "bob@example.com"},
+			wantErr:    "failed to get current user",
+		},
+		{
+			name:       "unruled roles",
+			identities: func() ([]string, error) { t.Error("identities called without rules"); return nil, nil },
+			opts:       provider.GCPOptions{Roles: []string{"viewer"}, User: "alice@example.com"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts
+			err := checkRoleRules(tc.identities, &opts)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("checkRoleRules = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// roleTTLsConfig sets default TTLs by role, and prod caps them at 2h
+const roleTTLsConfig = `
+default_ttl: 3h
+default_ttl_by_role:
+  roles/owner: 15m
+  roles/logging\..*: 6h
+environments:
+  label: env
+  classes:
+    - name: dev
+    - name: prod
+      max_ttl: 2h
+`
+
+func TestResolveRoleTTLs(t *testing.T) {
+	useConfig(t, roleTTLsConfig)
+	for _, tc := range []struct {
+		name        string
+		roles       []string
+		environment string
+		breakGlass  bool
+		// want are the TTLs and rules of the roles, and wantTTL the TTL of
+		// the session
+		want    map[string]provider.RoleTTL
+		wantTTL time.Duration
+	}{
+		{
+			name:  "by role and default",
+			roles: []string{"owner", "viewer", "logging.viewer"},
+			want: map[string]provider.RoleTTL{
+				"roles/owner":          {TTL: 15 * time.Minute, Rule: "default_ttl_by_role[roles/owner]"},
+				"roles/viewer":         {TTL: 3 * time.Hour, Rule: "default_ttl"},
+				"roles/logging.viewer": {TTL: 6 * time.Hour, Rule: `default_ttl_by_role[roles/logging\..*]`},
+			},
+			wantTTL: 6 * time.Hour,
+		},
+		{
+			name:        "capped by the environment",
+			roles:       []string{"owner", "logging.viewer"},
+			environment: "prod",
+			want: map[string]provider.RoleTTL{
+				"roles/owner":          {TTL: 15 * time.Minute, Rule: "default_ttl_by_role[roles/owner]"},
+				"roles/logging.viewer": {TTL: 2 * time.Hour, Rule: "the max_ttl of environment prod"},
+			},
+			wantTTL: 2 * time.Hour,
+		},
+		{
+			name:        "environment without a cap",
+			roles:       []string{"logging.viewer"},
+			environment: "dev",
+			want:        map[string]provider.RoleTTL{"roles/logging.viewer": {TTL: 6 * time.Hour, Rule: `default_ttl_by_role[roles/logging\..*]`}},
+			wantTTL:     6 * time.Hour,
+		},
+		{
+			name:       "break-glass",
+			roles:      []string{"owner", "viewer"},
+			breakGlass: true,
+			want: map[string]provider.RoleTTL{
+				"roles/owner":  {TTL: 15 * time.Minute, Rule: "default_ttl_by_role[roles/owner]"},
+				"roles/viewer": {TTL: time.Hour, Rule: "the break-glass maximum"},
+			},
+			wantTTL: time.Hour,
+		},
+		{name: "no roles", want: map[string]provider.RoleTTL{}, wantTTL: 45 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &provider.GCPOptions{Roles: tc.roles, Environment: tc.environment, TTL: 45 * time.Minute}
+			resolveRoleTTLs(opts, tc.breakGlass)
+			if opts.TTL != tc.wantTTL {
+				t.Errorf("TTL %s, want %s", opts.TTL, tc.wantTTL)
+			}
+			if len(opts.RoleTTLs) != len(tc.want) {
+				t.Errorf("role TTLs %v, want %v", opts.RoleTTLs, tc.want)
+			}
+			for role, want := range tc.want {
+				if got := opts.RoleTTLs[role]; got != want {
+					t.Errorf("%s: %s by %s, want %s by %s", role, got.TTL, got.Rule, want.TTL, want.Rule)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/history"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recent grants that can be issued again",
+	Long: `List the recent grants made from this machine with the current profile, as
+recorded in the local audit log, most recent first. The index of a grant
+selects it for gta grant --last.
+
+Example:
+  gta history
+  gta grant --last=3 --ttl=30m`,
+	Args: cobra.NoArgs,
+	RunE: runHistory,
+}
+
+func init() {
+	historyCmd.Flags().IntP("limit", "n", 10, "Maximum number of grants to show")
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	grants, err := grantHistory()
+	if err != nil {
+		return err
+	}
+	if len(grants) == 0 {
+		logger.Info("No grants found in the history of this profile")
+		return nil
+	}
+	for i, grant := range grants {
+		if i == limit {
+			logger.Info("  ... and %d more", len(grants)-i)
+			break
+		}
+		logger.Info("%3d  %s  %s", i+1, grant.Time.Local().Format("2006-01-02 15:04"), describeGrant(grant))
+	}
+	return nil
+}
+
+// grantHistory reads the grants of the current profile from the audit log
+func grantHistory() ([]history.Grant, error) {
+	if cfg.Audit.Disabled {
+		return nil, fmt.Errorf("the grant history is read from the audit log, which is disabled")
+	}
+	path, err := cfg.AuditPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	cipher, err := fileCipher()
+	if err != nil {
+		return nil, err
+	}
+	events, err := audit.ReadLog(path, cipher)
+	if err != nil {
+		return nil, err
+	}
+	return history.Grants(events, profile), nil
+}
+
+// describeGrant summarizes a past grant on one line
+func describeGrant(grant history.Grant) string {
+	desc := fmt.Sprintf("%s to %s in %s", strings.Join(grant.Roles, ", "), strings.Join(grant.Members, ", "), grant.Project)
+	if grant.Resource != "" {
+		desc = fmt.Sprintf("%s to %s on %s", strings.Join(grant.Roles, ", "), strings.Join(grant.Members, ", "), grant.Resource)
+	}
+	if grant.TTL > 0 {
+		desc += " for " + duration.Format(grant.TTL)
+	}
+	if grant.Reason != "" {
+		desc += fmt.Sprintf(" (%s)", grant.Reason)
+	}
+	return desc
+}
+
+// applyLast replaces the options with those of the past grant selected by
+// --last, keeping --ttl when it is set, and asks for confirmation unless --yes
+func (o *grantOptions) applyLast(cmd *cobra.Command) error {
+	for _, name := range []string{"project", "user", "member", "reason", "break-glass", "incident"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s cannot be combined with --last, which re-issues a past grant as it was", name)
+		}
+	}
+	grants, err := grantHistory()
+	if err != nil {
+		return err
+	}
+	if o.Last > len(grants) {
+		return fmt.Errorf("no grant #%d in the history of this profile, which has %d grant(s); see gta history", o.Last, len(grants))
+	}
+	grant := grants[o.Last-1]
+
+	o.Project = grant.Project
+	o.Resource = grant.Resource
+	o.Roles = grant.Roles
+	o.Reason = grant.Reason
+	o.User, o.Members = "", nil
+	if len(grant.Members) == 1 && strings.HasPrefix(grant.Members[0], "user:") {
+		o.User = strings.TrimPrefix(grant.Members[0], "user:")
+	} else {
+		o.Members = grant.Members
+	}
+	if _, ok := lookupOption(cmd, "ttl"); !ok && grant.TTL > 0 {
+		o.TTL, o.TTLGiven = grant.TTL, true
+	}
+
+	logger.Info("Grant #%d of %s: %s", o.Last, grant.Time.Local().Format(time.RFC3339), describeGrant(grant))
+	if o.TTL != grant.TTL {
+		logger.Info("TTL overridden to %s", duration.Format(o.TTL))
+	}
+	if o.Yes || o.DryRun {
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("re-issuing a past grant needs confirmation in a terminal, or --yes")
+	}
+	confirmed, err := confirmStdin("Grant it again?")
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("grant aborted")
+	}
+	return nil
+}
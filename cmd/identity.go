@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// selfIdentity is the --user naming the caller, so that scripts shared
+// between teammates need neither an email nor the default
+const selfIdentity = "me"
+
+// identityPrefix starts the names of the identities of the config, e.g.
+// @oncall
+const identityPrefix = "@"
+
+// expandIdentity returns the identity an alias such as @oncall names in the
+// identities of the config, and value unchanged when it is not an alias
+func expandIdentity(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, identityPrefix)
+	if !ok {
+		return value, nil
+	}
+	identity, ok := cfg.Identities[name]
+	if !ok {
+		if len(cfg.Identities) == 0 {
+			return "", fmt.Errorf("unknown identity %s: no identities are defined in the config file", value)
+		}
+		return "", fmt.Errorf("unknown identity %s (expected one of %s%s)", value, identityPrefix, strings.Join(cfg.IdentityNames(), ", "+identityPrefix))
+	}
+	logger.Debug("Expanded identity %s to %s", value, identity)
+	return identity, nil
+}
+
+// expandMember expands an alias given to --member into a principal, with
+// user: added to the identities given as emails
+func expandMember(member string) (string, error) {
+	expanded, err := expandIdentity(member)
+	if err != nil {
+		return "", err
+	}
+	if expanded != member && !strings.Contains(expanded, ":") {
+		expanded = "user:" + expanded
+	}
+	return expanded, nil
+}
+
+// resolveUser resolves the value of --user: me is the caller as found by the
+// identity sources of the provider, and an alias expands to its identity.
+// An alias of a principal other than a user is returned as a member instead.
+func resolveUser(ctx context.Context, user string) (string, []string, error) {
+	if user == selfIdentity {
+		p, err := newGCPProvider(ctx, true)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		caller, err := p.Caller()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to resolve --user %s: %w", selfIdentity, err)
+		}
+		logger.Debug("Resolved --user %s to %s", selfIdentity, caller)
+		return caller, nil, nil
+	}
+	expanded, err := expandIdentity(user)
+	if err != nil {
+		return "", nil, err
+	}
+	if email, ok := strings.CutPrefix(expanded, "user:"); ok {
+		return email, nil, nil
+	}
+	if strings.Contains(expanded, ":") {
+		return "", []string{expanded}, nil
+	}
+	return expanded, nil, nil
+}
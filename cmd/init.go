@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/fileutil"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the gta configuration file",
+	Long: `Create the configuration file, asking for the default project, the default and
+maximum TTL, the log format, a notification webhook, and a profile name. Each
+answer is checked like the config loader checks the file, and the file written
+is validated in full before it replaces anything.
+
+An existing file is left alone unless --force is given; run interactively,
+gta init offers to add a new profile to it instead.
+
+Example:
+  gta init
+  gta init --defaults --config ./team.gta.yaml`,
+	Args: cobra.NoArgs,
+	// Skip the root config loading, as the file may not exist yet
+	PersistentPreRunE: setupLogging,
+	RunE:              runInit,
+}
+
+func init() {
+	initCmd.Flags().Bool("defaults", false, "Write the defaults without asking any question")
+	initCmd.Flags().Bool("force", false, "Overwrite an existing config file")
+	rootCmd.AddCommand(initCmd)
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	defaults := flagBool(cmd, "defaults")
+	force := flagBool(cmd, "force")
+	q := &initPrompter{in: bufio.NewReader(os.Stdin)}
+
+	path := cfgFile
+	if path == "" {
+		var err error
+		if path, err = config.DefaultPath(); err != nil {
+			return err
+		}
+	}
+	if !defaults {
+		answer, err := q.ask("Config file", path, nil)
+		if err != nil {
+			return err
+		}
+		path = answer
+	}
+
+	existing, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		existing = nil
+	case err != nil:
+		return fmt.Errorf("failed to read config file: %v", err)
+	case force:
+		logger.Warn("Overwriting %s", path)
+		existing = nil
+	case defaults:
+		return fmt.Errorf("config file %s already exists, pass --force to overwrite it", path)
+	default:
+		add, err := q.confirm(fmt.Sprintf("Config file %s already exists. Add a profile to it?", path))
+		if err != nil {
+			return err
+		}
+		if !add {
+			return fmt.Errorf("config file %s already exists, pass --force to overwrite it", path)
+		}
+	}
+
+	var data []byte
+	if existing != nil {
+		data, err = q.addProfile(existing)
+	} else if defaults {
+		data, err = config.RenderInit(config.DefaultInitSettings())
+	} else {
+		data, err = q.newConfig()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := fileutil.WriteAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	logger.Info("Wrote %s; check it at any time with gta config validate", path)
+	return nil
+}
+
+// initPrompter asks the questions of gta init on the terminal
+type initPrompter struct {
+	in *bufio.Reader
+}
+
+// newConfig asks for the settings of a new config file, checking the file
+// rendered from the answers so far after each one
+func (q *initPrompter) newConfig() ([]byte, error) {
+	s := config.DefaultInitSettings()
+	check := func(set func(string) error) func(string) error {
+		return func(answer string) error {
+			before := s
+			if err := set(answer); err != nil {
+				return err
+			}
+			if _, err := config.RenderInit(s); err != nil {
+				s = before
+				return err
+			}
+			return nil
+		}
+	}
+	questions := []struct {
+		question, def string
+		set           func(string) error
+	}{
+		{"Profile name, empty for none", "", func(v string) error { s.Profile = v; return nil }},
+		{"Default project", "", func(v string) error { s.Project = v; return nil }},
+		{"Default TTL", duration.Format(s.DefaultTTL), durationSetter(&s.DefaultTTL)},
+		{"Maximum TTL, 0 for none", duration.Format(s.MaxTTL), durationSetter(&s.MaxTTL)},
+		{"Log format (plain or json)", s.Format, func(v string) error { s.Format = v; return nil }},
+		{"Notification webhook URL, empty for none", "", func(v string) error { s.WebhookURL = v; return nil }},
+	}
+	for _, question := range questions {
+		if _, err := q.ask(question.question, question.def, check(question.set)); err != nil {
+			return nil, err
+		}
+	}
+	return config.RenderInit(s)
+}
+
+// addProfile asks for a profile to add to existing, the contents of the
+// config file, checking the file with the answers so far after each one
+func (q *initPrompter) addProfile(existing []byte) ([]byte, error) {
+	var s config.InitSettings
+	check := func(set func(string)) func(string) error {
+		return func(answer string) error {
+			before := s
+			set(answer)
+			if _, err := config.AddProfile(existing, s); err != nil {
+				s = before
+				return err
+			}
+			return nil
+		}
+	}
+	questions := []struct {
+		question string
+		set      func(string)
+	}{
+		{"Profile name", func(v string) { s.Profile = v }},
+		{"Project of the profile", func(v string) { s.Project = v }},
+		{"Notification webhook URL of the profile, empty for none", func(v string) { s.WebhookURL = v }},
+	}
+	for _, question := range questions {
+		if _, err := q.ask(question.question, "", check(question.set)); err != nil {
+			return nil, err
+		}
+	}
+	data, err := config.AddProfile(existing, s)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Use the profile with --profile=%s", s.Profile)
+	return data, nil
+}
+
+// ask asks question until check accepts the answer, def when empty
+func (q *initPrompter) ask(question, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: ", question)
+		}
+		line, err := q.in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if check == nil {
+			return answer, nil
+		}
+		problem := check(answer)
+		if problem == nil {
+			return answer, nil
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n", initProblem(problem))
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("no valid answer to %q", question)
+		}
+	}
+}
+
+// confirm asks a yes/no question, defaulting to no
+func (q *initPrompter) confirm(question string) (bool, error) {
+	answer, err := q.ask(question+" [y/N]", "", nil)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// initProblem describes why an answer was refused, listing the problems of
+// the config file it would produce by field rather than by line
+func initProblem(err error) string {
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err.Error()
+	}
+	problems := make([]string, 0, len(validationErr.Problems))
+	for _, p := range validationErr.Problems {
+		if p.Field != "" {
+			problems = append(problems, p.Field+": "+p.Message)
+		} else {
+			problems = append(problems, p.Message)
+		}
+	}
+	return strings.Join(problems, "; ")
+}
+
+// durationSetter parses an answer into d, 0 meaning none
+func durationSetter(d *time.Duration) func(string) error {
+	return func(answer string) error {
+		if answer == "0" {
+			*d = 0
+			return nil
+		}
+		parsed, err := duration.Parse(answer)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/cleaner"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+var installCleanerCmd = &cobra.Command{
+	Use:   "install-cleaner",
+	Short: "Install a scheduled cleanup of expired temporary bindings in a project",
+	Long: `Install a Cloud Scheduler job that runs a Cloud Workflows cleanup daily. The
+workflow is the equivalent of gta clean --expired: it removes every binding
+created by gta whose expiry has passed. It runs as the dedicated service
+account gta-cleaner, which is granted the roles it needs in the project.
+
+Running the command again updates the existing resources. Use --dry-run to see
+exactly what would be created and --uninstall to remove everything.
+
+Example:
+  # Preview the resources
+  gta install-cleaner --project=my-project --dry-run
+
+  # Install or update the cleaner
+  gta install-cleaner --project=my-project --schedule="0 */6 * * *"
+
+  # Remove the cleaner
+  gta install-cleaner --project=my-project --uninstall`,
+	Args: cobra.NoArgs,
+	RunE: runInstallCleaner,
+}
+
+// installCleanerOptions are the options of gta install-cleaner
+type installCleanerOptions struct {
+	commonOptions
+	Location  string
+	Schedule  string
+	TimeZone  string
+	Uninstall bool
+}
+
+func init() {
+	flags := installCleanerCmd.Flags()
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID (required)")
+	flags.String("location", cleaner.DefaultLocation, "Region of the workflow and scheduler job")
+	flags.String("schedule", cleaner.DefaultSchedule, "Cron schedule of the cleanup")
+	flags.String("time-zone", cleaner.DefaultTimeZone, "Time zone of the schedule")
+	flags.Bool("uninstall", false, "Remove the cleaner instead of installing it")
+	flags.BoolP("dry-run", "d", false, "Show the resources that would be changed without applying them")
+}
+
+func runInstallCleaner(cmd *cobra.Command, args []string) error {
+	common, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if err := common.requireProject(); err != nil {
+		return err
+	}
+	o := installCleanerOptions{
+		commonOptions: common,
+		Location:      flagString(cmd, "location"),
+		Schedule:      flagString(cmd, "schedule"),
+		TimeZone:      flagString(cmd, "time-zone"),
+		Uninstall:     flagBool(cmd, "uninstall"),
+	}
+
+	ctx := cmd.Context()
+
+	if o.DryRun {
+		logger.Info("Running in dry-run mode - no changes will be made")
+	}
+
+	opts, err := apiClientOptions(ctx)
+	if err != nil {
+		return err
+	}
+	installer, err := cleaner.NewInstaller(ctx, cleaner.Config{
+		Project:  o.Project,
+		Location: o.Location,
+		Schedule: o.Schedule,
+		TimeZone: o.TimeZone,
+		// Each team's cleaner removes only its own bindings
+		BindingPrefix: cfg.BindingTitlePrefix(),
+	}, o.DryRun, opts...)
+	if err != nil {
+		return err
+	}
+
+	if o.Uninstall {
+		changes, err := installer.Uninstall(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to uninstall cleaner: %v", err)
+		}
+		if len(changes) == 0 {
+			logger.Info("No cleaner installed in project %s", o.Project)
+		}
+		return nil
+	}
+
+	if o.DryRun {
+		logger.Info("Workflow %s source:\n%s", installer.WorkflowName(), installer.WorkflowSource())
+	}
+	if _, err := installer.Install(ctx); err != nil {
+		return fmt.Errorf("failed to install cleaner: %v", err)
+	}
+	if !o.DryRun {
+		logger.Info("Cleaner installed: %s runs %s on schedule %q", installer.JobName(), installer.WorkflowName(), o.Schedule)
+	}
+	return nil
+}
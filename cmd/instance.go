@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// instanceOption returns the resource name of the instance given with
+// --instance as [PROJECT/]ZONE/INSTANCE, setting the project of o from it,
+// or empty when the flag is not set
+func instanceOption(cmd *cobra.Command, o *commonOptions) (string, error) {
+	value := flagString(cmd, "instance")
+	if value == "" {
+		return "", nil
+	}
+	parts := strings.Split(value, "/")
+	switch len(parts) {
+	case 2:
+		parts = append([]string{o.Project}, parts...)
+	case 3:
+		if _, ok := lookupOption(cmd, "project"); ok && o.Project != parts[0] {
+			return "", fmt.Errorf("instance %s is in project %s, not %s", value, parts[0], o.Project)
+		}
+		o.Project = parts[0]
+	default:
+		return "", fmt.Errorf("invalid --instance %q (expected [PROJECT/]ZONE/INSTANCE)", value)
+	}
+	if err := o.requireProject(); err != nil {
+		return "", err
+	}
+	resource := provider.InstanceResource(parts[0], parts[1], parts[2])
+	if _, err := provider.ParseResource(resource); err != nil {
+		return "", fmt.Errorf("invalid --instance %q: %v", value, err)
+	}
+	return resource, nil
+}
+
+// printSSHCommand prints the command connecting to the instance granted on
+// through IAP, if the grant was on an instance
+func printSSHCommand(resource string) {
+	project, zone, instance, ok := provider.ParseInstance(resource)
+	if !ok {
+		return
+	}
+	logger.Info("Connect with: gcloud compute ssh %s --zone=%s --project=%s --tunnel-through-iap", instance, zone, project)
+}
@@ -2,10 +2,22 @@ package cmd
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
 	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/workerpool"
 )
 
 var listCmd = &cobra.Command{
@@ -14,36 +26,406 @@ var listCmd = &cobra.Command{
 	Long: `List temporary IAM role bindings in a project. If a user is specified,
 only bindings for that user will be shown.
 
+With --all-providers, the temporary access of every provider configured for
+the profile is listed in one table; a provider that fails is warned about
+without failing the others.
+
+With --history, the grants and revocations of temporary bindings are read
+from the Cloud Audit Logs of the project instead, covering every machine and
+caller; this needs roles/logging.viewer.
+
+With --session, the bindings of a grant session recorded on this machine are
+listed in every project and resource the session granted on, grouped by
+resource.
+
+Each binding is marked with its ownership: session for those of a session
+running now, exported in GTA_SESSION or attended by its gta grant; mine for
+the others created by the caller, such as the leftovers of previous sessions;
+and others, along with who granted them when known. Bindings recorded in the
+local state are the caller's; the others are told apart by the creator in
+their description, or by their member for bindings of older versions. --mine
+and --others list only the bindings of the caller or of anyone else.
+
 Example:
   gta list --project=my-project
-  gta list --project=my-project --user=user@example.com`,
+  gta list --project=my-project --user=user@example.com
+  gta list --project=my-project --mine
+  gta list --instance=my-project/europe-west1-b/bastion
+  gta list --subnet=projects/host/regions/us-central1/subnetworks/app
+  gta list --project=my-project --all-providers --output=csv
+  gta list --project=my-project --history --since=30d
+  gta list --session=20240101T000000-ab12cd`,
 	RunE: runList,
 }
 
+// Output formats of list
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
 func init() {
 	flags := listCmd.Flags()
-	flags.StringVarP(&project, "project", "p", "", "Project ID")
-	flags.StringVarP(&user, "user", "u", "", "Filter bindings by user")
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID (required)")
+	flags.StringP("user", "u", "", "Filter bindings by user, me, or @NAME of identities in config")
+	flags.StringArray("member", nil, "Filter bindings by exact principal instead of --user, e.g. group:team@example.com (repeatable)")
+	flags.String("instance", "", "List the bindings of this Compute Engine instance and its IAP tunnel, [PROJECT/]ZONE/INSTANCE, instead of the project")
+	flags.String("subnet", "", "List the bindings of this Shared VPC subnetwork, projects/HOST_PROJECT/regions/REGION/subnetworks/SUBNET, instead of the project")
+	flags.Bool("any-prefix", false, "Also list the bindings of the prefixes in known_binding_prefixes and the default prefix")
+	flags.Bool("all-providers", false, "List the temporary access of every provider configured for the profile")
+	flags.Bool("history", false, "List past grants and revocations from the Cloud Audit Logs of the project")
+	flags.String("since", "30d", "How far back --history reads, as a duration such as 30d or 12h, or a date")
+	flags.String("session", "", "List the bindings of this grant session in every project and resource it granted on")
+	flags.StringP("output", "o", "", "Print the access as a table, json, or csv instead of log lines (default table with --all-providers)")
+	flags.Bool("mine", false, "Only list the bindings created by the caller, in a session running now or before")
+	flags.Bool("others", false, "Only list the bindings created by anyone but the caller")
+	listCmd.MarkFlagsMutuallyExclusive("instance", "subnet")
+	listCmd.MarkFlagsMutuallyExclusive("mine", "others")
+	registerMemberCompletion(listCmd)
+	memberFilters[listCmd] = true
+}
 
-	listCmd.MarkFlagRequired("project")
+// listOptions are the options of gta list
+type listOptions struct {
+	commonOptions
+	AnyPrefix bool
+	// Resource is the resource whose bindings are listed instead of those of
+	// the project
+	Resource string
+	// Ownerships restricts the list to the bindings of these ownerships, see
+	// --mine and --others
+	Ownerships []provider.Ownership
 }
 
 func runList(cmd *cobra.Command, args []string) error {
-	ctx := context.Background()
+	common, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return err
+	}
+	o := listOptions{commonOptions: common, AnyPrefix: flagBool(cmd, "any-prefix")}
+	if o.Resource, err = resourceOption(cmd, &o.commonOptions); err != nil {
+		return err
+	}
+	switch {
+	case flagBool(cmd, "mine"):
+		o.Ownerships = []provider.Ownership{provider.OwnedBySession, provider.OwnedByMe}
+	case flagBool(cmd, "others"):
+		o.Ownerships = []provider.Ownership{provider.OwnedByOthers}
+	}
+
+	allProviders := flagBool(cmd, "all-providers")
+	output := flagString(cmd, "output")
+	switch output {
+	case "", outputTable, outputJSON, outputCSV:
+	default:
+		return fmt.Errorf("invalid output format %q (expected table, json, or csv)", output)
+	}
+	if flagBool(cmd, "history") {
+		if allProviders || o.Resource != "" {
+			return fmt.Errorf("--history reads the audit logs of a GCP project and cannot be combined with --all-providers --instance, or --subnet")
+		}
+		if len(o.Ownerships) > 0 {
+			return fmt.Errorf("--mine and --others filter the bindings of policies and cannot be combined with --history")
+		}
+		return runListHistory(cmd, &o, output)
+	}
+	if id := flagString(cmd, "session"); id != "" {
+		if allProviders || o.Resource != "" {
+			return fmt.Errorf("--session cannot be combined with --all-providers, --instance, or --subnet")
+		}
+		if output == "" {
+			output = outputTable
+		}
+		return runListSession(cmd.Context(), &o, id, output)
+	}
+	if allProviders || output != "" {
+		providers := []string{o.Provider}
+		if allProviders {
+			providers = cfg.ProvidersFor(profile)
+		}
+		if output == "" {
+			output = outputTable
+		}
+		access, err := listAccess(cmd.Context(), &o, providers)
+		if err != nil {
+			return err
+		}
+		return writeAccess(os.Stdout, output, access)
+	}
+
+	if err := o.requireProject(); err != nil {
+		return err
+	}
 
-	p, err := provider.NewGCPProvider(ctx, false)
+	ctx := cmd.Context()
+
+	log := useSessionLogger("", o.Project)
+	p, err := newGCPProvider(ctx, false, provider.WithLogger(log))
 	if err != nil {
 		return fmt.Errorf("failed to create GCP provider: %v", err)
 	}
 
 	opts := &provider.GCPOptions{
-		Project: project,
-		User:    user,
+		Project:      o.Project,
+		Resource:     o.Resource,
+		User:         o.User,
+		Members:      o.Members,
+		AnyPrefix:    o.AnyPrefix,
+		KeptSessions: keptSessions(),
+		Owner:        newOwner(p),
+		Ownerships:   o.Ownerships,
+		// Deleted principals are listed, marked as such
+		IncludeDeleted: true,
 	}
 
 	if err := p.ListTemporaryBindings(opts); err != nil {
-		return fmt.Errorf("failed to list temporary bindings: %v", err)
+		return fmt.Errorf("failed to list temporary bindings: %w", err)
 	}
 
 	return nil
 }
+
+// runListSession lists the bindings of a session in each of its targets
+func runListSession(ctx context.Context, o *listOptions, id, output string) error {
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	s, ok := f.Session(id)
+	if !ok {
+		return fmt.Errorf("session %s not found in the local state", id)
+	}
+	if s.Provider != "" && s.Provider != provider.NameGCP {
+		return fmt.Errorf("listing the sessions of %s is not supported", s.Provider)
+	}
+	logger.Info("Session %s: %s", s.ID, s.Status(time.Now()))
+	ids := make(map[string]bool, len(s.Bindings))
+	for _, b := range s.Bindings {
+		ids[b.BindingID] = true
+	}
+
+	p, err := newGCPProvider(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	owner := newOwner(p)
+	var access []provider.TemporaryAccess
+	for _, target := range s.Targets() {
+		opts := &provider.GCPOptions{Project: s.Project, User: o.User, Members: o.Members, AnyPrefix: true, IncludeDeleted: true, Owner: owner, Ownerships: o.Ownerships}
+		if strings.Contains(target, "/") {
+			opts.Resource = target
+		} else {
+			opts.Project = target
+		}
+		found, err := p.ListAccess(opts)
+		if err != nil {
+			logger.Warn("Failed to list the bindings of session %s in %s: %v", id, target, err)
+			continue
+		}
+		for _, a := range found {
+			if ids[a.ID] {
+				access = append(access, a)
+			}
+		}
+	}
+	sort.SliceStable(access, func(i, j int) bool {
+		return access[i].Resource < access[j].Resource
+	})
+	return writeAccess(os.Stdout, output, access)
+}
+
+// keptSessions returns the IDs of the sessions recorded on this machine that
+// were left to expire by their condition, none when the state cannot be read
+func keptSessions() map[string]bool {
+	if passphraseNeeded() {
+		return nil
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return nil
+	}
+	f, err := store.Load()
+	if err != nil {
+		logger.Debug("Not marking unattended sessions: %v", err)
+		return nil
+	}
+	kept := make(map[string]bool)
+	for _, s := range f.Sessions {
+		// Sessions pending revocation are no longer attended either
+		if s.Kept || s.PendingRevocation != nil {
+			kept[s.ID] = true
+		}
+	}
+	return kept
+}
+
+// newOwner returns what the ownership of the bindings listed is told from:
+// the sessions of the local state, falling back to the metadata of the
+// bindings when it cannot be read, and the identities of the caller
+func newOwner(p *provider.GCPProvider) *provider.Owner {
+	owner := &provider.Owner{Current: make(map[string]bool)}
+	for _, id := range strings.Split(os.Getenv(optionEnv("session")), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			owner.Current[id] = true
+		}
+	}
+	if identities, err := p.CallerIdentities(); err != nil {
+		logger.Warn("Cannot tell the bindings of the caller by their creator: %v", err)
+	} else {
+		owner.Identities = identities
+	}
+
+	if passphraseNeeded() {
+		return owner
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return owner
+	}
+	f, err := store.Load()
+	if err != nil {
+		logger.Debug("Telling ownership without the local state: %v", err)
+		return owner
+	}
+	now := time.Now()
+	owner.Sessions = make(map[string]string)
+	for _, s := range f.Sessions {
+		for _, b := range s.Bindings {
+			owner.Sessions[b.BindingID] = s.ID
+		}
+		// Sessions attended by their gta grant are running
+		if !s.Kept && s.PendingRevocation == nil && s.LastExpiry().After(now) {
+			owner.Current[s.ID] = true
+		}
+	}
+	return owner
+}
+
+// accessListers create the lister of each provider along with the options
+// selecting the access of a list
+var accessListers = map[string]func(ctx context.Context, o *listOptions) (provider.AccessLister, provider.Options, error){
+	provider.NameGCP: func(ctx context.Context, o *listOptions) (provider.AccessLister, provider.Options, error) {
+		if err := o.requireProject(); err != nil {
+			return nil, nil, err
+		}
+		log := logger.With(slog.String("project", o.Project), slog.String("provider", provider.NameGCP))
+		p, err := newGCPProvider(ctx, false, provider.WithLogger(log))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		return p, &provider.GCPOptions{Project: o.Project, Resource: o.Resource, User: o.User, Members: o.Members, AnyPrefix: o.AnyPrefix, IncludeDeleted: true, Owner: newOwner(p), Ownerships: o.Ownerships}, nil
+	},
+}
+
+// listAccess lists the temporary access of the providers concurrently,
+// sorted by provider, resource, role, and principal. A provider that fails
+// is warned about; only the failure of every provider is an error.
+func listAccess(ctx context.Context, o *listOptions, providers []string) ([]provider.TemporaryAccess, error) {
+	var (
+		mu     sync.Mutex
+		access []provider.TemporaryAccess
+		failed int
+	)
+	workerpool.Run(ctx, len(providers), providers, func(_ int, name string) {
+		found, err := listProviderAccess(ctx, o, name)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed++
+			logger.Warn("Failed to list the temporary access of %s: %v", name, err)
+			return
+		}
+		access = append(access, found...)
+	})
+	if failed == len(providers) {
+		return nil, fmt.Errorf("failed to list the temporary access of any provider")
+	}
+
+	sort.Slice(access, func(i, j int) bool {
+		a, b := access[i], access[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		return a.Principal < b.Principal
+	})
+	return access, nil
+}
+
+// listProviderAccess lists the temporary access of a single provider
+func listProviderAccess(ctx context.Context, o *listOptions, name string) ([]provider.TemporaryAccess, error) {
+	newLister, ok := accessListers[name]
+	if !ok {
+		return nil, fmt.Errorf("listing is not supported")
+	}
+	lister, opts, err := newLister(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	return lister.ListAccess(opts)
+}
+
+// writeAccess prints temporary access in the given output format
+func writeAccess(w io.Writer, format string, access []provider.TemporaryAccess) error {
+	switch format {
+	case outputJSON:
+		if access == nil {
+			access = []provider.TemporaryAccess{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(access)
+	case outputCSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"provider", "resource", "role", "principal", "expiry", "id", "granted_by", "ownership"})
+		for _, a := range access {
+			writer.Write([]string{a.Provider, a.Resource, a.Role, a.Principal, a.Expiry.Format(time.RFC3339), a.ID, a.GrantedBy, string(a.Ownership)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		if len(access) == 0 {
+			logger.Info("No temporary access found")
+			return nil
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROVIDER\tRESOURCE\tROLE\tPRINCIPAL\tEXPIRY\tID\tGRANTED BY\tOWNERSHIP")
+		now := time.Now()
+		for _, a := range access {
+			expiry := a.Expiry.Format(time.RFC3339)
+			if a.Expiry.Before(now) {
+				expiry += " (expired)"
+			}
+			grantedBy := a.GrantedBy
+			if grantedBy == "" {
+				grantedBy = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Provider, a.Resource, a.Role, provider.DescribeMember(a.Principal), expiry, a.ID, grantedBy, describeOwnership(a))
+		}
+		return tw.Flush()
+	}
+}
+
+// describeOwnership describes the ownership of access for tables, naming who
+// granted the access of others when it is known
+func describeOwnership(a provider.TemporaryAccess) string {
+	switch {
+	case a.Ownership == "":
+		return "-"
+	case a.Ownership == provider.OwnedByOthers && a.CreatedBy != "":
+		return fmt.Sprintf("%s (%s)", a.Ownership, a.CreatedBy)
+	default:
+		return string(a.Ownership)
+	}
+}
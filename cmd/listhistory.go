@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// runListHistory prints the timeline of temporary bindings read from the
+// Cloud Audit Logs of the project
+func runListHistory(cmd *cobra.Command, o *listOptions, output string) error {
+	if err := o.requireProject(); err != nil {
+		return err
+	}
+	since, err := parseSince(stringOption(cmd, "since", ""), time.Now())
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = outputTable
+	}
+
+	log := useSessionLogger("", o.Project)
+	p, err := newGCPProvider(cmd.Context(), false, provider.WithLogger(log))
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	opts := &provider.GCPOptions{
+		Project:   o.Project,
+		User:      o.User,
+		Members:   o.Members,
+		AnyPrefix: o.AnyPrefix,
+	}
+	events, err := p.BindingHistory(opts, since)
+	if err != nil {
+		return fmt.Errorf("failed to read the binding history: %w", err)
+	}
+	return writeHistory(os.Stdout, output, events)
+}
+
+// parseSince parses the start of --since: a duration before now, which may
+// be given in days or weeks such as 30d or 2w, or a date such as 2024-05-14
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := duration.Parse(value); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (expected a duration such as 30d or 12h, or a date such as 2024-05-14)", value)
+}
+
+// writeHistory prints binding history events in the given output format
+func writeHistory(w io.Writer, format string, events []provider.HistoryEvent) error {
+	switch format {
+	case outputJSON:
+		if events == nil {
+			events = []provider.HistoryEvent{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(events)
+	case outputCSV:
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"time", "action", "caller", "resource", "role", "member", "expiry", "id"})
+		for _, e := range events {
+			writer.Write([]string{e.Time.Format(time.RFC3339), string(e.Action), e.Caller, e.Resource, e.Role, e.Member, formatExpiry(e.Expiry), e.BindingID})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		if len(events) == 0 {
+			logger.Info("No grants or revocations of temporary bindings found")
+			return nil
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tACTION\tCALLER\tRESOURCE\tROLE\tMEMBER\tEXPIRY\tID")
+		for _, e := range events {
+			expiry := formatExpiry(e.Expiry)
+			if expiry == "" {
+				expiry = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.RFC3339), e.Action, e.Caller, e.Resource, e.Role, e.Member, expiry, e.BindingID)
+		}
+		return tw.Flush()
+	}
+}
+
+// formatExpiry formats an expiry, empty when unknown
+func formatExpiry(expiry time.Time) string {
+	if expiry.IsZero() {
+		return ""
+	}
+	return expiry.Format(time.RFC3339)
+}
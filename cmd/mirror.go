@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// applyMirror sets the roles, and the targets of the roles held on related
+// resources, to those of the temporary bindings the principal of --mirror
+// holds on the project or resource, and asks for confirmation unless --yes.
+// The bindings of the principal are only read.
+func (o *grantOptions) applyMirror(cmd *cobra.Command) error {
+	source, err := expandMember(o.Mirror)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(source, ":") {
+		source = "user:" + source
+	}
+	if err := provider.ValidateMember(source); err != nil {
+		return fmt.Errorf("invalid --mirror %q: %v", o.Mirror, err)
+	}
+	o.Mirror = source
+
+	p, err := newGCPProvider(cmd.Context(), true, provider.WithServiceAccountCaller(o.AllowServiceAccountCaller))
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	target := o.Project
+	if o.Resource != "" {
+		target = o.Resource
+	}
+	bindings, err := p.TemporaryBindings(&provider.GCPOptions{Project: o.Project, Resource: o.Resource, Members: []string{source}})
+	if err != nil {
+		return fmt.Errorf("failed to list the bindings of %s: %w", source, err)
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var mirrored []provider.TemporaryBinding
+	var expired int
+	for _, b := range bindings {
+		if !o.MirrorExpired && !b.Expiry.IsZero() && b.Expiry.Before(now) {
+			expired++
+			continue
+		}
+		key := b.Resource + "\x00" + b.Role
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		mirrored = append(mirrored, b)
+	}
+	if expired > 0 {
+		logger.Info("Leaving out %d expired binding(s) of %s, include them with --mirror-expired", expired, source)
+	}
+	if len(mirrored) == 0 {
+		return fmt.Errorf("%s holds no temporary binding on %s to mirror", source, target)
+	}
+
+	logger.Info("Mirroring the temporary roles of %s on %s with a new TTL:", source, target)
+	targets := make(map[string]int)
+	o.Roles, o.Targets = nil, nil
+	for _, b := range mirrored {
+		logger.Info("  %s on %s, expiring %s", b.Role, b.Resource, formatExpiry(b.Expiry))
+		// Roles granted on target land where the source holds them, such
+		// as on the IAP tunnel resource of an instance
+		if b.Resource == provider.RoleResource(target, b.Role) {
+			o.Roles = append(o.Roles, b.Role)
+			continue
+		}
+		resource := policyTarget(b.Resource)
+		if i, ok := targets[resource]; ok {
+			o.Targets[i].Roles = append(o.Targets[i].Roles, b.Role)
+			continue
+		}
+		targets[resource] = len(o.Targets)
+		o.Targets = append(o.Targets, provider.GrantTarget{Resource: resource, Roles: []string{b.Role}})
+	}
+
+	if o.Yes || o.DryRun {
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("mirroring the roles of %s needs confirmation in a terminal, or --yes", source)
+	}
+	confirmed, err := confirmStdin("Grant these roles?")
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("grant aborted")
+	}
+	return nil
+}
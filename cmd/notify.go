@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/notify"
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Inspect the delivery of notifications",
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Show which notification routes a sample event would fire",
+	Long: `Build the notification of a sample event and show, for every route of the
+active profile, whether it would deliver it and why not. The routes of the
+environment of the project, and of break-glass access with --break-glass,
+are included. The environment is resolved as gta grant does unless
+--environment is given.
+
+With --send, the sample notification is delivered along the routes that fire.
+
+Example:
+  gta notify test --event grant --project prod-x
+  gta notify test --event revoke --project prod-x --role roles/owner
+  gta notify test --event grant --project prod-x --break-glass --send`,
+	Args: cobra.NoArgs,
+	RunE: runNotifyTest,
+}
+
+// routeSource is a route with the notifications block it comes from
+type routeSource struct {
+	route notify.Route
+	block string
+}
+
+func init() {
+	flags := notifyTestCmd.Flags()
+	flags.String("event", string(audit.ActionGrant), "Action of the sample event, e.g. grant, revoke, clean, expiring, or modified")
+	flags.StringP("project", "p", "", "Project of the sample event")
+	flags.String("environment", "", "Environment of the project (default: resolved from the config and labels)")
+	flags.StringSliceP("role", "r", []string{"roles/viewer"}, "Roles of the sample event")
+	flags.Bool("break-glass", false, "Make the sample event break-glass access")
+	flags.Bool("send", false, "Deliver the sample notification along the routes that fire")
+	notifyCmd.AddCommand(notifyTestCmd)
+	rootCmd.AddCommand(notifyCmd)
+}
+
+func runNotifyTest(cmd *cobra.Command, args []string) error {
+	project := stringOption(cmd, "project", cfg.ProjectFor(profile))
+	if project == "" {
+		return fmt.Errorf("project is required: use --project or set project in config")
+	}
+	action := audit.Action(flagString(cmd, "event"))
+	if !slices.Contains(notify.Actions, action) {
+		return fmt.Errorf("unknown event %q (expected one of %v)", action, notify.Actions)
+	}
+	roles, _ := cmd.Flags().GetStringSlice("role")
+	breakGlass := flagBool(cmd, "break-glass")
+
+	environment := flagString(cmd, "environment")
+	if _, set := lookupOption(cmd, "environment"); !set {
+		var err error
+		environment, err = cfg.EnvironmentFor(project, func() (map[string]string, error) {
+			p, err := newGCPProvider(cmd.Context(), true)
+			if err != nil {
+				return nil, err
+			}
+			return p.ProjectLabels(project)
+		})
+		if err != nil {
+			logger.Warn("Failed to read the labels of project %s, treating it as environment %s: %v", project, environment, err)
+		}
+	}
+
+	now := time.Now()
+	n := notify.Notification{
+		Action:      action,
+		Project:     project,
+		Environment: environment,
+		Roles:       roles,
+		Member:      "user:test@example.com",
+		TTL:         time.Hour,
+		Expiry:      now.Add(time.Hour),
+		Reason:      "gta notify test",
+		SessionID:   "test",
+		Caller:      "test@example.com",
+		BreakGlass:  breakGlass,
+	}
+	if breakGlass {
+		n.Incident = "INC-0000"
+	}
+
+	sources, err := notificationRoutes(environment, breakGlass)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no notification is configured")
+	}
+
+	logger.Info("Sample %s notification of severity %s in project %s (environment %s)", action, n.Severity(), project, orDash(environment))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tNOTIFIER\tBLOCK\tFIRES\tREASON")
+	var firing []notify.Route
+	for _, s := range sources {
+		fires, reason := "yes", s.route.Filter.Mismatch(n)
+		if reason != "" {
+			fires = "no"
+		} else {
+			firing = append(firing, s.route)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.route, s.route.Notifier.Name(), s.block, fires, orDash(reason))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if !flagBool(cmd, "send") || len(firing) == 0 {
+		return nil
+	}
+	d := notify.NewDispatcher(firing...)
+	d.Send(n)
+	if err := d.Close(); err != nil {
+		return err
+	}
+	logger.Info("Sent the sample notification along %d route(s); failures are logged above", len(firing))
+	return nil
+}
+
+// notificationRoutes returns the routes a notification of the active profile
+// in environment is delivered along, including those of break-glass access
+// when breakGlass is set
+func notificationRoutes(environment string, breakGlass bool) ([]routeSource, error) {
+	type block struct {
+		name     string
+		settings config.NotificationsConfig
+	}
+	name := "notifications"
+	if p, ok := cfg.Profiles[profile]; ok && p.Notifications != nil {
+		name = fmt.Sprintf("profiles.%s.notifications", profile)
+	}
+	blocks := []block{{name, cfg.NotificationsFor(profile)}}
+	if breakGlass && cfg.BreakGlass.Notifications != nil {
+		blocks = append(blocks, block{"break_glass.notifications", *cfg.BreakGlass.Notifications})
+	}
+	if class, ok := cfg.Environment(environment); ok && class.Notifications != nil {
+		blocks = append(blocks, block{fmt.Sprintf("environment %s", environment), *class.Notifications})
+	}
+
+	var sources []routeSource
+	for _, b := range blocks {
+		routes, err := newRoutes(b.settings)
+		if err != nil {
+			return nil, err
+		}
+		for _, route := range routes {
+			sources = append(sources, routeSource{route: route, block: b.name})
+		}
+	}
+	return sources, nil
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// envPrefix prefixes the environment variables read for flags that are not
+// set, e.g. GTA_PROJECT for --project
+const envPrefix = "GTA_"
+
+// commonOptions are the options shared by the commands acting on a project.
+// Each command registers the flags it supports on its own flag set and
+// resolves them in its RunE, so no value leaks from one command to another.
+type commonOptions struct {
+	// Provider is the provider the command acts on, set with --provider or
+	// detected from the other flags and arguments
+	Provider string
+	Project  string
+	User     string
+	// Members are exact principals given instead of User
+	Members []string
+	TTL     time.Duration
+	Reason  string
+	DryRun  bool
+	// AllowServiceAccountCaller allows modifying policies with service
+	// account credentials
+	AllowServiceAccountCaller bool
+}
+
+// resolveCommonOptions reads the common options from the flags of cmd. A flag
+// that is not set falls back to its GTA_ environment variable, then to the
+// active profile or the config file where they have a setting, and then to
+// the flag default. Options whose flag cmd does not define are left zero.
+func resolveCommonOptions(cmd *cobra.Command) (commonOptions, error) {
+	opts := commonOptions{
+		Project: stringOption(cmd, "project", cfg.ProjectFor(profile)),
+		User:    stringOption(cmd, "user", ""),
+		Reason:  stringOption(cmd, "reason", ""),
+	}
+
+	var err error
+	if opts.Provider, err = providerOption(cmd); err != nil {
+		return opts, err
+	}
+	if opts.Members, err = membersOption(cmd); err != nil {
+		return opts, err
+	}
+	if opts.User != "" && len(opts.Members) > 0 {
+		return opts, fmt.Errorf("--user and --member are mutually exclusive")
+	}
+	if opts.User != "" {
+		if opts.User, opts.Members, err = resolveUser(cmd.Context(), opts.User); err != nil {
+			return opts, err
+		}
+		for _, member := range opts.Members {
+			if err := provider.ValidateMember(member); err != nil {
+				return opts, err
+			}
+		}
+	}
+	if opts.TTL, err = durationOption(cmd, "ttl", time.Duration(cfg.DefaultTTL)); err != nil {
+		return opts, err
+	}
+	if opts.DryRun, err = boolOption(cmd, "dry-run"); err != nil {
+		return opts, err
+	}
+	if opts.AllowServiceAccountCaller, err = boolOption(cmd, "allow-service-account-caller"); err != nil {
+		return opts, err
+	}
+	opts.AllowServiceAccountCaller = opts.AllowServiceAccountCaller || cfg.AllowServiceAccountCaller
+	return opts, nil
+}
+
+// requireProject fails when no project was resolved
+func (o commonOptions) requireProject() error {
+	if o.Project == "" {
+		return fmt.Errorf("a project is required: set --project, %sPROJECT, or project in the config file", envPrefix)
+	}
+	return nil
+}
+
+// lookupOption returns the value of flag name when it is set on the command
+// line or through its environment variable
+func lookupOption(cmd *cobra.Command, name string) (string, bool) {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		return "", false
+	}
+	if flag.Changed {
+		return flag.Value.String(), true
+	}
+	if value := os.Getenv(optionEnv(name)); value != "" {
+		return value, true
+	}
+	return "", false
+}
+
+// optionEnv returns the environment variable of flag name
+func optionEnv(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// stringOption resolves a string flag, using fallback when it is not set and
+// fallback is not empty
+func stringOption(cmd *cobra.Command, name, fallback string) string {
+	if value, ok := lookupOption(cmd, name); ok {
+		return value
+	}
+	if fallback != "" {
+		return fallback
+	}
+	value, _ := cmd.Flags().GetString(name)
+	return value
+}
+
+// durationOption resolves a duration flag, using fallback when it is not set
+// and fallback is not zero
+func durationOption(cmd *cobra.Command, name string, fallback time.Duration) (time.Duration, error) {
+	if value, ok := lookupOption(cmd, name); ok {
+		d, err := duration.Parse(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %v", optionEnv(name), err)
+		}
+		return d, nil
+	}
+	if fallback > 0 {
+		return fallback, nil
+	}
+	return flagDuration(cmd, name), nil
+}
+
+// membersOption resolves the repeatable --member flag, whose environment
+// variable takes a comma-separated list, expands the identities of the
+// config, and validates each principal
+func membersOption(cmd *cobra.Command) ([]string, error) {
+	flag := cmd.Flags().Lookup("member")
+	if flag == nil {
+		return nil, nil
+	}
+	var members []string
+	if flag.Changed {
+		members, _ = cmd.Flags().GetStringArray("member")
+	} else if value := os.Getenv(optionEnv("member")); value != "" {
+		for _, member := range strings.Split(value, ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				members = append(members, member)
+			}
+		}
+	}
+	for i, member := range members {
+		expanded, err := expandMember(member)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateMemberOption(cmd, expanded); err != nil {
+			return nil, err
+		}
+		members[i] = expanded
+	}
+	return members, nil
+}
+
+// memberFilters are the commands whose --member selects existing bindings
+// rather than principals to grant to
+var memberFilters = make(map[*cobra.Command]bool)
+
+// validateMemberOption checks a principal given to --member. The commands of
+// memberFilters also accept the members of deleted principals verbatim, e.g.
+// deleted:user:alice@example.com?uid=123456789.
+func validateMemberOption(cmd *cobra.Command, member string) error {
+	if principal, _, ok := provider.ParseDeletedMember(member); ok && memberFilters[cmd] {
+		return provider.ValidateMember(principal)
+	}
+	return provider.ValidateMember(member)
+}
+
+// boolOption resolves a bool flag
+func boolOption(cmd *cobra.Command, name string) (bool, error) {
+	value, ok := lookupOption(cmd, name)
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %v", optionEnv(name), value, err)
+	}
+	return b, nil
+}
+
+// flagBool returns the value of a bool flag of cmd, false when cmd has no
+// such flag
+func flagBool(cmd *cobra.Command, name string) bool {
+	value, _ := cmd.Flags().GetBool(name)
+	return value
+}
+
+// flagString returns the value of a string flag of cmd, empty when cmd has no
+// such flag
+func flagString(cmd *cobra.Command, name string) string {
+	value, _ := cmd.Flags().GetString(name)
+	return value
+}
+
+// flagStringArray returns the values of a string array flag of cmd, nil when
+// cmd has no such flag
+func flagStringArray(cmd *cobra.Command, name string) []string {
+	values, _ := cmd.Flags().GetStringArray(name)
+	return values
+}
+
+// flagStringSlice returns the values of a string slice flag of cmd, nil when
+// cmd has no such flag
+func flagStringSlice(cmd *cobra.Command, name string) []string {
+	values, _ := cmd.Flags().GetStringSlice(name)
+	return values
+}
+
+// flagDuration returns the value of a duration flag of cmd added with
+// addDurationFlag, 0 when cmd has no such flag
+func flagDuration(cmd *cobra.Command, name string) time.Duration {
+	flag := cmd.Flags().Lookup(name)
+	if flag == nil {
+		return 0
+	}
+	if v, ok := flag.Value.(*duration.Value); ok {
+		return time.Duration(*v)
+	}
+	return 0
+}
+
+// addDurationFlag adds a duration flag to cmd accepting days and weeks, such
+// as 2d or 1w, along with the units of time.ParseDuration
+func addDurationFlag(cmd *cobra.Command, name, shorthand string, value time.Duration, usage string) {
+	v := duration.Value(value)
+	cmd.Flags().VarP(&v, name, shorthand, usage)
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/yckao/gta/pkg/config"
+)
+
+// useConfig parses data as the config file, restoring the configuration
+// when the test ends
+func useConfig(t *testing.T, data string) {
+	t.Helper()
+	c, err := config.Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := cfg
+	cfg = c
+	t.Cleanup(func() { cfg = saved })
+}
+
+// optionsCommand returns a command with the common flags whose RunE stores
+// the resolved options in opts
+func optionsCommand(opts *commonOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "test",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			*opts, err = resolveCommonOptions(cmd)
+			return err
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	flags := cmd.Flags()
+	flags.StringP("project", "p", "", "")
+	flags.StringP("user", "u", "", "")
+	flags.StringArray("member", nil, "")
+	flags.String("reason", "", "")
+	flags.Bool("dry-run", false, "")
+	addDurationFlag(cmd, "ttl", "t", time.Hour, "")
+	return cmd
+}
+
+func TestResolveCommonOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		profile string
+		env     map[string]string
+		args    []string
+		want    commonOptions
+		wantErr string
+	}{
+		{
+			name: "flag defaults",
+			want: commonOptions{TTL: time.Hour},
+		},
+		{
+			name:   "config file",
+			config: "project: from-config\ndefault_ttl: 2h\n",
+			want:   commonOptions{Project: "from-config", TTL: 2 * time.Hour},
+		},
+		{
+			name:   "environment over config",
+			config: "project: from-config\ndefault_ttl: 2h\n",
+			env:    map[string]string{"GTA_PROJECT": "from-env", "GTA_TTL": "30m", "GTA_DRY_RUN": "true"},
+			want:   commonOptions{Project: "from-env", TTL: 30 * time.Minute, DryRun: true},
+		},
+		{
+			name:   "flags over environment",
+			config: "project: from-config\n",
+			env:    map[string]string{"GTA_PROJECT": "from-env", "GTA_TTL": "30m"},
+			args:   []string{"--project=from-flag", "-t", "1d", "--reason", "INC-1"},
+			want:   commonOptions{Project: "from-flag", TTL: 24 * time.Hour, Reason: "INC-1"},
+		},
+		{
+			name:    "profile",
+			config:  "project: from-config\nprofiles:\n  prod:\n    project: from-profile\n",
+			profile: "prod",
+			want:    commonOptions{Project: "from-profile", TTL: time.Hour},
+		},
+		{
+			name: "members from the environment",
+			env:  map[string]string{"GTA_MEMBER": "user:a@example.com, group:team@example.com"},
+			want: commonOptions{Members: []string{"user:a@example.com", "group:team@example.com"}, TTL: time.Hour},
+		},
+		{
+			name:    "user and member",
+			args:    []string{"--user=a@example.com", "--member=user:b@example.com"},
+			wantErr: "--user and --member are mutually exclusive",
+		},
+		{
+			name:    "invalid member",
+			args:    []string{"--member=alice"},
+			wantErr: "alice",
+		},
+		{
+			name:    "invalid TTL in the environment",
+			env:     map[string]string{"GTA_TTL": "soon"},
+			wantErr: "invalid GTA_TTL",
+		},
+		{
+			name:    "invalid bool in the environment",
+			env:     map[string]string{"GTA_DRY_RUN": "maybe"},
+			wantErr: "invalid GTA_DRY_RUN",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, tc.config)
+			saved := profile
+			t.Cleanup(func() { profile = saved })
+			profile = tc.profile
+			for _, name := range []string{"GTA_PROJECT", "GTA_USER", "GTA_MEMBER", "GTA_TTL", "GTA_DRY_RUN", "GTA_REASON"} {
+				t.Setenv(name, tc.env[name])
+			}
+
+			var opts commonOptions
+			cmd := optionsCommand(&opts)
+			cmd.SetArgs(tc.args)
+			_, err := cmd.ExecuteC()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Project != tc.want.Project || opts.TTL != tc.want.TTL || opts.DryRun != tc.want.DryRun ||
+				opts.Reason != tc.want.Reason || strings.Join(opts.Members, ",") != strings.Join(tc.want.Members, ",") {
+				t.Errorf("options = %+v, want %+v", opts, tc.want)
+			}
+		})
+	}
+}
+
+// TestCommandsOwnTheirFlags checks no two commands share the variable of a
+// flag, so that a value given to one never shows up in another
+func TestCommandsOwnTheirFlags(t *testing.T) {
+	owners := make(map[pflag.Value]string)
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		// The shells of the completion command cobra adds share
+		// --no-descriptions on purpose
+		if cmd.Name() == "completion" {
+			return
+		}
+		cmd.LocalNonPersistentFlags().VisitAll(func(f *pflag.Flag) {
+			if owner, ok := owners[f.Value]; ok && owner != cmd.CommandPath() {
+				t.Errorf("--%s of %s shares its variable with %s", f.Name, cmd.CommandPath(), owner)
+			}
+			owners[f.Value] = cmd.CommandPath()
+		})
+		for _, child := range cmd.Commands() {
+			visit(child)
+		}
+	}
+	visit(rootCmd)
+}
+
+// execute runs gta with args and an empty config file, resetting the flags
+// of every command afterwards
+func execute(t *testing.T, args ...string) (*cobra.Command, string, error) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "data"))
+	for _, name := range []string{"GTA_PROJECT", "GTA_USER", "GTA_MEMBER", "GTA_TTL", "GTA_DRY_RUN", "GTA_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	configPath := filepath.Join(home, "gta.yaml")
+	if err := os.WriteFile(configPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	savedCfg, savedProfile := cfg, profile
+	t.Cleanup(func() {
+		cfg, profile, cfgFile = savedCfg, savedProfile, ""
+		resetFlags(rootCmd)
+	})
+
+	var out bytes.Buffer
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(&out)
+	rootCmd.SetArgs(append([]string{"--config", configPath}, args...))
+	cmd, err := rootCmd.ExecuteC()
+	return cmd, out.String(), err
+}
+
+// resetFlags sets every flag of cmd and its subcommands back to its default
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, child := range cmd.Commands() {
+		resetFlags(child)
+	}
+}
+
+func TestExecuteRejectsInvalidOptions(t *testing.T) {
+	for _, tc := range []struct {
+		args    []string
+		command string
+		wantErr string
+	}{
+		{[]string{"grant", "viewer"}, "grant", "a project is required"},
+		{[]string{"grant", "-p", "p", "--user=a@example.com", "--member=user:b@example.com", "viewer"}, "grant", "mutually exclusive"},
+		{[]string{"grant", "-p", "p", "--ttl=soon", "viewer"}, "grant", "soon"},
+		{[]string{"clean"}, "clean", "a project is required"},
+		{[]string{"list", "--user=a@example.com", "--member=user:b@example.com"}, "list", "mutually exclusive"},
+		{[]string{"grant", "--no-such-flag"}, "grant", "unknown flag"},
+		{[]string{"grant", "-p", "p", "arn:aws:iam::1:role/admin"}, "grant", "ambiguous provider"},
+		{[]string{"list", "--provider=aws", "-p", "p"}, "list", `provider "aws" is not supported`},
+		{[]string{"grant", "-p", "other-proj", "--from-url", "https://console.cloud.google.com/storage/browser/b-1?project=my-proj", "viewer"}, "grant", "the console URL is in project my-proj, not other-proj"},
+		{[]string{"grant", "--from-url", "https://example.com/storage/browser/b-1", "viewer"}, "grant", "not a Google Cloud console URL"},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cmd, _, err := execute(t, tc.args...)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tc.wantErr)
+			}
+			if cmd.Name() != tc.command {
+				t.Errorf("ran %s, want %s", cmd.Name(), tc.command)
+			}
+		})
+	}
+}
+
+// TestExecuteDoesNotLeakFlags runs a command with --project, then another
+// without, checking the second does not see the project of the first
+func TestExecuteDoesNotLeakFlags(t *testing.T) {
+	if _, _, err := execute(t, "grant", "-p", "first", "--user=a@example.com", "--member=user:b@example.com", "viewer"); err == nil {
+		t.Fatal("first run succeeded")
+	}
+	_, _, err := execute(t, "clean")
+	if err == nil || !strings.Contains(err.Error(), "a project is required") {
+		t.Errorf("second run = %v, want no project", err)
+	}
+}
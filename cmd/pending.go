@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// pendingRevocationTimeout bounds the retries of pending revocations made by
+// a command, so that an unreachable API delays its exit by that much at most
+const pendingRevocationTimeout = 30 * time.Second
+
+// skipPendingRevocations are the commands that do not retry pending
+// revocations, as they call no API or revoke sessions themselves
+var skipPendingRevocations = map[string]bool{
+	"completion":                    true,
+	cobra.ShellCompRequestCmd:       true,
+	cobra.ShellCompNoDescRequestCmd: true,
+	"help":                          true,
+	"revoke":                        true,
+	"shell-init":                    true,
+	"version":                       true,
+}
+
+// pendingRevocations waits for the retries of pending revocations running in
+// the background
+var pendingRevocations sync.WaitGroup
+
+// markRevokePending records in the state that the revocation of session id
+// failed with err, for later commands to retry it. Only the bindings whose
+// roles failed are kept when err tells them.
+func markRevokePending(id string, err error) {
+	store, storeErr := newStateStore()
+	if storeErr != nil {
+		logger.Warn("Failed to update the session state: %v", storeErr)
+		return
+	}
+	now := time.Now()
+	storeErr = store.Update(func(f *state.File) error {
+		s, ok := f.Session(id)
+		if !ok {
+			return nil
+		}
+		keepFailedBindings(s, err)
+		if s.PendingRevocation == nil {
+			s.PendingRevocation = &state.Revocation{}
+		}
+		s.PendingRevocation.Claim(now)
+		s.PendingRevocation.Failed(err, now)
+		return nil
+	})
+	if storeErr != nil {
+		logger.Warn("Failed to update the session state: %v", storeErr)
+		return
+	}
+	logger.Warn("Session %s is pending revocation, later gta commands retry it", id)
+}
+
+// keepFailedBindings removes from s the bindings revoked by a revocation that
+// failed with err, keeping those of the roles that failed. All are kept when
+// err does not tell which roles failed, e.g. when no API could be called.
+func keepFailedBindings(s *state.Session, err error) {
+	failed := provider.FailedRoles(err)
+	if len(failed) == 0 {
+		return
+	}
+	var kept []state.Binding
+	for _, b := range s.Bindings {
+		for _, roleErr := range failed {
+			if roleErr.Role == b.Role && roleErr.BindingID == b.BindingID {
+				kept = append(kept, b)
+				break
+			}
+		}
+	}
+	if len(kept) > 0 {
+		s.Bindings = kept
+	}
+}
+
+// retryPendingRevocations retries in the background the revocations of the
+// sessions pending revocation that are due, while cmd runs. Like the pruning
+// of sessions it reports failures at debug level only. The retries deliver
+// their events to sinks of their own, so that they neither share the sinks of
+// cmd, which may be created meanwhile, nor flush its notifications.
+func retryPendingRevocations(cmd *cobra.Command) {
+	if skipPendingRevocations[cmd.Name()] || passphraseNeeded() {
+		return
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(store.Path()); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	// Claiming the sessions first keeps other gta processes off them until
+	// their next attempt is due
+	now := time.Now()
+	var claimed []state.Session
+	err = store.Update(func(f *state.File) error {
+		for _, pending := range f.PendingRevocations(now) {
+			s, _ := f.Session(pending.ID)
+			s.PendingRevocation.Claim(now)
+			claimed = append(claimed, *s)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Debug("Skipping the pending revocations: %v", err)
+		return
+	}
+	if len(claimed) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pendingRevocationTimeout)
+	pendingRevocations.Add(1)
+	go func() {
+		defer pendingRevocations.Done()
+		defer cancel()
+		events, err := newEventSinks(ctx, false)
+		if err != nil {
+			logger.Debug("Skipping the pending revocations: %v", err)
+			return
+		}
+		defer func() {
+			if err := events.sink.Close(); err != nil {
+				logger.Debug("Failed to flush the events of the pending revocations: %v", err)
+			}
+		}()
+		for _, s := range claimed {
+			if ctx.Err() != nil {
+				return
+			}
+			retryRevocation(ctx, s, events)
+		}
+	}()
+}
+
+// retryRevocation retries the revocation of a session pending revocation. A
+// session whose bindings expired meanwhile is only forgotten, and bindings
+// removed meanwhile, e.g. by gta clean, are not found and left alone.
+func retryRevocation(ctx context.Context, s state.Session, events eventSinks) {
+	if !s.LastExpiry().After(time.Now()) {
+		logger.Debug("Forgetting session %s pending revocation, its bindings expired", s.ID)
+		forgetSession(s.ID)
+		return
+	}
+	logger.Debug("Retrying the revocation of session %s, attempt %d of %d", s.ID, s.PendingRevocation.Attempts, state.MaxRevokeAttempts)
+	err := sessionRevocation(ctx, s, false, events)()
+	if err == nil {
+		logger.Info("Revoked session %s, pending revocation since %s", s.ID, s.PendingRevocation.LastAttempt.Local().Format(time.RFC3339))
+		forgetSession(s.ID)
+		return
+	}
+
+	store, storeErr := newStateStore()
+	if storeErr != nil {
+		logger.Debug("Failed to update the session state: %v", storeErr)
+		return
+	}
+	storeErr = store.Update(func(f *state.File) error {
+		if current, ok := f.Session(s.ID); ok && current.PendingRevocation != nil {
+			keepFailedBindings(current, err)
+			current.PendingRevocation.Failed(err, time.Now())
+		}
+		return nil
+	})
+	if storeErr != nil {
+		logger.Debug("Failed to update the session state: %v", storeErr)
+	}
+	if s.PendingRevocation.Exhausted() {
+		logger.Warn("Gave up retrying the revocation of session %s after %d attempts: %v", s.ID, s.PendingRevocation.Attempts, withReauthHint(err))
+		logger.Warn("Revoke it with: gta revoke --from-state --session=%s", s.ID)
+		return
+	}
+	logger.Debug("Failed to revoke session %s pending revocation: %v", s.ID, err)
+}
+
+// waitPendingRevocations waits for the retries of pending revocations
+// started by the command before gta exits
+func waitPendingRevocations() {
+	pendingRevocations.Wait()
+}
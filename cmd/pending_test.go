@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// TestPendingRevocationsAlongsideBreakGlass runs the retry of a pending
+// revocation while a break-glass grant creates the sinks of the command, as
+// grant --break-glass does, and is meant to be run with -race too
+func TestPendingRevocationsAlongsideBreakGlass(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		posted = append(posted, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	store := useState(t, "s1")
+	err := store.Update(func(f *state.File) error {
+		s, _ := f.Session("s1")
+		s.Kept, s.PendingRevocation = true, &state.Revocation{}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, fmt.Sprintf("audit:\n  disabled: true\nbreak_glass:\n  notifications:\n    slack:\n      webhook_url: %s\n", srv.URL))
+	// The retry fails without credentials rather than calling the API
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	t.Cleanup(func() {
+		eventSink, dispatcher, eventWebhook, breakGlassRouted = nil, nil, nil, false
+	})
+
+	retryPendingRevocations(grantCmd)
+	sink, err := newEventSink(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := audit.NewEvent(audit.ActionGrant)
+	event.Project, event.Role, event.SessionID = "p", "roles/viewer", "s2"
+	event.BreakGlass, event.Incident = true, "INC-1"
+	sink.Emit(event)
+	flushNotifications()
+	waitPendingRevocations()
+	closeEventSink()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 1 || !strings.Contains(posted[0], "INC-1") {
+		t.Errorf("posted %q, want the break-glass notification", posted)
+	}
+	f, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := f.Session("s1")
+	if !ok || s.PendingRevocation == nil || s.PendingRevocation.Attempts != 1 || s.PendingRevocation.LastError == "" {
+		t.Errorf("session %+v, want its retry recorded", s)
+	}
+}
@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/plan"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/version"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan [roles...]",
+	Short: "Write the bindings a grant would add to a plan file for review",
+	Long: `Validate a grant as gta grant does, read the policies it would change, and
+write the bindings it would add to a JSON plan file: the resolved members,
+roles, resources, and expiries, along with the etags of the policies. The
+plan holds no credentials, and its fields are sorted so that the plans of
+successive revisions of a change can be diffed.
+
+gta apply --plan executes the plan, refusing once the policies changed or the
+plan is older than plan.max_age in config, an hour by default.
+
+Example:
+  gta plan roles/cloudsql.admin -p my-project -u alice@example.com --ttl 4h \
+    --reason CHG-1234 -o plan.json
+  gta apply --plan plan.json`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("target") {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	RunE: runPlan,
+}
+
+func init() {
+	flags := planCmd.Flags()
+	flags.StringP("project", "p", "", "Project ID (required)")
+	flags.StringP("user", "u", "", "User or service account to grant the role to, me, or @NAME of identities in config (defaults to current user)")
+	flags.StringArray("member", nil, "Exact principal to grant the role to instead of --user, e.g. group:team@example.com (repeatable)")
+	addDurationFlag(planCmd, "ttl", "t", 1*time.Hour, "Time-to-live for the granted permission, e.g. 30m, 8h, 2d, or 1w")
+	flags.StringP("reason", "r", "", "Reason for the access, recorded in the audit log")
+	flags.StringArray("target", nil, "Also grant roles on a resource or project in the same session, RESOURCE=ROLE[,ROLE] (repeatable)")
+	flags.String("dependencies", dependenciesPrompt, "Companion roles of role_dependencies in config: prompt for them, add them, or skip them")
+	flags.Bool("allow-service-account-caller", false, "Allow running with service account credentials")
+	flags.StringP("output", "o", "-", "Plan file to write, - for stdout")
+	registerMemberCompletion(planCmd)
+	rootCmd.AddCommand(planCmd)
+}
+
+func runPlan(cmd *cobra.Command, args []string) error {
+	o, err := resolveGrantOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if err := expandDependencies(o, args); err != nil {
+		return err
+	}
+	p, err := newGCPProvider(cmd.Context(), true, provider.WithServiceAccountCaller(o.AllowServiceAccountCaller))
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	opts := &provider.GCPOptions{
+		Project:  o.Project,
+		Roles:    args,
+		User:     o.User,
+		Members:  o.Members,
+		TTL:      o.TTL,
+		Reason:   o.Reason,
+		Profile:  profile,
+		Resource: o.Resource,
+		Targets:  o.Targets,
+	}
+	pl, err := makePlan(cmd.Context(), p, opts)
+	if err != nil {
+		return err
+	}
+
+	output := flagString(cmd, "output")
+	if output == "-" {
+		data, err := pl.Encode()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := pl.Write(output); err != nil {
+		return err
+	}
+	logger.Info("Wrote the plan of %d binding(s) to %s; execute it with gta apply --plan=%s before %s",
+		len(pl.Bindings), output, output, pl.CreatedAt.Add(cfg.PlanMaxAge()).Format(time.RFC3339))
+	return nil
+}
+
+// makePlan validates the grant of opts as gta grant does, applying the TTL
+// the policy sets, and returns the bindings it adds along with the etags of
+// the policies they are added to
+func makePlan(ctx context.Context, p *provider.GCPProvider, opts *provider.GCPOptions) (*plan.Plan, error) {
+	if cfg.ApprovalRequired(opts.Project) {
+		return nil, fmt.Errorf("project %s requires approval, ask for access with gta request instead", opts.Project)
+	}
+	if err := checkGrantPolicy(append(targetRoles(opts.Targets), opts.Roles...), opts.TTL); err != nil {
+		return nil, err
+	}
+	if err := provider.CheckResourceRoles(opts.Resource, opts.Roles); err != nil {
+		return nil, err
+	}
+	for _, target := range opts.Targets {
+		if err := provider.CheckResourceRoles(target.Resource, target.Roles); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkRoleRules(p.CallerIdentities, opts); err != nil {
+		return nil, err
+	}
+	opts.Environment = resolveEnvironment(p, opts.Project)
+	if err := enforceEnvironment(opts); err != nil {
+		return nil, err
+	}
+	caller, err := p.Caller()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+	if opts.User == "" && len(opts.Members) == 0 {
+		opts.User = caller
+	}
+	if len(cfg.AllowedGranters) > 0 {
+		if err := checkGranter(caller, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := enforcePolicy(ctx, p, opts, caller); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	pl := &plan.Plan{
+		Version:     plan.Version,
+		CreatedAt:   now,
+		CreatedBy:   caller,
+		GTAVersion:  version.String(),
+		Profile:     profile,
+		Project:     opts.Project,
+		Resource:    opts.Resource,
+		Environment: opts.Environment,
+		Roles:       opts.Roles,
+		Members:     opts.Principals(),
+		TTL:         duration.Format(opts.TTL),
+		Reason:      opts.Reason,
+	}
+	for _, target := range opts.Targets {
+		pl.Targets = append(pl.Targets, plan.Target{Resource: target.Resource, Roles: target.Roles})
+	}
+
+	expiry := now.Add(opts.TTL)
+	seen := make(map[string]bool)
+	add := func(target string, roles []string) error {
+		for _, role := range roles {
+			resource := provider.RoleResource(target, role)
+			pl.Bindings = append(pl.Bindings, plan.Binding{Resource: resource, Role: provider.FormatRole(role), Members: pl.Members, Expiry: expiry})
+			if seen[resource] {
+				continue
+			}
+			seen[resource] = true
+			etag, err := p.PolicyEtag(policyTarget(resource))
+			if err != nil {
+				return fmt.Errorf("failed to read the policy: %w", err)
+			}
+			pl.Policies = append(pl.Policies, plan.Policy{Resource: resource, Etag: etag})
+		}
+		return nil
+	}
+	target := opts.Project
+	if opts.Resource != "" {
+		target = opts.Resource
+	}
+	if err := add(target, opts.Roles); err != nil {
+		return nil, err
+	}
+	for _, t := range opts.Targets {
+		if err := add(t.Resource, t.Roles); err != nil {
+			return nil, err
+		}
+	}
+	pl.Sort()
+	return pl, nil
+}
+
+// runApplyPlan executes the plan file at path. The grant is planned again
+// and must add the same bindings to policies that did not change since, or
+// with forceRefresh, the new plan is executed as long as it adds the same
+// bindings.
+func runApplyPlan(cmd *cobra.Command, path string, dryRun, forceRefresh, allowSACaller bool) error {
+	ctx := cmd.Context()
+	pl, err := plan.Load(path)
+	if err != nil {
+		return err
+	}
+	if age := time.Since(pl.CreatedAt); age > cfg.PlanMaxAge() {
+		return fmt.Errorf("plan %s was made %s ago, longer than the %s of plan.max_age: make a new plan with gta plan",
+			path, duration.Format(age.Round(time.Second)), duration.Format(cfg.PlanMaxAge()))
+	}
+	if pl.Profile != profile {
+		logger.Warn("Plan %s was made with profile %q, applying it with profile %q", path, pl.Profile, profile)
+	}
+	ttl, err := duration.Parse(pl.TTL)
+	if err != nil {
+		return fmt.Errorf("invalid plan %s: %v", path, err)
+	}
+	if !forceRefresh {
+		// The bindings expire when planned
+		ttl = time.Until(pl.Expiry()).Round(time.Second)
+		if ttl <= 0 {
+			return fmt.Errorf("the bindings of plan %s expired at %s", path, pl.Expiry().Format(time.RFC3339))
+		}
+	}
+
+	if dryRun {
+		logger.Info("Running in dry-run mode - no changes will be made")
+	}
+	if _, err := newEventSink(ctx, false); err != nil {
+		return err
+	}
+	sessionID := audit.NewID()
+	log := logger.With(slog.String(logger.SessionKey, sessionID), slog.String("project", pl.Project), slog.String("provider", "gcp"))
+	providerOpts := append(confirmOptions(), provider.WithLogger(log), provider.WithServiceAccountCaller(allowSACaller), requestReasonOption(pl.Reason, ""))
+	p, err := newGCPProvider(ctx, dryRun, providerOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+
+	opts := &provider.GCPOptions{
+		Project:   pl.Project,
+		Roles:     pl.Roles,
+		Members:   pl.Members,
+		TTL:       ttl,
+		Reason:    pl.Reason,
+		SessionID: sessionID,
+		Profile:   profile,
+		Resource:  pl.Resource,
+	}
+	for _, target := range pl.Targets {
+		opts.Targets = append(opts.Targets, provider.GrantTarget{Resource: target.Resource, Roles: target.Roles})
+	}
+	current, err := makePlan(ctx, p, opts)
+	if err != nil {
+		return fmt.Errorf("plan %s no longer passes validation: %w", path, err)
+	}
+	if changes := pl.Changes(current); len(changes) > 0 {
+		return fmt.Errorf("the bindings of plan %s do not match its grant, make a new plan with gta plan:\n  %s", path, strings.Join(changes, "\n  "))
+	}
+	if changed := pl.ChangedPolicies(current.Policies); len(changed) > 0 {
+		if !forceRefresh {
+			return fmt.Errorf("the policies of %s changed since plan %s was made: review a new plan, or rerun with --force-refresh to plan again and apply", strings.Join(changed, ", "), path)
+		}
+		logger.Warn("The policies of %s changed since plan %s was made, applying it as planned again", strings.Join(changed, ", "), path)
+	}
+	if forceRefresh {
+		logger.Info("Planned again: the bindings expire at %s", current.Expiry().Format(time.RFC3339))
+	}
+	if !dryRun {
+		if err := confirmEnvironment(opts, flagBool(cmd, "yes")); err != nil {
+			return err
+		}
+	}
+
+	err = p.Grant(opts)
+	flushNotifications()
+	if err != nil {
+		reportGrantOutcome(p, "rolled back")
+		rollbackGrant(p, opts)
+		return fmt.Errorf("failed to apply plan %s: %w", path, err)
+	}
+	if dryRun {
+		return nil
+	}
+	recordKeptSession(opts, p.GrantedRoles())
+	logger.Info("Applied plan %s: granted %s until %s", path, describeGranted(p.GrantedRoles(), opts.Project), sessionExpiry(p.GrantedRoles()).Format(time.RFC3339))
+	logger.Info("The bindings expire by their condition; revoke them earlier with gta revoke --from-state --session=%s", opts.SessionID)
+	return nil
+}
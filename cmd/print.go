@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// Values printed by gta grant --print
+const (
+	printExpiry     = "expiry"
+	printBindingIDs = "binding-ids"
+	printSessionID  = "session-id"
+)
+
+// checkPrint checks --print and --best-effort, and leaves only errors on
+// stderr so that stdout holds nothing but the values printed
+func (o *grantOptions) checkPrint(wrapping bool) error {
+	if o.Print == "" {
+		if o.BestEffort {
+			return fmt.Errorf("--best-effort only applies to --print")
+		}
+		return nil
+	}
+	switch o.Print {
+	case printExpiry, printBindingIDs, printSessionID:
+	default:
+		return fmt.Errorf("invalid --print %q (expected %s, %s, or %s)", o.Print, printExpiry, printBindingIDs, printSessionID)
+	}
+	if wrapping {
+		return fmt.Errorf("--print leaves the bindings in place and cannot wrap a command")
+	}
+	logger.SetLevel(logger.LevelError)
+	return nil
+}
+
+// printGrant writes the values of --print to w, one per line: the session
+// ID, or the expiry or binding ID of each role in the order the roles were
+// given. A role that was not granted fails the command before anything is
+// written, unless --best-effort prints an empty line in its place.
+func printGrant(w io.Writer, o *grantOptions, opts *provider.GCPOptions, outcomes []provider.RoleOutcome) error {
+	var missing []string
+	for _, outcome := range outcomes {
+		if outcome.Granted == nil {
+			missing = append(missing, roleLabel(outcome.Role, provider.GrantedRole{Target: outcome.Target}.Resource()))
+		}
+	}
+	if len(missing) == len(outcomes) {
+		return fmt.Errorf("no role was granted, nothing to print")
+	}
+	if len(missing) > 0 && o.Print != printSessionID {
+		if !o.BestEffort {
+			return fmt.Errorf("no %s to print for %s, which failed; the roles granted are kept in session %s, remove them with gta revoke --from-state --session=%s, or pass --best-effort",
+				o.Print, strings.Join(missing, ", "), opts.SessionID, opts.SessionID)
+		}
+		logger.Error("Printing empty lines for %s, which failed", strings.Join(missing, ", "))
+	}
+
+	if o.Print == printSessionID {
+		_, err := fmt.Fprintln(w, opts.SessionID)
+		return err
+	}
+	var b strings.Builder
+	for _, outcome := range outcomes {
+		switch {
+		case outcome.Granted == nil:
+		case o.Print == printExpiry:
+			b.WriteString(outcome.Granted.Expiry.UTC().Format(time.RFC3339))
+		default:
+			b.WriteString(outcome.Granted.BindingID)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
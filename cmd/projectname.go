@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// resolveProjectFlag replaces the aliases of projects and the KEY=VALUE
+// pairs of project_template given to the --project flag of cmd, or its
+// environment variable, with the project IDs they name, so that commands,
+// their logs, prompts, and audit records only see concrete project IDs
+func resolveProjectFlag(cmd *cobra.Command) error {
+	flag := cmd.Flags().Lookup("project")
+	if flag == nil {
+		return nil
+	}
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		values := slice.GetSlice()
+		resolved := make([]string, 0, len(values))
+		for _, value := range values {
+			id, err := resolveProject(cmd.Context(), value)
+			if err != nil {
+				return err
+			}
+			resolved = append(resolved, id)
+		}
+		return slice.Replace(resolved)
+	}
+	value, ok := lookupOption(cmd, "project")
+	if !ok {
+		return nil
+	}
+	id, err := resolveProject(cmd.Context(), value)
+	if err != nil {
+		return err
+	}
+	if id == value {
+		return nil
+	}
+	return cmd.Flags().Set("project", id)
+}
+
+// resolveProject returns the project ID named by project: an alias of
+// projects in config, KEY=VALUE pairs filling project_template, which must
+// match exactly one project the caller can see, or a project ID, returned
+// unchanged
+func resolveProject(ctx context.Context, project string) (string, error) {
+	if id := cfg.ProjectAlias(project); id != project {
+		logger.Info("Using project %s for alias %s", id, project)
+		return id, nil
+	}
+	if !strings.Contains(project, "=") {
+		return project, nil
+	}
+	pattern, err := cfg.ProjectPattern(project)
+	if err != nil {
+		return "", err
+	}
+	p, err := newGCPProvider(ctx, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	// The search only narrows the projects down by prefix, the pattern
+	// matches them exactly
+	query := ""
+	if prefix, _, _ := strings.Cut(pattern, "*"); prefix != "" && !strings.Contains(prefix, "?") {
+		query = "id:" + prefix + "*"
+	}
+	found, err := p.SearchProjects(query)
+	if err != nil {
+		return "", fmt.Errorf("failed to search projects matching %s: %v", pattern, err)
+	}
+	var ids []string
+	for _, info := range found {
+		if ok, _ := path.Match(pattern, info.ID); ok {
+			ids = append(ids, info.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no project matches %s for %s", pattern, project)
+	case 1:
+		logger.Info("Using project %s for %s", ids[0], project)
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("%s matches %d projects for %s, give the project ID or more precise values:\n  %s",
+			pattern, len(ids), project, strings.Join(ids, "\n  "))
+	}
+}
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/projects"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/tui"
+	"github.com/yckao/gta/pkg/workerpool"
+)
+
+// projectsCacheFile is the name of the projects cache in the data directory
+const projectsCacheFile = "projects.json"
+
+var projectsCmd = &cobra.Command{
+	Use:   "projects [query]",
+	Short: "List the projects you can see",
+	Long: `List the active projects the caller can see, with their ID, name, number,
+and parent folder or organization. A query keeps the projects whose ID or
+name contains it, exact and prefix matches of the ID first. --filter is a
+Resource Manager search query applied by the API, such as labels.team=payments.
+
+With --iam-admin-only, only the projects whose IAM policy the caller may
+change are listed, that is where gta can grant roles.
+
+The projects are cached in ~/.gta/projects.json for an hour, which also
+completes --project in the shell; use --refresh to fetch them again. Searches
+with --filter are not cached.
+
+Example:
+  gta projects payments
+  gta projects --filter 'labels.team=payments'
+  gta projects --iam-admin-only
+  gta projects --output=json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runProjects,
+}
+
+// projectsOptions are the options of gta projects
+type projectsOptions struct {
+	Query        string
+	Filter       string
+	Output       string
+	Refresh      bool
+	IAMAdminOnly bool
+}
+
+func init() {
+	flags := projectsCmd.Flags()
+	flags.String("filter", "", "Resource Manager search query, e.g. labels.team=payments or parent=folders/123")
+	flags.Bool("iam-admin-only", false, "Only list the projects whose IAM policy you may change")
+	flags.StringP("output", "o", outputTable, "Print the projects as a table or json")
+	flags.Bool("refresh", false, "Fetch the projects again instead of using the cache")
+	rootCmd.AddCommand(projectsCmd)
+}
+
+func runProjects(cmd *cobra.Command, args []string) error {
+	o := projectsOptions{
+		Filter:       flagString(cmd, "filter"),
+		Output:       flagString(cmd, "output"),
+		Refresh:      flagBool(cmd, "refresh"),
+		IAMAdminOnly: flagBool(cmd, "iam-admin-only"),
+	}
+	if len(args) > 0 {
+		o.Query = args[0]
+	}
+	switch o.Output {
+	case outputTable, outputJSON:
+	default:
+		return fmt.Errorf("invalid output format %q (expected table or json)", o.Output)
+	}
+
+	ctx := cmd.Context()
+	visible, err := visibleProjects(ctx, &o)
+	if err != nil {
+		return err
+	}
+	found := projects.Search(visible, o.Query)
+	if o.IAMAdminOnly {
+		if found, err = iamAdminProjects(ctx, found); err != nil {
+			return err
+		}
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("no project found")
+	}
+	return writeProjects(os.Stdout, o.Output, found)
+}
+
+// visibleProjects returns the projects the caller can see, cached in the data
+// directory unless a filter is set
+func visibleProjects(ctx context.Context, o *projectsOptions) ([]provider.ProjectInfo, error) {
+	fetch := func() ([]provider.ProjectInfo, error) {
+		p, err := newGCPProvider(ctx, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		return p.SearchProjects(o.Filter)
+	}
+	if o.Filter != "" {
+		return fetch()
+	}
+	dir, err := config.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	maxAge := projects.DefaultCacheMaxAge
+	if o.Refresh {
+		maxAge = 0
+	}
+	return projects.Load(filepath.Join(dir, projectsCacheFile), maxAge, fetch)
+}
+
+// pickedProjectsListed is the number of best matches listed when no project
+// can be picked without a terminal
+const pickedProjectsListed = 10
+
+// pickProject asks on the terminal for one of the projects the caller can
+// see, narrowed first to those matching filter as by gta projects QUERY.
+// Without a terminal, the error lists the best matches instead.
+func pickProject(ctx context.Context, filter string) (string, error) {
+	visible, err := visibleProjects(ctx, &projectsOptions{})
+	if err != nil {
+		return "", err
+	}
+	candidates := projects.Search(visible, filter)
+	if len(candidates) == 0 {
+		if filter != "" {
+			return "", fmt.Errorf("no project matches %q", filter)
+		}
+		return "", fmt.Errorf("no project found")
+	}
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		if info, err := f.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return "", &exitError{err: unpickedProjectError(candidates), code: ExitUsage}
+		}
+	}
+
+	choices := make([]tui.Choice, len(candidates))
+	for i, project := range candidates {
+		choices[i] = tui.Choice{Columns: []string{project.ID, project.Name, project.Parent}}
+	}
+	title := "Choose the project to grant on"
+	if filter != "" {
+		title += fmt.Sprintf(", among those matching %q", filter)
+	}
+	chosen, err := tui.Pick(choices, tui.PickOptions{
+		In:     os.Stdin,
+		Out:    os.Stderr,
+		Title:  title,
+		Header: []string{"PROJECT ID", "NAME", "PARENT"},
+	})
+	if errors.Is(err, tui.ErrCancelled) {
+		return "", fmt.Errorf("no project chosen")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to pick a project: %v", err)
+	}
+	logger.Info("Picked project %s", candidates[chosen].ID)
+	return candidates[chosen].ID, nil
+}
+
+// applyPickProject sets the project to the one picked with --pick-project,
+// among those matching --project-filter
+func (o *grantOptions) applyPickProject(cmd *cobra.Command) error {
+	filter := flagString(cmd, "project-filter")
+	if !flagBool(cmd, "pick-project") {
+		if filter != "" {
+			return fmt.Errorf("--project-filter narrows the projects of --pick-project, set both")
+		}
+		return nil
+	}
+	project, err := pickProject(cmd.Context(), filter)
+	if err != nil {
+		return err
+	}
+	o.Project = project
+	return nil
+}
+
+// unpickedProjectError tells that a project cannot be picked without a
+// terminal, listing the best of candidates to set with --project
+func unpickedProjectError(candidates []provider.ProjectInfo) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--pick-project needs an interactive terminal, set --project instead; ")
+	if len(candidates) > pickedProjectsListed {
+		fmt.Fprintf(&b, "the best %d of %d projects are:\n", pickedProjectsListed, len(candidates))
+		candidates = candidates[:pickedProjectsListed]
+	} else {
+		fmt.Fprintf(&b, "the projects are:\n")
+	}
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, project := range candidates {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", project.ID, project.Name, project.Parent)
+	}
+	tw.Flush()
+	return errors.New(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// iamAdminProjects returns the projects whose IAM policy the caller may
+// change, warning about those that could not be checked
+func iamAdminProjects(ctx context.Context, candidates []provider.ProjectInfo) ([]provider.ProjectInfo, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	workers := fanOutWorkers(len(candidates))
+	logger.Info("Checking the permissions on %d project(s) with %d worker(s)", len(candidates), workers)
+	var providers []*provider.GCPProvider
+	for len(providers) < workers {
+		p, err := newGCPProvider(ctx, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		providers = append(providers, p)
+	}
+
+	var mu sync.Mutex
+	admin := make(map[string]bool)
+	workerpool.Run(ctx, workers, candidates, func(worker int, project provider.ProjectInfo) {
+		ok, err := providers[worker].CanSetIamPolicy(project.ID)
+		if err != nil {
+			logger.Warn("Failed to check the permissions on %s: %v", project.ID, err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		admin[project.ID] = ok
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var found []provider.ProjectInfo
+	for _, project := range candidates {
+		if admin[project.ID] {
+			found = append(found, project)
+		}
+	}
+	return found, nil
+}
+
+// writeProjects prints projects as a table or JSON
+func writeProjects(w io.Writer, output string, found []provider.ProjectInfo) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(found)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT ID\tNAME\tNUMBER\tPARENT")
+	for _, project := range found {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", project.ID, project.Name, project.Number, project.Parent)
+	}
+	return tw.Flush()
+}
+
+// cachedProjectIDs returns the IDs of the projects cached by gta projects,
+// however old, starting with prefix
+func cachedProjectIDs(prefix string) []string {
+	dir, err := config.DataDir()
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, project := range projects.Cached(filepath.Join(dir, projectsCacheFile)) {
+		if strings.HasPrefix(project.ID, prefix) {
+			ids = append(ids, project.ID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// Formats of gta prompt
+const (
+	promptPlain     = "plain"
+	promptPowerline = "powerline"
+	promptJSON      = "json"
+)
+
+// promptSnippets are the snippets printed by gta prompt init, each adding the
+// output of gta prompt in front of the prompt of the shell, once however
+// often it is evaluated
+var promptSnippets = map[string]string{
+	"bash": `# gta prompt, load with: eval "$(gta prompt init bash)"
+__gta_prompt() {
+  local gta
+  gta="$(command gta prompt 2>/dev/null)"
+  if [ -n "$gta" ]; then
+    printf '%s ' "$gta"
+  fi
+}
+case "$PS1" in
+  *__gta_prompt*) ;;
+  *) PS1='$(__gta_prompt)'"$PS1" ;;
+esac
+`,
+	"zsh": `# gta prompt, load with: eval "$(gta prompt init zsh)"
+setopt prompt_subst
+__gta_prompt() {
+  local gta
+  gta="$(command gta prompt 2>/dev/null)"
+  if [[ -n "$gta" ]]; then
+    print -rn -- "$gta "
+  fi
+}
+if [[ "$PROMPT" != *__gta_prompt* ]]; then
+  PROMPT='$(__gta_prompt)'"$PROMPT"
+fi
+`,
+	"fish": `# gta prompt, load with: gta prompt init fish | source
+if not functions -q __gta_original_prompt
+    functions -c fish_prompt __gta_original_prompt
+    function fish_prompt
+        set -l gta (command gta prompt 2>/dev/null)
+        if test -n "$gta"
+            printf '%s ' $gta
+        end
+        __gta_original_prompt
+    end
+end
+`,
+	"starship": `# gta prompt, add to ~/.config/starship.toml with:
+#   gta prompt init starship >> ~/.config/starship.toml
+# and add ${custom.gta} to the format of the prompt if it lists its modules
+[custom.gta]
+command = "gta prompt"
+when = true
+style = "bold red"
+format = "[$output]($style) "
+`,
+}
+
+var promptCmd = &cobra.Command{
+	Use:   "prompt",
+	Short: "Print the active temporary access for a shell prompt",
+	Long: `Print a compact summary of the sessions of this machine whose bindings still
+grant access, for embedding in a shell prompt, e.g.
+
+  gta:2↑(prod 37m)
+
+for two active sessions, the most sensitive of which is in the prod
+environment with 37 minutes left. Sessions are ranked by their environment,
+in the order of environments.classes; a session in no environment is named by
+its project. Nothing is printed when no session is active.
+
+Only the local state is read, without waiting for its lock or calling any API,
+so that the prompt stays instant; an encrypted state whose passphrase is not
+in GTA_PASSPHRASE shows nothing. Sessions granted on other machines are not
+shown.
+
+--format powerline prints a segment with Powerline glyphs, and json the
+details of the sessions, with "active": 0 when none is.
+
+gta prompt init prints the snippet adding the summary to the prompt of bash,
+zsh, or fish, or the custom module of starship.
+
+Example:
+  gta prompt
+  gta prompt --format=json
+  eval "$(gta prompt init zsh)"
+  gta prompt init starship >> ~/.config/starship.toml`,
+	Args: cobra.NoArgs,
+	// Skip the pruning of sessions and the retries of pending revocations of
+	// the root setup, which take the state lock and call APIs
+	PersistentPreRunE: setupPrompt,
+	RunE:              runPrompt,
+}
+
+var promptInitCmd = &cobra.Command{
+	Use:   "init bash|zsh|fish|starship",
+	Short: "Print the shell integration showing gta prompt in the prompt",
+	Long: `Print a snippet that shows the output of gta prompt in front of the prompt of
+the shell, or the custom module of starship.
+
+Example:
+  # ~/.bashrc
+  eval "$(gta prompt init bash)"
+
+  # ~/.zshrc
+  eval "$(gta prompt init zsh)"
+
+  # ~/.config/fish/config.fish
+  gta prompt init fish | source
+
+  # ~/.config/starship.toml
+  gta prompt init starship >> ~/.config/starship.toml`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"bash", "zsh", "fish", "starship"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Print(promptSnippets[args[0]])
+		return nil
+	},
+}
+
+func init() {
+	promptCmd.Flags().String("format", promptPlain, "Output format: plain, powerline, or json")
+	promptCmd.AddCommand(promptInitCmd)
+	rootCmd.AddCommand(promptCmd)
+}
+
+// setupPrompt loads the config file and configures logging like setup,
+// without its housekeeping of the local state
+func setupPrompt(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	loaded, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	cfg = loaded
+	return setupLogging(cmd, args)
+}
+
+// activeSession is a session of the local state still granting access, as
+// gta prompt --format=json prints it
+type activeSession struct {
+	ID          string    `json:"id"`
+	Project     string    `json:"project"`
+	Environment string    `json:"environment,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Remaining is the number of seconds left until the last binding of the
+	// session expires
+	Remaining int64 `json:"remaining_seconds"`
+	// rank is the strictness of the environment, -1 for none
+	rank int
+}
+
+// label names the session in the prompt: its environment, or its project
+func (s activeSession) label() string {
+	if s.Environment != "" {
+		return s.Environment
+	}
+	return s.Project
+}
+
+// promptSummary is the output of gta prompt --format=json
+type promptSummary struct {
+	Active int `json:"active"`
+	// MostSensitive is the session in the strictest environment, the one
+	// lasting longest among those of the same environment
+	MostSensitive *activeSession  `json:"most_sensitive,omitempty"`
+	Sessions      []activeSession `json:"sessions"`
+}
+
+func runPrompt(cmd *cobra.Command, args []string) error {
+	format := flagString(cmd, "format")
+	switch format {
+	case promptPlain, promptPowerline, promptJSON:
+	default:
+		return fmt.Errorf("invalid format %q (expected plain, powerline, or json)", format)
+	}
+
+	summary := summarizeActiveSessions(time.Now())
+	if format == promptJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		return encoder.Encode(summary)
+	}
+	if summary.Active == 0 {
+		return nil
+	}
+	top := summary.MostSensitive
+	remaining := formatRemaining(time.Duration(top.Remaining) * time.Second)
+	if format == promptPowerline {
+		// A lock and a thin separator of the Powerline glyphs
+		fmt.Printf("\ue0a2 %d↑ \ue0b1 %s %s\n", summary.Active, top.label(), remaining)
+		return nil
+	}
+	fmt.Printf("gta:%d↑(%s %s)\n", summary.Active, top.label(), remaining)
+	return nil
+}
+
+// summarizeActiveSessions returns the sessions of the local state whose
+// bindings grant access at now, most sensitive first. A state that cannot be
+// read counts as having none, as a prompt has nowhere to report it.
+func summarizeActiveSessions(now time.Time) promptSummary {
+	summary := promptSummary{Sessions: []activeSession{}}
+	if passphraseNeeded() {
+		return summary
+	}
+	store, err := newStateStore()
+	if err != nil {
+		logger.Debug("Not reading the local state: %v", err)
+		return summary
+	}
+	f, err := store.Snapshot()
+	if err != nil {
+		logger.Debug("Not reading the local state: %v", err)
+		return summary
+	}
+
+	ranks := make(map[string]int, len(cfg.Environments.Classes))
+	for i, class := range cfg.Environments.Classes {
+		ranks[class.Name] = i
+	}
+	for _, s := range f.Sessions {
+		expiry := s.LastExpiry()
+		if !expiry.After(now) {
+			continue
+		}
+		active := activeSession{
+			ID:          s.ID,
+			Project:     s.Project,
+			Environment: s.Environment,
+			ExpiresAt:   expiry,
+			Remaining:   int64(expiry.Sub(now) / time.Second),
+			rank:        -1,
+		}
+		if rank, ok := ranks[s.Environment]; ok {
+			active.rank = rank
+		}
+		summary.Sessions = append(summary.Sessions, active)
+	}
+	sortActiveSessions(summary.Sessions)
+	summary.Active = len(summary.Sessions)
+	if summary.Active > 0 {
+		summary.MostSensitive = &summary.Sessions[0]
+	}
+	return summary
+}
+
+// sortActiveSessions sorts sessions from the strictest environment, and
+// within one from the session lasting longest
+func sortActiveSessions(sessions []activeSession) {
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if sessions[i].rank != sessions[j].rank {
+			return sessions[i].rank > sessions[j].rank
+		}
+		return sessions[i].ExpiresAt.After(sessions[j].ExpiresAt)
+	})
+}
+
+// formatRemaining formats the time left in a session compactly, e.g. 37m or
+// 1h05m
+func formatRemaining(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return fmt.Sprintf("%dh%02dm", d/time.Hour, d%time.Hour/time.Minute)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// Providers recognized by detection, including those gta does not support yet
+const (
+	providerAWS        = "aws"
+	providerKubernetes = "k8s"
+)
+
+var (
+	// gcpTargetPattern matches arguments naming a GCP role or resource
+	gcpTargetPattern = regexp.MustCompile(`^(roles|projects|organizations|folders)/`)
+	// awsTargetPattern matches arguments naming an AWS resource by its ARN
+	awsTargetPattern = regexp.MustCompile(`^arn:aws[a-z-]*:`)
+)
+
+// providerHints is what a command was given that tells which provider it
+// acts on
+type providerHints struct {
+	// Explicit is the provider set with --provider, which always wins
+	Explicit string
+	// Project, Account, and KubeContext are the values of --project,
+	// --account, and --context set on the command line or in the environment
+	Project     string
+	Account     string
+	KubeContext string
+	// Targets are the positional arguments, e.g. roles or resource names
+	Targets []string
+	// Pinned is the provider of the active profile, used when nothing else
+	// points to a provider
+	Pinned string
+}
+
+// resolveProvider detects the provider from hints: --provider wins, then the
+// providers the other hints point to, which must agree, then the pinned
+// provider, and then the only supported provider
+func resolveProvider(h providerHints) (string, error) {
+	if h.Explicit != "" {
+		return h.Explicit, nil
+	}
+
+	detected := make(map[string]bool)
+	if h.Project != "" {
+		detected[provider.NameGCP] = true
+	}
+	if h.Account != "" {
+		detected[providerAWS] = true
+	}
+	if h.KubeContext != "" {
+		detected[providerKubernetes] = true
+	}
+	for _, target := range h.Targets {
+		switch {
+		case gcpTargetPattern.MatchString(target):
+			detected[provider.NameGCP] = true
+		case awsTargetPattern.MatchString(target):
+			detected[providerAWS] = true
+		}
+	}
+
+	candidates := make([]string, 0, len(detected))
+	for name := range detected {
+		candidates = append(candidates, name)
+	}
+	sort.Strings(candidates)
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case len(candidates) > 1:
+		return "", fmt.Errorf("ambiguous provider: the arguments point to %s; set --provider", strings.Join(candidates, ", "))
+	case h.Pinned != "":
+		return h.Pinned, nil
+	case len(provider.Names) == 1:
+		return provider.Names[0], nil
+	default:
+		return "", fmt.Errorf("cannot detect the provider (candidates: %s); set --provider", strings.Join(provider.Names, ", "))
+	}
+}
+
+// providerOption resolves the provider of cmd from its flags and arguments,
+// or returns an empty string when cmd has no --provider flag
+func providerOption(cmd *cobra.Command) (string, error) {
+	if cmd.Flags().Lookup("provider") == nil {
+		return "", nil
+	}
+	explicit, _ := lookupOption(cmd, "provider")
+	project, _ := lookupOption(cmd, "project")
+	account, _ := lookupOption(cmd, "account")
+	kubeContext, _ := lookupOption(cmd, "context")
+	name, err := resolveProvider(providerHints{
+		Explicit:    explicit,
+		Project:     project,
+		Account:     account,
+		KubeContext: kubeContext,
+		Targets:     cmd.Flags().Args(),
+		Pinned:      cfg.ProviderFor(profile),
+	})
+	if err != nil {
+		return "", err
+	}
+	if !provider.Supported(name) {
+		return "", fmt.Errorf("provider %q is not supported (supported: %s)", name, strings.Join(provider.Names, ", "))
+	}
+	return name, nil
+}
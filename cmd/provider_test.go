@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+func TestResolveProvider(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		hints   providerHints
+		want    string
+		wantErr string
+	}{
+		{name: "nothing", want: provider.NameGCP},
+		{name: "project", hints: providerHints{Project: "my-project"}, want: provider.NameGCP},
+		{name: "account", hints: providerHints{Account: "123456789012"}, want: providerAWS},
+		{name: "kube context", hints: providerHints{KubeContext: "prod"}, want: providerKubernetes},
+		{name: "role", hints: providerHints{Targets: []string{"roles/viewer"}}, want: provider.NameGCP},
+		{name: "project resource", hints: providerHints{Targets: []string{"projects/p/topics/t"}}, want: provider.NameGCP},
+		{name: "organization", hints: providerHints{Targets: []string{"organizations/123"}}, want: provider.NameGCP},
+		{name: "folder", hints: providerHints{Targets: []string{"folders/456"}}, want: provider.NameGCP},
+		{name: "ARN", hints: providerHints{Targets: []string{"arn:aws:iam::123456789012:role/admin"}}, want: providerAWS},
+		{name: "GovCloud ARN", hints: providerHints{Targets: []string{"arn:aws-us-gov:s3:::bucket"}}, want: providerAWS},
+		{name: "short role names say nothing", hints: providerHints{Targets: []string{"viewer", "editor"}, Pinned: providerAWS}, want: providerAWS},
+		{name: "agreeing hints", hints: providerHints{Project: "p", Targets: []string{"roles/viewer", "projects/p"}}, want: provider.NameGCP},
+		{name: "pinned", hints: providerHints{Pinned: providerKubernetes}, want: providerKubernetes},
+		{name: "hints over pinned", hints: providerHints{Project: "p", Pinned: providerAWS}, want: provider.NameGCP},
+		{
+			name:    "project and account",
+			hints:   providerHints{Project: "p", Account: "123456789012"},
+			wantErr: "the arguments point to aws, gcp; set --provider",
+		},
+		{
+			name:    "project and ARN",
+			hints:   providerHints{Project: "p", Targets: []string{"arn:aws:s3:::bucket"}},
+			wantErr: "the arguments point to aws, gcp",
+		},
+		{
+			name:    "all three",
+			hints:   providerHints{Project: "p", Account: "1", KubeContext: "c", Pinned: provider.NameGCP},
+			wantErr: "the arguments point to aws, gcp, k8s",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveProvider(tc.hints)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("resolveProvider = %q, %v, want %q", got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("resolveProvider = %q, %v, want %s", got, err, tc.want)
+			}
+		})
+
+		// --provider wins over whatever the other hints say
+		t.Run(tc.name+" with --provider", func(t *testing.T) {
+			for _, explicit := range []string{provider.NameGCP, providerAWS, providerKubernetes} {
+				hints := tc.hints
+				hints.Explicit = explicit
+				if got, err := resolveProvider(hints); err != nil || got != explicit {
+					t.Errorf("resolveProvider with --provider=%s = %q, %v", explicit, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveProviderWithoutDefault(t *testing.T) {
+	saved := provider.Names
+	t.Cleanup(func() { provider.Names = saved })
+	provider.Names = []string{provider.NameGCP, providerAWS}
+
+	_, err := resolveProvider(providerHints{Targets: []string{"viewer"}})
+	if err == nil || !strings.Contains(err.Error(), "cannot detect the provider (candidates: gcp, aws)") {
+		t.Errorf("err = %v, want the candidates listed", err)
+	}
+	if got, err := resolveProvider(providerHints{Pinned: providerAWS}); err != nil || got != providerAWS {
+		t.Errorf("pinned = %q, %v", got, err)
+	}
+}
+
+// providerCommand returns a command with the flags providerOption reads,
+// storing the provider it resolves in name
+func providerCommand(name *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "test",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			*name, err = providerOption(cmd)
+			return err
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	flags := cmd.Flags()
+	flags.StringP("provider", "c", "", "")
+	flags.StringP("project", "p", "", "")
+	flags.String("account", "", "")
+	flags.String("context", "", "")
+	return cmd
+}
+
+func TestProviderOption(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		profile string
+		env     map[string]string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "default", want: provider.NameGCP},
+		{name: "project flag", args: []string{"-p", "p", "roles/viewer"}, want: provider.NameGCP},
+		{name: "explicit", args: []string{"--provider=gcp", "arn:aws:s3:::bucket", "--account=1"}, want: provider.NameGCP},
+		{name: "explicit in the environment", env: map[string]string{"GTA_PROVIDER": "gcp"}, args: []string{"--account=1"}, want: provider.NameGCP},
+		{name: "project in the environment", env: map[string]string{"GTA_PROJECT": "p"}, args: []string{"--context=prod"}, wantErr: "ambiguous provider"},
+		{name: "detected but unsupported", args: []string{"arn:aws:iam::1:role/admin"}, wantErr: `provider "aws" is not supported`},
+		{name: "explicit but unsupported", args: []string{"--provider=azure", "-p", "p"}, wantErr: `provider "azure" is not supported`},
+		{
+			name:    "pinned by the profile",
+			config:  "profiles:\n  prod:\n    provider: gcp\n",
+			profile: "prod",
+			args:    []string{"viewer"},
+			want:    provider.NameGCP,
+		},
+		{
+			name:    "hints over the profile",
+			config:  "profiles:\n  prod:\n    provider: gcp\n",
+			profile: "prod",
+			args:    []string{"--account=1"},
+			wantErr: `provider "aws" is not supported`,
+		},
+		{
+			name:    "explicit over hints and the profile",
+			config:  "profiles:\n  prod:\n    provider: gcp\n",
+			profile: "prod",
+			args:    []string{"-c", "gcp", "--account=1", "--context=prod"},
+			want:    provider.NameGCP,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, tc.config)
+			saved := profile
+			t.Cleanup(func() { profile = saved })
+			profile = tc.profile
+			for _, name := range []string{"GTA_PROVIDER", "GTA_PROJECT", "GTA_ACCOUNT", "GTA_CONTEXT"} {
+				t.Setenv(name, tc.env[name])
+			}
+
+			var got string
+			cmd := providerCommand(&got)
+			cmd.SetArgs(tc.args)
+			_, err := cmd.ExecuteC()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("providerOption = %q, %v, want %q", got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("providerOption = %q, %v, want %s", got, err, tc.want)
+			}
+		})
+	}
+
+	// Commands without --provider have none
+	if got, err := providerOption(&cobra.Command{Use: "version"}); err != nil || got != "" {
+		t.Errorf("providerOption without the flag = %q, %v", got, err)
+	}
+}
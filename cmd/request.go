@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/approval"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+var requestCmd = &cobra.Command{
+	Use:   "request [roles...]",
+	Short: "Request temporary IAM roles that a second person must approve",
+	Long: `Request temporary IAM roles for yourself. The request is signed and stored in
+the approval store from config, and approvers are notified. Access is granted
+once someone else runs gta approve with the request ID, as long as the request
+has not expired.
+
+Example:
+  gta request roles/editor --project=prod --reason="Fix incident INC-123"`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runRequest,
+}
+
+var requestsCmd = &cobra.Command{
+	Use:   "requests",
+	Short: "Manage access requests",
+}
+
+var requestsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List access requests",
+	Long: `List pending access requests. Use --all to include approved and expired ones.
+
+Example:
+  gta requests list
+  gta requests list --all`,
+	Args: cobra.NoArgs,
+	RunE: runRequestsList,
+}
+
+func init() {
+	flags := requestCmd.Flags()
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID (required)")
+	addDurationFlag(requestCmd, "ttl", "t", 1*time.Hour, "Time-to-live of the access once approved, e.g. 30m, 8h, 2d, or 1w")
+	flags.StringP("reason", "r", "", "Reason for the access, shown to approvers (required)")
+
+	requestsListCmd.Flags().BoolP("all", "a", false, "Include approved and expired requests")
+	requestsCmd.AddCommand(requestsListCmd)
+}
+
+func runRequest(cmd *cobra.Command, args []string) error {
+	o, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if err := o.requireProject(); err != nil {
+		return err
+	}
+	if o.Reason == "" {
+		return fmt.Errorf("a reason is required: set --reason or %sREASON", envPrefix)
+	}
+
+	ctx := cmd.Context()
+
+	if err := checkGrantPolicy(args, o.TTL); err != nil {
+		return err
+	}
+
+	key, err := approvalSigningKey()
+	if err != nil {
+		return err
+	}
+	store, err := newRequestStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	p, err := newGCPProvider(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	requester, err := p.Caller()
+	if err != nil {
+		return fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	roles := make([]string, len(args))
+	for i, role := range args {
+		roles[i] = provider.FormatRole(role)
+	}
+	now := time.Now().UTC()
+	r := &approval.Request{
+		ID:        audit.NewID(),
+		Project:   o.Project,
+		Roles:     roles,
+		Requester: requester,
+		TTL:       o.TTL,
+		Reason:    o.Reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.RequestExpiry()),
+		Status:    approval.StatusPending,
+	}
+	if err := r.Sign(key); err != nil {
+		return err
+	}
+	if err := store.Save(ctx, r); err != nil {
+		return err
+	}
+
+	sink, err := newEventSink(ctx, false)
+	if err != nil {
+		return err
+	}
+	for _, role := range r.Roles {
+		event := audit.NewEvent(audit.ActionRequest)
+		event.Project = r.Project
+		event.Role = role
+		event.Member = "user:" + r.Requester
+		event.Reason = r.Reason
+		event.Caller = r.Requester
+		event.RequestID = r.ID
+		event.Requester = r.Requester
+		sink.Emit(event)
+	}
+	flushNotifications()
+
+	logger.Info("Created request %s for %s in project %s, valid until %s",
+		r.ID, strings.Join(r.Roles, ", "), r.Project, r.ExpiresAt.Local().Format(time.RFC3339))
+	logger.Info("Ask an approver to run: gta approve %s", r.ID)
+	return nil
+}
+
+func runRequestsList(cmd *cobra.Command, args []string) error {
+	all := flagBool(cmd, "all")
+	ctx := cmd.Context()
+
+	store, err := newRequestStore(ctx)
+	if err != nil {
+		return err
+	}
+	requests, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	found := false
+	for _, r := range requests {
+		status := r.StatusAt(now)
+		if status != approval.StatusPending && !all {
+			continue
+		}
+		found = true
+		logger.Info("Request %s: Status=%s, Project=%s, Roles=%s, Requester=%s, TTL=%s, Expires=%s, Reason=%q",
+			r.ID,
+			status,
+			r.Project,
+			strings.Join(r.Roles, ","),
+			r.Requester,
+			duration.Format(r.TTL),
+			r.ExpiresAt.Local().Format(time.RFC3339),
+			r.Reason,
+		)
+		if r.Approver != "" {
+			logger.Info("  Approved by %s at %s", r.Approver, r.ApprovedAt.Local().Format(time.RFC3339))
+		}
+	}
+
+	if !found {
+		logger.Info("No requests found")
+	}
+	return nil
+}
+
+// newRequestStore opens the approval request store from config
+func newRequestStore(ctx context.Context) (approval.Store, error) {
+	location, err := cfg.RequestStore()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Using request store: %s", location)
+	opts, err := apiClientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return approval.NewStore(ctx, location, opts...)
+}
+
+// approvalSigningKey returns the key used to sign and verify requests
+func approvalSigningKey() ([]byte, error) {
+	key := cfg.Approval.SigningKey
+	if env := cfg.Approval.SigningKeyEnv; env != "" {
+		key = os.Getenv(env)
+		if key == "" {
+			return nil, fmt.Errorf("approval signing key variable %s is not set", env)
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("approval.signing_key or approval.signing_key_env must be configured to sign requests")
+	}
+	return []byte(key), nil
+}
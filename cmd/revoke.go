@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke the roles of sessions recorded on this machine",
+	Long: `Revoke the bindings of sessions recorded in the local state, such as those
+granted with gta grant --keep or --export-session, and forget the sessions.
+
+--session takes session IDs as shown by gta sessions list, separated by
+commas, and defaults to GTA_SESSION as exported by gta grant
+--export-session. Sessions no longer recorded, e.g. revoked already, are
+skipped. --timeout bounds the whole command, so that the exit hook of gta
+shell-init cannot hang the shell when the API is unreachable; bindings left
+behind expire by their condition.
+
+Example:
+  gta revoke --from-state --session=3f2a9c1d5e6b7a80
+  gta revoke --from-state --timeout=15s`,
+	Args: cobra.NoArgs,
+	RunE: runRevoke,
+}
+
+func init() {
+	flags := revokeCmd.Flags()
+	flags.Bool("from-state", false, "Revoke the sessions recorded in the local state (required)")
+	flags.String("session", "", "Comma-separated IDs of the sessions to revoke (default $GTA_SESSION)")
+	addDurationFlag(revokeCmd, "timeout", "", 0, "Give up on the revocation after this long, e.g. 15s (default none)")
+	flags.BoolP("dry-run", "d", false, "Show the bindings that would be revoked without revoking them")
+	rootCmd.AddCommand(revokeCmd)
+}
+
+func runRevoke(cmd *cobra.Command, args []string) error {
+	if !flagBool(cmd, "from-state") {
+		return fmt.Errorf("gta revoke revokes the sessions recorded on this machine, pass --from-state; remove other bindings with gta clean")
+	}
+	var ids []string
+	for _, id := range strings.Split(stringOption(cmd, "session", ""), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no session to revoke: pass --session or set %s", optionEnv("session"))
+	}
+	timeout, err := durationOption(cmd, "timeout", 0)
+	if err != nil {
+		return err
+	}
+	dryRun, err := boolOption(cmd, "dry-run")
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, id := range ids {
+		s, ok := f.Session(id)
+		if !ok {
+			logger.Info("Session %s is not recorded on this machine, nothing to revoke", id)
+			continue
+		}
+		if err := revokeSession(ctx, *s, dryRun); err != nil {
+			if logFormat == string(logger.FormatJSON) {
+				logger.ErrorAttrs("Failed to revoke session", append(errorAttrs(err), slog.String("session", id))...)
+			} else {
+				logger.Error("Failed to revoke session %s: %v", id, err)
+			}
+			failed = append(failed, id)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to revoke %d session(s), their bindings expire by their condition; retry with gta revoke --from-state --session=%s", len(failed), strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// revokeSession revokes the bindings of a session of the local state and
+// forgets it
+func revokeSession(ctx context.Context, s state.Session, dryRun bool) error {
+	if s.Provider != "gcp" {
+		return fmt.Errorf("sessions of provider %s cannot be revoked", s.Provider)
+	}
+	events, err := commandEventSinks(ctx)
+	if err != nil {
+		return err
+	}
+	revoke := sessionRevocation(ctx, s, dryRun, events)
+	if dryRun {
+		return revoke()
+	}
+	if err := revokeWithReauth(s.ID, revoke, nil); err != nil {
+		return err
+	}
+	forgetSession(s.ID)
+	return nil
+}
+
+// sessionRevocation returns the revocation of the bindings of a session of
+// the local state, creating the provider at each call so that the
+// credentials are read again. Its events go to events.
+func sessionRevocation(ctx context.Context, s state.Session, dryRun bool, events eventSinks) func() error {
+	log := logger.With(slog.String(logger.SessionKey, s.ID), slog.String("project", s.Project), slog.String("provider", s.Provider))
+	granted, opts := sessionGrant(s)
+	return func() error {
+		p, err := newGCPProviderWith(ctx, dryRun, events, provider.WithLogger(log), provider.WithServiceAccountCaller(cfg.AllowServiceAccountCaller), requestReasonOption(s.Reason, ""))
+		if err != nil {
+			return fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		p.AdoptGrantedRoles(granted)
+		err = p.Revoke(opts)
+		events.flush()
+		return err
+	}
+}
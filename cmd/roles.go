@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/suggest"
+)
+
+var rolesCmd = &cobra.Command{
+	Use:   "roles [query]",
+	Short: "Browse and search the roles that can be granted",
+	Long: `List the predefined IAM roles, and the custom roles of a project with
+--project, with their launch stage and number of permissions. A query filters
+the roles whose ID, title, or description contains it or each of its words,
+then those whose ID or title contains its characters in order, best matches
+first.
+
+Only GA roles are listed unless --stage names the launch stages to list, e.g.
+--stage=GA,BETA, or --stage=all. Custom roles are created at the ALPHA stage
+unless given another.
+
+With --permissions, the permissions of a role are printed instead, one per
+line, whatever its stage.
+
+The predefined roles are cached in ~/.gta/roles.json for a day, shared with
+gta suggest; use --refresh to fetch them again. Custom roles are always
+fetched.
+
+Example:
+  gta roles logging
+  gta roles "log view"
+  gta roles --project=my-project deploy
+  gta roles --stage=GA,BETA bigquery
+  gta roles --permissions roles/logging.viewer
+  gta roles --permissions projects/my-project/roles/deployer
+  gta roles storage --output=json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRoles,
+}
+
+// rolesOptions are the options of gta roles
+type rolesOptions struct {
+	Project     string
+	Query       string
+	Permissions string
+	Output      string
+	Refresh     bool
+	// Stages are the launch stages listed, every stage when nil
+	Stages []string
+}
+
+// roleListing is a role as listed by gta roles
+type roleListing struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Stage       string `json:"stage"`
+	Permissions int    `json:"permission_count"`
+}
+
+func init() {
+	flags := rolesCmd.Flags()
+	flags.StringP("project", "p", "", "Also list the custom roles of this project")
+	flags.String("permissions", "", "Print the permissions of this role instead, e.g. roles/logging.viewer")
+	flags.StringP("output", "o", outputTable, "Print the roles as a table or json")
+	flags.Bool("refresh", false, "Fetch the predefined roles again instead of using the cache")
+	flags.StringSlice("stage", []string{provider.StageGA}, "Launch stages of the roles listed, e.g. GA,BETA, or all")
+	_ = rolesCmd.RegisterFlagCompletionFunc("stage", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return append(append([]string{}, provider.RoleStages...), stageAll), cobra.ShellCompDirectiveNoFileComp
+	})
+	_ = rolesCmd.RegisterFlagCompletionFunc("permissions", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		stages, err := parseStages(flagStringSlice(cmd, "stage"))
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeRoles(toComplete, stages), cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.AddCommand(rolesCmd)
+}
+
+func runRoles(cmd *cobra.Command, args []string) error {
+	o := rolesOptions{
+		Project:     stringOption(cmd, "project", ""),
+		Permissions: flagString(cmd, "permissions"),
+		Output:      flagString(cmd, "output"),
+		Refresh:     flagBool(cmd, "refresh"),
+	}
+	if len(args) > 0 {
+		o.Query = args[0]
+	}
+	stages, err := parseStages(flagStringSlice(cmd, "stage"))
+	if err != nil {
+		return err
+	}
+	o.Stages = stages
+	switch o.Output {
+	case outputTable, outputJSON:
+	default:
+		return fmt.Errorf("invalid output format %q (expected table or json)", o.Output)
+	}
+	if o.Permissions != "" && o.Query != "" {
+		return fmt.Errorf("--permissions prints a single role and cannot be combined with a query")
+	}
+
+	if o.Permissions != "" {
+		role, err := findRole(cmd, &o)
+		if err != nil {
+			return err
+		}
+		return writeRolePermissions(os.Stdout, o.Output, role)
+	}
+
+	roles, err := grantableRoles(cmd, &o)
+	if err != nil {
+		return err
+	}
+	found := suggest.Search(filterStages(roles, o.Stages), o.Query)
+	if len(found) == 0 {
+		if o.Stages != nil && len(suggest.Search(roles, o.Query)) > 0 {
+			return fmt.Errorf("no %s role matches %q, list the roles of other launch stages with --stage", strings.Join(o.Stages, " or "), o.Query)
+		}
+		return fmt.Errorf("no role matches %q", o.Query)
+	}
+	return writeRoles(os.Stdout, o.Output, found)
+}
+
+// grantableRoles returns the predefined roles, and the custom roles of the
+// project of o when set
+func grantableRoles(cmd *cobra.Command, o *rolesOptions) ([]provider.PredefinedRole, error) {
+	catalog, err := loadRoleCatalog(cmd.Context(), o.Refresh)
+	if err != nil {
+		return nil, err
+	}
+	roles := catalog.Roles()
+	if o.Project == "" {
+		return roles, nil
+	}
+	custom, err := customRoles(cmd, o.Project)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]provider.PredefinedRole{}, roles...), custom...), nil
+}
+
+// findRole returns the role named by --permissions: a custom role of its
+// project for projects/PROJECT/roles/ROLE, and otherwise a predefined role
+func findRole(cmd *cobra.Command, o *rolesOptions) (provider.PredefinedRole, error) {
+	name := o.Permissions
+	if !strings.HasPrefix(name, "projects/") && !strings.HasPrefix(name, "roles/") {
+		name = "roles/" + name
+	}
+	if strings.HasPrefix(name, "projects/") {
+		parts := strings.Split(name, "/")
+		if len(parts) != 4 || parts[1] == "" || parts[2] != "roles" || parts[3] == "" {
+			return provider.PredefinedRole{}, fmt.Errorf("invalid custom role %q (expected projects/PROJECT/roles/ROLE)", name)
+		}
+		custom, err := customRoles(cmd, parts[1])
+		if err != nil {
+			return provider.PredefinedRole{}, err
+		}
+		for _, role := range custom {
+			if role.Name == name {
+				return role, nil
+			}
+		}
+		return provider.PredefinedRole{}, fmt.Errorf("no custom role %s in project %s", name, parts[1])
+	}
+
+	catalog, err := loadRoleCatalog(cmd.Context(), o.Refresh)
+	if err != nil {
+		return provider.PredefinedRole{}, err
+	}
+	if role, ok := catalog.Role(name); ok {
+		return role, nil
+	}
+	return provider.PredefinedRole{}, fmt.Errorf("no predefined role %s, search for it with: gta roles %s", name, strings.TrimPrefix(name, "roles/"))
+}
+
+// customRoles fetches the custom roles of project
+func customRoles(cmd *cobra.Command, project string) ([]provider.PredefinedRole, error) {
+	p, err := newGCPProvider(cmd.Context(), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	return p.CustomRoles(project)
+}
+
+// writeRoles prints roles as a table or JSON
+func writeRoles(w io.Writer, output string, roles []provider.PredefinedRole) error {
+	listings := make([]roleListing, len(roles))
+	for i, role := range roles {
+		listings[i] = roleListing{
+			Name:        role.Name,
+			Title:       role.Title,
+			Description: role.Description,
+			Stage:       role.Stage,
+			Permissions: len(role.Permissions),
+		}
+	}
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(listings)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tTITLE\tSTAGE\tPERMISSIONS")
+	for _, l := range listings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", l.Name, l.Title, l.Stage, l.Permissions)
+	}
+	return tw.Flush()
+}
+
+// writeRolePermissions prints the permissions of role, one per line, or the
+// role with its permissions as JSON
+func writeRolePermissions(w io.Writer, output string, role provider.PredefinedRole) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(role)
+	}
+	for _, permission := range role.Permissions {
+		fmt.Fprintln(w, permission)
+	}
+	return nil
+}
+
+// stageAll selects the roles of every launch stage with --stage
+const stageAll = "all"
+
+// parseStages validates the launch stages given with --stage, returning them
+// in upper case, or nil for all
+func parseStages(values []string) ([]string, error) {
+	var stages []string
+	for _, value := range values {
+		if strings.EqualFold(value, stageAll) {
+			return nil, nil
+		}
+		stage := strings.ToUpper(strings.TrimSpace(value))
+		if !slices.Contains(provider.RoleStages, stage) {
+			return nil, fmt.Errorf("invalid stage %q (expected %s, or %s)", value, strings.Join(provider.RoleStages, ", "), stageAll)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// filterStages returns the roles at one of stages, all of them when stages
+// is nil
+func filterStages(roles []provider.PredefinedRole, stages []string) []provider.PredefinedRole {
+	if stages == nil {
+		return roles
+	}
+	var filtered []provider.PredefinedRole
+	for _, role := range roles {
+		if slices.Contains(stages, role.Stage) {
+			filtered = append(filtered, role)
+		}
+	}
+	return filtered
+}
@@ -1,24 +1,66 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/errutil"
+	"github.com/yckao/gta/pkg/httpclient"
 	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/metrics"
+	"github.com/yckao/gta/pkg/notify"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/ratelimit"
+	"github.com/yckao/gta/pkg/workerpool"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
+// The global flags are shared by every command; the flags of a command are
+// resolved into its own options struct instead, see options.go
 var (
 	cfgFile   string
-	project   string
-	user      string
-	ttl       time.Duration
 	verbosity string
 	logFormat string
 	quietMode bool
-	dryRun    bool
+	profile   string
+
+	// debugHTTP logs the sanitized URL of every API call
+	debugHTTP bool
+	// noClockCorrection computes expiries with the local clock as is
+	noClockCorrection bool
+	// noRevocationCheck skips verifying that revoked bindings are gone
+	noRevocationCheck bool
+
+	// cfg is the validated config file, loaded before any command runs
+	cfg = &config.Config{}
+
+	// metricsRegistry collects metrics when running at debug level or in a
+	// long-running mode; it is nil otherwise so the CLI path pays no cost
+	metricsRegistry *metrics.Registry
+
+	// eventSink receives lifecycle events; it is created on first use and
+	// flushed when the command finishes
+	eventSink audit.Sink
+	// dispatcher delivers notifications, nil when none are configured
+	dispatcher *notify.Dispatcher
+	// eventWebhook posts lifecycle events to the generic webhook, nil when none is configured
+	eventWebhook *notify.Webhook
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -28,7 +70,9 @@ var rootCmd = &cobra.Command{
 	Long: `Grant Temporary Access (gta) is a CLI tool for managing temporary IAM roles
 across different cloud providers. It currently supports GCP and allows you to
 grant temporary permissions that are automatically revoked when the program exits.`,
-	PersistentPreRunE: setupLogging,
+	PersistentPreRunE: setup,
+	// Execute reports errors itself so they are printed once
+	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("please specify a command (e.g., grant, list)")
 	},
@@ -36,26 +80,195 @@ grant temporary permissions that are automatically revoked when the program exit
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() error {
-	return rootCmd.Execute()
+	// Every command runs with a context cancelled on interrupt, aborting the
+	// API calls in flight
+	ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals()...)
+	defer stop()
+
+	// Every command has been added by now
+	registerProjectCompletion(rootCmd)
+	err := rootCmd.ExecuteContext(ctx)
+	if err != nil {
+		reportError(err)
+	}
+	return err
+}
+
+// Exit codes of gta
+const (
+	// ExitFailure is the exit code of any error without a more specific code
+	ExitFailure = 1
+	// ExitUsage means the command was given too little to act on, e.g. gta
+	// status with several active sessions to choose from
+	ExitUsage = 2
+	// ExitServiceDisabled means a required Google API is disabled
+	ExitServiceDisabled = 3
+	// ExitSessionEnded means the session gta status reports on has expired
+	// or was revoked
+	ExitSessionEnded = 4
+)
+
+// exitError is an error exiting gta with a code of its own
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// ExitCode returns the process exit code for an error returned by Execute
+func ExitCode(err error) int {
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) && cmdErr.code > 0 {
+		return cmdErr.code
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	if _, ok := provider.AsServiceDisabled(err); ok {
+		return ExitServiceDisabled
+	}
+	return ExitFailure
+}
+
+// reportError logs err once: as a single line in quiet mode, as a record
+// with its details in JSON mode, see errorAttrs, as its indented chain of
+// causes otherwise, followed at debug level by the stack of unexpected
+// errors. A disabled API is reported with the command that enables it
+// instead, its full chain only at debug level.
+func reportError(err error) {
+	disabled, isDisabled := provider.AsServiceDisabled(err)
+	switch {
+	case logFormat == string(logger.FormatJSON):
+		msg := err.Error()
+		if isDisabled {
+			msg = disabled.Error()
+		}
+		logger.ErrorAttrs(msg, errorAttrs(err)...)
+	case isDisabled:
+		logger.Error("%v", disabled)
+	case quietMode:
+		logger.Error("%v", err)
+	default:
+		logger.Error("%s", errutil.Format(err))
+	}
+	if isDisabled {
+		logger.Info("Enable it with: %s", disabled.Command())
+		logger.Debug("%s", errutil.Format(err))
+		return
+	}
+	if trace := errutil.StackTrace(err); trace != "" && provider.Unexpected(err) {
+		logger.Debug("Stack trace:\n%s", trace)
+	}
+}
+
+// errorAttrs returns the attributes of a JSON record describing err: its
+// details, and those of each role when roles failed
+func errorAttrs(err error) []slog.Attr {
+	attrs := []slog.Attr{slog.Any("error", provider.Details(err))}
+	if failed := provider.FailedRoles(err); len(failed) > 0 {
+		attrs = append(attrs, slog.Any("failed", failed.Details()))
+	}
+	return attrs
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnFinalize(waitPendingRevocations, dumpMetrics, closeEventSink)
 
 	flags := rootCmd.PersistentFlags()
 	flags.StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gta.yaml)")
 	flags.StringVarP(&verbosity, "verbosity", "v", "info", "log level (debug, info, warn, error)")
 	flags.StringVar(&logFormat, "format", "plain", "log format (plain, json)")
 	flags.BoolVarP(&quietMode, "quiet", "q", false, "quiet mode, only show errors")
+	flags.StringVar(&profile, "profile", "", "config profile to use (default is the profile set in config)")
+	flags.BoolVar(&debugHTTP, "debug-http", false, "log the sanitized URL of every API call (implies --verbosity=debug)")
+	flags.BoolVar(&noClockCorrection, "no-clock-correction", false, "compute expiries with the local clock even when it is off from Google's")
+	flags.BoolVar(&noRevocationCheck, "no-revocation-check", false, "do not read policies again to verify that revoked bindings are gone")
+	flags.Int("max-retries", 0, "retries of failing API calls in every category, 0 for none (default per category, see gta doctor)")
+	flags.Var(new(duration.Value), "retry-max-elapsed", "give up retrying an API call after this long, e.g. 10s (default per category, see gta doctor)")
+	flags.Int("concurrency", 0, fmt.Sprintf("projects handled at once by operations over many projects, 1 for serial (default %d)", workerpool.DefaultWorkers))
 
 	// Add commands
 	rootCmd.AddCommand(grantCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(webhookCmd)
+	rootCmd.AddCommand(requestCmd)
+	rootCmd.AddCommand(requestsCmd)
+	rootCmd.AddCommand(approveCmd)
+	rootCmd.AddCommand(installCleanerCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(suggestCmd)
+	rootCmd.AddCommand(sessionsCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(waitCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(scheduleCmd)
+	rootCmd.AddCommand(stateCmd)
+}
+
+// setup loads the config file and configures logging before any command runs.
+// An invalid config file fails the command rather than falling back to defaults.
+func setup(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	loaded, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	cfg = loaded
+
+	if profile, err = cfg.ResolveProfile(profile); err != nil {
+		return err
+	}
+
+	if err := setupLogging(cmd, args); err != nil {
+		return err
+	}
+	if cfg.Path() != "" {
+		logger.Debug("Using config file: %s", cfg.Path())
+	}
+	if profile != "" {
+		logger.Debug("Using profile: %s", profile)
+	}
+	if apiRetries, err = resolveRetries(cmd); err != nil {
+		return err
+	}
+	if concurrency, err = resolveConcurrency(cmd); err != nil {
+		return err
+	}
+	if err := resolveProjectFlag(cmd); err != nil {
+		return err
+	}
+	// A dry run changes nothing, not even the local state
+	if dryRun, _ := boolOption(cmd, "dry-run"); !dryRun {
+		pruneStaleSessions()
+		retryPendingRevocations(cmd)
+	}
+	return nil
 }
 
 // setupLogging configures the logging system based on command-line flags
 func setupLogging(cmd *cobra.Command, args []string) error {
+	// Flags parsed fine, so later errors are not usage errors
+	cmd.SilenceUsage = true
+
+	// Fall back to the config file for settings not given on the command line
+	if !cmd.Flags().Changed("verbosity") && cfg.Verbosity != "" {
+		verbosity = cfg.Verbosity
+	}
+	if !cmd.Flags().Changed("format") && cfg.Format != "" {
+		logFormat = cfg.Format
+	}
+
 	// Set up logging based on verbosity flags
 	if quietMode {
 		logger.SetLevel(logger.LevelError)
@@ -64,8 +277,12 @@ func setupLogging(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
+		if debugHTTP {
+			level = logger.LevelDebug
+		}
 		logger.SetLevel(level)
 	}
+	logger.SetDebugHTTP(debugHTTP)
 
 	// Set up logging format
 	format, err := logger.ParseFormat(logFormat)
@@ -76,34 +293,525 @@ func setupLogging(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if logger.Enabled(logger.LevelDebug) {
+		metricsRegistry = metrics.NewRegistry()
+	}
+
 	logger.Debug("Starting command execution: %s", cmd.Name())
 	logger.Debug("Arguments: %v", args)
 	return nil
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
+// newGCPProvider creates a GCP provider configured from the loaded config,
+// followed by any extra options
+func newGCPProvider(ctx context.Context, dryRun bool, extra ...provider.GCPProviderOption) (*provider.GCPProvider, error) {
+	events, err := commandEventSinks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newGCPProviderWith(ctx, dryRun, events, extra...)
+}
+
+// newGCPProviderWith creates a GCP provider delivering its events to events
+func newGCPProviderWith(ctx context.Context, dryRun bool, events eventSinks, extra ...provider.GCPProviderOption) (*provider.GCPProvider, error) {
+	partial, err := provider.ParsePartialFailurePolicy(cfg.PartialFailure)
+	if err != nil {
+		return nil, err
+	}
+
+	network, err := networkTransport()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []provider.GCPProviderOption{
+		provider.WithTransport(network),
+		provider.WithRateLimiter(apiLimiter()),
+		provider.WithMetrics(metricsRecorder()),
+		provider.WithPartialFailurePolicy(partial),
+		provider.WithEventSink(events.sink),
+		provider.WithBroadRoles(cfg.BroadRole),
+		provider.WithClockCorrection(!noClockCorrection),
+		provider.WithRevocationCheck(!noRevocationCheck),
+		provider.WithRetryPolicies(apiRetries),
+		provider.WithBindingPrefix(cfg.BindingTitlePrefix(), cfg.KnownBindingPrefixes...),
+		provider.WithPolicyLimits(cfg.PolicyWarnLimits()),
+		provider.WithGranterRoster(granterRoster()),
+	}
+	if events.webhook != nil && events.webhook.Strict() {
+		logger.Debug("Grants must be registered with the webhook before they are applied")
+		opts = append(opts, provider.WithGrantHook(events.webhook.Register))
+	}
+	opts = append(opts, extra...)
+	return provider.NewGCPProvider(ctx, dryRun, opts...)
+}
+
+// granterRosterCacheFile is the name of the cache of the group memberships
+// of granter_roster in the data directory
+const granterRosterCacheFile = "granters.json"
+
+// granterRoster returns the roster of granter_roster, caching group
+// memberships in the data directory when it can be found
+func granterRoster() *provider.GranterRoster {
+	var cachePath string
+	if dir, err := config.DataDir(); err == nil {
+		cachePath = filepath.Join(dir, granterRosterCacheFile)
+	}
+	return cfg.Roster(cachePath)
+}
+
+// requestReasonOption sends reason, preceded by the incident of a
+// break-glass grant, in the X-Goog-Request-Reason header of the API calls of
+// a provider, unless http.disable_request_reason is set
+func requestReasonOption(reason, incident string) provider.GCPProviderOption {
+	if cfg.HTTPFor(profile).DisableRequestReason {
+		return provider.WithRequestReason("")
+	}
+	if incident != "" {
+		reason = strings.TrimSuffix("incident "+incident+": "+reason, ": ")
+	}
+	return provider.WithRequestReason(reason)
+}
+
+// useSessionLogger makes every following record of the command carry the
+// session ID, when there is a session, the project, and the provider
+func useSessionLogger(sessionID, project string) *logger.Logger {
+	var attrs []slog.Attr
+	if sessionID != "" {
+		attrs = append(attrs, slog.String(logger.SessionKey, sessionID))
+	}
+	attrs = append(attrs, slog.String("project", project), slog.String("provider", "gcp"))
+	log := logger.With(attrs...)
+	logger.SetDefault(log)
+	return log
+}
+
+// apiRetries holds the retry policies of API calls, resolved by setup
+var apiRetries provider.RetryPolicies
+
+// resolveRetries resolves the retry policies of the config, with
+// --max-retries and --retry-max-elapsed applied to every category
+func resolveRetries(cmd *cobra.Command) (provider.RetryPolicies, error) {
+	var flags config.RetrySettings
+	if value, ok := lookupOption(cmd, "max-retries"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid --max-retries %q (expected a number of retries, 0 for none)", value)
+		}
+		flags.MaxRetries = &n
+	}
+	if value, ok := lookupOption(cmd, "retry-max-elapsed"); ok {
+		d, err := duration.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --retry-max-elapsed: %v", err)
+		}
+		elapsed := config.Duration(d)
+		flags.MaxElapsed = &elapsed
+	}
+	if flags.MaxRetries != nil && *flags.MaxRetries == 0 && flags.MaxElapsed != nil && *flags.MaxElapsed > 0 {
+		return nil, fmt.Errorf("--retry-max-elapsed has no effect with --max-retries=0, leave it out or allow retries")
+	}
+
+	policies := cfg.RetryPolicies()
+	for _, category := range provider.RetryCategories {
+		policy := flags.Apply(policies[category])
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid retries of %s: %v", category, err)
+		}
+		policies[category] = policy
+	}
+	return policies, nil
+}
+
+// concurrency is the number of workers of operations over many projects,
+// resolved by setup
+var concurrency = workerpool.DefaultWorkers
+
+// resolveConcurrency resolves the number of workers of operations over many
+// projects from --concurrency, or else the config
+func resolveConcurrency(cmd *cobra.Command) (int, error) {
+	value, ok := lookupOption(cmd, "concurrency")
+	if !ok {
+		if cfg.Concurrency > 0 {
+			return cfg.Concurrency, nil
+		}
+		return workerpool.DefaultWorkers, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > workerpool.MaxWorkers {
+		return 0, fmt.Errorf("invalid --concurrency %q (expected a number of workers from 1 to %d)", value, workerpool.MaxWorkers)
+	}
+	return n, nil
+}
+
+// fanOutWorkers returns the number of workers handling items at once,
+// at most the configured concurrency
+func fanOutWorkers(items int) int {
+	if items < concurrency {
+		return items
+	}
+	return concurrency
+}
+
+var (
+	limiterOnce sync.Once
+	limiter     *ratelimit.Limiter
+)
+
+// apiLimiter returns the rate limiter of the process, shared by every
+// provider so that concurrent workers stay within the configured rate
+func apiLimiter() *ratelimit.Limiter {
+	limiterOnce.Do(func() {
+		rate := ratelimit.DefaultRate
+		if cfg.RateLimit.QPS > 0 {
+			rate = cfg.RateLimit.QPS
+		}
+		burst := ratelimit.DefaultBurst
+		if cfg.RateLimit.Burst > 0 {
+			burst = cfg.RateLimit.Burst
+		}
+		logger.Debug("Rate limiting API calls to %.2f requests/s (burst %d)", rate, burst)
+		limiter = ratelimit.New(rate, burst)
+	})
+	return limiter
+}
+
+var (
+	transportOnce sync.Once
+	httpTransport http.RoundTripper
+	transportErr  error
+)
+
+// networkTransport returns the transport configured under http for the active
+// profile, created once so that connections are reused, or nil when the
+// default transport is used
+func networkTransport() (http.RoundTripper, error) {
+	transportOnce.Do(func() {
+		h := cfg.HTTPFor(profile)
+		c := httpclient.Config{
+			Proxy:          h.Proxy,
+			CAFile:         h.CAFile,
+			CertFile:       h.CertFile,
+			KeyFile:        h.KeyFile,
+			ConnectTimeout: time.Duration(h.ConnectTimeout),
+			ReadTimeout:    time.Duration(h.ReadTimeout),
+		}
+		if c.IsZero() {
+			return
+		}
+		t, err := httpclient.NewTransport(c)
+		if err != nil {
+			transportErr = fmt.Errorf("invalid http config: %w", err)
+			return
+		}
+		httpTransport = t
+	})
+	return httpTransport, transportErr
+}
+
+// apiClientOptions returns options for Google API clients created outside the
+// provider, so that their calls use the configured transport and are logged at
+// debug level like the provider's
+func apiClientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	network, err := networkTransport()
+	if err != nil {
+		return nil, err
+	}
+	if network == nil && !logger.Enabled(logger.LevelDebug) {
+		return nil, nil
+	}
+	if network == nil {
+		network = http.DefaultTransport
 	} else {
-		// Find home directory.
-		home, err := os.UserHomeDir()
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: network})
+	}
+	transport, err := htransport.NewTransport(ctx, &logger.Transport{Base: network},
+		option.WithScopes("https://www.googleapis.com/auth/cloud-platform"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP transport: %v", err)
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: transport})}, nil
+}
+
+// metricsRecorder returns the active metrics recorder
+func metricsRecorder() metrics.Recorder {
+	if metricsRegistry == nil {
+		return metrics.Nop{}
+	}
+	return metricsRegistry
+}
+
+// dumpMetrics logs the collected metrics at debug level on exit
+func dumpMetrics() {
+	if metricsRegistry == nil {
+		return
+	}
+	logger.Debug("Metrics:\n%s", metricsRegistry.String())
+}
+
+// eventSinks are the sinks of the lifecycle events of a command, or of the
+// retries of pending revocations running alongside it
+type eventSinks struct {
+	// sink delivers events to the local audit log, the exporters, and the
+	// others below
+	sink audit.Sink
+	// dispatcher delivers notifications, nil when none are configured
+	dispatcher *notify.Dispatcher
+	// webhook posts lifecycle events to the generic webhook, nil when none is configured
+	webhook *notify.Webhook
+}
+
+// flush sends notifications for the operation that just completed
+func (e eventSinks) flush() []notify.Notification {
+	if e.dispatcher == nil {
+		return nil
+	}
+	return e.dispatcher.Flush()
+}
+
+// breakGlassRouted tells whether the notifications of break_glass were added
+// to the dispatcher
+var breakGlassRouted bool
+
+// newEventSink creates the sink delivering lifecycle events of the command to
+// the local audit log and configured exporters, including the break-glass
+// notifiers when breakGlass is set. The sink is created by the first call and
+// reused after, the break-glass notifiers joining it when a later call sets
+// breakGlass.
+func newEventSink(ctx context.Context, breakGlass bool) (audit.Sink, error) {
+	if eventSink != nil {
+		if breakGlass && !breakGlassRouted && cfg.BreakGlass.Notifications != nil {
+			routes, err := newRoutes(*cfg.BreakGlass.Notifications)
+			if err != nil {
+				return nil, err
+			}
+			dispatcher.Add(routes...)
+			breakGlassRouted = true
+		}
+		return eventSink, nil
+	}
+
+	events, err := newEventSinks(ctx, breakGlass)
+	if err != nil {
+		return nil, err
+	}
+	eventSink, dispatcher, eventWebhook = events.sink, events.dispatcher, events.webhook
+	breakGlassRouted = breakGlass
+	return eventSink, nil
+}
+
+// commandEventSinks returns the sinks of the events of the command, creating
+// them on first use
+func commandEventSinks(ctx context.Context) (eventSinks, error) {
+	if _, err := newEventSink(ctx, false); err != nil {
+		return eventSinks{}, err
+	}
+	return eventSinks{sink: eventSink, dispatcher: dispatcher, webhook: eventWebhook}, nil
+}
+
+// newEventSinks creates sinks delivering lifecycle events to the local audit
+// log and configured exporters, including the break-glass notifiers when
+// breakGlass is set
+func newEventSinks(ctx context.Context, breakGlass bool) (eventSinks, error) {
+	var events eventSinks
+	var sinks audit.Multi
+	// Events the exporters fail to deliver are recorded in the audit log
+	var undelivered audit.Sink
+	if !cfg.Audit.Disabled {
+		path, err := cfg.AuditPath()
+		if err != nil {
+			return events, err
+		}
+		cipher, err := fileCipher()
+		if err != nil {
+			return events, err
+		}
+		log, err := audit.NewLog(path, cipher)
+		if err != nil {
+			return events, err
+		}
+		sinks = append(sinks, log)
+		undelivered = log
+	}
+	if cfg.Audit.BigQuery != nil {
+		exporter, err := newBigQueryExporter(ctx)
 		if err != nil {
-			logger.Error("Error finding home directory: %v", err)
-			os.Exit(1)
+			return events, err
 		}
+		sinks = append(sinks, exporter)
+	}
+	if cfg.Audit.PubSub != nil {
+		exporter, err := newPubSubExporter(ctx, undelivered)
+		if err != nil {
+			return events, err
+		}
+		sinks = append(sinks, exporter)
+	}
+	routes, err := newRoutes(cfg.NotificationsFor(profile))
+	if err != nil {
+		return events, err
+	}
+	if breakGlass && cfg.BreakGlass.Notifications != nil {
+		breakGlassRoutes, err := newRoutes(*cfg.BreakGlass.Notifications)
+		if err != nil {
+			return events, err
+		}
+		routes = append(routes, breakGlassRoutes...)
+	}
+	// Environments add their routes once the project is classified, and the
+	// break-glass notifiers once a grant is known to be break-glass
+	if len(routes) > 0 || cfg.EnvironmentNotifications() || cfg.BreakGlass.Notifications != nil {
+		events.dispatcher = notify.NewDispatcher(routes...)
+		sinks = append(sinks, events.dispatcher)
+	}
+	if webhook := newWebhook(); webhook != nil {
+		events.webhook = webhook
+		sinks = append(sinks, webhook)
+	}
 
-		// Search config in home directory with name ".gta" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigType("yaml")
-		viper.SetConfigName(".gta")
+	events.sink = sinks
+	return events, nil
+}
+
+// newPubSubExporter creates the Pub/Sub exporter from config, recording the
+// events it fails to publish in undelivered
+func newPubSubExporter(ctx context.Context, undelivered audit.Sink) (*audit.PubSubExporter, error) {
+	opts, err := apiClientOptions(ctx)
+	if err != nil {
+		return nil, err
 	}
+	return audit.NewPubSubExporter(ctx, cfg.Audit.PubSub.Topic, undelivered, opts...)
+}
 
-	viper.AutomaticEnv() // read in environment variables that match
+// newBigQueryExporter creates the BigQuery exporter from config
+func newBigQueryExporter(ctx context.Context) (*audit.BigQueryExporter, error) {
+	bq := cfg.Audit.BigQuery
+	opts, err := apiClientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewBigQueryExporter(ctx, audit.BigQueryConfig{
+		Project:    bq.Project,
+		Dataset:    bq.Dataset,
+		Table:      bq.Table,
+		DisableDDL: bq.DisableDDL,
+	}, opts...)
+}
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		logger.Debug("Using config file: %s", viper.ConfigFileUsed())
+// newRoutes creates the notifiers of a notifications block and the routes
+// delivering to them, every notification to every notifier unless the block
+// sets routes
+func newRoutes(settings config.NotificationsConfig) ([]notify.Route, error) {
+	notifiers, err := newNotifiers(settings)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings.Routes) == 0 {
+		var routes []notify.Route
+		for _, name := range config.Notifiers {
+			if notifier, ok := notifiers[name]; ok {
+				routes = append(routes, notify.Unfiltered(notifier)...)
+			}
+		}
+		return routes, nil
+	}
+
+	var routes []notify.Route
+	for i, r := range settings.Routes {
+		filter, err := r.Filter()
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification route %d: %v", i, err)
+		}
+		notifier, ok := notifiers[r.Notifier]
+		if !ok {
+			return nil, fmt.Errorf("invalid notification route %d: %s is not configured", i, r.Notifier)
+		}
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("routes[%d]", i)
+		}
+		routes = append(routes, notify.Route{Name: name, Notifier: notifier, Filter: filter})
+	}
+	return routes, nil
+}
+
+// newNotifiers creates the notifiers of a notifications block by the name
+// routes give them
+func newNotifiers(settings config.NotificationsConfig) (map[string]notify.Notifier, error) {
+	notifiers := make(map[string]notify.Notifier)
+	if settings.Slack != nil {
+		notifiers[config.NotifierSlack] = notify.NewSlackNotifier(settings.Slack.WebhookURL)
+	}
+	if settings.GoogleChat != nil {
+		notifiers[config.NotifierGoogleChat] = notify.NewChatNotifier(settings.GoogleChat.WebhookURL)
+	}
+	if settings.Teams != nil {
+		notifiers[config.NotifierTeams] = notify.NewTeamsNotifier(settings.Teams.WebhookURL)
+	}
+	if e := settings.Email; e != nil {
+		password := e.Password
+		if e.PasswordEnv != "" {
+			password = os.Getenv(e.PasswordEnv)
+		}
+		port := e.Port
+		if port == 0 {
+			port = 587
+		}
+		emailNotifier, err := notify.NewEmailNotifier(notify.EmailConfig{
+			Host:            e.Host,
+			Port:            port,
+			Username:        e.Username,
+			Password:        password,
+			From:            e.From,
+			To:              e.To,
+			TLS:             e.TLS,
+			SubjectTemplate: e.SubjectTemplate,
+			BodyTemplate:    e.BodyTemplate,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create email notifier: %v", err)
+		}
+		notifiers[config.NotifierEmail] = emailNotifier
+	}
+	return notifiers, nil
+}
+
+// newWebhook creates the generic event webhook configured for the active
+// profile, or nil if there is none
+func newWebhook() *notify.Webhook {
+	w := cfg.NotificationsFor(profile).Webhook
+	if w == nil {
+		return nil
+	}
+	secret := w.Secret
+	if w.SecretEnv != "" {
+		secret = os.Getenv(w.SecretEnv)
+		if secret == "" {
+			logger.Warn("Webhook secret variable %s is empty, events will be sent unsigned", w.SecretEnv)
+		}
+	}
+	return notify.NewWebhook(notify.WebhookConfig{
+		URL:         w.URL,
+		Secret:      secret,
+		Strict:      w.Strict,
+		MaxAttempts: w.MaxAttempts,
+	})
+}
+
+// flushNotifications sends notifications for the operation that just completed
+func flushNotifications() []notify.Notification {
+	if dispatcher == nil {
+		return nil
+	}
+	return dispatcher.Flush()
+}
+
+// closeEventSink flushes buffered events on exit
+func closeEventSink() {
+	if eventSink == nil {
+		return
+	}
+	if err := eventSink.Close(); err != nil {
+		logger.Warn("Failed to flush audit events: %v", err)
 	}
 }
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/workerpool"
+	"google.golang.org/api/googleapi"
+)
+
+func TestExitCode(t *testing.T) {
+	disabled := &provider.ServiceDisabledError{Service: "cloudresourcemanager.googleapis.com", Project: "p", Err: &googleapi.Error{Code: 403}}
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{errors.New("failed"), ExitFailure},
+		{&googleapi.Error{Code: 403, Message: "The caller does not have permission"}, ExitFailure},
+		{disabled, ExitServiceDisabled},
+		{fmt.Errorf("failed to list roles: %w", disabled), ExitServiceDisabled},
+		{&exitError{err: errors.New("session ended"), code: ExitSessionEnded}, ExitSessionEnded},
+	} {
+		if got := ExitCode(tc.err); got != tc.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestResolveConcurrency(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		env     string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", want: workerpool.DefaultWorkers},
+		{name: "config", config: "concurrency: 3\n", want: 3},
+		{name: "flag over config", config: "concurrency: 3\n", args: []string{"--concurrency=1"}, want: 1},
+		{name: "environment", env: "12", want: 12},
+		{name: "largest", args: []string{"--concurrency=" + fmt.Sprint(workerpool.MaxWorkers)}, want: workerpool.MaxWorkers},
+		{name: "zero", args: []string{"--concurrency=0"}, wantErr: true},
+		{name: "too many", args: []string{"--concurrency=" + fmt.Sprint(workerpool.MaxWorkers+1)}, wantErr: true},
+		{name: "not a number", env: "many", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, tc.config)
+			t.Setenv(optionEnv("concurrency"), tc.env)
+			cmd := &cobra.Command{Use: "test"}
+			cmd.Flags().Int("concurrency", 0, "")
+			if err := cmd.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := resolveConcurrency(cmd)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("resolveConcurrency = %d, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("resolveConcurrency = %d, %v, want %d", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestFanOutWorkers(t *testing.T) {
+	saved := concurrency
+	t.Cleanup(func() { concurrency = saved })
+	for _, tc := range []struct{ concurrency, items, want int }{
+		{8, 100, 8},
+		{8, 3, 3},
+		{1, 100, 1},
+		{8, 0, 0},
+	} {
+		concurrency = tc.concurrency
+		if got := fanOutWorkers(tc.items); got != tc.want {
+			t.Errorf("fanOutWorkers(%d) with --concurrency=%d = %d, want %d", tc.items, tc.concurrency, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/schedule"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// schedulePollInterval bounds how long gta schedule run sleeps, so that
+// schedules added or removed meanwhile are picked up
+const schedulePollInterval = time.Minute
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Manage grants recurring on a schedule",
+}
+
+var scheduleAddCmd = &cobra.Command{
+	Use:   "add [roles...]",
+	Short: "Add a grant recurring on a cron schedule",
+	Long: `Add a grant recurring on a cron schedule. Every time the cron expression fires,
+gta schedule run grants the roles for the TTL and revokes them at the end of
+the window. The schedule is stored in the local state of the current profile.
+
+Cron expressions have five fields, minute, hour, day of month, month, and day
+of week, in the local time zone, or are one of @hourly, @daily, @weekly,
+@monthly, or @yearly.
+
+Example:
+  # Grant roles/bigquery.user on weekdays from 09:00 to 12:00
+  gta schedule add --cron "0 9 * * 1-5" --ttl 3h roles/bigquery.user -p analytics`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runScheduleAdd,
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the schedules of the current profile",
+	Args:  cobra.NoArgs,
+	RunE:  runScheduleList,
+}
+
+var scheduleRemoveCmd = &cobra.Command{
+	Use:   "remove [id...]",
+	Short: "Remove schedules",
+	Long: `Remove schedules. A window in progress is revoked by gta schedule run once it
+notices the removal.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runScheduleRemove,
+}
+
+var scheduleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Carry out the schedules of the current profile until interrupted",
+	Long: `Carry out the schedules of the current profile until interrupted: grant the
+roles of a schedule when its window starts and revoke them when it ends,
+recording, logging, and notifying each window like a gta grant session.
+
+A window in progress when gta schedule run starts is granted for its remaining
+time, and one granted by an earlier run that did not revoke it is taken over,
+so restarting never leaves duplicate bindings. When a window starts before the
+previous one of the same schedule ended, it replaces that one. Every window is
+revoked on interrupt.
+
+Example:
+  gta schedule run`,
+	Args: cobra.NoArgs,
+	RunE: runScheduleRun,
+}
+
+func init() {
+	flags := scheduleAddCmd.Flags()
+	flags.String("cron", "", "Cron expression of the start of each window (required)")
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID (required)")
+	flags.StringP("user", "u", "", "User or service account to grant the role to, me, or @NAME of identities in config (defaults to current user)")
+	flags.StringArray("member", nil, "Exact principal to grant the role to instead of --user, e.g. group:team@example.com (repeatable)")
+	addDurationFlag(scheduleAddCmd, "ttl", "t", 1*time.Hour, "Length of each window, e.g. 30m, 8h, or 2d")
+	flags.StringP("reason", "r", "", "Reason for the access, recorded in the audit log")
+	scheduleAddCmd.MarkFlagRequired("cron")
+	registerMemberCompletion(scheduleAddCmd)
+
+	scheduleCmd.AddCommand(scheduleAddCmd)
+	scheduleCmd.AddCommand(scheduleListCmd)
+	scheduleCmd.AddCommand(scheduleRemoveCmd)
+	scheduleCmd.AddCommand(scheduleRunCmd)
+}
+
+func runScheduleAdd(cmd *cobra.Command, args []string) error {
+	o, err := resolveCommonOptions(cmd)
+	if err != nil {
+		return err
+	}
+	if err := o.requireProject(); err != nil {
+		return err
+	}
+	expr := flagString(cmd, "cron")
+	cron, err := schedule.Parse(expr)
+	if err != nil {
+		return err
+	}
+	if o.TTL <= 0 {
+		return fmt.Errorf("--ttl must be positive")
+	}
+	if cfg.ApprovalRequired(o.Project) {
+		return fmt.Errorf("project %s requires approval and cannot be granted on a schedule", o.Project)
+	}
+	if err := checkGrantPolicy(args, o.TTL); err != nil {
+		return err
+	}
+	next := cron.Next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("cron expression %q never fires", expr)
+	}
+
+	roles := make([]string, len(args))
+	for i, role := range args {
+		roles[i] = provider.FormatRole(role)
+	}
+	s := state.Schedule{
+		ID:        audit.NewID(),
+		Cron:      expr,
+		TTL:       o.TTL.String(),
+		Project:   o.Project,
+		Roles:     roles,
+		User:      o.User,
+		Members:   o.Members,
+		Reason:    o.Reason,
+		Profile:   profile,
+		CreatedAt: time.Now().UTC(),
+	}
+	// Whoever runs the schedule grants it, so the caller scheduling it must
+	// comply with the rules of its roles too
+	identities := func() ([]string, error) {
+		p, err := newGCPProvider(cmd.Context(), true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		return p.CallerIdentities()
+	}
+	if err := checkRoleRules(identities, scheduleOptions(s, "", o.TTL)); err != nil {
+		return err
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Update(func(f *state.File) error {
+		f.Schedules = append(f.Schedules, s)
+		return nil
+	}); err != nil {
+		return err
+	}
+	logger.Info("Added schedule %s: %s, next window %s", s.ID, describeSchedule(s), next.Format(time.RFC3339))
+	logger.Info("Run gta schedule run to carry it out")
+	return nil
+}
+
+func runScheduleList(cmd *cobra.Command, args []string) error {
+	schedules, err := loadSchedules()
+	if err != nil {
+		return err
+	}
+	if len(schedules) == 0 {
+		logger.Info("No schedules found for this profile")
+		return nil
+	}
+	now := time.Now()
+	for _, s := range schedules {
+		next := "never"
+		if cron, err := schedule.Parse(s.Cron); err == nil {
+			if t := cron.Next(now); !t.IsZero() {
+				next = t.Format(time.RFC3339)
+			}
+		}
+		logger.Info("%s  %s, next window %s", s.ID, describeSchedule(s), next)
+	}
+	return nil
+}
+
+func runScheduleRemove(cmd *cobra.Command, args []string) error {
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	return store.Update(func(f *state.File) error {
+		for _, id := range args {
+			if s, ok := f.Schedule(id); !ok || s.Profile != profile {
+				return fmt.Errorf("schedule %s not found for this profile", id)
+			}
+		}
+		for _, id := range args {
+			f.RemoveSchedule(id)
+			logger.Info("Removed schedule %s", id)
+		}
+		return nil
+	})
+}
+
+// loadSchedules returns the schedules of the current profile
+func loadSchedules() ([]state.Schedule, error) {
+	store, err := newStateStore()
+	if err != nil {
+		return nil, err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	var schedules []state.Schedule
+	for _, s := range f.Schedules {
+		if s.Profile == profile {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules, nil
+}
+
+// describeSchedule summarizes a schedule on one line
+func describeSchedule(s state.Schedule) string {
+	members := s.User
+	if len(s.Members) > 0 {
+		members = strings.Join(s.Members, ", ")
+	}
+	if members == "" {
+		members = "the current user"
+	}
+	return fmt.Sprintf("%s to %s in %s at %q for %s", strings.Join(s.Roles, ", "), members, s.Project, s.Cron, s.TTL)
+}
+
+// scheduledGrant is the grant of a schedule window in progress
+type scheduledGrant struct {
+	provider *provider.GCPProvider
+	opts     *provider.GCPOptions
+	end      time.Time
+}
+
+// scheduleRunner carries out the schedules of the current profile
+type scheduleRunner struct {
+	ctx    context.Context
+	active map[string]*scheduledGrant
+	// failed holds the start of the window whose grant failed by schedule,
+	// which is not retried
+	failed map[string]time.Time
+}
+
+func runScheduleRun(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	if _, err := newEventSink(ctx, false); err != nil {
+		return err
+	}
+
+	r := &scheduleRunner{
+		ctx:    ctx,
+		active: make(map[string]*scheduledGrant),
+		failed: make(map[string]time.Time),
+	}
+	if err := r.adopt(); err != nil {
+		return err
+	}
+
+	logger.Info("Running schedules (Ctrl+C to exit)...")
+	for {
+		wake := r.tick(time.Now())
+		select {
+		case <-ctx.Done():
+			logger.Info("Revoking the windows in progress...")
+			for id := range r.active {
+				r.revoke(id)
+			}
+			return nil
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
+
+// adopt takes over the windows granted by an earlier run that were not revoked
+func (r *scheduleRunner) adopt() error {
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for _, session := range f.Sessions {
+		if session.ScheduleID == "" || session.Profile != profile {
+			continue
+		}
+		p, err := r.newProvider(session.ID, session.Project, "")
+		if err != nil {
+			return err
+		}
+		granted, opts := sessionGrant(session)
+		p.AdoptGrantedRoles(granted)
+
+		g := &scheduledGrant{provider: p, opts: opts, end: session.Expiry()}
+		logger.Info("Taking over the window of schedule %s granted until %s", session.ScheduleID, g.end.Format(time.RFC3339))
+		if previous, ok := r.active[session.ScheduleID]; ok {
+			// A replacement was interrupted, keep the later window
+			if previous.end.After(g.end) {
+				previous, g = g, previous
+			}
+			r.revokeGrant(previous)
+		}
+		r.active[session.ScheduleID] = g
+	}
+	return nil
+}
+
+// tick grants the windows that started and revokes those that ended or whose
+// schedule was removed, returning when to tick next
+func (r *scheduleRunner) tick(now time.Time) time.Time {
+	wake := now.Add(schedulePollInterval)
+	schedules, err := loadSchedules()
+	if err != nil {
+		logger.Warn("Failed to load schedules: %v", err)
+		return wake
+	}
+
+	seen := make(map[string]bool, len(schedules))
+	for _, s := range schedules {
+		seen[s.ID] = true
+		cron, err := schedule.Parse(s.Cron)
+		if err != nil {
+			logger.Warn("Skipping schedule %s: %v", s.ID, err)
+			continue
+		}
+		ttl, err := duration.Parse(s.TTL)
+		if err != nil || ttl <= 0 {
+			logger.Warn("Skipping schedule %s: invalid ttl %q", s.ID, s.TTL)
+			continue
+		}
+
+		active := r.active[s.ID]
+		if active != nil && !now.Before(active.end) {
+			logger.Info("Window of schedule %s ended", s.ID)
+			r.revoke(s.ID)
+			active = nil
+		}
+		// Expiries are off the window by the rounding of the TTL, and windows
+		// start on minutes
+		start, end, ok := cron.Window(now, ttl)
+		if ok && (active == nil || active.end.Before(end.Add(-time.Minute))) && !r.failed[s.ID].Equal(start) {
+			if err := r.grant(s, start, end, now); err != nil {
+				logger.Error("Failed to grant the window of schedule %s starting at %s, retrying at the next window: %v", s.ID, start.Format(time.RFC3339), err)
+				r.failed[s.ID] = start
+			}
+		}
+
+		if next := cron.Next(now); !next.IsZero() && next.Before(wake) {
+			wake = next
+		}
+		if active := r.active[s.ID]; active != nil && active.end.Before(wake) {
+			wake = active.end
+		}
+	}
+
+	for id := range r.active {
+		if !seen[id] {
+			logger.Info("Schedule %s was removed", id)
+			r.revoke(id)
+		}
+	}
+	return wake
+}
+
+// grant grants the window of s ending at end, replacing the window of s in
+// progress if any
+func (r *scheduleRunner) grant(s state.Schedule, start, end, now time.Time) error {
+	if cfg.ApprovalRequired(s.Project) {
+		return fmt.Errorf("project %s requires approval", s.Project)
+	}
+	opts := scheduleOptions(s, audit.NewID(), end.Sub(now).Round(time.Second))
+	if err := checkGrantPolicy(opts.Roles, opts.TTL); err != nil {
+		return err
+	}
+	p, err := r.newProvider(opts.SessionID, s.Project, s.Reason)
+	if err != nil {
+		return err
+	}
+	if err := checkRoleRules(p.CallerIdentities, opts); err != nil {
+		return err
+	}
+	if len(cfg.AllowedGranters) > 0 {
+		caller, err := p.Caller()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		if err := checkGranter(caller, opts); err != nil {
+			return err
+		}
+	}
+	if cfg.Policy.Path != "" {
+		caller, err := p.Caller()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		if err := enforcePolicy(r.ctx, p, opts, caller); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Window of schedule %s started at %s, granting until %s", s.ID, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	err = p.Grant(opts)
+	flushNotifications()
+	if err != nil {
+		rollbackGrant(p, opts)
+		return err
+	}
+	recordScheduledSession(opts, p.GrantedRoles(), s.ID)
+
+	previous := r.active[s.ID]
+	r.active[s.ID] = &scheduledGrant{provider: p, opts: opts, end: end}
+	if previous != nil {
+		logger.Info("Replacing the previous window of schedule %s", s.ID)
+		r.revokeGrant(previous)
+	}
+	return nil
+}
+
+// revoke revokes the window of schedule id in progress
+func (r *scheduleRunner) revoke(id string) {
+	if g, ok := r.active[id]; ok {
+		delete(r.active, id)
+		r.revokeGrant(g)
+	}
+}
+
+// revokeGrant revokes a window, keeping it in the local state if that fails
+// so that sessions prune or a later run can find it
+func (r *scheduleRunner) revokeGrant(g *scheduledGrant) {
+	detach(g.provider)
+	err := revokeWithReauth(g.opts.SessionID, func() error {
+		err := g.provider.Revoke(g.opts)
+		flushNotifications()
+		return err
+	}, func() error {
+		p, err := r.newProvider(g.opts.SessionID, g.opts.Project, g.opts.Reason)
+		if err != nil {
+			return err
+		}
+		detach(p)
+		p.AdoptGrantedRoles(g.provider.GrantedRoles())
+		err = p.Revoke(g.opts)
+		flushNotifications()
+		return err
+	})
+	if err != nil {
+		logger.Error("Failed to revoke roles: %v", err)
+		return
+	}
+	forgetSession(g.opts.SessionID)
+}
+
+// newProvider creates the provider of a window, logging with its session and
+// sending reason with its API calls
+func (r *scheduleRunner) newProvider(sessionID, project, reason string) (*provider.GCPProvider, error) {
+	log := logger.With(slog.String(logger.SessionKey, sessionID), slog.String("project", project), slog.String("provider", "gcp"))
+	p, err := newGCPProvider(r.ctx, false, provider.WithLogger(log), provider.WithServiceAccountCaller(cfg.AllowServiceAccountCaller), requestReasonOption(reason, ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	return p, nil
+}
+
+// scheduleOptions returns the grant options of a window of s
+func scheduleOptions(s state.Schedule, sessionID string, ttl time.Duration) *provider.GCPOptions {
+	return &provider.GCPOptions{
+		Project:   s.Project,
+		Roles:     s.Roles,
+		User:      s.User,
+		Members:   s.Members,
+		TTL:       ttl,
+		Reason:    s.Reason,
+		SessionID: sessionID,
+		Profile:   s.Profile,
+	}
+}
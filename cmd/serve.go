@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/metrics"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/server"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+const defaultServeListen = ":8080"
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve temporary access as an HTTP API for team self-service",
+	Long: `Serve an HTTP API that grants temporary roles to its callers, so that
+teammates can request access without local credentials or the CLI.
+
+Callers authenticate with a Google-signed ID token, either as an IAP assertion
+or as a bearer token forwarded by ESPv2, issued for --audience. Roles can only
+be granted to the caller, and the same TTL and role limits as gta grant apply;
+projects requiring approval are refused. IAM changes are made with the server's
+credentials, or as the granter service account given with
+--impersonate-service-account.
+
+Endpoints:
+  POST   /grants             Grant roles to the caller
+  GET    /grants?project=ID  List temporary bindings in a project
+  DELETE /grants/{session}   Revoke a session early
+  GET    /openapi.json       OpenAPI description
+
+Example:
+  gta serve --audience=/projects/123/global/backendServices/456 \
+      --impersonate-service-account=gta-granter@my-project.iam.gserviceaccount.com`,
+	Args: cobra.NoArgs,
+	RunE: runServe,
+}
+
+// serveOptions are the options of gta serve, resolved against the server
+// section of the config file
+type serveOptions struct {
+	Listen        string
+	Audience      string
+	ImpersonateSA string
+	OpenAPI       bool
+}
+
+func init() {
+	flags := serveCmd.Flags()
+	flags.String("listen", "", "Address to listen on (default "+defaultServeListen+")")
+	flags.String("audience", "", "Audience expected in ID tokens, e.g. the IAP client ID")
+	flags.String("impersonate-service-account", "", "Granter service account used for IAM changes")
+	flags.Bool("openapi", false, "Print the OpenAPI description and exit")
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	o := serveOptions{
+		Listen:        firstNonEmpty(flagString(cmd, "listen"), cfg.Server.Listen, defaultServeListen),
+		Audience:      firstNonEmpty(flagString(cmd, "audience"), cfg.Server.Audience),
+		ImpersonateSA: firstNonEmpty(flagString(cmd, "impersonate-service-account"), cfg.Server.ImpersonateServiceAccount),
+		OpenAPI:       flagBool(cmd, "openapi"),
+	}
+	if o.OpenAPI {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(server.OpenAPI())
+	}
+
+	if o.Audience == "" {
+		return fmt.Errorf("an ID token audience is required (--audience or server.audience)")
+	}
+
+	ctx := cmd.Context()
+
+	if metricsRegistry == nil {
+		metricsRegistry = metrics.NewRegistry()
+	}
+	if cfg.Metrics.Listen != "" {
+		go func() {
+			if err := metrics.Serve(ctx, cfg.Metrics.Listen, metricsRegistry); err != nil {
+				logger.Error("Metrics listener stopped: %v", err)
+			}
+		}()
+	}
+
+	// Set up the event sink once so that it is shared by every session
+	if _, err := newEventSink(ctx, false); err != nil {
+		return err
+	}
+	defer closeEventSink()
+
+	// A server necessarily runs as a service account, and grants go to the
+	// authenticated callers rather than to it
+	extra := []provider.GCPProviderOption{provider.WithServiceAccountCaller(true)}
+	if o.ImpersonateSA != "" {
+		clientOpts, err := apiClientOptions(ctx)
+		if err != nil {
+			return err
+		}
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: o.ImpersonateSA,
+			Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+		}, clientOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to impersonate %s: %v", o.ImpersonateSA, err)
+		}
+		extra = append(extra, provider.WithClientOptions(option.WithTokenSource(ts)))
+		logger.Info("Making IAM changes as %s", o.ImpersonateSA)
+	}
+
+	if cfg.Policy.Path != "" {
+		if _, err := loadPolicy(ctx); err != nil {
+			logger.Warn("%v", err)
+		}
+	}
+
+	auth, err := server.NewIDTokenAuthenticator(ctx, o.Audience)
+	if err != nil {
+		return err
+	}
+
+	srv := server.New(server.Config{
+		NewProvider: func(ctx context.Context, log *logger.Logger) (server.Provider, error) {
+			opts := append([]provider.GCPProviderOption{provider.WithLogger(log)}, extra...)
+			return newGCPProvider(ctx, false, opts...)
+		},
+		Authenticator: auth,
+		Policy:        authorizeServerGrant,
+		DefaultTTL:    serverDefaultTTL(),
+		Metrics:       metricsRegistry,
+		Flush: func() {
+			flushNotifications()
+		},
+	})
+	return srv.Run(ctx, o.Listen)
+}
+
+// authorizeServerGrant checks a grant requested from the server by caller
+// as gta grant checks its own
+func authorizeServerGrant(ctx context.Context, p server.Provider, opts *provider.GCPOptions, caller string) error {
+	if cfg.ApprovalRequired(opts.Project) {
+		return fmt.Errorf("project %s requires approval; use gta request instead", opts.Project)
+	}
+	if err := checkGrantPolicy(opts.Roles, opts.TTL); err != nil {
+		return err
+	}
+	// The authenticated caller grants, through the identity of the server
+	identities := func() ([]string, error) { return []string{caller}, nil }
+	if err := checkRoleRules(identities, opts); err != nil {
+		return err
+	}
+	if err := checkRoleStages(p, opts); err != nil {
+		return err
+	}
+	opts.Environment = resolveEnvironment(p, opts.Project)
+	// A request without a TTL gets the defaults of its roles, as gta grant
+	// without --ttl
+	if opts.TTL <= 0 {
+		opts.TTL = serverDefaultTTL()
+		resolveRoleTTLs(opts, false)
+	}
+	if err := enforceEnvironment(opts); err != nil {
+		return err
+	}
+	return enforcePolicy(ctx, p, opts, caller)
+}
+
+// serverDefaultTTL returns the TTL of grants requesting none, default_ttl
+// or the default of --ttl
+func serverDefaultTTL() time.Duration {
+	if cfg.DefaultTTL > 0 {
+		return time.Duration(cfg.DefaultTTL)
+	}
+	return time.Hour
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/notify"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// labelProvider is a server provider knowing only the labels of projects
+// and the launch stages of roles
+type labelProvider struct {
+	labels map[string]map[string]string
+	stages map[string]string
+}
+
+func (p *labelProvider) Grant(provider.Options) error         { return nil }
+func (p *labelProvider) Revoke(provider.Options) error        { return nil }
+func (p *labelProvider) GrantedRoles() []provider.GrantedRole { return nil }
+func (p *labelProvider) GrantErrors() provider.RoleErrors     { return nil }
+func (p *labelProvider) TemporaryBindings(provider.Options) ([]provider.TemporaryBinding, error) {
+	return nil, nil
+}
+
+func (p *labelProvider) ProjectLabels(project string) (map[string]string, error) {
+	labels, ok := p.labels[project]
+	if !ok {
+		return nil, errors.New("permission denied")
+	}
+	return labels, nil
+}
+
+func (p *labelProvider) Role(name string) (provider.PredefinedRole, error) {
+	name = provider.FormatRole(name)
+	stage, ok := p.stages[name]
+	if !ok {
+		return provider.PredefinedRole{}, errors.New("role not found")
+	}
+	return provider.PredefinedRole{Name: name, Stage: stage, Description: "Use roles/logging.viewer instead."}, nil
+}
+
+// environmentsConfig classifies projects by their env label into dev and
+// prod, prod requiring a reason and at most 2h
+const environmentsConfig = `
+environments:
+  label: env
+  classes:
+    - name: dev
+      max_ttl: 8h
+    - name: prod
+      require_reason: true
+      max_ttl: 2h
+`
+
+func TestAuthorizeServerGrant(t *testing.T) {
+	p := &labelProvider{labels: map[string]map[string]string{
+		"web-dev":  {"env": "dev"},
+		"web-prod": {"env": "prod"},
+	}}
+	for _, tc := range []struct {
+		name    string
+		opts    provider.GCPOptions
+		wantEnv string
+		wantErr string
+	}{
+		{name: "dev", opts: provider.GCPOptions{Project: "web-dev", Roles: []string{"roles/viewer"}, TTL: 4 * time.Hour}, wantEnv: "dev"},
+		{name: "prod", opts: provider.GCPOptions{Project: "web-prod", Roles: []string{"roles/viewer"}, TTL: time.Hour, Reason: "INC-42"}, wantEnv: "prod"},
+		{name: "prod without a reason", opts: provider.GCPOptions{Project: "web-prod", Roles: []string{"roles/viewer"}, TTL: time.Hour}, wantErr: "environment prod, which requires a reason"},
+		{name: "prod over its TTL", opts: provider.GCPOptions{Project: "web-prod", Roles: []string{"roles/viewer"}, TTL: 4 * time.Hour, Reason: "INC-42"}, wantErr: "exceeds the maximum of 2h of environment prod"},
+		{name: "unreadable labels", opts: provider.GCPOptions{Project: "web-unknown", Roles: []string{"roles/viewer"}, TTL: time.Hour}, wantErr: "environment prod, which requires a reason"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, environmentsConfig)
+			opts := tc.opts
+			err := authorizeServerGrant(context.Background(), p, &opts, "alice@example.com")
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("authorizeServerGrant = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Environment != tc.wantEnv {
+				t.Errorf("environment %q, want %s", opts.Environment, tc.wantEnv)
+			}
+		})
+	}
+}
+
+func TestAuthorizeServerGrantRoleStages(t *testing.T) {
+	p := &labelProvider{stages: map[string]string{
+		"roles/viewer":         provider.StageGA,
+		"roles/logging.legacy": provider.StageDeprecated,
+	}}
+	for _, tc := range []struct {
+		name    string
+		config  string
+		roles   []string
+		wantErr string
+	}{
+		{name: "GA", config: "forbid_deprecated_roles: true\n", roles: []string{"viewer"}},
+		{name: "unknown stage", config: "forbid_deprecated_roles: true\n", roles: []string{"custom.role"}},
+		{name: "deprecated, warned", roles: []string{"viewer", "logging.legacy"}},
+		{name: "deprecated, forbidden", config: "forbid_deprecated_roles: true\n", roles: []string{"viewer", "logging.legacy"}, wantErr: "role roles/logging.legacy is deprecated, which forbid_deprecated_roles in config refuses, grant roles/logging.viewer instead"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, tc.config)
+			opts := &provider.GCPOptions{Project: "p", Roles: tc.roles, TTL: time.Hour}
+			err := authorizeServerGrant(context.Background(), p, opts, "alice@example.com")
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("authorizeServerGrant = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizeServerGrantTTL(t *testing.T) {
+	p := &labelProvider{labels: map[string]map[string]string{
+		"web-dev":  {"env": "dev"},
+		"web-prod": {"env": "prod"},
+	}}
+	for _, tc := range []struct {
+		name    string
+		project string
+		roles   []string
+		ttl     time.Duration
+		wantTTL time.Duration
+		// wantRoleTTLs are the TTLs of the roles, none when requested
+		wantRoleTTLs map[string]time.Duration
+	}{
+		{name: "requested", project: "web-dev", roles: []string{"owner"}, ttl: 30 * time.Minute, wantTTL: 30 * time.Minute},
+		{name: "by role", project: "web-dev", roles: []string{"owner", "viewer"}, wantTTL: 3 * time.Hour, wantRoleTTLs: map[string]time.Duration{"roles/owner": 15 * time.Minute, "roles/viewer": 3 * time.Hour}},
+		{name: "capped by the environment", project: "web-prod", roles: []string{"logging.viewer"}, wantTTL: 2 * time.Hour, wantRoleTTLs: map[string]time.Duration{"roles/logging.viewer": 2 * time.Hour}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, roleTTLsConfig)
+			opts := &provider.GCPOptions{Project: tc.project, Roles: tc.roles, TTL: tc.ttl, Reason: "INC-42"}
+			if err := authorizeServerGrant(context.Background(), p, opts, "alice@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			if opts.TTL != tc.wantTTL {
+				t.Errorf("TTL %s, want %s", opts.TTL, tc.wantTTL)
+			}
+			if len(opts.RoleTTLs) != len(tc.wantRoleTTLs) {
+				t.Errorf("role TTLs %v, want %v", opts.RoleTTLs, tc.wantRoleTTLs)
+			}
+			for role, want := range tc.wantRoleTTLs {
+				if got := opts.RoleTTLs[role].TTL; got != want {
+					t.Errorf("%s: %s, want %s", role, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvironmentRoutes(t *testing.T) {
+	var mu sync.Mutex
+	var posts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		posts = append(posts, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	useConfig(t, environmentsConfig+`      notifications:
+        slack:
+          webhook_url: `+srv.URL+`
+`)
+	savedDispatcher, savedRoutes := dispatcher, environmentRoutes
+	t.Cleanup(func() { dispatcher, environmentRoutes = savedDispatcher, savedRoutes })
+	dispatcher = notify.NewDispatcher()
+	environmentRoutes = make(map[string]bool)
+
+	// A server enforces the environment of every grant
+	for _, project := range []string{"web-prod", "web-dev", "web-prod"} {
+		opts := &provider.GCPOptions{Project: project, Environment: strings.TrimPrefix(project, "web-"), Roles: []string{"roles/viewer"}, TTL: time.Hour, Reason: "INC-42"}
+		if err := enforceEnvironment(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, env := range []string{"prod", "dev"} {
+		dispatcher.Emit(audit.Event{Action: audit.ActionGrant, Project: "web-" + env, Environment: env, Role: "roles/viewer", Member: "user:alice@example.com"})
+		dispatcher.Flush()
+	}
+	if err := dispatcher.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The routes of prod were added once, and see the grants of prod only
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posts) != 1 || !strings.Contains(posts[0], "web-prod") {
+		t.Errorf("posted %q, want the grant in web-prod once", posts)
+	}
+}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Manage the grant sessions recorded on this machine",
+}
+
+var sessionsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove sessions whose bindings are gone from the local state",
+	Long: `Remove sessions from the local state once their bindings can no longer grant
+access: sessions whose bindings all expired longer than state.retention ago
+(a week by default), and sessions whose bindings are verified to be gone from
+their project policy. A session whose project policy cannot be read is kept.
+
+Sessions past the retention are also pruned at the start of every command.
+
+Example:
+  gta sessions prune --dry-run
+  gta sessions prune`,
+	Args: cobra.NoArgs,
+	RunE: runSessionsPrune,
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the grant sessions recorded on this machine",
+	Long: `List the grant sessions recorded on this machine with the projects and
+resources they granted on, their expiry, and their status: attended by the
+process that granted them, unattended when granted with --keep and left to
+expire by their condition, pending revocation, or expired.
+
+A session whose revocation failed, e.g. while offline, is pending revocation:
+later gta commands retry it in the background, backing off from a minute to
+an hour, and give up after a few attempts, leaving it to gta revoke
+--from-state. LAST ERROR shows why the last attempt failed.
+
+Example:
+  gta sessions list`,
+	Args: cobra.NoArgs,
+	RunE: runSessionsList,
+}
+
+var sessionsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write a session to a file for another machine to take over",
+	Long: `Write a session of the local state to a handoff file, e.g. at a shift change,
+for gta sessions import to take over on another machine: its resources, roles,
+member, binding IDs, expiries, and metadata, without any credentials. The file
+is versioned with the state file.
+
+Only a session no gta process attends can be exported, such as one granted
+with --keep, as that process would revoke it when it ends. The session stays
+in the local state, which never revokes it on its own.
+
+Example:
+  gta sessions export --session=3f2a9c1d5e6b7a80 -o handoff.json`,
+	Args: cobra.NoArgs,
+	RunE: runSessionsExport,
+}
+
+var sessionsImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Take over a session exported from another machine",
+	Long: `Add the session of a handoff file written by gta sessions export to the local
+state, once its bindings are verified to exist in the policies; those gone are
+left out, and nothing is imported when all are. The session is then
+unattended, for gta wait --session to wait on, gta revoke --from-state
+--session to revoke, and gta status to report on.
+
+Example:
+  gta sessions import handoff.json
+  gta wait --session=3f2a9c1d5e6b7a80`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSessionsImport,
+}
+
+func init() {
+	sessionsPruneCmd.Flags().BoolP("dry-run", "d", false, "Show the sessions that would be pruned without removing them")
+	sessionsExportCmd.Flags().String("session", "", "ID of the session to export (required)")
+	sessionsExportCmd.Flags().StringP("output", "o", "-", "Handoff file to write, - for stdout")
+	sessionsExportCmd.MarkFlagRequired("session")
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsPruneCmd)
+	sessionsCmd.AddCommand(sessionsExportCmd)
+	sessionsCmd.AddCommand(sessionsImportCmd)
+}
+
+func runSessionsList(cmd *cobra.Command, args []string) error {
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	if len(f.Sessions) == 0 {
+		logger.Info("No sessions recorded")
+		return nil
+	}
+
+	return writeSessions(os.Stdout, f.Sessions, time.Now())
+}
+
+// writeSessions prints sessions as a table, in the order they started
+func writeSessions(w io.Writer, sessions []state.Session, now time.Time) error {
+	sessions = append([]state.Session(nil), sessions...)
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTARGETS\tMEMBER\tEXPIRY\tSTATUS\tLAST ERROR")
+	for _, s := range sessions {
+		lastError := ""
+		if s.PendingRevocation != nil {
+			lastError = s.PendingRevocation.LastError
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, strings.Join(s.Targets(), ","), s.Member, s.LastExpiry().Format(time.RFC3339), s.Status(now), lastError)
+	}
+	return tw.Flush()
+}
+
+func runSessionsPrune(cmd *cobra.Command, args []string) error {
+	dryRun, err := boolOption(cmd, "dry-run")
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	retention := cfg.StateRetention()
+	prune := make(map[string]string)
+	for _, s := range f.Stale(time.Now(), retention) {
+		prune[s.ID] = fmt.Sprintf("expired more than %s ago", retention)
+	}
+
+	// Verify the other sessions against the policies of the projects and
+	// resources they granted on
+	byTarget := make(map[string][]state.Session)
+	unverified := 0
+	for _, s := range f.Sessions {
+		if _, ok := prune[s.ID]; !ok && s.Provider == "gcp" {
+			for _, target := range s.Targets() {
+				byTarget[target] = append(byTarget[target], s)
+			}
+			unverified++
+		}
+	}
+	if unverified > 0 {
+		if err := verifySessions(ctx, byTarget, prune); err != nil {
+			logger.Warn("Keeping %d session(s) as their bindings cannot be verified: %v", unverified, err)
+		}
+	}
+
+	if len(prune) == 0 {
+		logger.Info("No sessions to prune")
+		return nil
+	}
+	for _, s := range f.Sessions {
+		reason, ok := prune[s.ID]
+		if !ok {
+			continue
+		}
+		verb := "Pruning"
+		if dryRun {
+			verb = "[DRY-RUN] Would prune"
+		}
+		logger.Info("%s session %s of %s in projects/%s: %s", verb, s.ID, s.Member, s.Project, reason)
+	}
+	if dryRun {
+		return nil
+	}
+
+	err = store.Update(func(f *state.File) error {
+		for id := range prune {
+			f.Remove(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Pruned %d of %d session(s)", len(prune), len(f.Sessions))
+	return nil
+}
+
+func runSessionsExport(cmd *cobra.Command, args []string) error {
+	id := flagString(cmd, "session")
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	s, ok := f.Session(id)
+	if !ok {
+		return fmt.Errorf("no session %s recorded on this machine: it was revoked, pruned, or granted elsewhere", id)
+	}
+	if _, err := attachable(*s, os.Getpid(), time.Now(), processAlive); err != nil {
+		return err
+	}
+
+	handoff := state.NewHandoff(*s, time.Now())
+	output := flagString(cmd, "output")
+	if output == "-" {
+		data, err := handoff.Encode()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := handoff.Write(output); err != nil {
+		return err
+	}
+	logger.Info("Wrote session %s of %s to %s; take it over on another machine with gta sessions import %s", s.ID, s.Member, output, output)
+	return nil
+}
+
+func runSessionsImport(cmd *cobra.Command, args []string) error {
+	handoff, err := state.LoadHandoff(args[0])
+	if err != nil {
+		return err
+	}
+	s := handoff.Session
+	s.Kept, s.PID, s.PendingRevocation = true, 0, nil
+	if s.Provider != "gcp" {
+		return fmt.Errorf("session %s was granted on %s, only gcp sessions are supported", s.ID, s.Provider)
+	}
+	if !s.LastExpiry().After(time.Now()) {
+		return &exitError{err: fmt.Errorf("session %s expired at %s, nothing imported", s.ID, s.LastExpiry().Local().Format(time.RFC3339)), code: ExitSessionEnded}
+	}
+
+	p, err := newGCPProvider(cmd.Context(), true)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	granted, _ := sessionGrant(s)
+	existing, err := existingRoles(p, s, granted)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		return &exitError{err: fmt.Errorf("the bindings of session %s are gone from the policies, nothing imported", s.ID), code: ExitSessionEnded}
+	}
+	s.Bindings = nil
+	for _, role := range existing {
+		s.Bindings = append(s.Bindings, state.Binding{Role: role.Role, BindingID: role.BindingID, Expiry: role.Expiry, Target: role.Target})
+	}
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	err = store.Update(func(f *state.File) error {
+		if _, ok := f.Session(s.ID); ok {
+			return fmt.Errorf("session %s is already recorded on this machine", s.ID)
+		}
+		f.Put(s)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Imported session %s of %s with %s, exported at %s", s.ID, s.Member, describeGranted(existing, s.Project), handoff.ExportedAt.Local().Format(time.RFC3339))
+	logger.Info("Wait on it with gta wait --session=%s, or revoke it with gta revoke --from-state --session=%s", s.ID, s.ID)
+	return nil
+}
+
+// verifySessions marks for pruning the sessions of byTarget whose bindings
+// are gone from the policies of all their projects and resources
+func verifySessions(ctx context.Context, byTarget map[string][]state.Session, prune map[string]string) error {
+	p, err := newGCPProvider(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	targets := make([]string, 0, len(byTarget))
+	for target := range byTarget {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	// A session is kept when its bindings exist in any of its targets, or
+	// when any of them cannot be verified
+	kept := make(map[string]bool)
+	for _, target := range targets {
+		bindings, err := p.PolicyBindings(target)
+		if err != nil {
+			logger.Warn("Keeping %d session(s) as their bindings cannot be verified: %v", len(byTarget[target]), err)
+			for _, s := range byTarget[target] {
+				kept[s.ID] = true
+			}
+			continue
+		}
+		for _, s := range byTarget[target] {
+			if sessionBindingsExist(s, bindings) {
+				kept[s.ID] = true
+			}
+		}
+	}
+	for _, target := range targets {
+		for _, s := range byTarget[target] {
+			if !kept[s.ID] {
+				prune[s.ID] = "its bindings are gone from the policy"
+			}
+		}
+	}
+	return nil
+}
+
+// sessionBindingsExist reports whether any of bindings belongs to s, by its
+// recorded binding IDs or the session ID in the binding description
+func sessionBindingsExist(s state.Session, bindings []provider.TemporaryBinding) bool {
+	ids := make(map[string]bool, len(s.Bindings))
+	for _, b := range s.Bindings {
+		ids[b.BindingID] = true
+	}
+	for _, binding := range bindings {
+		if ids[binding.BindingID] || binding.SessionID == s.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneStaleSessions removes the sessions whose bindings all expired longer
+// than the retention ago. It runs at the start of every command, so it
+// reports at debug level only and leaves a missing state file alone.
+func pruneStaleSessions() {
+	if passphraseNeeded() {
+		return
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(store.Path()); errors.Is(err, os.ErrNotExist) {
+		return
+	}
+
+	now := time.Now()
+	retention := cfg.StateRetention()
+	f, err := store.Load()
+	if err != nil {
+		logger.Debug("Skipping pruning of the session state: %v", err)
+		return
+	}
+	if len(f.Stale(now, retention)) == 0 {
+		return
+	}
+
+	err = store.Update(func(f *state.File) error {
+		for _, s := range f.Stale(now, retention) {
+			f.Remove(s.ID)
+			logger.Debug("Pruned session %s of projects/%s, expired at %s",
+				s.ID, s.Project, s.LastExpiry().Local().Format(time.RFC3339))
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Debug("Failed to prune the session state: %v", err)
+	}
+}
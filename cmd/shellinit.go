@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/spf13/cobra"
+)
+
+// exportedSessions matches a GTA_SESSION that is safe to export again
+var exportedSessions = regexp.MustCompile(`^[A-Za-z0-9_,-]+$`)
+
+// shellSnippets are the snippets printed by gta shell-init. Each revokes the
+// sessions exported in the shell when it exits, leaving those inherited from
+// a parent shell to it, and loads once however often it is evaluated.
+var shellSnippets = map[string]string{
+	"bash": `# gta shell integration, load with: eval "$(gta shell-init bash)"
+if [ -z "${__gta_shell_init:-}" ]; then
+  __gta_shell_init=1
+  __gta_inherited_sessions="${GTA_SESSION:-}"
+  __gta_revoke_sessions() {
+    local sessions="${GTA_SESSION:-}"
+    if [ -n "$__gta_inherited_sessions" ]; then
+      sessions="${sessions#"$__gta_inherited_sessions"}"
+    fi
+    sessions="${sessions#,}"
+    if [ -n "$sessions" ]; then
+      command gta revoke --from-state --session="$sessions" --timeout=15s </dev/null
+    fi
+  }
+  __gta_previous_exit_trap="$(trap -p EXIT)"
+  __gta_previous_exit_trap="${__gta_previous_exit_trap#trap -- }"
+  __gta_previous_exit_trap="${__gta_previous_exit_trap% EXIT}"
+  eval "__gta_previous_exit_trap=${__gta_previous_exit_trap:-''}"
+  trap '__gta_revoke_sessions; eval "$__gta_previous_exit_trap"' EXIT
+fi
+`,
+	"zsh": `# gta shell integration, load with: eval "$(gta shell-init zsh)"
+if [[ -z "${__gta_shell_init:-}" ]]; then
+  __gta_shell_init=1
+  __gta_inherited_sessions="${GTA_SESSION:-}"
+  __gta_revoke_sessions() {
+    local sessions="${GTA_SESSION:-}"
+    if [[ -n "$__gta_inherited_sessions" ]]; then
+      sessions="${sessions#"$__gta_inherited_sessions"}"
+    fi
+    sessions="${sessions#,}"
+    if [[ -n "$sessions" ]]; then
+      command gta revoke --from-state --session="$sessions" --timeout=15s </dev/null
+    fi
+  }
+  autoload -Uz add-zsh-hook
+  add-zsh-hook zshexit __gta_revoke_sessions
+fi
+`,
+	"fish": `# gta shell integration, load with: gta shell-init fish | source
+if not set -q __gta_shell_init
+    set -g __gta_shell_init 1
+    set -g __gta_inherited_sessions "$GTA_SESSION"
+    function __gta_revoke_sessions --on-event fish_exit
+        set -l sessions "$GTA_SESSION"
+        if test -n "$__gta_inherited_sessions"
+            set sessions (string replace --regex -- '^'(string escape --style=regex -- "$__gta_inherited_sessions") '' "$sessions")
+        end
+        set sessions (string trim --left --chars=, -- "$sessions")
+        if test -n "$sessions"
+            command gta revoke --from-state --session="$sessions" --timeout=15s </dev/null
+        end
+    end
+end
+`,
+}
+
+var shellInitCmd = &cobra.Command{
+	Use:   "shell-init bash|zsh|fish",
+	Short: "Print the shell integration revoking sessions when the shell exits",
+	Long: `Print a snippet that ties the bindings of gta grant --export-session to the
+shell: once loaded, the sessions granted in the shell with
+
+  eval "$(gta grant roles/viewer -p my-project --export-session)"
+
+are revoked with gta revoke --from-state when the shell exits. Each grant adds
+its session to GTA_SESSION. Nested shells inherit GTA_SESSION but only revoke
+the sessions granted in them, and the revocation gives up after 15 seconds so
+that an unreachable API never hangs a logout; the bindings then expire by
+their condition.
+
+Example:
+  # ~/.bashrc
+  eval "$(gta shell-init bash)"
+
+  # ~/.zshrc
+  eval "$(gta shell-init zsh)"
+
+  # ~/.config/fish/config.fish
+  gta shell-init fish | source`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Print(shellSnippets[args[0]])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shellInitCmd)
+}
+
+// exportSession returns the command adding id to the sessions exported in
+// GTA_SESSION for the exit hook of gta shell-init, in a syntax bash, zsh,
+// and fish share
+func exportSession(id string) string {
+	sessions := id
+	if current := os.Getenv(optionEnv("session")); exportedSessions.MatchString(current) {
+		sessions = current + "," + id
+	}
+	return fmt.Sprintf("export %s='%s'", optionEnv("session"), sessions)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestShutdownSignals(t *testing.T) {
+	// Ctrl+C, and the console closing on Windows, both end a session
+	found := make(map[os.Signal]bool)
+	for _, s := range shutdownSignals() {
+		found[s] = true
+	}
+	if !found[os.Interrupt] || !found[syscall.SIGTERM] {
+		t.Errorf("shutdownSignals() = %v, want os.Interrupt and SIGTERM", shutdownSignals())
+	}
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Error("this process is not alive")
+	}
+	for _, pid := range []int{0, -1} {
+		if processAlive(pid) {
+			t.Errorf("pid %d alive", pid)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// shutdownSignals are the signals cancelling the context of every command,
+// which revokes the roles of a waiting session. SIGHUP, sent when the
+// terminal is closed, is left alone when it is ignored, e.g. under nohup.
+func shutdownSignals() []os.Signal {
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	if !signal.Ignored(syscall.SIGHUP) {
+		signals = append(signals, syscall.SIGHUP)
+	}
+	return signals
+}
+
+// interruptProcess asks a wrapped command to exit
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+// processAlive reports whether a process with the given PID is running,
+// including one of another user
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
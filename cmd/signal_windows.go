@@ -0,0 +1,40 @@
+//go:build windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// shutdownSignals are the console events cancelling the context of every
+// command, which revokes the roles of a waiting session. The Go runtime
+// delivers CTRL_C_EVENT and CTRL_BREAK_EVENT as os.Interrupt, and
+// CTRL_CLOSE_EVENT, CTRL_LOGOFF_EVENT, and CTRL_SHUTDOWN_EVENT as
+// syscall.SIGTERM, holding off the end of the process until the handler of
+// the console gives up, about 5 seconds after the console window is closed.
+func shutdownSignals() []os.Signal {
+	return []os.Signal{os.Interrupt, syscall.SIGTERM}
+}
+
+// interruptProcess asks a wrapped command to exit. Windows cannot send
+// os.Interrupt to a process, but a command sharing the console of gta
+// receives the console event itself, and is killed after commandWaitDelay
+// otherwise.
+func interruptProcess(p *os.Process) error {
+	return nil
+}
+
+// processAlive reports whether a process with the given PID is running.
+// Opening a process fails on Windows once it has exited.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
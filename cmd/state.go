@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/encryption"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// passphraseEnv holds the passphrase of the encryption key when it is
+// derived from one, instead of asking for it
+const passphraseEnv = envPrefix + "PASSPHRASE"
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Manage the local state and audit files",
+}
+
+var stateMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Encrypt or decrypt the existing local state and audit files",
+	Long: `Encrypt the existing state file and local audit log with the key configured
+under state.encryption, creating the key if needed, or decrypt them.
+
+Enable state.encryption in the config before encrypting so that gta reads
+the files afterwards, and disable it after decrypting.
+
+Example:
+  gta state migrate --encrypt
+  gta state migrate --decrypt`,
+	Args: cobra.NoArgs,
+	RunE: runStateMigrate,
+}
+
+func init() {
+	flags := stateMigrateCmd.Flags()
+	flags.Bool("encrypt", false, "Encrypt the files")
+	flags.Bool("decrypt", false, "Decrypt the files")
+	stateMigrateCmd.MarkFlagsMutuallyExclusive("encrypt", "decrypt")
+	stateMigrateCmd.MarkFlagsOneRequired("encrypt", "decrypt")
+	stateCmd.AddCommand(stateMigrateCmd)
+}
+
+func runStateMigrate(cmd *cobra.Command, args []string) error {
+	encrypt := flagBool(cmd, "encrypt")
+	if encrypt && !cfg.State.Encryption.Enabled {
+		return fmt.Errorf("set state.encryption.enabled in the config first, so that gta reads the encrypted files")
+	}
+
+	key, err := loadCipher()
+	if err != nil {
+		return err
+	}
+	target := key
+	action := "Encrypted"
+	if !encrypt {
+		target = nil
+		action = "Decrypted"
+	}
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(store.Path()); err == nil {
+		if err := store.Convert(target); err != nil {
+			return err
+		}
+		logger.Info("%s %s", action, store.Path())
+	}
+	if !cfg.Audit.Disabled {
+		path, err := cfg.AuditPath()
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil {
+			if err := audit.ConvertLog(path, key, target); err != nil {
+				return err
+			}
+			logger.Info("%s %s", action, path)
+		}
+	}
+	if !encrypt && cfg.State.Encryption.Enabled {
+		logger.Warn("Disable state.encryption in the config, or the files will be encrypted again as they are written")
+	}
+	return nil
+}
+
+var (
+	cipherOnce  sync.Once
+	localCipher *encryption.Cipher
+	cipherErr   error
+)
+
+// fileCipher returns the cipher of the local state and audit files, or nil
+// when state.encryption is not enabled
+func fileCipher() (*encryption.Cipher, error) {
+	if !cfg.State.Encryption.Enabled {
+		return nil, nil
+	}
+	return loadCipher()
+}
+
+// loadCipher loads the key configured under state.encryption, creating it
+// when there is none yet, once per process
+func loadCipher() (*encryption.Cipher, error) {
+	cipherOnce.Do(func() {
+		dir, err := config.DataDir()
+		if err != nil {
+			cipherErr = err
+			return
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			cipherErr = fmt.Errorf("failed to create data directory: %v", err)
+			return
+		}
+
+		var keyring encryption.Keyring = encryption.Keychain(dir)
+		if cfg.State.Encryption.Key == config.EncryptionKeyPassphrase {
+			keyring = &encryption.PassphraseKeyring{Dir: dir, Passphrase: passphrase}
+		}
+		key, err := encryption.LoadKey(keyring)
+		if err != nil {
+			cipherErr = fmt.Errorf("failed to load the encryption key from the %s: %w; without it the encrypted state cannot be read, "+
+				"remove the bindings of pending sessions with gta clean --project=PROJECT", keyring.Name(), err)
+			return
+		}
+		localCipher, cipherErr = encryption.New(key)
+	})
+	return localCipher, cipherErr
+}
+
+// passphrase returns the passphrase of the encryption key from its
+// environment variable, or asks for it on the terminal
+func passphrase() (string, error) {
+	if value := os.Getenv(passphraseEnv); value != "" {
+		return value, nil
+	}
+	value, err := encryption.ReadPassphrase("Passphrase of the gta encryption key: ")
+	if err != nil {
+		return "", fmt.Errorf("%v; set %s to pass the passphrase", err, passphraseEnv)
+	}
+	return value, nil
+}
+
+// passphraseNeeded reports whether reading the local files would ask for a
+// passphrase, which commands only pruning them in passing must not do
+func passphraseNeeded() bool {
+	return cfg.State.Encryption.Enabled && cfg.State.Encryption.Key == config.EncryptionKeyPassphrase && os.Getenv(passphraseEnv) == ""
+}
@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// States of a session reported by gta status
+const (
+	sessionActive  = "active"
+	sessionExpired = "expired"
+	sessionRevoked = "revoked"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show how long a grant session has left",
+	Long: `Show a grant session recorded on this machine: the resources and roles it
+granted, its member, when it started and expires, and the time left. With
+--verify, the policies are read to tell whether each binding still exists.
+
+The session is the one given with --session, the most recent one with
+--last, or otherwise the only active session. When several sessions are
+active, or none is, they are listed on stderr and gta exits with status 2.
+
+gta status exits with status 4 when the session has expired, or with
+--verify when all its bindings are gone, so that scripts can branch on it.
+
+Example:
+  gta status
+  gta status --last --verify
+  gta status --session=3f2a9c1d5e6b7a80 --output=json
+  gta status --last || gta grant roles/viewer --project=my-project --keep`,
+	Args: cobra.NoArgs,
+	RunE: runStatus,
+}
+
+// statusOptions are the options of gta status
+type statusOptions struct {
+	Session string
+	Last    bool
+	Verify  bool
+	Output  string
+}
+
+// sessionStatus is a session as gta status reports it
+type sessionStatus struct {
+	ID          string    `json:"id"`
+	Project     string    `json:"project"`
+	Environment string    `json:"environment,omitempty"`
+	Member      string    `json:"member"`
+	Reason      string    `json:"reason,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Remaining is the number of seconds left until the last binding
+	// expires, 0 once it has
+	Remaining int64 `json:"remaining_seconds"`
+	// State is active, expired, or revoked
+	State string `json:"state"`
+	// Status tells who looks after the session, as in gta sessions list
+	Status   string          `json:"status"`
+	Bindings []bindingStatus `json:"bindings"`
+}
+
+// bindingStatus is a binding of a session as gta status reports it
+type bindingStatus struct {
+	Resource  string    `json:"resource"`
+	Role      string    `json:"role"`
+	BindingID string    `json:"binding_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Remaining int64     `json:"remaining_seconds"`
+	// Exists tells whether the binding is still in the policy, nil when not
+	// verified
+	Exists *bool `json:"exists,omitempty"`
+}
+
+func init() {
+	flags := statusCmd.Flags()
+	flags.String("session", "", "ID of the session to show")
+	flags.Bool("last", false, "Show the session started last")
+	flags.Bool("verify", false, "Read the policies to tell whether each binding still exists")
+	flags.StringP("output", "o", outputTable, "Print the session as a table or json")
+	statusCmd.MarkFlagsMutuallyExclusive("session", "last")
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	o := statusOptions{
+		Session: flagString(cmd, "session"),
+		Last:    flagBool(cmd, "last"),
+		Verify:  flagBool(cmd, "verify"),
+		Output:  flagString(cmd, "output"),
+	}
+	switch o.Output {
+	case outputTable, outputJSON:
+	default:
+		return fmt.Errorf("invalid output format %q (expected table or json)", o.Output)
+	}
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s, err := selectSession(f.Sessions, &o, now)
+	if err != nil {
+		return err
+	}
+
+	status := newSessionStatus(s, now)
+	if o.Verify {
+		verifySessionStatus(cmd, s, &status)
+	}
+	if err := writeSessionStatus(os.Stdout, o.Output, status); err != nil {
+		return err
+	}
+	switch status.State {
+	case sessionExpired:
+		return &exitError{err: fmt.Errorf("session %s expired at %s", s.ID, status.ExpiresAt.Local().Format(time.RFC3339)), code: ExitSessionEnded}
+	case sessionRevoked:
+		return &exitError{err: fmt.Errorf("the bindings of session %s are gone from the policies", s.ID), code: ExitSessionEnded}
+	}
+	return nil
+}
+
+// selectSession returns the session of --session or --last, or the only
+// active session at now. Without one to choose, the candidates are listed on
+// stderr and the error exits with ExitUsage.
+func selectSession(sessions []state.Session, o *statusOptions, now time.Time) (state.Session, error) {
+	switch {
+	case o.Session != "":
+		for _, s := range sessions {
+			if s.ID == o.Session {
+				return s, nil
+			}
+		}
+		return state.Session{}, fmt.Errorf("no session %s recorded on this machine: it was revoked, pruned, or granted elsewhere", o.Session)
+	case o.Last:
+		if len(sessions) == 0 {
+			return state.Session{}, fmt.Errorf("no sessions recorded on this machine")
+		}
+		last := sessions[0]
+		for _, s := range sessions[1:] {
+			if s.StartedAt.After(last.StartedAt) {
+				last = s
+			}
+		}
+		return last, nil
+	}
+
+	var active []state.Session
+	for _, s := range sessions {
+		if s.LastExpiry().After(now) {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 1 {
+		return active[0], nil
+	}
+	if len(sessions) == 0 {
+		return state.Session{}, &exitError{err: fmt.Errorf("no sessions recorded on this machine"), code: ExitUsage}
+	}
+	candidates := active
+	if len(active) == 0 {
+		candidates = sessions
+	}
+	if err := writeSessions(os.Stderr, candidates, now); err != nil {
+		return state.Session{}, err
+	}
+	if len(active) == 0 {
+		return state.Session{}, &exitError{err: fmt.Errorf("no session is active, select one with --session=ID or --last"), code: ExitUsage}
+	}
+	return state.Session{}, &exitError{err: fmt.Errorf("%d sessions are active, select one with --session=ID or --last", len(active)), code: ExitUsage}
+}
+
+// newSessionStatus describes s at now from the local state
+func newSessionStatus(s state.Session, now time.Time) sessionStatus {
+	expiry := s.LastExpiry()
+	status := sessionStatus{
+		ID:          s.ID,
+		Project:     s.Project,
+		Environment: s.Environment,
+		Member:      s.Member,
+		Reason:      s.Reason,
+		StartedAt:   s.StartedAt,
+		ExpiresAt:   expiry,
+		Remaining:   remainingSeconds(expiry, now),
+		State:       sessionActive,
+		Status:      s.Status(now),
+		Bindings:    make([]bindingStatus, 0, len(s.Bindings)),
+	}
+	if !expiry.After(now) {
+		status.State = sessionExpired
+	}
+	for _, b := range s.Bindings {
+		resource := b.Target
+		if resource == "" {
+			resource = s.Target()
+		}
+		status.Bindings = append(status.Bindings, bindingStatus{
+			Resource:  resource,
+			Role:      b.Role,
+			BindingID: b.BindingID,
+			ExpiresAt: b.Expiry,
+			Remaining: remainingSeconds(b.Expiry, now),
+		})
+	}
+	return status
+}
+
+// remainingSeconds returns the whole seconds from now to expiry, 0 once past
+func remainingSeconds(expiry, now time.Time) int64 {
+	if !expiry.After(now) {
+		return 0
+	}
+	return int64(expiry.Sub(now) / time.Second)
+}
+
+// verifySessionStatus records whether each binding of status is still in the
+// policy of its resource, and marks the session revoked when none is. The
+// bindings of a policy that cannot be read stay unverified.
+func verifySessionStatus(cmd *cobra.Command, s state.Session, status *sessionStatus) {
+	p, err := newGCPProvider(cmd.Context(), true)
+	if err != nil {
+		logger.Warn("Not verifying the bindings: failed to create GCP provider: %v", err)
+		return
+	}
+	found := make(map[string]map[string]bool)
+	for _, target := range s.Targets() {
+		bindings, err := p.PolicyBindings(target)
+		if err != nil {
+			logger.Warn("Not verifying the bindings on %s: %v", target, err)
+			continue
+		}
+		ids := make(map[string]bool, len(bindings))
+		for _, binding := range bindings {
+			ids[binding.Role+" "+binding.BindingID] = true
+		}
+		found[target] = ids
+	}
+
+	verified, existing := 0, 0
+	for i := range status.Bindings {
+		b := &status.Bindings[i]
+		ids, ok := found[b.Resource]
+		if !ok {
+			continue
+		}
+		exists := ids[b.Role+" "+b.BindingID]
+		b.Exists = &exists
+		verified++
+		if exists {
+			existing++
+		}
+	}
+	if verified > 0 && verified == len(status.Bindings) && existing == 0 && status.State == sessionActive {
+		status.State = sessionRevoked
+	}
+}
+
+// writeSessionStatus prints status as a summary followed by a table of its
+// bindings, or as JSON
+func writeSessionStatus(w io.Writer, output string, status sessionStatus) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Session:\t%s\n", status.ID)
+	if status.Environment != "" {
+		fmt.Fprintf(tw, "Project:\t%s (%s)\n", status.Project, status.Environment)
+	} else {
+		fmt.Fprintf(tw, "Project:\t%s\n", status.Project)
+	}
+	fmt.Fprintf(tw, "Member:\t%s\n", status.Member)
+	if status.Reason != "" {
+		fmt.Fprintf(tw, "Reason:\t%s\n", status.Reason)
+	}
+	fmt.Fprintf(tw, "Started:\t%s\n", status.StartedAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Expires:\t%s\n", status.ExpiresAt.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Remaining:\t%s\n", describeRemaining(status))
+	fmt.Fprintf(tw, "Status:\t%s\n", status.Status)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	bindings := append([]bindingStatus(nil), status.Bindings...)
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return bindings[i].Resource < bindings[j].Resource
+	})
+	verified := false
+	for _, b := range bindings {
+		verified = verified || b.Exists != nil
+	}
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"RESOURCE", "ROLE", "EXPIRY", "REMAINING"}
+	if verified {
+		header = append(header, "EXISTS")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, b := range bindings {
+		row := []string{b.Resource, b.Role, b.ExpiresAt.Local().Format(time.RFC3339), formatRemaining(time.Duration(b.Remaining) * time.Second)}
+		if b.Remaining == 0 {
+			row[3] = "expired"
+		}
+		if verified {
+			switch {
+			case b.Exists == nil:
+				row = append(row, "unknown")
+			case *b.Exists:
+				row = append(row, "yes")
+			default:
+				row = append(row, "no")
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// describeRemaining tells the time left in the session, or how it ended
+func describeRemaining(status sessionStatus) string {
+	switch status.State {
+	case sessionExpired:
+		return "none, expired"
+	case sessionRevoked:
+		return "none, revoked"
+	}
+	return formatRemaining(time.Duration(status.Remaining) * time.Second)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// subnetOption returns the Shared VPC subnetwork given with --subnet, empty
+// when the flag is not set. The project defaults to the host project of the
+// subnetwork but may be the service project the grant is for.
+func subnetOption(cmd *cobra.Command, o *commonOptions) (string, error) {
+	subnet := flagString(cmd, "subnet")
+	if subnet == "" {
+		return "", nil
+	}
+	if kind, err := provider.ParseResource(subnet); err != nil || kind != provider.ResourceSubnet {
+		return "", fmt.Errorf("invalid --subnet %q (expected projects/HOST_PROJECT/regions/REGION/subnetworks/SUBNET)", subnet)
+	}
+	if o.Project == "" {
+		o.Project = provider.ResourceProject(subnet)
+	}
+	return subnet, nil
+}
+
+// resourceOption returns the resource given with --instance or --subnet,
+// empty when neither is set
+func resourceOption(cmd *cobra.Command, o *commonOptions) (string, error) {
+	instance, err := instanceOption(cmd, o)
+	if err != nil || instance != "" {
+		return instance, err
+	}
+	return subnetOption(cmd, o)
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/suggest"
+)
+
+var suggestCmd = &cobra.Command{
+	Use:   "suggest [error message]",
+	Short: "Find the smallest predefined roles granting permissions",
+	Long: `Find the smallest predefined roles that include every given permission,
+ranked by how many permissions they include. Permissions are given with
+--permission or extracted from a pasted error message, or from standard input
+with "-". With --grant, a grant session is started with the top suggestion.
+
+The list of predefined roles is cached in ~/.gta/roles.json for a day; use
+--refresh to fetch it again.
+
+Example:
+  gta suggest --permission storage.objects.get
+  gta suggest "Permission 'cloudsql.instances.connect' denied on resource"
+  gcloud sql connect my-db 2>&1 | gta suggest -
+  gta suggest --permission storage.objects.get --grant --project=my-project`,
+	Args: cobra.ArbitraryArgs,
+	RunE: runSuggest,
+}
+
+// suggestOptions are the options of gta suggest
+type suggestOptions struct {
+	Permissions []string
+	Limit       int
+	Refresh     bool
+	Grant       bool
+}
+
+func init() {
+	flags := suggestCmd.Flags()
+	flags.StringSlice("permission", nil, "Permission the role must include (repeatable)")
+	flags.Int("limit", 10, "Maximum number of roles to show")
+	flags.Bool("refresh", false, "Fetch the predefined roles again instead of using the cache")
+	flags.Bool("grant", false, "Start a grant session with the top suggestion")
+	flags.StringP("provider", "c", "", "Cloud provider (default: detected from the other flags and arguments)")
+	flags.StringP("project", "p", "", "Project ID, required with --grant")
+	flags.StringP("user", "u", "", "User or service account to grant the role to, me, or @NAME of identities in config (defaults to current user)")
+	addDurationFlag(suggestCmd, "ttl", "t", 1*time.Hour, "Time-to-live for the granted permission, e.g. 30m, 8h, 2d, or 1w")
+	flags.StringP("reason", "r", "", "Reason for the access, recorded in the audit log")
+	flags.BoolP("dry-run", "d", false, "Preview changes without applying them")
+	registerMemberCompletion(suggestCmd)
+}
+
+func runSuggest(cmd *cobra.Command, args []string) error {
+	var o suggestOptions
+	o.Permissions, _ = cmd.Flags().GetStringSlice("permission")
+	o.Limit, _ = cmd.Flags().GetInt("limit")
+	o.Refresh = flagBool(cmd, "refresh")
+	o.Grant = flagBool(cmd, "grant")
+
+	ctx := cmd.Context()
+
+	if o.Grant {
+		// Resolve the grant options early to fail before fetching roles
+		if _, err := resolveGrantOptions(cmd); err != nil {
+			return err
+		}
+	}
+	text, err := suggestInput(args)
+	if err != nil {
+		return err
+	}
+	if len(o.Permissions) == 0 && strings.TrimSpace(text) == "" {
+		return fmt.Errorf("give permissions with --permission or an error message to extract them from")
+	}
+
+	catalog, err := loadRoleCatalog(ctx, o.Refresh)
+	if err != nil {
+		return err
+	}
+
+	permissions := append([]string{}, o.Permissions...)
+	for _, permission := range permissions {
+		if !catalog.Known(permission) {
+			return fmt.Errorf("no predefined role includes %s", permission)
+		}
+	}
+	if text != "" {
+		extracted := catalog.ExtractPermissions(text)
+		if len(extracted) == 0 && len(permissions) == 0 {
+			return fmt.Errorf("no known permission found in the error message")
+		}
+		permissions = append(permissions, extracted...)
+	}
+
+	suggestions := catalog.Suggest(permissions)
+	if len(suggestions) == 0 {
+		return fmt.Errorf("no predefined role includes all of %s", strings.Join(permissions, ", "))
+	}
+	logger.Info("Roles including %s, smallest first:", strings.Join(permissions, ", "))
+	for i, s := range suggestions {
+		if i == o.Limit {
+			logger.Info("  ... and %d more", len(suggestions)-i)
+			break
+		}
+		logger.Info("  %s (%s): %d permissions", s.Role, s.Title, s.Permissions)
+	}
+
+	if !o.Grant {
+		return nil
+	}
+	logger.Info("Granting the top suggestion %s", suggestions[0].Role)
+	return runGrant(cmd, []string{suggestions[0].Role})
+}
+
+// roleCatalogFile returns the path of the cache of predefined roles in the
+// data directory
+func roleCatalogFile() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "roles.json"), nil
+}
+
+// loadRoleCatalog returns the predefined roles cached in the data directory,
+// fetching them when the cache is older than a day or refresh is set
+func loadRoleCatalog(ctx context.Context, refresh bool) (*suggest.Catalog, error) {
+	path, err := roleCatalogFile()
+	if err != nil {
+		return nil, err
+	}
+	maxAge := suggest.DefaultCacheMaxAge
+	if refresh {
+		maxAge = 0
+	}
+	return suggest.LoadCatalog(path, maxAge, func() ([]provider.PredefinedRole, error) {
+		logger.Info("Fetching predefined roles, this may take a while...")
+		p, err := newGCPProvider(ctx, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		return p.PredefinedRoles()
+	})
+}
+
+// suggestInput joins the error message arguments, reading standard input for "-"
+func suggestInput(args []string) (string, error) {
+	if len(args) == 1 && args[0] == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read standard input: %v", err)
+		}
+		return string(data), nil
+	}
+	return strings.Join(args, " "), nil
+}
@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// resolveTagMatches resolves the tags of --match-tag for a grant in project,
+// failing on the first one that cannot be found
+func resolveTagMatches(p *provider.GCPProvider, project string, specs []string) ([]provider.TagMatch, error) {
+	var tags []provider.TagMatch
+	for _, spec := range specs {
+		tag, err := p.ResolveTagMatch(project, spec)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Confining the roles to the resources tagged %s", tag)
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// targetsOption parses the --target flags of cmd, each RESOURCE=ROLE[,ROLE]
+// where RESOURCE is a resource name, a project ID, or an alias of projects in
+// config
+func targetsOption(cmd *cobra.Command) ([]provider.GrantTarget, error) {
+	values, _ := cmd.Flags().GetStringArray("target")
+	targets := make([]provider.GrantTarget, 0, len(values))
+	for _, value := range values {
+		resource, roles, ok := strings.Cut(value, "=")
+		if !ok || resource == "" || roles == "" {
+			return nil, fmt.Errorf("invalid --target %q (expected RESOURCE=ROLE[,ROLE])", value)
+		}
+		if strings.Contains(resource, "/") {
+			if _, err := provider.ParseResource(resource); err != nil {
+				return nil, fmt.Errorf("invalid --target %q: %v", value, err)
+			}
+		}
+		target := provider.GrantTarget{Resource: cfg.ProjectAlias(resource)}
+		for _, role := range strings.Split(roles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				target.Roles = append(target.Roles, role)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// targetRoles returns the roles of targets
+func targetRoles(targets []provider.GrantTarget) []string {
+	var roles []string
+	for _, target := range targets {
+		roles = append(roles, target.Roles...)
+	}
+	return roles
+}
+
+// describeGranted lists granted roles grouped by the project or resource
+// they were granted on, e.g. "roles/viewer in projects/p; roles/x on buckets/b"
+func describeGranted(granted []provider.GrantedRole, project string) string {
+	var order []string
+	byResource := make(map[string][]string)
+	for _, role := range granted {
+		resource := role.Resource()
+		if resource == "" {
+			resource = "projects/" + project
+		}
+		if _, ok := byResource[resource]; !ok {
+			order = append(order, resource)
+		}
+		label := role.Role
+		if role.AddedFor != "" {
+			label += " (added for " + role.AddedFor + ")"
+		}
+		byResource[resource] = append(byResource[resource], label)
+	}
+	groups := make([]string, 0, len(order))
+	for _, resource := range order {
+		preposition := "on"
+		if isProjectName(resource) {
+			preposition = "in"
+		}
+		groups = append(groups, fmt.Sprintf("%s %s %s", strings.Join(byResource[resource], ", "), preposition, resource))
+	}
+	return strings.Join(groups, "; ")
+}
+
+// roleLabel names a role for messages, along with the resource it is
+// granted on unless that is a project
+func roleLabel(role, resource string) string {
+	if resource == "" || isProjectName(resource) {
+		return role
+	}
+	return role + " on " + resource
+}
+
+// isProjectName reports whether resource names a project, projects/ID
+func isProjectName(resource string) bool {
+	return strings.HasPrefix(resource, "projects/") && strings.Count(resource, "/") == 1
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/terraform"
+)
+
+// applyTerraformPlan sets the roles to the smallest set of predefined roles
+// that include the permissions the Terraform plan given with
+// --from-terraform-plan needs, and asks for confirmation unless --yes
+func (o *grantOptions) applyTerraformPlan(cmd *cobra.Command) error {
+	plan, err := terraform.LoadPlan(o.TerraformPlan)
+	if err != nil {
+		return err
+	}
+	table := terraform.DefaultTable.Merge(cfg.Terraform.Permissions)
+	required := table.Requirements(plan)
+	if len(required.Unknown) > 0 {
+		logger.Warn("No permissions are known for %s; add them to terraform.permissions in the configuration", strings.Join(required.Unknown, ", "))
+	}
+	if len(required.Permissions) == 0 {
+		return fmt.Errorf("the plan changes no resource with known permissions")
+	}
+
+	catalog, err := loadRoleCatalog(cmd.Context(), false)
+	if err != nil {
+		return err
+	}
+	roles, uncovered := catalog.Cover(required.Permissions)
+	if len(uncovered) > 0 {
+		logger.Warn("No predefined role includes %s", strings.Join(uncovered, ", "))
+	}
+	if len(roles) == 0 {
+		return fmt.Errorf("no predefined role includes the permissions the plan needs")
+	}
+
+	logger.Info("The %d resource(s) of %s need %d permission(s), included in:", required.Resources, o.TerraformPlan, len(required.Permissions))
+	o.Roles = nil
+	for _, role := range roles {
+		logger.Info("  %s (%s)", role.Role, role.Title)
+		o.Roles = append(o.Roles, role.Role)
+	}
+	if o.Yes || o.DryRun {
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("granting the roles of a Terraform plan needs confirmation in a terminal, or --yes")
+	}
+	confirmed, err := confirmStdin("Grant these roles?")
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("grant aborted")
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/dashboard"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+	"github.com/yckao/gta/pkg/tui"
+)
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Show a live dashboard of temporary bindings and local sessions",
+	Long: `Show the temporary bindings of the configured projects and of the sessions
+recorded on this machine full screen, with the time each has left, refreshed
+periodically. Keys act on the selected binding:
+
+  ↑/↓ or j/k  move the selection
+  e           extend the binding by --extend-by
+  r           revoke the binding, once confirmed
+  c           remove the expired bindings of the projects, once confirmed
+  g           read the bindings again
+  q           quit
+
+Only the bindings of sessions left to expire (granted with --keep) can be
+extended; extending grants the role again until the later expiry and then
+revokes the old binding, within the limits of the config and policy. With
+--dry-run, the changes are only reported.
+
+gta tui needs an interactive terminal; use gta list and gta sessions list in
+scripts.
+
+Example:
+  gta tui
+  gta tui -p my-project -p other-project --extend-by=30m`,
+	Args: cobra.NoArgs,
+	RunE: runTUI,
+}
+
+func init() {
+	flags := tuiCmd.Flags()
+	flags.StringArrayP("project", "p", nil, "Project to list the temporary bindings of (repeatable, default is the project of the profile)")
+	addDurationFlag(tuiCmd, "extend-by", "", time.Hour, "How long e extends a binding by (default is default_ttl, or 1h)")
+	addDurationFlag(tuiCmd, "refresh", "", 30*time.Second, "How often the bindings are read again")
+	flags.BoolP("dry-run", "d", false, "Report the changes of the keys without making them")
+	rootCmd.AddCommand(tuiCmd)
+}
+
+func runTUI(cmd *cobra.Command, args []string) error {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if info, err := f.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("gta tui needs an interactive terminal; use gta list --output=json and gta sessions list instead")
+		}
+	}
+	projects, _ := cmd.Flags().GetStringArray("project")
+	if len(projects) == 0 {
+		if project := cfg.ProjectFor(profile); project != "" {
+			projects = []string{project}
+		}
+	}
+	extendBy, err := durationOption(cmd, "extend-by", time.Duration(cfg.DefaultTTL))
+	if err != nil {
+		return err
+	}
+	if extendBy <= 0 {
+		return fmt.Errorf("--extend-by must be positive")
+	}
+	refresh := flagDuration(cmd, "refresh")
+	dryRun, err := boolOption(cmd, "dry-run")
+	if err != nil {
+		return err
+	}
+
+	// Records would draw over the dashboard, so they are shown in it instead
+	logs := tui.NewLogBuffer()
+	logger.SetOutput(logs)
+	defer logger.SetOutput(os.Stderr)
+
+	m := &dashboard.Model{
+		Backend:  &dashboardBackend{ctx: cmd.Context(), dryRun: dryRun},
+		Projects: projects,
+		ExtendBy: extendBy,
+		DryRun:   dryRun,
+	}
+	return tui.Run(cmd.Context(), m, tui.Options{In: os.Stdin, Out: os.Stdout, Refresh: refresh, Logs: logs})
+}
+
+// dashboardBackend carries out the actions of gta tui with the GCP provider
+// and the local state
+type dashboardBackend struct {
+	ctx    context.Context
+	dryRun bool
+	// reader lists the bindings. It caches policies briefly, so it is
+	// replaced after every change to show its effect.
+	reader *provider.GCPProvider
+}
+
+// Sessions implements dashboard.Backend
+func (b *dashboardBackend) Sessions() ([]state.Session, error) {
+	if passphraseNeeded() {
+		return nil, nil
+	}
+	store, err := newStateStore()
+	if err != nil {
+		return nil, err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return f.Sessions, nil
+}
+
+// Bindings implements dashboard.Backend
+func (b *dashboardBackend) Bindings(target string) ([]provider.TemporaryBinding, error) {
+	if b.reader == nil {
+		p, err := newGCPProvider(b.ctx, b.dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCP provider: %v", err)
+		}
+		b.reader = p
+	}
+	return b.reader.PolicyBindings(target)
+}
+
+// Extend implements dashboard.Backend by granting the role of row again
+// until its expiry plus d, then revoking the binding of row
+func (b *dashboardBackend) Extend(row dashboard.Row, d time.Duration) error {
+	defer b.changed()
+	s := row.Session
+	project := targetProject(row.Target, s.Project)
+	ttl := time.Until(row.Expiry).Round(time.Second) + d
+	if cfg.ApprovalRequired(project) {
+		return fmt.Errorf("project %s requires approval, ask for access with gta request instead", project)
+	}
+	if err := checkGrantPolicy([]string{row.Role}, ttl); err != nil {
+		return err
+	}
+
+	p, err := newGCPProvider(b.ctx, b.dryRun, requestReasonOption(s.Reason, ""))
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	opts := &provider.GCPOptions{
+		Project:   project,
+		Roles:     []string{row.Role},
+		Members:   []string{row.Member},
+		TTL:       ttl,
+		Reason:    s.Reason,
+		SessionID: s.ID,
+		Profile:   s.Profile,
+		// The new binding is confined as the one it replaces
+		Condition: row.Condition,
+		// The role was accepted when the session first granted it
+		AcceptBroad: true,
+	}
+	if row.Target != project {
+		opts.Resource = row.Target
+	}
+	if err := checkRoleRules(p.CallerIdentities, opts); err != nil {
+		return err
+	}
+	if len(cfg.AllowedGranters) > 0 || cfg.Policy.Path != "" {
+		caller, err := p.Caller()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		if len(cfg.AllowedGranters) > 0 {
+			if err := checkGranter(caller, opts); err != nil {
+				return err
+			}
+		}
+		if err := enforcePolicy(b.ctx, p, opts, caller); err != nil {
+			return err
+		}
+	}
+	// A single role is granted or not, leaving nothing to roll back
+	err = p.Grant(opts)
+	flushNotifications()
+	if err != nil {
+		return withReauthHint(err)
+	}
+	if b.dryRun {
+		return nil
+	}
+	granted := p.GrantedRoles()
+	if err := b.revoke(row); err != nil {
+		updateSessionBindings(s.ID, granted, nil)
+		return fmt.Errorf("granted until %s, but failed to revoke the old binding: %w", sessionExpiry(granted).Format(time.RFC3339), err)
+	}
+	updateSessionBindings(s.ID, granted, &row)
+	return nil
+}
+
+// Revoke implements dashboard.Backend
+func (b *dashboardBackend) Revoke(row dashboard.Row) error {
+	defer b.changed()
+	if err := b.revoke(row); err != nil {
+		return err
+	}
+	if row.Session != nil && !b.dryRun {
+		updateSessionBindings(row.Session.ID, nil, &row)
+	}
+	return nil
+}
+
+// revoke removes the binding of row from its policy
+func (b *dashboardBackend) revoke(row dashboard.Row) error {
+	project := targetProject(row.Target, cfg.ProjectFor(profile))
+	if row.Session != nil {
+		project = targetProject(row.Target, row.Session.Project)
+	}
+	p, err := newGCPProvider(b.ctx, b.dryRun)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	p.AdoptGrantedRoles([]provider.GrantedRole{{Role: row.Role, BindingID: row.BindingID, Expiry: row.Expiry, Target: row.Target}})
+	opts := &provider.GCPOptions{Project: project, Members: []string{row.Member}, SessionID: row.SessionID, Profile: profile}
+	err = p.Revoke(opts)
+	flushNotifications()
+	return withReauthHint(err)
+}
+
+// CleanExpired implements dashboard.Backend
+func (b *dashboardBackend) CleanExpired(target string) (int, error) {
+	defer b.changed()
+	p, err := newGCPProvider(b.ctx, b.dryRun)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	removed, err := p.CleanTemporaryBindings(&provider.GCPOptions{Project: target, Expired: true, Profile: profile})
+	flushNotifications()
+	return len(removed), withReauthHint(err)
+}
+
+// changed drops the policies read so far, after a change to them
+func (b *dashboardBackend) changed() {
+	b.reader = nil
+}
+
+// updateSessionBindings adds granted to the bindings of session id in the
+// local state and removes that of revoked, dropping the session once it has
+// no binding left. Failures are only warned about as in recordSession.
+func updateSessionBindings(id string, granted []provider.GrantedRole, revoked *dashboard.Row) {
+	store, err := newStateStore()
+	if err != nil {
+		logger.Warn("Failed to update the session state: %v", err)
+		return
+	}
+	err = store.Update(func(f *state.File) error {
+		s, ok := f.Session(id)
+		if !ok {
+			return nil
+		}
+		updated := *s
+		updated.Bindings = nil
+		for _, binding := range s.Bindings {
+			if revoked != nil && binding.BindingID == revoked.BindingID && binding.Role == revoked.Role {
+				continue
+			}
+			updated.Bindings = append(updated.Bindings, binding)
+		}
+		for _, role := range granted {
+			updated.Bindings = append(updated.Bindings, state.Binding{Role: role.Role, BindingID: role.BindingID, Expiry: role.Expiry, Target: role.Target})
+		}
+		if len(updated.Bindings) == 0 {
+			f.Remove(id)
+		} else {
+			f.Put(updated)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Failed to update the session state: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/upgrade"
+	"github.com/yckao/gta/pkg/version"
+)
+
+// upgradeTimeout bounds the release lookup and download
+const upgradeTimeout = 5 * time.Minute
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Update gta to its latest release",
+	Long: `Look up the latest GitHub release of gta and, when it is newer than this one,
+download the archive built for this platform, verify it against the checksums
+published with the release, and atomically replace the running executable.
+
+A gta installed by a package manager such as Homebrew or by go install is left
+alone and the command upgrading it is printed instead. The lookup and download
+use the proxy and TLS settings of the http config.
+
+Example:
+  gta upgrade --check
+  gta upgrade`,
+	Args: cobra.NoArgs,
+	RunE: runUpgrade,
+}
+
+func init() {
+	upgradeCmd.Flags().Bool("check", false, "Only report whether a newer release is available")
+	upgradeCmd.Flags().Bool("force", false, "Install the latest release even when it is not newer, or gta was installed by a package manager")
+	upgradeCmd.Flags().String("api-url", upgrade.DefaultAPIURL, "GitHub API URL to look releases up with")
+	_ = upgradeCmd.Flags().MarkHidden("api-url")
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+func runUpgrade(cmd *cobra.Command, args []string) error {
+	check := flagBool(cmd, "check")
+	force := flagBool(cmd, "force")
+	ctx := cmd.Context()
+
+	transport, err := networkTransport()
+	if err != nil {
+		return err
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	u := &upgrade.Updater{
+		Client: &http.Client{Transport: transport, Timeout: upgradeTimeout},
+		APIURL: flagString(cmd, "api-url"),
+	}
+
+	current := version.String()
+	release, err := u.Latest(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to look up the latest release: %w", err)
+	}
+	newer, known := upgrade.Newer(current, release.Tag)
+	switch {
+	case !known:
+		logger.Info("Latest release is %s; this gta is %s, which cannot be compared with it", release.Tag, current)
+	case !newer:
+		logger.Info("gta %s is up to date (latest release is %s)", current, release.Tag)
+	default:
+		logger.Info("gta %s is available (this is %s)", release.Tag, current)
+	}
+	if check {
+		if newer && release.URL != "" {
+			logger.Info("Release notes: %s", release.URL)
+		}
+		return nil
+	}
+	if known && !newer && !force {
+		return nil
+	}
+	if !known && !force {
+		return fmt.Errorf("cannot tell whether %s is newer than %s, pass --force to install it anyway", release.Tag, current)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the gta executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	if name, command, ok := installedBy(exe); ok && !force {
+		logger.Info("gta was installed by %s, upgrade it with: %s", name, command)
+		return nil
+	}
+	windows := runtime.GOOS == "windows"
+	if windows {
+		upgrade.RemoveOld(exe)
+	}
+
+	asset, err := release.Asset(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	logger.Info("Downloading %s", asset.Name)
+	binary, err := u.Download(ctx, release, asset)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", release.Tag, err)
+	}
+	if err := upgrade.Replace(exe, binary, windows); err != nil {
+		return err
+	}
+	logger.Info("Upgraded %s from %s to %s", exe, current, release.Tag)
+	return nil
+}
+
+// installedBy returns the package manager that installed the executable at
+// exe and the command upgrading it, counting go install as one
+func installedBy(exe string) (name, command string, ok bool) {
+	if name, command, ok := upgrade.PackageManager(exe); ok {
+		return name, command, true
+	}
+	// Release builds set the version at link time, go install records the
+	// module version only
+	if version.Version == "" && version.String() != "dev" {
+		return "go install", "go install github.com/yckao/gta@latest", true
+	}
+	return "", "", false
+}
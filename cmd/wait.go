@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/notify"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/session"
+	"github.com/yckao/gta/pkg/state"
+)
+
+var waitCmd = &cobra.Command{
+	Use:   "wait",
+	Short: "Wait in the foreground on a session granted earlier, revoking it on exit",
+	Long: `Attach to a session recorded on this machine, such as one granted with --keep
+or --export-session, and wait on it as gta grant does: until its bindings
+expire or the process is interrupted with Ctrl+C, then revoke them. The
+bindings are read from the policies first, and those gone are left out; the
+reminder, the notifications, and --watch work as for gta grant.
+
+A session attended by another gta process still running, or carried out by
+gta schedule run, is refused: the process owning it revokes it when it ends,
+and interrupting it with Ctrl+C revokes it right away. A session whose
+process is gone is taken over.
+
+Example:
+  gta grant roles/viewer --project=my-project --ttl=4h --keep
+  gta wait --session=3f2a9c1d5e6b7a80
+  gta wait --session=3f2a9c1d5e6b7a80 --watch`,
+	Args: cobra.NoArgs,
+	RunE: runWait,
+}
+
+func init() {
+	flags := waitCmd.Flags()
+	flags.String("session", "", "ID of the session to wait on (required)")
+	flags.Bool("watch", false, "Warn when another writer removes or changes the bindings during the session (default from watch.enabled)")
+	flags.Bool("auto-regrant", false, "Grant the roles removed or changed during the session again without asking, implies --watch")
+	waitCmd.MarkFlagRequired("session")
+}
+
+func runWait(cmd *cobra.Command, args []string) error {
+	id := flagString(cmd, "session")
+	watch := flagBool(cmd, "watch") || cfg.Watch.Enabled
+	autoRegrant := flagBool(cmd, "auto-regrant") || cfg.Watch.AutoRegrant
+	ctx := cmd.Context()
+
+	store, err := newStateStore()
+	if err != nil {
+		return err
+	}
+	f, err := store.Load()
+	if err != nil {
+		return err
+	}
+	found, ok := f.Session(id)
+	if !ok {
+		return fmt.Errorf("no session %s recorded on this machine: it was revoked, pruned, or granted elsewhere", id)
+	}
+	s := *found
+	if _, err := attachable(s, os.Getpid(), time.Now(), processAlive); err != nil {
+		return err
+	}
+
+	log := useSessionLogger(s.ID, s.Project)
+	if _, err := newEventSink(ctx, false); err != nil {
+		return err
+	}
+	providerOpts := append(confirmOptions(), provider.WithLogger(log))
+	p, err := newGCPProvider(ctx, false, providerOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to create GCP provider: %v", err)
+	}
+	granted, opts := sessionGrant(s)
+	granted, err = existingRoles(p, s, granted)
+	if err != nil {
+		return err
+	}
+	if len(granted) == 0 {
+		return &exitError{err: fmt.Errorf("the bindings of session %s are gone from the policies, nothing to wait for", s.ID), code: ExitSessionEnded}
+	}
+
+	// The session is claimed under the lock of the state, checking again
+	// that no other process claimed it since it was read
+	var takeOver bool
+	err = store.Update(func(f *state.File) error {
+		current, ok := f.Session(id)
+		if !ok {
+			return fmt.Errorf("session %s was removed from the local state, e.g. revoked by another process", id)
+		}
+		if takeOver, err = attachable(*current, os.Getpid(), time.Now(), processAlive); err != nil {
+			return err
+		}
+		current.Kept = false
+		current.PID = os.Getpid()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if takeOver {
+		logger.Info("Taking over session %s, whose gta process %d is gone", s.ID, s.PID)
+	}
+	p.AdoptGrantedRoles(granted)
+	logger.Info("Attached to session %s of %s in %s", s.ID, s.Member, describeGranted(granted, s.Project))
+
+	recorder := metricsRecorder()
+	recorder.SessionStarted()
+	defer recorder.SessionEnded()
+
+	timer := session.NewTimer(revokeTime(opts, granted))
+	scheduleReminder(timer, []notify.Notification{sessionNotification(s, granted)})
+
+	stopWatch := func() {}
+	if watch || autoRegrant {
+		stopWatch = startWatch(&sessionWatch{
+			p:           p,
+			opts:        opts,
+			interval:    cfg.WatchInterval(),
+			autoRegrant: autoRegrant,
+			prompt:      len(confirmOptions()) > 0,
+		})
+	}
+	logger.Info("Waiting until %s or interrupt signal to revoke roles (Ctrl+C to exit)...", timer.Expiry().Format(time.RFC3339))
+	if err := timer.Run(ctx); err == nil {
+		logger.Info("Session TTL expired")
+	}
+	stopWatch()
+
+	logger.Info("Revoking roles...")
+	detach(p)
+	err = revokeWithReauth(opts.SessionID, func() error {
+		err := p.Revoke(opts)
+		flushNotifications()
+		return err
+	}, freshRevoke(context.Background(), opts, p.GrantedRoles(), providerOpts...))
+	if err != nil {
+		return fmt.Errorf("failed to revoke roles: %w", err)
+	}
+	forgetSession(opts.SessionID)
+	return nil
+}
+
+// attachable tells whether gta wait, running as pid self, may attach to s at
+// now: a session left to expire, or one whose process is gone by alive, which
+// is taken over. A session owned by another process running is refused with
+// the reason.
+func attachable(s state.Session, self int, now time.Time, alive func(pid int) bool) (takeOver bool, err error) {
+	switch {
+	case s.Provider != "gcp":
+		return false, fmt.Errorf("session %s was granted on %s, only gcp sessions are supported", s.ID, s.Provider)
+	case !s.LastExpiry().After(now):
+		return false, &exitError{err: fmt.Errorf("session %s expired at %s, nothing to wait for", s.ID, s.LastExpiry().Local().Format(time.RFC3339)), code: ExitSessionEnded}
+	case s.PendingRevocation != nil:
+		return false, fmt.Errorf("session %s is pending revocation, finish it with gta revoke --from-state --session=%s", s.ID, s.ID)
+	case s.ScheduleID != "":
+		return false, fmt.Errorf("session %s is a window of schedule %s, carried out by gta schedule run, which revokes it when the window ends", s.ID, s.ScheduleID)
+	case s.Kept:
+		return false, nil
+	case s.PID == self:
+		return false, fmt.Errorf("session %s is already attended by this process", s.ID)
+	case alive(s.PID):
+		return false, fmt.Errorf("session %s is attended by gta process %d, which revokes it when it ends; interrupt it with Ctrl+C to revoke it now, or let it be", s.ID, s.PID)
+	}
+	return true, nil
+}
+
+// existingRoles returns the roles of granted still bound in the policies of
+// session s, warning about those gone
+func existingRoles(p *provider.GCPProvider, s state.Session, granted []provider.GrantedRole) ([]provider.GrantedRole, error) {
+	found := make(map[string]bool)
+	for _, target := range s.Targets() {
+		bindings, err := p.PolicyBindings(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify the bindings of session %s: %w", s.ID, err)
+		}
+		for _, binding := range bindings {
+			found[target+" "+binding.Role+" "+binding.BindingID] = true
+		}
+	}
+	var existing []provider.GrantedRole
+	for _, role := range granted {
+		target := role.Target
+		if target == "" {
+			target = s.Target()
+		}
+		if !found[target+" "+role.Role+" "+role.BindingID] {
+			logger.Warn("Leaving out %s, whose binding %s is gone from the policy", roleLabel(role.Role, role.Resource()), role.BindingID)
+			continue
+		}
+		existing = append(existing, role)
+	}
+	return existing, nil
+}
+
+// sessionNotification describes the grant of session s for the reminder
+// sent before its roles expire
+func sessionNotification(s state.Session, granted []provider.GrantedRole) notify.Notification {
+	roles := make([]string, len(granted))
+	for i, role := range granted {
+		roles[i] = role.Role
+	}
+	return notify.Notification{
+		Action:      audit.ActionGrant,
+		Project:     s.Project,
+		Environment: s.Environment,
+		Roles:       roles,
+		Member:      s.Member,
+		Expiry:      sessionExpiry(granted),
+		Reason:      s.Reason,
+		SessionID:   s.ID,
+	}
+}
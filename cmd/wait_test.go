@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/state"
+)
+
+func TestAttachable(t *testing.T) {
+	now := time.Now()
+	const self, other = 100, 200
+	session := func(set func(s *state.Session)) state.Session {
+		s := state.Session{ID: "s1", Provider: "gcp", Project: "p", PID: other, Bindings: []state.Binding{{Role: "roles/viewer", BindingID: "gta_1", Expiry: now.Add(time.Hour)}}}
+		if set != nil {
+			set(&s)
+		}
+		return s
+	}
+	for _, tc := range []struct {
+		name    string
+		session state.Session
+		// running are the PIDs alive reports running
+		running []int
+		// wantChecked tells whether alive is asked about the owner
+		wantChecked  bool
+		wantTakeOver bool
+		wantErr      string
+		wantCode     int
+	}{
+		{name: "left to expire", session: session(func(s *state.Session) { s.Kept = true }), running: []int{other}},
+		{name: "process gone", session: session(nil), wantChecked: true, wantTakeOver: true},
+		{name: "process running", session: session(nil), running: []int{other}, wantChecked: true, wantErr: "attended by gta process 200"},
+		{name: "this process", session: session(func(s *state.Session) { s.PID = self }), running: []int{self}, wantErr: "already attended by this process"},
+		{
+			name:     "expired",
+			session:  session(func(s *state.Session) { s.Bindings[0].Expiry = now.Add(-time.Minute) }),
+			wantErr:  "nothing to wait for",
+			wantCode: ExitSessionEnded,
+		},
+		{
+			name:    "pending revocation",
+			session: session(func(s *state.Session) { s.Kept, s.PendingRevocation = true, &state.Revocation{Attempts: 1} }),
+			wantErr: "gta revoke --from-state --session=s1",
+		},
+		{name: "scheduled", session: session(func(s *state.Session) { s.ScheduleID = "nightly" }), wantErr: "window of schedule nightly"},
+		{name: "other provider", session: session(func(s *state.Session) { s.Provider = "aws" }), wantErr: "granted on aws"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var asked []int
+			alive := func(pid int) bool {
+				asked = append(asked, pid)
+				for _, running := range tc.running {
+					if pid == running {
+						return true
+					}
+				}
+				return false
+			}
+			takeOver, err := attachable(tc.session, self, now, alive)
+			if tc.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("attachable = %v, want %q", err, tc.wantErr)
+			}
+			if takeOver != tc.wantTakeOver {
+				t.Errorf("take over %v, want %v", takeOver, tc.wantTakeOver)
+			}
+			if tc.wantCode != 0 && ExitCode(err) != tc.wantCode {
+				t.Errorf("exit code %d, want %d", ExitCode(err), tc.wantCode)
+			}
+			// Only a session whose owner may be gone is checked for it
+			if checked := len(asked) > 0; checked != tc.wantChecked {
+				t.Errorf("asked whether %v are alive", asked)
+			}
+		})
+	}
+}
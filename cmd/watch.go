@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// sessionWatch checks the bindings of a waiting session for removals and
+// changes made by other writers, such as a colleague running gta clean
+type sessionWatch struct {
+	p           *provider.GCPProvider
+	opts        *provider.GCPOptions
+	interval    time.Duration
+	autoRegrant bool
+	// prompt offers to grant the changed roles again on Enter, which needs
+	// the terminal not to be used by a wrapped command
+	prompt bool
+	// reported are the changes already warned about, by binding ID and kind
+	reported map[string]bool
+}
+
+// startWatch checks the bindings of the session every interval until the
+// returned function is called, which waits for the check in flight
+func startWatch(w *sessionWatch) (stop func()) {
+	w.reported = make(map[string]bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.run(ctx)
+	}()
+	logger.Debug("Checking the bindings of the session every %v", w.interval)
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// run checks the bindings every interval until ctx is done
+func (w *sessionWatch) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changes, err := w.p.CheckGrantedRoles(w.opts)
+		if err != nil {
+			logger.Warn("Failed to check the bindings of the session: %v", withReauthHint(err))
+		}
+		changes = w.fresh(changes)
+		if len(changes) == 0 {
+			continue
+		}
+
+		logger.Warn("!!! The bindings of this session were changed by someone else !!!")
+		for _, change := range changes {
+			logger.Warn("!!! %s", change)
+		}
+		w.p.ReportChanges(w.opts, changes)
+		flushNotifications()
+
+		switch {
+		case w.autoRegrant:
+		case w.prompt:
+			logger.Warn("Press Enter to grant the roles again, or ignore this to keep the session as it is")
+			if !waitForEnter(ctx) {
+				return
+			}
+		default:
+			logger.Warn("Grant the roles again with gta grant, or wait with --auto-regrant to do so automatically")
+			continue
+		}
+		err = w.p.Regrant(w.opts, changes)
+		flushNotifications()
+		recordSession(w.opts, w.p.GrantedRoles())
+		if err != nil {
+			logger.Warn("Failed to grant the roles again: %v", withReauthHint(err))
+		}
+	}
+}
+
+// fresh returns the changes not reported before, marking them reported
+func (w *sessionWatch) fresh(changes []provider.BindingChange) []provider.BindingChange {
+	var fresh []provider.BindingChange
+	for _, change := range changes {
+		key := change.Role.BindingID + "|" + change.Kind
+		if !w.reported[key] {
+			w.reported[key] = true
+			fresh = append(fresh, change)
+		}
+	}
+	return fresh
+}
+
+// waitForEnter reports whether a line was read from the terminal before ctx
+// was done
+func waitForEnter(ctx context.Context) bool {
+	entered := make(chan struct{})
+	go func() {
+		if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err == nil {
+			close(entered)
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-entered:
+		return true
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/config"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/notify"
+)
+
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Manage the lifecycle event webhook",
+}
+
+var webhookTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a sample event to the configured webhook or chat channel",
+	Long: `Send a sample grant event to the webhook configured under notifications.webhook
+of the active profile, signed the same way as real events. The event uses the
+session ID "test" so that receivers can tell it apart.
+
+With --channel slack, google_chat, or teams, a sample grant notification is
+sent to that chat webhook of the active profile instead, to check how it is
+rendered.
+
+Example:
+  gta webhook test
+  gta webhook test --profile prod
+  gta webhook test --channel teams`,
+	Args: cobra.NoArgs,
+	RunE: runWebhookTest,
+}
+
+func init() {
+	webhookTestCmd.Flags().String("channel", "webhook", "Where to send the sample: webhook, slack, google_chat, or teams")
+	webhookCmd.AddCommand(webhookTestCmd)
+}
+
+func runWebhookTest(cmd *cobra.Command, args []string) error {
+	if channel := flagString(cmd, "channel"); channel != "webhook" {
+		return testChannel(cmd.Context(), channel)
+	}
+	webhook := newWebhook()
+	if webhook == nil {
+		return fmt.Errorf("notifications.webhook is not configured")
+	}
+
+	event := audit.NewEvent(audit.ActionGrant)
+	event.SessionID = "test"
+	event.Project = "example-project"
+	event.Role = "roles/viewer"
+	event.Member = "user:test@example.com"
+	event.BindingID = "gta_temporary_access_test"
+	event.Expiry = event.Time.Add(time.Hour)
+	event.Reason = "gta webhook test"
+
+	logger.Info("Sending sample %s event to the webhook...", event.Action)
+	if err := webhook.Deliver(cmd.Context(), event); err != nil {
+		return fmt.Errorf("failed to deliver sample event: %v", err)
+	}
+	logger.Info("Webhook accepted the sample event")
+	return nil
+}
+
+// testChannel sends a sample grant notification to a chat webhook of the
+// active profile
+func testChannel(ctx context.Context, channel string) error {
+	settings := cfg.NotificationsFor(profile)
+	var webhook *config.WebhookConfig
+	var newNotifier func(webhookURL string) notify.Notifier
+	switch channel {
+	case "slack":
+		webhook = settings.Slack
+		newNotifier = func(u string) notify.Notifier { return notify.NewSlackNotifier(u) }
+	case "google_chat":
+		webhook = settings.GoogleChat
+		newNotifier = func(u string) notify.Notifier { return notify.NewChatNotifier(u) }
+	case "teams":
+		webhook = settings.Teams
+		newNotifier = func(u string) notify.Notifier { return notify.NewTeamsNotifier(u) }
+	default:
+		return fmt.Errorf("invalid channel %q (expected webhook, slack, google_chat, or teams)", channel)
+	}
+	if webhook == nil {
+		return fmt.Errorf("notifications.%s is not configured", channel)
+	}
+	notifier := newNotifier(webhook.WebhookURL)
+
+	now := time.Now()
+	n := notify.Notification{
+		Action:    audit.ActionGrant,
+		Project:   "example-project",
+		Roles:     []string{"roles/viewer"},
+		Member:    "user:test@example.com",
+		TTL:       time.Hour,
+		Expiry:    now.Add(time.Hour),
+		Reason:    "gta webhook test",
+		SessionID: "test",
+		Caller:    "test@example.com",
+	}
+	logger.Info("Sending sample %s notification to %s...", n.Action, notifier.Name())
+	if err := notifier.Notify(ctx, n); err != nil {
+		return fmt.Errorf("failed to deliver sample notification: %v", err)
+	}
+	logger.Info("%s accepted the sample notification", notifier.Name())
+	return nil
+}
@@ -0,0 +1,16 @@
+# Deny grants outside business hours unless they are break-glass grants.
+package gta
+
+import rego.v1
+
+deny contains msg if {
+	not input.break_glass
+	not business_hours
+	msg := sprintf("grants are only allowed on weekdays between 08:00 and 18:00 UTC, not %s %02d:00", [input.weekday, input.hour])
+}
+
+business_hours if {
+	not input.weekday in {"Saturday", "Sunday"}
+	input.hour >= 8
+	input.hour < 18
+}
@@ -0,0 +1,23 @@
+# Require a reason and clamp the TTL to 30 minutes in projects labelled env=prod,
+# and never grant owner there.
+package gta
+
+import rego.v1
+
+production if input.project_labels.env == "prod"
+
+deny contains "a reason is required in production projects" if {
+	production
+	input.reason == ""
+}
+
+deny contains sprintf("%s cannot be granted in production projects", [role]) if {
+	production
+	some role in input.roles
+	role == "roles/owner"
+}
+
+ttl := "30m" if {
+	production
+	input.ttl_seconds > 1800
+}
@@ -0,0 +1,11 @@
+# Only allow granting roles to yourself, except for members of the platform team.
+package gta
+
+import rego.v1
+
+platform_team := {"alice@example.com", "bob@example.com"}
+
+deny contains sprintf("%s cannot grant roles to %s", [input.caller, input.member]) if {
+	input.member != input.caller
+	not input.caller in platform_team
+}
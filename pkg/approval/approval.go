@@ -0,0 +1,123 @@
+// Package approval implements access requests that must be approved by a
+// second person before the grant is applied
+package approval
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+// Status is the state of a request
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	// StatusExpired is never stored; it is derived for pending requests past their expiry
+	StatusExpired Status = "expired"
+)
+
+// ErrNotFound is returned when a request does not exist in the store
+var ErrNotFound = errors.New("request not found")
+
+// Request is a signed record asking for temporary access
+type Request struct {
+	ID         string        `json:"id"`
+	Project    string        `json:"project"`
+	Roles      []string      `json:"roles"`
+	Requester  string        `json:"requester"`
+	TTL        time.Duration `json:"ttl"`
+	Reason     string        `json:"reason"`
+	CreatedAt  time.Time     `json:"created_at"`
+	ExpiresAt  time.Time     `json:"expires_at"`
+	Status     Status        `json:"status"`
+	Approver   string        `json:"approver,omitempty"`
+	ApprovedAt time.Time     `json:"approved_at,omitempty"`
+	Signature  string        `json:"signature"`
+}
+
+// StatusAt returns the status of the request at the given time
+func (r *Request) StatusAt(now time.Time) Status {
+	if r.Status == StatusPending && now.After(r.ExpiresAt) {
+		return StatusExpired
+	}
+	return r.Status
+}
+
+// Sign computes the request signature with key, covering every field
+func (r *Request) Sign(key []byte) error {
+	sig, err := r.signature(key)
+	if err != nil {
+		return err
+	}
+	r.Signature = sig
+	return nil
+}
+
+// Verify checks that the request was signed with key and not modified since
+func (r *Request) Verify(key []byte) error {
+	sig, err := r.signature(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(r.Signature)) {
+		return fmt.Errorf("invalid signature on request %s", r.ID)
+	}
+	return nil
+}
+
+// signature returns the hex-encoded HMAC-SHA256 of the request without its signature
+func (r *Request) signature(key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", fmt.Errorf("no signing key configured")
+	}
+	unsigned := *r
+	unsigned.Signature = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Store persists requests where both requesters and approvers can reach them
+type Store interface {
+	Save(ctx context.Context, r *Request) error
+	// Load returns ErrNotFound if the request does not exist
+	Load(ctx context.Context, id string) (*Request, error)
+	List(ctx context.Context) ([]*Request, error)
+}
+
+// NewStore creates the store for location, which is either a gs://bucket/prefix
+// URL or a local directory. Options configure the Cloud Storage client.
+func NewStore(ctx context.Context, location string, opts ...option.ClientOption) (Store, error) {
+	if strings.HasPrefix(location, gcsScheme) {
+		return NewGCSStore(ctx, location, opts...)
+	}
+	return NewLocalStore(location)
+}
+
+// decode parses a stored request
+func decode(data []byte) (*Request, error) {
+	var r Request
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse request: %v", err)
+	}
+	return &r, nil
+}
+
+// objectName returns the file or object name of a request
+func objectName(id string) string {
+	return id + ".json"
+}
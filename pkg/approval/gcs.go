@@ -0,0 +1,125 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// gcsScheme prefixes store locations in Cloud Storage
+const gcsScheme = "gs://"
+
+// GCSStore keeps requests as JSON objects in a Cloud Storage bucket
+type GCSStore struct {
+	service *storage.Service
+	bucket  string
+	prefix  string
+}
+
+// NewGCSStore creates a store for a gs://bucket/prefix location
+func NewGCSStore(ctx context.Context, location string, opts ...option.ClientOption) (*GCSStore, error) {
+	bucket, prefix, err := ParseGCSLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]option.ClientOption{option.WithScopes(storage.DevstorageReadWriteScope)}, opts...)
+	service, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Storage service: %v", err)
+	}
+	return &GCSStore{service: service, bucket: bucket, prefix: prefix}, nil
+}
+
+// ParseGCSLocation splits a gs://bucket/prefix location into bucket and object prefix
+func ParseGCSLocation(location string) (string, string, error) {
+	if !strings.HasPrefix(location, gcsScheme) {
+		return "", "", fmt.Errorf("invalid Cloud Storage location %q (expected gs://bucket/prefix)", location)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, gcsScheme), "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("invalid Cloud Storage location %q: missing bucket", location)
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return bucket, prefix, nil
+}
+
+// Save implements Store
+func (s *GCSStore) Save(ctx context.Context, r *Request) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+	object := &storage.Object{Name: s.prefix + objectName(r.ID), ContentType: "application/json"}
+	if _, err := s.service.Objects.Insert(s.bucket, object).Media(bytes.NewReader(data)).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to write request: %w", err)
+	}
+	return nil
+}
+
+// Load implements Store
+func (s *GCSStore) Load(ctx context.Context, id string) (*Request, error) {
+	if strings.Contains(id, "/") {
+		return nil, ErrNotFound
+	}
+	return s.load(ctx, s.prefix+objectName(id))
+}
+
+// load downloads and decodes a request object
+func (s *GCSStore) load(ctx context.Context, name string) (*Request, error) {
+	resp, err := s.service.Objects.Get(s.bucket, name).Context(ctx).Download()
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %v", err)
+	}
+	return decode(data)
+}
+
+// List implements Store, returning requests oldest first
+func (s *GCSStore) List(ctx context.Context) ([]*Request, error) {
+	var names []string
+	err := s.service.Objects.List(s.bucket).Prefix(s.prefix).Pages(ctx, func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			// Skip objects in nested "directories" and foreign files
+			rest := strings.TrimPrefix(object.Name, s.prefix)
+			if !strings.Contains(rest, "/") && path.Ext(rest) == ".json" {
+				names = append(names, object.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list requests: %w", err)
+	}
+
+	requests := make([]*Request, 0, len(names))
+	for _, name := range names {
+		r, err := s.load(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		requests = append(requests, r)
+	}
+	sortRequests(requests)
+	return requests, nil
+}
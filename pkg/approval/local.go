@@ -0,0 +1,84 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalStore keeps requests as JSON files in a directory, which may be shared
+// between users, e.g. on a network file system
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates a store in dir, creating the directory if needed
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create request directory: %v", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// Save implements Store
+func (s *LocalStore) Save(ctx context.Context, r *Request) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, objectName(r.ID)), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write request: %v", err)
+	}
+	return nil
+}
+
+// Load implements Store
+func (s *LocalStore) Load(ctx context.Context, id string) (*Request, error) {
+	// A colon would name an alternate data stream on Windows
+	if strings.ContainsAny(id, `/\:`) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, objectName(id)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read request: %v", err)
+	}
+	return decode(data)
+}
+
+// List implements Store, returning requests oldest first
+func (s *LocalStore) List(ctx context.Context) ([]*Request, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, objectName("*")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list requests: %v", err)
+	}
+
+	requests := make([]*Request, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request: %v", err)
+		}
+		r, err := decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		requests = append(requests, r)
+	}
+	sortRequests(requests)
+	return requests, nil
+}
+
+// sortRequests orders requests by creation time
+func sortRequests(requests []*Request) {
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+}
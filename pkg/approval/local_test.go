@@ -0,0 +1,35 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Request{ID: "req-20240514-ab12", Project: "p", Roles: []string{"roles/viewer"}, Requester: "alice@example.com", TTL: time.Hour, CreatedAt: time.Date(2024, 5, 14, 9, 30, 0, 0, time.UTC), Status: StatusPending}
+	if err := s.Save(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Load(ctx, r.ID)
+	if err != nil || got.Requester != r.Requester || got.TTL != r.TTL || !got.CreatedAt.Equal(r.CreatedAt) {
+		t.Fatalf("Load = %+v, %v", got, err)
+	}
+	if list, err := s.List(ctx); err != nil || len(list) != 1 {
+		t.Errorf("List = %v, %v", list, err)
+	}
+
+	// IDs naming other files, or an alternate data stream on Windows, are
+	// not requests
+	for _, id := range []string{"missing", "../req-20240514-ab12", `..\req-20240514-ab12`, "req-20240514-ab12:stream", "req-20240514-ab12.json:$DATA"} {
+		if _, err := s.Load(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Package audit records lifecycle events of temporary access and delivers them to sinks
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/encryption"
+	"github.com/yckao/gta/pkg/fileutil"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// Action identifies the kind of lifecycle event
+type Action string
+
+const (
+	ActionGrant  Action = "grant"
+	ActionRevoke Action = "revoke"
+	ActionClean  Action = "clean"
+	// ActionRequest records a request for access awaiting approval
+	ActionRequest Action = "request"
+	// ActionModified records a granted binding removed or changed by another
+	// writer during its session
+	ActionModified Action = "modified"
+)
+
+// Event is a single lifecycle event of a temporary binding. The same payload is
+// written to the local audit log and to every configured exporter.
+type Event struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    Action    `json:"action"`
+	SessionID string    `json:"session_id,omitempty"`
+	Project   string    `json:"project"`
+	// Resource is the resource granted on within Project, empty for the
+	// project itself
+	Resource  string    `json:"resource,omitempty"`
+	Role      string    `json:"role"`
+	Member    string    `json:"member"`
+	BindingID string    `json:"binding_id,omitempty"`
+	Expiry    time.Time `json:"expiry,omitempty"`
+	// RevokeAt is when the session revokes the binding, ahead of Expiry
+	// with a grace period
+	RevokeAt  time.Time `json:"revoke_at,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Caller    string    `json:"caller,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Requester string    `json:"requester,omitempty"`
+	Approver  string    `json:"approver,omitempty"`
+	// Profile is the config profile the event was produced with
+	Profile string `json:"profile,omitempty"`
+	// Environment is the environment of Project, e.g. prod
+	Environment string `json:"environment,omitempty"`
+	// BreakGlass marks emergency grants bypassing approval for Incident
+	BreakGlass bool   `json:"break_glass,omitempty"`
+	Incident   string `json:"incident,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Delivery marks a copy of an event appended to the audit log to record
+	// its delivery by an exporter, rather than an event of its own
+	Delivery *Delivery `json:"delivery,omitempty"`
+}
+
+// Delivery records whether an exporter delivered an event
+type Delivery struct {
+	Exporter  string `json:"exporter"`
+	Delivered bool   `json:"delivered"`
+}
+
+// NewEvent creates an event with a fresh ID and the current time
+func NewEvent(action Action) Event {
+	return Event{
+		ID:     NewID(),
+		Time:   time.Now().UTC(),
+		Action: action,
+	}
+}
+
+// NewID returns a random identifier suitable for events and sessions
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Sink receives lifecycle events. Implementations must not block the caller
+// for long and must never fail the IAM operation that produced the event.
+type Sink interface {
+	Emit(event Event)
+	Close() error
+}
+
+// Multi fans events out to several sinks
+type Multi []Sink
+
+// Emit implements Sink
+func (m Multi) Emit(event Event) {
+	for _, sink := range m {
+		sink.Emit(event)
+	}
+}
+
+// Close implements Sink
+func (m Multi) Close() error {
+	var firstErr error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Nop is a Sink that discards all events
+type Nop struct{}
+
+func (Nop) Emit(Event)   {}
+func (Nop) Close() error { return nil }
+
+// Log appends events as JSON lines to a local file
+type Log struct {
+	mu     sync.Mutex
+	path   string
+	cipher *encryption.Cipher
+}
+
+// NewLog creates a log writing to path, creating its directory if needed.
+// Lines are encrypted with cipher unless it is nil.
+func NewLog(path string, cipher *encryption.Cipher) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+	return &Log{path: path, cipher: cipher}, nil
+}
+
+// Emit implements Sink
+func (l *Log) Emit(event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Warn("Failed to encode audit event: %v", err)
+		return
+	}
+	if l.cipher != nil {
+		data = l.cipher.EncryptLine(data)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		logger.Warn("Failed to open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logger.Warn("Failed to write audit log: %v", err)
+	}
+}
+
+// Close implements Sink
+func (l *Log) Close() error {
+	return nil
+}
+
+// ReadLog reads all events from a JSON lines audit log, decrypting the lines
+// encrypted with cipher. Delivery records are left out.
+func ReadLog(path string, cipher *encryption.Cipher) ([]Event, error) {
+	records, err := readLog(path, cipher)
+	if err != nil {
+		return nil, err
+	}
+	events := records[:0]
+	for _, event := range records {
+		if event.Delivery == nil {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Undelivered returns the events of a JSON lines audit log recorded as
+// undelivered by exporter and not recorded as delivered since, oldest first
+func Undelivered(path string, cipher *encryption.Cipher, exporter string) ([]Event, error) {
+	records, err := readLog(path, cipher)
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	pending := make(map[string]Event)
+	for _, event := range records {
+		if event.Delivery == nil || event.Delivery.Exporter != exporter {
+			continue
+		}
+		if event.Delivery.Delivered {
+			delete(pending, event.ID)
+			continue
+		}
+		if _, ok := pending[event.ID]; !ok {
+			order = append(order, event.ID)
+		}
+		event.Delivery = nil
+		pending[event.ID] = event
+	}
+	var events []Event
+	for _, id := range order {
+		if event, ok := pending[id]; ok {
+			events = append(events, event)
+			// An event undelivered again after a delivery is in order twice
+			delete(pending, id)
+		}
+	}
+	return events, nil
+}
+
+// readLog reads all lines of a JSON lines audit log, decrypting the lines
+// encrypted with cipher
+func readLog(path string, cipher *encryption.Cipher) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		data, err := cipher.DecryptLine(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt audit log line %d: %w", line, err)
+		}
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log line %d: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return events, nil
+}
+
+// ConvertLog rewrites the audit log at path with its lines encrypted with
+// cipher, or in plaintext if it is nil. Lines already encrypted are
+// decrypted with from.
+func ConvertLog(path string, from, cipher *encryption.Cipher) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var out []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		data, err := from.DecryptLine(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to decrypt audit log line %d: %w", line, err)
+		}
+		if cipher != nil {
+			data = cipher.EncryptLine(data)
+		}
+		out = append(append(out, data...), '\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	if err := fileutil.WriteAtomic(path, out); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"github.com/yckao/gta/pkg/logger"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	// bigQueryBufferSize bounds the number of events waiting to be exported
+	bigQueryBufferSize = 256
+	// bigQueryBatchSize is the maximum number of rows appended in a single request
+	bigQueryBatchSize = 500
+	// bigQueryMaxAttempts bounds the retries of a failed insert
+	bigQueryMaxAttempts = 3
+	// bigQueryCloseTimeout bounds how long Close waits for buffered events
+	bigQueryCloseTimeout = 10 * time.Second
+)
+
+// bigQuerySchema is the table schema matching Event, created and migrated
+// through the BigQuery API
+var bigQuerySchema = &bigquery.TableSchema{
+	Fields: []*bigquery.TableFieldSchema{
+		{Name: "id", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "time", Type: "TIMESTAMP", Mode: "REQUIRED"},
+		{Name: "action", Type: "STRING", Mode: "REQUIRED"},
+		{Name: "session_id", Type: "STRING"},
+		{Name: "project", Type: "STRING"},
+		{Name: "role", Type: "STRING"},
+		{Name: "member", Type: "STRING"},
+		{Name: "binding_id", Type: "STRING"},
+		{Name: "expiry", Type: "TIMESTAMP"},
+		{Name: "reason", Type: "STRING"},
+		{Name: "caller", Type: "STRING"},
+		{Name: "error", Type: "STRING"},
+		{Name: "request_id", Type: "STRING"},
+		{Name: "requester", Type: "STRING"},
+		{Name: "approver", Type: "STRING"},
+		{Name: "break_glass", Type: "BOOLEAN"},
+		{Name: "incident", Type: "STRING"},
+		{Name: "profile", Type: "STRING"},
+		{Name: "environment", Type: "STRING"},
+		{Name: "resource", Type: "STRING"},
+		{Name: "revoke_at", Type: "TIMESTAMP"},
+	},
+}
+
+// BigQueryConfig identifies the table events are exported to
+type BigQueryConfig struct {
+	Project    string
+	Dataset    string
+	Table      string
+	DisableDDL bool
+}
+
+// BigQueryExporter streams events to a BigQuery table in the background,
+// appending them to the default stream of the table with the Storage Write
+// API. Events are buffered locally so that IAM operations never wait on the
+// export.
+type BigQueryExporter struct {
+	cfg     BigQueryConfig
+	service *bigquery.Service
+	// writer appends rows, encoded as messages of descriptor
+	writer     *managedwriter.Client
+	descriptor protoreflect.MessageDescriptor
+	stream     *managedwriter.ManagedStream
+	streamErr  error
+	openStream sync.Once
+	events     chan Event
+	done       chan struct{}
+	ensureErr  error
+	ensure     sync.Once
+	closeOnce  sync.Once
+}
+
+// NewBigQueryExporter creates an exporter and starts its background worker.
+// The options configure the BigQuery API managing the table; the Storage
+// Write API, called over gRPC, takes none of them but the default credentials.
+func NewBigQueryExporter(ctx context.Context, cfg BigQueryConfig, opts ...option.ClientOption) (*BigQueryExporter, error) {
+	service, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery service: %v", err)
+	}
+	descriptor, err := bigQueryDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	writer, err := managedwriter.NewClient(ctx, cfg.Project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery Storage Write client: %v", err)
+	}
+
+	e := &BigQueryExporter{
+		cfg:        cfg,
+		service:    service,
+		writer:     writer,
+		descriptor: descriptor,
+		events:     make(chan Event, bigQueryBufferSize),
+		done:       make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Emit implements Sink. Events are dropped with a warning if the buffer is full.
+func (e *BigQueryExporter) Emit(event Event) {
+	select {
+	case e.events <- event:
+	default:
+		logger.Warn("BigQuery export buffer is full, dropping event %s", event.ID)
+	}
+}
+
+// Close flushes buffered events, waiting at most a bounded time, and closes
+// the stream
+func (e *BigQueryExporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.events)
+	})
+	select {
+	case <-e.done:
+	case <-time.After(bigQueryCloseTimeout):
+		return fmt.Errorf("timed out flushing events to BigQuery")
+	}
+	if e.stream != nil {
+		e.stream.Close()
+	}
+	return e.writer.Close()
+}
+
+// run exports buffered events until the channel is closed
+func (e *BigQueryExporter) run() {
+	defer close(e.done)
+
+	for event := range e.events {
+		batch := []Event{event}
+	drain:
+		for len(batch) < bigQueryBatchSize {
+			select {
+			case next, ok := <-e.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), bigQueryCloseTimeout)
+		if err := e.insertWithRetry(ctx, batch); err != nil {
+			logger.Warn("Failed to export %d event(s) to BigQuery: %v", len(batch), err)
+		}
+		cancel()
+	}
+}
+
+// insertWithRetry inserts a batch, retrying transient failures a bounded number of times
+func (e *BigQueryExporter) insertWithRetry(ctx context.Context, events []Event) error {
+	var err error
+	backoff := 500 * time.Millisecond
+	for attempt := 1; attempt <= bigQueryMaxAttempts; attempt++ {
+		if err = e.Insert(logger.WithAttempt(ctx, attempt), events); err == nil || !retriable(err) {
+			return err
+		}
+		logger.Debug("BigQuery insert attempt %d failed: %v", attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// Insert appends events to the default stream of the table, creating the
+// table first if needed. The default stream does not deduplicate rows, see
+// Replay to append only the events the table lacks.
+func (e *BigQueryExporter) Insert(ctx context.Context, events []Event) error {
+	if err := e.ensureTable(ctx); err != nil {
+		return err
+	}
+	stream, err := e.defaultStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(events); start += bigQueryBatchSize {
+		end := start + bigQueryBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+
+		rows := make([][]byte, 0, end-start)
+		for _, event := range events[start:end] {
+			row, err := encodeRow(e.descriptor, event)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+
+		result, err := stream.AppendRows(ctx, rows)
+		if err != nil {
+			return fmt.Errorf("failed to append rows: %w", err)
+		}
+		resp, err := result.FullResponse(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to append rows: %w", err)
+		}
+		if rowErrs := resp.GetRowErrors(); len(rowErrs) > 0 {
+			var msgs []string
+			for _, rowErr := range rowErrs {
+				msgs = append(msgs, fmt.Sprintf("row %d: %s", int64(start)+rowErr.GetIndex(), rowErr.GetMessage()))
+			}
+			return fmt.Errorf("failed to append rows: %s", strings.Join(msgs, "; "))
+		}
+	}
+	return nil
+}
+
+// Replay appends the events the table lacks, found by their ID, and returns
+// how many it appended
+func (e *BigQueryExporter) Replay(ctx context.Context, events []Event) (int, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+	if err := e.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	from, to := events[0].Time, events[0].Time
+	for _, event := range events {
+		if event.Time.Before(from) {
+			from = event.Time
+		}
+		if event.Time.After(to) {
+			to = event.Time
+		}
+	}
+	existing, err := e.existingIDs(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	var missing []Event
+	for _, event := range events {
+		if !existing[event.ID] {
+			missing = append(missing, event)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	return len(missing), e.Insert(ctx, missing)
+}
+
+// existingIDs returns the IDs of the events of the table from from to to
+func (e *BigQueryExporter) existingIDs(ctx context.Context, from, to time.Time) (map[string]bool, error) {
+	legacySQL := false
+	timestamp := func(name string, t time.Time) *bigquery.QueryParameter {
+		return &bigquery.QueryParameter{
+			Name:           name,
+			ParameterType:  &bigquery.QueryParameterType{Type: "TIMESTAMP"},
+			ParameterValue: &bigquery.QueryParameterValue{Value: t.UTC().Format(time.RFC3339Nano)},
+		}
+	}
+	request := &bigquery.QueryRequest{
+		Query:           fmt.Sprintf("SELECT id FROM `%s.%s.%s` WHERE time BETWEEN @from AND @to", e.cfg.Project, e.cfg.Dataset, e.cfg.Table),
+		UseLegacySql:    &legacySQL,
+		ParameterMode:   "NAMED",
+		QueryParameters: []*bigquery.QueryParameter{timestamp("from", from), timestamp("to", to)},
+	}
+	resp, err := e.service.Jobs.Query(e.cfg.Project, request).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the events in the table: %w", err)
+	}
+
+	ids := make(map[string]bool)
+	job, rows, pageToken, complete := resp.JobReference, resp.Rows, resp.PageToken, resp.JobComplete
+	for {
+		for _, row := range rows {
+			if len(row.F) > 0 {
+				if id, ok := row.F[0].V.(string); ok {
+					ids[id] = true
+				}
+			}
+		}
+		if complete && pageToken == "" {
+			return ids, nil
+		}
+		results, err := e.service.Jobs.GetQueryResults(job.ProjectId, job.JobId).Location(job.Location).PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the events in the table: %w", err)
+		}
+		rows, pageToken, complete = results.Rows, results.PageToken, results.JobComplete
+	}
+}
+
+// defaultStream opens the default stream of the table once
+func (e *BigQueryExporter) defaultStream(ctx context.Context) (*managedwriter.ManagedStream, error) {
+	e.openStream.Do(func() {
+		descriptor, err := adapt.NormalizeDescriptor(e.descriptor)
+		if err != nil {
+			e.streamErr = fmt.Errorf("failed to describe rows: %v", err)
+			return
+		}
+		e.stream, e.streamErr = e.writer.NewManagedStream(ctx,
+			managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(e.cfg.Project, e.cfg.Dataset, e.cfg.Table)),
+			managedwriter.WithType(managedwriter.DefaultStream),
+			managedwriter.WithSchemaDescriptor(descriptor),
+		)
+		if e.streamErr != nil {
+			e.streamErr = fmt.Errorf("failed to open the default stream: %w", e.streamErr)
+		}
+	})
+	return e.stream, e.streamErr
+}
+
+// ensureTable creates the table with the expected schema if it does not exist,
+// and adds columns introduced by newer versions to an existing table
+func (e *BigQueryExporter) ensureTable(ctx context.Context) error {
+	if e.cfg.DisableDDL {
+		return nil
+	}
+	e.ensure.Do(func() {
+		existing, err := e.service.Tables.Get(e.cfg.Project, e.cfg.Dataset, e.cfg.Table).Context(ctx).Do()
+		if err == nil {
+			e.ensureErr = e.addMissingColumns(ctx, existing)
+			return
+		}
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+			e.ensureErr = fmt.Errorf("failed to get table: %w", err)
+			return
+		}
+
+		logger.Info("Creating BigQuery table %s:%s.%s", e.cfg.Project, e.cfg.Dataset, e.cfg.Table)
+		table := &bigquery.Table{
+			TableReference: &bigquery.TableReference{
+				ProjectId: e.cfg.Project,
+				DatasetId: e.cfg.Dataset,
+				TableId:   e.cfg.Table,
+			},
+			Schema: bigQuerySchema,
+			TimePartitioning: &bigquery.TimePartitioning{
+				Type:  "DAY",
+				Field: "time",
+			},
+		}
+		if _, err := e.service.Tables.Insert(e.cfg.Project, e.cfg.Dataset, table).Context(ctx).Do(); err != nil {
+			e.ensureErr = fmt.Errorf("failed to create table: %w", err)
+		}
+	})
+	return e.ensureErr
+}
+
+// addMissingColumns patches the table schema with columns of bigQuerySchema it lacks
+func (e *BigQueryExporter) addMissingColumns(ctx context.Context, table *bigquery.Table) error {
+	if table.Schema == nil {
+		return nil
+	}
+	present := make(map[string]bool, len(table.Schema.Fields))
+	for _, field := range table.Schema.Fields {
+		present[field.Name] = true
+	}
+	fields := table.Schema.Fields
+	for _, field := range bigQuerySchema.Fields {
+		if !present[field.Name] {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == len(table.Schema.Fields) {
+		return nil
+	}
+
+	logger.Info("Adding %d column(s) to BigQuery table %s:%s.%s", len(fields)-len(table.Schema.Fields), e.cfg.Project, e.cfg.Dataset, e.cfg.Table)
+	patch := &bigquery.Table{Schema: &bigquery.TableSchema{Fields: fields}}
+	if _, err := e.service.Tables.Patch(e.cfg.Project, e.cfg.Dataset, e.cfg.Table, patch).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to update table schema: %w", err)
+	}
+	return nil
+}
+
+// storageTypes are the Storage Write API types of the column types of
+// bigQuerySchema
+var storageTypes = map[string]storagepb.TableFieldSchema_Type{
+	"STRING":    storagepb.TableFieldSchema_STRING,
+	"TIMESTAMP": storagepb.TableFieldSchema_TIMESTAMP,
+	"BOOLEAN":   storagepb.TableFieldSchema_BOOL,
+}
+
+// bigQueryDescriptor returns the descriptor of the protocol buffer messages
+// the Storage Write API takes rows of bigQuerySchema as
+func bigQueryDescriptor() (protoreflect.MessageDescriptor, error) {
+	schema := &storagepb.TableSchema{}
+	for _, field := range bigQuerySchema.Fields {
+		typ, ok := storageTypes[field.Type]
+		if !ok {
+			return nil, fmt.Errorf("column %s has unsupported type %s", field.Name, field.Type)
+		}
+		mode := storagepb.TableFieldSchema_NULLABLE
+		if field.Mode == "REQUIRED" {
+			mode = storagepb.TableFieldSchema_REQUIRED
+		}
+		schema.Fields = append(schema.Fields, &storagepb.TableFieldSchema{Name: field.Name, Type: typ, Mode: mode})
+	}
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(schema, "root")
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe rows: %v", err)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("failed to describe rows: %s is not a message", descriptor.FullName())
+	}
+	return message, nil
+}
+
+// encodeRow encodes the row of an event as a message of descriptor
+func encodeRow(descriptor protoreflect.MessageDescriptor, event Event) ([]byte, error) {
+	data, err := json.Marshal(bigQueryRow(event))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event %s: %v", event.ID, err)
+	}
+	message := dynamicpb.NewMessage(descriptor)
+	if err := protojson.Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("failed to encode event %s: %v", event.ID, err)
+	}
+	row, err := proto.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event %s: %v", event.ID, err)
+	}
+	return row, nil
+}
+
+// bigQueryRow converts an event into a row matching bigQuerySchema, with
+// timestamps in microseconds since the epoch as the Storage Write API takes
+// them
+func bigQueryRow(event Event) map[string]any {
+	row := map[string]any{
+		"id":     event.ID,
+		"time":   event.Time.UnixMicro(),
+		"action": string(event.Action),
+	}
+	set := func(key, value string) {
+		if value != "" {
+			row[key] = value
+		}
+	}
+	set("session_id", event.SessionID)
+	set("project", event.Project)
+	set("resource", event.Resource)
+	set("role", event.Role)
+	set("member", event.Member)
+	set("binding_id", event.BindingID)
+	set("reason", event.Reason)
+	set("caller", event.Caller)
+	set("error", event.Error)
+	set("request_id", event.RequestID)
+	set("requester", event.Requester)
+	set("approver", event.Approver)
+	set("incident", event.Incident)
+	set("profile", event.Profile)
+	set("environment", event.Environment)
+	if event.BreakGlass {
+		row["break_glass"] = true
+	}
+	if !event.Expiry.IsZero() {
+		row["expiry"] = event.Expiry.UnixMicro()
+	}
+	if !event.RevokeAt.IsZero() {
+		row["revoke_at"] = event.RevokeAt.UnixMicro()
+	}
+	return row
+}
+
+// retriable reports whether an export error is worth retrying
+func retriable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestEncodeRow(t *testing.T) {
+	descriptor, err := bigQueryDescriptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 15, 9, 30, 0, 123456000, time.UTC)
+	event := Event{
+		ID:         "e1",
+		Time:       now,
+		Action:     ActionGrant,
+		SessionID:  "s1",
+		Project:    "p",
+		Resource:   "buckets/b",
+		Role:       "roles/viewer",
+		Member:     "user:alice@example.com",
+		BindingID:  "gta_1",
+		Expiry:     now.Add(time.Hour),
+		RevokeAt:   now.Add(55 * time.Minute),
+		BreakGlass: true,
+		Incident:   "INC-1",
+	}
+
+	data, err := encodeRow(descriptor, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(data, row); err != nil {
+		t.Fatal(err)
+	}
+	get := func(name string) protoreflect.Value {
+		field := descriptor.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			t.Fatalf("no column %s", name)
+		}
+		return row.Get(field)
+	}
+	for name, want := range map[string]string{
+		"id":         "e1",
+		"action":     "grant",
+		"session_id": "s1",
+		"project":    "p",
+		"resource":   "buckets/b",
+		"role":       "roles/viewer",
+		"member":     "user:alice@example.com",
+		"binding_id": "gta_1",
+		"incident":   "INC-1",
+		"reason":     "",
+	} {
+		if got := get(name).String(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	// Timestamps are microseconds since the epoch
+	if got := get("time").Int(); got != now.UnixMicro() {
+		t.Errorf("time = %d, want %d", got, now.UnixMicro())
+	}
+	if got := get("expiry").Int(); got != now.Add(time.Hour).UnixMicro() {
+		t.Errorf("expiry = %d, want %d", got, now.Add(time.Hour).UnixMicro())
+	}
+	if got := get("revoke_at").Int(); got != now.Add(55*time.Minute).UnixMicro() {
+		t.Errorf("revoke_at = %d, want %d", got, now.Add(55*time.Minute).UnixMicro())
+	}
+	if !get("break_glass").Bool() {
+		t.Error("break_glass not set")
+	}
+	// Fields left empty are not written
+	if field := descriptor.Fields().ByName("reason"); row.Has(field) {
+		t.Error("empty reason written")
+	}
+}
+
+// TestSchemaCoversEvent checks that every field of Event, but for the record
+// of its delivery, has its column
+func TestSchemaCoversEvent(t *testing.T) {
+	columns := make(map[string]bool)
+	for _, field := range bigQuerySchema.Fields {
+		columns[field.Name] = true
+	}
+	fields := reflect.TypeOf(Event{})
+	for i := 0; i < fields.NumField(); i++ {
+		name, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+		if name != "delivery" && !columns[name] {
+			t.Errorf("field %s has no column", name)
+		}
+	}
+}
+
+func TestExistingIDs(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/queries"):
+			var request bigquery.QueryRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				t.Error(err)
+			}
+			queries = append(queries, request.Query)
+			// The first page, the job still running
+			json.NewEncoder(w).Encode(map[string]any{
+				"jobReference": map[string]any{"projectId": "p", "jobId": "j1", "location": "US"},
+				"jobComplete":  false,
+				"rows":         []any{map[string]any{"f": []any{map[string]any{"v": "e1"}}}},
+			})
+		case r.URL.Query().Get("pageToken") == "":
+			json.NewEncoder(w).Encode(map[string]any{
+				"jobComplete": true,
+				"pageToken":   "page2",
+				"rows":        []any{map[string]any{"f": []any{map[string]any{"v": "e2"}}}},
+			})
+		default:
+			json.NewEncoder(w).Encode(map[string]any{
+				"jobComplete": true,
+				"rows":        []any{map[string]any{"f": []any{map[string]any{"v": "e3"}}}},
+			})
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	service, err := bigquery.NewService(ctx, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &BigQueryExporter{cfg: BigQueryConfig{Project: "p", Dataset: "d", Table: "t"}, service: service}
+	now := time.Now()
+	ids, err := e.existingIDs(ctx, now.Add(-time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || !ids["e1"] || !ids["e2"] || !ids["e3"] {
+		t.Errorf("ids %v, want e1, e2, and e3", ids)
+	}
+	if len(queries) != 1 || !strings.Contains(queries[0], "`p.d.t`") {
+		t.Errorf("queries %q", queries)
+	}
+}
@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+const (
+	// pubSubBufferSize bounds the number of events waiting to be published
+	pubSubBufferSize = 256
+	// pubSubBatchSize is the maximum number of messages sent in a single request
+	pubSubBatchSize = 100
+	// pubSubMaxAttempts bounds the retries of a failed publish
+	pubSubMaxAttempts = 3
+	// pubSubCloseTimeout bounds how long Close waits for buffered events
+	pubSubCloseTimeout = 10 * time.Second
+
+	// ExporterPubSub names the Pub/Sub exporter in delivery records
+	ExporterPubSub = "pubsub"
+	// cloudEventTypePrefix starts the CloudEvents type of every event,
+	// followed by its action
+	cloudEventTypePrefix = "com.github.yckao.gta."
+)
+
+// PubSubExporter publishes events to a Pub/Sub topic as CloudEvents in the
+// background, with the session ID as ordering key. Events are buffered
+// locally so that IAM operations never wait on the export; those that
+// cannot be published are handed to the undelivered sink instead.
+type PubSubExporter struct {
+	topic       string
+	service     *pubsub.Service
+	undelivered Sink
+	events      chan Event
+	done        chan struct{}
+	closing     chan struct{}
+}
+
+// NewPubSubExporter creates an exporter publishing to topic, given as
+// projects/PROJECT/topics/TOPIC, and starts its background worker. Events
+// that cannot be published are emitted to undelivered, marked as such,
+// unless it is nil.
+func NewPubSubExporter(ctx context.Context, topic string, undelivered Sink, opts ...option.ClientOption) (*PubSubExporter, error) {
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub service: %v", err)
+	}
+
+	e := &PubSubExporter{
+		topic:       topic,
+		service:     service,
+		undelivered: undelivered,
+		events:      make(chan Event, pubSubBufferSize),
+		done:        make(chan struct{}),
+		closing:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Emit implements Sink. Events are recorded as undelivered if the buffer is full.
+func (e *PubSubExporter) Emit(event Event) {
+	select {
+	case e.events <- event:
+	default:
+		logger.Warn("Pub/Sub export buffer is full, recording event %s as undelivered", event.ID)
+		e.giveUp([]Event{event})
+	}
+}
+
+// Close flushes buffered events, waiting at most a bounded time. The events
+// still buffered then are recorded as undelivered.
+func (e *PubSubExporter) Close() error {
+	select {
+	case <-e.closing:
+	default:
+		close(e.closing)
+		close(e.events)
+	}
+	select {
+	case <-e.done:
+		return nil
+	case <-time.After(pubSubCloseTimeout):
+	}
+
+	var unsent []Event
+	for event := range e.events {
+		unsent = append(unsent, event)
+	}
+	e.giveUp(unsent)
+	return fmt.Errorf("timed out publishing events to Pub/Sub")
+}
+
+// run publishes buffered events until the channel is closed
+func (e *PubSubExporter) run() {
+	defer close(e.done)
+
+	for event := range e.events {
+		batch := []Event{event}
+	drain:
+		for len(batch) < pubSubBatchSize {
+			select {
+			case next, ok := <-e.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pubSubCloseTimeout)
+		if err := e.publishWithRetry(ctx, batch); err != nil {
+			logger.Warn("Failed to publish %d event(s) to Pub/Sub: %v", len(batch), err)
+			e.giveUp(batch)
+		}
+		cancel()
+	}
+}
+
+// giveUp records events as undelivered by Pub/Sub
+func (e *PubSubExporter) giveUp(events []Event) {
+	if e.undelivered == nil {
+		return
+	}
+	for _, event := range events {
+		event.Delivery = &Delivery{Exporter: ExporterPubSub}
+		e.undelivered.Emit(event)
+	}
+}
+
+// publishWithRetry publishes a batch, retrying transient failures a bounded
+// number of times
+func (e *PubSubExporter) publishWithRetry(ctx context.Context, events []Event) error {
+	var err error
+	backoff := 500 * time.Millisecond
+	for attempt := 1; attempt <= pubSubMaxAttempts; attempt++ {
+		if err = e.Publish(logger.WithAttempt(ctx, attempt), events); err == nil || !retriable(err) {
+			return err
+		}
+		logger.Debug("Pub/Sub publish attempt %d failed: %v", attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// Publish publishes events to the topic as CloudEvents, in order
+func (e *PubSubExporter) Publish(ctx context.Context, events []Event) error {
+	for start := 0; start < len(events); start += pubSubBatchSize {
+		end := start + pubSubBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+
+		request := &pubsub.PublishRequest{}
+		for _, event := range events[start:end] {
+			message, err := cloudEventMessage(event)
+			if err != nil {
+				return err
+			}
+			request.Messages = append(request.Messages, message)
+		}
+		if _, err := e.service.Projects.Topics.Publish(e.topic, request).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to publish messages: %w", err)
+		}
+	}
+	return nil
+}
+
+// cloudEventMessage converts an event into a Pub/Sub message in the binary
+// content mode of CloudEvents: the attributes hold the context of the event
+// and the data its JSON encoding, as written to the audit log
+func cloudEventMessage(event Event) (*pubsub.PubsubMessage, error) {
+	event.Delivery = nil
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event %s: %v", event.ID, err)
+	}
+	attributes := map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          event.ID,
+		"ce-type":        cloudEventTypePrefix + string(event.Action),
+		"ce-source":      "//cloudresourcemanager.googleapis.com/projects/" + event.Project,
+		"ce-time":        event.Time.UTC().Format(time.RFC3339Nano),
+		"content-type":   "application/json",
+	}
+	if event.BindingID != "" {
+		attributes["ce-subject"] = event.BindingID
+	}
+	return &pubsub.PubsubMessage{
+		Data:        base64.StdEncoding.EncodeToString(data),
+		Attributes:  attributes,
+		OrderingKey: event.SessionID,
+	}, nil
+}
@@ -0,0 +1,120 @@
+// Package checkpoint records the progress of a bulk operation over many
+// projects, so that a re-run resumes where an interrupted one stopped
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/fileutil"
+)
+
+// Checkpoint is the set of projects an operation has completed. It is safe
+// for concurrent use.
+type Checkpoint struct {
+	mu   sync.Mutex
+	path string
+	file file
+	done map[string]bool
+}
+
+// file is the content of a checkpoint file
+type file struct {
+	// Scope identifies the operation; a checkpoint of another scope is discarded
+	Scope     string    `json:"scope"`
+	StartedAt time.Time `json:"started_at"`
+	Completed []string  `json:"completed"`
+}
+
+// Load reads the checkpoint at path for the operation identified by scope.
+// The checkpoint is empty when the file does not exist or was written for
+// another scope.
+func Load(path, scope string) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path: path,
+		file: file{Scope: scope, StartedAt: time.Now().UTC()},
+		done: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", path, err)
+	}
+	if f.Scope != scope {
+		return c, nil
+	}
+	c.file = f
+	for _, project := range f.Completed {
+		c.done[project] = true
+	}
+	return c, nil
+}
+
+// Path returns the path of the checkpoint file
+func (c *Checkpoint) Path() string {
+	return c.path
+}
+
+// StartedAt returns when the checkpointed operation first started
+func (c *Checkpoint) StartedAt() time.Time {
+	return c.file.StartedAt
+}
+
+// Len returns the number of completed projects
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// Done reports whether project was completed
+func (c *Checkpoint) Done(project string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[project]
+}
+
+// Complete records project as completed and writes the checkpoint
+func (c *Checkpoint) Complete(project string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done[project] {
+		return nil
+	}
+	c.done[project] = true
+	c.file.Completed = append(c.file.Completed, project)
+	sort.Strings(c.file.Completed)
+
+	data, err := json.MarshalIndent(c.file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %v", err)
+	}
+	if err := fileutil.WriteAtomic(c.path, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes the checkpoint file once the operation is complete
+func (c *Checkpoint) Remove() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Package ci reports the phases of a grant in CI job logs and summaries,
+// with GitHub Actions workflow commands when running in GitHub Actions and
+// plain structured lines elsewhere
+package ci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Outcomes of a phase
+const (
+	OutcomeOK     = "ok"
+	OutcomeFailed = "failed"
+)
+
+// Reporter writes the annotations of a CI job. A nil Reporter writes
+// nothing, so that callers need not tell CI runs apart.
+type Reporter struct {
+	w      io.Writer
+	github bool
+	// summaryPath is the file the job summary is appended to, empty for none
+	summaryPath string
+}
+
+// New creates a Reporter writing to w, using workflow commands when running
+// in GitHub Actions. The summary is appended to summaryPath, or to the step
+// summary of GitHub Actions when summaryPath is empty.
+func New(w io.Writer, summaryPath string) *Reporter {
+	github := os.Getenv("GITHUB_ACTIONS") == "true"
+	if summaryPath == "" && github {
+		summaryPath = os.Getenv("GITHUB_STEP_SUMMARY")
+	}
+	return &Reporter{w: w, github: github, summaryPath: summaryPath}
+}
+
+// GitHub reports whether workflow commands are written
+func (r *Reporter) GitHub() bool {
+	return r != nil && r.github
+}
+
+// Mask hides value in the job log from now on
+func (r *Reporter) Mask(value string) {
+	if r.GitHub() && value != "" {
+		fmt.Fprintf(r.w, "::add-mask::%s\n", escapeData(value))
+	}
+}
+
+// Group runs fn as a collapsible phase of the job log and reports its outcome
+func (r *Reporter) Group(title string, fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+	if r.github {
+		fmt.Fprintf(r.w, "::group::%s\n", escapeData(title))
+	} else {
+		fmt.Fprintf(r.w, "==> %s\n", title)
+	}
+	err := fn()
+	outcome := OutcomeOK
+	if err != nil {
+		outcome = OutcomeFailed
+	}
+	if r.github {
+		fmt.Fprintln(r.w, "::endgroup::")
+	} else {
+		fmt.Fprintf(r.w, "<== %s: %s\n", title, outcome)
+	}
+	return err
+}
+
+// Notice annotates the job with an informational message
+func (r *Reporter) Notice(title, format string, args ...interface{}) {
+	r.annotate("notice", "NOTICE", title, fmt.Sprintf(format, args...))
+}
+
+// Error annotates the job with an error
+func (r *Reporter) Error(title, format string, args ...interface{}) {
+	r.annotate("error", "ERROR", title, fmt.Sprintf(format, args...))
+}
+
+// annotate writes an annotation as a workflow command, or as a plain line
+func (r *Reporter) annotate(command, label, title, message string) {
+	if r == nil {
+		return
+	}
+	if r.github {
+		fmt.Fprintf(r.w, "::%s title=%s::%s\n", command, escapeProperty(title), escapeData(message))
+		return
+	}
+	fmt.Fprintf(r.w, "%s [%s] %s\n", label, title, message)
+}
+
+// Binding is a binding listed in the job summary
+type Binding struct {
+	// Resource is the project or resource granted on, e.g. buckets/my-bucket
+	Resource string
+	Role     string
+	ID       string
+	Expiry   time.Time
+	// AddedFor is the role whose dependency rule added Role
+	AddedFor string
+}
+
+// Summary describes a grant session in the job summary
+type Summary struct {
+	SessionID string
+	Project   string
+	// Environment is the environment of Project, e.g. prod
+	Environment string
+	Member      string
+	Bindings    []Binding
+	// Revoke is the outcome of the revocation, empty when the bindings are
+	// left to expire
+	Revoke string
+}
+
+// WriteSummary appends the Markdown summary of a session to the summary
+// file, when there is one
+func (r *Reporter) WriteSummary(s Summary) error {
+	if r == nil || r.summaryPath == "" {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "### gta session `%s`\n\n", s.SessionID)
+	fmt.Fprintf(&b, "- Project: `%s`\n", s.Project)
+	if s.Environment != "" {
+		fmt.Fprintf(&b, "- Environment: `%s`\n", s.Environment)
+	}
+	fmt.Fprintf(&b, "- Member: `%s`\n", s.Member)
+	revoke := s.Revoke
+	if revoke == "" {
+		revoke = "left to expire"
+	}
+	fmt.Fprintf(&b, "- Revocation: %s\n", revoke)
+	// Bindings are grouped by the resource granted on
+	bindings := append([]Binding(nil), s.Bindings...)
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return bindings[i].Resource < bindings[j].Resource
+	})
+	b.WriteString("\n| Resource | Role | Binding | Expiry |\n| --- | --- | --- | --- |\n")
+	for _, binding := range bindings {
+		role := fmt.Sprintf("`%s`", binding.Role)
+		if binding.AddedFor != "" {
+			role += fmt.Sprintf(" (added for `%s`)", binding.AddedFor)
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | `%s` | %s |\n", binding.Resource, role, binding.ID, binding.Expiry.UTC().Format(time.RFC3339))
+	}
+	b.WriteString("\n")
+
+	f, err := os.OpenFile(r.summaryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open job summary: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String()); err != nil {
+		return fmt.Errorf("failed to write job summary: %v", err)
+	}
+	return nil
+}
+
+// escapeData escapes the message of a workflow command
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property of a workflow command
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,479 @@
+// Package cleaner provisions a scheduled cleanup of expired temporary bindings,
+// so that projects clean themselves even if nobody runs gta clean
+package cleaner
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	cloudscheduler "google.golang.org/api/cloudscheduler/v1"
+	"google.golang.org/api/googleapi"
+	iam "google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	workflows "google.golang.org/api/workflows/v1"
+)
+
+const (
+	// Name is used for the service account, workflow, and scheduler job
+	Name = "gta-cleaner"
+
+	// DefaultLocation is the region of the workflow and scheduler job
+	DefaultLocation = "us-central1"
+	// DefaultSchedule runs the cleanup daily at 03:00
+	DefaultSchedule = "0 3 * * *"
+	// DefaultTimeZone is the time zone of the schedule
+	DefaultTimeZone = "Etc/UTC"
+
+	// operationPollInterval is the delay between polls of a workflow operation
+	operationPollInterval = 2 * time.Second
+	// bindingAttempts bounds retries while a new service account propagates
+	bindingAttempts = 6
+)
+
+// cleanerRoles are granted to the cleaner service account in the project: it
+// must rewrite the IAM policy and let the scheduler start the workflow
+var cleanerRoles = []string{
+	"roles/resourcemanager.projectIamAdmin",
+	"roles/workflows.invoker",
+}
+
+// Config identifies where the cleaner is installed
+type Config struct {
+	Project  string
+	Location string
+	Schedule string
+	TimeZone string
+	// BindingPrefix selects the bindings removed, defaults to the one of gta
+	BindingPrefix string
+}
+
+// Change records what happened to a resource during install or uninstall
+type Change struct {
+	// Action is e.g. "created", "updated", "unchanged", or "deleted", prefixed
+	// with "would be" in dry-run mode
+	Action string
+	Kind   string
+	Name   string
+}
+
+// Installer creates and removes the cleaner resources. Every step checks the
+// current state first, so running it again only applies what is missing.
+type Installer struct {
+	cfg       Config
+	dryRun    bool
+	changes   []Change
+	iam       *iam.Service
+	rm        *resourcemanager.Service
+	workflows *workflows.Service
+	scheduler *cloudscheduler.Service
+}
+
+// NewInstaller creates an installer for the given project
+func NewInstaller(ctx context.Context, cfg Config, dryRun bool, opts ...option.ClientOption) (*Installer, error) {
+	if cfg.Location == "" {
+		cfg.Location = DefaultLocation
+	}
+	if cfg.Schedule == "" {
+		cfg.Schedule = DefaultSchedule
+	}
+	if cfg.TimeZone == "" {
+		cfg.TimeZone = DefaultTimeZone
+	}
+	if cfg.BindingPrefix == "" {
+		cfg.BindingPrefix = provider.DefaultBindingPrefix
+	}
+
+	i := &Installer{cfg: cfg, dryRun: dryRun}
+	var err error
+	if i.iam, err = iam.NewService(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create IAM service: %v", err)
+	}
+	if i.rm, err = resourcemanager.NewService(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Resource Manager service: %v", err)
+	}
+	if i.workflows, err = workflows.NewService(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create Workflows service: %v", err)
+	}
+	if i.scheduler, err = cloudscheduler.NewService(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Scheduler service: %v", err)
+	}
+	return i, nil
+}
+
+// ServiceAccountEmail returns the email of the cleaner service account
+func (i *Installer) ServiceAccountEmail() string {
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", Name, i.cfg.Project)
+}
+
+// WorkflowName returns the full resource name of the cleaner workflow
+func (i *Installer) WorkflowName() string {
+	return fmt.Sprintf("%s/workflows/%s", i.locationName(), Name)
+}
+
+// JobName returns the full resource name of the scheduler job
+func (i *Installer) JobName() string {
+	return fmt.Sprintf("%s/jobs/%s", i.locationName(), Name)
+}
+
+// WorkflowSource returns the source of the cleanup workflow
+func (i *Installer) WorkflowSource() string {
+	return workflowFor(i.cfg.BindingPrefix)
+}
+
+func (i *Installer) locationName() string {
+	return fmt.Sprintf("projects/%s/locations/%s", i.cfg.Project, i.cfg.Location)
+}
+
+func (i *Installer) serviceAccountName() string {
+	return fmt.Sprintf("projects/%s/serviceAccounts/%s", i.cfg.Project, i.ServiceAccountEmail())
+}
+
+// record notes a change, adjusting the wording in dry-run mode
+func (i *Installer) record(action, kind, name string) {
+	if i.dryRun && action != "unchanged" {
+		action = "would be " + action
+	}
+	i.changes = append(i.changes, Change{Action: action, Kind: kind, Name: name})
+	logger.Info("%s %s: %s", kind, action, name)
+}
+
+// Install creates or updates the service account, its roles, the workflow, and
+// the scheduler job, returning what was changed
+func (i *Installer) Install(ctx context.Context) ([]Change, error) {
+	i.changes = nil
+	steps := []func(context.Context) error{
+		i.ensureServiceAccount,
+		i.ensureRoles,
+		i.ensureWorkflow,
+		i.ensureJob,
+	}
+	for _, step := range steps {
+		if err := step(ctx); err != nil {
+			return i.changes, err
+		}
+	}
+	return i.changes, nil
+}
+
+// Uninstall removes every resource created by Install, returning what was removed
+func (i *Installer) Uninstall(ctx context.Context) ([]Change, error) {
+	i.changes = nil
+	steps := []func(context.Context) error{
+		i.deleteJob,
+		i.deleteWorkflow,
+		i.removeRoles,
+		i.deleteServiceAccount,
+	}
+	for _, step := range steps {
+		if err := step(ctx); err != nil {
+			return i.changes, err
+		}
+	}
+	return i.changes, nil
+}
+
+func (i *Installer) ensureServiceAccount(ctx context.Context) error {
+	const kind = "Service account"
+	_, err := i.iam.Projects.ServiceAccounts.Get(i.serviceAccountName()).Context(ctx).Do()
+	if err == nil {
+		i.record("unchanged", kind, i.ServiceAccountEmail())
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to get service account: %w", err)
+	}
+
+	if !i.dryRun {
+		request := &iam.CreateServiceAccountRequest{
+			AccountId: Name,
+			ServiceAccount: &iam.ServiceAccount{
+				DisplayName: "gta cleaner",
+				Description: "Removes expired temporary bindings created by gta",
+			},
+		}
+		if _, err := i.iam.Projects.ServiceAccounts.Create("projects/"+i.cfg.Project, request).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to create service account: %w", err)
+		}
+	}
+	i.record("created", kind, i.ServiceAccountEmail())
+	return nil
+}
+
+func (i *Installer) ensureRoles(ctx context.Context) error {
+	return i.updateRoles(ctx, true)
+}
+
+func (i *Installer) removeRoles(ctx context.Context) error {
+	return i.updateRoles(ctx, false)
+}
+
+// updateRoles adds or removes the cleaner service account in the unconditional
+// bindings of cleanerRoles
+func (i *Installer) updateRoles(ctx context.Context, add bool) error {
+	const kind = "Project role binding"
+	member := "serviceAccount:" + i.ServiceAccountEmail()
+	action := "created"
+	if !add {
+		action = "deleted"
+	}
+
+	// A service account that was just created can take a moment to be accepted
+	// in policies, so retry while it propagates
+	backoff := operationPollInterval
+	for attempt := 1; ; attempt++ {
+		policy, err := i.rm.Projects.GetIamPolicy(i.cfg.Project, &resourcemanager.GetIamPolicyRequest{
+			Options: &resourcemanager.GetPolicyOptions{RequestedPolicyVersion: 3},
+		}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to get IAM policy: %w", err)
+		}
+		policy.Version = 3
+
+		var changed []string
+		for _, role := range cleanerRoles {
+			if setMember(policy, role, member, add) {
+				changed = append(changed, role)
+			}
+		}
+		if len(changed) > 0 && !i.dryRun {
+			_, err = i.rm.Projects.SetIamPolicy(i.cfg.Project, &resourcemanager.SetIamPolicyRequest{Policy: policy, UpdateMask: "bindings,etag"}).Context(ctx).Do()
+		}
+		if err == nil {
+			for _, role := range cleanerRoles {
+				switch {
+				case contains(changed, role):
+					i.record(action, kind, role+" for "+member)
+				case add:
+					i.record("unchanged", kind, role+" for "+member)
+				}
+			}
+			return nil
+		}
+
+		var apiErr *googleapi.Error
+		retriable := errors.As(err, &apiErr) && (apiErr.Code == http.StatusBadRequest || apiErr.Code == http.StatusConflict)
+		if !add || !retriable || attempt >= bindingAttempts {
+			return fmt.Errorf("failed to set IAM policy: %w", err)
+		}
+		logger.Debug("Setting IAM policy failed, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// setMember adds or removes member in the unconditional binding of role and
+// reports whether the policy changed
+func setMember(policy *resourcemanager.Policy, role, member string, add bool) bool {
+	for bi, binding := range policy.Bindings {
+		if binding.Role != role || binding.Condition != nil {
+			continue
+		}
+		for mi, m := range binding.Members {
+			if m != member {
+				continue
+			}
+			if add {
+				return false
+			}
+			binding.Members = append(binding.Members[:mi], binding.Members[mi+1:]...)
+			if len(binding.Members) == 0 {
+				policy.Bindings = append(policy.Bindings[:bi], policy.Bindings[bi+1:]...)
+			}
+			return true
+		}
+		if add {
+			binding.Members = append(binding.Members, member)
+			return true
+		}
+		return false
+	}
+	if !add {
+		return false
+	}
+	policy.Bindings = append(policy.Bindings, &resourcemanager.Binding{Role: role, Members: []string{member}})
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Installer) ensureWorkflow(ctx context.Context) error {
+	const kind = "Workflow"
+	desired := &workflows.Workflow{
+		Description:    "Removes expired temporary bindings created by gta",
+		ServiceAccount: i.serviceAccountName(),
+		SourceContents: i.WorkflowSource(),
+		Labels:         map[string]string{"managed-by": "gta"},
+	}
+
+	existing, err := i.workflows.Projects.Locations.Workflows.Get(i.WorkflowName()).Context(ctx).Do()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to get workflow: %w", err)
+	}
+
+	if err == nil && existing.SourceContents == desired.SourceContents && existing.ServiceAccount == desired.ServiceAccount {
+		i.record("unchanged", kind, i.WorkflowName())
+		return nil
+	}
+
+	action := "updated"
+	if err != nil {
+		action = "created"
+	}
+	if !i.dryRun {
+		var op *workflows.Operation
+		if action == "created" {
+			op, err = i.workflows.Projects.Locations.Workflows.Create(i.locationName(), desired).WorkflowId(Name).Context(ctx).Do()
+		} else {
+			op, err = i.workflows.Projects.Locations.Workflows.Patch(i.WorkflowName(), desired).
+				UpdateMask("description,serviceAccount,sourceContents,labels").Context(ctx).Do()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply workflow: %w", err)
+		}
+		if err := i.waitWorkflowOperation(ctx, op); err != nil {
+			return err
+		}
+	}
+	i.record(action, kind, i.WorkflowName())
+	return nil
+}
+
+func (i *Installer) deleteWorkflow(ctx context.Context) error {
+	const kind = "Workflow"
+	if _, err := i.workflows.Projects.Locations.Workflows.Get(i.WorkflowName()).Context(ctx).Do(); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get workflow: %w", err)
+	}
+	if !i.dryRun {
+		op, err := i.workflows.Projects.Locations.Workflows.Delete(i.WorkflowName()).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to delete workflow: %w", err)
+		}
+		if err := i.waitWorkflowOperation(ctx, op); err != nil {
+			return err
+		}
+	}
+	i.record("deleted", kind, i.WorkflowName())
+	return nil
+}
+
+// waitWorkflowOperation polls a workflow operation until it is done
+func (i *Installer) waitWorkflowOperation(ctx context.Context, op *workflows.Operation) error {
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(operationPollInterval):
+		}
+		var err error
+		if op, err = i.workflows.Projects.Locations.Operations.Get(op.Name).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to get operation: %w", err)
+		}
+	}
+	if op.Error != nil {
+		return fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Message)
+	}
+	return nil
+}
+
+func (i *Installer) ensureJob(ctx context.Context) error {
+	const kind = "Scheduler job"
+	desired := &cloudscheduler.Job{
+		Name:        i.JobName(),
+		Description: "Runs the gta cleaner workflow",
+		Schedule:    i.cfg.Schedule,
+		TimeZone:    i.cfg.TimeZone,
+		HttpTarget: &cloudscheduler.HttpTarget{
+			Uri:        fmt.Sprintf("https://workflowexecutions.googleapis.com/v1/%s/executions", i.WorkflowName()),
+			HttpMethod: http.MethodPost,
+			Body:       base64.StdEncoding.EncodeToString([]byte("{}")),
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			OauthToken: &cloudscheduler.OAuthToken{
+				ServiceAccountEmail: i.ServiceAccountEmail(),
+				Scope:               "https://www.googleapis.com/auth/cloud-platform",
+			},
+		},
+	}
+
+	_, err := i.scheduler.Projects.Locations.Jobs.Get(i.JobName()).Context(ctx).Do()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to get scheduler job: %w", err)
+	}
+	if err != nil {
+		if !i.dryRun {
+			if _, err := i.scheduler.Projects.Locations.Jobs.Create(i.locationName(), desired).Context(ctx).Do(); err != nil {
+				return fmt.Errorf("failed to create scheduler job: %w", err)
+			}
+		}
+		i.record("created", kind, fmt.Sprintf("%s (%s %s)", i.JobName(), i.cfg.Schedule, i.cfg.TimeZone))
+		return nil
+	}
+
+	if !i.dryRun {
+		if _, err := i.scheduler.Projects.Locations.Jobs.Patch(i.JobName(), desired).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to update scheduler job: %w", err)
+		}
+	}
+	i.record("updated", kind, fmt.Sprintf("%s (%s %s)", i.JobName(), i.cfg.Schedule, i.cfg.TimeZone))
+	return nil
+}
+
+func (i *Installer) deleteJob(ctx context.Context) error {
+	const kind = "Scheduler job"
+	if _, err := i.scheduler.Projects.Locations.Jobs.Get(i.JobName()).Context(ctx).Do(); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get scheduler job: %w", err)
+	}
+	if !i.dryRun {
+		if _, err := i.scheduler.Projects.Locations.Jobs.Delete(i.JobName()).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to delete scheduler job: %w", err)
+		}
+	}
+	i.record("deleted", kind, i.JobName())
+	return nil
+}
+
+func (i *Installer) deleteServiceAccount(ctx context.Context) error {
+	const kind = "Service account"
+	if _, err := i.iam.Projects.ServiceAccounts.Get(i.serviceAccountName()).Context(ctx).Do(); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get service account: %w", err)
+	}
+	if !i.dryRun {
+		if _, err := i.iam.Projects.ServiceAccounts.Delete(i.serviceAccountName()).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to delete service account: %w", err)
+		}
+	}
+	i.record("deleted", kind, i.ServiceAccountEmail())
+	return nil
+}
+
+// isNotFound reports whether err is a 404 from a Google API
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
@@ -0,0 +1,83 @@
+package cleaner
+
+import "strings"
+
+// workflowSource is the Cloud Workflows program run on schedule. It is the
+// equivalent of `gta clean --expired`: it removes every binding created by gta
+// whose expiry timestamp has passed from the IAM policy of its own project.
+// BINDING_PREFIX is replaced with the binding prefix by workflowFor.
+const workflowSource = `# Managed by gta install-cleaner; changes are overwritten on the next install
+main:
+  steps:
+    - init:
+        assign:
+          - project: ${sys.get_env("GOOGLE_CLOUD_PROJECT_ID")}
+          - now: ${sys.now()}
+          - kept: []
+          - removed: 0
+    - getPolicy:
+        call: http.post
+        args:
+          url: ${"https://cloudresourcemanager.googleapis.com/v1/projects/" + project + ":getIamPolicy"}
+          auth:
+            type: OAuth2
+          body:
+            options:
+              requestedPolicyVersion: 3
+        result: response
+    - filterBindings:
+        for:
+          value: binding
+          in: ${default(map.get(response.body, "bindings"), [])}
+          steps:
+            - checkCondition:
+                switch:
+                  - condition: ${not("condition" in binding)}
+                    next: keep
+            - checkTitle:
+                switch:
+                  - condition: ${not(text.match_regex(default(map.get(binding.condition, "title"), ""), "^BINDING_PREFIX_[0-9]+$"))}
+                    next: keep
+            - findExpiry:
+                assign:
+                  - matches: ${text.find_all_regex(default(map.get(binding.condition, "expression"), ""), "[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9:.]+(Z|[+-][0-9]{2}:[0-9]{2})")}
+            - checkMatch:
+                switch:
+                  - condition: ${len(matches) == 0}
+                    next: keep
+            - checkExpiry:
+                switch:
+                  - condition: ${time.parse(matches[0].match) >= now}
+                    next: keep
+            - drop:
+                assign:
+                  - removed: ${removed + 1}
+                next: continue
+            - keep:
+                assign:
+                  - kept: ${list.concat(kept, binding)}
+    - checkRemoved:
+        switch:
+          - condition: ${removed == 0}
+            return: "No expired temporary bindings found"
+    - updatePolicy:
+        assign:
+          - policy: ${response.body}
+          - policy.bindings: ${kept}
+    - setPolicy:
+        call: http.post
+        args:
+          url: ${"https://cloudresourcemanager.googleapis.com/v1/projects/" + project + ":setIamPolicy"}
+          auth:
+            type: OAuth2
+          body:
+            policy: ${policy}
+    - done:
+        return: ${"Removed " + string(removed) + " expired temporary binding(s)"}
+`
+
+// workflowFor returns the source of the workflow removing the expired
+// bindings with prefix
+func workflowFor(prefix string) string {
+	return strings.ReplaceAll(workflowSource, "BINDING_PREFIX", prefix)
+}
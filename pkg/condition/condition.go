@@ -0,0 +1,305 @@
+// Package condition extracts expiry times from IAM condition expressions
+package condition
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Confidence is how certain a parsed expiry bounds the whole expression
+type Confidence int
+
+const (
+	// None means no expiry clause bounds the expression
+	None Confidence = iota
+	// Partial means the expiry clause is joined to other clauses with &&, so
+	// the binding ends at the expiry at the latest
+	Partial
+	// Exact means the expression is only the expiry clause, as created by gta
+	Exact
+)
+
+// String implements fmt.Stringer
+func (c Confidence) String() string {
+	switch c {
+	case Exact:
+		return "exact"
+	case Partial:
+		return "partial"
+	default:
+		return "none"
+	}
+}
+
+// Expiry is the result of parsing a condition expression
+type Expiry struct {
+	// Time is the earliest expiry found, zero when Confidence is None
+	Time time.Time
+	// Other holds the clauses other than the expiry
+	Other      []string
+	Confidence Confidence
+}
+
+// Found reports whether an expiry bounds the expression
+func (e Expiry) Found() bool {
+	return e.Confidence != None
+}
+
+// timestampPattern matches a CEL timestamp literal with either quote style
+const timestampPattern = `timestamp\(\s*(?:'([^'\\]*)'|"([^"\\]*)")\s*\)`
+
+var (
+	// beforePattern matches "request.time < timestamp('...')"
+	beforePattern = regexp.MustCompile(`^request\.time\s*<=?\s*` + timestampPattern + `$`)
+	// afterPattern matches the reversed "timestamp('...') > request.time"
+	afterPattern = regexp.MustCompile(`^` + timestampPattern + `\s*>=?\s*request\.time$`)
+)
+
+// Expression returns the condition expression granting access until expiry
+func Expression(expiry time.Time) string {
+	return fmt.Sprintf("request.time < timestamp('%s')", expiry.Format(time.RFC3339))
+}
+
+// ServiceClause returns the clause confining access to the resources of
+// service, e.g. storage.googleapis.com
+func ServiceClause(service string) string {
+	return fmt.Sprintf("resource.service == '%s'", service)
+}
+
+// servicePattern matches the clause of ServiceClause with either quote style
+var servicePattern = regexp.MustCompile(`^resource\.service\s*==\s*(?:'([^'\\]*)'|"([^"\\]*)")$`)
+
+// Service returns the service a clause of expr, and-ed with the others,
+// confines access to, empty when none does
+func Service(expr string) string {
+	parsed, err := Parse(expr)
+	if err != nil {
+		return ""
+	}
+	for _, clause := range parsed.Other {
+		if match := servicePattern.FindStringSubmatch(clause); match != nil {
+			return match[1] + match[2]
+		}
+	}
+	return ""
+}
+
+// TagClause returns the clause confining access to the resources carrying
+// a tag, given by the IDs of its key and value, e.g. tagKeys/123 and
+// tagValues/456
+func TagClause(key, value string) string {
+	return fmt.Sprintf("resource.matchTagId('%s', '%s')", key, value)
+}
+
+// tagPattern matches the clauses of TagClause, and of resource.matchTag
+// naming the tag, with either quote style
+var tagPattern = regexp.MustCompile(`^resource\.(matchTagId|matchTag)\(\s*(?:'([^'\\]*)'|"([^"\\]*)")\s*,\s*(?:'([^'\\]*)'|"([^"\\]*)")\s*\)$`)
+
+// Tags returns the tags clauses of expr, and-ed with the others, confine
+// access to as KEY=VALUE: the IDs of TagClause, e.g.
+// tagKeys/123=tagValues/456, or the names of resource.matchTag, e.g.
+// 123456789012/env=prod
+func Tags(expr string) []string {
+	parsed, err := Parse(expr)
+	if err != nil {
+		return nil
+	}
+	var tags []string
+	for _, clause := range parsed.Other {
+		if match := tagPattern.FindStringSubmatch(clause); match != nil {
+			tags = append(tags, match[2]+match[3]+"="+match[4]+match[5])
+		}
+	}
+	return tags
+}
+
+// Clauses returns the clauses of expr other than its expiry, each in
+// parentheses and joined by &&, so that they can be and-ed with another
+// expiry, empty when expr is nothing but its expiry or has none
+func Clauses(expr string) string {
+	parsed, err := Parse(expr)
+	if err != nil || parsed.Confidence != Partial {
+		return ""
+	}
+	clauses := make([]string, len(parsed.Other))
+	for i, clause := range parsed.Other {
+		clauses[i] = "(" + clause + ")"
+	}
+	return strings.Join(clauses, " && ")
+}
+
+// Parse extracts the expiry from any expression requiring request.time to be
+// before a timestamp. Expressions where the expiry clause can be bypassed, such
+// as through || or !, have no expiry. A malformed expression, or an expiry
+// clause whose timestamp does not parse, is an error rather than a guess.
+func Parse(expr string) (Expiry, error) {
+	clauses, err := splitAnd(expr)
+	if err != nil {
+		return Expiry{}, err
+	}
+
+	var result Expiry
+	for _, clause := range clauses {
+		expiry, ok, err := parseClause(clause)
+		if err != nil {
+			return Expiry{}, err
+		}
+		if !ok {
+			result.Other = append(result.Other, clause)
+			continue
+		}
+		if result.Time.IsZero() || expiry.Before(result.Time) {
+			result.Time = expiry
+		}
+	}
+
+	switch {
+	case result.Time.IsZero():
+		result.Confidence = None
+	case len(result.Other) == 0:
+		result.Confidence = Exact
+	default:
+		result.Confidence = Partial
+	}
+	return result, nil
+}
+
+// parseClause parses a single clause as an expiry clause
+func parseClause(clause string) (time.Time, bool, error) {
+	match := beforePattern.FindStringSubmatch(clause)
+	if match == nil {
+		match = afterPattern.FindStringSubmatch(clause)
+	}
+	if match == nil {
+		return time.Time{}, false, nil
+	}
+	raw := match[1] + match[2]
+	expiry, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid expiry timestamp %q: %v", raw, err)
+	}
+	return expiry, true, nil
+}
+
+// splitAnd splits expr into the clauses joined by top-level &&, descending
+// into parenthesized groups that are themselves conjunctions
+func splitAnd(expr string) ([]string, error) {
+	parts, hasOr, err := splitTopLevel(expr)
+	if err != nil {
+		return nil, err
+	}
+	if hasOr {
+		// A disjunction holds no clause that must be true, so keep it whole
+		return []string{strings.TrimSpace(expr)}, nil
+	}
+
+	var clauses []string
+	for _, part := range parts {
+		inner, ok := unwrapParens(part)
+		if !ok {
+			clauses = append(clauses, part)
+			continue
+		}
+		nested, err := splitAnd(inner)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, nested...)
+	}
+	return clauses, nil
+}
+
+// splitTopLevel splits expr on && outside of parentheses and string literals,
+// and reports whether a top-level || was seen
+func splitTopLevel(expr string) ([]string, bool, error) {
+	var (
+		parts []string
+		hasOr bool
+		depth int
+		quote byte
+		start int
+	)
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, false, fmt.Errorf("unbalanced parentheses in %q", expr)
+			}
+		case '&', '|':
+			if depth == 0 && i+1 < len(expr) && expr[i+1] == c {
+				if c == '|' {
+					hasOr = true
+				} else {
+					parts = append(parts, strings.TrimSpace(expr[start:i]))
+					start = i + 2
+				}
+				i++
+			}
+		}
+	}
+	if quote != 0 {
+		return nil, false, fmt.Errorf("unterminated string in %q", expr)
+	}
+	if depth != 0 {
+		return nil, false, fmt.Errorf("unbalanced parentheses in %q", expr)
+	}
+	parts = append(parts, strings.TrimSpace(expr[start:]))
+	for _, part := range parts {
+		if part == "" {
+			return nil, false, fmt.Errorf("empty clause in %q", expr)
+		}
+	}
+	return parts, hasOr, nil
+}
+
+// unwrapParens returns the inside of part when a single pair of parentheses
+// encloses all of it
+func unwrapParens(part string) (string, bool) {
+	if !strings.HasPrefix(part, "(") || !strings.HasSuffix(part, ")") {
+		return "", false
+	}
+	depth := 0
+	var quote byte
+	for i := 0; i < len(part); i++ {
+		c := part[i]
+		if quote != 0 {
+			switch c {
+			case '\\':
+				i++
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(part)-1 {
+				// The opening parenthesis closes before the end, e.g. "(a) || (b)"
+				return "", false
+			}
+		}
+	}
+	return part[1 : len(part)-1], true
+}
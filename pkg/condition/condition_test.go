@@ -0,0 +1,188 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var expiry = time.Date(2024, 5, 14, 10, 30, 0, 0, time.UTC)
+
+// realWorld are expressions seen in IAM policies, from gta and from other
+// tools and consoles
+var realWorld = []string{
+	"request.time < timestamp('2024-05-14T10:30:00Z')",
+	`request.time < timestamp("2024-05-14T10:30:00Z")`,
+	"request.time < timestamp('2024-05-14T10:30:00.000Z')",
+	"request.time <= timestamp('2024-05-14T12:30:00+02:00')",
+	"timestamp('2024-05-14T10:30:00Z') > request.time",
+	"request.time < timestamp('2024-05-14T10:30:00Z') && resource.service == 'storage.googleapis.com'",
+	"(request.time < timestamp('2024-05-14T10:30:00Z')) && (resource.matchTagId('tagKeys/11', 'tagValues/456'))",
+	"resource.name.startsWith('projects/_/buckets/logs') && request.time < timestamp('2024-05-14T10:30:00Z')",
+	"request.time > timestamp('2024-05-01T00:00:00Z') && request.time < timestamp('2024-05-14T10:30:00Z')",
+	"request.time < timestamp('2024-05-14T10:30:00Z') || resource.type == 'storage.googleapis.com/Bucket'",
+	"!(request.time < timestamp('2024-05-14T10:30:00Z'))",
+	"request.time.getHours('Europe/Berlin') >= 9 && request.time.getHours('Europe/Berlin') <= 17",
+	"resource.name.endsWith('&&') && request.time < timestamp('2024-05-14T10:30:00Z')",
+	"api.getAttribute('iam.googleapis.com/modifiedGrantsByRole', []).hasOnly(['roles/viewer'])",
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		expr       string
+		confidence Confidence
+		other      []string
+	}{
+		{"gta", "request.time < timestamp('2024-05-14T10:30:00Z')", Exact, nil},
+		{"double quotes", `request.time < timestamp("2024-05-14T10:30:00Z")`, Exact, nil},
+		{"fractional seconds", "request.time < timestamp('2024-05-14T10:30:00.000Z')", Exact, nil},
+		{"offset and <=", "request.time <= timestamp('2024-05-14T12:30:00+02:00')", Exact, nil},
+		{"reversed", "timestamp('2024-05-14T10:30:00Z') > request.time", Exact, nil},
+		{"parenthesized", "((request.time < timestamp('2024-05-14T10:30:00Z')))", Exact, nil},
+		{
+			"and service",
+			"request.time < timestamp('2024-05-14T10:30:00Z') && resource.service == 'storage.googleapis.com'",
+			Partial, []string{"resource.service == 'storage.googleapis.com'"},
+		},
+		{
+			"nested conjunctions",
+			"(a && (request.time < timestamp('2024-05-14T10:30:00Z') && (b))) && c",
+			Partial, []string{"a", "b", "c"},
+		},
+		{
+			"earliest of two expiries",
+			"request.time < timestamp('2024-05-15T00:00:00Z') && request.time < timestamp('2024-05-14T10:30:00Z')",
+			Exact, nil,
+		},
+		{
+			"and inside a string",
+			"resource.name.endsWith('&&') && request.time < timestamp('2024-05-14T10:30:00Z')",
+			Partial, []string{"resource.name.endsWith('&&')"},
+		},
+		{
+			"or bypasses the expiry",
+			"request.time < timestamp('2024-05-14T10:30:00Z') || resource.type == 'x'",
+			None, []string{"request.time < timestamp('2024-05-14T10:30:00Z') || resource.type == 'x'"},
+		},
+		{
+			"or inside a conjunction",
+			"request.time < timestamp('2024-05-14T10:30:00Z') && (a || b)",
+			Partial, []string{"a || b"},
+		},
+		{
+			"negation",
+			"!(request.time < timestamp('2024-05-14T10:30:00Z'))",
+			None, []string{"!(request.time < timestamp('2024-05-14T10:30:00Z'))"},
+		},
+		{
+			"negated expiry in a conjunction",
+			"a && !(request.time < timestamp('2024-05-14T10:30:00Z'))",
+			None, []string{"a", "!(request.time < timestamp('2024-05-14T10:30:00Z'))"},
+		},
+		{
+			"start time only",
+			"request.time > timestamp('2024-05-14T10:30:00Z')",
+			None, []string{"request.time > timestamp('2024-05-14T10:30:00Z')"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Confidence != tc.confidence {
+				t.Errorf("confidence = %s, want %s", got.Confidence, tc.confidence)
+			}
+			if tc.confidence != None && !got.Time.Equal(expiry) {
+				t.Errorf("time = %s, want %s", got.Time, expiry)
+			}
+			if tc.confidence == None && !got.Time.IsZero() {
+				t.Errorf("time = %s without an expiry", got.Time)
+			}
+			if strings.Join(got.Other, "\n") != strings.Join(tc.other, "\n") {
+				t.Errorf("other = %q, want %q", got.Other, tc.other)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"request.time < timestamp('2024-05-14')",
+		"request.time < timestamp('tomorrow')",
+		"request.time < timestamp('2024-13-01T00:00:00Z')",
+		"a && request.time < timestamp('2024-05-14 10:30:00')",
+		"(request.time < timestamp('2024-05-14T10:30:00Z')",
+		"request.time < timestamp('2024-05-14T10:30:00Z'))",
+		"resource.name == 'unterminated",
+		"a && ",
+		"&& a",
+		"",
+	} {
+		if got, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", expr, got)
+		}
+	}
+}
+
+func TestClausesAndTags(t *testing.T) {
+	expr := Expression(expiry) + " && " + ServiceClause("storage.googleapis.com") + " && " + TagClause("tagKeys/11", "tagValues/456")
+	if got := Service(expr); got != "storage.googleapis.com" {
+		t.Errorf("Service = %q", got)
+	}
+	if got := Tags(expr); len(got) != 1 || got[0] != "tagKeys/11=tagValues/456" {
+		t.Errorf("Tags = %q", got)
+	}
+	clauses := Clauses(expr)
+	if clauses != "(resource.service == 'storage.googleapis.com') && (resource.matchTagId('tagKeys/11', 'tagValues/456'))" {
+		t.Errorf("Clauses = %q", clauses)
+	}
+	if got := Clauses(Expression(expiry)); got != "" {
+		t.Errorf("Clauses of a bare expiry = %q", got)
+	}
+}
+
+// FuzzParse checks Parse never panics, never finds an expiry that || or !
+// can bypass, and that the clauses it keeps and-ed with a new expiry parse
+// back to the same clauses
+func FuzzParse(f *testing.F) {
+	for _, expr := range realWorld {
+		f.Add(expr)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		parsed, err := Parse(expr)
+		if err != nil {
+			return
+		}
+		if !parsed.Found() {
+			if !parsed.Time.IsZero() {
+				t.Fatalf("Parse(%q): time %s without an expiry", expr, parsed.Time)
+			}
+			return
+		}
+		if parts, hasOr, err := splitTopLevel(expr); err == nil && hasOr {
+			t.Fatalf("Parse(%q): expiry found in a top-level disjunction %q", expr, parts)
+		}
+		for _, clause := range parsed.Other {
+			if _, ok, _ := parseClause(clause); ok {
+				t.Fatalf("Parse(%q): expiry clause %q kept as another clause", expr, clause)
+			}
+		}
+
+		clauses := Clauses(expr)
+		if (clauses == "") != (parsed.Confidence == Exact) {
+			t.Fatalf("Parse(%q): Clauses %q with confidence %s", expr, clauses, parsed.Confidence)
+		}
+		if clauses == "" {
+			return
+		}
+		again, err := Parse(Expression(expiry) + " && " + clauses)
+		if err != nil {
+			t.Fatalf("Parse(%q): clauses %q do not parse again: %v", expr, clauses, err)
+		}
+		if !again.Time.Equal(expiry) || strings.Join(again.Other, "\n") != strings.Join(parsed.Other, "\n") {
+			t.Fatalf("Parse(%q): clauses %q parse again to %+v, want %q until %s", expr, clauses, again, parsed.Other, expiry)
+		}
+	})
+}
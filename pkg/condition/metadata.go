@@ -0,0 +1,160 @@
+package condition
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxDescriptionLength is the longest condition description GCP accepts
+	MaxDescriptionLength = 256
+	// metadataPrefix starts descriptions in the structured format
+	metadataPrefix = "gta"
+	// metadataFormat is the version of the structured format written
+	metadataFormat = 1
+	// metadataTimeFormat is a compact UTC timestamp
+	metadataTimeFormat = "20060102T150405Z"
+)
+
+// escaper escapes the characters that would break the structured format
+var escaper = strings.NewReplacer("%", "%25", "|", "%7C", "\n", "%0A", "\r", "%0D")
+
+// Metadata describes a binding created by gta. It is stored in the condition
+// description as fields separated by |, e.g.
+// gta|v=1|gv=v1.2.0|at=20240514T093000Z|by=alice@example.com|sid=abc123|reason=INC-42
+type Metadata struct {
+	// Version is the gta version that created the binding
+	Version   string
+	GrantedAt time.Time
+	// By is the caller who created the binding
+	By        string
+	SessionID string
+	// Manifest is the hash of the name of the gta apply manifest declaring
+	// the binding
+	Manifest string
+	// MirroredFrom is the principal whose bindings gta grant --mirror copied
+	MirroredFrom string
+	// SQLInstance is the connection name of the Cloud SQL instance on which
+	// gta created the database user of the member, to be deleted with the
+	// binding
+	SQLInstance string
+	// RequestID, Requester, and Approver are set on approved requests
+	RequestID string
+	Requester string
+	Approver  string
+	// BreakGlass marks emergency grants made for Incident
+	BreakGlass bool
+	Incident   string
+	Reason     string
+}
+
+// Encode returns the description holding m. Fields that do not fit the
+// description length limit are left out, the reason first by truncation,
+// then the other fields from the last. The database user and the break-glass
+// marker come first and are never left out, as gta clean deletes the user
+// with the binding and the audit reports break-glass bindings: only the
+// incident is truncated when nothing else is left.
+func (m Metadata) Encode() string {
+	fields := []string{metadataPrefix, "v=" + strconv.Itoa(metadataFormat)}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+escaper.Replace(value))
+		}
+	}
+	add("sqlu", m.SQLInstance)
+	if m.BreakGlass {
+		room := MaxDescriptionLength - len(strings.Join(fields, "|")+"|bg=")
+		fields = append(fields, "bg="+fit(m.Incident, room))
+	}
+	kept := len(fields)
+	add("gv", m.Version)
+	if !m.GrantedAt.IsZero() {
+		add("at", m.GrantedAt.UTC().Format(metadataTimeFormat))
+	}
+	add("by", m.By)
+	add("sid", m.SessionID)
+	add("mf", m.Manifest)
+	add("mir", m.MirroredFrom)
+	add("rid", m.RequestID)
+	add("req", m.Requester)
+	add("appr", m.Approver)
+
+	for len(fields) > kept && len(strings.Join(fields, "|")) > MaxDescriptionLength {
+		fields = fields[:len(fields)-1]
+	}
+	head := strings.Join(fields, "|") + "|reason="
+	if reason := fit(m.Reason, MaxDescriptionLength-len(head)); reason != "" {
+		return head + reason
+	}
+	return strings.Join(fields, "|")
+}
+
+// fit returns the longest start of value that is at most room characters
+// long once escaped, escaped
+func fit(value string, room int) string {
+	runes := []rune(value)
+	for len(runes) > 0 {
+		if escaped := escaper.Replace(string(runes)); len(escaped) <= room {
+			return escaped
+		}
+		runes = runes[:len(runes)-1]
+	}
+	return ""
+}
+
+// DecodeMetadata parses a description written by Encode. It reports false for
+// any other description, such as the free-form sentences of older gta
+// versions. Unknown fields are ignored so newer formats can add some.
+func DecodeMetadata(description string) (Metadata, bool) {
+	fields := strings.Split(description, "|")
+	if len(fields) < 2 || fields[0] != metadataPrefix || !strings.HasPrefix(fields[1], "v=") {
+		return Metadata{}, false
+	}
+	if _, err := strconv.Atoi(strings.TrimPrefix(fields[1], "v=")); err != nil {
+		return Metadata{}, false
+	}
+
+	var m Metadata
+	for _, field := range fields[2:] {
+		key, raw, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value, err := url.PathUnescape(raw)
+		if err != nil {
+			value = raw
+		}
+		switch key {
+		case "gv":
+			m.Version = value
+		case "at":
+			if t, err := time.Parse(metadataTimeFormat, value); err == nil {
+				m.GrantedAt = t
+			}
+		case "by":
+			m.By = value
+		case "sid":
+			m.SessionID = value
+		case "mf":
+			m.Manifest = value
+		case "mir":
+			m.MirroredFrom = value
+		case "sqlu":
+			m.SQLInstance = value
+		case "rid":
+			m.RequestID = value
+		case "req":
+			m.Requester = value
+		case "appr":
+			m.Approver = value
+		case "bg":
+			m.BreakGlass = true
+			m.Incident = value
+		case "reason":
+			m.Reason = value
+		}
+	}
+	return m, true
+}
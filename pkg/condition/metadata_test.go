@@ -0,0 +1,197 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// full sets every field of Metadata
+var full = Metadata{
+	Version:      "v1.2.0",
+	GrantedAt:    time.Date(2024, 5, 14, 9, 30, 0, 0, time.UTC),
+	By:           "alice@example.com",
+	SessionID:    "20240514T093000-ab12cd",
+	Manifest:     "3f2a9c",
+	MirroredFrom: "user:bob@example.com",
+	SQLInstance:  "my-project:europe-west1:db",
+	RequestID:    "req-7",
+	Requester:    "carol@example.com",
+	Approver:     "dave@example.com",
+	BreakGlass:   true,
+	Incident:     "INC-42",
+	Reason:       "investigate INC-42",
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    Metadata
+	}{
+		{"empty", Metadata{}},
+		{"every field", full},
+		{"reason only", Metadata{Reason: "INC-42"}},
+		{"separators in values", Metadata{By: "a|b@example.com", Reason: "50% | done\nnext line\r"}},
+		{"escapes in values", Metadata{Reason: "already %7C escaped %zz and %"}},
+		{"equals and unicode", Metadata{Reason: "key=value, 日本語 ✓"}},
+		{"break glass without incident", Metadata{BreakGlass: true}},
+		{"local time", Metadata{GrantedAt: time.Date(2024, 5, 14, 11, 30, 0, 0, time.FixedZone("CEST", 2*3600))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded := tc.m.Encode()
+			if !strings.HasPrefix(encoded, "gta|v=1") || strings.ContainsAny(encoded, "\n\r") {
+				t.Errorf("Encode = %q", encoded)
+			}
+			got, ok := DecodeMetadata(encoded)
+			if !ok {
+				t.Fatalf("DecodeMetadata(%q) failed", encoded)
+			}
+			if !got.GrantedAt.Equal(tc.m.GrantedAt) {
+				t.Errorf("granted at %s, want %s", got.GrantedAt, tc.m.GrantedAt)
+			}
+			got.GrantedAt, tc.m.GrantedAt = time.Time{}, time.Time{}
+			if got != tc.m {
+				t.Errorf("DecodeMetadata(%q) =\n%+v, want\n%+v", encoded, got, tc.m)
+			}
+		})
+	}
+}
+
+func TestEncodeLength(t *testing.T) {
+	// A long reason is truncated to fit, keeping every other field
+	long := full
+	long.Reason = strings.Repeat("long reason ", 40)
+	encoded := long.Encode()
+	if len(encoded) > MaxDescriptionLength {
+		t.Fatalf("%d characters, over the limit of %d", len(encoded), MaxDescriptionLength)
+	}
+	got, _ := DecodeMetadata(encoded)
+	if got.Reason == "" || !strings.HasPrefix(long.Reason, got.Reason) {
+		t.Errorf("reason %q is not a prefix of the original", got.Reason)
+	}
+	if got.Incident != full.Incident || got.SessionID != full.SessionID {
+		t.Errorf("fields lost for the reason: %+v", got)
+	}
+
+	// Truncation never splits a character or an escape
+	escaped := Metadata{By: "alice@example.com", Reason: strings.Repeat("é|", 200)}
+	got, _ = DecodeMetadata(escaped.Encode())
+	if !utf8.ValidString(got.Reason) || !strings.HasPrefix(escaped.Reason, got.Reason) {
+		t.Errorf("truncated reason %q", got.Reason)
+	}
+
+	// Past that, fields are left out from the last
+	crowded := full
+	crowded.Approver = strings.Repeat("a", 200) + "@example.com"
+	encoded = crowded.Encode()
+	got, _ = DecodeMetadata(encoded)
+	if len(encoded) > MaxDescriptionLength || got.By != full.By || got.SessionID != full.SessionID {
+		t.Errorf("Encode = %q", encoded)
+	}
+	if got.Approver != "" {
+		t.Errorf("fields after the one over the limit kept: %+v", got)
+	}
+	// The break-glass marker and the database user are never left out
+	if !got.BreakGlass || got.Incident != full.Incident || got.SQLInstance != full.SQLInstance {
+		t.Errorf("break-glass marker or database user lost: %+v", got)
+	}
+	// The reason still goes in the room left
+	if got.Reason != full.Reason {
+		t.Errorf("reason %q, want %q", got.Reason, full.Reason)
+	}
+}
+
+func TestEncodeOversizedIncident(t *testing.T) {
+	// Values too long for any other field keep the marker, the incident
+	// truncated in the room left
+	oversized := full
+	oversized.Incident = "INC-" + strings.Repeat("9", 300)
+	oversized.Requester = strings.Repeat("r", 200) + "@example.com"
+	encoded := oversized.Encode()
+	if len(encoded) > MaxDescriptionLength {
+		t.Fatalf("%d characters, over the limit of %d", len(encoded), MaxDescriptionLength)
+	}
+	got, ok := DecodeMetadata(encoded)
+	if !ok {
+		t.Fatalf("DecodeMetadata(%q) failed", encoded)
+	}
+	if !got.BreakGlass || got.Incident == "" || !strings.HasPrefix(oversized.Incident, got.Incident) {
+		t.Errorf("break-glass marker %v for incident %q", got.BreakGlass, got.Incident)
+	}
+	if got.SQLInstance != full.SQLInstance {
+		t.Errorf("database user %q, want %q", got.SQLInstance, full.SQLInstance)
+	}
+}
+
+func TestDecodeMetadataTolerance(t *testing.T) {
+	// Descriptions of older gta versions and of other tools are not metadata
+	for _, description := range []string{
+		"",
+		"Temporary access granted by gta",
+		"Temporary access granted by alice@example.com until 2024-05-14T10:30:00Z",
+		"gta",
+		"gta|reason=INC-42",
+		"gta|v=next|by=alice@example.com",
+		"GTA|v=1|by=alice@example.com",
+	} {
+		if m, ok := DecodeMetadata(description); ok {
+			t.Errorf("DecodeMetadata(%q) = %+v", description, m)
+		}
+	}
+
+	// Newer formats may add fields, and broken fields do not lose the others
+	m, ok := DecodeMetadata("gta|v=2|new=field|garbage|at=yesterday|by=alice@example.com|reason=100%")
+	if !ok {
+		t.Fatal("newer format rejected")
+	}
+	if m.By != "alice@example.com" || !m.GrantedAt.IsZero() || m.Reason != "100%" {
+		t.Errorf("DecodeMetadata = %+v", m)
+	}
+}
+
+// FuzzMetadata checks any metadata encodes within the length limit, decodes
+// back, and only ever loses the end of its reason or incident or its last
+// fields, never the break-glass marker
+func FuzzMetadata(f *testing.F) {
+	f.Add("alice@example.com", "abc123", "INC-42", "")
+	f.Add("a|b", "%7C", "50% | done\n", "INC|1")
+	f.Add(strings.Repeat("x", 300), "", strings.Repeat("é", 300), "")
+	f.Fuzz(func(t *testing.T, by, sid, reason, incident string) {
+		for _, s := range []string{by, sid, reason, incident} {
+			if !utf8.ValidString(s) {
+				return
+			}
+		}
+		m := Metadata{By: by, SessionID: sid, Reason: reason, BreakGlass: incident != "", Incident: incident}
+		encoded := m.Encode()
+		if len(encoded) > MaxDescriptionLength {
+			t.Fatalf("Encode(%+v) is %d characters long", m, len(encoded))
+		}
+		got, ok := DecodeMetadata(encoded)
+		if !ok {
+			t.Fatalf("DecodeMetadata(%q) failed", encoded)
+		}
+		for _, field := range [][2]string{{got.By, by}, {got.SessionID, sid}} {
+			if field[0] != "" && field[0] != field[1] {
+				t.Fatalf("DecodeMetadata(%q) = %+v, want %+v", encoded, got, m)
+			}
+		}
+		if got.BreakGlass != m.BreakGlass || !strings.HasPrefix(incident, got.Incident) {
+			t.Fatalf("DecodeMetadata(%q) = %+v, want the marker of %+v", encoded, got, m)
+		}
+		if !strings.HasPrefix(reason, got.Reason) {
+			t.Fatalf("reason %q is not a prefix of %q", got.Reason, reason)
+		}
+		// Everything is kept when it all fits
+		unabridged := "gta|v=1"
+		for _, field := range [][2]string{{"by", by}, {"sid", sid}, {"bg", incident}, {"reason", reason}} {
+			if field[1] != "" {
+				unabridged += "|" + field[0] + "=" + escaper.Replace(field[1])
+			}
+		}
+		if len(unabridged) <= MaxDescriptionLength && got != m {
+			t.Fatalf("DecodeMetadata(%q) = %+v, want %+v", encoded, got, m)
+		}
+	})
+}
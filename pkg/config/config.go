@@ -0,0 +1,1867 @@
+// Package config loads and validates the gta configuration file
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/approval"
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/notify"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/retry"
+	"github.com/yckao/gta/pkg/terraform"
+	"github.com/yckao/gta/pkg/workerpool"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFileName is the name of the config file looked up in the home directory
+const DefaultFileName = ".gta.yaml"
+
+// Config is the schema of the gta configuration file
+type Config struct {
+	Project    string   `yaml:"project"`
+	Verbosity  string   `yaml:"verbosity"`
+	Format     string   `yaml:"format"`
+	DefaultTTL Duration `yaml:"default_ttl"`
+	// DefaultTTLByRole and DefaultTTLByEnvironment override DefaultTTL for
+	// the roles matching a regular expression and the environments they
+	// name, see ResolveTTL
+	DefaultTTLByRole        map[string]Duration `yaml:"default_ttl_by_role"`
+	DefaultTTLByEnvironment map[string]Duration `yaml:"default_ttl_by_environment"`
+	MaxTTL                  Duration            `yaml:"max_ttl"`
+	// RevokeEarly is how long before the expiry of their condition the
+	// bindings of a session are revoked, see gta grant --revoke-early
+	RevokeEarly    Duration            `yaml:"revoke_early"`
+	AllowedRoles   []string            `yaml:"allowed_roles"`
+	BroadRoles     []string            `yaml:"broad_roles"`
+	PartialFailure string              `yaml:"partial_failure"`
+	RateLimit      RateLimitConfig     `yaml:"rate_limit"`
+	Concurrency    int                 `yaml:"concurrency"`
+	Retry          RetryConfig         `yaml:"retry"`
+	Metrics        MetricsConfig       `yaml:"metrics"`
+	Audit          AuditConfig         `yaml:"audit"`
+	Notifications  NotificationsConfig `yaml:"notifications"`
+	Approval       ApprovalConfig      `yaml:"approval"`
+	BreakGlass     BreakGlassConfig    `yaml:"break_glass"`
+	Environments   EnvironmentsConfig  `yaml:"environments"`
+	Server         ServerConfig        `yaml:"server"`
+	Policy         PolicyConfig        `yaml:"policy"`
+	HTTP           HTTPConfig          `yaml:"http"`
+	State          StateConfig         `yaml:"state"`
+	Completion     CompletionConfig    `yaml:"completion"`
+	Terraform      TerraformConfig     `yaml:"terraform"`
+	Watch          WatchConfig         `yaml:"watch"`
+	Plan           PlanConfig          `yaml:"plan"`
+	PolicyLimits   PolicyLimitsConfig  `yaml:"policy_limits"`
+	GranterRoster  GranterRosterConfig `yaml:"granter_roster"`
+	// AllowServiceAccountCaller allows modifying policies with the credentials
+	// of a service account, which are refused unless they impersonate it
+	AllowServiceAccountCaller bool `yaml:"allow_service_account_caller"`
+	// ForbidDeprecatedRoles refuses to grant deprecated and disabled roles,
+	// which are otherwise granted with a warning
+	ForbidDeprecatedRoles bool `yaml:"forbid_deprecated_roles"`
+	// AllowedGranters are regular expressions of the callers who may grant
+	// to members other than themselves; anyone may when empty
+	AllowedGranters []string `yaml:"allowed_granters"`
+	// NoSelfGrant are regular expressions of the roles nobody may grant to
+	// themselves directly, even through impersonation: someone else grants
+	// them, or they are requested through the approval workflow
+	NoSelfGrant []string `yaml:"no_self_grant"`
+	// RoleGranters restrict who may grant roles directly at all
+	RoleGranters []RoleGranters `yaml:"role_granters"`
+	// RoleDependencies add or offer companion roles when granting roles
+	// that are rarely useful alone
+	RoleDependencies []RoleDependency `yaml:"role_dependencies"`
+	// Identities name users and principals, given as @NAME wherever a
+	// member is accepted, e.g. oncall: oncall-sre@example.com
+	Identities map[string]string `yaml:"identities"`
+	// Projects name project IDs, given wherever a project is accepted, e.g.
+	// payments-prod: acme-prod-payments-7f3a2
+	Projects map[string]string `yaml:"projects"`
+	// ProjectTemplate is the pattern of project IDs whose {placeholders} are
+	// given as KEY=VALUE pairs wherever a project is accepted, e.g.
+	// acme-{env}-{service}-* matches acme-prod-payments-7f3a2 for
+	// env=prod,service=payments
+	ProjectTemplate string `yaml:"project_template"`
+	// BindingPrefix starts the condition titles of the bindings gta creates
+	// and matches, defaults to gta_temporary_access
+	BindingPrefix string `yaml:"binding_prefix"`
+	// KnownBindingPrefixes are the prefixes of other teams, matched by list
+	// and clean with --any-prefix
+	KnownBindingPrefixes []string `yaml:"known_binding_prefixes"`
+	// Provider is the provider of commands whose flags and arguments do not
+	// point to one
+	Provider string `yaml:"provider"`
+	// Providers are the providers whose access gta list --all-providers
+	// shows, defaults to gcp
+	Providers []string `yaml:"providers"`
+	// Profile is the profile used when --profile is not given
+	Profile  string                   `yaml:"profile"`
+	Profiles map[string]ProfileConfig `yaml:"profiles"`
+
+	// path is the file the config was loaded from, empty for defaults
+	path string
+	// lines maps dotted field paths to their line in the config file
+	lines map[string]int
+	// allowedRoles holds the compiled AllowedRoles patterns
+	allowedRoles []*regexp.Regexp
+	// allowedGranters holds the compiled AllowedGranters patterns
+	allowedGranters []*regexp.Regexp
+	// noSelfGrant holds the compiled NoSelfGrant patterns
+	noSelfGrant []*regexp.Regexp
+	// ttlByRole holds the compiled DefaultTTLByRole patterns
+	ttlByRole map[string]*regexp.Regexp
+	// approvalProjects holds the compiled Approval.Projects patterns
+	approvalProjects []*regexp.Regexp
+	// incidentPattern holds the compiled BreakGlass.IncidentPattern
+	incidentPattern *regexp.Regexp
+	// broadRoles holds the compiled BroadRoles patterns
+	broadRoles []*regexp.Regexp
+	// sensitiveProjects holds the compiled Policy.SensitiveProjects patterns
+	sensitiveProjects []*regexp.Regexp
+}
+
+// RateLimitConfig configures client-side rate limiting of Google API calls
+type RateLimitConfig struct {
+	QPS   float64 `yaml:"qps"`
+	Burst int     `yaml:"burst"`
+}
+
+// RetryConfig tunes the retries of Google API calls. The settings at its top
+// apply to every category unless the category sets them itself.
+type RetryConfig struct {
+	RetrySettings `yaml:",inline"`
+	// Conflicts, Transient, and Propagation are the settings of etag
+	// conflicts, of 429, 5xx, and network errors, and of the polling of a
+	// policy after a revocation
+	Conflicts   RetrySettings `yaml:"conflicts"`
+	Transient   RetrySettings `yaml:"transient"`
+	Propagation RetrySettings `yaml:"propagation"`
+}
+
+// RetrySettings are retry settings, each left to the defaults when nil
+type RetrySettings struct {
+	MaxRetries *int      `yaml:"max_retries"`
+	MaxElapsed *Duration `yaml:"max_elapsed"`
+	Backoff    *Duration `yaml:"backoff"`
+	MaxBackoff *Duration `yaml:"max_backoff"`
+}
+
+// Apply returns policy with the settings that are set. A policy left
+// without retries drops its time budget, unless the settings set both.
+func (s RetrySettings) Apply(policy retry.Policy) retry.Policy {
+	if s.MaxRetries != nil {
+		policy.MaxRetries = *s.MaxRetries
+	}
+	if s.MaxElapsed != nil {
+		policy.MaxElapsed = time.Duration(*s.MaxElapsed)
+	}
+	if s.Backoff != nil {
+		policy.Backoff = time.Duration(*s.Backoff)
+	}
+	if s.MaxBackoff != nil {
+		policy.MaxBackoff = time.Duration(*s.MaxBackoff)
+	}
+	if policy.MaxRetries == 0 && (s.MaxRetries == nil || s.MaxElapsed == nil) {
+		policy.MaxElapsed = 0
+	}
+	return policy
+}
+
+// category returns the settings of a retry category
+func (r RetryConfig) category(name string) RetrySettings {
+	switch name {
+	case provider.RetryConflicts:
+		return r.Conflicts
+	case provider.RetryTransient:
+		return r.Transient
+	case provider.RetryPropagation:
+		return r.Propagation
+	}
+	return RetrySettings{}
+}
+
+// RetryPolicies returns the retry policy of each category: its default,
+// with the settings at the top of retry applied, then those of the category
+func (c *Config) RetryPolicies() provider.RetryPolicies {
+	policies := provider.DefaultRetryPolicies()
+	for category, policy := range policies {
+		policies[category] = c.Retry.category(category).Apply(c.Retry.Apply(policy))
+	}
+	return policies
+}
+
+// MetricsConfig configures the metrics listener used by long-running modes
+type MetricsConfig struct {
+	Listen string `yaml:"listen"`
+}
+
+// PolicyConfig configures the Rego policy evaluated before every grant
+type PolicyConfig struct {
+	// Path is a Rego file or a directory of Rego files
+	Path string `yaml:"path"`
+	// SensitiveProjects are regular expressions of project IDs where grants are
+	// denied when the policy cannot be loaded or evaluated; other projects
+	// proceed with a warning
+	SensitiveProjects []string `yaml:"sensitive_projects"`
+}
+
+// HTTPConfig configures the HTTP transport of Google API calls, e.g. to go
+// through a TLS-inspecting proxy. HTTPS_PROXY is honored when Proxy is not set.
+type HTTPConfig struct {
+	Proxy string `yaml:"proxy"`
+	// CAFile is a PEM bundle of CAs trusted in addition to the system ones
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are a client certificate for mutual TLS
+	CertFile       string   `yaml:"cert_file"`
+	KeyFile        string   `yaml:"key_file"`
+	ConnectTimeout Duration `yaml:"connect_timeout"`
+	ReadTimeout    Duration `yaml:"read_timeout"`
+	// DisableRequestReason stops sending the reason of a session in the
+	// X-Goog-Request-Reason header of API calls
+	DisableRequestReason bool `yaml:"disable_request_reason"`
+}
+
+// StateConfig configures the local session state
+type StateConfig struct {
+	// Retention is how long sessions are kept after their bindings expired,
+	// defaults to a week
+	Retention Duration `yaml:"retention"`
+	// Encryption encrypts the state file and the local audit log at rest
+	Encryption EncryptionConfig `yaml:"encryption"`
+}
+
+// EncryptionConfig configures the encryption of the local files
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Key is where the key is kept: keychain, the OS keychain (the default),
+	// or passphrase, derived from a passphrase asked for or read from
+	// GTA_PASSPHRASE
+	Key string `yaml:"key"`
+}
+
+// Key sources of EncryptionConfig
+const (
+	EncryptionKeyKeychain   = "keychain"
+	EncryptionKeyPassphrase = "passphrase"
+)
+
+// DefaultStateRetention is how long sessions are kept after their bindings
+// expired when state.retention is not set
+const DefaultStateRetention = 7 * 24 * time.Hour
+
+// CompletionConfig configures shell completion
+type CompletionConfig struct {
+	// Directory completes --user and --member from the Admin Directory API in
+	// addition to the identities granted before
+	Directory bool `yaml:"directory"`
+	// Timeout bounds the directory lookup, defaults to two seconds
+	Timeout Duration `yaml:"timeout"`
+}
+
+// DefaultCompletionTimeout bounds the directory lookup of shell completion
+// when completion.timeout is not set
+const DefaultCompletionTimeout = 2 * time.Second
+
+// WatchConfig configures the check of the bindings of a session while it
+// waits, warning when another writer removes or changes them
+type WatchConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is how often the policies are read, defaults to five minutes
+	Interval Duration `yaml:"interval"`
+	// AutoRegrant grants the roles found removed or changed again without asking
+	AutoRegrant bool `yaml:"auto_regrant"`
+}
+
+// PlanConfig configures the plan files of gta plan
+type PlanConfig struct {
+	// MaxAge is how long after it was made gta apply --plan executes a plan,
+	// defaults to an hour
+	MaxAge Duration `yaml:"max_age"`
+}
+
+// PolicyLimitsConfig configures the sizes of a policy past which grants warn,
+// below the GCP limits of 1,500 principals and 64KB they fail at
+type PolicyLimitsConfig struct {
+	// WarnPrincipals is the number of principals, counted once per binding,
+	// defaults to 1,350
+	WarnPrincipals int `yaml:"warn_principals"`
+	// WarnBytes is the size of the serialized policy, defaults to 90% of 64KB
+	WarnBytes int `yaml:"warn_bytes"`
+}
+
+// GranterRosterConfig restricts modifying IAM policies with gta to the
+// callers it lists, whatever IAM allows them. Unlike allowed_granters, which
+// only restricts granting to others, it applies to every change.
+type GranterRosterConfig struct {
+	// Members are the emails of the callers allowed, and group:EMAIL the
+	// Cloud Identity groups whose members, direct or not, are
+	Members []string `yaml:"members"`
+	// LookupTimeout bounds each group membership lookup, defaults to five
+	// seconds
+	LookupTimeout Duration `yaml:"lookup_timeout"`
+	// CacheTTL is how long a group membership looked up is reused, defaults
+	// to ten minutes
+	CacheTTL Duration `yaml:"cache_ttl"`
+	// FailOpen allows callers whose group memberships cannot be looked up,
+	// e.g. as the Cloud Identity API is unreachable; they are refused by
+	// default
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// DefaultPlanMaxAge is how long a plan can be executed when plan.max_age is
+// not set
+const DefaultPlanMaxAge = time.Hour
+
+const (
+	// DefaultWatchInterval is how often watch.enabled reads the policies of a
+	// session when watch.interval is not set
+	DefaultWatchInterval = 5 * time.Minute
+	// MinWatchInterval bounds how often a session reads its policies, which
+	// also go through the rate limiter
+	MinWatchInterval = 30 * time.Second
+)
+
+// RoleDependency offers the roles Add along with Role when it is granted on
+// a project or resource of the kind On
+type RoleDependency struct {
+	Role string `yaml:"role"`
+	// On is project or a resource kind such as bucket, any when empty
+	On  string   `yaml:"on"`
+	Add []string `yaml:"add"`
+	// AddOn is where the companion roles are granted: the same project or
+	// resource as Role (same, the default), or its project (project)
+	AddOn string `yaml:"add_on"`
+}
+
+// RoleGranters restricts the direct grants of the roles matching Role, a
+// regular expression, to the callers matching AllowedGranters
+type RoleGranters struct {
+	Role            string   `yaml:"role"`
+	AllowedGranters []string `yaml:"allowed_granters"`
+
+	// role and allowedGranters hold the compiled patterns
+	role            *regexp.Regexp
+	allowedGranters []*regexp.Regexp
+}
+
+// Places of the companion roles of a RoleDependency
+const (
+	DependencyOnSame    = "same"
+	DependencyOnProject = "project"
+)
+
+// TerraformConfig configures gta grant --from-terraform-plan
+type TerraformConfig struct {
+	// Permissions extends the bundled table of the permissions that each
+	// resource type needs per action (create, read, update, or delete),
+	// replacing the permissions of the actions it lists
+	Permissions map[string]map[string][]string `yaml:"permissions"`
+}
+
+// ServerConfig configures gta serve
+type ServerConfig struct {
+	Listen string `yaml:"listen"`
+	// Audience is the audience expected in ID tokens, e.g. the IAP client ID
+	Audience string `yaml:"audience"`
+	// ImpersonateServiceAccount is the granter service account used for IAM changes
+	ImpersonateServiceAccount string `yaml:"impersonate_service_account"`
+}
+
+// AuditConfig configures the local audit log and event exporters
+type AuditConfig struct {
+	// Path of the local JSON lines audit log, defaults to ~/.gta/audit.jsonl
+	Path     string          `yaml:"path"`
+	Disabled bool            `yaml:"disabled"`
+	BigQuery *BigQueryConfig `yaml:"bigquery"`
+	PubSub   *PubSubConfig   `yaml:"pubsub"`
+}
+
+// PubSubConfig configures publishing audit events to Pub/Sub as CloudEvents
+type PubSubConfig struct {
+	// Topic is the topic published to, as projects/PROJECT/topics/TOPIC
+	Topic string `yaml:"topic"`
+}
+
+// BigQueryConfig configures exporting audit events to BigQuery
+type BigQueryConfig struct {
+	Project    string `yaml:"project"`
+	Dataset    string `yaml:"dataset"`
+	Table      string `yaml:"table"`
+	DisableDDL bool   `yaml:"disable_ddl"`
+}
+
+// NotificationsConfig configures where notifications are delivered
+type NotificationsConfig struct {
+	Slack      *WebhookConfig `yaml:"slack"`
+	GoogleChat *WebhookConfig `yaml:"google_chat"`
+	Teams      *WebhookConfig `yaml:"teams"`
+	Email      *EmailConfig   `yaml:"email"`
+	// Webhook posts every lifecycle event to a generic endpoint
+	Webhook *EventWebhookConfig `yaml:"webhook"`
+	// RemindBefore sends a reminder this long before a session expires
+	RemindBefore Duration `yaml:"remind_before"`
+	// Routes select the notifications each notifier above receives; without
+	// routes, every notifier receives every notification
+	Routes []NotificationRoute `yaml:"routes"`
+}
+
+// Notifiers that routes can name
+const (
+	NotifierSlack      = "slack"
+	NotifierGoogleChat = "google_chat"
+	NotifierTeams      = "teams"
+	NotifierEmail      = "email"
+)
+
+// Notifiers are the names of the notifiers, in the order they are created
+var Notifiers = []string{NotifierSlack, NotifierGoogleChat, NotifierTeams, NotifierEmail}
+
+// NotificationRoute sends the notifications matching all of its filters to
+// a notifier of its notifications block. Filters left empty match every
+// notification; projects and roles are regular expressions matching whole
+// values.
+type NotificationRoute struct {
+	Name string `yaml:"name"`
+	// Notifier is slack, google_chat, teams, or email
+	Notifier     string   `yaml:"notifier"`
+	Actions      []string `yaml:"actions"`
+	Projects     []string `yaml:"projects"`
+	Environments []string `yaml:"environments"`
+	Roles        []string `yaml:"roles"`
+	// MinSeverity is info (default), warning, or critical
+	MinSeverity string `yaml:"min_severity"`
+}
+
+// Filter compiles the filters of the route
+func (r NotificationRoute) Filter() (notify.Filter, error) {
+	var f notify.Filter
+	for _, action := range r.Actions {
+		if !slices.Contains(notify.Actions, audit.Action(action)) {
+			names := make([]string, len(notify.Actions))
+			for i, a := range notify.Actions {
+				names[i] = string(a)
+			}
+			return f, fmt.Errorf("unknown action %q (expected %s)", action, strings.Join(names, ", "))
+		}
+		f.Actions = append(f.Actions, audit.Action(action))
+	}
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var compiled []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled, nil
+	}
+	var err error
+	if f.Projects, err = compile(r.Projects); err != nil {
+		return f, err
+	}
+	if f.Roles, err = compile(r.Roles); err != nil {
+		return f, err
+	}
+	f.Environments = r.Environments
+	f.MinSeverity, err = notify.ParseSeverity(r.MinSeverity)
+	return f, err
+}
+
+// EmailConfig configures the SMTP notifier
+type EmailConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordEnv names an environment variable holding the password
+	PasswordEnv     string   `yaml:"password_env"`
+	From            string   `yaml:"from"`
+	To              []string `yaml:"to"`
+	TLS             string   `yaml:"tls"`
+	SubjectTemplate string   `yaml:"subject_template"`
+	BodyTemplate    string   `yaml:"body_template"`
+}
+
+// WebhookConfig configures an incoming webhook
+type WebhookConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// EventWebhookConfig configures the generic lifecycle event webhook
+type EventWebhookConfig struct {
+	URL    string `yaml:"url"`
+	Secret string `yaml:"secret"`
+	// SecretEnv names an environment variable holding the signing secret
+	SecretEnv string `yaml:"secret_env"`
+	// Strict aborts a grant when the event cannot be delivered
+	Strict      bool `yaml:"strict"`
+	MaxAttempts int  `yaml:"max_attempts"`
+}
+
+// ApprovalConfig configures the request and approval workflow
+type ApprovalConfig struct {
+	// Projects are regular expressions of project IDs that require approval
+	Projects []string `yaml:"projects"`
+	// Store is a directory or gs://bucket/prefix holding requests, defaults to ~/.gta/requests
+	Store string `yaml:"store"`
+	// Expiry is how long a request can be approved, defaults to 24h
+	Expiry     Duration `yaml:"expiry"`
+	SigningKey string   `yaml:"signing_key"`
+	// SigningKeyEnv names an environment variable holding the signing key
+	SigningKeyEnv string `yaml:"signing_key_env"`
+}
+
+// DefaultRequestExpiry is how long requests can be approved when approval.expiry is not set
+const DefaultRequestExpiry = 24 * time.Hour
+
+// BreakGlassConfig configures emergency grants bypassing approval
+type BreakGlassConfig struct {
+	// IncidentPattern is a regular expression incident references must match
+	IncidentPattern string `yaml:"incident_pattern"`
+	// MaxTTL caps the TTL of break-glass grants, defaults to 1h
+	MaxTTL Duration `yaml:"max_ttl"`
+	// Notifications always receive break-glass events, in addition to the
+	// notifications of the active profile
+	Notifications *NotificationsConfig `yaml:"notifications"`
+}
+
+const (
+	// DefaultIncidentPattern matches references such as INC-1234
+	DefaultIncidentPattern = `[A-Z][A-Z0-9]*-[0-9]+`
+	// DefaultBreakGlassMaxTTL caps break-glass grants when break_glass.max_ttl is not set
+	DefaultBreakGlassMaxTTL = time.Hour
+)
+
+// EnvironmentsConfig classifies projects into environments, such as dev,
+// stage, and prod, each with its own grant policy
+type EnvironmentsConfig struct {
+	// Label is the project label holding the environment, e.g. env
+	Label string `yaml:"label"`
+	// Values maps label values to environments, e.g. production: prod; a
+	// value that names an environment needs no mapping
+	Values map[string]string `yaml:"values"`
+	// Projects sets the environment of projects by ID, overriding their label
+	Projects map[string]string `yaml:"projects"`
+	// Default is the environment of projects without the label or with a
+	// value naming no environment, none when empty
+	Default string `yaml:"default"`
+	// OnLookupFailure is the environment of projects whose labels cannot be
+	// read, defaulting to the strictest, the last of Classes
+	OnLookupFailure string `yaml:"on_lookup_failure"`
+	// Classes are the environments and their policies, from the least to the
+	// most strict
+	Classes []EnvironmentClass `yaml:"classes"`
+}
+
+// EnvironmentClass is the grant policy of an environment
+type EnvironmentClass struct {
+	Name string `yaml:"name"`
+	// RequireReason refuses grants without a reason
+	RequireReason bool `yaml:"require_reason"`
+	// Confirm asks for confirmation before granting, unless --yes is given
+	Confirm bool `yaml:"confirm"`
+	// MaxTTL caps the TTL of grants, along with max_ttl
+	MaxTTL Duration `yaml:"max_ttl"`
+	// Notifications always receive the events of grants in the environment,
+	// in addition to the notifications of the active profile
+	Notifications *NotificationsConfig `yaml:"notifications"`
+}
+
+// ProfileConfig holds settings that override the top-level config when the
+// profile is active
+type ProfileConfig struct {
+	Project       string               `yaml:"project"`
+	Notifications *NotificationsConfig `yaml:"notifications"`
+	HTTP          *HTTPConfig          `yaml:"http"`
+	Provider      string               `yaml:"provider"`
+	Providers     []string             `yaml:"providers"`
+}
+
+// Duration is a time.Duration decoded from a duration string such as "1h30m"
+// or "2d"
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := duration.Parse(node.Value)
+	if err != nil {
+		return &yaml.TypeError{Errors: []string{
+			fmt.Sprintf("line %d: invalid duration %q (expected %s)", node.Line, node.Value, duration.Examples),
+		}}
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return duration.Format(time.Duration(d)), nil
+}
+
+// Problem is a single issue found in a config file
+type Problem struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+// String formats the problem with its line and field context
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", p.Line)
+	}
+	if p.Field != "" {
+		fmt.Fprintf(&b, "%s: ", p.Field)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// ValidationError reports every problem found in a config file
+type ValidationError struct {
+	Path     string
+	Problems []Problem
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid config file %s:", e.Path))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// AuditPath returns the path of the local audit log
+func (c *Config) AuditPath() (string, error) {
+	if c.Audit.Path != "" {
+		return c.Audit.Path, nil
+	}
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// DefaultPath returns the default config file path in the user's home directory
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %v", err)
+	}
+	return filepath.Join(home, DefaultFileName), nil
+}
+
+// Load reads, decodes, and validates the config file at path. If path is empty
+// the default path is used, and a missing default file yields an empty config.
+func Load(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		defaultPath, err := DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+		path = defaultPath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			validationErr.Path = path
+		}
+		return nil, err
+	}
+	cfg.path = path
+	return cfg, nil
+}
+
+// Parse decodes and validates config file contents
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{lines: make(map[string]int)}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, &ValidationError{Problems: []Problem{{Message: err.Error()}}}
+	}
+	recordLines(&root, "", cfg.lines)
+
+	var problems []Problem
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, &ValidationError{Problems: []Problem{{Message: err.Error()}}}
+		}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, decodeProblem(msg))
+		}
+	}
+
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// Path returns the file the config was loaded from
+func (c *Config) Path() string {
+	return c.path
+}
+
+// ResolveProfile returns the name of the active profile, which is the given
+// name or else the configured default. An empty name means no profile.
+func (c *Config) ResolveProfile(name string) (string, error) {
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		return "", nil
+	}
+	if _, ok := c.Profiles[name]; !ok {
+		return "", fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(c.ProfileNames(), ", "))
+	}
+	return name, nil
+}
+
+// ProfileNames returns the configured profile names in sorted order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IdentityNames returns the names of the identities in sorted order
+func (c *Config) IdentityNames() []string {
+	names := make([]string, 0, len(c.Identities))
+	for name := range c.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProjectFor returns the default project of the given profile, falling back
+// to the top-level project, with its alias resolved
+func (c *Config) ProjectFor(profile string) string {
+	if p, ok := c.Profiles[profile]; ok && p.Project != "" {
+		return c.ProjectAlias(p.Project)
+	}
+	return c.ProjectAlias(c.Project)
+}
+
+// ProjectAlias returns the project ID named by an alias of projects, or the
+// project unchanged when it is not an alias
+func (c *Config) ProjectAlias(project string) string {
+	if id, ok := c.Projects[project]; ok {
+		return id
+	}
+	return project
+}
+
+// ProjectAliasNames returns the aliases of projects in sorted order
+func (c *Config) ProjectAliasNames() []string {
+	names := make([]string, 0, len(c.Projects))
+	for name := range c.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProjectTemplateKeys returns the placeholders of project_template in order
+func (c *Config) ProjectTemplateKeys() []string {
+	var keys []string
+	for _, m := range templatePlaceholderPattern.FindAllStringSubmatch(c.ProjectTemplate, -1) {
+		if !slices.Contains(keys, m[1]) {
+			keys = append(keys, m[1])
+		}
+	}
+	return keys
+}
+
+// ProjectPattern fills the placeholders of project_template with values,
+// given as KEY=VALUE pairs separated by commas, e.g. env=prod,service=payments,
+// and returns the resulting pattern of project IDs
+func (c *Config) ProjectPattern(values string) (string, error) {
+	if c.ProjectTemplate == "" {
+		return "", fmt.Errorf("%q names no project: set project_template in config to find projects by KEY=VALUE", values)
+	}
+	keys := c.ProjectTemplateKeys()
+	given := make(map[string]string)
+	for _, pair := range strings.Split(values, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		switch {
+		case !slices.Contains(keys, key):
+			return "", fmt.Errorf("unknown key %q in %q (project_template takes %s)", key, values, strings.Join(keys, ", "))
+		case !projectValuePattern.MatchString(value):
+			return "", fmt.Errorf("invalid value %q of %s in %q", value, key, values)
+		}
+		given[key] = value
+	}
+	var missing []string
+	for _, key := range keys {
+		if _, ok := given[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%q gives no %s of project_template %s", values, strings.Join(missing, ", "), c.ProjectTemplate)
+	}
+	return templatePlaceholderPattern.ReplaceAllStringFunc(c.ProjectTemplate, func(placeholder string) string {
+		return given[strings.Trim(placeholder, "{}")]
+	}), nil
+}
+
+// HTTPFor returns the HTTP settings of the given profile, falling back to the
+// top-level settings
+func (c *Config) HTTPFor(profile string) HTTPConfig {
+	if p, ok := c.Profiles[profile]; ok && p.HTTP != nil {
+		return *p.HTTP
+	}
+	return c.HTTP
+}
+
+// BindingTitlePrefix returns the prefix of the bindings gta creates
+func (c *Config) BindingTitlePrefix() string {
+	if c.BindingPrefix != "" {
+		return c.BindingPrefix
+	}
+	return provider.DefaultBindingPrefix
+}
+
+// ProviderFor returns the provider pinned by the given profile, falling back
+// to the top-level setting
+func (c *Config) ProviderFor(profile string) string {
+	if p, ok := c.Profiles[profile]; ok && p.Provider != "" {
+		return p.Provider
+	}
+	return c.Provider
+}
+
+// ProvidersFor returns the providers configured for the given profile,
+// falling back to the top-level setting and then to gcp
+func (c *Config) ProvidersFor(profile string) []string {
+	if p, ok := c.Profiles[profile]; ok && len(p.Providers) > 0 {
+		return p.Providers
+	}
+	if len(c.Providers) > 0 {
+		return c.Providers
+	}
+	return []string{provider.NameGCP}
+}
+
+// NotificationsFor returns the notification settings of the given profile,
+// falling back to the top-level settings
+func (c *Config) NotificationsFor(profile string) NotificationsConfig {
+	if p, ok := c.Profiles[profile]; ok && p.Notifications != nil {
+		return *p.Notifications
+	}
+	return c.Notifications
+}
+
+// RequestStore returns the location of the approval request store
+func (c *Config) RequestStore() (string, error) {
+	if c.Approval.Store != "" {
+		return c.Approval.Store, nil
+	}
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "requests"), nil
+}
+
+// RequestExpiry returns how long a request can be approved
+func (c *Config) RequestExpiry() time.Duration {
+	if c.Approval.Expiry > 0 {
+		return time.Duration(c.Approval.Expiry)
+	}
+	return DefaultRequestExpiry
+}
+
+// StateRetention returns how long sessions are kept after their bindings expired
+func (c *Config) StateRetention() time.Duration {
+	if c.State.Retention > 0 {
+		return time.Duration(c.State.Retention)
+	}
+	return DefaultStateRetention
+}
+
+// CompletionTimeout returns how long shell completion waits for the directory
+func (c *Config) CompletionTimeout() time.Duration {
+	if c.Completion.Timeout > 0 {
+		return time.Duration(c.Completion.Timeout)
+	}
+	return DefaultCompletionTimeout
+}
+
+// WatchInterval returns how often the bindings of a session are checked
+func (c *Config) WatchInterval() time.Duration {
+	if c.Watch.Interval > 0 {
+		return time.Duration(c.Watch.Interval)
+	}
+	return DefaultWatchInterval
+}
+
+// PlanMaxAge returns how long after it was made a plan can be executed
+func (c *Config) PlanMaxAge() time.Duration {
+	if c.Plan.MaxAge > 0 {
+		return time.Duration(c.Plan.MaxAge)
+	}
+	return DefaultPlanMaxAge
+}
+
+// PolicyWarnLimits returns the sizes of a policy past which grants warn
+func (c *Config) PolicyWarnLimits() provider.PolicyLimits {
+	return provider.PolicyLimits{Members: c.PolicyLimits.WarnPrincipals, Bytes: c.PolicyLimits.WarnBytes}
+}
+
+// Roster returns the granter roster of granter_roster, caching group
+// memberships at cachePath, or nil when it lists no members
+func (c *Config) Roster(cachePath string) *provider.GranterRoster {
+	if len(c.GranterRoster.Members) == 0 {
+		return nil
+	}
+	source := "granter_roster"
+	if c.path != "" {
+		source += " in " + c.path
+	}
+	return &provider.GranterRoster{
+		Members:   c.GranterRoster.Members,
+		Source:    source,
+		Timeout:   time.Duration(c.GranterRoster.LookupTimeout),
+		FailOpen:  c.GranterRoster.FailOpen,
+		CachePath: cachePath,
+		CacheTTL:  time.Duration(c.GranterRoster.CacheTTL),
+	}
+}
+
+// ApprovalRequired reports whether grants in project must go through approval
+func (c *Config) ApprovalRequired(project string) bool {
+	for _, re := range c.approvalProjects {
+		if re.MatchString(project) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicySensitive reports whether policy failures deny grants in project
+func (c *Config) PolicySensitive(project string) bool {
+	for _, re := range c.sensitiveProjects {
+		if re.MatchString(project) {
+			return true
+		}
+	}
+	return false
+}
+
+// IncidentValid reports whether ref matches the break-glass incident pattern
+func (c *Config) IncidentValid(ref string) bool {
+	if c.incidentPattern == nil {
+		c.incidentPattern = regexp.MustCompile("^(?:" + DefaultIncidentPattern + ")$")
+	}
+	return c.incidentPattern.MatchString(ref)
+}
+
+// IncidentPattern returns the pattern incident references must match
+func (c *Config) IncidentPattern() string {
+	if c.BreakGlass.IncidentPattern != "" {
+		return c.BreakGlass.IncidentPattern
+	}
+	return DefaultIncidentPattern
+}
+
+// BreakGlassMaxTTL returns the longest TTL allowed for break-glass grants
+func (c *Config) BreakGlassMaxTTL() time.Duration {
+	if c.BreakGlass.MaxTTL > 0 {
+		return time.Duration(c.BreakGlass.MaxTTL)
+	}
+	return DefaultBreakGlassMaxTTL
+}
+
+// Environment returns the environment named name
+func (c *Config) Environment(name string) (EnvironmentClass, bool) {
+	for _, class := range c.Environments.Classes {
+		if class.Name == name {
+			return class, true
+		}
+	}
+	return EnvironmentClass{}, false
+}
+
+// EnvironmentFor returns the environment of project: its override in
+// environments.projects, or the environment its label maps to, read with
+// labels. When the labels cannot be read, the environment of
+// on_lookup_failure is returned along with the error. The environment is
+// empty when none is configured or the project is not classified.
+func (c *Config) EnvironmentFor(project string, labels func() (map[string]string, error)) (string, error) {
+	envs := c.Environments
+	if len(envs.Classes) == 0 {
+		return "", nil
+	}
+	if name, ok := envs.Projects[project]; ok {
+		return name, nil
+	}
+	if envs.Label == "" {
+		return envs.Default, nil
+	}
+	values, err := labels()
+	if err != nil {
+		fallback := envs.OnLookupFailure
+		if fallback == "" {
+			fallback = envs.Classes[len(envs.Classes)-1].Name
+		}
+		return fallback, err
+	}
+	value, ok := values[envs.Label]
+	if !ok {
+		return envs.Default, nil
+	}
+	if name, ok := envs.Values[value]; ok {
+		return name, nil
+	}
+	if _, ok := c.Environment(value); ok {
+		return value, nil
+	}
+	return envs.Default, nil
+}
+
+// EnvironmentNotifications reports whether any environment has notifications
+// of its own
+func (c *Config) EnvironmentNotifications() bool {
+	for _, class := range c.Environments.Classes {
+		if class.Notifications != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleAllowed reports whether role matches the allowed_roles patterns.
+// All roles are allowed when no patterns are configured.
+func (c *Config) RoleAllowed(role string) bool {
+	if len(c.allowedRoles) == 0 {
+		return true
+	}
+	for _, re := range c.allowedRoles {
+		if re.MatchString(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// GranterAllowed reports whether caller, an email, matches the
+// allowed_granters patterns. Everyone may grant to others when no patterns
+// are configured.
+func (c *Config) GranterAllowed(caller string) bool {
+	if len(c.allowedGranters) == 0 {
+		return true
+	}
+	for _, re := range c.allowedGranters {
+		if re.MatchString(caller) {
+			return true
+		}
+	}
+	return false
+}
+
+// The rules a TTL comes from, in the order of precedence
+const (
+	TTLRuleFlag        = "--ttl"
+	TTLRuleRole        = "default_ttl_by_role"
+	TTLRuleEnvironment = "default_ttl_by_environment"
+	TTLRuleDefault     = "default_ttl"
+	TTLRuleBuiltIn     = "the built-in default"
+)
+
+// ResolveTTL returns the TTL of role, in its full form, granted in
+// environment, and the rule it comes from, by precedence: given, the TTL of
+// --ttl when set, then the shortest of default_ttl_by_role whose pattern
+// matches role, then default_ttl_by_environment of environment, then
+// default_ttl, and last fallback, the default of --ttl
+func (c *Config) ResolveTTL(role, environment string, given, fallback time.Duration) (time.Duration, string) {
+	if given > 0 {
+		return given, TTLRuleFlag
+	}
+	var ttl time.Duration
+	var rule string
+	for _, pattern := range sortedKeys(c.ttlByRole) {
+		d := time.Duration(c.DefaultTTLByRole[pattern])
+		if c.ttlByRole[pattern].MatchString(role) && (ttl == 0 || d < ttl) {
+			ttl, rule = d, fmt.Sprintf("%s[%s]", TTLRuleRole, pattern)
+		}
+	}
+	if ttl > 0 {
+		return ttl, rule
+	}
+	if d, ok := c.DefaultTTLByEnvironment[environment]; ok && environment != "" {
+		return time.Duration(d), fmt.Sprintf("%s[%s]", TTLRuleEnvironment, environment)
+	}
+	if c.DefaultTTL > 0 {
+		return time.Duration(c.DefaultTTL), TTLRuleDefault
+	}
+	return fallback, TTLRuleBuiltIn
+}
+
+// SelfGrantForbidden returns the no_self_grant entry matching role, if any,
+// e.g. no_self_grant[0] (roles/iam\.securityAdmin)
+func (c *Config) SelfGrantForbidden(role string) (string, bool) {
+	for i, re := range c.noSelfGrant {
+		if re.MatchString(role) {
+			return fmt.Sprintf("no_self_grant[%d] (%s)", i, c.NoSelfGrant[i]), true
+		}
+	}
+	return "", false
+}
+
+// RoleGranterDenied returns the first role_granters entry matching role that
+// none of callers, emails, matches, if any, e.g. role_granters[0]
+func (c *Config) RoleGranterDenied(role string, callers []string) (string, bool) {
+	for i, rule := range c.RoleGranters {
+		if rule.role == nil || !rule.role.MatchString(role) {
+			continue
+		}
+		allowed := false
+		for _, re := range rule.allowedGranters {
+			for _, caller := range callers {
+				allowed = allowed || re.MatchString(caller)
+			}
+		}
+		if !allowed {
+			return fmt.Sprintf("role_granters[%d]", i), true
+		}
+	}
+	return "", false
+}
+
+// HasRoleRules reports whether no_self_grant or role_granters restrict the
+// grants of role
+func (c *Config) HasRoleRules(role string) bool {
+	if _, ok := c.SelfGrantForbidden(role); ok {
+		return true
+	}
+	for _, rule := range c.RoleGranters {
+		if rule.role != nil && rule.role.MatchString(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultBroadRoles are the broad roles used when broad_roles is not set
+var DefaultBroadRoles = []string{"roles/owner", "roles/editor"}
+
+// BroadRole reports whether role is broad enough to look up narrower
+// alternatives before granting it
+func (c *Config) BroadRole(role string) bool {
+	if c.BroadRoles == nil {
+		for _, broad := range DefaultBroadRoles {
+			if role == broad {
+				return true
+			}
+		}
+		return false
+	}
+	for _, re := range c.broadRoles {
+		if re.MatchString(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks field values and compiles patterns
+func (c *Config) validate() []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{
+			Line:    c.lines[field],
+			Field:   field,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if c.Verbosity != "" {
+		if _, err := logger.ParseLevel(c.Verbosity); err != nil {
+			add("verbosity", "%v (expected debug, info, warn, or error)", err)
+		}
+	}
+	if c.Format != "" {
+		if _, err := logger.ParseFormat(c.Format); err != nil {
+			add("format", "%v (expected plain or json)", err)
+		}
+	}
+	if c.DefaultTTL < 0 {
+		add("default_ttl", "must be positive")
+	}
+	if c.MaxTTL < 0 {
+		add("max_ttl", "must be positive")
+	}
+	if c.DefaultTTL > 0 && c.MaxTTL > 0 && c.DefaultTTL > c.MaxTTL {
+		add("default_ttl", "%s exceeds max_ttl %s", duration.Format(time.Duration(c.DefaultTTL)), duration.Format(time.Duration(c.MaxTTL)))
+	}
+	c.ttlByRole = make(map[string]*regexp.Regexp, len(c.DefaultTTLByRole))
+	for _, pattern := range sortedKeys(c.DefaultTTLByRole) {
+		field := "default_ttl_by_role." + pattern
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(field, "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.ttlByRole[pattern] = re
+		c.validateDefaultTTL(field, c.DefaultTTLByRole[pattern], add)
+	}
+	for _, name := range sortedKeys(c.DefaultTTLByEnvironment) {
+		field := "default_ttl_by_environment." + name
+		if _, ok := c.Environment(name); !ok {
+			add(field, "unknown environment %q (expected one of environments.classes)", name)
+		}
+		c.validateDefaultTTL(field, c.DefaultTTLByEnvironment[name], add)
+	}
+	if c.Plan.MaxAge < 0 {
+		add("plan.max_age", "must be positive")
+	}
+	if c.PolicyLimits.WarnPrincipals < 0 || c.PolicyLimits.WarnPrincipals > provider.MaxPolicyMembers {
+		add("policy_limits.warn_principals", "must be between 1 and %d", provider.MaxPolicyMembers)
+	}
+	if c.PolicyLimits.WarnBytes < 0 || c.PolicyLimits.WarnBytes > provider.MaxPolicyBytes {
+		add("policy_limits.warn_bytes", "must be between 1 and %d", provider.MaxPolicyBytes)
+	}
+	for i, member := range c.GranterRoster.Members {
+		email := strings.TrimPrefix(member, "group:")
+		if kind, _, ok := strings.Cut(email, ":"); ok {
+			add(fmt.Sprintf("granter_roster.members[%d]", i), "unknown type %q, expected an email or group:EMAIL", kind)
+			continue
+		}
+		if !strings.Contains(email, "@") {
+			add(fmt.Sprintf("granter_roster.members[%d]", i), "invalid member %q, expected an email or group:EMAIL", member)
+		}
+	}
+	if c.GranterRoster.LookupTimeout < 0 {
+		add("granter_roster.lookup_timeout", "must be positive")
+	}
+	if c.GranterRoster.CacheTTL < 0 {
+		add("granter_roster.cache_ttl", "must be positive")
+	}
+	if c.RevokeEarly < 0 {
+		add("revoke_early", "must be positive")
+	}
+	if c.RevokeEarly > 0 && c.DefaultTTL > 0 && c.RevokeEarly >= c.DefaultTTL {
+		add("revoke_early", "%s must be shorter than default_ttl %s", duration.Format(time.Duration(c.RevokeEarly)), duration.Format(time.Duration(c.DefaultTTL)))
+	}
+	c.allowedRoles = nil
+	for i, pattern := range c.AllowedRoles {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(fmt.Sprintf("allowed_roles[%d]", i), "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.allowedRoles = append(c.allowedRoles, re)
+	}
+	c.allowedGranters = nil
+	for i, pattern := range c.AllowedGranters {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(fmt.Sprintf("allowed_granters[%d]", i), "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.allowedGranters = append(c.allowedGranters, re)
+	}
+	c.noSelfGrant = nil
+	for i, pattern := range c.NoSelfGrant {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(fmt.Sprintf("no_self_grant[%d]", i), "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.noSelfGrant = append(c.noSelfGrant, re)
+	}
+	for i := range c.RoleGranters {
+		rule := &c.RoleGranters[i]
+		field := fmt.Sprintf("role_granters[%d]", i)
+		rule.role, rule.allowedGranters = nil, nil
+		if rule.Role == "" {
+			add(field+".role", "is required")
+		} else if re, err := regexp.Compile("^(?:" + rule.Role + ")$"); err != nil {
+			add(field+".role", "invalid pattern %q: %v", rule.Role, err)
+		} else {
+			rule.role = re
+		}
+		if len(rule.AllowedGranters) == 0 {
+			add(field+".allowed_granters", "lists no granters")
+		}
+		for j, pattern := range rule.AllowedGranters {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				add(fmt.Sprintf("%s.allowed_granters[%d]", field, j), "invalid pattern %q: %v", pattern, err)
+				continue
+			}
+			rule.allowedGranters = append(rule.allowedGranters, re)
+		}
+	}
+	for i, dep := range c.RoleDependencies {
+		field := fmt.Sprintf("role_dependencies[%d]", i)
+		if dep.Role == "" {
+			add(field+".role", "is required")
+		}
+		if len(dep.Add) == 0 {
+			add(field+".add", "lists no roles")
+		}
+		for j, role := range dep.Add {
+			if role == "" || provider.FormatRole(role) == provider.FormatRole(dep.Role) {
+				add(fmt.Sprintf("%s.add[%d]", field, j), "%q is not a companion of %s", role, dep.Role)
+			}
+		}
+		if dep.On != "" && dep.On != DependencyOnProject && !slices.Contains(provider.ResourceKinds(), dep.On) {
+			add(field+".on", "unknown %q (expected project or one of %s)", dep.On, strings.Join(provider.ResourceKinds(), ", "))
+		}
+		switch dep.AddOn {
+		case "", DependencyOnSame, DependencyOnProject:
+		default:
+			add(field+".add_on", "unknown %q (expected same or project)", dep.AddOn)
+		}
+	}
+	for _, name := range c.IdentityNames() {
+		field := "identities." + name
+		identity := c.Identities[name]
+		switch {
+		case name == "" || strings.ContainsAny(name, "@: \t"):
+			add(field, "invalid name %q (expected a name such as oncall, given as @oncall)", name)
+		case identity == "":
+			add(field, "is empty")
+		case strings.Contains(identity, ":"):
+			if err := provider.ValidateMember(identity); err != nil {
+				add(field, "%v", err)
+			}
+		case !strings.Contains(identity, "@"):
+			add(field, "invalid identity %q (expected an email or a member such as group:team@example.com)", identity)
+		}
+	}
+	for _, name := range c.ProjectAliasNames() {
+		field := "projects." + name
+		switch id := c.Projects[name]; {
+		case name == "" || strings.ContainsAny(name, "/=, \t"):
+			add(field, "invalid alias %q (expected a name such as payments-prod)", name)
+		case !projectIDPattern.MatchString(id):
+			add(field, "invalid project ID %q", id)
+		}
+	}
+	if c.ProjectTemplate != "" {
+		if len(c.ProjectTemplateKeys()) == 0 {
+			add("project_template", "%q has no {placeholder}", c.ProjectTemplate)
+		} else if rest := templatePlaceholderPattern.ReplaceAllString(c.ProjectTemplate, "x"); !projectGlobPattern.MatchString(rest) {
+			add("project_template", "%q is not a pattern of project IDs (expected lowercase letters, digits, hyphens, * and {placeholders})", c.ProjectTemplate)
+		}
+	}
+	c.broadRoles = nil
+	for i, pattern := range c.BroadRoles {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(fmt.Sprintf("broad_roles[%d]", i), "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.broadRoles = append(c.broadRoles, re)
+	}
+	c.approvalProjects = nil
+	for i, pattern := range c.Approval.Projects {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(fmt.Sprintf("approval.projects[%d]", i), "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.approvalProjects = append(c.approvalProjects, re)
+	}
+	c.sensitiveProjects = nil
+	for i, pattern := range c.Policy.SensitiveProjects {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add(fmt.Sprintf("policy.sensitive_projects[%d]", i), "invalid pattern %q: %v", pattern, err)
+			continue
+		}
+		c.sensitiveProjects = append(c.sensitiveProjects, re)
+	}
+	if len(c.Policy.SensitiveProjects) > 0 && c.Policy.Path == "" {
+		add("policy.sensitive_projects", "requires policy.path")
+	}
+	if strings.HasPrefix(c.Approval.Store, "gs://") {
+		if _, _, err := approval.ParseGCSLocation(c.Approval.Store); err != nil {
+			add("approval.store", "%v", err)
+		}
+	}
+	if c.Approval.Expiry < 0 {
+		add("approval.expiry", "must be positive")
+	}
+	if c.Approval.SigningKey != "" && c.Approval.SigningKeyEnv != "" {
+		add("approval.signing_key_env", "cannot be combined with signing_key")
+	}
+	c.incidentPattern = nil
+	if pattern := c.BreakGlass.IncidentPattern; pattern != "" {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			add("break_glass.incident_pattern", "invalid pattern %q: %v", pattern, err)
+		}
+		c.incidentPattern = re
+	}
+	if c.BreakGlass.MaxTTL < 0 {
+		add("break_glass.max_ttl", "must be positive")
+	}
+	if n := c.BreakGlass.Notifications; n != nil {
+		c.validateNotifications("break_glass.notifications", *n, add)
+		if n.Webhook != nil {
+			add("break_glass.notifications.webhook", "is not supported, use notifications.webhook")
+		}
+		if n.RemindBefore != 0 {
+			add("break_glass.notifications.remind_before", "is not supported, use notifications.remind_before")
+		}
+	}
+	c.validateEnvironments(add)
+	if _, err := provider.ParsePartialFailurePolicy(c.PartialFailure); err != nil {
+		add("partial_failure", "%v (expected allow or fail)", err)
+	}
+	if c.Completion.Timeout < 0 {
+		add("completion.timeout", "must not be negative")
+	}
+	resourceTypes := make([]string, 0, len(c.Terraform.Permissions))
+	for resourceType := range c.Terraform.Permissions {
+		resourceTypes = append(resourceTypes, resourceType)
+	}
+	sort.Strings(resourceTypes)
+	for _, resourceType := range resourceTypes {
+		for action := range c.Terraform.Permissions[resourceType] {
+			switch action {
+			case terraform.ActionCreate, terraform.ActionRead, terraform.ActionUpdate, terraform.ActionDelete:
+			default:
+				add(fmt.Sprintf("terraform.permissions.%s", resourceType), "invalid action %q (expected create, read, update, or delete)", action)
+			}
+		}
+	}
+	if c.State.Retention < 0 {
+		add("state.retention", "must not be negative")
+	}
+	if c.Watch.Interval != 0 && time.Duration(c.Watch.Interval) < MinWatchInterval {
+		add("watch.interval", "must be at least %v", MinWatchInterval)
+	}
+	switch c.State.Encryption.Key {
+	case "", EncryptionKeyKeychain, EncryptionKeyPassphrase:
+	default:
+		add("state.encryption.key", "invalid key source %q (expected keychain or passphrase)", c.State.Encryption.Key)
+	}
+	retryValid := validateRetry("retry", c.Retry.RetrySettings, add)
+	for _, category := range provider.RetryCategories {
+		retryValid = validateRetry("retry."+category, c.Retry.category(category), add) && retryValid
+	}
+	if retryValid {
+		policies := c.RetryPolicies()
+		for _, category := range provider.RetryCategories {
+			if err := policies[category].Validate(); err != nil {
+				add("retry."+category, "%v", err)
+			}
+		}
+	}
+	if c.RateLimit.QPS < 0 {
+		add("rate_limit.qps", "must not be negative")
+	}
+	if c.Concurrency < 0 || c.Concurrency > workerpool.MaxWorkers {
+		add("concurrency", "must be between 1 and %d", workerpool.MaxWorkers)
+	}
+	if c.RateLimit.Burst < 0 {
+		add("rate_limit.burst", "must not be negative")
+	}
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			add("metrics.listen", "invalid listen address %q (expected host:port)", c.Metrics.Listen)
+		}
+	}
+	if c.Server.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Server.Listen); err != nil {
+			add("server.listen", "invalid listen address %q (expected host:port)", c.Server.Listen)
+		}
+	}
+	if bq := c.Audit.BigQuery; bq != nil {
+		if bq.Project == "" {
+			add("audit.bigquery.project", "is required")
+		}
+		if !validBigQueryName(bq.Dataset) {
+			add("audit.bigquery.dataset", "invalid name %q (expected letters, digits, and underscores)", bq.Dataset)
+		}
+		if !validBigQueryName(bq.Table) {
+			add("audit.bigquery.table", "invalid name %q (expected letters, digits, and underscores)", bq.Table)
+		}
+	}
+	if ps := c.Audit.PubSub; ps != nil && !pubSubTopicPattern.MatchString(ps.Topic) {
+		add("audit.pubsub.topic", "invalid topic %q (expected projects/PROJECT/topics/TOPIC)", ps.Topic)
+	}
+	c.validateNotifications("notifications", c.Notifications, add)
+	validateHTTP("http", c.HTTP, add)
+	if c.BindingPrefix != "" {
+		if err := provider.ValidateBindingPrefix(c.BindingPrefix); err != nil {
+			add("binding_prefix", "%v", err)
+		}
+	}
+	for _, prefix := range c.KnownBindingPrefixes {
+		if err := provider.ValidateBindingPrefix(prefix); err != nil {
+			add("known_binding_prefixes", "%v", err)
+		}
+	}
+	validateProviders("provider", []string{c.Provider}, add)
+	validateProviders("providers", c.Providers, add)
+	if c.Profile != "" {
+		if _, ok := c.Profiles[c.Profile]; !ok {
+			add("profile", "unknown profile %q", c.Profile)
+		}
+	}
+	for _, name := range c.ProfileNames() {
+		if n := c.Profiles[name].Notifications; n != nil {
+			c.validateNotifications(fmt.Sprintf("profiles.%s.notifications", name), *n, add)
+		}
+		if h := c.Profiles[name].HTTP; h != nil {
+			validateHTTP(fmt.Sprintf("profiles.%s.http", name), *h, add)
+		}
+		validateProviders(fmt.Sprintf("profiles.%s.provider", name), []string{c.Profiles[name].Provider}, add)
+		validateProviders(fmt.Sprintf("profiles.%s.providers", name), c.Profiles[name].Providers, add)
+	}
+
+	return problems
+}
+
+// validateRetry checks the retry settings under prefix on their own and
+// reports whether they are valid, the policies they make up being checked
+// as a whole afterwards
+func validateRetry(prefix string, s RetrySettings, add func(field, format string, args ...interface{})) bool {
+	valid := true
+	if s.MaxRetries != nil && *s.MaxRetries < 0 {
+		add(prefix+".max_retries", "must not be negative")
+		valid = false
+	}
+	for _, d := range []struct {
+		field string
+		value *Duration
+	}{{"max_elapsed", s.MaxElapsed}, {"backoff", s.Backoff}, {"max_backoff", s.MaxBackoff}} {
+		if d.value != nil && *d.value < 0 {
+			add(prefix+"."+d.field, "must not be negative")
+			valid = false
+		}
+	}
+	if s.MaxRetries != nil && *s.MaxRetries == 0 && s.MaxElapsed != nil && *s.MaxElapsed > 0 {
+		add(prefix+".max_elapsed", "has no effect with max_retries 0, remove it or allow retries")
+		valid = false
+	}
+	return valid
+}
+
+// validateProviders checks a providers list
+func validateProviders(field string, providers []string, add func(field, format string, args ...interface{})) {
+	for _, name := range providers {
+		if name != "" && !provider.Supported(name) {
+			add(field, "unsupported provider %q (expected %s)", name, strings.Join(provider.Names, ", "))
+		}
+	}
+}
+
+// validateDefaultTTL checks a TTL of default_ttl_by_role or
+// default_ttl_by_environment against max_ttl
+func (c *Config) validateDefaultTTL(field string, ttl Duration, add func(field, format string, args ...interface{})) {
+	switch {
+	case ttl <= 0:
+		add(field, "must be positive")
+	case c.MaxTTL > 0 && ttl > c.MaxTTL:
+		add(field, "%s exceeds max_ttl %s", duration.Format(time.Duration(ttl)), duration.Format(time.Duration(c.MaxTTL)))
+	}
+}
+
+// validateHTTP checks an http block; the files are only read when the
+// transport is created
+func validateHTTP(prefix string, h HTTPConfig, add func(field, format string, args ...interface{})) {
+	if h.Proxy != "" {
+		if err := validateURL(h.Proxy); err != nil {
+			add(prefix+".proxy", "invalid URL %q: %v", h.Proxy, err)
+		}
+	}
+	if (h.CertFile == "") != (h.KeyFile == "") {
+		add(prefix+".cert_file", "cert_file and key_file must be set together")
+	}
+	if h.ConnectTimeout < 0 {
+		add(prefix+".connect_timeout", "must be positive")
+	}
+	if h.ReadTimeout < 0 {
+		add(prefix+".read_timeout", "must be positive")
+	}
+}
+
+// validateEnvironments checks that the environments are named once and that
+// the classification names known environments
+func (c *Config) validateEnvironments(add func(field, format string, args ...interface{})) {
+	envs := c.Environments
+	if len(envs.Classes) == 0 {
+		if envs.Label != "" || len(envs.Values) > 0 || len(envs.Projects) > 0 || envs.Default != "" || envs.OnLookupFailure != "" {
+			add("environments", "classifies projects but lists no environments in classes")
+		}
+		return
+	}
+	seen := make(map[string]bool)
+	for i, class := range envs.Classes {
+		field := fmt.Sprintf("environments.classes[%d]", i)
+		switch {
+		case class.Name == "":
+			add(field+".name", "is required")
+		case seen[class.Name]:
+			add(field+".name", "duplicate environment %q", class.Name)
+		}
+		seen[class.Name] = true
+		if class.MaxTTL < 0 {
+			add(field+".max_ttl", "must be positive")
+		}
+		if n := class.Notifications; n != nil {
+			c.validateNotifications(field+".notifications", *n, add)
+			if n.Webhook != nil {
+				add(field+".notifications.webhook", "is not supported, use notifications.webhook")
+			}
+			if n.RemindBefore != 0 {
+				add(field+".notifications.remind_before", "is not supported, use notifications.remind_before")
+			}
+		}
+	}
+	known := func(field, name string) {
+		if !seen[name] {
+			add(field, "unknown environment %q", name)
+		}
+	}
+	if len(envs.Values) > 0 && envs.Label == "" {
+		add("environments.values", "requires environments.label")
+	}
+	for _, value := range sortedKeys(envs.Values) {
+		known("environments.values."+value, envs.Values[value])
+	}
+	for _, project := range sortedKeys(envs.Projects) {
+		known("environments.projects."+project, envs.Projects[project])
+	}
+	if envs.Default != "" {
+		known("environments.default", envs.Default)
+	}
+	if envs.OnLookupFailure != "" {
+		known("environments.on_lookup_failure", envs.OnLookupFailure)
+	}
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validateNotifications checks the webhook URLs of a notifications block
+func (c *Config) validateNotifications(prefix string, n NotificationsConfig, add func(field, format string, args ...interface{})) {
+	webhooks := []struct {
+		name    string
+		webhook *WebhookConfig
+	}{
+		{"slack", n.Slack},
+		{"google_chat", n.GoogleChat},
+		{"teams", n.Teams},
+	}
+	for _, w := range webhooks {
+		if w.webhook == nil {
+			continue
+		}
+		field := prefix + "." + w.name + ".webhook_url"
+		if err := validateURL(w.webhook.WebhookURL); err != nil {
+			add(field, "invalid URL %q: %v", w.webhook.WebhookURL, err)
+		}
+	}
+
+	if n.RemindBefore < 0 {
+		add(prefix+".remind_before", "must be positive")
+	}
+
+	if w := n.Webhook; w != nil {
+		prefix := prefix + ".webhook"
+		if err := validateURL(w.URL); err != nil {
+			add(prefix+".url", "invalid URL %q: %v", w.URL, err)
+		}
+		if w.Secret != "" && w.SecretEnv != "" {
+			add(prefix+".secret_env", "cannot be combined with secret")
+		}
+		if w.MaxAttempts < 0 {
+			add(prefix+".max_attempts", "must be positive")
+		}
+	}
+
+	if e := n.Email; e != nil {
+		prefix := prefix + ".email"
+		if e.Host == "" {
+			add(prefix+".host", "is required")
+		}
+		if e.Port < 0 || e.Port > 65535 {
+			add(prefix+".port", "invalid port %d", e.Port)
+		}
+		if e.Password != "" && e.PasswordEnv != "" {
+			add(prefix+".password_env", "cannot be combined with password")
+		}
+		if _, err := mail.ParseAddress(e.From); err != nil {
+			add(prefix+".from", "invalid address %q: %v", e.From, err)
+		}
+		if len(e.To) == 0 {
+			add(prefix+".to", "at least one recipient is required")
+		}
+		for i, to := range e.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				add(fmt.Sprintf("%s.to[%d]", prefix, i), "invalid address %q: %v", to, err)
+			}
+		}
+		switch e.TLS {
+		case "", notify.EmailTLSStartTLS, notify.EmailTLSImplicit, notify.EmailTLSNone:
+		default:
+			add(prefix+".tls", "invalid TLS mode %q (expected starttls, tls, or none)", e.TLS)
+		}
+		if _, _, err := notify.ParseEmailTemplates(e.SubjectTemplate, e.BodyTemplate); err != nil {
+			add(prefix, "%v", err)
+		}
+	}
+
+	configured := map[string]bool{
+		NotifierSlack:      n.Slack != nil,
+		NotifierGoogleChat: n.GoogleChat != nil,
+		NotifierTeams:      n.Teams != nil,
+		NotifierEmail:      n.Email != nil,
+	}
+	for i, route := range n.Routes {
+		field := fmt.Sprintf("%s.routes[%d]", prefix, i)
+		if set, known := configured[route.Notifier]; !known {
+			add(field+".notifier", "unknown notifier %q (expected %s)", route.Notifier, strings.Join(Notifiers, ", "))
+		} else if !set {
+			add(field+".notifier", "%s is not configured in %s", route.Notifier, prefix)
+		}
+		if _, err := route.Filter(); err != nil {
+			add(field, "%v", err)
+		}
+		for _, env := range route.Environments {
+			if _, ok := c.Environment(env); !ok {
+				add(field+".environments", "unknown environment %q", env)
+			}
+		}
+	}
+}
+
+// validateURL checks that raw is an absolute http(s) URL
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("expected an absolute http(s) URL")
+	}
+	return nil
+}
+
+// bigQueryNamePattern matches the characters allowed in BigQuery dataset and table names
+// projectIDPattern matches project IDs, including the domain-scoped ones
+var projectIDPattern = regexp.MustCompile(`^(?:[a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// projectGlobPattern matches project_template once its placeholders are
+// filled
+var projectGlobPattern = regexp.MustCompile(`^[a-z0-9*?-]+$`)
+
+// projectValuePattern matches the values filling project_template
+var projectValuePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// templatePlaceholderPattern matches the {placeholders} of project_template
+var templatePlaceholderPattern = regexp.MustCompile(`\{([a-z][a-z0-9_]*)\}`)
+
+var bigQueryNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// pubSubTopicPattern matches the full name of a Pub/Sub topic
+var pubSubTopicPattern = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// validBigQueryName reports whether name is a valid BigQuery dataset or table
+// name; RE2 caps repeat counts at 1000, so the length limit is checked separately
+func validBigQueryName(name string) bool {
+	return len(name) <= 1024 && bigQueryNamePattern.MatchString(name)
+}
+
+// decodeProblemPattern matches the "line N: message" form of yaml decode errors
+var decodeProblemPattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// unknownFieldPattern matches yaml's unknown field message
+var unknownFieldPattern = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+
+// decodeProblem converts a yaml decode error message into a Problem
+func decodeProblem(msg string) Problem {
+	m := decodeProblemPattern.FindStringSubmatch(msg)
+	if m == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(m[1])
+	message := m[2]
+	if f := unknownFieldPattern.FindStringSubmatch(message); f != nil {
+		message = fmt.Sprintf("unknown field %q", f[1])
+	}
+	return Problem{Line: line, Message: message}
+}
+
+// recordLines walks a yaml document and records the line of every mapping key
+func recordLines(node *yaml.Node, prefix string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			recordLines(child, prefix, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			lines[key] = node.Content[i].Line
+			recordLines(node.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			key := fmt.Sprintf("%s[%d]", prefix, i)
+			lines[key] = child.Line
+			recordLines(child, key, lines)
+		}
+	}
+}
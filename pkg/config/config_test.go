@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveTTL(t *testing.T) {
+	c, err := Parse([]byte(`
+default_ttl: 2h
+default_ttl_by_role:
+  roles/owner: 15m
+  roles/.*Admin: 30m
+  roles/iam\..*: 45m
+default_ttl_by_environment:
+  prod: 1h
+  dev: 8h
+environments:
+  label: env
+  classes:
+    - name: dev
+    - name: prod
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, err := Parse([]byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name        string
+		config      *Config
+		role        string
+		environment string
+		given       time.Duration
+		wantTTL     time.Duration
+		wantRule    string
+	}{
+		{name: "given", config: c, role: "roles/owner", environment: "prod", given: 3 * time.Hour, wantTTL: 3 * time.Hour, wantRule: TTLRuleFlag},
+		{name: "role", config: c, role: "roles/owner", environment: "dev", wantTTL: 15 * time.Minute, wantRule: "default_ttl_by_role[roles/owner]"},
+		{name: "shortest matching role", config: c, role: "roles/iam.securityAdmin", wantTTL: 30 * time.Minute, wantRule: "default_ttl_by_role[roles/.*Admin]"},
+		{name: "one matching role", config: c, role: "roles/iam.serviceAccountUser", wantTTL: 45 * time.Minute, wantRule: `default_ttl_by_role[roles/iam\..*]`},
+		{name: "anchored role", config: c, role: "roles/ownerish", environment: "prod", wantTTL: time.Hour, wantRule: "default_ttl_by_environment[prod]"},
+		{name: "environment", config: c, role: "roles/viewer", environment: "dev", wantTTL: 8 * time.Hour, wantRule: "default_ttl_by_environment[dev]"},
+		{name: "unknown environment", config: c, role: "roles/viewer", environment: "staging", wantTTL: 2 * time.Hour, wantRule: TTLRuleDefault},
+		{name: "default", config: c, role: "roles/viewer", wantTTL: 2 * time.Hour, wantRule: TTLRuleDefault},
+		{name: "built-in", config: bare, role: "roles/owner", environment: "prod", wantTTL: time.Hour, wantRule: TTLRuleBuiltIn},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ttl, rule := tc.config.ResolveTTL(tc.role, tc.environment, tc.given, time.Hour)
+			if ttl != tc.wantTTL || rule != tc.wantRule {
+				t.Errorf("ResolveTTL = %s by %s, want %s by %s", ttl, rule, tc.wantTTL, tc.wantRule)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDataDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("APPDATA", filepath.Join(home, "AppData", "Roaming"))
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	want := filepath.Join(home, ".gta")
+	if runtime.GOOS == "windows" {
+		want = filepath.Join(home, "AppData", "Roaming", "gta")
+	}
+	if got, err := DataDir(); err != nil || got != want {
+		t.Errorf("DataDir = %q, %v, want %s", got, err, want)
+	}
+
+	// The state of earlier versions stays where it is
+	legacy := filepath.Join(home, ".gta")
+	if err := os.Mkdir(legacy, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DataDir(); err != nil || got != legacy {
+		t.Errorf("DataDir with %s = %q, %v", legacy, got, err)
+	}
+}
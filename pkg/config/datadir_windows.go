@@ -0,0 +1,27 @@
+//go:build windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DataDir returns the directory holding gta's local state, %APPDATA%\gta, or
+// ~/.gta when earlier versions already keep the state there
+func DataDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %v", err)
+	}
+	legacy := filepath.Join(home, ".gta")
+	if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+		return legacy, nil
+	}
+	appData, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the application data directory: %v", err)
+	}
+	return filepath.Join(appData, "gta"), nil
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/duration"
+	"gopkg.in/yaml.v3"
+)
+
+// InitSettings are the answers gta init writes a config file from
+type InitSettings struct {
+	// Profile puts Project and WebhookURL in a profile of this name, made the
+	// default one, instead of at the top level
+	Profile    string
+	Project    string
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	Format     string
+	// WebhookURL receives every lifecycle event, see notifications.webhook
+	WebhookURL string
+}
+
+// DefaultInitSettings returns the settings of gta init --defaults
+func DefaultInitSettings() InitSettings {
+	return InitSettings{
+		DefaultTTL: time.Hour,
+		MaxTTL:     8 * time.Hour,
+		Format:     "plain",
+	}
+}
+
+// RenderInit returns a commented config file holding s. The file is decoded
+// and validated like any config file, so that an invalid answer is reported
+// with the problems Load would find.
+func RenderInit(s InitSettings) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("# gta configuration, written by gta init\n")
+	b.WriteString("# Check it with: gta config validate\n")
+	if s.DefaultTTL > 0 {
+		b.WriteString("\n# TTL of grants when --ttl is not given\n")
+		fmt.Fprintf(&b, "default_ttl: %s\n", duration.Format(s.DefaultTTL))
+	}
+	if s.MaxTTL > 0 {
+		b.WriteString("\n# Grants with a longer TTL are refused\n")
+		fmt.Fprintf(&b, "max_ttl: %s\n", duration.Format(s.MaxTTL))
+	}
+	if s.Format != "" {
+		b.WriteString("\n# Log format: plain or json\n")
+		fmt.Fprintf(&b, "format: %s\n", yamlScalar(s.Format))
+	}
+
+	indent := ""
+	if s.Profile != "" {
+		b.WriteString("\n# Profile used when --profile is not given\n")
+		fmt.Fprintf(&b, "profile: %s\n", yamlScalar(s.Profile))
+		b.WriteString("profiles:\n")
+		if s.Project == "" && s.WebhookURL == "" {
+			fmt.Fprintf(&b, "  %s: {}\n", yamlScalar(s.Profile))
+		} else {
+			fmt.Fprintf(&b, "  %s:\n", yamlScalar(s.Profile))
+		}
+		indent = "    "
+	}
+	// The settings of a profile follow its name without a blank line
+	blank := "\n"
+	if s.Profile != "" {
+		blank = ""
+	}
+	if s.Project != "" {
+		fmt.Fprintf(&b, "%s%s# Project granted in when --project is not given\n", blank, indent)
+		fmt.Fprintf(&b, "%sproject: %s\n", indent, yamlScalar(s.Project))
+		blank = "\n"
+	}
+	if s.WebhookURL != "" {
+		fmt.Fprintf(&b, "%s%s# Every grant, revoke, and clean event is posted as JSON to the webhook\n", blank, indent)
+		fmt.Fprintf(&b, "%snotifications:\n%s  webhook:\n%s    url: %s\n", indent, indent, indent, yamlScalar(s.WebhookURL))
+	}
+
+	if _, err := Parse(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// AddProfile returns data, the contents of a config file, with a profile
+// named s.Profile holding the project and webhook of s. Comments of data are
+// kept, and the result is validated like any config file.
+func AddProfile(data []byte, s InitSettings) ([]byte, error) {
+	if s.Profile == "" {
+		return nil, fmt.Errorf("a profile name is required")
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	if len(root.Content) == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file is not a mapping")
+	}
+
+	profiles := mappingValue(doc, "profiles")
+	if profiles == nil || profiles.Kind != yaml.MappingNode {
+		profiles = &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(doc, "profiles", profiles)
+	}
+	if mappingValue(profiles, s.Profile) != nil {
+		return nil, fmt.Errorf("profile %q already exists", s.Profile)
+	}
+	profile := &yaml.Node{Kind: yaml.MappingNode}
+	if s.Project != "" {
+		setMappingValue(profile, "project", scalarNode(s.Project))
+	}
+	if s.WebhookURL != "" {
+		webhook := &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(webhook, "url", scalarNode(s.WebhookURL))
+		notifications := &yaml.Node{Kind: yaml.MappingNode}
+		setMappingValue(notifications, "webhook", webhook)
+		setMappingValue(profile, "notifications", notifications)
+	}
+	setMappingValue(profiles, s.Profile, profile)
+
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %v", err)
+	}
+	if _, err := Parse(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// mappingValue returns the value of key in mapping, nil when it has none
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets key to value in mapping, appending it when missing
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, scalarNode(key), value)
+}
+
+// scalarNode returns a string node, quoted when it would read as another type
+func scalarNode(value string) *yaml.Node {
+	node := &yaml.Node{}
+	node.SetString(value)
+	return node
+}
+
+// yamlScalar returns value as a YAML scalar, quoted when needed
+func yamlScalar(value string) string {
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%q", value)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,190 @@
+// Package consoleurl derives the project, and where possible the resource, to
+// grant on from a link to the Google Cloud console
+package consoleurl
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// consoleHosts are the hosts of the console links that are parsed
+var consoleHosts = []string{"console.cloud.google.com", "storage.cloud.google.com"}
+
+var (
+	// projectPattern matches a project ID
+	projectPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	// projectPathPattern matches a project in the path, e.g. /projects/my-project/
+	projectPathPattern = regexp.MustCompile(`/projects/([a-z][a-z0-9-]{4,28}[a-z0-9])(/|$)`)
+	// datasetPattern matches a BigQuery dataset ID
+	datasetPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,1000}$`)
+	// bucketPattern matches a Cloud Storage bucket name
+	bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,220}[a-z0-9]$`)
+	// secretPattern matches a Secret Manager secret ID
+	secretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+)
+
+// Target is what a console link points at
+type Target struct {
+	// Project is the project the link belongs to, empty when the link names
+	// none, as is the case of links to buckets without ?project=
+	Project string
+	// Resource is the resource to grant on, e.g. buckets/my-bucket, empty
+	// when only the project can be derived
+	Resource string
+	// Note explains why the grant is broader than what the link shows, e.g.
+	// the project of a VM or the bucket of an object, empty when it is not
+	Note string
+}
+
+// surface derives the target of the links of a console page from the path
+// below the page and the query
+type surface struct {
+	prefix string
+	parse  func(rest []string, query url.Values, t *Target)
+}
+
+var surfaces = []surface{
+	{prefix: "storage/browser", parse: parseStorage},
+	{prefix: "bigquery", parse: parseBigQuery},
+	{prefix: "security/secret-manager", parse: parseSecret},
+	{prefix: "compute", parse: projectOnly("Compute Engine resources")},
+	{prefix: "sql", parse: projectOnly("Cloud SQL instances")},
+	{prefix: "run", parse: projectOnly("Cloud Run services")},
+	{prefix: "functions", parse: projectOnly("Cloud Functions")},
+	{prefix: "kubernetes", parse: projectOnly("GKE resources")},
+	{prefix: "cloudpubsub", parse: projectOnly("Pub/Sub topics and subscriptions")},
+	{prefix: "logs", parse: projectOnly("Cloud Logging resources")},
+	{prefix: "iam-admin", parse: projectOnly("Service accounts and IAM settings")},
+}
+
+// Parse derives the target of a console link. The resource is derived for
+// Cloud Storage buckets, BigQuery datasets, and Secret Manager secrets; for
+// the links of other pages only the project is, with a Note saying so.
+func Parse(raw string) (*Target, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse console URL: %v", err)
+	}
+	if !isConsoleHost(u.Host) {
+		return nil, fmt.Errorf("%q is not a Google Cloud console URL", raw)
+	}
+	query := u.Query()
+	t := &Target{Project: query.Get("project")}
+	if t.Project == "" {
+		if m := projectPathPattern.FindStringSubmatch(u.Path); m != nil {
+			t.Project = m[1]
+		}
+	}
+
+	// Drop the state the console keeps in the path, as in BUCKET;tab=objects
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, segment := range segments {
+		segments[i], _, _ = strings.Cut(segment, ";")
+	}
+	path := strings.Join(segments, "/")
+	if u.Host == "storage.cloud.google.com" {
+		// Authenticated object links: storage.cloud.google.com/BUCKET/OBJECT
+		path = "storage/browser/" + path
+	}
+	parsed := false
+	for _, s := range surfaces {
+		if path == s.prefix || strings.HasPrefix(path, s.prefix+"/") {
+			rest := strings.Split(strings.TrimPrefix(strings.TrimPrefix(path, s.prefix), "/"), "/")
+			if len(rest) == 1 && rest[0] == "" {
+				rest = nil
+			}
+			s.parse(rest, query, t)
+			parsed = true
+			break
+		}
+	}
+	if !parsed {
+		t.Note = "the page does not name a resource that can be granted on"
+	}
+
+	if t.Project != "" && !projectPattern.MatchString(t.Project) {
+		return nil, fmt.Errorf("invalid project %q in console URL", t.Project)
+	}
+	return t, nil
+}
+
+// isConsoleHost reports whether host serves console links
+func isConsoleHost(host string) bool {
+	for _, h := range consoleHosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStorage parses storage/browser/BUCKET[/PREFIX] and
+// storage/browser/_details/BUCKET/OBJECT
+func parseStorage(rest []string, _ url.Values, t *Target) {
+	if len(rest) > 0 && rest[0] == "_details" {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		t.Note = "the link lists the buckets of the project rather than one bucket"
+		return
+	}
+	if !bucketPattern.MatchString(rest[0]) {
+		t.Note = fmt.Sprintf("%q is not a bucket name", rest[0])
+		return
+	}
+	t.Resource = "buckets/" + rest[0]
+	if len(rest) > 1 {
+		t.Note = "objects are granted on through their bucket"
+	}
+}
+
+// parseBigQuery parses the dataset selected in the BigQuery studio, given by
+// the ws parameter as in ws=!1m4!1m3!3m2!1sPROJECT!2sDATASET, or by the p and
+// d parameters of older links
+func parseBigQuery(_ []string, query url.Values, t *Target) {
+	project, dataset := query.Get("p"), query.Get("d")
+	if ws := query.Get("ws"); ws != "" {
+		project, dataset = "", ""
+		for _, field := range strings.Split(ws, "!") {
+			switch {
+			case strings.HasPrefix(field, "1s") && project == "":
+				project = strings.TrimPrefix(field, "1s")
+			case strings.HasPrefix(field, "2s") && dataset == "":
+				dataset = strings.TrimPrefix(field, "2s")
+			}
+		}
+	}
+	if project == "" {
+		project = t.Project
+	}
+	if dataset == "" || !datasetPattern.MatchString(dataset) || !projectPattern.MatchString(project) {
+		t.Note = "the link selects no BigQuery dataset"
+		return
+	}
+	// The dataset may be in another project than the one the console shows
+	t.Project = project
+	t.Resource = fmt.Sprintf("projects/%s/datasets/%s", project, dataset)
+}
+
+// parseSecret parses security/secret-manager/secret/NAME[/versions]
+func parseSecret(rest []string, _ url.Values, t *Target) {
+	if len(rest) < 2 || rest[0] != "secret" {
+		t.Note = "the link lists the secrets of the project rather than one secret"
+		return
+	}
+	if !secretPattern.MatchString(rest[1]) || t.Project == "" {
+		t.Note = fmt.Sprintf("%q is not a secret of a known project", rest[1])
+		return
+	}
+	t.Resource = fmt.Sprintf("projects/%s/secrets/%s", t.Project, rest[1])
+}
+
+// projectOnly returns the parser of the pages of resources that are granted
+// on through their project
+func projectOnly(what string) func([]string, url.Values, *Target) {
+	return func(_ []string, _ url.Values, t *Target) {
+		t.Note = what + " are granted on through their project"
+	}
+}
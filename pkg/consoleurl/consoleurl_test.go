@@ -0,0 +1,106 @@
+package consoleurl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		url      string
+		project  string
+		resource string
+		note     string
+	}{
+		// Cloud Storage
+		{"https://console.cloud.google.com/storage/browser/my-bucket?project=my-proj", "my-proj", "buckets/my-bucket", ""},
+		{
+			"https://console.cloud.google.com/storage/browser/my-bucket;tab=objects?forceOnBucketsSortingFiltering=true&project=my-proj&prefix=&forceOnObjectsSortingFiltering=false",
+			"my-proj", "buckets/my-bucket", "",
+		},
+		{"https://console.cloud.google.com/storage/browser/my-bucket/logs/2024/05?project=my-proj", "my-proj", "buckets/my-bucket", "through their bucket"},
+		{
+			"https://console.cloud.google.com/storage/browser/_details/my-bucket/exports/data.csv;tab=live_object?project=my-proj",
+			"my-proj", "buckets/my-bucket", "through their bucket",
+		},
+		{"https://storage.cloud.google.com/my-bucket/exports/data.csv", "", "buckets/my-bucket", "through their bucket"},
+		{"https://storage.cloud.google.com/my.bucket.example.com/report.pdf?authuser=1", "", "buckets/my.bucket.example.com", "through their bucket"},
+		{"https://console.cloud.google.com/storage/browser?project=my-proj", "my-proj", "", "lists the buckets"},
+		{"https://console.cloud.google.com/storage/browser/Bad_Bucket?project=my-proj", "my-proj", "", "not a bucket name"},
+
+		// BigQuery
+		{
+			"https://console.cloud.google.com/bigquery?project=my-proj&ws=!1m4!1m3!3m2!1sdata-proj!2sanalytics",
+			"data-proj", "projects/data-proj/datasets/analytics", "",
+		},
+		{
+			"https://console.cloud.google.com/bigquery?project=my-proj&ws=!1m5!1m4!4m3!1smy-proj!2sanalytics!3sevents_20240514",
+			"my-proj", "projects/my-proj/datasets/analytics", "",
+		},
+		{"https://console.cloud.google.com/bigquery?project=my-proj&p=my-proj&d=raw_events&page=dataset", "my-proj", "projects/my-proj/datasets/raw_events", ""},
+		{"https://console.cloud.google.com/bigquery?d=raw_events&p=my-proj&page=dataset&project=my-proj&t=sessions", "my-proj", "projects/my-proj/datasets/raw_events", ""},
+		{"https://console.cloud.google.com/bigquery?project=my-proj", "my-proj", "", "selects no BigQuery dataset"},
+		{"https://console.cloud.google.com/bigquery?project=my-proj&ws=!1m0", "my-proj", "", "selects no BigQuery dataset"},
+
+		// Secret Manager
+		{
+			"https://console.cloud.google.com/security/secret-manager/secret/db-password/versions?project=my-proj",
+			"my-proj", "projects/my-proj/secrets/db-password", "",
+		},
+		{"https://console.cloud.google.com/security/secret-manager/secret/API_KEY/overview?project=my-proj", "my-proj", "projects/my-proj/secrets/API_KEY", ""},
+		{"https://console.cloud.google.com/security/secret-manager?project=my-proj", "my-proj", "", "lists the secrets"},
+		{"https://console.cloud.google.com/security/secret-manager/secret/db-password/versions", "", "", "not a secret of a known project"},
+
+		// Pages granted on through their project
+		{"https://console.cloud.google.com/compute/instancesDetail/zones/europe-west1-b/instances/vm-1?project=my-proj", "my-proj", "", "Compute Engine"},
+		{"https://console.cloud.google.com/compute/instances?project=my-proj", "my-proj", "", "Compute Engine"},
+		{"https://console.cloud.google.com/sql/instances/db-1/overview?project=my-proj", "my-proj", "", "Cloud SQL"},
+		{"https://console.cloud.google.com/run/detail/europe-west1/api/metrics?project=my-proj", "my-proj", "", "Cloud Run"},
+		{"https://console.cloud.google.com/functions/details/europe-west1/resize?env=gen2&project=my-proj", "my-proj", "", "Cloud Functions"},
+		{"https://console.cloud.google.com/kubernetes/clusters/details/europe-west1/prod/details?project=my-proj", "my-proj", "", "GKE"},
+		{"https://console.cloud.google.com/cloudpubsub/topic/detail/orders?project=my-proj", "my-proj", "", "Pub/Sub"},
+		{"https://console.cloud.google.com/cloudpubsub/subscription/list?project=my-proj", "my-proj", "", "Pub/Sub"},
+		{
+			"https://console.cloud.google.com/logs/query;query=resource.type%3D%22gce_instance%22;cursorTimestamp=2024-05-14T09:30:00Z?project=my-proj",
+			"my-proj", "", "Cloud Logging",
+		},
+		{"https://console.cloud.google.com/iam-admin/iam?project=my-proj", "my-proj", "", "IAM settings"},
+		{"https://console.cloud.google.com/iam-admin/serviceaccounts/details/104829?project=my-proj", "my-proj", "", "IAM settings"},
+
+		// Other pages
+		{"https://console.cloud.google.com/home/dashboard?project=my-proj", "my-proj", "", "does not name a resource"},
+		{"https://console.cloud.google.com/welcome?authuser=0&project=my-proj", "my-proj", "", "does not name a resource"},
+		{"https://console.cloud.google.com/home/dashboard", "", "", "does not name a resource"},
+		{"https://console.cloud.google.com/apis/library/projects/my-proj/sqladmin.googleapis.com", "my-proj", "", "does not name a resource"},
+		{"  https://console.cloud.google.com/storage/browser/my-bucket?project=my-proj\n", "my-proj", "buckets/my-bucket", ""},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			got, err := Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Project != tc.project || got.Resource != tc.resource {
+				t.Errorf("Parse = %+v, want project %q and resource %q", got, tc.project, tc.resource)
+			}
+			if (tc.note == "") != (got.Note == "") || !strings.Contains(got.Note, tc.note) {
+				t.Errorf("note = %q, want %q", got.Note, tc.note)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, raw := range []string{
+		"https://example.com/storage/browser/my-bucket?project=my-proj",
+		"https://console.aws.amazon.com/s3/buckets/my-bucket",
+		"console.cloud.google.com/storage/browser/my-bucket?project=my-proj",
+		"https://console.cloud.google.com/storage/browser/my-bucket?project=My_Proj",
+		"https://console.cloud.google.com/home/dashboard?project=123456789012",
+		"https://console.cloud.google.com/%zz",
+		"",
+	} {
+		if got, err := Parse(raw); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", raw, got)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+// Package dashboard holds the data of gta tui: the temporary bindings of the
+// configured projects joined with the sessions recorded on this machine, and
+// the actions taken on them. It draws nothing, so that it can be driven by
+// any view, or by a fake Backend.
+package dashboard
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// Backend reads and changes the temporary access shown on the dashboard
+type Backend interface {
+	// Sessions returns the sessions recorded on this machine
+	Sessions() ([]state.Session, error)
+	// Bindings returns the temporary bindings in the policy of target, a
+	// project ID or resource name
+	Bindings(target string) ([]provider.TemporaryBinding, error)
+	// Extend moves the expiry of the binding of row by d
+	Extend(row Row, d time.Duration) error
+	// Revoke removes the binding of row
+	Revoke(row Row) error
+	// CleanExpired removes the expired temporary bindings of target
+	CleanExpired(target string) (int, error)
+}
+
+// Row is a temporary binding of one member, found in a policy, recorded in a
+// local session, or both
+type Row struct {
+	// Target is the project ID or resource name whose policy holds the binding
+	Target    string
+	Role      string
+	Member    string
+	BindingID string
+	Expiry    time.Time
+	// Remote is set when the binding was found in the policy of Target
+	Remote bool
+	// Unverified is set when the policy of Target could not be read
+	Unverified bool
+	// Session is the local session that granted the binding, nil for the
+	// bindings granted elsewhere
+	Session *state.Session
+	// SessionID is the session named in the binding description, or that of
+	// Session
+	SessionID string
+	// Condition holds the clauses of the condition of the binding other
+	// than its expiry, kept when it is extended
+	Condition string
+}
+
+// Key identifies the binding of a row across refreshes
+func (r Row) Key() string {
+	return r.Target + "|" + r.Role + "|" + r.Member + "|" + r.BindingID
+}
+
+// Expired reports whether the binding no longer grants access at now
+func (r Row) Expired(now time.Time) bool {
+	return !r.Expiry.After(now)
+}
+
+// Remaining returns the time left until the binding expires, 0 once expired
+func (r Row) Remaining(now time.Time) time.Duration {
+	if r.Expired(now) {
+		return 0
+	}
+	return r.Expiry.Sub(now)
+}
+
+// Fraction returns the share of its lifetime the binding has left at now,
+// from 1 when granted to 0 once expired. The lifetime of bindings outside
+// local sessions is unknown, so scale, the longest remaining time of the
+// dashboard, stands in for it.
+func (r Row) Fraction(now time.Time, scale time.Duration) float64 {
+	remaining := r.Remaining(now)
+	total := scale
+	if r.Session != nil && r.Expiry.After(r.Session.StartedAt) {
+		total = r.Expiry.Sub(r.Session.StartedAt)
+	}
+	if remaining <= 0 || total <= 0 {
+		return 0
+	}
+	if remaining >= total {
+		return 1
+	}
+	return float64(remaining) / float64(total)
+}
+
+// Status describes where the binding of a row stands at now
+func (r Row) Status(now time.Time) string {
+	switch {
+	case r.Expired(now):
+		return "expired"
+	case r.Unverified:
+		return "unverified"
+	case !r.Remote:
+		return "not found"
+	case r.Session == nil:
+		return "remote"
+	case r.Session.PendingRevocation != nil:
+		return "pending revocation"
+	case r.Session.Kept:
+		return "unattended"
+	default:
+		return fmt.Sprintf("attended (pid %d)", r.Session.PID)
+	}
+}
+
+// Model is the state of the dashboard
+type Model struct {
+	Backend Backend
+	// Projects are the projects whose policies are listed, along with the
+	// targets of the local sessions
+	Projects []string
+	// ExtendBy is how much Extend moves an expiry
+	ExtendBy time.Duration
+	// DryRun marks a Backend that only reports the changes it would make
+	DryRun bool
+
+	Rows []Row
+	// Selected is the index of the row actions apply to
+	Selected int
+	// Message describes the outcome of the last refresh or action
+	Message string
+	// Problems are the targets that failed to list at the last refresh
+	Problems []string
+	// Refreshed is when the rows were last read
+	Refreshed time.Time
+}
+
+// Refresh reads the rows again, keeping the selection on the same binding
+// when it is still there
+func (m *Model) Refresh(now time.Time) error {
+	selected, hadSelection := m.Current()
+
+	sessions, err := m.Backend.Sessions()
+	if err != nil {
+		return fmt.Errorf("failed to read the local sessions: %w", err)
+	}
+	targets := m.targets(sessions)
+
+	var rows []Row
+	seen := make(map[string]bool)
+	listed := make(map[string]bool, len(targets))
+	m.Problems = nil
+	for _, target := range targets {
+		bindings, err := m.Backend.Bindings(target)
+		if err != nil {
+			m.Problems = append(m.Problems, fmt.Sprintf("%s: %v", target, err))
+			continue
+		}
+		listed[target] = true
+		for _, b := range bindings {
+			row := Row{
+				Target:    policyTarget(b.Resource, target),
+				Role:      b.Role,
+				Member:    b.Member,
+				BindingID: b.BindingID,
+				Expiry:    b.Expiry,
+				Remote:    true,
+				SessionID: b.SessionID,
+				Condition: b.Condition,
+			}
+			row.Session = findSession(sessions, row)
+			if row.Session != nil {
+				row.SessionID = row.Session.ID
+			}
+			if !seen[row.Key()] {
+				seen[row.Key()] = true
+				rows = append(rows, row)
+			}
+		}
+	}
+	// Bindings of local sessions missing from the policies, left out once
+	// expired unless their policy could not be read
+	for i := range sessions {
+		s := &sessions[i]
+		if s.Provider != "" && s.Provider != provider.NameGCP {
+			continue
+		}
+		for _, b := range s.Bindings {
+			target := b.Target
+			if target == "" {
+				target = s.Target()
+			}
+			for _, member := range strings.Split(s.Member, ",") {
+				if member == "" {
+					continue
+				}
+				row := Row{
+					Target:     target,
+					Role:       b.Role,
+					Member:     principal(member),
+					BindingID:  b.BindingID,
+					Expiry:     b.Expiry,
+					Session:    s,
+					SessionID:  s.ID,
+					Unverified: !listed[target],
+				}
+				if !seen[row.Key()] && !(listed[target] && row.Expired(now)) {
+					seen[row.Key()] = true
+					rows = append(rows, row)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Expiry.Equal(rows[j].Expiry) {
+			return rows[i].Key() < rows[j].Key()
+		}
+		return rows[i].Expiry.Before(rows[j].Expiry)
+	})
+	m.Rows = rows
+	m.Refreshed = now
+	// A binding replaced by Extend is found by its role and member
+	m.Selected = 0
+	if hadSelection {
+		for i, row := range rows {
+			if row.Key() == selected.Key() {
+				m.Selected = i
+				break
+			}
+			if row.Target == selected.Target && row.Role == selected.Role && row.Member == selected.Member {
+				m.Selected = i
+			}
+		}
+	}
+	m.clamp()
+	return nil
+}
+
+// targets returns the projects of the dashboard followed by the other
+// targets of the local GCP sessions
+func (m *Model) targets(sessions []state.Session) []string {
+	seen := make(map[string]bool)
+	var targets []string
+	add := func(target string) {
+		if target != "" && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	for _, project := range m.Projects {
+		add(project)
+	}
+	for _, s := range sessions {
+		if s.Provider == "" || s.Provider == provider.NameGCP {
+			for _, target := range s.Targets() {
+				add(target)
+			}
+		}
+	}
+	return targets
+}
+
+// Current returns the selected row, ok false when there are no rows
+func (m *Model) Current() (Row, bool) {
+	if m.Selected < 0 || m.Selected >= len(m.Rows) {
+		return Row{}, false
+	}
+	return m.Rows[m.Selected], true
+}
+
+// Move moves the selection by delta rows, staying within the rows
+func (m *Model) Move(delta int) {
+	m.Selected += delta
+	m.clamp()
+}
+
+// clamp keeps the selection within the rows
+func (m *Model) clamp() {
+	if m.Selected >= len(m.Rows) {
+		m.Selected = len(m.Rows) - 1
+	}
+	if m.Selected < 0 {
+		m.Selected = 0
+	}
+}
+
+// Scale returns the longest remaining time of the rows at now, the lifetime
+// assumed by Fraction for bindings outside local sessions
+func (m *Model) Scale(now time.Time) time.Duration {
+	var scale time.Duration
+	for _, row := range m.Rows {
+		if remaining := row.Remaining(now); remaining > scale {
+			scale = remaining
+		}
+	}
+	return scale
+}
+
+// Extend moves the expiry of the selected binding by ExtendBy. Only the
+// bindings of sessions left to expire on this machine can be extended: an
+// attended session is revoked by the process that granted it, which would
+// not know of the new binding.
+func (m *Model) Extend(now time.Time) error {
+	row, ok := m.Current()
+	if !ok {
+		return fmt.Errorf("no binding selected")
+	}
+	switch {
+	case row.Session == nil:
+		return fmt.Errorf("%s was not granted from this machine; grant it again with gta grant", row.Role)
+	case row.Session.PendingRevocation != nil:
+		return fmt.Errorf("session %s is pending revocation; grant it again with gta grant", row.Session.ID)
+	case !row.Session.Kept && !row.Expired(now):
+		return fmt.Errorf("session %s is attended by pid %d; extend it there", row.Session.ID, row.Session.PID)
+	case !row.Remote:
+		return fmt.Errorf("%s was not found in the policy of %s", row.Role, row.Target)
+	case row.Expired(now):
+		return fmt.Errorf("%s already expired; grant it again with gta grant", row.Role)
+	}
+	if err := m.Backend.Extend(row, m.ExtendBy); err != nil {
+		return fmt.Errorf("failed to extend %s: %w", row.Role, err)
+	}
+	m.Message = m.outcome("Extended %s of %s by %s", row.Role, row.Member, duration.Format(m.ExtendBy))
+	return m.Refresh(now)
+}
+
+// Revoke removes the selected binding
+func (m *Model) Revoke(now time.Time) error {
+	row, ok := m.Current()
+	if !ok {
+		return fmt.Errorf("no binding selected")
+	}
+	if !row.Remote {
+		return fmt.Errorf("%s was not found in the policy of %s", row.Role, row.Target)
+	}
+	if err := m.Backend.Revoke(row); err != nil {
+		return fmt.Errorf("failed to revoke %s: %w", row.Role, err)
+	}
+	m.Message = m.outcome("Revoked %s from %s", row.Role, row.Member)
+	return m.Refresh(now)
+}
+
+// CleanExpired removes the expired temporary bindings of the projects of the
+// dashboard
+func (m *Model) CleanExpired(now time.Time) error {
+	var failed []string
+	removed := 0
+	for _, project := range m.Projects {
+		n, err := m.Backend.CleanExpired(project)
+		removed += n
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", project, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to clean %s", strings.Join(failed, "; "))
+	}
+	m.Message = m.outcome("Removed %d expired binding(s)", removed)
+	return m.Refresh(now)
+}
+
+// outcome formats the message of an action, marked in dry-run mode
+func (m *Model) outcome(format string, args ...interface{}) string {
+	message := fmt.Sprintf(format, args...)
+	if m.DryRun {
+		return "[DRY-RUN] " + message
+	}
+	return message
+}
+
+// findSession returns the local session holding the binding of row
+func findSession(sessions []state.Session, row Row) *state.Session {
+	for i := range sessions {
+		s := &sessions[i]
+		for _, b := range s.Bindings {
+			if b.BindingID == row.BindingID && b.Role == row.Role {
+				return s
+			}
+		}
+		if row.SessionID != "" && s.ID == row.SessionID {
+			return s
+		}
+	}
+	return nil
+}
+
+// policyTarget turns the resource of a listed binding, e.g.
+// projects/my-project, into the project ID or resource name it was read
+// from, fallback when empty
+func policyTarget(resource, fallback string) string {
+	if resource == "" {
+		return fallback
+	}
+	if project, ok := strings.CutPrefix(resource, "projects/"); ok && !strings.Contains(project, "/") {
+		return project
+	}
+	return resource
+}
+
+// principal returns the member of a session as a principal, sessions of a
+// single user recording the email alone
+func principal(member string) string {
+	if strings.Contains(member, ":") {
+		return member
+	}
+	return "user:" + member
+}
@@ -0,0 +1,376 @@
+package dashboard
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/state"
+)
+
+// now is the time of the tests
+var now = time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC)
+
+// fakeBackend serves sessions and bindings from memory, recording the
+// actions taken
+type fakeBackend struct {
+	sessions []state.Session
+	bindings map[string][]provider.TemporaryBinding
+	failing  map[string]error
+	extended []Row
+	revoked  []Row
+	cleaned  []string
+}
+
+func (b *fakeBackend) Sessions() ([]state.Session, error) {
+	return b.sessions, nil
+}
+
+func (b *fakeBackend) Bindings(target string) ([]provider.TemporaryBinding, error) {
+	if err := b.failing[target]; err != nil {
+		return nil, err
+	}
+	return b.bindings[target], nil
+}
+
+func (b *fakeBackend) Extend(row Row, d time.Duration) error {
+	b.extended = append(b.extended, row)
+	// The binding is replaced by one expiring later, in the policy and in
+	// its session
+	bindings := b.bindings[row.Target]
+	for i, binding := range bindings {
+		if binding.BindingID == row.BindingID {
+			bindings[i].BindingID += "-extended"
+			bindings[i].Expiry = binding.Expiry.Add(d)
+		}
+	}
+	for i := range b.sessions {
+		for j, binding := range b.sessions[i].Bindings {
+			if b.sessions[i].ID == row.SessionID && binding.BindingID == row.BindingID {
+				b.sessions[i].Bindings[j].BindingID += "-extended"
+				b.sessions[i].Bindings[j].Expiry = binding.Expiry.Add(d)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *fakeBackend) Revoke(row Row) error {
+	b.revoked = append(b.revoked, row)
+	var left []provider.TemporaryBinding
+	for _, binding := range b.bindings[row.Target] {
+		if binding.BindingID != row.BindingID || binding.Member != row.Member {
+			left = append(left, binding)
+		}
+	}
+	b.bindings[row.Target] = left
+	return nil
+}
+
+func (b *fakeBackend) CleanExpired(target string) (int, error) {
+	if err := b.failing[target]; err != nil {
+		return 0, err
+	}
+	b.cleaned = append(b.cleaned, target)
+	var left []provider.TemporaryBinding
+	for _, binding := range b.bindings[target] {
+		if binding.Expiry.After(now) {
+			left = append(left, binding)
+		}
+	}
+	removed := len(b.bindings[target]) - len(left)
+	b.bindings[target] = left
+	return removed, nil
+}
+
+// newBackend returns a backend with:
+//   - an attended session of alice on p, found in its policy,
+//   - an unattended session of alice on p, found in its policy,
+//   - a session of bob on a secret, whose binding is gone,
+//   - an expired session of alice on p, whose binding is gone,
+//   - a binding of carol on p granted elsewhere, and an expired one of dave,
+//   - a session of alice on q, whose policy fails to read
+func newBackend() *fakeBackend {
+	session := func(id, target, member string, kept bool, bindings ...state.Binding) state.Session {
+		s := state.Session{ID: id, Provider: provider.NameGCP, Project: "p", Member: member, PID: 42, StartedAt: now.Add(-time.Hour), Bindings: bindings, Kept: kept}
+		if target != "p" {
+			s.Resource = target
+		}
+		return s
+	}
+	binding := func(id, role string, expiry time.Time) state.Binding {
+		return state.Binding{Role: role, BindingID: id, Expiry: expiry}
+	}
+	return &fakeBackend{
+		sessions: []state.Session{
+			session("attended", "p", "alice@example.com", false, binding("b1", "roles/viewer", now.Add(time.Hour))),
+			session("kept", "p", "alice@example.com", true, binding("b2", "roles/browser", now.Add(30*time.Minute))),
+			session("gone", "projects/p/secrets/s", "bob@example.com", true, binding("b3", "roles/secretmanager.secretAccessor", now.Add(2*time.Hour))),
+			session("over", "p", "alice@example.com", true, binding("b4", "roles/logging.viewer", now.Add(-time.Minute))),
+			{ID: "other", Provider: provider.NameGCP, Project: "q", Member: "alice@example.com", PID: 7, StartedAt: now.Add(-time.Hour), Bindings: []state.Binding{binding("b7", "roles/viewer", now.Add(time.Hour))}},
+			// Sessions of other providers are not shown
+			{ID: "aws", Provider: "aws", Project: "123456789012", Member: "alice", Bindings: []state.Binding{binding("b8", "admin", now.Add(time.Hour))}},
+		},
+		bindings: map[string][]provider.TemporaryBinding{
+			"p": {
+				{Role: "roles/viewer", Member: "user:alice@example.com", BindingID: "b1", Expiry: now.Add(time.Hour), Resource: "projects/p"},
+				{Role: "roles/browser", Member: "user:alice@example.com", BindingID: "b2", Expiry: now.Add(30 * time.Minute), Resource: "projects/p"},
+				{Role: "roles/viewer", Member: "user:carol@example.com", BindingID: "b5", Expiry: now.Add(3 * time.Hour), Resource: "projects/p", SessionID: "elsewhere"},
+				{Role: "roles/viewer", Member: "user:dave@example.com", BindingID: "b6", Expiry: now.Add(-time.Hour), Resource: "projects/p"},
+			},
+		},
+		failing: map[string]error{"q": errors.New("permission denied")},
+	}
+}
+
+// describe returns the member, role, and status of the rows at now
+func describe(m *Model) []string {
+	var rows []string
+	for _, row := range m.Rows {
+		rows = append(rows, strings.TrimPrefix(row.Member, "user:")+" "+row.Role+" "+row.Status(now))
+	}
+	return rows
+}
+
+func TestRefresh(t *testing.T) {
+	backend := newBackend()
+	m := &Model{Backend: backend, Projects: []string{"p"}, ExtendBy: time.Hour}
+	if err := m.Refresh(now); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"dave@example.com roles/viewer expired",
+		"alice@example.com roles/browser unattended",
+		"alice@example.com roles/viewer attended (pid 42)",
+		"alice@example.com roles/viewer unverified",
+		"bob@example.com roles/secretmanager.secretAccessor not found",
+		"carol@example.com roles/viewer remote",
+	}
+	if got := describe(m); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rows\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if len(m.Problems) != 1 || !strings.HasPrefix(m.Problems[0], "q: permission denied") {
+		t.Errorf("problems %v", m.Problems)
+	}
+	if !m.Refreshed.Equal(now) {
+		t.Errorf("refreshed at %s", m.Refreshed)
+	}
+	// The rows of listed policies carry their target as read, and the rows
+	// of local sessions their ID
+	for _, row := range m.Rows {
+		if row.Remote && row.Target != "p" {
+			t.Errorf("target %q, want p", row.Target)
+		}
+		if row.Session != nil && row.SessionID != row.Session.ID {
+			t.Errorf("%s of %s in session %q, want %s", row.Role, row.Member, row.SessionID, row.Session.ID)
+		}
+	}
+
+	// The selection follows its binding
+	m.Selected = 5
+	backend.bindings["p"] = append(backend.bindings["p"][:3:3], provider.TemporaryBinding{Role: "roles/editor", Member: "user:erin@example.com", BindingID: "b9", Expiry: now.Add(-2 * time.Hour)})
+	if err := m.Refresh(now); err != nil {
+		t.Fatal(err)
+	}
+	if row, _ := m.Current(); row.Member != "user:carol@example.com" {
+		t.Errorf("selected %+v, want carol's binding", row)
+	}
+}
+
+func TestRowFraction(t *testing.T) {
+	session := &state.Session{StartedAt: now.Add(-time.Hour)}
+	for _, tc := range []struct {
+		name string
+		row  Row
+		want float64
+	}{
+		{"half of its session", Row{Expiry: now.Add(time.Hour), Session: session}, 0.5},
+		{"expired", Row{Expiry: now.Add(-time.Minute), Session: session}, 0},
+		{"a quarter of the scale", Row{Expiry: now.Add(time.Hour)}, 0.25},
+		{"past the scale", Row{Expiry: now.Add(8 * time.Hour)}, 1},
+	} {
+		if got := tc.row.Fraction(now, 4*time.Hour); got != tc.want {
+			t.Errorf("%s: Fraction = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := (Row{Expiry: now.Add(time.Hour)}).Fraction(now, 0); got != 0 {
+		t.Errorf("Fraction without a scale = %v", got)
+	}
+	if got := (Row{Expiry: now}).Remaining(now); got != 0 {
+		t.Errorf("Remaining at the expiry = %s", got)
+	}
+}
+
+func TestMove(t *testing.T) {
+	m := &Model{Backend: newBackend(), Projects: []string{"p"}}
+	if _, ok := m.Current(); ok {
+		t.Error("a row is selected before the refresh")
+	}
+	if err := m.Refresh(now); err != nil {
+		t.Fatal(err)
+	}
+	m.Move(100)
+	if m.Selected != len(m.Rows)-1 {
+		t.Errorf("selected %d past the last row", m.Selected)
+	}
+	m.Move(-100)
+	if m.Selected != 0 {
+		t.Errorf("selected %d before the first row", m.Selected)
+	}
+	if got := m.Scale(now); got != 3*time.Hour {
+		t.Errorf("Scale = %s, want the 3h left to carol", got)
+	}
+}
+
+// selectRow selects the row of member and role
+func selectRow(t *testing.T, m *Model, member, role string) {
+	t.Helper()
+	for i, row := range m.Rows {
+		if row.Member == "user:"+member && row.Role == role {
+			m.Selected = i
+			return
+		}
+	}
+	t.Fatalf("no row of %s for %s", member, role)
+}
+
+func TestExtend(t *testing.T) {
+	for _, tc := range []struct {
+		name, member, role string
+		wantErr            string
+	}{
+		{name: "unattended", member: "alice@example.com", role: "roles/browser"},
+		{name: "attended", member: "alice@example.com", role: "roles/viewer", wantErr: "attended by pid 42; extend it there"},
+		{name: "granted elsewhere", member: "carol@example.com", role: "roles/viewer", wantErr: "was not granted from this machine"},
+		{name: "not found", member: "bob@example.com", role: "roles/secretmanager.secretAccessor", wantErr: "was not found in the policy"},
+		{name: "expired", member: "dave@example.com", role: "roles/viewer", wantErr: "was not granted from this machine"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := newBackend()
+			m := &Model{Backend: backend, Projects: []string{"p"}, ExtendBy: time.Hour, DryRun: tc.name == "unattended"}
+			if err := m.Refresh(now); err != nil {
+				t.Fatal(err)
+			}
+			selectRow(t, m, tc.member, tc.role)
+			err := m.Extend(now)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Extend = %v, want %q", err, tc.wantErr)
+				}
+				if len(backend.extended) != 0 {
+					t.Errorf("extended %+v", backend.extended)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backend.extended) != 1 || backend.extended[0].BindingID != "b2" {
+				t.Errorf("extended %+v", backend.extended)
+			}
+			if m.Message != "[DRY-RUN] Extended roles/browser of user:alice@example.com by 1h" {
+				t.Errorf("message %q", m.Message)
+			}
+			// The replaced binding stays selected
+			if row, _ := m.Current(); row.BindingID != "b2-extended" || !row.Expiry.Equal(now.Add(90*time.Minute)) {
+				t.Errorf("selected %+v after extending", row)
+			}
+		})
+	}
+
+	// A pending revocation is retried rather than extended
+	backend := newBackend()
+	backend.sessions[1].PendingRevocation = &state.Revocation{Attempts: 1}
+	m := &Model{Backend: backend, Projects: []string{"p"}, ExtendBy: time.Hour}
+	if err := m.Refresh(now); err != nil {
+		t.Fatal(err)
+	}
+	selectRow(t, m, "alice@example.com", "roles/browser")
+	if err := m.Extend(now); err == nil || !strings.Contains(err.Error(), "pending revocation") {
+		t.Errorf("Extend of a pending revocation = %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	backend := newBackend()
+	m := &Model{Backend: backend, Projects: []string{"p"}}
+	if err := m.Refresh(now); err != nil {
+		t.Fatal(err)
+	}
+
+	selectRow(t, m, "bob@example.com", "roles/secretmanager.secretAccessor")
+	if err := m.Revoke(now); err == nil || !strings.Contains(err.Error(), "was not found in the policy") {
+		t.Errorf("Revoke of a binding not found = %v", err)
+	}
+
+	selectRow(t, m, "carol@example.com", "roles/viewer")
+	if err := m.Revoke(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.revoked) != 1 || backend.revoked[0].BindingID != "b5" {
+		t.Errorf("revoked %+v", backend.revoked)
+	}
+	if m.Message != "Revoked roles/viewer from user:carol@example.com" {
+		t.Errorf("message %q", m.Message)
+	}
+	for _, row := range m.Rows {
+		if row.Member == "user:carol@example.com" {
+			t.Errorf("revoked binding still shown: %+v", row)
+		}
+	}
+
+	m.Rows = nil
+	if err := m.Revoke(now); err == nil {
+		t.Error("Revoke without a selection succeeded")
+	}
+}
+
+func TestCleanExpired(t *testing.T) {
+	backend := newBackend()
+	m := &Model{Backend: backend, Projects: []string{"p"}}
+	if err := m.Refresh(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CleanExpired(now); err != nil {
+		t.Fatal(err)
+	}
+	if m.Message != "Removed 1 expired binding(s)" {
+		t.Errorf("message %q", m.Message)
+	}
+	// Only the projects are cleaned, not the targets of sessions
+	if strings.Join(backend.cleaned, ",") != "p" {
+		t.Errorf("cleaned %v", backend.cleaned)
+	}
+	for _, row := range m.Rows {
+		if row.Expired(now) {
+			t.Errorf("expired binding still shown: %+v", row)
+		}
+	}
+
+	m.Projects = []string{"p", "q"}
+	if err := m.CleanExpired(now); err == nil || !strings.Contains(err.Error(), "q: permission denied") {
+		t.Errorf("CleanExpired = %v, want the failure of q", err)
+	}
+}
+
+func TestPolicyTarget(t *testing.T) {
+	for _, tc := range [][3]string{
+		{"projects/p", "fallback", "p"},
+		{"", "fallback", "fallback"},
+		{"projects/p/secrets/s", "p", "projects/p/secrets/s"},
+		{"buckets/b", "p", "buckets/b"},
+	} {
+		if got := policyTarget(tc[0], tc[1]); got != tc[2] {
+			t.Errorf("policyTarget(%q, %q) = %q, want %q", tc[0], tc[1], got, tc[2])
+		}
+	}
+	if got := principal("alice@example.com"); got != "user:alice@example.com" {
+		t.Errorf("principal = %q", got)
+	}
+	if got := principal("group:team@example.com"); got != "group:team@example.com" {
+		t.Errorf("principal = %q", got)
+	}
+}
@@ -0,0 +1,133 @@
+// Package duration parses and formats durations in the units of time.Duration
+// along with days and weeks, such as 2d or 1d12h
+package duration
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Day is a day of 24 hours, ignoring daylight saving changes
+	Day = 24 * time.Hour
+	// Week is 7 days
+	Week = 7 * Day
+)
+
+// Examples describes the accepted syntax in error messages
+const Examples = "e.g. 30m, 1h30m, 2d, 1d12h, or 1w"
+
+// Parse parses a duration such as 90m, 1h30m, 2d, or 1w2d12h: a sequence of
+// decimal numbers, each with a unit of ns, us, ms, s, m, h, d, or w
+func Parse(s string) (time.Duration, error) {
+	value := strings.TrimSpace(s)
+	invalid := fmt.Errorf("invalid duration %q (expected %s)", s, Examples)
+	if value == "" {
+		return 0, invalid
+	}
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(value, "+-")
+	if value == "0" {
+		return 0, nil
+	}
+
+	// Days and weeks are summed here, the other units by time.ParseDuration
+	var days float64
+	var rest strings.Builder
+	for value != "" {
+		number := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if number <= 0 {
+			return 0, invalid
+		}
+		unit := number + strings.IndexFunc(value[number:], func(r rune) bool { return (r >= '0' && r <= '9') || r == '.' })
+		if unit < number {
+			unit = len(value)
+		}
+		switch value[number:unit] {
+		case "d", "w":
+			n, err := strconv.ParseFloat(value[:number], 64)
+			if err != nil {
+				return 0, invalid
+			}
+			if value[number:unit] == "w" {
+				n *= 7
+			}
+			days += n
+		default:
+			rest.WriteString(value[:unit])
+		}
+		value = value[unit:]
+	}
+
+	var d time.Duration
+	if rest.Len() > 0 {
+		parsed, err := time.ParseDuration(rest.String())
+		if err != nil {
+			return 0, invalid
+		}
+		d = parsed
+	}
+	if float64(d)+days*float64(Day) > math.MaxInt64 {
+		return 0, fmt.Errorf("duration %q is too long", s)
+	}
+	d += time.Duration(days * float64(Day))
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+// Format formats d in the largest units first, leaving out those that are
+// zero, e.g. 1d12h rather than 36h0m0s. Durations of a second or more are
+// rounded to the second.
+func Format(d time.Duration) string {
+	if d < 0 {
+		return "-" + Format(-d)
+	}
+	if d < time.Second {
+		return d.String()
+	}
+	d = d.Round(time.Second)
+	var b strings.Builder
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{{Week, "w"}, {Day, "d"}, {time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}} {
+		if n := d / unit.size; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, unit.name)
+			d -= n * unit.size
+		}
+	}
+	return b.String()
+}
+
+// Value is a flag value holding a duration read with Parse. It implements
+// the Value interface of github.com/spf13/pflag.
+type Value time.Duration
+
+// String implements pflag.Value, with 0 for no duration so that a zero
+// default is not shown in help
+func (v *Value) String() string {
+	if *v == 0 {
+		return "0"
+	}
+	return Format(time.Duration(*v))
+}
+
+// Set implements pflag.Value
+func (v *Value) Set(s string) error {
+	d, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*v = Value(d)
+	return nil
+}
+
+// Type implements pflag.Value
+func (v *Value) Type() string {
+	return "duration"
+}
@@ -0,0 +1,129 @@
+// Package encryption encrypts the local files of gta at rest with a key kept
+// in the OS keychain or derived from a passphrase
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const (
+	// KeySize is the size of the AES-256 key
+	KeySize = 32
+	// fileMagic starts encrypted files
+	fileMagic = "GTAENC1\n"
+	// linePrefix starts encrypted lines of line-oriented files
+	linePrefix = "gtaenc1:"
+)
+
+// ErrNoKey is returned by a keyring holding no key yet
+var ErrNoKey = errors.New("no encryption key")
+
+// ErrDecrypt is returned for data that cannot be decrypted with the key,
+// which was lost or replaced, or for encrypted data read without a key
+var ErrDecrypt = errors.New("cannot decrypt with the current key")
+
+// Cipher encrypts and decrypts data with AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New creates a cipher for key
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid encryption key of %d bytes, expected %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// NewKey returns a random key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %v", err)
+	}
+	return key, nil
+}
+
+// Encrypted reports whether data is the content of an encrypted file
+func Encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(fileMagic))
+}
+
+// EncryptedLine reports whether line is an encrypted line
+func EncryptedLine(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(linePrefix))
+}
+
+// seal encrypts plaintext into nonce and ciphertext
+func (c *Cipher) seal(plaintext []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("failed to generate nonce: %v", err))
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, []byte(fileMagic))
+}
+
+// open decrypts the output of seal
+func (c *Cipher) open(sealed []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:size], sealed[size:], []byte(fileMagic))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Encrypt returns the content of an encrypted file holding plaintext
+func (c *Cipher) Encrypt(plaintext []byte) []byte {
+	return append([]byte(fileMagic), c.seal(plaintext)...)
+}
+
+// Decrypt returns the plaintext of data, which is returned as is when it is
+// not encrypted so that files written before encryption was enabled are read
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if !Encrypted(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("%w: the file is encrypted but encryption is not enabled", ErrDecrypt)
+	}
+	return c.open(data[len(fileMagic):])
+}
+
+// EncryptLine returns an encrypted line holding plaintext, without newline
+func (c *Cipher) EncryptLine(plaintext []byte) []byte {
+	return []byte(linePrefix + base64.RawStdEncoding.EncodeToString(c.seal(plaintext)))
+}
+
+// DecryptLine returns the plaintext of an encrypted line, or line as is
+// when it is not encrypted
+func (c *Cipher) DecryptLine(line []byte) ([]byte, error) {
+	if !EncryptedLine(line) {
+		return line, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("%w: the line is encrypted but encryption is not enabled", ErrDecrypt)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(string(line[len(linePrefix):]))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return c.open(sealed)
+}
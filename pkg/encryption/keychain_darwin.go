@@ -0,0 +1,48 @@
+package encryption
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychain keeps the key in the macOS login keychain through security(1)
+type keychain struct{}
+
+func newKeychain(string) Keyring {
+	return keychain{}
+}
+
+// Name implements Keyring
+func (keychain) Name() string {
+	return "macOS keychain"
+}
+
+// Get implements Keyring
+func (keychain) Get() ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "could not be found") {
+			return nil, ErrNoKey
+		}
+		return nil, fmt.Errorf("failed to read the encryption key from the keychain: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return decodeKey(string(out))
+}
+
+// Create implements Keyring
+func (keychain) Create() ([]byte, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("security", "add-generic-password", "-U", "-s", keychainService, "-a", keychainAccount, "-w", encodeKey(key)).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the encryption key in the keychain: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return key, nil
+}
@@ -0,0 +1,55 @@
+//go:build !darwin && !windows
+
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychain keeps the key in the Secret Service, e.g. GNOME Keyring or
+// KWallet, through secret-tool(1)
+type keychain struct{}
+
+func newKeychain(string) Keyring {
+	return keychain{}
+}
+
+// Name implements Keyring
+func (keychain) Name() string {
+	return "Secret Service"
+}
+
+// Get implements Keyring
+func (keychain) Get() ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && len(out) == 0 && stderr.Len() == 0:
+		// secret-tool exits with 1 and prints nothing when there is no secret
+		return nil, ErrNoKey
+	case err != nil:
+		return nil, fmt.Errorf("failed to read the encryption key from the Secret Service: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return decodeKey(string(out))
+}
+
+// Create implements Keyring
+func (keychain) Create() ([]byte, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("secret-tool", "store", "--label=gta encryption key", "service", keychainService, "account", keychainAccount)
+	cmd.Stdin = strings.NewReader(encodeKey(key))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to store the encryption key in the Secret Service: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return key, nil
+}
@@ -0,0 +1,87 @@
+//go:build windows
+
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/yckao/gta/pkg/fileutil"
+	"golang.org/x/sys/windows"
+)
+
+// dpapiFileName holds the key protected by DPAPI in the data directory
+const dpapiFileName = "encryption.key"
+
+// keychain keeps the key in a file protected by DPAPI, which only the
+// current Windows user can decrypt
+type keychain struct {
+	path string
+}
+
+func newKeychain(dir string) Keyring {
+	return keychain{path: filepath.Join(dir, dpapiFileName)}
+}
+
+// Name implements Keyring
+func (keychain) Name() string {
+	return "DPAPI"
+}
+
+// Get implements Keyring
+func (k keychain) Get() ([]byte, error) {
+	protected, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the encryption key: %v", err)
+	}
+	key, err := dpapi(protected, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unprotect the encryption key: %v", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid encryption key in %s", k.path)
+	}
+	return key, nil
+}
+
+// Create implements Keyring
+func (k keychain) Create() ([]byte, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	protected, err := dpapi(key, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to protect the encryption key: %v", err)
+	}
+	if err := fileutil.WriteAtomic(k.path, protected); err != nil {
+		return nil, fmt.Errorf("failed to write the encryption key: %v", err)
+	}
+	return key, nil
+}
+
+// dpapi protects or unprotects data for the current user
+func dpapi(data []byte, protect bool) ([]byte, error) {
+	in := windows.DataBlob{Size: uint32(len(data))}
+	if len(data) > 0 {
+		in.Data = &data[0]
+	}
+	var out windows.DataBlob
+	var err error
+	if protect {
+		err = windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	} else {
+		err = windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
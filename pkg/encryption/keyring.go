@@ -0,0 +1,113 @@
+package encryption
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yckao/gta/pkg/fileutil"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// keychainService and keychainAccount identify the key in the OS keychain
+	keychainService = "gta"
+	keychainAccount = "encryption-key"
+	// saltFileName holds the salt of the passphrase key in the data directory
+	saltFileName = "encryption.salt"
+)
+
+// Keyring keeps the encryption key
+type Keyring interface {
+	// Name describes where the key is kept
+	Name() string
+	// Get returns the key, or ErrNoKey when there is none yet
+	Get() ([]byte, error)
+	// Create creates and stores a new key
+	Create() ([]byte, error)
+}
+
+// LoadKey returns the key of keyring, creating it when there is none yet
+func LoadKey(keyring Keyring) ([]byte, error) {
+	key, err := keyring.Get()
+	if errors.Is(err, ErrNoKey) {
+		return keyring.Create()
+	}
+	return key, err
+}
+
+// Keychain returns the keyring of the OS: the macOS keychain, the Secret
+// Service on Linux, or a DPAPI-protected file in dir on Windows
+func Keychain(dir string) Keyring {
+	return newKeychain(dir)
+}
+
+// encodeKey encodes a key for keychains storing text
+func encodeKey(key []byte) string {
+	return hex.EncodeToString(key)
+}
+
+// decodeKey decodes a key stored by encodeKey
+func decodeKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("invalid encryption key in keychain")
+	}
+	return key, nil
+}
+
+// PassphraseKeyring derives the key from a passphrase and a random salt kept
+// in the data directory
+type PassphraseKeyring struct {
+	Dir string
+	// Passphrase returns the passphrase, e.g. by prompting for it
+	Passphrase func() (string, error)
+}
+
+// Name implements Keyring
+func (k *PassphraseKeyring) Name() string {
+	return "passphrase"
+}
+
+// Get implements Keyring
+func (k *PassphraseKeyring) Get() ([]byte, error) {
+	salt, err := os.ReadFile(filepath.Join(k.Dir, saltFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption salt: %v", err)
+	}
+	return k.derive(salt)
+}
+
+// Create implements Keyring
+func (k *PassphraseKeyring) Create() ([]byte, error) {
+	salt, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileutil.WriteAtomic(filepath.Join(k.Dir, saltFileName), salt); err != nil {
+		return nil, fmt.Errorf("failed to write encryption salt: %v", err)
+	}
+	return key, nil
+}
+
+// derive derives the key from the passphrase and salt
+func (k *PassphraseKeyring) derive(salt []byte) ([]byte, error) {
+	passphrase, err := k.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, KeySize)
+}
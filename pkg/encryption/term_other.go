@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !windows
+
+package encryption
+
+import "fmt"
+
+// ReadPassphrase is not supported on this platform
+func ReadPassphrase(prompt string) (string, error) {
+	return "", fmt.Errorf("cannot prompt for a passphrase on this platform")
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package encryption
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// ReadPassphrase prompts for a passphrase on the console without echoing it
+func ReadPassphrase(prompt string) (string, error) {
+	handle := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return "", fmt.Errorf("no console to read the passphrase from: %v", err)
+	}
+	if err := windows.SetConsoleMode(handle, mode&^windows.ENABLE_ECHO_INPUT); err != nil {
+		return "", fmt.Errorf("failed to turn off echo: %v", err)
+	}
+	defer windows.SetConsoleMode(handle, mode)
+
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
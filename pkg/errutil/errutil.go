@@ -0,0 +1,117 @@
+// Package errutil attaches stack traces to errors and formats error chains
+package errutil
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// maxStackDepth bounds the number of frames captured
+const maxStackDepth = 32
+
+// stackError annotates an error with the stack of the call that returned it
+type stackError struct {
+	err   error
+	stack []uintptr
+}
+
+func (e *stackError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stackError) Unwrap() error {
+	return e.err
+}
+
+// WithStack captures the caller's stack into err at debug level, where it can
+// be printed with StackTrace; err is returned as is otherwise
+func WithStack(err error) error {
+	if err == nil || !logger.Enabled(logger.LevelDebug) {
+		return err
+	}
+	var existing *stackError
+	if errors.As(err, &existing) {
+		return err
+	}
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(2, pcs)
+	return &stackError{err: err, stack: pcs[:n]}
+}
+
+// StackTrace formats the stack captured by WithStack anywhere in the chain of
+// err, or returns an empty string when none was captured
+func StackTrace(err error) string {
+	var withStack *stackError
+	if !errors.As(err, &withStack) {
+		return ""
+	}
+	var b strings.Builder
+	frames := runtime.CallersFrames(withStack.stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Format renders the chain of err on multiple lines, one cause per line and
+// indented below the error wrapping it
+func Format(err error) string {
+	var b strings.Builder
+	writeChain(&b, err, 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// writeChain writes the message err adds to its causes, then its causes
+func writeChain(b *strings.Builder, err error, depth int) {
+	causes := unwrap(err)
+	msg := err.Error()
+	switch {
+	case len(causes) == 1:
+		// Keep only the context added by this error, e.g. "getIamPolicy"
+		if inner := causes[0].Error(); strings.HasSuffix(msg, inner) {
+			msg = strings.TrimSuffix(strings.TrimSuffix(msg, inner), ": ")
+		}
+	case len(causes) > 1 && containsAll(msg, causes):
+		// Aggregates such as errors.Join add nothing but their causes
+		msg = ""
+	}
+
+	if msg != "" {
+		fmt.Fprintf(b, "%s%s\n", strings.Repeat("  ", depth), msg)
+		depth++
+	}
+	for _, cause := range causes {
+		writeChain(b, cause, depth)
+	}
+}
+
+// unwrap returns the errors wrapped by err
+func unwrap(err error) []error {
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		return e.Unwrap()
+	case interface{ Unwrap() error }:
+		if cause := e.Unwrap(); cause != nil {
+			return []error{cause}
+		}
+	}
+	return nil
+}
+
+// containsAll reports whether msg includes the message of every error
+func containsAll(msg string, errs []error) bool {
+	for _, err := range errs {
+		if !strings.Contains(msg, err.Error()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package errutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/yckao/gta/pkg/logger"
+)
+
+func TestWithStack(t *testing.T) {
+	defer logger.SetLevel(logger.LevelInfo)
+
+	logger.SetLevel(logger.LevelInfo)
+	if err := WithStack(fs.ErrNotExist); err != fs.ErrNotExist {
+		t.Errorf("WithStack wrapped an error below debug level")
+	}
+	if WithStack(nil) != nil {
+		t.Errorf("WithStack(nil) != nil")
+	}
+
+	logger.SetLevel(logger.LevelDebug)
+	err := fmt.Errorf("getIamPolicy: %w", WithStack(fs.ErrNotExist))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stack hides the cause")
+	}
+	if err.Error() != "getIamPolicy: file does not exist" {
+		t.Errorf("message = %q", err.Error())
+	}
+	if trace := StackTrace(err); !strings.Contains(trace, "errutil.TestWithStack") {
+		t.Errorf("trace does not start at the caller:\n%s", trace)
+	}
+	// A stack is captured once, where the error first is
+	if again := WithStack(err); again != err {
+		t.Errorf("WithStack captured a second stack")
+	}
+	if StackTrace(fs.ErrNotExist) != "" {
+		t.Errorf("trace of an error without a stack")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cause := errors.New("googleapi: Error 409: conflict")
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"single", cause, "googleapi: Error 409: conflict"},
+		{
+			"wrapped",
+			fmt.Errorf("grant roles/viewer on projects/p: %w", fmt.Errorf("setIamPolicy: %w", cause)),
+			"grant roles/viewer on projects/p\n  setIamPolicy\n    googleapi: Error 409: conflict",
+		},
+		{
+			"joined",
+			errors.Join(fmt.Errorf("getIamPolicy: %w", cause), errors.New("timeout")),
+			"getIamPolicy\n  googleapi: Error 409: conflict\ntimeout",
+		},
+		{
+			"context not ending in the cause",
+			fmt.Errorf("%w (after 3 attempts)", cause),
+			"googleapi: Error 409: conflict (after 3 attempts)\n  googleapi: Error 409: conflict",
+		},
+	} {
+		if got := Format(tc.err); got != tc.want {
+			t.Errorf("%s: Format =\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
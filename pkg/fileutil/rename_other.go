@@ -0,0 +1,10 @@
+//go:build !windows
+
+package fileutil
+
+import "os"
+
+// rename replaces to with from
+func rename(from, to string) error {
+	return os.Rename(from, to)
+}
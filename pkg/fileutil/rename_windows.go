@@ -0,0 +1,32 @@
+//go:build windows
+
+package fileutil
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// renameAttempts is how many times rename tries to replace a file
+const renameAttempts = 10
+
+// renameDelay is how long rename waits between attempts
+const renameDelay = 50 * time.Millisecond
+
+// rename replaces to with from, retrying briefly while another process,
+// such as a virus scanner or another gta reading the file, holds to open:
+// Windows refuses to replace an open file
+func rename(from, to string) error {
+	var err error
+	for i := 0; i < renameAttempts; i++ {
+		err = os.Rename(from, to)
+		if !errors.Is(err, windows.ERROR_ACCESS_DENIED) && !errors.Is(err, windows.ERROR_SHARING_VIOLATION) {
+			return err
+		}
+		time.Sleep(renameDelay)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// Package history reconstructs past grants from the audit log so that they
+// can be issued again
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+)
+
+// Grant is a grant session as recorded in the audit log
+type Grant struct {
+	SessionID string
+	Time      time.Time
+	Profile   string
+	Project   string
+	// Resource is the resource granted on within Project, empty for the
+	// project itself
+	Resource string
+	Roles    []string
+	// Members are the principals as IAM policies spell them, e.g. user:alice@example.com
+	Members []string
+	Reason  string
+	// TTL is the requested TTL, as far as the recorded expiry tells
+	TTL time.Duration
+}
+
+// Grants returns the grants made with profile that can be issued again, most
+// recent first. Approved requests and break-glass grants are left out as a
+// plain grant cannot reproduce them, as are roles that failed to be granted.
+func Grants(events []audit.Event, profile string) []Grant {
+	bySession := make(map[string]*Grant)
+	var grants []*Grant
+	for _, event := range events {
+		if event.Action != audit.ActionGrant || event.Error != "" || event.SessionID == "" ||
+			event.RequestID != "" || event.BreakGlass || event.Profile != profile {
+			continue
+		}
+		grant, ok := bySession[event.SessionID]
+		if !ok {
+			grant = &Grant{
+				SessionID: event.SessionID,
+				Time:      event.Time,
+				Profile:   event.Profile,
+				Project:   event.Project,
+				Resource:  event.Resource,
+				Reason:    event.Reason,
+			}
+			if !event.Expiry.IsZero() {
+				grant.TTL = event.Expiry.Sub(event.Time).Round(time.Minute)
+			}
+			bySession[event.SessionID] = grant
+			grants = append(grants, grant)
+		}
+		grant.Roles = appendNew(grant.Roles, event.Role)
+		grant.Members = appendNew(grant.Members, event.Member)
+	}
+
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].Time.After(grants[j].Time)
+	})
+	result := make([]Grant, len(grants))
+	for i, grant := range grants {
+		result[i] = *grant
+	}
+	return result
+}
+
+// appendNew appends value to values unless it is already there
+func appendNew(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,69 @@
+package httpclient
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// RequestReasonHeader carries the justification of an API call, which Google
+// records in the Cloud Audit Logs entry of the call
+const RequestReasonHeader = "X-Goog-Request-Reason"
+
+// MaxRequestReasonLength bounds the request reason sent, in bytes
+const MaxRequestReasonLength = 256
+
+// HeaderTransport is an http.RoundTripper identifying gta on every request:
+// its user agent goes first in User-Agent, and the reason of the session,
+// when there is one, in X-Goog-Request-Reason
+type HeaderTransport struct {
+	Base      http.RoundTripper
+	UserAgent string
+	Reason    string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	reason := RequestReason(t.Reason)
+	if t.UserAgent == "" && reason == "" {
+		return base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	if t.UserAgent != "" {
+		if ua := req.Header.Get("User-Agent"); ua != "" {
+			req.Header.Set("User-Agent", t.UserAgent+" "+ua)
+		} else {
+			req.Header.Set("User-Agent", t.UserAgent)
+		}
+	}
+	if reason != "" {
+		req.Header.Set(RequestReasonHeader, reason)
+	}
+	return base.RoundTrip(req)
+}
+
+// RequestReason returns reason as sent in X-Goog-Request-Reason: on one line
+// of printable ASCII, with other characters replaced by spaces or question
+// marks, and truncated to MaxRequestReasonLength
+func RequestReason(reason string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(reason) {
+		switch {
+		case r == utf8.RuneError, r > '~':
+			b.WriteByte('?')
+		case r < ' ':
+			b.WriteByte(' ')
+		default:
+			b.WriteRune(r)
+		}
+		if b.Len() >= MaxRequestReasonLength {
+			break
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,82 @@
+package httpclient
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// recorder is a round-tripper recording the requests it is sent
+type recorder struct {
+	requests []*http.Request
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.requests = append(r.requests, req)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+func TestHeaderTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		userAgent string
+		reason    string
+		// header is the User-Agent of the request before the transport
+		header        string
+		wantUserAgent string
+		wantReason    string
+	}{
+		{name: "both", userAgent: "gta/v1.2.3", reason: "INC-42", wantUserAgent: "gta/v1.2.3", wantReason: "INC-42"},
+		{name: "ahead of the client's", userAgent: "gta/v1.2.3", header: "google-api-go-client/0.5", wantUserAgent: "gta/v1.2.3 google-api-go-client/0.5"},
+		{name: "reason only", reason: "deploy", header: "Go-http-client/1.1", wantUserAgent: "Go-http-client/1.1", wantReason: "deploy"},
+		{name: "neither", header: "Go-http-client/1.1", wantUserAgent: "Go-http-client/1.1"},
+		{name: "blank reason", userAgent: "gta/dev", reason: " \n\t", wantUserAgent: "gta/dev"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{}
+			transport := &HeaderTransport{Base: rec, UserAgent: tc.userAgent, Reason: tc.reason}
+			req, _ := http.NewRequest(http.MethodGet, "https://cloudresourcemanager.googleapis.com/v1/projects/p", nil)
+			if tc.header != "" {
+				req.Header.Set("User-Agent", tc.header)
+			}
+			if _, err := transport.RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+			sent := rec.requests[0].Header
+			if got := sent.Get("User-Agent"); got != tc.wantUserAgent {
+				t.Errorf("User-Agent = %q, want %q", got, tc.wantUserAgent)
+			}
+			if got, ok := sent[RequestReasonHeader]; strings.Join(got, "") != tc.wantReason || ok != (tc.wantReason != "") {
+				t.Errorf("%s = %q, want %q", RequestReasonHeader, got, tc.wantReason)
+			}
+			// The request given is left as it was
+			if got := req.Header.Get("User-Agent"); got != tc.header || req.Header.Get(RequestReasonHeader) != "" {
+				t.Errorf("request modified: %v", req.Header)
+			}
+		})
+	}
+}
+
+func TestRequestReason(t *testing.T) {
+	for reason, want := range map[string]string{
+		"":                            "",
+		"INC-42: restore the backups": "INC-42: restore the backups",
+		"  padded  ":                  "padded",
+		"two\nlines\r\tand a tab":     "two lines  and a tab",
+		"café ✓":                      "caf? ?",
+		"broken \xff byte":            "broken ? byte",
+	} {
+		if got := RequestReason(reason); got != want {
+			t.Errorf("RequestReason(%q) = %q, want %q", reason, got, want)
+		}
+	}
+
+	long := RequestReason(strings.Repeat("a", 2*MaxRequestReasonLength))
+	if len(long) != MaxRequestReasonLength {
+		t.Errorf("%d bytes, want %d", len(long), MaxRequestReasonLength)
+	}
+	long = RequestReason(strings.Repeat("é", 2*MaxRequestReasonLength))
+	if len(long) != MaxRequestReasonLength {
+		t.Errorf("%d bytes of replaced characters, want %d", len(long), MaxRequestReasonLength)
+	}
+}
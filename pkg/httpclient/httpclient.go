@@ -0,0 +1,104 @@
+// Package httpclient builds the HTTP transport used for Google API calls from
+// network settings such as a proxy, a private CA, and client certificates
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Config configures the transport. The zero value is equivalent to
+// http.DefaultTransport, which honors HTTPS_PROXY and NO_PROXY.
+type Config struct {
+	// Proxy is the proxy URL, overriding HTTPS_PROXY
+	Proxy string
+	// CAFile is a PEM bundle of CAs trusted in addition to the system ones,
+	// e.g. the CA of a TLS-inspecting proxy
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key presented to
+	// servers requiring mutual TLS
+	CertFile string
+	KeyFile  string
+	// ConnectTimeout bounds establishing a connection, including the TLS handshake
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds waiting for the response headers of a request
+	ReadTimeout time.Duration
+}
+
+// IsZero reports whether c leaves the default transport unchanged
+func (c Config) IsZero() bool {
+	return c == Config{}
+}
+
+// NewTransport creates a transport from c, failing on any setting that cannot
+// be applied, such as a missing CA file, so that misconfiguration is reported
+// before the first API call
+func NewTransport(c Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", c.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if c.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   c.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = c.ConnectTimeout
+	}
+	if c.ReadTimeout > 0 {
+		transport.ResponseHeaderTimeout = c.ReadTimeout
+	}
+	return transport, nil
+}
+
+// tlsConfig loads the CA bundle and client certificate
+func (c Config) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate found in CA file %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
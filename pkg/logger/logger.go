@@ -6,7 +6,10 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 )
 
 type Level = slog.Level
@@ -25,90 +28,261 @@ const (
 	FormatJSON  Format = "json"
 )
 
+// SessionKey is the attribute holding the session ID, shown as a short tag
+// in plain output
+const SessionKey = "session_id"
+
+// sessionTagLength is the number of session ID characters shown in plain output
+const sessionTagLength = 8
+
+// Masked replaces the values hidden with Mask
+const Masked = "***"
+
 var (
+	// defaultLogger holds the handler for the current level and format
 	defaultLogger *slog.Logger
+	// std is the Logger used by the package-level functions
+	std           = &Logger{}
 	currentLevel  = LevelInfo
+	currentFormat = FormatPlain
 	osExit        = os.Exit // For testing
+	// masked are the values hidden with Mask, paired with their replacement
+	masked []string
+	// redactor hides the masked values, nil when there are none
+	redactor *strings.Replacer
 )
 
+// output receives the records, stderr unless set with SetOutput
+var output io.Writer = os.Stderr
+
 func init() {
 	SetFormat(FormatPlain)
 	SetLevel(LevelInfo)
 }
 
-// SetLevel sets the current logging level
+// SetLevel sets the current logging level. At debug level records include
+// the source location of the log call.
 func SetLevel(level Level) {
 	currentLevel = level
+	defaultLogger = slog.New(newHandler(currentFormat))
+}
+
+// SetFormat sets the output format
+func SetFormat(format Format) error {
+	if format != FormatJSON && format != FormatPlain {
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+	currentFormat = format
+	defaultLogger = slog.New(newHandler(format))
+	return nil
+}
+
+// CurrentFormat returns the output format
+func CurrentFormat() Format {
+	return currentFormat
+}
+
+// SetOutput sends the records logged afterwards to w, e.g. to keep them from
+// drawing over a full-screen view
+func SetOutput(w io.Writer) {
+	output = w
+	defaultLogger = slog.New(newHandler(currentFormat))
+}
+
+// newHandler creates the handler for format at the current level
+func newHandler(format Format) slog.Handler {
 	opts := &slog.HandlerOptions{
-		Level: level,
+		Level:     currentLevel,
+		AddSource: currentLevel <= LevelDebug,
+	}
+	if format == FormatJSON {
+		return slog.NewJSONHandler(output, opts)
 	}
+	return newPlainHandler(output, opts)
+}
 
-	var handler slog.Handler
-	if defaultLogger != nil {
-		// Preserve existing format
-		if _, ok := defaultLogger.Handler().(*slog.JSONHandler); ok {
-			handler = slog.NewJSONHandler(os.Stderr, opts)
-		} else {
-			handler = newPlainHandler(os.Stderr, opts)
+// Mask hides values, e.g. emails, in every record logged afterwards. It is
+// meant to be called before logging concurrently.
+func Mask(values ...string) {
+	for _, value := range values {
+		if value == "" || value == Masked {
+			continue
 		}
-	} else {
-		handler = newPlainHandler(os.Stderr, opts)
+		masked = append(masked, value, Masked)
+	}
+	if len(masked) > 0 {
+		redactor = strings.NewReplacer(masked...)
 	}
+}
 
-	defaultLogger = slog.New(handler)
+// Redact returns s with the masked values hidden
+func Redact(s string) string {
+	if redactor == nil {
+		return s
+	}
+	return redactor.Replace(s)
 }
 
-// SetFormat sets the output format
-func SetFormat(format Format) error {
-	opts := &slog.HandlerOptions{
-		Level: currentLevel,
+// redactAttrs hides the masked values in the string attributes of attrs
+func redactAttrs(attrs []slog.Attr) []slog.Attr {
+	if redactor == nil || len(attrs) == 0 {
+		return attrs
 	}
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		if attr.Value.Kind() == slog.KindString {
+			attr.Value = slog.StringValue(redactor.Replace(attr.Value.String()))
+		}
+		redacted[i] = attr
+	}
+	return redacted
+}
 
-	var handler slog.Handler
-	switch format {
-	case FormatJSON:
-		handler = slog.NewJSONHandler(os.Stderr, opts)
-	case FormatPlain:
-		handler = newPlainHandler(os.Stderr, opts)
-	default:
-		return fmt.Errorf("unsupported format: %s", format)
+// Enabled reports whether messages at the given level are currently logged
+func Enabled(level Level) bool {
+	return level >= currentLevel
+}
+
+// Logger logs with a fixed set of attributes, such as the fields of a session.
+// It follows the level and format set for the package.
+type Logger struct {
+	attrs []slog.Attr
+}
+
+// Default returns the Logger used by the package-level functions
+func Default() *Logger {
+	return std
+}
+
+// SetDefault makes the package-level functions log through l, e.g. to attach
+// session fields to every record of a command
+func SetDefault(l *Logger) {
+	std = l
+}
+
+// With returns a Logger adding attrs to the records of the default Logger
+func With(attrs ...slog.Attr) *Logger {
+	return std.With(attrs...)
+}
+
+// With returns a Logger adding attrs to the records of l, replacing
+// attributes of l with the same key
+func (l *Logger) With(attrs ...slog.Attr) *Logger {
+	replaced := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		replaced[attr.Key] = true
+	}
+	merged := make([]slog.Attr, 0, len(l.attrs)+len(attrs))
+	for _, attr := range l.attrs {
+		if !replaced[attr.Key] {
+			merged = append(merged, attr)
+		}
 	}
+	return &Logger{attrs: append(merged, attrs...)}
+}
 
-	defaultLogger = slog.New(handler)
-	return nil
+// Debug logs a debug message
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
+}
+
+// Info logs an info message
+func (l *Logger) Info(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args...)
+}
+
+// Warn logs a warning message
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
+}
+
+// Error logs an error message
+func (l *Logger) Error(format string, args ...interface{}) {
+	l.log(LevelError, format, args...)
+}
+
+// DebugAttrs logs a structured debug record
+func (l *Logger) DebugAttrs(msg string, attrs ...slog.Attr) {
+	l.emit(3, LevelDebug, msg, attrs)
+}
+
+// WarnAttrs logs a structured warning record
+func (l *Logger) WarnAttrs(msg string, attrs ...slog.Attr) {
+	l.emit(3, LevelWarn, msg, attrs)
+}
+
+// ErrorAttrs logs a structured error record
+func (l *Logger) ErrorAttrs(msg string, attrs ...slog.Attr) {
+	l.emit(3, LevelError, msg, attrs)
 }
 
 // Debug logs a debug message
 func Debug(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	defaultLogger.Debug(msg)
+	std.log(LevelDebug, format, args...)
 }
 
 // Info logs an info message
 func Info(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	defaultLogger.Info(msg)
+	std.log(LevelInfo, format, args...)
 }
 
 // Warn logs a warning message
 func Warn(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	defaultLogger.Warn(msg)
+	std.log(LevelWarn, format, args...)
 }
 
 // Error logs an error message
 func Error(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	defaultLogger.Error(msg)
+	std.log(LevelError, format, args...)
 }
 
 // Fatal logs a fatal message and exits
 func Fatal(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	defaultLogger.Error(msg)
+	std.log(LevelError, format, args...)
 	osExit(1)
 }
 
+// DebugAttrs logs a structured debug record
+func DebugAttrs(msg string, attrs ...slog.Attr) {
+	std.emit(3, LevelDebug, msg, attrs)
+}
+
+// WarnAttrs logs a structured warning record
+func WarnAttrs(msg string, attrs ...slog.Attr) {
+	std.emit(3, LevelWarn, msg, attrs)
+}
+
+// ErrorAttrs logs a structured error record
+func ErrorAttrs(msg string, attrs ...slog.Attr) {
+	std.emit(3, LevelError, msg, attrs)
+}
+
+// log formats and emits a record
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if !Enabled(level) {
+		return
+	}
+	l.emit(4, level, fmt.Sprintf(format, args...), nil)
+}
+
+// emit sends a record attributed to the caller of the exported logging
+// function, skip stack frames above, as counted by runtime.Callers
+func (l *Logger) emit(skip int, level Level, msg string, attrs []slog.Attr) {
+	ctx := context.Background()
+	handler := defaultLogger.Handler()
+	if !handler.Enabled(ctx, level) {
+		return
+	}
+	if len(l.attrs) > 0 {
+		handler = handler.WithAttrs(redactAttrs(l.attrs))
+	}
+	var pcs [1]uintptr
+	runtime.Callers(skip, pcs[:])
+	r := slog.NewRecord(time.Now(), level, Redact(msg), pcs[0])
+	r.AddAttrs(redactAttrs(attrs)...)
+	_ = handler.Handle(ctx, r)
+}
+
 // ParseLevel parses a string level into a Level value
 func ParseLevel(level string) (Level, error) {
 	switch strings.ToLower(level) {
@@ -169,11 +343,42 @@ func (h *plainHandler) Handle(ctx context.Context, r slog.Record) error {
 		level = fmt.Sprintf("[%s] ", strings.ToUpper(level))
 	}
 
-	msg := fmt.Sprintf("%s%s\n", level, r.Message)
-	_, err := io.WriteString(h.w, msg)
+	var b strings.Builder
+	// Fields attached with With identify the context of the record; only the
+	// session is shown, as a short tag telling concurrent sessions apart
+	for _, attr := range h.attrs {
+		if attr.Key == SessionKey {
+			tag := attr.Value.String()
+			if len(tag) > sessionTagLength {
+				tag = tag[:sessionTagLength]
+			}
+			fmt.Fprintf(&b, "[%s] ", tag)
+		}
+	}
+	b.WriteString(level)
+	b.WriteString(r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&b, attr)
+		return true
+	})
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		fmt.Fprintf(&b, " (%s:%d)", filepath.Join(filepath.Base(filepath.Dir(frame.File)), filepath.Base(frame.File)), frame.Line)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(h.w, b.String())
 	return err
 }
 
+// writeAttr appends an attribute as key=value, quoting values with spaces
+func writeAttr(b *strings.Builder, attr slog.Attr) {
+	value := attr.Value.String()
+	if strings.ContainsAny(value, " \t\"=") {
+		value = fmt.Sprintf("%q", value)
+	}
+	fmt.Fprintf(b, " %s=%s", attr.Key, value)
+}
+
 func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	return &plainHandler{
 		opts:   h.opts,
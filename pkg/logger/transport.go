@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// attemptKey is the context key of the attempt number of a request
+type attemptKey struct{}
+
+// versionPattern matches the API version segment of a Google API path
+var versionPattern = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]*)?$`)
+
+// safeQueryParams are query parameters whose values are logged by --debug-http
+var safeQueryParams = map[string]bool{"alt": true, "prettyPrint": true, "pageSize": true, "view": true}
+
+// debugHTTP enables logging sanitized URLs of API calls
+var debugHTTP bool
+
+// SetDebugHTTP enables logging the sanitized URL of every API call
+func SetDebugHTTP(enabled bool) {
+	debugHTTP = enabled
+}
+
+// WithAttempt records the attempt number of the requests made with ctx, for
+// callers retrying API calls
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// attempt returns the attempt number recorded in ctx, 1 by default
+func attempt(ctx context.Context) int {
+	if n, ok := ctx.Value(attemptKey{}).(int); ok {
+		return n
+	}
+	return 1
+}
+
+// Transport emits one debug record per Google API call with its method,
+// resource, duration, response status, and attempt number. Bodies, headers,
+// and tokens are never logged.
+type Transport struct {
+	Base http.RoundTripper
+	// Logger receives the records, the default Logger when nil
+	Logger *Logger
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !Enabled(LevelDebug) {
+		return base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	service, resource, method := describeRequest(req)
+	attrs := []slog.Attr{
+		slog.String("service", service),
+		slog.String("method", method),
+		slog.String("resource", resource),
+		slog.Duration("duration", time.Since(start).Round(time.Millisecond)),
+		slog.Int("attempt", attempt(req.Context())),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", sanitizeError(err)))
+	} else {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+	if debugHTTP {
+		attrs = append(attrs, slog.String("url", SanitizeURL(req.URL)))
+	}
+	log := t.Logger
+	if log == nil {
+		log = std
+	}
+	log.emit(2, LevelDebug, "API call", attrs)
+	return resp, err
+}
+
+// describeRequest derives the service, resource, and method of a Google API
+// request, e.g. cloudresourcemanager, projects/p, and getIamPolicy from
+// https://cloudresourcemanager.googleapis.com/v1/projects/p:getIamPolicy
+func describeRequest(req *http.Request) (string, string, string) {
+	service, _, _ := strings.Cut(req.URL.Host, ".")
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		if versionPattern.MatchString(segment) {
+			segments = segments[i+1:]
+			break
+		}
+	}
+	resource := strings.Join(segments, "/")
+	method := strings.ToLower(req.Method)
+	if i := strings.LastIndex(resource, ":"); i >= 0 {
+		resource, method = resource[:i], resource[i+1:]
+	}
+	return service, resource, method
+}
+
+// SanitizeURL returns u without credentials and with query values redacted,
+// except for a few harmless parameters
+func SanitizeURL(u *url.URL) string {
+	sanitized := *u
+	sanitized.User = nil
+	sanitized.Fragment = ""
+	query := u.Query()
+	for key, values := range query {
+		if safeQueryParams[key] {
+			continue
+		}
+		for i := range values {
+			values[i] = "REDACTED"
+		}
+	}
+	sanitized.RawQuery = query.Encode()
+	return sanitized.String()
+}
+
+// sanitizeError drops the URL embedded in transport errors
+func sanitizeError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Op + ": " + urlErr.Err.Error()
+	}
+	return err.Error()
+}
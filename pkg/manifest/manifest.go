@@ -0,0 +1,267 @@
+// Package manifest reads the YAML manifests of gta apply, which declare grants
+// prepared ahead of time, e.g. for planned maintenance
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/provider"
+	"gopkg.in/yaml.v3"
+)
+
+// hashLength is the number of hex digits of the hash recorded in bindings
+const hashLength = 12
+
+// Manifest declares grants, e.g.
+//
+//	name: maintenance-2024-06
+//	grants:
+//	  - project: my-project
+//	    roles: [roles/cloudsql.admin]
+//	    targets:
+//	      - resource: buckets/my-backups
+//	        roles: [roles/storage.objectAdmin]
+//	    members: [user:alice@example.com, group:dba@example.com]
+//	    ttl: 8h
+//	    reason: Database upgrade CHG-1234
+type Manifest struct {
+	// Name identifies the manifest across applies; its hash marks the
+	// bindings granted from it
+	Name   string  `yaml:"name"`
+	Grants []Grant `yaml:"grants"`
+}
+
+// Grant is an entry of a manifest, granted in a session of its own
+type Grant struct {
+	// Project is the project the session is recorded in, granted Roles
+	Project string   `yaml:"project"`
+	Roles   []string `yaml:"roles"`
+	// Targets are further projects or resources granted roles on in the
+	// same session
+	Targets []Target `yaml:"targets"`
+	// Members are exact principals, or @NAME identities of the config
+	Members []string `yaml:"members"`
+	TTL     string   `yaml:"ttl"`
+	Reason  string   `yaml:"reason"`
+	// Duration is TTL parsed
+	Duration time.Duration `yaml:"-"`
+}
+
+// Target is a project or resource granted roles on by a grant
+type Target struct {
+	// Resource is a resource name, see provider.ParseResource, or a project ID
+	Resource string   `yaml:"resource"`
+	Roles    []string `yaml:"roles"`
+}
+
+// Problem is a single issue found in a manifest
+type Problem struct {
+	// Field locates the problem, e.g. grants[2].ttl
+	Field   string
+	Message string
+}
+
+// String implements fmt.Stringer
+func (p Problem) String() string {
+	if p.Field == "" {
+		return p.Message
+	}
+	return p.Field + ": " + p.Message
+}
+
+// ValidationError reports every problem found in a manifest
+type ValidationError struct {
+	Path     string
+	Problems []Problem
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid manifest %s:", e.Path))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Load reads and validates the manifest at path
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", err)
+	}
+	m, err := Parse(data)
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			validationErr.Path = path
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// Parse decodes and validates manifest contents
+func Parse(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(m); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, &ValidationError{Problems: []Problem{{Message: err.Error()}}}
+		}
+		problems := make([]Problem, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, Problem{Message: msg})
+		}
+		return nil, &ValidationError{Problems: problems}
+	}
+	if problems := m.validate(); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return m, nil
+}
+
+// Hash identifies the manifest in the metadata of the bindings granted from
+// it. It only depends on the name, so that the bindings of earlier applies
+// of the manifest are found once its grants changed.
+func (m *Manifest) Hash() string {
+	sum := sha256.Sum256([]byte(m.Name))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// validate checks the manifest as a whole, parsing the TTLs of its grants
+func (m *Manifest) validate() []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(m.Name) == "" {
+		add("name", "required, it identifies the bindings of the manifest for --prune")
+	}
+	if len(m.Grants) == 0 {
+		add("grants", "at least one grant is required")
+	}
+	// declared maps each target, role, and member to the grant declaring it
+	declared := make(map[string]int)
+	for i := range m.Grants {
+		g := &m.Grants[i]
+		field := fmt.Sprintf("grants[%d]", i)
+		if g.Project == "" {
+			add(field+".project", "required")
+		} else if strings.Contains(g.Project, "/") {
+			add(field+".project", "%q is not a project ID, grant on resources with targets", g.Project)
+		}
+		if len(g.Roles) == 0 && len(g.Targets) == 0 {
+			add(field, "roles or targets are required")
+		}
+		for j, role := range g.Roles {
+			if strings.TrimSpace(role) == "" {
+				add(fmt.Sprintf("%s.roles[%d]", field, j), "empty role")
+			}
+		}
+		for j, target := range g.Targets {
+			targetField := fmt.Sprintf("%s.targets[%d]", field, j)
+			switch {
+			case target.Resource == "":
+				add(targetField+".resource", "required")
+			case strings.Contains(target.Resource, "/"):
+				if _, err := provider.ParseResource(target.Resource); err != nil {
+					add(targetField+".resource", "%v", err)
+				} else if err := provider.CheckResourceRoles(target.Resource, target.Roles); err != nil {
+					add(targetField+".roles", "%v", err)
+				}
+			}
+			if len(target.Roles) == 0 {
+				add(targetField+".roles", "at least one role is required")
+			}
+			for k, role := range target.Roles {
+				if strings.TrimSpace(role) == "" {
+					add(fmt.Sprintf("%s.roles[%d]", targetField, k), "empty role")
+				}
+			}
+		}
+		if len(g.Members) == 0 {
+			add(field+".members", "at least one member is required")
+		}
+		for j, member := range g.Members {
+			// Identities are expanded with the config by the caller
+			if strings.HasPrefix(member, "@") {
+				continue
+			}
+			if err := provider.ValidateMember(member); err != nil {
+				add(fmt.Sprintf("%s.members[%d]", field, j), "%v", err)
+			}
+		}
+		if g.TTL == "" {
+			add(field+".ttl", "required")
+		} else if ttl, err := duration.Parse(g.TTL); err != nil {
+			add(field+".ttl", "%v", err)
+		} else if ttl <= 0 {
+			add(field+".ttl", "must be positive")
+		} else {
+			g.Duration = ttl
+		}
+
+		for _, b := range g.Bindings() {
+			key := b.Target + "\x00" + b.Role + "\x00" + b.Member
+			if first, ok := declared[key]; ok && first != i {
+				add(field, "%s on %s for %s is already declared by grants[%d]", b.Role, b.Target, b.Member, first)
+				continue
+			}
+			declared[key] = i
+		}
+	}
+	return problems
+}
+
+// Binding is a role a grant declares for a member on a target
+type Binding struct {
+	// Target is the project ID or resource name granted on
+	Target string
+	Role   string
+	Member string
+}
+
+// Bindings returns the roles the grant declares for each of its members, by
+// target, with the roles in their full form
+func (g Grant) Bindings() []Binding {
+	var bindings []Binding
+	add := func(target string, roles []string) {
+		for _, role := range roles {
+			if strings.TrimSpace(role) == "" {
+				continue
+			}
+			for _, member := range g.Members {
+				bindings = append(bindings, Binding{Target: target, Role: provider.FormatRole(role), Member: member})
+			}
+		}
+	}
+	add(g.Project, g.Roles)
+	for _, target := range g.Targets {
+		add(target.Resource, target.Roles)
+	}
+	return bindings
+}
+
+// GrantTargets returns the targets of the grant in the form of
+// provider.GCPOptions
+func (g Grant) GrantTargets() []provider.GrantTarget {
+	targets := make([]provider.GrantTarget, 0, len(g.Targets))
+	for _, target := range g.Targets {
+		targets = append(targets, provider.GrantTarget{Resource: target.Resource, Roles: target.Roles})
+	}
+	return targets
+}
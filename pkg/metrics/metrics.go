@@ -0,0 +1,267 @@
+// Package metrics records operational metrics and exposes them in Prometheus text format
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder receives metric observations from the provider and commands.
+// Use Nop when metrics are not collected so the CLI-only path pays no cost.
+type Recorder interface {
+	// GrantSucceeded records a successfully applied binding
+	GrantSucceeded()
+	// GrantFailed records a failed grant, classified by error class
+	GrantFailed(class string)
+	// RevokeSucceeded records a successfully removed binding
+	RevokeSucceeded()
+	// RevokeFailed records a failed revocation, classified by error class
+	RevokeFailed(class string)
+	// SessionStarted records the start of a grant session
+	SessionStarted()
+	// SessionEnded records the end of a grant session
+	SessionEnded()
+	// BindingsChanged adjusts the number of active bindings managed by this process
+	BindingsChanged(delta int)
+	// APICall records the duration of a single Google API call
+	APICall(method string, duration time.Duration)
+}
+
+// Nop is a Recorder that discards all observations
+type Nop struct{}
+
+func (Nop) GrantSucceeded()               {}
+func (Nop) GrantFailed(string)            {}
+func (Nop) RevokeSucceeded()              {}
+func (Nop) RevokeFailed(string)           {}
+func (Nop) SessionStarted()               {}
+func (Nop) SessionEnded()                 {}
+func (Nop) BindingsChanged(int)           {}
+func (Nop) APICall(string, time.Duration) {}
+
+// defaultBuckets are the histogram upper bounds in seconds for API call durations
+var defaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram is a cumulative Prometheus-style histogram
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range defaultBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Registry is an in-memory Recorder that can be scraped or dumped
+type Registry struct {
+	mu             sync.Mutex
+	grants         uint64
+	revokes        uint64
+	grantErrors    map[string]uint64
+	revokeErrors   map[string]uint64
+	activeSessions int64
+	activeBindings int64
+	apiCalls       map[string]*histogram
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		grantErrors:  make(map[string]uint64),
+		revokeErrors: make(map[string]uint64),
+		apiCalls:     make(map[string]*histogram),
+	}
+}
+
+// GrantSucceeded implements Recorder
+func (r *Registry) GrantSucceeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grants++
+}
+
+// GrantFailed implements Recorder
+func (r *Registry) GrantFailed(class string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.grantErrors[class]++
+}
+
+// RevokeSucceeded implements Recorder
+func (r *Registry) RevokeSucceeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revokes++
+}
+
+// RevokeFailed implements Recorder
+func (r *Registry) RevokeFailed(class string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revokeErrors[class]++
+}
+
+// SessionStarted implements Recorder
+func (r *Registry) SessionStarted() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.activeSessions++
+}
+
+// SessionEnded implements Recorder
+func (r *Registry) SessionEnded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.activeSessions--
+}
+
+// BindingsChanged implements Recorder
+func (r *Registry) BindingsChanged(delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.activeBindings += int64(delta)
+}
+
+// APICall implements Recorder
+func (r *Registry) APICall(method string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.apiCalls[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(defaultBuckets))}
+		r.apiCalls[method] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+	writeHeader(&b, "gta_grants_total", "counter", "Number of bindings granted")
+	fmt.Fprintf(&b, "gta_grants_total %d\n", r.grants)
+	writeHeader(&b, "gta_revokes_total", "counter", "Number of bindings revoked")
+	fmt.Fprintf(&b, "gta_revokes_total %d\n", r.revokes)
+	writeHeader(&b, "gta_grant_errors_total", "counter", "Number of failed grants by error class")
+	for _, class := range sortedKeys(r.grantErrors) {
+		fmt.Fprintf(&b, "gta_grant_errors_total{class=%q} %d\n", class, r.grantErrors[class])
+	}
+	writeHeader(&b, "gta_revoke_errors_total", "counter", "Number of failed revocations by error class")
+	for _, class := range sortedKeys(r.revokeErrors) {
+		fmt.Fprintf(&b, "gta_revoke_errors_total{class=%q} %d\n", class, r.revokeErrors[class])
+	}
+	writeHeader(&b, "gta_active_sessions", "gauge", "Number of active grant sessions")
+	fmt.Fprintf(&b, "gta_active_sessions %d\n", r.activeSessions)
+	writeHeader(&b, "gta_active_bindings", "gauge", "Number of active bindings managed by this process")
+	fmt.Fprintf(&b, "gta_active_bindings %d\n", r.activeBindings)
+
+	writeHeader(&b, "gta_api_call_duration_seconds", "histogram", "Duration of Google API calls")
+	methods := make([]string, 0, len(r.apiCalls))
+	for method := range r.apiCalls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		h := r.apiCalls[method]
+		for i, bound := range defaultBuckets {
+			fmt.Fprintf(&b, "gta_api_call_duration_seconds_bucket{method=%q,le=\"%g\"} %d\n", method, bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "gta_api_call_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, h.count)
+		fmt.Fprintf(&b, "gta_api_call_duration_seconds_sum{method=%q} %g\n", method, h.sum)
+		fmt.Fprintf(&b, "gta_api_call_duration_seconds_count{method=%q} %d\n", method, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// String returns the metrics in Prometheus text exposition format
+func (r *Registry) String() string {
+	var b strings.Builder
+	r.WriteTo(&b)
+	return b.String()
+}
+
+// Handler returns an http.Handler serving the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+// Serve exposes the registry on addr at /metrics until ctx is cancelled
+func Serve(ctx context.Context, addr string, r *Registry) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve metrics on %s: %v", addr, err)
+	}
+	return nil
+}
+
+// Transport is an http.RoundTripper that records the duration of each API call
+type Transport struct {
+	Base     http.RoundTripper
+	Recorder Recorder
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	t.Recorder.APICall(apiMethod(req), time.Since(start))
+	return resp, err
+}
+
+// apiMethod derives a low-cardinality method label from a Google API request,
+// e.g. "getIamPolicy" from ".../projects/p:getIamPolicy"
+func apiMethod(req *http.Request) string {
+	path := req.URL.Path
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		return path[i+1:]
+	}
+	return strings.ToLower(req.Method)
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ChatNotifier posts card messages to a Google Chat incoming webhook. Messages
+// of the same session are threaded so the revoke replies to the grant.
+type ChatNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewChatNotifier creates a notifier posting to a Google Chat webhook URL
+func NewChatNotifier(webhookURL string) *ChatNotifier {
+	return &ChatNotifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+// Name implements Notifier
+func (c *ChatNotifier) Name() string {
+	return "Google Chat"
+}
+
+// Notify implements Notifier
+func (c *ChatNotifier) Notify(ctx context.Context, n Notification) error {
+	target, err := c.threadURL(n)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, c.client, target, chatMessage(n))
+}
+
+// threadURL adds the thread key for the notification's session to the webhook URL
+func (c *ChatNotifier) threadURL(n Notification) (string, error) {
+	u, err := url.Parse(c.webhookURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if n.SessionID == "" {
+		return u.String(), nil
+	}
+	query := u.Query()
+	query.Set("threadKey", "gta-"+n.SessionID)
+	query.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// chatMessage renders a notification as a Google Chat cardsV2 message
+func chatMessage(n Notification) map[string]interface{} {
+	widgets := make([]map[string]interface{}, 0)
+	for _, field := range n.Fields() {
+		widgets = append(widgets, map[string]interface{}{
+			"decoratedText": map[string]interface{}{
+				"topLabel": field.Label,
+				"text":     field.Value,
+				"wrapText": true,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"text": n.Title(),
+		"cardsV2": []map[string]interface{}{{
+			"cardId": "gta-" + string(n.Action),
+			"card": map[string]interface{}{
+				"header": map[string]interface{}{
+					"title":    n.Title(),
+					"subtitle": n.Project,
+				},
+				"sections": []map[string]interface{}{{
+					"widgets": widgets,
+				}},
+			},
+		}},
+	}
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TLS modes supported by the email notifier
+const (
+	EmailTLSStartTLS = "starttls"
+	EmailTLSImplicit = "tls"
+	EmailTLSNone     = "none"
+)
+
+const (
+	defaultEmailSubject = `[gta] {{.Title}}{{if .Project}}: {{.Project}}{{end}}`
+	defaultEmailBody    = `{{.Title}}
+{{range .Fields}}
+{{.Label}}: {{.Value}}{{end}}
+
+This message was sent by gta (Grant Temporary Access).
+`
+)
+
+// EmailConfig configures the SMTP notifier
+type EmailConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+	// TLS is one of EmailTLSStartTLS (default), EmailTLSImplicit, or EmailTLSNone
+	TLS             string
+	SubjectTemplate string
+	BodyTemplate    string
+}
+
+// EmailNotifier sends notifications by email over SMTP
+type EmailNotifier struct {
+	cfg     EmailConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewEmailNotifier creates an email notifier, parsing its templates
+func NewEmailNotifier(cfg EmailConfig) (*EmailNotifier, error) {
+	if cfg.TLS == "" {
+		cfg.TLS = EmailTLSStartTLS
+	}
+	subject, body, err := ParseEmailTemplates(cfg.SubjectTemplate, cfg.BodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailNotifier{cfg: cfg, subject: subject, body: body}, nil
+}
+
+// ParseEmailTemplates parses the subject and body templates, using the
+// defaults for empty values
+func ParseEmailTemplates(subjectText, bodyText string) (*template.Template, *template.Template, error) {
+	if subjectText == "" {
+		subjectText = defaultEmailSubject
+	}
+	if bodyText == "" {
+		bodyText = defaultEmailBody
+	}
+	subject, err := template.New("subject").Parse(subjectText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid subject template: %v", err)
+	}
+	body, err := template.New("body").Parse(bodyText)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid body template: %v", err)
+	}
+	return subject, body, nil
+}
+
+// Name implements Notifier
+func (e *EmailNotifier) Name() string {
+	return "email"
+}
+
+// Notify implements Notifier
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	msg, err := e.message(n)
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, msg)
+}
+
+// message renders the RFC 5322 message for a notification
+func (e *EmailNotifier) message(n Notification) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := e.subject.Execute(&subject, n); err != nil {
+		return nil, fmt.Errorf("failed to render subject: %v", err)
+	}
+	if err := e.body.Execute(&body, n); err != nil {
+		return nil, fmt.Errorf("failed to render body: %v", err)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(subject.String(), "\n", " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+// send delivers msg over SMTP honoring the configured TLS mode
+func (e *EmailNotifier) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	tlsConfig := &tls.Config{ServerName: e.cfg.Host}
+
+	var conn net.Conn
+	var err error
+	if e.cfg.TLS == EmailTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if e.cfg.TLS == EmailTLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+	}
+
+	from, err := mail.ParseAddress(e.cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %v", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("failed to set sender: %v", err)
+	}
+	for _, to := range e.cfg.To {
+		rcpt, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %v", to, err)
+		}
+		if err := client.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %v", rcpt.Address, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	return client.Quit()
+}
@@ -0,0 +1,408 @@
+// Package notify delivers human-readable notifications about temporary access
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+const (
+	// deliveryTimeout bounds a single notification delivery
+	deliveryTimeout = 10 * time.Second
+	// closeTimeout bounds how long Close waits for queued deliveries
+	closeTimeout = 15 * time.Second
+	// queueSize bounds the notifications waiting for each notifier
+	queueSize = 64
+	// postAttempts is how many times a chat message is posted before
+	// giving up
+	postAttempts = 3
+	// postInitialBackoff is the delay before the first repost
+	postInitialBackoff = time.Second
+)
+
+// ActionExpiring marks reminder notifications sent shortly before a session expires
+const ActionExpiring audit.Action = "expiring"
+
+// Notification summarizes one operation, e.g. a grant of several roles
+type Notification struct {
+	Action  audit.Action
+	Project string
+	// Environment is the environment of Project, e.g. prod
+	Environment string
+	Roles       []string
+	Member      string
+	Expiry      time.Time
+	// RevokeAt is when the session revokes the roles ahead of Expiry, zero
+	// when they are revoked at expiry
+	RevokeAt  time.Time
+	TTL       time.Duration
+	Reason    string
+	SessionID string
+	Caller    string
+	RequestID string
+	Requester string
+	Approver  string
+	// BreakGlass marks emergency access bypassing approval for Incident
+	BreakGlass bool
+	Incident   string
+	// Failed maps roles that could not be processed to their error, or with
+	// ActionModified, the roles changed to how they changed
+	Failed map[string]string
+}
+
+// Field is a labelled value displayed in a notification
+type Field struct {
+	Label string
+	Value string
+}
+
+// Title returns a short headline for the notification
+func (n Notification) Title() string {
+	if n.BreakGlass {
+		return "BREAK-GLASS: " + n.title()
+	}
+	return n.title()
+}
+
+// title returns the headline for the notification's action
+func (n Notification) title() string {
+	switch n.Action {
+	case audit.ActionGrant:
+		return "Temporary access granted"
+	case audit.ActionRevoke:
+		return "Temporary access revoked"
+	case audit.ActionClean:
+		return "Temporary bindings cleaned up"
+	case ActionExpiring:
+		return "Temporary access expiring soon"
+	case audit.ActionModified:
+		return "Temporary access modified externally"
+	case audit.ActionRequest:
+		return "Temporary access requested"
+	default:
+		return fmt.Sprintf("Temporary access %s", n.Action)
+	}
+}
+
+// Fields returns the notification details in display order, omitting empty values
+func (n Notification) Fields() []Field {
+	var fields []Field
+	add := func(label, value string) {
+		if value != "" {
+			fields = append(fields, Field{Label: label, Value: value})
+		}
+	}
+	add("Project", n.Project)
+	add("Environment", n.Environment)
+	add("Roles", strings.Join(n.Roles, ", "))
+	add("Member", n.Member)
+	if n.TTL > 0 {
+		add("TTL", duration.Format(n.TTL))
+	}
+	if !n.Expiry.IsZero() {
+		add("Expires", n.Expiry.Format(time.RFC3339))
+	}
+	if !n.RevokeAt.IsZero() {
+		add("Revoked at", n.RevokeAt.Format(time.RFC3339))
+	}
+	add("Incident", n.Incident)
+	add("Reason", n.Reason)
+	add("Requested by", n.Requester)
+	if n.Approver != "" {
+		add("Approved by", n.Approver)
+	} else if n.Action != audit.ActionRequest {
+		add("Granted by", n.Caller)
+	}
+	add("Request", n.RequestID)
+	add("Session", n.SessionID)
+	if len(n.Failed) > 0 {
+		roles := make([]string, 0, len(n.Failed))
+		for role := range n.Failed {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		failed := make([]string, len(roles))
+		for i, role := range roles {
+			failed[i] = fmt.Sprintf("%s (%s)", role, n.Failed[role])
+		}
+		label := "Failed"
+		if n.Action == audit.ActionModified {
+			label = "Changed"
+		}
+		add(label, strings.Join(failed, "; "))
+	}
+	return fields
+}
+
+// Text renders the notification as plain text
+func (n Notification) Text() string {
+	lines := []string{n.Title()}
+	for _, field := range n.Fields() {
+		lines = append(lines, fmt.Sprintf("%s: %s", field.Label, field.Value))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Notifier delivers notifications to a single destination
+type Notifier interface {
+	// Name identifies the notifier in logs
+	Name() string
+	// Notify delivers a notification
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Dispatcher is the notification pipeline shared by all notifiers. It is an
+// audit.Sink collecting lifecycle events; Flush groups the pending events into
+// one notification per operation and queues it for the notifier of every
+// route selecting it. Each notifier delivers its queue in order in the
+// background, and Close drains the queues before returning. Delivery
+// failures are logged and never affect the IAM operation.
+type Dispatcher struct {
+	mu      sync.Mutex
+	routes  []Route
+	lanes   map[Notifier]*lane
+	closed  bool
+	pending []audit.Event
+	wg      sync.WaitGroup
+}
+
+// lane delivers the notifications queued for one notifier in order
+type lane struct {
+	notifier Notifier
+	queue    chan Notification
+}
+
+// NewDispatcher creates a dispatcher delivering along the given routes
+func NewDispatcher(routes ...Route) *Dispatcher {
+	return &Dispatcher{routes: routes, lanes: make(map[Notifier]*lane)}
+}
+
+// Add delivers the following notifications along routes as well
+func (d *Dispatcher) Add(routes ...Route) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.routes = append(d.routes, routes...)
+}
+
+// Emit implements audit.Sink
+func (d *Dispatcher) Emit(event audit.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = append(d.pending, event)
+}
+
+// Flush delivers notifications for all pending events and returns them
+func (d *Dispatcher) Flush() []Notification {
+	d.mu.Lock()
+	events := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+
+	notifications := Group(events)
+	for _, n := range notifications {
+		d.Send(n)
+	}
+	return notifications
+}
+
+// Send queues a notification for the notifier of every route selecting it,
+// once per notifier. A notification finding the queue of a notifier full is
+// dropped with a warning rather than holding up the operation.
+func (d *Dispatcher) Send(n Notification) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		logger.Warn("Notifications are closed, dropping the %s notification", n.Action)
+		return
+	}
+	queued := make(map[Notifier]bool)
+	for _, route := range d.routes {
+		if queued[route.Notifier] {
+			continue
+		}
+		if reason := route.Filter.Mismatch(n); reason != "" {
+			logger.Debug("Route %s skips the %s notification: %s", route, n.Action, reason)
+			continue
+		}
+		queued[route.Notifier] = true
+		select {
+		case d.lane(route.Notifier).queue <- n:
+		default:
+			logger.Warn("The %s notification queue is full, dropping the %s notification", route.Notifier.Name(), n.Action)
+		}
+	}
+}
+
+// lane returns the lane of notifier, starting it on first use; d.mu must be
+// held
+func (d *Dispatcher) lane(notifier Notifier) *lane {
+	if l, ok := d.lanes[notifier]; ok {
+		return l
+	}
+	l := &lane{notifier: notifier, queue: make(chan Notification, queueSize)}
+	d.lanes[notifier] = l
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for n := range l.queue {
+			l.deliver(n)
+		}
+	}()
+	return l
+}
+
+// deliver delivers a notification, bounded by deliveryTimeout
+func (l *lane) deliver(n Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+	if err := l.notifier.Notify(ctx, n); err != nil {
+		logger.Warn("Failed to send %s notification: %v", l.notifier.Name(), err)
+		return
+	}
+	logger.Debug("Sent %s notification for %s", l.notifier.Name(), n.Action)
+}
+
+// Close implements audit.Sink, flushing pending events and waiting for the
+// queues to be delivered
+func (d *Dispatcher) Close() error {
+	d.Flush()
+
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, l := range d.lanes {
+			close(l.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(closeTimeout):
+		return fmt.Errorf("timed out delivering notifications")
+	}
+}
+
+// Group combines per-binding events into one notification per operation,
+// keyed by action, session, project, and member, preserving event order
+func Group(events []audit.Event) []Notification {
+	type key struct {
+		action    audit.Action
+		sessionID string
+		project   string
+		member    string
+	}
+
+	var order []key
+	groups := make(map[key]*Notification)
+	for _, event := range events {
+		k := key{event.Action, event.SessionID, event.Project, event.Member}
+		n, ok := groups[k]
+		if !ok {
+			n = &Notification{
+				Action:      event.Action,
+				Project:     event.Project,
+				Environment: event.Environment,
+				Member:      event.Member,
+				Reason:      event.Reason,
+				SessionID:   event.SessionID,
+				Caller:      event.Caller,
+				RequestID:   event.RequestID,
+				Requester:   event.Requester,
+				Approver:    event.Approver,
+				BreakGlass:  event.BreakGlass,
+				Incident:    event.Incident,
+			}
+			groups[k] = n
+			order = append(order, k)
+		}
+		if event.Error != "" {
+			if n.Failed == nil {
+				n.Failed = make(map[string]string)
+			}
+			n.Failed[event.Role] = event.Error
+			continue
+		}
+		n.Roles = append(n.Roles, event.Role)
+		if !event.Expiry.IsZero() && event.Expiry.After(n.Expiry) {
+			n.Expiry = event.Expiry
+			n.RevokeAt = event.RevokeAt
+			n.TTL = event.Expiry.Sub(event.Time)
+		}
+	}
+
+	notifications := make([]Notification, 0, len(order))
+	for _, k := range order {
+		notifications = append(notifications, *groups[k])
+	}
+	return notifications
+}
+
+// postJSON posts payload as JSON to url and checks for a successful status,
+// retrying with backoff on network errors, 429, and 5xx responses as long as
+// ctx allows
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	backoff := postInitialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := postAttempt(ctx, client, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= postAttempts {
+			return err
+		}
+		logger.Debug("Notification delivery attempt %d failed, retrying in %v: %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postAttempt sends a single delivery attempt and reports whether a failure
+// is retriable
+func postAttempt(ctx context.Context, client *http.Client, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
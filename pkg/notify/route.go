@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/yckao/gta/pkg/audit"
+)
+
+// Severity ranks notifications so that routes can leave out the minor ones
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+// severityNames are the names of the severities, in order
+var severityNames = []string{"info", "warning", "critical"}
+
+// String returns the name of the severity
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// ParseSeverity parses the name of a severity, info when empty
+func ParseSeverity(name string) (Severity, error) {
+	if name == "" {
+		return SeverityInfo, nil
+	}
+	if i := slices.Index(severityNames, name); i >= 0 {
+		return Severity(i), nil
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q (expected %s)", name, strings.Join(severityNames, ", "))
+}
+
+// Actions are the actions notifications are sent for
+var Actions = []audit.Action{audit.ActionGrant, audit.ActionRevoke, audit.ActionClean, ActionExpiring, audit.ActionModified, audit.ActionRequest}
+
+// Severity returns the severity of the notification: critical for
+// break-glass access and bindings modified externally, warning for
+// operations that failed in part and sessions about to expire, and info
+// otherwise
+func (n Notification) Severity() Severity {
+	switch {
+	case n.BreakGlass, n.Action == audit.ActionModified:
+		return SeverityCritical
+	case len(n.Failed) > 0, n.Action == ActionExpiring:
+		return SeverityWarning
+	default:
+		return SeverityInfo
+	}
+}
+
+// Filter selects notifications. Each field left empty matches every
+// notification; patterns match whole values.
+type Filter struct {
+	Actions      []audit.Action
+	Projects     []*regexp.Regexp
+	Environments []string
+	// Roles match a notification when one of its roles matches
+	Roles       []*regexp.Regexp
+	MinSeverity Severity
+}
+
+// Match reports whether the filter selects n
+func (f Filter) Match(n Notification) bool {
+	return f.Mismatch(n) == ""
+}
+
+// Mismatch describes why the filter does not select n, empty when it does
+func (f Filter) Mismatch(n Notification) string {
+	if len(f.Actions) > 0 && !slices.Contains(f.Actions, n.Action) {
+		return fmt.Sprintf("action %s is not selected", n.Action)
+	}
+	if len(f.Projects) > 0 && !matchAny(f.Projects, n.Project) {
+		return fmt.Sprintf("project %s is not selected", n.Project)
+	}
+	if len(f.Environments) > 0 && !slices.Contains(f.Environments, n.Environment) {
+		if n.Environment == "" {
+			return "the project is in no environment"
+		}
+		return fmt.Sprintf("environment %s is not selected", n.Environment)
+	}
+	if len(f.Roles) > 0 && !slices.ContainsFunc(n.Roles, func(role string) bool { return matchAny(f.Roles, role) }) {
+		return fmt.Sprintf("none of the roles %s is selected", strings.Join(n.Roles, ", "))
+	}
+	if severity := n.Severity(); severity < f.MinSeverity {
+		return fmt.Sprintf("severity %s is below %s", severity, f.MinSeverity)
+	}
+	return ""
+}
+
+// matchAny reports whether one of patterns matches value
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Route delivers the notifications its filter selects to a notifier
+type Route struct {
+	// Name identifies the route in logs, the name of the notifier by default
+	Name     string
+	Notifier Notifier
+	Filter   Filter
+}
+
+// String returns the name of the route
+func (r Route) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Notifier.Name()
+}
+
+// Unfiltered returns routes delivering every notification to notifiers
+func Unfiltered(notifiers ...Notifier) []Route {
+	routes := make([]Route, len(notifiers))
+	for i, notifier := range notifiers {
+		routes[i] = Route{Notifier: notifier}
+	}
+	return routes
+}
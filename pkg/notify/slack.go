@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// SlackNotifier posts Block Kit messages to a Slack incoming webhook
+type SlackNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewSlackNotifier creates a notifier posting to a Slack webhook URL
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+// Name implements Notifier
+func (s *SlackNotifier) Name() string {
+	return "Slack"
+}
+
+// Notify implements Notifier
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, s.client, s.webhookURL, slackMessage(n))
+}
+
+// slackMessage renders a notification as a Slack Block Kit message
+func slackMessage(n Notification) map[string]interface{} {
+	fields := make([]map[string]interface{}, 0)
+	for _, field := range n.Fields() {
+		fields = append(fields, map[string]interface{}{
+			"type": "mrkdwn",
+			"text": fmt.Sprintf("*%s*\n%s", field.Label, field.Value),
+		})
+	}
+
+	blocks := []map[string]interface{}{{
+		"type": "header",
+		"text": map[string]interface{}{"type": "plain_text", "text": n.Title()},
+	}}
+	// Slack allows at most 10 fields per section
+	for start := 0; start < len(fields); start += 10 {
+		end := start + 10
+		if end > len(fields) {
+			end = len(fields)
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type":   "section",
+			"fields": fields[start:end],
+		})
+	}
+
+	return map[string]interface{}{
+		"text":   n.Title(),
+		"blocks": blocks,
+	}
+}
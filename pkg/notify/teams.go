@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+)
+
+// TeamsNotifier posts Adaptive Cards to a Microsoft Teams incoming webhook or
+// workflow
+type TeamsNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewTeamsNotifier creates a notifier posting to a Microsoft Teams webhook URL
+func NewTeamsNotifier(webhookURL string) *TeamsNotifier {
+	return &TeamsNotifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+// Name implements Notifier
+func (t *TeamsNotifier) Name() string {
+	return "Microsoft Teams"
+}
+
+// Notify implements Notifier
+func (t *TeamsNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, t.client, t.webhookURL, teamsMessage(n))
+}
+
+// teamsMessage renders a notification as a message holding an Adaptive Card
+func teamsMessage(n Notification) map[string]interface{} {
+	facts := make([]map[string]interface{}, 0)
+	for _, field := range n.Fields() {
+		facts = append(facts, map[string]interface{}{
+			"title": field.Label,
+			"value": field.Value,
+		})
+	}
+
+	title := map[string]interface{}{
+		"type":   "TextBlock",
+		"text":   n.Title(),
+		"size":   "Medium",
+		"weight": "Bolder",
+		"wrap":   true,
+	}
+	if n.BreakGlass {
+		title["color"] = "Attention"
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]interface{}{
+					title,
+					{"type": "FactSet", "facts": facts},
+				},
+				"msteams": map[string]interface{}{"width": "Full"},
+			},
+		}},
+	}
+}
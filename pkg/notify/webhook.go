@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 signature of the request body
+	SignatureHeader = "X-GTA-Signature"
+	// EventHeader carries the event action
+	EventHeader = "X-GTA-Event"
+
+	// defaultWebhookAttempts bounds deliveries of a single event
+	defaultWebhookAttempts = 4
+	// webhookInitialBackoff is the delay before the first retry
+	webhookInitialBackoff = 500 * time.Millisecond
+)
+
+// WebhookConfig configures the generic lifecycle event webhook
+type WebhookConfig struct {
+	URL    string
+	Secret string
+	// Strict makes grants wait for the webhook to accept the event and abort
+	// when it cannot be delivered
+	Strict      bool
+	MaxAttempts int
+}
+
+// Webhook posts every lifecycle event as JSON to a URL, signed with a shared
+// secret. It is an audit.Sink delivering in the background; in strict mode
+// grant events are instead delivered synchronously through Register before
+// the binding is written.
+type Webhook struct {
+	cfg    WebhookConfig
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+// NewWebhook creates a webhook sink
+func NewWebhook(cfg WebhookConfig) *Webhook {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultWebhookAttempts
+	}
+	return &Webhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+// Strict reports whether grants must be registered before they are applied
+func (w *Webhook) Strict() bool {
+	return w.cfg.Strict
+}
+
+// Register synchronously delivers a grant event before the binding is written
+func (w *Webhook) Register(ctx context.Context, event audit.Event) error {
+	if err := w.Deliver(ctx, event); err != nil {
+		return fmt.Errorf("failed to register grant with webhook: %w", err)
+	}
+	return nil
+}
+
+// Emit implements audit.Sink
+func (w *Webhook) Emit(event audit.Event) {
+	// Successful grants were already delivered by Register in strict mode
+	if w.cfg.Strict && event.Action == audit.ActionGrant && event.Error == "" {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		if err := w.Deliver(ctx, event); err != nil {
+			logger.Warn("Failed to deliver %s event to webhook: %v", event.Action, err)
+		}
+	}()
+}
+
+// Close implements audit.Sink, waiting for in-flight deliveries
+func (w *Webhook) Close() error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(closeTimeout):
+		return fmt.Errorf("timed out delivering webhook events")
+	}
+}
+
+// Deliver posts an event, retrying with backoff on network errors and 5xx responses
+func (w *Webhook) Deliver(ctx context.Context, event audit.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.cfg.MaxAttempts {
+			return err
+		}
+		logger.Debug("Webhook delivery attempt %d failed, retrying in %v: %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a single delivery attempt and reports whether a failure is retriable
+func (w *Webhook) post(ctx context.Context, event audit.Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event.Action))
+	if w.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.cfg.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// Sign returns the signature header value for body, "sha256=" followed by the
+// hex-encoded HMAC-SHA256 of the body keyed with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
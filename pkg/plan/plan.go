@@ -0,0 +1,171 @@
+// Package plan reads and writes the plan files of gta plan, which hold the
+// exact bindings a grant would add for review before gta apply --plan
+// executes them
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/fileutil"
+)
+
+// Version is the version of the plan format written
+const Version = 1
+
+// Plan is a grant resolved and validated ahead of its execution. It holds
+// no credentials, and its fields are sorted so that the plans of successive
+// revisions of a change can be diffed.
+type Plan struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// CreatedBy is the caller who made the plan
+	CreatedBy  string `json:"created_by"`
+	GTAVersion string `json:"gta_version"`
+	Profile    string `json:"profile,omitempty"`
+	// Project, Resource, Roles, Targets, Members, TTL, and Reason are the
+	// grant planned, with the companion roles of role_dependencies added
+	Project     string   `json:"project"`
+	Resource    string   `json:"resource,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Targets     []Target `json:"targets,omitempty"`
+	Members     []string `json:"members"`
+	TTL         string   `json:"ttl"`
+	Reason      string   `json:"reason,omitempty"`
+	// Bindings are the bindings the grant adds
+	Bindings []Binding `json:"bindings"`
+	// Policies are the etags of the policies the bindings are added to, as
+	// read when planning
+	Policies []Policy `json:"policies"`
+}
+
+// Target is a further project or resource granted roles on
+type Target struct {
+	Resource string   `json:"resource"`
+	Roles    []string `json:"roles"`
+}
+
+// Binding is a role added to the policy of a project or resource
+type Binding struct {
+	// Resource names the policy, e.g. projects/my-project or buckets/logs
+	Resource string    `json:"resource"`
+	Role     string    `json:"role"`
+	Members  []string  `json:"members"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// Policy is the etag of the policy of a project or resource
+type Policy struct {
+	Resource string `json:"resource"`
+	Etag     string `json:"etag"`
+}
+
+// Sort orders the bindings and policies by resource and role, for plans of
+// the same grant to compare equal
+func (p *Plan) Sort() {
+	sort.Slice(p.Bindings, func(i, j int) bool {
+		if p.Bindings[i].Resource != p.Bindings[j].Resource {
+			return p.Bindings[i].Resource < p.Bindings[j].Resource
+		}
+		return p.Bindings[i].Role < p.Bindings[j].Role
+	})
+	sort.Slice(p.Policies, func(i, j int) bool { return p.Policies[i].Resource < p.Policies[j].Resource })
+}
+
+// Expiry returns the earliest expiry of the bindings
+func (p *Plan) Expiry() time.Time {
+	var expiry time.Time
+	for _, b := range p.Bindings {
+		if expiry.IsZero() || b.Expiry.Before(expiry) {
+			expiry = b.Expiry
+		}
+	}
+	return expiry
+}
+
+// Encode returns the plan as indented JSON
+func (p *Plan) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Write saves the plan to path
+func (p *Plan) Write(path string) error {
+	data, err := p.Encode()
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write plan: %v", err)
+	}
+	return nil
+}
+
+// Load reads the plan at path
+func Load(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %v", err)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %v", path, err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("plan %s has version %d, this gta reads version %d", path, p.Version, Version)
+	}
+	if len(p.Bindings) == 0 {
+		return nil, fmt.Errorf("plan %s adds no binding", path)
+	}
+	return &p, nil
+}
+
+// Changes describes how the bindings of next differ from those of p, leaving
+// out their expiries, empty when they add the same roles to the same members
+// of the same policies
+func (p *Plan) Changes(next *Plan) []string {
+	key := func(b Binding) string {
+		return fmt.Sprintf("%s on %s for %s", b.Role, b.Resource, strings.Join(b.Members, ", "))
+	}
+	planned := make(map[string]bool, len(p.Bindings))
+	for _, b := range p.Bindings {
+		planned[key(b)] = true
+	}
+	var changes []string
+	for _, b := range next.Bindings {
+		if !planned[key(b)] {
+			changes = append(changes, "adds "+key(b))
+		}
+		delete(planned, key(b))
+	}
+	for _, b := range p.Bindings {
+		if planned[key(b)] {
+			changes = append(changes, "no longer adds "+key(b))
+		}
+	}
+	return changes
+}
+
+// ChangedPolicies returns the resources whose policy etag in current differs
+// from the one in p
+func (p *Plan) ChangedPolicies(current []Policy) []string {
+	etags := make(map[string]string, len(current))
+	for _, policy := range current {
+		etags[policy.Resource] = policy.Etag
+	}
+	var changed []string
+	for _, policy := range p.Policies {
+		if etags[policy.Resource] != policy.Etag {
+			changed = append(changed, policy.Resource)
+		}
+	}
+	return changed
+}
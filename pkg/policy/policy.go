@@ -0,0 +1,141 @@
+// Package policy evaluates Rego policies governing grants before they are applied
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/yckao/gta/pkg/duration"
+)
+
+// Query is the document a policy must define, i.e. policies use `package gta`
+const Query = "data.gta"
+
+// Input is the document describing a grant, available to policies as `input`
+type Input struct {
+	// Caller is the identity running gta, the approver for approved requests
+	Caller string `json:"caller"`
+	// Member is the identity receiving the roles
+	Member        string            `json:"member"`
+	Roles         []string          `json:"roles"`
+	Project       string            `json:"project"`
+	ProjectLabels map[string]string `json:"project_labels"`
+	// Environment is the environment of Project, e.g. prod, empty when
+	// environments are not configured
+	Environment string `json:"environment"`
+	// TTL is the requested TTL as a Go duration string
+	TTL        string `json:"ttl"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+	Reason     string `json:"reason"`
+	BreakGlass bool   `json:"break_glass"`
+	Incident   string `json:"incident"`
+	// Time is the time of the request in RFC 3339, Hour and Weekday are in UTC
+	Time    string `json:"time"`
+	Hour    int    `json:"hour"`
+	Weekday string `json:"weekday"`
+}
+
+// NewInput creates an input for a grant at now
+func NewInput(now time.Time, ttl time.Duration) Input {
+	now = now.UTC()
+	return Input{
+		TTL:        ttl.String(),
+		TTLSeconds: int64(ttl / time.Second),
+		Time:       now.Format(time.RFC3339),
+		Hour:       now.Hour(),
+		Weekday:    now.Weekday().String(),
+	}
+}
+
+// Decision is the outcome of a policy evaluation
+type Decision struct {
+	// Deny holds the reasons the grant is denied, it is allowed when empty
+	Deny []string
+	// TTL is the TTL set by the policy, zero when unchanged
+	TTL time.Duration
+}
+
+// Allowed reports whether the grant may proceed
+func (d Decision) Allowed() bool {
+	return len(d.Deny) == 0
+}
+
+// Evaluator evaluates a compiled policy
+type Evaluator struct {
+	query rego.PreparedEvalQuery
+}
+
+// Load compiles the Rego files at path, a file or a directory
+func Load(ctx context.Context, path string) (*Evaluator, error) {
+	query, err := rego.New(
+		rego.Query(Query),
+		rego.Load([]string{path}, nil),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy %s: %v", path, err)
+	}
+	return &Evaluator{query: query}, nil
+}
+
+// Evaluate evaluates the policy for input. Policies deny grants by adding
+// messages to the `deny` set and may change the TTL by defining `ttl` as a
+// duration string or a number of seconds.
+func (e *Evaluator) Evaluate(ctx context.Context, input Input) (Decision, error) {
+	results, err := e.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to evaluate policy: %v", err)
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return Decision{}, fmt.Errorf("policy does not define package gta")
+	}
+	doc, ok := results[0].Expressions[0].Value.(map[string]interface{})
+	if !ok {
+		return Decision{}, fmt.Errorf("unexpected policy result %v", results[0].Expressions[0].Value)
+	}
+	return decode(doc)
+}
+
+// decode reads a decision from the gta document
+func decode(doc map[string]interface{}) (Decision, error) {
+	var decision Decision
+	switch deny := doc["deny"].(type) {
+	case nil:
+	case []interface{}:
+		for _, v := range deny {
+			decision.Deny = append(decision.Deny, fmt.Sprint(v))
+		}
+		sort.Strings(decision.Deny)
+	default:
+		return Decision{}, fmt.Errorf("policy deny must be a set of messages, got %v", deny)
+	}
+
+	switch ttl := doc["ttl"].(type) {
+	case nil:
+	case string:
+		d, err := duration.Parse(ttl)
+		if err != nil || d <= 0 {
+			return Decision{}, fmt.Errorf("invalid policy ttl %q", ttl)
+		}
+		decision.TTL = d
+	default:
+		// Numbers are decoded as json.Number
+		seconds, err := parseSeconds(ttl)
+		if err != nil {
+			return Decision{}, err
+		}
+		decision.TTL = seconds
+	}
+	return decision, nil
+}
+
+// parseSeconds parses a positive number of seconds
+func parseSeconds(v interface{}) (time.Duration, error) {
+	var seconds float64
+	if _, err := fmt.Sscan(fmt.Sprint(v), &seconds); err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid policy ttl %v (expected a duration string or seconds)", v)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
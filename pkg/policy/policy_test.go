@@ -0,0 +1,201 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// examples is the directory of the sample policies shipped with gta
+const examples = "../../examples/policies"
+
+func loadExample(t *testing.T, name string) *Evaluator {
+	t.Helper()
+	e, err := Load(context.Background(), filepath.Join(examples, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// weekday is a Wednesday at 10:00 UTC, within business hours
+var weekday = time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+
+func TestProductionPolicy(t *testing.T) {
+	e := loadExample(t, "production.rego")
+	prod := map[string]string{"env": "prod"}
+	for _, tc := range []struct {
+		name     string
+		labels   map[string]string
+		roles    []string
+		reason   string
+		ttl      time.Duration
+		wantDeny []string
+		wantTTL  time.Duration
+	}{
+		{
+			name:   "allowed outside production",
+			labels: map[string]string{"env": "dev"},
+			roles:  []string{"roles/owner"},
+			ttl:    8 * time.Hour,
+		},
+		{
+			name:   "allowed in production with a reason and a short TTL",
+			labels: prod,
+			roles:  []string{"roles/viewer"},
+			reason: "INC-1",
+			ttl:    15 * time.Minute,
+		},
+		{
+			name:     "reason required in production",
+			labels:   prod,
+			roles:    []string{"roles/viewer"},
+			ttl:      15 * time.Minute,
+			wantDeny: []string{"a reason is required in production projects"},
+		},
+		{
+			name:     "owner denied in production",
+			labels:   prod,
+			roles:    []string{"roles/viewer", "roles/owner"},
+			reason:   "INC-1",
+			ttl:      15 * time.Minute,
+			wantDeny: []string{"roles/owner cannot be granted in production projects"},
+		},
+		{
+			name:    "TTL clamped in production",
+			labels:  prod,
+			roles:   []string{"roles/viewer"},
+			reason:  "INC-1",
+			ttl:     4 * time.Hour,
+			wantTTL: 30 * time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			input := NewInput(weekday, tc.ttl)
+			input.Project = "p"
+			input.ProjectLabels = tc.labels
+			input.Roles = tc.roles
+			input.Reason = tc.reason
+			decision, err := e.Evaluate(context.Background(), input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(decision.Deny, "\n") != strings.Join(tc.wantDeny, "\n") {
+				t.Errorf("deny = %q, want %q", decision.Deny, tc.wantDeny)
+			}
+			if decision.Allowed() != (len(tc.wantDeny) == 0) {
+				t.Errorf("Allowed() = %v with deny %q", decision.Allowed(), decision.Deny)
+			}
+			if decision.TTL != tc.wantTTL {
+				t.Errorf("TTL = %v, want %v", decision.TTL, tc.wantTTL)
+			}
+		})
+	}
+}
+
+func TestSelfGrantPolicy(t *testing.T) {
+	e := loadExample(t, "self_grant.rego")
+	for _, tc := range []struct {
+		caller, member string
+		allowed        bool
+	}{
+		{"carol@example.com", "carol@example.com", true},
+		{"carol@example.com", "dave@example.com", false},
+		{"alice@example.com", "dave@example.com", true},
+	} {
+		input := NewInput(weekday, time.Hour)
+		input.Caller, input.Member = tc.caller, tc.member
+		decision, err := e.Evaluate(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Allowed() != tc.allowed {
+			t.Errorf("%s granting %s: allowed %v, want %v (deny %q)", tc.caller, tc.member, decision.Allowed(), tc.allowed, decision.Deny)
+		}
+	}
+}
+
+func TestBusinessHoursPolicy(t *testing.T) {
+	e := loadExample(t, "business_hours.rego")
+	saturday := time.Date(2024, 5, 18, 10, 0, 0, 0, time.UTC)
+	night := time.Date(2024, 5, 15, 22, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name       string
+		now        time.Time
+		breakGlass bool
+		allowed    bool
+	}{
+		{"weekday", weekday, false, true},
+		{"saturday", saturday, false, false},
+		{"night", night, false, false},
+		{"break-glass at night", night, true, true},
+	} {
+		input := NewInput(tc.now, time.Hour)
+		input.BreakGlass = tc.breakGlass
+		decision, err := e.Evaluate(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decision.Allowed() != tc.allowed {
+			t.Errorf("%s: allowed %v, want %v (deny %q)", tc.name, decision.Allowed(), tc.allowed, decision.Deny)
+		}
+	}
+}
+
+// writePolicy writes a policy to a temporary file and loads it
+func writePolicy(t *testing.T, rego string) (*Evaluator, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.rego")
+	if err := os.WriteFile(path, []byte(rego), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(context.Background(), path)
+}
+
+func TestPolicyTTLForms(t *testing.T) {
+	for _, tc := range []struct {
+		rego    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{`package gta
+ttl := "45m"`, 45 * time.Minute, false},
+		{`package gta
+ttl := 90`, 90 * time.Second, false},
+		{`package gta
+ttl := "never"`, 0, true},
+		{`package gta
+ttl := -5`, 0, true},
+		{`package gta
+deny := "not a set"`, 0, true},
+	} {
+		e, err := writePolicy(t, tc.rego)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decision, err := e.Evaluate(context.Background(), NewInput(weekday, time.Hour))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: err = %v, want error %v", tc.rego, err, tc.wantErr)
+			continue
+		}
+		if decision.TTL != tc.want {
+			t.Errorf("%q: TTL = %v, want %v", tc.rego, decision.TTL, tc.want)
+		}
+	}
+}
+
+func TestPolicyLoadErrors(t *testing.T) {
+	if _, err := writePolicy(t, "package gta\ndeny contains"); err == nil {
+		t.Error("invalid Rego loaded")
+	}
+	e, err := writePolicy(t, "package other\nx := 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Evaluate(context.Background(), NewInput(weekday, time.Hour)); err == nil {
+		t.Error("policy without package gta evaluated")
+	}
+}
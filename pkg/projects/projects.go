@@ -0,0 +1,117 @@
+// Package projects caches the projects the caller can see and searches them
+package projects
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// DefaultCacheMaxAge is how long the cached projects list is used before it
+// is refreshed
+const DefaultCacheMaxAge = time.Hour
+
+// cacheFile is the on-disk format of the projects cache
+type cacheFile struct {
+	FetchedAt time.Time              `json:"fetched_at"`
+	Projects  []provider.ProjectInfo `json:"projects"`
+}
+
+// Load returns the projects cached at path when they are younger than
+// maxAge, and otherwise fetches them and refreshes the cache
+func Load(path string, maxAge time.Duration, fetch func() ([]provider.ProjectInfo, error)) ([]provider.ProjectInfo, error) {
+	if cache, ok := readCache(path); ok && time.Since(cache.FetchedAt) < maxAge {
+		return cache.Projects, nil
+	}
+
+	projects, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCache(path, projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// Cached returns the projects cached at path however old, or none when
+// there is no cache
+func Cached(path string) []provider.ProjectInfo {
+	cache, _ := readCache(path)
+	return cache.Projects
+}
+
+// Search returns the projects matching query, best matches first: the
+// project whose ID or number is query, then those whose ID starts with it,
+// and last those whose ID or name contains it. Matching ignores case; an
+// empty query matches every project, sorted by ID.
+func Search(projects []provider.ProjectInfo, query string) []provider.ProjectInfo {
+	query = strings.ToLower(strings.TrimSpace(query))
+	type match struct {
+		project provider.ProjectInfo
+		rank    int
+	}
+	var matches []match
+	for _, project := range projects {
+		id := strings.ToLower(project.ID)
+		rank := -1
+		switch {
+		case query == "", id == query, project.Number == query:
+			rank = 0
+		case strings.HasPrefix(id, query):
+			rank = 1
+		case strings.Contains(id, query):
+			rank = 2
+		case strings.Contains(strings.ToLower(project.Name), query):
+			rank = 3
+		}
+		if rank >= 0 {
+			matches = append(matches, match{project: project, rank: rank})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].project.ID < matches[j].project.ID
+	})
+	found := make([]provider.ProjectInfo, len(matches))
+	for i, m := range matches {
+		found[i] = m.project
+	}
+	return found
+}
+
+// readCache reads the cache at path, reporting whether there is one
+func readCache(path string) (cacheFile, bool) {
+	var cache cacheFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache, false
+	}
+	if err := json.Unmarshal(data, &cache); err != nil || cache.FetchedAt.IsZero() {
+		return cacheFile{}, false
+	}
+	return cache, true
+}
+
+// writeCache stores projects at path
+func writeCache(path string, projects []provider.ProjectInfo) error {
+	data, err := json.Marshal(cacheFile{FetchedAt: time.Now().UTC(), Projects: projects})
+	if err != nil {
+		return fmt.Errorf("failed to encode projects cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write projects cache: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+)
+
+// NameGCP is the name of the Google Cloud provider
+const NameGCP = "gcp"
+
+// Names are the names of the supported providers
+var Names = []string{NameGCP}
+
+// Supported reports whether name is a supported provider
+func Supported(name string) bool {
+	for _, supported := range Names {
+		if name == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// TemporaryAccess is a temporary grant in any provider, such as a GCP
+// binding, in the form shared by all providers
+type TemporaryAccess struct {
+	Provider string `json:"provider"`
+	// Resource is the resource access is granted on, e.g. projects/my-project
+	Resource  string    `json:"resource"`
+	Role      string    `json:"role"`
+	Principal string    `json:"principal"`
+	Expiry    time.Time `json:"expiry"`
+	// ID identifies the grant within its provider, e.g. a binding ID
+	ID string `json:"id"`
+	// GrantedBy is who granted the access when it is not the principal
+	// itself, empty otherwise or when unknown
+	GrantedBy string `json:"granted_by,omitempty"`
+	// MirroredFrom is the principal whose access was copied, see gta grant
+	// --mirror
+	MirroredFrom string `json:"mirrored_from,omitempty"`
+	// Deleted is set when the principal was deleted since it was granted
+	Deleted bool `json:"deleted,omitempty"`
+	// Service is the service the access is confined to, e.g.
+	// storage.googleapis.com
+	Service string `json:"service,omitempty"`
+	// Tags are the tags the resources must carry, e.g.
+	// 123456789012/env=prod
+	Tags []string `json:"tags,omitempty"`
+	// Ownership tells whether the access is of a session running now, the
+	// caller's, or someone else's, and CreatedBy who granted it when known
+	Ownership Ownership `json:"ownership,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+// AccessLister is implemented by providers that can list their temporary
+// access in the shared form
+type AccessLister interface {
+	ListAccess(opts Options) ([]TemporaryAccess, error)
+}
+
+// ListAccess returns the temporary bindings of the project as temporary access
+func (p *GCPProvider) ListAccess(opts Options) ([]TemporaryAccess, error) {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return nil, fmt.Errorf("invalid options type")
+	}
+	bindings, err := p.TemporaryBindings(gcpOpts)
+	if err != nil {
+		return nil, err
+	}
+	access := make([]TemporaryAccess, 0, len(bindings))
+	for _, binding := range bindings {
+		ownership, ok := gcpOpts.owned(binding)
+		if !ok {
+			continue
+		}
+		_, _, deleted := ParseDeletedMember(binding.Member)
+		a := TemporaryAccess{
+			Provider:     NameGCP,
+			Resource:     binding.Resource,
+			Role:         binding.Role,
+			Principal:    binding.Member,
+			Expiry:       binding.Expiry,
+			ID:           binding.BindingID,
+			GrantedBy:    binding.grantedOnBehalf(),
+			MirroredFrom: binding.MirroredFrom,
+			Deleted:      deleted,
+			Service:      binding.Service,
+			Ownership:    ownership,
+			CreatedBy:    binding.CreatedBy,
+		}
+		if len(binding.Tags) > 0 {
+			a.Tags = p.describeTags(binding.Tags)
+		}
+		access = append(access, a)
+	}
+	return access, nil
+}
@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/transport"
+)
+
+// serviceAccountDomain ends the emails of Google service accounts, whether
+// user-managed (iam.gserviceaccount.com) or Google-managed defaults
+const serviceAccountDomain = ".gserviceaccount.com"
+
+// impersonatedCredentialsType is the type of credential files created by
+// gcloud auth application-default login --impersonate-service-account
+const impersonatedCredentialsType = "impersonated_service_account"
+
+// IsServiceAccount reports whether email is the email of a service account
+func IsServiceAccount(email string) bool {
+	return strings.HasSuffix(strings.ToLower(email), serviceAccountDomain)
+}
+
+// ServiceAccountCallerError is returned when gta would modify IAM policies
+// with the credentials of a service account that was not explicitly allowed
+type ServiceAccountCallerError struct {
+	Email string
+}
+
+// Error implements error
+func (e *ServiceAccountCallerError) Error() string {
+	return fmt.Sprintf("refusing to run as the service account %s: it would modify IAM policies, and receive the grant when no --user is given. "+
+		"Application Default Credentials of a deployer account, e.g. on a CI machine, are the usual cause; "+
+		"log in as yourself with gcloud auth application-default login, impersonate a service account explicitly, "+
+		"or pass --allow-service-account-caller (allow_service_account_caller in the config) if this is intended", e.Email)
+}
+
+// CheckCaller resolves the caller and refuses service account credentials
+// unless they are allowed or come from explicit impersonation, and callers
+// missing from the granter roster
+func (p *GCPProvider) CheckCaller() (string, error) {
+	caller, err := p.Caller()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current user: %w", err)
+	}
+	switch {
+	case !IsServiceAccount(caller):
+	case p.allowSACaller:
+		p.log.Debug("Running as the service account %s, which is allowed", caller)
+	case p.impersonating():
+		p.log.Debug("Running as the service account %s through impersonation", caller)
+	default:
+		return "", &ServiceAccountCallerError{Email: caller}
+	}
+	if err := p.checkRoster(); err != nil {
+		return "", err
+	}
+	return caller, nil
+}
+
+// CallerIdentities returns the emails of the caller: the one it runs as and,
+// when the Application Default Credentials impersonate a service account,
+// the account of gcloud impersonating it, which must be known
+func (p *GCPProvider) CallerIdentities() ([]string, error) {
+	caller, err := p.Caller()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+	if !p.impersonating() {
+		return []string{caller}, nil
+	}
+	source, err := gcloudAccount()
+	if err == nil && source == "" {
+		err = fmt.Errorf("no gcloud account is set")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the account impersonating %s: %w", caller, err)
+	}
+	if strings.EqualFold(source, caller) {
+		return []string{caller}, nil
+	}
+	p.log.Debug("Running as the service account %s impersonated by %s", caller, source)
+	return []string{caller, source}, nil
+}
+
+// impersonating reports whether the Application Default Credentials
+// impersonate a service account, which is an explicit choice of the caller
+func (p *GCPProvider) impersonating() bool {
+	creds, err := transport.Creds(p.ctx, p.clientOpts...)
+	if err != nil || len(creds.JSON) == 0 {
+		return false
+	}
+	var file struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(creds.JSON, &file); err != nil {
+		return false
+	}
+	return file.Type == impersonatedCredentialsType
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+const deployer = "deployer@my-project.iam.gserviceaccount.com"
+
+// impersonatedCredentials is an ADC file written by gcloud auth
+// application-default login --impersonate-service-account
+const impersonatedCredentials = `{
+  "delegates": [],
+  "service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/` + deployer + `:generateAccessToken",
+  "source_credentials": {
+    "client_id": "client-id.apps.googleusercontent.com",
+    "client_secret": "client-secret",
+    "refresh_token": "1//0refresh",
+    "type": "authorized_user"
+  },
+  "type": "impersonated_service_account"
+}`
+
+// answerTokens answers the token requests of impersonated credentials
+func answerTokens(m string, r *http.Request, _ []byte) (int, string, bool) {
+	switch {
+	case m == "POST /token":
+		return http.StatusOK, `{"access_token":"source-token","token_type":"Bearer","expires_in":3600}`, true
+	case m == "generateAccessToken":
+		return http.StatusOK, `{"accessToken":"impersonated-token","expireTime":"` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`, true
+	}
+	return 0, "", false
+}
+
+func TestCheckCaller(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		email       string
+		opts        []GCPProviderOption
+		impersonate bool
+		wantErr     bool
+	}{
+		{name: "user", email: "alice@example.com"},
+		{name: "service account", email: deployer, wantErr: true},
+		{name: "Google-managed service account", email: "123456789012-compute@developer.gserviceaccount.com", wantErr: true},
+		{name: "allowed service account", email: deployer, opts: []GCPProviderOption{WithServiceAccountCaller(true)}},
+		{name: "impersonated service account", email: deployer, impersonate: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.email = tc.email
+			fake.fail = answerTokens
+			p := newTestProvider(t, fake, tc.opts...)
+			if tc.impersonate {
+				p = newImpersonatingProvider(t, fake)
+			}
+
+			caller, err := p.CheckCaller()
+			if tc.wantErr {
+				var saErr *ServiceAccountCallerError
+				if !errors.As(err, &saErr) || saErr.Email != tc.email {
+					t.Fatalf("err = %v, want a ServiceAccountCallerError for %s", err, tc.email)
+				}
+				if !strings.Contains(err.Error(), "--allow-service-account-caller") {
+					t.Errorf("error does not name the override: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caller != tc.email {
+				t.Errorf("caller = %s, want %s", caller, tc.email)
+			}
+		})
+	}
+}
+
+// newImpersonatingProvider creates a provider whose Application Default
+// Credentials impersonate deployer
+func newImpersonatingProvider(t *testing.T, f *fakeGCP) *GCPProvider {
+	t.Helper()
+	p, err := NewGCPProvider(context.Background(), false,
+		WithClientOptions(option.WithCredentialsJSON([]byte(impersonatedCredentials))),
+		WithTransport(f),
+		WithClockCorrection(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestGrantAsServiceAccount(t *testing.T) {
+	opts := func() *GCPOptions {
+		return &GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour}
+	}
+
+	// By default the grant would go to the service account itself
+	fake := newFakeGCP(t)
+	fake.email = deployer
+	p := newTestProvider(t, fake)
+	var saErr *ServiceAccountCallerError
+	if err := p.Grant(opts()); !errors.As(err, &saErr) {
+		t.Fatalf("Grant = %v, want a ServiceAccountCallerError", err)
+	}
+	// An explicit member does not make the service account a safe modifier
+	explicit := opts()
+	explicit.User = "alice@example.com"
+	if err := p.Grant(explicit); !errors.As(err, &saErr) {
+		t.Fatalf("Grant to %s = %v, want a ServiceAccountCallerError", explicit.User, err)
+	}
+	if got := fake.Calls("setIamPolicy"); got != 0 {
+		t.Errorf("%d policy updates by a refused service account", got)
+	}
+
+	// Allowed, it grants to the member it is given
+	p = newTestProvider(t, fake, WithServiceAccountCaller(true), WithRetryPolicies(noRetries()))
+	if err := p.Grant(explicit); err != nil {
+		t.Fatal(err)
+	}
+	policy := fake.Policy("p")
+	if len(policy.Bindings) != 1 || policy.Bindings[0].Members[0] != "user:alice@example.com" {
+		t.Errorf("bindings = %+v, want alice only", policy.Bindings)
+	}
+
+	// Impersonation is explicit, so it is allowed without the override
+	fake = newFakeGCP(t)
+	fake.email = deployer
+	fake.fail = answerTokens
+	p = newImpersonatingProvider(t, fake)
+	if err := p.Grant(explicit); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Calls("generateAccessToken"); got == 0 {
+		t.Errorf("impersonated credentials not used")
+	}
+}
@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+)
+
+const (
+	// clockSkewTolerance is the skew below which no correction is applied, as
+	// the Date header only has a resolution of one second
+	clockSkewTolerance = 2 * time.Second
+	// clockSkewWarning is the skew above which a warning is logged
+	clockSkewWarning = 30 * time.Second
+)
+
+// skewClock is the local clock corrected by its offset from Google's clock,
+// measured from the Date header of the first API response
+type skewClock struct {
+	mu       sync.Mutex
+	local    func() time.Time
+	skew     time.Duration
+	measured bool
+	disabled bool
+	log      *logger.Logger
+}
+
+// newSkewClock creates a clock that is not corrected until a response is observed
+func newSkewClock(log *logger.Logger, disabled bool) *skewClock {
+	return &skewClock{local: time.Now, disabled: disabled, log: log}
+}
+
+// Now returns the current time according to Google
+func (c *skewClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabled {
+		return c.local()
+	}
+	return c.local().Add(c.skew)
+}
+
+// Skew returns the measured offset of Google's clock from the local one, and
+// whether it was measured yet
+func (c *skewClock) Skew() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew, c.measured
+}
+
+// observe measures the skew from a response received between sent and
+// received, unless it was measured already
+func (c *skewClock) observe(sent, received time.Time, resp *http.Response) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.measured {
+		return
+	}
+	c.measured = true
+
+	// The server time lies within the second of its Date header, compare
+	// its middle with the middle of the round trip
+	server := date.Add(500 * time.Millisecond)
+	local := sent.Add(received.Sub(sent) / 2)
+	skew := server.Sub(local).Round(time.Second)
+	if skew > -clockSkewTolerance && skew < clockSkewTolerance {
+		return
+	}
+	c.skew = skew
+
+	offset := fmt.Sprintf("%s behind", skew)
+	if skew < 0 {
+		offset = fmt.Sprintf("%s ahead of", -skew)
+	}
+	switch {
+	case skew.Abs() < clockSkewWarning:
+		c.log.Debug("Local clock is %s Google's", offset)
+	case c.disabled:
+		c.log.Warn("Local clock is %s Google's; expiries are not corrected as clock correction is disabled", offset)
+	default:
+		c.log.Warn("Local clock is %s Google's, correcting expiries; fix the system clock, or use --no-clock-correction if the correction is wrong", offset)
+	}
+}
+
+// clockTransport is an http.RoundTripper measuring the clock skew from the
+// responses it receives
+type clockTransport struct {
+	Base  http.RoundTripper
+	Clock *skewClock
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *clockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := t.Clock.local()
+	resp, err := t.Base.RoundTrip(req)
+	if err == nil {
+		t.Clock.observe(sent, t.Clock.local(), resp)
+	}
+	return resp, err
+}
@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// dateTransport answers every request with a Date header of server(), taking
+// latency of the local clock to do so
+type dateTransport struct {
+	server  func() time.Time
+	local   *time.Time
+	latency time.Duration
+}
+
+func (t *dateTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: r}
+	if t.server != nil {
+		resp.Header.Set("Date", t.server().Format(http.TimeFormat))
+	}
+	*t.local = t.local.Add(t.latency)
+	return resp, nil
+}
+
+func TestClockSkew(t *testing.T) {
+	// The server time is half way through the second of its Date header,
+	// where the clock assumes it is
+	base := time.Date(2024, 5, 14, 10, 30, 0, 500*int(time.Millisecond), time.UTC)
+	for _, tc := range []struct {
+		name     string
+		offset   time.Duration // of the server from the local clock
+		latency  time.Duration
+		noDate   bool
+		disabled bool
+		want     time.Duration
+		measured bool
+	}{
+		{name: "in sync", measured: true},
+		{name: "local clock slow", offset: 5 * time.Minute, want: 5 * time.Minute, measured: true},
+		{name: "local clock fast", offset: -5 * time.Minute, want: -5 * time.Minute, measured: true},
+		{name: "within tolerance", offset: time.Second, measured: true},
+		{name: "slow round trip", offset: time.Minute, latency: 4 * time.Second, want: time.Minute, measured: true},
+		{name: "no Date header", offset: time.Hour, noDate: true},
+		{name: "disabled", offset: 5 * time.Minute, disabled: true, want: 5 * time.Minute, measured: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			local := base
+			clock := newSkewClock(logger.Default(), tc.disabled)
+			clock.local = func() time.Time { return local }
+			transport := &dateTransport{local: &local, latency: tc.latency}
+			if !tc.noDate {
+				// The server answers when the request arrives, half way
+				transport.server = func() time.Time { return local.Add(tc.offset + tc.latency/2) }
+			}
+			client := &http.Client{Transport: &clockTransport{Base: transport, Clock: clock}}
+
+			if _, err := client.Get("https://cloudresourcemanager.googleapis.com/v1/projects/p"); err != nil {
+				t.Fatal(err)
+			}
+			skew, measured := clock.Skew()
+			if measured != tc.measured || skew.Round(time.Second) != tc.want {
+				t.Errorf("Skew = %s, %v, want %s, %v", skew, measured, tc.want, tc.measured)
+			}
+
+			want := local.Add(tc.want)
+			if tc.disabled {
+				want = local
+			}
+			if got := clock.Now(); !got.Equal(want) {
+				t.Errorf("Now = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestClockSkewMeasuredOnce(t *testing.T) {
+	local := time.Date(2024, 5, 14, 10, 30, 0, 500*int(time.Millisecond), time.UTC)
+	clock := newSkewClock(logger.Default(), false)
+	clock.local = func() time.Time { return local }
+	offset := 10 * time.Minute
+	transport := &dateTransport{local: &local, server: func() time.Time { return local.Add(offset) }}
+	client := &http.Client{Transport: &clockTransport{Base: transport, Clock: clock}}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.Get("https://oauth2.googleapis.com/tokeninfo"); err != nil {
+			t.Fatal(err)
+		}
+		// Later responses do not move the clock
+		offset = time.Duration(i+1) * time.Hour
+	}
+	if skew, _ := clock.Skew(); skew != 10*time.Minute {
+		t.Errorf("Skew = %s, want the first measurement of 10m", skew)
+	}
+}
+
+// skewedTransport sends requests to a fake and shifts the Date header of its
+// responses by offset
+type skewedTransport struct {
+	base   http.RoundTripper
+	offset time.Duration
+}
+
+func (t *skewedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Set("Date", time.Now().Add(t.offset).UTC().Format(http.TimeFormat))
+	return resp, nil
+}
+
+func TestGrantCorrectsExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		offset     time.Duration
+		correction bool
+		want       time.Duration
+	}{
+		{"slow clock corrected", 10 * time.Minute, true, time.Hour + 10*time.Minute},
+		{"fast clock corrected", -10 * time.Minute, true, time.Hour - 10*time.Minute},
+		{"correction disabled", 10 * time.Minute, false, time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			p := newTestProvider(t, fake, WithTransport(&skewedTransport{base: fake, offset: tc.offset}),
+				WithClockCorrection(tc.correction), WithRetryPolicies(noRetries()))
+			start := time.Now()
+			if err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+			bindings := fake.Policy("p").Bindings
+			if len(bindings) != 1 || bindings[0].Condition == nil {
+				t.Fatalf("bindings = %+v", bindings)
+			}
+			parsed, err := condition.Parse(bindings[0].Condition.Expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parsed.Time.Sub(start); got < tc.want-2*time.Second || got > tc.want+2*time.Second {
+				t.Errorf("expiry in %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
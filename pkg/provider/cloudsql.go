@@ -0,0 +1,429 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
+	sqladmin "google.golang.org/api/sqladmin/v1beta4"
+)
+
+// CloudSQLInstanceUserRole allows logging in to Cloud SQL instances with IAM
+// database authentication
+const CloudSQLInstanceUserRole = "roles/cloudsql.instanceUser"
+
+const (
+	// sqlOperationPoll is how often a SQL Admin operation is polled
+	sqlOperationPoll = time.Second
+	// sqlOperationTimeout bounds waiting for a SQL Admin operation
+	sqlOperationTimeout = 2 * time.Minute
+)
+
+// sqlInstancePattern matches an instance connection name, PROJECT:REGION:INSTANCE
+var sqlInstancePattern = regexp.MustCompile(`^([a-z][a-z0-9-]{4,28}[a-z0-9]):([a-z]+-[a-z]+[0-9]+):([a-z][a-z0-9-]{0,97})$`)
+
+// SQLInstance is a Cloud SQL instance
+type SQLInstance struct {
+	Project string
+	Region  string
+	Name    string
+}
+
+// ParseSQLInstance parses an instance connection name, e.g.
+// my-project:europe-west1:orders
+func ParseSQLInstance(connectionName string) (SQLInstance, error) {
+	m := sqlInstancePattern.FindStringSubmatch(connectionName)
+	if m == nil {
+		return SQLInstance{}, fmt.Errorf("invalid Cloud SQL instance connection name %q (expected PROJECT:REGION:INSTANCE)", connectionName)
+	}
+	return SQLInstance{Project: m[1], Region: m[2], Name: m[3]}, nil
+}
+
+// String returns the connection name of the instance
+func (i SQLInstance) String() string {
+	return i.Project + ":" + i.Region + ":" + i.Name
+}
+
+// Condition returns the condition clause limiting a binding to the instance
+func (i SQLInstance) Condition() string {
+	return fmt.Sprintf(`resource.type == "sqladmin.googleapis.com/Instance" && resource.name == "projects/%s/instances/%s"`, i.Project, i.Name)
+}
+
+// sqlUserType returns the database user type of an IAM member
+func sqlUserType(member string) (string, error) {
+	switch kind, _, _ := strings.Cut(member, ":"); kind {
+	case "user":
+		return "CLOUD_IAM_USER", nil
+	case "serviceAccount":
+		return "CLOUD_IAM_SERVICE_ACCOUNT", nil
+	case "group":
+		return "CLOUD_IAM_GROUP", nil
+	default:
+		return "", fmt.Errorf("member %s cannot have a Cloud SQL IAM database user", member)
+	}
+}
+
+// sqlUserNames returns the names the database user of member may have:
+// PostgreSQL keeps the email, trimming .gserviceaccount.com from service
+// accounts, while MySQL keeps the part before the @
+func sqlUserNames(member string) []string {
+	_, email, _ := strings.Cut(member, ":")
+	names := []string{email}
+	if trimmed := strings.TrimSuffix(email, ".gserviceaccount.com"); trimmed != email {
+		names = append(names, trimmed)
+	}
+	if local, _, ok := strings.Cut(email, "@"); ok {
+		names = append(names, local)
+	}
+	return names
+}
+
+// sqlService returns the SQL Admin service of the provider
+func (p *GCPProvider) sqlService() (*sqladmin.Service, error) {
+	service, err := sqladmin.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SQL Admin service: %w", err)
+	}
+	return service, nil
+}
+
+// findSQLUser returns the database user of member on instance, nil when it
+// has none
+func (p *GCPProvider) findSQLUser(service *sqladmin.Service, instance SQLInstance, member string) (*sqladmin.User, error) {
+	userType, err := sqlUserType(member)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := service.Users.List(instance.Project, instance.Name).Context(p.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("users.list: %w", apiError(err))
+	}
+	names := sqlUserNames(member)
+	for _, user := range resp.Items {
+		if user.Type != userType {
+			continue
+		}
+		for _, name := range names {
+			if user.Name == name {
+				return user, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ensureSQLUser creates the IAM database user of member on instance unless
+// it exists, reporting whether it was created
+func (p *GCPProvider) ensureSQLUser(instance SQLInstance, member string) (bool, error) {
+	service, err := p.sqlService()
+	if err != nil {
+		return false, err
+	}
+	existing, err := p.findSQLUser(service, instance, member)
+	if err != nil {
+		return false, fmt.Errorf("look up the database user of %s on %s: %w", member, instance, err)
+	}
+	if existing != nil {
+		p.log.Info("Database user %s already exists on %s, it is left in place", existing.Name, instance)
+		return false, nil
+	}
+	if p.dryRun {
+		p.log.Info("[DRY-RUN] Would create the IAM database user of %s on %s", member, instance)
+		return false, nil
+	}
+
+	userType, _ := sqlUserType(member)
+	db, err := service.Instances.Get(instance.Project, instance.Name).Context(p.ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("instances.get %s: %w", instance, apiError(err))
+	}
+	names := sqlUserNames(member)
+	name := names[0]
+	if userType == "CLOUD_IAM_SERVICE_ACCOUNT" && !strings.HasPrefix(db.DatabaseVersion, "MYSQL") {
+		name = names[1]
+	}
+	op, err := service.Users.Insert(instance.Project, instance.Name, &sqladmin.User{Name: name, Type: userType}).Context(p.ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("create the database user of %s on %s: users.insert: %w", member, instance, apiError(err))
+	}
+	if err := p.waitSQLOperation(p.ctx, service, instance, op); err != nil {
+		return false, fmt.Errorf("create the database user of %s on %s: %w", member, instance, err)
+	}
+	p.log.Info("Created database user %s on %s", name, instance)
+	return true, nil
+}
+
+// deleteSQLUser deletes the IAM database user of member on instance, if any.
+// It runs with a context that is not cancelled, as it cleans up after
+// interrupted sessions.
+func (p *GCPProvider) deleteSQLUser(instance SQLInstance, member string) error {
+	interrupted := p.ctx
+	p.ctx = context.WithoutCancel(interrupted)
+	defer func() { p.ctx = interrupted }()
+
+	service, err := p.sqlService()
+	if err != nil {
+		return err
+	}
+	user, err := p.findSQLUser(service, instance, member)
+	if err != nil {
+		return fmt.Errorf("look up the database user of %s on %s: %w", member, instance, err)
+	}
+	if user == nil {
+		p.log.Info("Database user of %s on %s is already gone", member, instance)
+		return nil
+	}
+	if p.dryRun {
+		p.log.Info("[DRY-RUN] Would delete database user %s on %s", user.Name, instance)
+		return nil
+	}
+	call := service.Users.Delete(instance.Project, instance.Name).Name(user.Name)
+	if user.Host != "" {
+		call = call.Host(user.Host)
+	}
+	op, err := call.Context(p.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("delete database user %s on %s: users.delete: %w", user.Name, instance, apiError(err))
+	}
+	if err := p.waitSQLOperation(p.ctx, service, instance, op); err != nil {
+		return fmt.Errorf("delete database user %s on %s: %w", user.Name, instance, err)
+	}
+	p.log.Info("Deleted database user %s on %s", user.Name, instance)
+	return nil
+}
+
+// waitSQLOperation waits for a SQL Admin operation to be done
+func (p *GCPProvider) waitSQLOperation(ctx context.Context, service *sqladmin.Service, instance SQLInstance, op *sqladmin.Operation) error {
+	ctx, cancel := context.WithTimeout(ctx, sqlOperationTimeout)
+	defer cancel()
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operation %s: %w", op.Name, ctx.Err())
+		case <-time.After(sqlOperationPoll):
+		}
+		var err error
+		if op, err = service.Operations.Get(instance.Project, op.Name).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("operations.get: %w", apiError(err))
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return fmt.Errorf("operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+// sqlMark returns the connection name of the instance on which binding marks
+// the database user of its member as created by gta, empty for none
+func (p *GCPProvider) sqlMark(binding *resourcemanager.Binding) string {
+	if binding.Condition == nil || !p.temporaryTitle(binding.Condition.Title, true) {
+		return ""
+	}
+	metadata, _ := condition.DecodeMetadata(binding.Condition.Description)
+	return metadata.SQLInstance
+}
+
+// sqlUserStays reports whether a temporary binding of policy that is not
+// being removed still marks the database user of member on instance
+func (p *GCPProvider) sqlUserStays(policy *resourcemanager.Policy, instance, member string, removing map[int]map[string]bool) bool {
+	for i, binding := range policy.Bindings {
+		if p.sqlMark(binding) != instance || removing[i][member] {
+			continue
+		}
+		for _, m := range binding.Members {
+			if m == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// releaseSQLUsers deletes the database users that gta created for the
+// members of the temporary bindings about to be removed from policy, unless a
+// binding staying in policy still marks them. It returns the bindings whose
+// database user could not be deleted.
+func (p *GCPProvider) releaseSQLUsers(policy *resourcemanager.Policy, removing []temporaryBinding) []temporaryBinding {
+	indexes := make(map[int]map[string]bool)
+	for _, binding := range removing {
+		if indexes[binding.Index] == nil {
+			indexes[binding.Index] = make(map[string]bool)
+		}
+		indexes[binding.Index][binding.Member] = true
+	}
+
+	done := make(map[string]bool)
+	failed := make(map[string]bool)
+	for _, binding := range removing {
+		mark := p.sqlMark(policy.Bindings[binding.Index])
+		key := mark + " " + binding.Member
+		if mark == "" || done[key] {
+			continue
+		}
+		done[key] = true
+		if principal, _, ok := ParseDeletedMember(binding.Member); ok {
+			// A database user of that name may belong to a principal of the
+			// same name created since
+			p.log.Warn("Not deleting the database user of deleted %s on %s, delete it by hand unless it belongs to a principal of that name created since", principal, mark)
+			continue
+		}
+		if p.sqlUserStays(policy, mark, binding.Member, indexes) {
+			p.log.Info("Keeping the database user of %s on %s, still used by another temporary binding", binding.Member, mark)
+			continue
+		}
+		instance, err := ParseSQLInstance(mark)
+		if err != nil {
+			p.log.Warn("Ignoring the database user mark of binding %s: %v", binding.BindingID, err)
+			continue
+		}
+		if err := p.deleteSQLUser(instance, binding.Member); err != nil {
+			p.log.Warn("Failed to delete the database user of %s on %s: %v", binding.Member, mark, err)
+			failed[key] = true
+		}
+	}
+
+	var unreleased []temporaryBinding
+	for _, binding := range removing {
+		if failed[p.sqlMark(policy.Bindings[binding.Index])+" "+binding.Member] {
+			unreleased = append(unreleased, binding)
+		}
+	}
+	return unreleased
+}
+
+// sqlUserCommand returns the command deleting the database user of member
+// on the instance named by mark by hand
+func sqlUserCommand(mark, member string) string {
+	instance, err := ParseSQLInstance(mark)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("gcloud sql users delete %s --instance=%s --project=%s", sqlUserNames(member)[0], instance.Name, instance.Project)
+}
+
+// withoutBindings returns bindings less those in exclude
+func withoutBindings(bindings, exclude []temporaryBinding) []temporaryBinding {
+	excluded := make(map[temporaryBinding]bool, len(exclude))
+	for _, binding := range exclude {
+		excluded[binding] = true
+	}
+	var kept []temporaryBinding
+	for _, binding := range bindings {
+		if !excluded[binding] {
+			kept = append(kept, binding)
+		}
+	}
+	return kept
+}
+
+// prepareSQLUser creates the database user of the member of opts on its
+// SQL instance unless it exists. The returned function deletes the user
+// again if Grant granted no role along with it.
+func (p *GCPProvider) prepareSQLUser(opts *GCPOptions, members []string) (func(), error) {
+	if len(members) != 1 {
+		return nil, fmt.Errorf("a Cloud SQL database user is created for a single member, got %d", len(members))
+	}
+	instance, err := ParseSQLInstance(opts.SQLInstance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Project != opts.Project {
+		return nil, fmt.Errorf("Cloud SQL instance %s is not in project %s", instance, opts.Project)
+	}
+	created, err := p.ensureSQLUser(instance, members[0])
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return func() {}, nil
+	}
+	p.sqlUser = instance.String()
+	granted := len(p.grantedRoles)
+	return func() {
+		if len(p.grantedRoles) > granted {
+			return
+		}
+		p.log.Info("No role was granted, deleting the database user created for %s", members[0])
+		if err := p.deleteSQLUser(instance, members[0]); err != nil {
+			p.log.Warn("Failed to delete the database user of %s on %s, delete it by hand: %s: %v", members[0], instance, sqlUserCommand(instance.String(), members[0]), err)
+		}
+		p.sqlUser = ""
+	}, nil
+}
+
+// releaseRevokedSQLUsers deletes the database users created along with the
+// granted roles about to be revoked from the members in remove, returning
+// the policy of target it read, nil if it could not be read. Roles are
+// revoked even if a deletion fails, which is then left to be done by hand.
+func (p *GCPProvider) releaseRevokedSQLUsers(target string, remove map[string]bool) *resourcemanager.Policy {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil
+	}
+	granted := make(map[string]bool, len(p.grantedRoles))
+	for _, grantedRole := range p.grantedRoles {
+		granted[grantedRole.Role+" "+grantedRole.BindingID] = true
+	}
+	var revoking []temporaryBinding
+	for i, binding := range policy.Bindings {
+		if binding.Condition == nil || !granted[binding.Role+" "+binding.Condition.Title] {
+			continue
+		}
+		for _, member := range binding.Members {
+			if remove[member] {
+				revoking = append(revoking, temporaryBinding{Role: binding.Role, Member: member, BindingID: binding.Condition.Title, Index: i})
+			}
+		}
+	}
+	for _, binding := range p.releaseSQLUsers(policy, revoking) {
+		mark := p.sqlMark(policy.Bindings[binding.Index])
+		p.log.Warn("Revoking binding %s anyway, delete the database user of %s on %s by hand: %s", binding.BindingID, binding.Member, mark, sqlUserCommand(mark, binding.Member))
+	}
+	return policy
+}
+
+// sqlUserStates looks up whether the database users marked in temporary
+// bindings exist, once per instance and member
+type sqlUserStates struct {
+	p       *GCPProvider
+	service *sqladmin.Service
+	states  map[string]string
+}
+
+// newSQLUserStates returns an empty cache of database user states
+func (p *GCPProvider) newSQLUserStates() *sqlUserStates {
+	return &sqlUserStates{p: p, states: make(map[string]string)}
+}
+
+// state returns present or missing for the database user of member on the
+// instance named by mark, or why it could not be looked up
+func (s *sqlUserStates) state(mark, member string) string {
+	key := mark + " " + member
+	if state, ok := s.states[key]; ok {
+		return state
+	}
+	state := "unknown"
+	instance, err := ParseSQLInstance(mark)
+	if err == nil && s.service == nil {
+		s.service, err = s.p.sqlService()
+	}
+	if err == nil {
+		var user *sqladmin.User
+		if user, err = s.p.findSQLUser(s.service, instance, member); err == nil {
+			state = "present"
+			if user == nil {
+				state = "missing"
+			}
+		}
+	}
+	if err != nil {
+		s.p.log.Debug("Failed to look up the database user of %s on %s: %v", member, mark, err)
+	}
+	s.states[key] = state
+	return state
+}
@@ -0,0 +1,247 @@
+package provider
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	"github.com/yckao/gta/pkg/logger"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// CompactGroup is a set of temporary bindings of a role whose expiries fall
+// within the compaction window, merged into the one expiring last
+type CompactGroup struct {
+	// Resource is the project or resource whose policy holds the bindings,
+	// e.g. projects/my-project
+	Resource string
+	Role     string
+	// BindingID is the binding kept, which expires at Expiry, the latest
+	// expiry of the group, and holds Members once merged
+	BindingID string
+	Expiry    time.Time
+	Members   []string
+	// Merged are the bindings removed, whose members BindingID now holds
+	Merged []TemporaryBinding
+}
+
+// String describes the group for logs
+func (g CompactGroup) String() string {
+	ids := make([]string, 0, len(g.Merged))
+	for _, b := range g.Merged {
+		ids = append(ids, fmt.Sprintf("%s (%s, expiring %s)", b.BindingID, b.Member, b.Expiry.UTC().Format(time.RFC3339)))
+	}
+	return fmt.Sprintf("role %s on %s: %s into %s, expiring %s, for %s",
+		g.Role, g.Resource, strings.Join(ids, ", "), g.BindingID, g.Expiry.UTC().Format(time.RFC3339), strings.Join(g.Members, ", "))
+}
+
+// compactCandidate is a temporary binding that may be merged with others
+type compactCandidate struct {
+	index  int
+	expiry time.Time
+}
+
+// CompactTemporaryBindings merges, in the policy of the project or resource
+// of opts, the active temporary bindings of each role whose expiries fall
+// within window of the earliest one into the binding expiring last, so that
+// no member loses access earlier than before, and removes the others, in a
+// single write of each policy. Only the bindings of the configured prefix
+// whose condition is nothing but their expiry are merged, leaving out those
+// marking a database user or a manifest and the PinnedBindings of opts. It
+// returns the groups merged, or that would be in a dry run.
+func (p *GCPProvider) CompactTemporaryBindings(opts Options, window time.Duration) ([]CompactGroup, error) {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return nil, fmt.Errorf("invalid options type")
+	}
+	log := p.log.With(slog.String("project", gcpOpts.Project))
+	if _, err := p.CheckCaller(); err != nil {
+		return nil, err
+	}
+
+	var compacted []CompactGroup
+	for _, target := range relatedTargets(gcpOpts.target()) {
+		groups, err := p.compactOnce(log, target, window, gcpOpts.PinnedBindings)
+		if isConflict(err) {
+			err = p.retryConflict(log, target, err, func() error {
+				var err error
+				groups, err = p.compactOnce(log, target, window, gcpOpts.PinnedBindings)
+				return err
+			})
+		}
+		compacted = append(compacted, groups...)
+		if err != nil {
+			return compacted, err
+		}
+	}
+	return compacted, nil
+}
+
+// compactOnce reads the policy of target and merges its temporary bindings
+func (p *GCPProvider) compactOnce(log *logger.Logger, target string, window time.Duration, pinned map[string]bool) ([]CompactGroup, error) {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil, fmt.Errorf("compact %s: %w", describeTarget(target), err)
+	}
+	groups, remove := p.compactGroups(target, policy, window, pinned)
+	if len(groups) == 0 {
+		log.Info("No temporary bindings to compact in %s", describeTarget(target))
+		return nil, nil
+	}
+	merged := 0
+	for _, g := range groups {
+		merged += len(g.Merged)
+		if p.dryRun {
+			log.Info("[DRY-RUN] Would merge %s", g)
+		} else {
+			log.Info("Merging %s", g)
+		}
+	}
+	if p.dryRun {
+		log.Info("[DRY-RUN] Would remove %d of the %d bindings of the policy of %s", merged, len(policy.Bindings), describeTarget(target))
+		return groups, nil
+	}
+
+	kept := make([]*resourcemanager.Binding, 0, len(policy.Bindings)-merged)
+	for i, binding := range policy.Bindings {
+		if !remove[i] {
+			kept = append(kept, binding)
+		}
+	}
+	policy.Bindings = kept
+	if _, err := p.setIAMPolicy(target, policy); err != nil {
+		return nil, fmt.Errorf("compact %s: %w", describeTarget(target), err)
+	}
+	log.Info("Compacted %d temporary binding(s) of %s into %d", merged+len(groups), describeTarget(target), len(groups))
+	return groups, nil
+}
+
+// compactGroups finds the bindings of policy to merge, other than pinned,
+// adding the members of each group to the binding kept, and returns the
+// groups along with the indexes of the bindings to remove
+func (p *GCPProvider) compactGroups(target string, policy *resourcemanager.Policy, window time.Duration, pinned map[string]bool) ([]CompactGroup, map[int]bool) {
+	now := p.now()
+	byRole := make(map[string][]compactCandidate)
+	for i, binding := range policy.Bindings {
+		cond := binding.Condition
+		if cond == nil || !p.temporaryTitle(cond.Title, false) || pinned[cond.Title] {
+			continue
+		}
+		expiry, ok := p.bindingExpiry(cond)
+		if !ok || !expiry.After(now) {
+			continue
+		}
+		// Merging would drop any further condition, and the marks that
+		// clean and apply --prune act on
+		if cond.Expression != condition.Expression(expiry) {
+			continue
+		}
+		if metadata, ok := condition.DecodeMetadata(cond.Description); ok && (metadata.SQLInstance != "" || metadata.Manifest != "") {
+			continue
+		}
+		// Deleted principals cannot be added to another binding
+		if slices.ContainsFunc(binding.Members, func(m string) bool { _, _, deleted := ParseDeletedMember(m); return deleted }) {
+			continue
+		}
+		byRole[binding.Role] = append(byRole[binding.Role], compactCandidate{index: i, expiry: expiry})
+	}
+
+	roles := make([]string, 0, len(byRole))
+	for role := range byRole {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	var groups []CompactGroup
+	remove := make(map[int]bool)
+	for _, role := range roles {
+		candidates := byRole[role]
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].expiry.Before(candidates[j].expiry) })
+		for start := 0; start < len(candidates); {
+			end := start + 1
+			for end < len(candidates) && !candidates[end].expiry.After(candidates[start].expiry.Add(window)) {
+				end++
+			}
+			if end-start > 1 {
+				groups = append(groups, p.mergeGroup(target, policy, candidates[start:end], remove))
+			}
+			start = end
+		}
+	}
+	return groups, remove
+}
+
+// mergeGroup adds the members of the candidates to the last of them, which
+// expires last, marking the others for removal
+func (p *GCPProvider) mergeGroup(target string, policy *resourcemanager.Policy, candidates []compactCandidate, remove map[int]bool) CompactGroup {
+	last := candidates[len(candidates)-1]
+	keep := policy.Bindings[last.index]
+	group := CompactGroup{
+		Resource:  describeTarget(target),
+		Role:      keep.Role,
+		BindingID: keep.Condition.Title,
+		Expiry:    last.expiry,
+	}
+	members := make(map[string]bool)
+	for _, member := range keep.Members {
+		members[member] = true
+	}
+	merged := make([]*resourcemanager.Binding, 0, len(candidates)-1)
+	for _, c := range candidates[:len(candidates)-1] {
+		binding := policy.Bindings[c.index]
+		merged = append(merged, binding)
+		for _, member := range binding.Members {
+			described := p.describeBinding(binding, member)
+			described.Resource = group.Resource
+			group.Merged = append(group.Merged, described)
+			if !members[member] {
+				members[member] = true
+				keep.Members = append(keep.Members, member)
+			}
+		}
+		remove[c.index] = true
+	}
+	group.Members = append([]string(nil), keep.Members...)
+	keep.Condition.Description = mergeMetadata(keep.Condition.Description, merged)
+	return group
+}
+
+// mergeMetadata returns the description of a binding kept once it holds the
+// members of merged as well: the fields describing the grant, such as its
+// caller and session, are only kept when every binding agrees on them
+func mergeMetadata(description string, merged []*resourcemanager.Binding) string {
+	m, ok := condition.DecodeMetadata(description)
+	if !ok {
+		return description
+	}
+	kept := m
+	for _, binding := range merged {
+		other, _ := condition.DecodeMetadata(binding.Condition.Description)
+		if other.By != m.By {
+			m.By = ""
+		}
+		if other.SessionID != m.SessionID {
+			m.SessionID = ""
+		}
+		if other.Reason != m.Reason {
+			m.Reason = ""
+		}
+		if other.MirroredFrom != m.MirroredFrom {
+			m.MirroredFrom = ""
+		}
+		if other.RequestID != m.RequestID || other.Requester != m.Requester || other.Approver != m.Approver {
+			m.RequestID, m.Requester, m.Approver = "", "", ""
+		}
+		if other.BreakGlass != m.BreakGlass || other.Incident != m.Incident {
+			m.BreakGlass, m.Incident = false, ""
+		}
+	}
+	if m == kept {
+		return description
+	}
+	return m.Encode()
+}
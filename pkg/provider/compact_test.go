@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// compactBinding is a temporary binding of roles/viewer, granted in its own
+// session
+type compactBinding struct {
+	id     int
+	member string
+	by     string
+	expiry time.Duration
+}
+
+// compactPolicy returns a policy holding bindings, which expire after their
+// expiry from now
+func compactPolicy(now time.Time, bindings []compactBinding) *resourcemanager.Policy {
+	policy := &resourcemanager.Policy{Version: 3}
+	for _, b := range bindings {
+		metadata := condition.Metadata{By: b.by, SessionID: fmt.Sprintf("s%d", b.id), Reason: "INC-" + b.member}
+		policy.Bindings = append(policy.Bindings, &resourcemanager.Binding{
+			Role:    "roles/viewer",
+			Members: []string{"user:" + b.member},
+			Condition: &resourcemanager.Expr{
+				Title:       fmt.Sprintf("%s_%d", DefaultBindingPrefix, b.id),
+				Description: metadata.Encode(),
+				Expression:  condition.Expression(now.Add(b.expiry)),
+			},
+		})
+	}
+	return policy
+}
+
+// accessUntil returns when each member of policy loses roles/viewer
+func accessUntil(t *testing.T, policy *resourcemanager.Policy) map[string]time.Time {
+	t.Helper()
+	until := make(map[string]time.Time)
+	for _, b := range policy.Bindings {
+		expiry, err := condition.Parse(b.Condition.Expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, member := range b.Members {
+			if expiry.Time.After(until[member]) {
+				until[member] = expiry.Time
+			}
+		}
+	}
+	return until
+}
+
+func TestCompactTemporaryBindings(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	separate := []compactBinding{
+		{1, "alice@example.com", "alice@example.com", time.Hour},
+		{2, "bob@example.com", "bob@example.com", time.Hour + 5*time.Minute},
+		{3, "erin@example.com", "erin@example.com", time.Hour + 8*time.Minute},
+		{4, "carol@example.com", "carol@example.com", time.Hour + 10*time.Minute},
+		{5, "dave@example.com", "dave@example.com", 3 * time.Hour},
+	}
+	byLead := []compactBinding{
+		{1, "alice@example.com", "lead@example.com", time.Hour},
+		{2, "bob@example.com", "lead@example.com", time.Hour + 5*time.Minute},
+	}
+	for _, tc := range []struct {
+		name     string
+		bindings []compactBinding
+		pinned   []int
+		// wantKept is the binding kept and its members, wantLeft the
+		// number of bindings left
+		wantKept    int
+		wantMembers int
+		wantLeft    int
+		// wantBy is the caller the kept binding keeps, if any
+		wantBy string
+	}{
+		{name: "separate sessions", bindings: separate, wantKept: 4, wantMembers: 4, wantLeft: 2},
+		{name: "pinned", bindings: separate, pinned: []int{3}, wantKept: 4, wantMembers: 3, wantLeft: 3},
+		{name: "pinned last", bindings: separate, pinned: []int{4}, wantKept: 3, wantMembers: 3, wantLeft: 3},
+		{name: "one caller", bindings: byLead, wantKept: 2, wantMembers: 2, wantLeft: 1, wantBy: "lead@example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.SetPolicy("p", compactPolicy(now, tc.bindings))
+			before := accessUntil(t, fake.Policy("p"))
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			pinned := make(map[string]bool)
+			for _, id := range tc.pinned {
+				pinned[fmt.Sprintf("%s_%d", DefaultBindingPrefix, id)] = true
+			}
+
+			groups, err := p.CompactTemporaryBindings(&GCPOptions{Project: "p", PinnedBindings: pinned}, 15*time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept := fmt.Sprintf("%s_%d", DefaultBindingPrefix, tc.wantKept)
+			if len(groups) != 1 || groups[0].BindingID != kept || len(groups[0].Members) != tc.wantMembers {
+				t.Fatalf("groups %v, want one into %s with %d members", groups, kept, tc.wantMembers)
+			}
+			for _, merged := range groups[0].Merged {
+				if pinned[merged.BindingID] {
+					t.Errorf("pinned binding %s merged", merged.BindingID)
+				}
+			}
+
+			policy := fake.Policy("p")
+			if len(policy.Bindings) != tc.wantLeft {
+				t.Errorf("%d bindings left, want %d", len(policy.Bindings), tc.wantLeft)
+			}
+			// No member loses access earlier than before
+			after := accessUntil(t, policy)
+			for member, until := range before {
+				if after[member].Before(until) {
+					t.Errorf("%s loses access at %s, before %s", member, after[member], until)
+				}
+			}
+			for _, b := range policy.Bindings {
+				if pinned[b.Condition.Title] && len(b.Members) != 1 {
+					t.Errorf("pinned binding %s holds %v", b.Condition.Title, b.Members)
+				}
+				if b.Condition.Title != kept {
+					continue
+				}
+				// The kept binding describes only what its members share
+				metadata, ok := condition.DecodeMetadata(b.Condition.Description)
+				if !ok || metadata.SessionID != "" || metadata.Reason != "" || metadata.By != tc.wantBy {
+					t.Errorf("kept binding described by %+v, want only by %q", metadata, tc.wantBy)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactedRevoke(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	fake := newFakeGCP(t)
+	fake.SetPolicy("p", compactPolicy(now, []compactBinding{
+		{1, "alice@example.com", "alice@example.com", time.Hour},
+		{2, "bob@example.com", "bob@example.com", time.Hour + 5*time.Minute},
+		{3, "carol@example.com", "carol@example.com", time.Hour + 10*time.Minute},
+	}))
+	p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+	groups, err := p.CompactTemporaryBindings(&GCPOptions{Project: "p"}, 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := groups[0]
+
+	// Revoking the session of the binding kept leaves the members merged
+	// into it, and revoking a merged session by the binding kept only its
+	// member
+	for _, tc := range []struct {
+		user string
+		want string
+	}{
+		{"carol@example.com", "alice@example.com,bob@example.com"},
+		{"alice@example.com", "bob@example.com"},
+	} {
+		p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+		p.AdoptGrantedRoles([]GrantedRole{{Role: "roles/viewer", BindingID: kept.BindingID, Expiry: kept.Expiry}})
+		if err := p.Revoke(&GCPOptions{Project: "p", User: tc.user}); err != nil {
+			t.Fatal(err)
+		}
+		if got := policyMembers(fake, "p"); got != tc.want {
+			t.Errorf("after revoking %s: %s, want %s", tc.user, got, tc.want)
+		}
+	}
+}
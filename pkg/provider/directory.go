@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+// directoryPrefixPattern matches the email prefixes that can be searched for
+// without escaping in a directory query
+var directoryPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9._%+@-]+$`)
+
+// SearchDirectory returns the primary emails of at most limit users of the
+// caller's Google Workspace or Cloud Identity account starting with prefix.
+// The credentials need the admin.directory.user.readonly scope, which
+// application default credentials only have when requested with
+// gcloud auth application-default login --scopes.
+func (p *GCPProvider) SearchDirectory(prefix string, limit int) ([]string, error) {
+	if !directoryPrefixPattern.MatchString(prefix) {
+		return nil, nil
+	}
+
+	client, err := p.newHTTPClient(admin.AdminDirectoryUserReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+	service, err := admin.NewService(p.ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Admin Directory service: %w", err)
+	}
+
+	// domain_public lets users who are not administrators see the directory
+	users, err := service.Users.List().
+		Customer("my_customer").
+		ViewType("domain_public").
+		Query(fmt.Sprintf("email:%s*", prefix)).
+		MaxResults(int64(limit)).
+		Fields("users(primaryEmail)").
+		Context(p.ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("users.list: %w", apiError(err))
+	}
+	emails := make([]string, 0, len(users.Users))
+	for _, user := range users.Users {
+		emails = append(emails, user.PrimaryEmail)
+	}
+	return emails, nil
+}
@@ -0,0 +1,433 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/yckao/gta/pkg/errutil"
+	"github.com/yckao/gta/pkg/logger"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// Error classes used to label failures in metrics
+const (
+	errorClassPermissionDenied = "permission_denied"
+	errorClassNotFound         = "not_found"
+	errorClassConflict         = "conflict"
+	errorClassRateLimited      = "rate_limited"
+	errorClassUnavailable      = "unavailable"
+	errorClassInvalid          = "invalid_argument"
+	errorClassCancelled        = "cancelled"
+	errorClassServiceDisabled  = "service_disabled"
+	errorClassOther            = "other"
+)
+
+// serviceDisabledReasons are the error reasons of calls to a disabled API, in
+// the ErrorInfo detail and the legacy error items respectively
+var serviceDisabledReasons = []string{"SERVICE_DISABLED", "accessNotConfigured"}
+
+// credentialFailures are the OAuth2 error codes of a token endpoint refusing
+// to refresh the credentials, e.g. after a password change or revoked consent
+var credentialFailures = []string{"invalid_grant", "invalid_rapt", "unauthorized_client"}
+
+// serviceURLPattern extracts the service and project from the console link in
+// the message of a disabled API error
+var serviceURLPattern = regexp.MustCompile(`/apis/api/([a-z0-9.-]+)/overview\?project=([a-z0-9-]+)`)
+
+// Unexpected reports whether err is worth a stack trace, i.e. it is neither a
+// cancellation nor a client error reported by a Google API
+func Unexpected(err error) bool {
+	switch errorClass(err) {
+	case errorClassOther, errorClassUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+// PermissionDenied reports whether err is a Google API call refused for
+// missing permissions
+func PermissionDenied(err error) bool {
+	return errorClass(err) == errorClassPermissionDenied
+}
+
+// CredentialsExpired reports whether err is a refusal of the credentials of
+// the caller, which no longer refresh or are no longer accepted, rather than
+// of a permission. Logging in again fixes it.
+func CredentialsExpired(err error) bool {
+	if err == nil {
+		return false
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && slices.Contains(credentialFailures, retrieveErr.ErrorCode) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+		return true
+	}
+	// The token errors of cloud.google.com/go/auth only tell the code in
+	// their message
+	message := err.Error()
+	for _, code := range credentialFailures {
+		if strings.Contains(message, `"`+code+`"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorClass maps an error to a low-cardinality class for metrics
+func errorClass(err error) string {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return errorClassCancelled
+	}
+
+	if _, ok := AsServiceDisabled(err); ok {
+		return errorClassServiceDisabled
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return errorClassOther
+	}
+
+	switch {
+	case apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusUnauthorized:
+		return errorClassPermissionDenied
+	case apiErr.Code == http.StatusNotFound:
+		return errorClassNotFound
+	case apiErr.Code == http.StatusConflict || apiErr.Code == http.StatusPreconditionFailed:
+		return errorClassConflict
+	case apiErr.Code == http.StatusTooManyRequests:
+		return errorClassRateLimited
+	case apiErr.Code == http.StatusBadRequest:
+		return errorClassInvalid
+	case apiErr.Code >= 500:
+		return errorClassUnavailable
+	default:
+		return errorClassOther
+	}
+}
+
+// ServiceDisabledError is a Google API call refused because the API is not
+// enabled in the project the call is billed to
+type ServiceDisabledError struct {
+	// Service is the API name, e.g. cloudresourcemanager.googleapis.com
+	Service string
+	// Title is the display name of the API when known
+	Title string
+	// Project is the number or ID of the project the API must be enabled in
+	Project string
+	Err     error
+}
+
+// Error implements error
+func (e *ServiceDisabledError) Error() string {
+	name := e.Service
+	if e.Title != "" {
+		name = fmt.Sprintf("%s (%s)", e.Title, e.Service)
+	}
+	return fmt.Sprintf("%s is disabled in project %s", name, e.Project)
+}
+
+// Unwrap returns the underlying error
+func (e *ServiceDisabledError) Unwrap() error {
+	return e.Err
+}
+
+// Command returns the gcloud command enabling the service
+func (e *ServiceDisabledError) Command() string {
+	return fmt.Sprintf("gcloud services enable %s --project %s", e.Service, e.Project)
+}
+
+// AsServiceDisabled finds a disabled API error in the chain of err, either
+// already typed or as a Google API error that is yet to be recognized
+func AsServiceDisabled(err error) (*ServiceDisabledError, bool) {
+	var disabled *ServiceDisabledError
+	if errors.As(err, &disabled) {
+		return disabled, true
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+		return nil, false
+	}
+	return parseServiceDisabled(apiErr)
+}
+
+// parseServiceDisabled recognizes a disabled API error by its ErrorInfo
+// detail, falling back to the legacy reason and the console link in the message
+func parseServiceDisabled(apiErr *googleapi.Error) (*ServiceDisabledError, bool) {
+	for _, detail := range apiErr.Details {
+		info, ok := detail.(map[string]interface{})
+		if !ok || !strings.HasSuffix(fmt.Sprint(info["@type"]), "google.rpc.ErrorInfo") || info["reason"] != serviceDisabledReasons[0] {
+			continue
+		}
+		metadata, _ := info["metadata"].(map[string]interface{})
+		service, _ := metadata["service"].(string)
+		consumer, _ := metadata["consumer"].(string)
+		if service == "" || consumer == "" {
+			continue
+		}
+		title, _ := metadata["serviceTitle"].(string)
+		return &ServiceDisabledError{
+			Service: service,
+			Title:   title,
+			Project: strings.TrimPrefix(consumer, "projects/"),
+			Err:     apiErr,
+		}, true
+	}
+
+	disabled := false
+	for _, item := range apiErr.Errors {
+		for _, reason := range serviceDisabledReasons {
+			disabled = disabled || item.Reason == reason
+		}
+	}
+	if !disabled {
+		return nil, false
+	}
+	match := serviceURLPattern.FindStringSubmatch(apiErr.Message)
+	if match == nil {
+		return nil, false
+	}
+	return &ServiceDisabledError{Service: match[1], Project: match[2], Err: apiErr}, true
+}
+
+// apiError annotates an error returned by a Google API call with a stack
+// trace and, for a disabled API, its ServiceDisabledError
+func apiError(err error) error {
+	err = errutil.WithStack(err)
+	if disabled, ok := AsServiceDisabled(err); ok {
+		disabled.Err = err
+		return disabled
+	}
+	return err
+}
+
+// RoleError is a failure to grant or revoke a single role
+type RoleError struct {
+	// Action is the failed operation, grant or revoke
+	Action  string
+	Role    string
+	Project string
+	// Resource names the project or resource the role is granted on, e.g.
+	// buckets/my-bucket, when it may not be Project
+	Resource string
+	// BindingID is the title of the binding of a role that failed to be
+	// revoked
+	BindingID string
+	Err       error
+}
+
+// Error implements error, e.g. "grant roles/viewer on projects/p: setIamPolicy: ..."
+func (e *RoleError) Error() string {
+	return fmt.Sprintf("%s %s on %s: %v", e.Action, e.Role, e.resource(), e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RoleError) Unwrap() error {
+	return e.Err
+}
+
+// Details describes the failure of the role for the JSON output, its message
+// leaving out the role and resource reported apart
+func (e *RoleError) Details() ErrorDetails {
+	d := Details(e.Err)
+	d.Role = e.Role
+	d.Resource = e.resource()
+	return d
+}
+
+// resource names the project or resource of the role, e.g. projects/p
+func (e *RoleError) resource() string {
+	if e.Resource != "" {
+		return e.Resource
+	}
+	return "projects/" + e.Project
+}
+
+// RoleErrors aggregates per-role failures of a multi-role operation while
+// preserving each wrapped error for errors.Is and errors.As
+type RoleErrors []*RoleError
+
+// Error implements error
+func (e RoleErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, roleErr := range e {
+		msgs[i] = roleErr.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual role errors
+func (e RoleErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, roleErr := range e {
+		errs[i] = roleErr
+	}
+	return errs
+}
+
+// Details describes each failure for the JSON output
+func (e RoleErrors) Details() []ErrorDetails {
+	details := make([]ErrorDetails, len(e))
+	for i, roleErr := range e {
+		details[i] = roleErr.Details()
+	}
+	return details
+}
+
+// ByRole returns the failures keyed by role
+func (e RoleErrors) ByRole() map[string]error {
+	byRole := make(map[string]error, len(e))
+	for _, roleErr := range e {
+		byRole[roleErr.Role] = roleErr.Err
+	}
+	return byRole
+}
+
+// ErrorDetails is an error as the JSON output reports it, for automated
+// callers to tell failures apart without parsing their message
+type ErrorDetails struct {
+	// Code is the class of the error, e.g. permission_denied, conflict, or
+	// invalid_argument, other when it is not a Google API error
+	Code string `json:"code"`
+	// HTTPStatus is the status of the failed Google API call, 0 for none
+	HTTPStatus int `json:"http_status,omitempty"`
+	// Reason and Domain are those of the error info the API returned, e.g.
+	// IAM_PERMISSION_DENIED and iam.googleapis.com
+	Reason string `json:"reason,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	// Resource and Role are those of the role that failed, the first one when
+	// several did
+	Resource string `json:"resource,omitempty"`
+	Role     string `json:"role,omitempty"`
+	// Retriable tells whether the same call may succeed when made again, as
+	// for a rate limit, an unavailable service, or a concurrent change
+	Retriable bool `json:"retriable"`
+	// Message is that of the error, with the values masked in the logs
+	// hidden
+	Message string `json:"message"`
+}
+
+// Details describes err for the JSON output from the typed errors and the
+// Google API error in its chain
+func Details(err error) ErrorDetails {
+	class := errorClass(err)
+	d := ErrorDetails{
+		Code:    class,
+		Message: logger.Redact(err.Error()),
+	}
+	switch class {
+	case errorClassRateLimited, errorClassUnavailable, errorClassConflict:
+		d.Retriable = true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		d.HTTPStatus = apiErr.Code
+		d.Reason, d.Domain = errorReason(apiErr)
+	}
+	var roleErr *RoleError
+	if errors.As(err, &roleErr) {
+		d.Role = roleErr.Role
+		d.Resource = roleErr.resource()
+	}
+	if disabled, ok := AsServiceDisabled(err); ok && d.Resource == "" {
+		d.Resource = "projects/" + disabled.Project
+	}
+	return d
+}
+
+// errorReason returns the reason and domain of the ErrorInfo detail of
+// apiErr, falling back to the reason of its legacy error items
+func errorReason(apiErr *googleapi.Error) (string, string) {
+	for _, detail := range apiErr.Details {
+		info, ok := detail.(map[string]interface{})
+		if !ok || !strings.HasSuffix(fmt.Sprint(info["@type"]), "google.rpc.ErrorInfo") {
+			continue
+		}
+		reason, _ := info["reason"].(string)
+		domain, _ := info["domain"].(string)
+		return reason, domain
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason != "" {
+			return item.Reason, ""
+		}
+	}
+	return "", ""
+}
+
+// FailedRoles returns every role failure in the chain of err, none when err
+// is not a failure of roles
+func FailedRoles(err error) RoleErrors {
+	var failed RoleErrors
+	var walk func(err error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case *RoleError:
+			failed = append(failed, e)
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	walk(err)
+	return failed
+}
+
+// PartialFailurePolicy decides whether a multi-role operation in which some
+// roles failed is reported as an error
+type PartialFailurePolicy string
+
+const (
+	// PartialFailureAllow reports an error only when no role succeeded; failures
+	// of individual roles are logged as warnings. This is the default. It
+	// applies to grants only: a revocation reports any role it failed to
+	// revoke, for the role to be revoked again.
+	PartialFailureAllow PartialFailurePolicy = "allow"
+	// PartialFailureFail reports an error when any role failed
+	PartialFailureFail PartialFailurePolicy = "fail"
+)
+
+// ParsePartialFailurePolicy parses a string into a PartialFailurePolicy value
+func ParsePartialFailurePolicy(policy string) (PartialFailurePolicy, error) {
+	switch PartialFailurePolicy(strings.ToLower(policy)) {
+	case PartialFailureAllow, "":
+		return PartialFailureAllow, nil
+	case PartialFailureFail:
+		return PartialFailureFail, nil
+	default:
+		return PartialFailureAllow, fmt.Errorf("invalid partial failure policy: %s", policy)
+	}
+}
+
+// check applies the policy to the outcome of an operation on total roles
+func (policy PartialFailurePolicy) check(log *logger.Logger, action string, errs RoleErrors, total int) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == total {
+		return fmt.Errorf("failed to %s any roles: %w", action, errs)
+	}
+	if policy == PartialFailureFail {
+		return fmt.Errorf("failed to %s some roles: %w", action, errs)
+	}
+	if logger.CurrentFormat() == logger.FormatJSON {
+		log.WarnAttrs(fmt.Sprintf("Failed to %s some roles", action), slog.Any("failed", errs.Details()))
+		return nil
+	}
+	log.Warn("Failed to %s some roles: %v", action, errs)
+	return nil
+}
@@ -0,0 +1,367 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/errutil"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// failWith answers calls of method with status and an API error body
+func failWith(method string, status int, body string) func(string, *http.Request, []byte) (int, string, bool) {
+	return func(m string, r *http.Request, _ []byte) (int, string, bool) {
+		return status, body, m == method
+	}
+}
+
+func TestGrantErrorChain(t *testing.T) {
+	for _, tc := range []struct {
+		method    string
+		status    int
+		body      string
+		wantClass string
+		wantMsg   string
+	}{
+		{
+			method:    "setIamPolicy",
+			status:    http.StatusConflict,
+			body:      `{"error":{"code":409,"message":"There were concurrent policy changes.","status":"ABORTED"}}`,
+			wantClass: errorClassConflict,
+			wantMsg:   "grant roles/viewer on projects/p: setIamPolicy: googleapi: Error 409",
+		},
+		{
+			method:    "getIamPolicy",
+			status:    http.StatusForbidden,
+			body:      `{"error":{"code":403,"message":"The caller does not have permission","status":"PERMISSION_DENIED"}}`,
+			wantClass: errorClassPermissionDenied,
+			wantMsg:   "grant roles/viewer on projects/p: getIamPolicy: googleapi: Error 403",
+		},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.fail = failWith(tc.method, tc.status, tc.body)
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"})
+			if err == nil {
+				t.Fatal("Grant succeeded")
+			}
+
+			var roleErrs RoleErrors
+			if !errors.As(err, &roleErrs) || len(roleErrs) != 1 {
+				t.Fatalf("err = %#v, want RoleErrors with one failure", err)
+			}
+			var roleErr *RoleError
+			if !errors.As(err, &roleErr) || roleErr.Action != "grant" || roleErr.Role != "roles/viewer" || roleErr.Project != "p" {
+				t.Errorf("RoleError = %+v", roleErr)
+			}
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tc.status {
+				t.Errorf("googleapi.Error = %v, want code %d", apiErr, tc.status)
+			}
+			if !strings.HasPrefix(roleErr.Error(), tc.wantMsg) {
+				t.Errorf("message = %q, want prefix %q", roleErr.Error(), tc.wantMsg)
+			}
+			if class := errorClass(err); class != tc.wantClass {
+				t.Errorf("class = %s, want %s", class, tc.wantClass)
+			}
+			if Unexpected(err) {
+				t.Errorf("API client error reported as unexpected")
+			}
+
+			// Each level of context goes on its own line
+			lines := strings.Split(errutil.Format(roleErr), "\n")
+			want := []string{"grant roles/viewer on projects/p", "  " + tc.method}
+			if len(lines) < 3 || lines[0] != want[0] || lines[1] != want[1] || !strings.HasPrefix(lines[2], "    googleapi: Error") {
+				t.Errorf("Format =\n%s", strings.Join(lines, "\n"))
+			}
+		})
+	}
+}
+
+func TestRevokePartlyFailed(t *testing.T) {
+	fake := newFakeGCP(t)
+	p := newTestProvider(t, fake, WithRetryPolicies(noRetries()), WithPartialFailurePolicy(PartialFailureAllow))
+	opts := &GCPOptions{Project: "p", Roles: []string{"viewer", "browser"}, TTL: time.Hour, User: "alice@example.com"}
+	if err := p.Grant(opts); err != nil {
+		t.Fatal(err)
+	}
+	// The second policy update, revoking roles/browser, fails
+	var writes atomic.Int32
+	fake.fail = func(method string, r *http.Request, _ []byte) (int, string, bool) {
+		return http.StatusServiceUnavailable, `{"error":{"code":503,"message":"unavailable","status":"UNAVAILABLE"}}`, method == "setIamPolicy" && writes.Add(1) == 2
+	}
+
+	// The roles left bound are reported even though partial failures of
+	// grants are allowed
+	err := p.Revoke(opts)
+	failed := FailedRoles(err)
+	if len(failed) != 1 || failed[0].Role != "roles/browser" || failed[0].BindingID != p.GrantedRoles()[1].BindingID {
+		t.Fatalf("Revoke = %v, want roles/browser failed", err)
+	}
+	if bindings := fake.Policy("p").Bindings; len(bindings) != 1 || bindings[0].Role != "roles/browser" {
+		t.Errorf("bindings left %+v, want that of roles/browser", bindings)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&googleapi.Error{Code: 429}, errorClassRateLimited},
+		{&googleapi.Error{Code: 503}, errorClassUnavailable},
+		{&googleapi.Error{Code: 404}, errorClassNotFound},
+		{&googleapi.Error{Code: 400}, errorClassInvalid},
+		{errors.New("connection reset"), errorClassOther},
+	} {
+		wrapped := &RoleError{Action: "revoke", Role: "roles/viewer", Project: "p", Err: tc.err}
+		if class := errorClass(RoleErrors{wrapped}); class != tc.want {
+			t.Errorf("%v: class %s, want %s", tc.err, class, tc.want)
+		}
+	}
+}
+
+// readPayload reads an error body captured from a Google API
+func readPayload(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestServiceDisabled(t *testing.T) {
+	for _, tc := range []struct {
+		payload     string
+		wantService string
+		wantTitle   string
+		wantProject string
+	}{
+		{"service_disabled.json", "secretmanager.googleapis.com", "Secret Manager API", "123456789012"},
+		{"access_not_configured.json", "cloudresourcemanager.googleapis.com", "", "my-quota-project"},
+		{"permission_denied.json", "", "", ""},
+		{"billing_disabled.json", "", "", ""},
+	} {
+		t.Run(tc.payload, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.fail = failWith("getIamPolicy", http.StatusForbidden, readPayload(t, tc.payload))
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"})
+			if err == nil {
+				t.Fatal("Grant succeeded")
+			}
+
+			disabled, ok := AsServiceDisabled(err)
+			if tc.wantService == "" {
+				if ok {
+					t.Fatalf("%v recognized as a disabled API: %+v", err, disabled)
+				}
+				if class := errorClass(err); class != errorClassPermissionDenied {
+					t.Errorf("class = %s, want %s", class, errorClassPermissionDenied)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("err = %v, want a disabled API", err)
+			}
+			if disabled.Service != tc.wantService || disabled.Title != tc.wantTitle || disabled.Project != tc.wantProject {
+				t.Errorf("disabled = %+v", disabled)
+			}
+			want := "gcloud services enable " + tc.wantService + " --project " + tc.wantProject
+			if disabled.Command() != want {
+				t.Errorf("Command = %q, want %q", disabled.Command(), want)
+			}
+			// The typed error is in the chain, with the API error under it
+			var typed *ServiceDisabledError
+			var apiErr *googleapi.Error
+			if !errors.As(err, &typed) || !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+				t.Errorf("err = %#v, want a ServiceDisabledError wrapping the API error", err)
+			}
+			if class := errorClass(err); class != errorClassServiceDisabled {
+				t.Errorf("class = %s, want %s", class, errorClassServiceDisabled)
+			}
+			if d := Details(err); d.HTTPStatus != http.StatusForbidden || d.Reason != "SERVICE_DISABLED" && d.Reason != "accessNotConfigured" {
+				t.Errorf("Details = %+v", d)
+			}
+		})
+	}
+}
+
+func TestCredentialsExpired(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&oauth2.RetrieveError{ErrorCode: "invalid_grant"}, true},
+		{&oauth2.RetrieveError{ErrorCode: "invalid_rapt"}, true},
+		{&oauth2.RetrieveError{ErrorCode: "unauthorized_client"}, true},
+		{&oauth2.RetrieveError{ErrorCode: "temporarily_unavailable"}, false},
+		{&googleapi.Error{Code: http.StatusUnauthorized}, true},
+		{&googleapi.Error{Code: http.StatusForbidden}, false},
+		{errors.New(`auth: cannot fetch token: 400 Response: {"error":"invalid_grant","error_description":"Bad Request"}`), true},
+		{errors.New("connection reset"), false},
+		{&RoleError{Action: "revoke", Role: "roles/viewer", Project: "p", Err: &oauth2.RetrieveError{ErrorCode: "invalid_grant"}}, true},
+	} {
+		if got := CredentialsExpired(tc.err); got != tc.want {
+			t.Errorf("CredentialsExpired(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// refusingTokens answers the token requests of impersonated credentials with
+// tokens expiring at once, so that every call fetches one, until refused is
+// set, from which on the refresh token is refused as revoked
+func refusingTokens(refused *atomic.Bool) func(string, *http.Request, []byte) (int, string, bool) {
+	return func(m string, r *http.Request, _ []byte) (int, string, bool) {
+		switch {
+		case m == "POST /token" && refused.Load():
+			return http.StatusBadRequest, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`, true
+		case m == "POST /token":
+			return http.StatusOK, `{"access_token":"source-token","token_type":"Bearer","expires_in":1}`, true
+		case m == "generateAccessToken":
+			return http.StatusOK, `{"accessToken":"impersonated-token","expireTime":"` + time.Now().Add(time.Second).UTC().Format(time.RFC3339) + `"}`, true
+		}
+		return 0, "", false
+	}
+}
+
+func TestTokenFailure(t *testing.T) {
+	for _, phase := range []string{"caller", "grant", "revoke"} {
+		t.Run(phase, func(t *testing.T) {
+			var refused atomic.Bool
+			fake := newFakeGCP(t)
+			fake.email = "alice@example.com"
+			fake.fail = refusingTokens(&refused)
+			p := newImpersonatingProvider(t, fake)
+			opts := &GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"}
+
+			refused.Store(phase == "caller")
+			if _, err := p.Caller(); phase == "caller" {
+				if !CredentialsExpired(err) {
+					t.Fatalf("Caller = %v, want expired credentials", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			refused.Store(phase == "grant")
+			err := p.Grant(opts)
+			if phase == "grant" {
+				if !CredentialsExpired(err) {
+					t.Fatalf("Grant = %v, want expired credentials", err)
+				}
+				if got := fake.Calls("setIamPolicy"); got != 0 {
+					t.Errorf("%d writes without credentials", got)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			// The binding stays until the user logs in again
+			refused.Store(true)
+			if err := p.Revoke(opts); !CredentialsExpired(err) {
+				t.Fatalf("Revoke = %v, want expired credentials", err)
+			}
+			if got := policyMembers(fake, "p"); got != "alice@example.com" {
+				t.Fatalf("left %q after the failed revocation, want the binding", got)
+			}
+			refused.Store(false)
+			retry := newImpersonatingProvider(t, fake)
+			retry.AdoptGrantedRoles(p.GrantedRoles())
+			if err := retry.Revoke(opts); err != nil {
+				t.Fatalf("Revoke once logged in again = %v", err)
+			}
+			if got := policyMembers(fake, "p"); got != "" {
+				t.Errorf("left %q", got)
+			}
+		})
+	}
+}
+
+func TestErrorDetailsJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		payload string
+		// want is the JSON of the details but for their message, which
+		// must contain wantMsg
+		want    string
+		wantMsg string
+	}{
+		{
+			name:    "permission denied",
+			status:  http.StatusForbidden,
+			payload: "iam_permission_denied.json",
+			want: `{"code":"permission_denied","http_status":403,"reason":"IAM_PERMISSION_DENIED",` +
+				`"domain":"cloudresourcemanager.googleapis.com","resource":"projects/p","role":"roles/viewer","retriable":false}`,
+			wantMsg: "Error 403",
+		},
+		{
+			name:    "concurrent change",
+			status:  http.StatusConflict,
+			payload: "concurrent_policy_change.json",
+			want:    `{"code":"conflict","http_status":409,"resource":"projects/p","role":"roles/viewer","retriable":true}`,
+			wantMsg: "Error 409",
+		},
+		{
+			name:    "invalid argument",
+			status:  http.StatusBadRequest,
+			payload: "invalid_argument.json",
+			want:    `{"code":"invalid_argument","http_status":400,"resource":"projects/p","role":"roles/viewer","retriable":false}`,
+			wantMsg: "policy.bindings[0].condition.expression",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.fail = failWith("setIamPolicy", tc.status, readPayload(t, tc.payload))
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"})
+			if err == nil {
+				t.Fatal("grant succeeded")
+			}
+			var want map[string]any
+			if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatal(err)
+			}
+
+			// The details of the error and of the role that failed share
+			// their fields
+			roles := FailedRoles(err).Details()
+			if len(roles) != 1 {
+				t.Fatalf("details of %d roles, want 1", len(roles))
+			}
+			for _, d := range []ErrorDetails{Details(err), roles[0]} {
+				data, err := json.Marshal(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got map[string]any
+				if err := json.Unmarshal(data, &got); err != nil {
+					t.Fatal(err)
+				}
+				msg, _ := got["message"].(string)
+				if !strings.Contains(msg, tc.wantMsg) {
+					t.Errorf("message %q, want it to contain %q", msg, tc.wantMsg)
+				}
+				delete(got, "message")
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("details\n%s\nwant\n%s", data, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// fuzzyExpiryTolerance is how far the expiry of a binding may be from the
+// expiry recorded for it and still match
+const fuzzyExpiryTolerance = time.Minute
+
+// Strategies matching a binding gta created in a policy
+const (
+	// MatchTitle matches the binding ID in the title of the condition
+	MatchTitle = "title"
+	// MatchFuzzy matches the member, role, and expiry of a binding whose
+	// title was rewritten by another tool
+	MatchFuzzy = "member, role, and expiry"
+)
+
+// WithFuzzyMatch allows removing bindings found by member, role, and expiry
+// when their title no longer matches, which otherwise needs confirmation
+func WithFuzzyMatch(allowed bool) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.fuzzyMatch = allowed
+	}
+}
+
+// ExpectedBinding is a binding gta recorded creating, e.g. in the state of a
+// session
+type ExpectedBinding struct {
+	// Target is the project ID or resource name whose policy holds it
+	Target    string
+	Role      string
+	Member    string
+	BindingID string
+	Expiry    time.Time
+}
+
+// FuzzyMatch is a binding found by member, role, and expiry in place of an
+// expected binding whose title is gone
+type FuzzyMatch struct {
+	Expected ExpectedBinding
+	// Title is the title the condition carries now, maybe empty
+	Title      string
+	Expression string
+}
+
+// String describes the match for logs and confirmations
+func (m FuzzyMatch) String() string {
+	return fmt.Sprintf("binding %s of %s for role %s in %s, now titled %q with %q",
+		m.Expected.BindingID, m.Expected.Member, m.Expected.Role, describeTarget(m.Expected.Target), m.Title, m.Expression)
+}
+
+// fuzzyCandidates returns the indexes of the bindings of policy that may be
+// the binding of role for members expiring at expiry after its title was
+// rewritten. Only a binding whose condition is nothing but an expiry within
+// the tolerance, that holds every member, and whose title is not that of
+// another temporary binding qualifies; bindings without conditions never do.
+func (p *GCPProvider) fuzzyCandidates(policy *resourcemanager.Policy, role string, members []string, expiry time.Time) []int {
+	var candidates []int
+	for i, binding := range policy.Bindings {
+		if binding.Role != role || binding.Condition == nil || p.temporaryTitle(binding.Condition.Title, true) {
+			continue
+		}
+		parsed, err := condition.Parse(binding.Condition.Expression)
+		if err != nil || parsed.Confidence != condition.Exact {
+			continue
+		}
+		if diff := parsed.Time.Sub(expiry); diff > fuzzyExpiryTolerance || diff < -fuzzyExpiryTolerance {
+			continue
+		}
+		if !holdsMembers(binding, members) {
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+	return candidates
+}
+
+// holdsMembers reports whether binding holds every one of members
+func holdsMembers(binding *resourcemanager.Binding, members []string) bool {
+	held := make(map[string]bool, len(binding.Members))
+	for _, m := range binding.Members {
+		held[m] = true
+	}
+	for _, m := range members {
+		if !held[m] {
+			return false
+		}
+	}
+	return len(members) > 0
+}
+
+// fuzzyIndex finds the binding of a granted role whose title no longer
+// matches, returning -1 when there is none, more than one, or its removal
+// is neither allowed nor confirmed; err tells the caller why the binding
+// was left in place
+func (p *GCPProvider) fuzzyIndex(target string, policy *resourcemanager.Policy, role GrantedRole, members []string) (int, error) {
+	candidates := p.fuzzyCandidates(policy, role.Role, members, role.Expiry)
+	switch len(candidates) {
+	case 0:
+		return -1, nil
+	case 1:
+	default:
+		return -1, fmt.Errorf("binding %s not found by its title, and %d bindings of role %s match by %s; remove the right one by hand", role.BindingID, len(candidates), role.Role, MatchFuzzy)
+	}
+	binding := policy.Bindings[candidates[0]]
+	match := FuzzyMatch{
+		Expected:   ExpectedBinding{Target: target, Role: role.Role, Member: members[0], BindingID: role.BindingID, Expiry: role.Expiry},
+		Title:      binding.Condition.Title,
+		Expression: binding.Condition.Expression,
+	}
+	if !p.fuzzyMatch {
+		confirmed := false
+		if p.confirm != nil {
+			p.log.Warn("Not found by its title, matched by %s: %s", MatchFuzzy, match)
+			var err error
+			if confirmed, err = p.confirm("Remove this binding?"); err != nil {
+				return -1, err
+			}
+		}
+		if !confirmed {
+			return -1, fmt.Errorf("not found by its title, only matched by %s: %s; revoke it with --fuzzy-match", MatchFuzzy, match)
+		}
+	}
+	p.log.Info("Matched %s by %s", match, MatchFuzzy)
+	return candidates[0], nil
+}
+
+// FuzzyMatches finds the expected bindings of target that are no longer
+// found by their title but match a single binding by member, role, and
+// expiry. A binding matching several expected bindings is left out.
+func (p *GCPProvider) FuzzyMatches(target string, expected []ExpectedBinding) ([]FuzzyMatch, error) {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[string]bool, len(policy.Bindings))
+	for _, binding := range policy.Bindings {
+		if binding.Condition != nil {
+			titles[binding.Condition.Title] = true
+		}
+	}
+
+	claimed := make(map[int]int)
+	found := make(map[int]int)
+	for i, e := range expected {
+		if titles[e.BindingID] {
+			continue
+		}
+		candidates := p.fuzzyCandidates(policy, e.Role, []string{e.Member}, e.Expiry)
+		if len(candidates) != 1 {
+			if len(candidates) > 1 {
+				p.log.Warn("Binding %s of role %s matches %d bindings by %s, leaving them", e.BindingID, e.Role, len(candidates), MatchFuzzy)
+			}
+			continue
+		}
+		claimed[candidates[0]]++
+		found[i] = candidates[0]
+	}
+
+	var matches []FuzzyMatch
+	for i, e := range expected {
+		index, ok := found[i]
+		if !ok || claimed[index] > 1 {
+			continue
+		}
+		binding := policy.Bindings[index]
+		matches = append(matches, FuzzyMatch{Expected: e, Title: binding.Condition.Title, Expression: binding.Condition.Expression})
+	}
+	return matches, nil
+}
+
+// RemoveFuzzyMatches removes the members of matches from the bindings of
+// target they were matched to, returning the matches removed. A binding
+// that changed since it was matched is left in place.
+func (p *GCPProvider) RemoveFuzzyMatches(target string, matches []FuzzyMatch) ([]FuzzyMatch, error) {
+	if p.dryRun || len(matches) == 0 {
+		return matches, nil
+	}
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil, err
+	}
+	remove := make(map[int]map[string]bool)
+	var removed []FuzzyMatch
+	for _, m := range matches {
+		candidates := p.fuzzyCandidates(policy, m.Expected.Role, []string{m.Expected.Member}, m.Expected.Expiry)
+		if len(candidates) != 1 || policy.Bindings[candidates[0]].Condition.Title != m.Title {
+			p.log.Warn("Leaving %s as it changed since it was matched", m)
+			continue
+		}
+		if remove[candidates[0]] == nil {
+			remove[candidates[0]] = make(map[string]bool)
+		}
+		remove[candidates[0]][m.Expected.Member] = true
+		removed = append(removed, m)
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	policy.Bindings = removeMembers(policy.Bindings, remove)
+	if _, err := p.setIAMPolicy(target, policy); err != nil {
+		return nil, err
+	}
+	for _, m := range removed {
+		p.log.Info("Removed %s, matched by %s", m, MatchFuzzy)
+	}
+	return removed, nil
+}
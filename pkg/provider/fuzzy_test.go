@@ -0,0 +1,219 @@
+package provider
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// bystanders are bindings of the role and member fuzzy matching looks for
+// that it must never match: a permanent one and the binding of another tool
+func bystanders(role, member string, expiry time.Time) []*resourcemanager.Binding {
+	return []*resourcemanager.Binding{
+		{Role: role, Members: []string{member}},
+		{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: "office hours", Expression: `request.time.getHours("Europe/Berlin") < 18`}},
+		{Role: "roles/owner", Members: []string{"user:owner@example.com"}},
+		{Role: role, Members: []string{"user:bob@example.com"}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: condition.Expression(expiry)}},
+	}
+}
+
+func TestFuzzyCandidates(t *testing.T) {
+	const role, member = "roles/viewer", "user:alice@example.com"
+	expiry := time.Date(2024, 5, 14, 10, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		binding *resourcemanager.Binding
+		want    bool
+	}{
+		{
+			name:    "rewritten title",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: "expires_2024_05_14", Expression: condition.Expression(expiry)}},
+			want:    true,
+		},
+		{
+			name:    "no title",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Expression: condition.Expression(expiry.Add(30 * time.Second))}},
+			want:    true,
+		},
+		{
+			name:    "shared with other members",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{"user:bob@example.com", member}, Condition: &resourcemanager.Expr{Title: "merged", Expression: condition.Expression(expiry)}},
+			want:    true,
+		},
+		{
+			name:    "without a condition",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}},
+		},
+		{
+			name:    "another temporary binding",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: DefaultBindingPrefix + "_1715679000000000000", Expression: condition.Expression(expiry)}},
+		},
+		{
+			name:    "another role",
+			binding: &resourcemanager.Binding{Role: "roles/editor", Members: []string{member}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: condition.Expression(expiry)}},
+		},
+		{
+			name:    "another member",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{"user:alice@example.org"}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: condition.Expression(expiry)}},
+		},
+		{
+			name:    "expiry past the tolerance",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: condition.Expression(expiry.Add(fuzzyExpiryTolerance + time.Second))}},
+		},
+		{
+			name:    "expiry before the tolerance",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: condition.Expression(expiry.Add(-fuzzyExpiryTolerance - time.Second))}},
+		},
+		{
+			name:    "more than an expiry",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: condition.Expression(expiry) + ` && resource.name.startsWith("projects/_/buckets/logs")`}},
+		},
+		{
+			name:    "an expression that does not parse",
+			binding: &resourcemanager.Binding{Role: role, Members: []string{member}, Condition: &resourcemanager.Expr{Title: "rewritten", Expression: `request.time < timestamp("`}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProvider(t, newFakeGCP(t))
+			policy := &resourcemanager.Policy{Bindings: append(bystanders(role, member, expiry), tc.binding)}
+			got := p.fuzzyCandidates(policy, role, []string{member}, expiry)
+			want := []int(nil)
+			if tc.want {
+				want = []int{len(policy.Bindings) - 1}
+			}
+			if len(got) != len(want) || len(got) == 1 && got[0] != want[0] {
+				t.Errorf("fuzzyCandidates = %v, want %v", got, want)
+			}
+		})
+	}
+
+	// No members match nothing
+	p := newTestProvider(t, newFakeGCP(t))
+	policy := &resourcemanager.Policy{Bindings: bystanders(role, member, expiry)}
+	if got := p.fuzzyCandidates(policy, role, nil, expiry); len(got) != 0 {
+		t.Errorf("fuzzyCandidates without members = %v", got)
+	}
+}
+
+func TestFuzzyRevoke(t *testing.T) {
+	const role, member = "roles/viewer", "user:alice@example.com"
+	errDeclined := errors.New("no terminal")
+	for _, tc := range []struct {
+		name       string
+		fuzzyMatch bool
+		confirm    ConfirmFunc
+		removed    bool
+		wantErr    string
+	}{
+		{name: "not allowed", wantErr: "revoke it with --fuzzy-match"},
+		{name: "declined", confirm: func(string) (bool, error) { return false, nil }, wantErr: "revoke it with --fuzzy-match"},
+		{name: "confirmation failed", confirm: func(string) (bool, error) { return false, errDeclined }, wantErr: errDeclined.Error()},
+		{name: "confirmed", confirm: func(string) (bool, error) { return true, nil }, removed: true},
+		{name: "--fuzzy-match", fuzzyMatch: true, removed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			opts := []GCPProviderOption{WithRetryPolicies(noRetries()), WithFuzzyMatch(tc.fuzzyMatch)}
+			if tc.confirm != nil {
+				opts = append(opts, WithConfirm(tc.confirm))
+			}
+			p := newTestProvider(t, fake, opts...)
+			grant := &GCPOptions{Project: "p", Roles: []string{role}, TTL: time.Hour, User: "alice@example.com"}
+			if err := p.Grant(grant); err != nil {
+				t.Fatal(err)
+			}
+
+			// Another tool rewrites the title, next to bindings that must stay
+			expiry := p.GrantedRoles()[0].Expiry
+			policy := fake.Policy("p")
+			policy.Bindings[0].Condition.Title = "expires_" + expiry.Format("2006_01_02")
+			policy.Bindings = append(policy.Bindings, bystanders(role, member, expiry)...)
+			fake.SetPolicy("p", policy)
+
+			err := p.Revoke(grant)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("Revoke = %v, want %q", err, tc.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			left := fake.Policy("p").Bindings
+			want := len(policy.Bindings)
+			if tc.removed {
+				want--
+			}
+			if len(left) != want {
+				t.Fatalf("%d bindings left, want %d: %+v", len(left), want, left)
+			}
+			// Whatever happens to the rewritten binding, the others stay
+			for i, b := range bystanders(role, member, expiry) {
+				kept := left[len(left)-4+i]
+				if kept.Role != b.Role || strings.Join(kept.Members, ",") != strings.Join(b.Members, ",") || (kept.Condition == nil) != (b.Condition == nil) {
+					t.Errorf("binding %+v changed to %+v", b, kept)
+				}
+			}
+		})
+	}
+}
+
+func TestFuzzyMatches(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	rewritten := func(title string, members ...string) *resourcemanager.Binding {
+		return &resourcemanager.Binding{Role: "roles/viewer", Members: members, Condition: &resourcemanager.Expr{Title: title, Expression: condition.Expression(expiry)}}
+	}
+	fake := newFakeGCP(t)
+	fake.SetPolicy("p", &resourcemanager.Policy{Version: 3, Bindings: []*resourcemanager.Binding{
+		rewritten("alice", "user:alice@example.com"),
+		// Two bindings bob may be in
+		rewritten("bob 1", "user:bob@example.com"),
+		rewritten("bob 2", "user:bob@example.com"),
+		// A binding carol's two sessions both match
+		rewritten("carol", "user:carol@example.com"),
+		// A binding still found by its title
+		rewritten("gta_temporary_access_1715679000000000004", "user:dave@example.com"),
+		{Role: "roles/viewer", Members: []string{"user:erin@example.com"}},
+	}})
+	p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+
+	expected := func(id, member string) ExpectedBinding {
+		return ExpectedBinding{Target: "p", Role: "roles/viewer", Member: "user:" + member, BindingID: id, Expiry: expiry}
+	}
+	matches, err := p.FuzzyMatches("p", []ExpectedBinding{
+		expected("gta_temporary_access_1715679000000000001", "alice@example.com"),
+		expected("gta_temporary_access_1715679000000000002", "bob@example.com"),
+		expected("gta_temporary_access_1715679000000000003", "carol@example.com"),
+		expected("gta_temporary_access_1715679000000000033", "carol@example.com"),
+		expected("gta_temporary_access_1715679000000000004", "dave@example.com"),
+		expected("gta_temporary_access_1715679000000000005", "erin@example.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Expected.Member != "user:alice@example.com" || matches[0].Title != "alice" {
+		t.Fatalf("FuzzyMatches = %+v, want alice's binding only", matches)
+	}
+
+	// A binding changed since it was matched is left
+	changed := matches[0]
+	changed.Title = "renamed again"
+	if removed, err := p.RemoveFuzzyMatches("p", []FuzzyMatch{changed}); err != nil || len(removed) != 0 {
+		t.Errorf("RemoveFuzzyMatches of a changed binding = %+v, %v", removed, err)
+	}
+	if got := policyMembers(fake, "p"); got != "alice@example.com,bob@example.com,bob@example.com,carol@example.com,dave@example.com,erin@example.com" {
+		t.Errorf("left %s", got)
+	}
+
+	removed, err := p.RemoveFuzzyMatches("p", matches)
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("RemoveFuzzyMatches = %+v, %v", removed, err)
+	}
+	if got := policyMembers(fake, "p"); got != "bob@example.com,bob@example.com,carol@example.com,dave@example.com,erin@example.com" {
+		t.Errorf("left %s", got)
+	}
+}
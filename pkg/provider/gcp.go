@@ -3,23 +3,41 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/condition"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/httpclient"
 	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/metrics"
+	"github.com/yckao/gta/pkg/ratelimit"
+	"github.com/yckao/gta/pkg/version"
+	xoauth2 "golang.org/x/oauth2"
 	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
-	"google.golang.org/api/oauth2/v2"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 const (
-	// gcpBindingTitlePrefix is used to identify bindings created by this tool
-	gcpBindingTitlePrefix = "gta_temporary_access"
 	// policyVersion is required for using conditions in IAM policies
 	policyVersion = 3
+	// policyUpdateMask limits a setIamPolicy call to the fields gta changes,
+	// leaving audit configs and fields gta does not know to the API
+	policyUpdateMask = "bindings,etag"
 	// rolePrefix is the standard prefix for GCP IAM roles
 	rolePrefix = "roles/"
+	// userinfoEmailScope is required to resolve the current user
+	userinfoEmailScope = "https://www.googleapis.com/auth/userinfo.email"
+	// interruptedLookupTimeout bounds the lookup of a binding whose update was
+	// interrupted in flight
+	interruptedLookupTimeout = 10 * time.Second
 )
 
 // temporaryBinding represents a binding that will be cleaned up
@@ -32,16 +50,186 @@ type temporaryBinding struct {
 
 // GrantedRole represents a successfully granted role and its binding ID
 type GrantedRole struct {
-	Role      string
-	BindingID string
+	Role      string    `json:"role"`
+	BindingID string    `json:"binding_id"`
+	Expiry    time.Time `json:"expiry"`
+	// Target is the project ID or resource name whose policy holds the
+	// binding, empty for roles adopted from gta versions not recording it
+	Target string `json:"target,omitempty"`
+	// AddedFor is the role whose dependency rule added this one
+	AddedFor string `json:"added_for,omitempty"`
+}
+
+// Resource names the project or resource the role was granted on, e.g.
+// projects/my-project, empty when unknown
+func (r GrantedRole) Resource() string {
+	if r.Target == "" {
+		return ""
+	}
+	return describeTarget(r.Target)
 }
 
 // GCPProvider implements the Provider interface for Google Cloud Platform
 type GCPProvider struct {
 	ctx          context.Context
 	service      *resourcemanager.Service
+	httpClient   *http.Client
+	limiter      *ratelimit.Limiter
+	metrics      metrics.Recorder
+	events       audit.Sink
+	caller       string // Identity of the authenticated caller, resolved lazily
 	dryRun       bool
 	grantedRoles []GrantedRole // Track successfully granted roles and their binding IDs
+	grantErrors  RoleErrors    // Per-role failures of the last Grant
+	notAttempted []string      // Roles the last Grant stopped before with FailFast
+	partial      PartialFailurePolicy
+	grantHook    GrantHook
+	clientOpts   []option.ClientOption
+	transport    http.RoundTripper // Network transport under auth and instrumentation
+	policies     *policyCache      // Recently fetched IAM policies
+	fields       *policyFields     // Fields of fetched policies written back as read
+	recommender  Recommender
+	isBroad      func(role string) bool
+	confirm      ConfirmFunc
+	log          *logger.Logger
+	// allowSACaller allows modifying policies with service account credentials
+	allowSACaller bool
+	// clock corrects the local time by its skew from Google's clock
+	clock             *skewClock
+	noClockCorrection bool
+	// noRevocationCheck skips reading the policy again after a revocation
+	noRevocationCheck bool
+	// fuzzyMatch allows revoking bindings found by member, role, and expiry
+	// without confirmation
+	fuzzyMatch bool
+	// bindingPrefix starts the IDs of the bindings created, and knownPrefixes
+	// are those of other teams matched with AnyPrefix
+	bindingPrefix string
+	knownPrefixes []string
+	// requestReason is sent in the X-Goog-Request-Reason header of API calls
+	requestReason string
+	// sqlUser is the connection name of the instance Grant created the
+	// database user of the member on, marked in the bindings it creates
+	sqlUser string
+	// retry holds the retry policies by category
+	retry RetryPolicies
+	// policyLimits are the sizes of a policy past which writes are warned about
+	policyLimits PolicyLimits
+	// tagNames caches the namespaced names of tag values by ID, empty for
+	// those that could not be read
+	tagNames map[string]string
+	// roster restricts the callers modifying policies, nil when anyone may;
+	// the outcome of checking the caller is kept in rosterErr
+	roster        *GranterRoster
+	rosterChecked bool
+	rosterErr     error
+}
+
+// GrantHook is called with the grant event of each binding before it is
+// written; an error aborts the grant
+type GrantHook func(ctx context.Context, event audit.Event) error
+
+// GCPProviderOption configures optional behavior of a GCPProvider
+type GCPProviderOption func(*GCPProvider)
+
+// WithRateLimiter sets the limiter shared by every API call made by the provider
+func WithRateLimiter(limiter *ratelimit.Limiter) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.limiter = limiter
+	}
+}
+
+// WithPartialFailurePolicy sets how multi-role operations report partial failures
+func WithPartialFailurePolicy(policy PartialFailurePolicy) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.partial = policy
+	}
+}
+
+// WithEventSink sets the sink receiving grant, revoke, and clean lifecycle events
+func WithEventSink(sink audit.Sink) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.events = sink
+	}
+}
+
+// WithGrantHook sets a hook that must accept each binding before it is written,
+// e.g. to register the grant with an external inventory
+func WithGrantHook(hook GrantHook) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.grantHook = hook
+	}
+}
+
+// WithClientOptions adds options used to create the authenticated HTTP client,
+// e.g. to impersonate a dedicated granter service account
+func WithClientOptions(opts ...option.ClientOption) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.clientOpts = append(p.clientOpts, opts...)
+	}
+}
+
+// WithServiceAccountCaller allows policies to be modified with the
+// credentials of a service account, which are refused otherwise unless they
+// impersonate it
+func WithServiceAccountCaller(allowed bool) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.allowSACaller = allowed
+	}
+}
+
+// WithClockCorrection sets whether expiries are computed with the local clock
+// corrected by its skew from Google's clock, which is the default
+func WithClockCorrection(enabled bool) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.noClockCorrection = !enabled
+	}
+}
+
+// WithTransport sets the network transport of Google API calls and token
+// requests, e.g. one going through a proxy; http.DefaultTransport is used otherwise
+func WithTransport(transport http.RoundTripper) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.transport = transport
+	}
+}
+
+// WithRequestReason sends reason in the X-Goog-Request-Reason header of
+// every API call of the provider, so that it shows in Cloud Audit Logs
+func WithRequestReason(reason string) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.requestReason = reason
+	}
+}
+
+// WithLogger sets the logger of the provider, e.g. one carrying session fields;
+// the default logger is used otherwise
+func WithLogger(log *logger.Logger) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.log = log
+	}
+}
+
+// WithMetrics sets the recorder that receives grant, revoke, and API call metrics
+func WithMetrics(recorder metrics.Recorder) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.metrics = recorder
+	}
+}
+
+// RoleTTL is the TTL of a role and the rule of the config it comes from
+type RoleTTL struct {
+	TTL  time.Duration
+	Rule string
+}
+
+// roleTTL returns the TTL of role, in its full form, and the rule it comes
+// from, empty when it is TTL
+func (o *GCPOptions) roleTTL(role string) (time.Duration, string) {
+	if r, ok := o.RoleTTLs[role]; ok && r.TTL <= o.TTL {
+		return r.TTL, r.Rule
+	}
+	return o.TTL, ""
 }
 
 // GCPOptions contains GCP-specific options for granting temporary access
@@ -49,104 +237,486 @@ type GCPOptions struct {
 	Project string
 	Roles   []string
 	User    string
+	// Members are exact principals, e.g. group:team@example.com, acted on
+	// instead of User when set
+	Members []string
 	TTL     time.Duration
+	// RoleTTLs are the TTLs of the roles they name, in their full form, when
+	// they differ by role; TTL applies to the other roles and caps them all
+	RoleTTLs  map[string]RoleTTL
+	Reason    string
+	SessionID string
+	// Profile is the config profile recorded in events
+	Profile string
+	// Environment is the environment of the project recorded in events,
+	// e.g. prod
+	Environment string
+	// RequestID, Requester, and Approver are set when the grant carries out an
+	// approved request on the requester's behalf
+	RequestID string
+	Requester string
+	Approver  string
+	// BreakGlass marks an emergency grant bypassing approval for Incident
+	BreakGlass bool
+	Incident   string
+	// Expired restricts cleaning to bindings whose condition has expired
+	Expired bool
+	// IncludeDeleted selects the members of principals deleted since they
+	// were granted by the principal they were, see ParseDeletedMember
+	IncludeDeleted bool
+	// BindingIDs restricts cleaning to the bindings with these IDs
+	BindingIDs []string
+	// KeptSessions are the IDs of the sessions left to expire by their
+	// condition, whose bindings list marks as unattended
+	KeptSessions map[string]bool
+	// PinnedBindings are the IDs of the bindings compaction leaves alone,
+	// such as those of sessions a process still attends
+	PinnedBindings map[string]bool
+	// Owner tells list the ownership of the bindings it shows, and
+	// Ownerships, when set, restricts it to the bindings of these
+	Owner      *Owner
+	Ownerships []Ownership
+	// CreatedBy restricts cleaning to the bindings created by this caller
+	CreatedBy string
+	// AnyPrefix matches the bindings of every known prefix rather than only
+	// those of the configured one
+	AnyPrefix bool
+	// AcceptBroad grants broad roles even when narrower alternatives exist
+	AcceptBroad bool
+	// RevokeEarly is how long before the expiry of its bindings the session
+	// revokes them, recorded in the grant events; the conditions still
+	// expire at the end of the TTL
+	RevokeEarly time.Duration
+	// AutoCleanOwn removes the expired temporary bindings of the members
+	// from a policy in the same write that grants them a role
+	AutoCleanOwn bool
+	// AutoCompact removes the expired temporary bindings of every member
+	// from a policy in the same write that grants a role when the policy
+	// would otherwise exceed the GCP limits
+	AutoCompact bool
+	// FailFast stops granting at the first role that fails, leaving the
+	// following roles unattempted
+	FailFast bool
+	// Resource is the resource granted on instead of Project, see
+	// ParseResource; Project is still the project the grant is recorded in
+	Resource string
+	// SQLInstance is the connection name of a Cloud SQL instance, see
+	// ParseSQLInstance, on which the IAM database user of the member is
+	// created if it has none, and deleted again when the grant ends
+	SQLInstance string
+	// Condition is a CEL clause the expiry condition of the bindings
+	// created is and-ed with, e.g. to limit them to one resource
+	Condition string
+	// Service confines the bindings created to the resources of a service,
+	// e.g. storage.googleapis.com, see CheckService
+	Service string
+	// MatchTags confine the bindings created to the resources carrying all
+	// of the tags, see ResolveTagMatch
+	MatchTags []TagMatch
+	// Targets are further projects or resources granted roles on in the same
+	// session, along with Roles
+	Targets []GrantTarget
+	// Manifest is the hash of the gta apply manifest the grant declares,
+	// recorded in the bindings created for --prune to find them
+	Manifest string
+	// MirroredFrom is the principal whose bindings the grant copies, recorded
+	// in the bindings created
+	MirroredFrom string
+}
+
+// GrantTarget is a project or resource granted roles on
+type GrantTarget struct {
+	// Resource is a resource name, see ParseResource, or a project ID
+	Resource string
+	Roles    []string
+	// AddedFor is the role whose dependency rule added Roles, empty for
+	// roles requested as such
+	AddedFor string
+}
+
+// roleGrant is a role to grant along with the target granted on
+type roleGrant struct {
+	Role     string
+	Target   string
+	AddedFor string
 }
 
 // IsOptions implements provider.Options interface
 func (o *GCPOptions) IsOptions() {}
 
-// formatRole ensures the role has the proper prefix
-func formatRole(role string) string {
+// target returns the key the policy granted on is read and written with:
+// the resource when there is one, and the project otherwise
+func (o *GCPOptions) target() string {
+	if o.Resource != "" {
+		return o.Resource
+	}
+	return o.Project
+}
+
+// roleTarget returns the target role is granted on, which differs from
+// target for the roles of an instance granted on its IAP tunnel resource
+func (o *GCPOptions) roleTarget(role string) string {
+	return roleTarget(o.target(), role)
+}
+
+// roleTarget returns the target role is granted on when granting on target
+func roleTarget(target, role string) string {
+	targets := relatedTargets(target)
+	if len(targets) > 1 && instanceRoles[role] == ResourceIAPTunnel {
+		return targets[1]
+	}
+	return targets[0]
+}
+
+// revokeAt returns when the session revokes a binding expiring at expiry,
+// zero when it is revoked at expiry
+func (o *GCPOptions) revokeAt(expiry time.Time) time.Time {
+	if o.RevokeEarly <= 0 {
+		return time.Time{}
+	}
+	return expiry.Add(-o.RevokeEarly)
+}
+
+// roleGrants returns the roles of opts along with their targets: Roles on
+// the project or Resource, followed by the roles of each of Targets
+func (o *GCPOptions) roleGrants() []roleGrant {
+	var grants []roleGrant
+	for _, role := range o.Roles {
+		role = FormatRole(role)
+		grants = append(grants, roleGrant{Role: role, Target: o.roleTarget(role)})
+	}
+	for _, target := range o.Targets {
+		for _, role := range target.Roles {
+			role = FormatRole(role)
+			grants = append(grants, roleGrant{Role: role, Target: roleTarget(target.Resource, role), AddedFor: target.AddedFor})
+		}
+	}
+	return grants
+}
+
+// label names the role for messages, along with its target when it is not
+// the project of opts
+func (g roleGrant) label(opts *GCPOptions) string {
+	label := g.Role
+	if g.Target != opts.Project {
+		label += " on " + describeTarget(g.Target)
+	}
+	if g.AddedFor != "" {
+		label += " (added for " + g.AddedFor + ")"
+	}
+	return label
+}
+
+// scope describes where roles are granted on target for messages
+func scope(target string) string {
+	if isResource(target) {
+		return "on " + target
+	}
+	return "in project " + target
+}
+
+// eventResource returns the resource of the events of a binding in the
+// policy of target, empty for a project
+func eventResource(target string) string {
+	if isResource(target) {
+		return target
+	}
+	return ""
+}
+
+// FormatRole ensures the role has the proper prefix
+func FormatRole(role string) string {
 	if strings.HasPrefix(role, rolePrefix) {
 		return role
 	}
 	return rolePrefix + role
 }
 
+// bindingExpiry extracts the expiry time bounding a binding's condition
+func (p *GCPProvider) bindingExpiry(cond *resourcemanager.Expr) (time.Time, bool) {
+	expiry, err := condition.Parse(cond.Expression)
+	if err != nil {
+		p.log.Debug("Ignoring condition %s: %v", cond.Title, err)
+		return time.Time{}, false
+	}
+	return expiry.Time, expiry.Found()
+}
+
 // formatMember formats a user email into a GCP member string
 func formatMember(email string) string {
 	return fmt.Sprintf("user:%s", email)
 }
 
 // NewGCPProvider creates a new GCP provider instance
-func NewGCPProvider(ctx context.Context, dryRun bool) (*GCPProvider, error) {
-	service, err := resourcemanager.NewService(ctx, option.WithScopes(resourcemanager.CloudPlatformScope))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Cloud Resource Manager service: %v", err)
-	}
-
-	return &GCPProvider{
+func NewGCPProvider(ctx context.Context, dryRun bool, opts ...GCPProviderOption) (*GCPProvider, error) {
+	p := &GCPProvider{
 		ctx:          ctx,
-		service:      service,
 		dryRun:       dryRun,
 		grantedRoles: make([]GrantedRole, 0),
-	}, nil
-}
+		policies:     newPolicyCache(),
+		fields:       newPolicyFields(),
+		retry:        DefaultRetryPolicies(),
+		policyLimits: DefaultPolicyLimits,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.limiter == nil {
+		p.limiter = ratelimit.New(ratelimit.DefaultRate, ratelimit.DefaultBurst)
+	}
+	if p.metrics == nil {
+		p.metrics = metrics.Nop{}
+	}
+	if p.events == nil {
+		p.events = audit.Nop{}
+	}
+	if p.partial == "" {
+		p.partial = PartialFailureAllow
+	}
+	if p.bindingPrefix == "" {
+		p.bindingPrefix = DefaultBindingPrefix
+	}
+	if p.log == nil {
+		p.log = logger.Default()
+	}
+	p.clock = newSkewClock(p.log, p.noClockCorrection)
 
-// getCurrentUser gets the email of the currently authenticated user
-func (p *GCPProvider) getCurrentUser() (string, error) {
-	oauth2Service, err := oauth2.NewService(p.ctx, option.WithScopes("https://www.googleapis.com/auth/userinfo.email"))
+	httpClient, err := p.newHTTPClient(resourcemanager.CloudPlatformScope, userinfoEmailScope)
 	if err != nil {
-		return "", fmt.Errorf("failed to create OAuth2 service: %v", err)
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
 	}
+	p.httpClient = httpClient
 
-	userInfo, err := oauth2Service.Userinfo.Get().Do()
+	service, err := resourcemanager.NewService(ctx, option.WithHTTPClient(p.httpClient))
 	if err != nil {
-		return "", fmt.Errorf("failed to get user info: %v", err)
+		return nil, fmt.Errorf("failed to create Cloud Resource Manager service: %w", err)
 	}
+	p.service = service
 
-	if userInfo.Email == "" {
-		return "", fmt.Errorf("no email found in credentials")
+	if p.isBroad != nil && p.recommender == nil {
+		r, err := newGCPRecommender(ctx, p.httpClient)
+		if err != nil {
+			return nil, err
+		}
+		p.recommender = r
 	}
 
-	return userInfo.Email, nil
+	return p, nil
 }
 
-// getIAMPolicy gets the IAM policy for a project with the required version
-func (p *GCPProvider) getIAMPolicy(project string) (*resourcemanager.Policy, error) {
-	getRequest := &resourcemanager.GetIamPolicyRequest{
-		Options: &resourcemanager.GetPolicyOptions{
-			RequestedPolicyVersion: policyVersion,
-		},
+// newHTTPClient creates an authenticated HTTP client requesting scopes, the
+// one shared by all Google API clients unless an API needs other scopes
+func (p *GCPProvider) newHTTPClient(scopes ...string) (*http.Client, error) {
+	ctx := p.tokenContext()
+	network := http.DefaultTransport
+	if p.transport != nil {
+		network = p.transport
+	}
+	var base http.RoundTripper = &logger.Transport{Base: &clockTransport{Base: network, Clock: p.clock}, Logger: p.log}
+	if _, ok := p.metrics.(metrics.Nop); !ok {
+		base = &metrics.Transport{Base: base, Recorder: p.metrics}
+	}
+	base = &ratelimit.Transport{Base: base, Limiter: p.limiter}
+	base = &retryTransport{Base: base, Policy: p.retry[RetryTransient]}
+	base = &httpclient.HeaderTransport{Base: base, UserAgent: UserAgent(), Reason: p.requestReason}
+	opts := append([]option.ClientOption{option.WithScopes(scopes...)}, p.clientOpts...)
+	transport, err := htransport.NewTransport(ctx, base, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// tokenContext returns the context credentials fetch their tokens with.
+// Tokens are still refreshed once the context of the calls is cancelled,
+// e.g. to revoke roles after an interrupt, and fetched through the same
+// transport as API calls.
+func (p *GCPProvider) tokenContext() context.Context {
+	ctx := context.WithoutCancel(p.ctx)
+	if p.transport != nil {
+		ctx = context.WithValue(ctx, xoauth2.HTTPClient, &http.Client{Transport: p.transport})
+	}
+	return ctx
+}
+
+// UserAgent identifies the API calls of gta and its version, e.g. gta/v1.2.3
+func UserAgent() string {
+	return "gta/" + version.String()
+}
+
+// now returns the current time corrected by the measured clock skew
+func (p *GCPProvider) now() time.Time {
+	return p.clock.Now()
+}
+
+// Caller returns the email of the authenticated caller
+func (p *GCPProvider) Caller() (string, error) {
+	if p.caller == "" {
+		caller, err := p.resolveIdentity(p.identitySources())
+		if err != nil {
+			return "", err
+		}
+		p.caller = caller
+	}
+	return p.caller, nil
+}
+
+// callerIdentity returns the email of the authenticated caller, or an empty
+// string if it cannot be determined
+func (p *GCPProvider) callerIdentity() string {
+	caller, err := p.Caller()
+	if err != nil {
+		p.log.Debug("Failed to resolve caller identity: %v", err)
+		return ""
+	}
+	return caller
+}
+
+// newEvent creates a lifecycle event populated with the operation's context
+func (p *GCPProvider) newEvent(action audit.Action, opts *GCPOptions) audit.Event {
+	event := audit.NewEvent(action)
+	event.SessionID = opts.SessionID
+	event.Project = opts.Project
+	event.Resource = opts.Resource
+	event.Reason = opts.Reason
+	event.RequestID = opts.RequestID
+	event.Requester = opts.Requester
+	event.Approver = opts.Approver
+	event.BreakGlass = opts.BreakGlass
+	event.Incident = opts.Incident
+	event.Profile = opts.Profile
+	event.Environment = opts.Environment
+	if _, ok := p.events.(audit.Nop); !ok {
+		event.Caller = p.callerIdentity()
+	}
+	return event
+}
+
+// getIAMPolicy gets the IAM policy of a project, or of a resource named by
+// target, with the required version, reusing a policy fetched in the last
+// few seconds
+func (p *GCPProvider) getIAMPolicy(target string) (*resourcemanager.Policy, error) {
+	if policy, ok := p.policies.get(target); ok {
+		p.log.Debug("Using the cached IAM policy of %s", describeTarget(target))
+		return policy, nil
+	}
+
+	var policy *resourcemanager.Policy
+	var err error
+	if isResource(target) {
+		policy, err = p.getResourcePolicy(target)
+	} else {
+		getRequest := &resourcemanager.GetIamPolicyRequest{
+			Options: &resourcemanager.GetPolicyOptions{
+				RequestedPolicyVersion: policyVersion,
+			},
+		}
+		policy, err = p.service.Projects.GetIamPolicy(target, getRequest).Context(p.ctx).Do()
+		if err != nil {
+			err = fmt.Errorf("getIamPolicy: %w", apiError(err))
+		}
 	}
-	policy, err := p.service.Projects.GetIamPolicy(project, getRequest).Context(p.ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get IAM policy: %v", err)
+		return nil, err
 	}
 
 	// Set the policy version to support conditions
 	policy.Version = policyVersion
+	p.policies.put(target, policy)
 	return policy, nil
 }
 
-// setIAMPolicy updates the IAM policy for a project
-func (p *GCPProvider) setIAMPolicy(project string, policy *resourcemanager.Policy) error {
+// ProjectLabels returns the labels of a project
+func (p *GCPProvider) ProjectLabels(project string) (map[string]string, error) {
+	proj, err := p.service.Projects.Get(project).Context(p.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("get projects/%s: %w", project, apiError(err))
+	}
+	return proj.Labels, nil
+}
+
+// setIAMPolicy updates the IAM policy of a project or resource and returns
+// the stored policy, which carries the etag for the next update
+func (p *GCPProvider) setIAMPolicy(target string, policy *resourcemanager.Policy) (*resourcemanager.Policy, error) {
+	// Drop the cached policy whatever the outcome: after a failure, such as an
+	// etag conflict, the next read must see the current policy
+	p.policies.invalidate(target)
+	// Without the etag it was read with, the write would replace whatever
+	// was set since then
+	if policy.Etag == "" {
+		return nil, fmt.Errorf("refusing to write the policy of %s without the etag it was read with", describeTarget(target))
+	}
+	if err := p.checkRoster(); err != nil {
+		return nil, err
+	}
+	if isResource(target) {
+		updated, err := p.setResourcePolicy(target, policy)
+		if err != nil {
+			return nil, err
+		}
+		updated.Version = policyVersion
+		return updated, nil
+	}
+
 	setRequest := &resourcemanager.SetIamPolicyRequest{
-		Policy: policy,
+		Policy:     policy,
+		UpdateMask: policyUpdateMask,
 	}
-	_, err := p.service.Projects.SetIamPolicy(project, setRequest).Context(p.ctx).Do()
+	updated, err := p.service.Projects.SetIamPolicy(target, setRequest).Context(p.ctx).Do()
 	if err != nil {
-		return fmt.Errorf("failed to set IAM policy: %v", err)
+		return nil, fmt.Errorf("setIamPolicy: %w", apiError(err))
 	}
-	return nil
+	updated.Version = policyVersion
+	return updated, nil
 }
 
-// createBinding creates a new IAM binding with the specified role, member, and expiration
-func (p *GCPProvider) createBinding(role, member string, ttl time.Duration) *resourcemanager.Binding {
-	expireTime := time.Now().Add(ttl).Format(time.RFC3339)
-	bindingID := fmt.Sprintf("%s_%d", gcpBindingTitlePrefix, time.Now().UnixNano())
-
+// createBinding creates a new IAM binding with the specified role, members, and expiration
+func (p *GCPProvider) createBinding(opts *GCPOptions, role string, members []string, expiry time.Time) *resourcemanager.Binding {
+	bindingID := p.newBindingID()
+	metadata := condition.Metadata{
+		Version:      version.String(),
+		GrantedAt:    p.now(),
+		By:           p.callerIdentity(),
+		SessionID:    opts.SessionID,
+		Manifest:     opts.Manifest,
+		MirroredFrom: opts.MirroredFrom,
+		RequestID:    opts.RequestID,
+		Requester:    opts.Requester,
+		Approver:     opts.Approver,
+		BreakGlass:   opts.BreakGlass,
+		Incident:     opts.Incident,
+		Reason:       opts.Reason,
+	}
+	if opts.SQLInstance != "" && opts.SQLInstance == p.sqlUser {
+		metadata.SQLInstance = p.sqlUser
+	}
 	return &resourcemanager.Binding{
 		Role:    role,
-		Members: []string{member},
+		Members: append([]string(nil), members...),
 		Condition: &resourcemanager.Expr{
 			Title:       bindingID,
-			Description: fmt.Sprintf("Temporary access granted by GTA tool at %s", time.Now().Format(time.RFC3339)),
-			Expression:  fmt.Sprintf("request.time < timestamp('%s')", expireTime),
+			Description: metadata.Encode(),
+			Expression:  bindingExpression(opts, expiry),
 		},
 	}
 }
 
+// bindingExpression returns the condition expression of the bindings
+// created with opts expiring at expiry
+func bindingExpression(opts *GCPOptions, expiry time.Time) string {
+	expression := condition.Expression(expiry)
+	if opts.Condition != "" {
+		expression += " && (" + opts.Condition + ")"
+	}
+	if opts.Service != "" {
+		expression += " && (" + condition.ServiceClause(opts.Service) + ")"
+	}
+	for _, tag := range opts.MatchTags {
+		expression += " && (" + condition.TagClause(tag.KeyID, tag.ValueID) + ")"
+	}
+	return expression
+}
+
 // Grant grants temporary access to the specified roles in the specified project
 func (p *GCPProvider) Grant(opts Options) error {
 	gcpOpts, ok := opts.(*GCPOptions)
@@ -154,59 +724,324 @@ func (p *GCPProvider) Grant(opts Options) error {
 		return fmt.Errorf("invalid options type")
 	}
 
-	if gcpOpts.User == "" {
-		user, err := p.getCurrentUser()
-		if err != nil {
-			return fmt.Errorf("failed to get current user: %v", err)
+	caller, err := p.CheckCaller()
+	explicit := gcpOpts.User != "" || len(gcpOpts.Members) > 0
+	switch {
+	case errors.Is(err, ErrUnknownCaller) && explicit:
+		// The caller only matters as the default member
+		p.log.Warn("Granting to %s without verifying the caller: %v", gcpOpts.memberNames(), err)
+	case err != nil:
+		return err
+	}
+	for _, member := range gcpOpts.Members {
+		if err := ValidateMember(member); err != nil {
+			return err
+		}
+	}
+	if err := CheckResourceRoles(gcpOpts.Resource, gcpOpts.Roles); err != nil {
+		return err
+	}
+	for _, target := range gcpOpts.Targets {
+		if err := CheckResourceRoles(target.Resource, target.Roles); err != nil {
+			return err
 		}
-		gcpOpts.User = user
-		logger.Debug("Using current user: %s", user)
+	}
+	if !explicit {
+		gcpOpts.User = caller
+		p.log.Debug("Using current user: %s", caller)
+	} else if caller != "" && !strings.EqualFold(gcpOpts.memberNames(), caller) {
+		p.log.Info("Granting as %s for %s", caller, gcpOpts.memberNames())
 	}
 
-	var grantErrors []string
-	member := formatMember(gcpOpts.User)
+	var grantErrors RoleErrors
+	members := gcpOpts.members()
+	for _, member := range members {
+		if err := p.checkBroadRoles(gcpOpts, member); err != nil {
+			return err
+		}
+	}
+	if gcpOpts.SQLInstance != "" {
+		release, err := p.prepareSQLUser(gcpOpts, members)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 
-	for _, role := range gcpOpts.Roles {
-		formattedRole := formatRole(role)
-		logger.Info("Granting role %s to %s in project %s for %v", formattedRole, gcpOpts.User, gcpOpts.Project, gcpOpts.TTL)
+	// The policy of each target is reused from its last successful update and
+	// fetched again only after a failure, when its etag may be stale
+	policies := make(map[string]*resourcemanager.Policy)
+	p.notAttempted = nil
+	grants := gcpOpts.roleGrants()
+	cleaned := p.checkLeftovers(gcpOpts, grants, policies, members)
+	for i, grant := range grants {
+		formattedRole, target := grant.Role, grant.Target
+		if gcpOpts.FailFast && len(grantErrors) > 0 {
+			for _, rest := range grants[i:] {
+				p.notAttempted = append(p.notAttempted, rest.label(gcpOpts))
+			}
+			p.log.Warn("Stopping at the first failure, not granting %s", strings.Join(p.notAttempted, ", "))
+			break
+		}
+		// Start no further role once interrupted
+		if err := p.ctx.Err(); err != nil {
+			p.grantErrors = grantErrors
+			return fmt.Errorf("grant interrupted before role %s: %w", formattedRole, err)
+		}
+		ttl, rule := gcpOpts.roleTTL(formattedRole)
+		period := duration.Format(ttl)
+		if rule != "" {
+			period += " by " + rule
+		}
+		if grant.AddedFor != "" {
+			p.log.Info("Granting role %s to %s %s for %s, added for %s", formattedRole, gcpOpts.memberNames(), scope(target), period, grant.AddedFor)
+		} else {
+			p.log.Info("Granting role %s to %s %s for %s", formattedRole, gcpOpts.memberNames(), scope(target), period)
+		}
 		if p.dryRun {
-			logger.Info("[DRY-RUN] Would grant role %s to %s in project %s", formattedRole, gcpOpts.User, gcpOpts.Project)
+			p.log.Info("[DRY-RUN] Would grant role %s to %s %s", formattedRole, gcpOpts.memberNames(), scope(target))
 			continue
 		}
 
-		policy, err := p.getIAMPolicy(gcpOpts.Project)
+		policy := policies[target]
+		if policy == nil {
+			var err error
+			if policy, err = p.getIAMPolicy(target); err != nil {
+				p.log.Warn("Failed to get IAM policy for role %s: %v", formattedRole, err)
+				grantErrors = append(grantErrors, &RoleError{Action: "grant", Role: formattedRole, Project: gcpOpts.Project, Resource: describeTarget(target), Err: err})
+				p.metrics.GrantFailed(errorClass(err))
+				p.emitGrantFailure(gcpOpts, target, formattedRole, members, err)
+				continue
+			}
+			policies[target] = policy
+		}
+
+		expiry := p.now().Add(ttl)
+		binding := p.createBinding(gcpOpts, formattedRole, members, expiry)
+		policy.Bindings = append(policy.Bindings, binding)
+		compacted, err := p.fitPolicy(target, policy, gcpOpts.AutoCompact)
 		if err != nil {
-			logger.Warn("Failed to get IAM policy for role %s: %v", formattedRole, err)
-			grantErrors = append(grantErrors, fmt.Sprintf("role %s: %v", formattedRole, err))
+			grantErrors = append(grantErrors, &RoleError{Action: "grant", Role: formattedRole, Project: gcpOpts.Project, Resource: describeTarget(target), Err: err})
+			p.metrics.GrantFailed(errorClass(err))
+			p.emitGrantFailure(gcpOpts, target, formattedRole, members, err)
+			policy.Bindings = policy.Bindings[:len(policy.Bindings)-1]
 			continue
 		}
 
-		binding := p.createBinding(formattedRole, member, gcpOpts.TTL)
-		policy.Bindings = append(policy.Bindings, binding)
+		// One event per member, as the audit log and notifications expect
+		events := make([]audit.Event, 0, len(members))
+		for _, member := range members {
+			event := p.newEvent(audit.ActionGrant, gcpOpts)
+			event.Resource = eventResource(target)
+			event.Role = formattedRole
+			event.Member = member
+			event.BindingID = binding.Condition.Title
+			event.Expiry = expiry
+			event.RevokeAt = gcpOpts.revokeAt(expiry)
+			events = append(events, event)
+		}
 
-		if err := p.setIAMPolicy(gcpOpts.Project, policy); err != nil {
-			logger.Warn("Failed to set IAM policy for role %s: %v", formattedRole, err)
-			grantErrors = append(grantErrors, fmt.Sprintf("role %s: %v", formattedRole, err))
+		if p.grantHook != nil {
+			for _, event := range events {
+				if err := p.grantHook(p.ctx, event); err != nil {
+					grantErrors = append(grantErrors, &RoleError{Action: "grant", Role: formattedRole, Project: gcpOpts.Project, Resource: describeTarget(target), Err: err})
+					p.metrics.GrantFailed(errorClass(err))
+					p.emitGrantFailure(gcpOpts, target, formattedRole, members, err)
+					p.grantErrors = grantErrors
+					return fmt.Errorf("grant aborted at role %s: %w", formattedRole, err)
+				}
+			}
+		}
+
+		updated, err := p.setIAMPolicy(target, policy)
+		if isConflict(err) {
+			// The policy read again keeps the expired bindings removed before
+			delete(cleaned, target)
+			err = p.retryConflict(p.log, target, err, func() error {
+				var err error
+				updated, err = p.addBinding(gcpOpts, target, binding)
+				return err
+			})
+		}
+		if err != nil && errorClass(err) == errorClassCancelled && p.bindingWritten(target, binding) {
+			// The update reached the server before the interruption
+			p.metrics.GrantSucceeded()
+			p.metrics.BindingsChanged(1)
+			for _, event := range events {
+				p.events.Emit(event)
+			}
+			p.grantedRoles = append(p.grantedRoles, GrantedRole{Role: formattedRole, BindingID: binding.Condition.Title, Expiry: expiry, Target: target, AddedFor: grant.AddedFor})
+			p.reportLeftovers(gcpOpts, target, cleaned[target])
+			p.grantErrors = grantErrors
+			return fmt.Errorf("grant interrupted after role %s: %w", formattedRole, err)
+		}
+		if err != nil {
+			p.log.Warn("Failed to set IAM policy for role %s: %v", formattedRole, err)
+			grantErrors = append(grantErrors, &RoleError{Action: "grant", Role: formattedRole, Project: gcpOpts.Project, Resource: describeTarget(target), Err: err})
+			p.metrics.GrantFailed(errorClass(err))
+			p.emitGrantFailure(gcpOpts, target, formattedRole, members, err)
+			// The policy read again no longer has the expired bindings removed
+			delete(policies, target)
+			delete(cleaned, target)
 			continue
 		}
+		policies[target] = updated
+		// Expired bindings removed before the scan or to make room went out
+		// with this write
+		p.reportLeftovers(gcpOpts, target, append(cleaned[target], compacted...))
+		delete(cleaned, target)
+		p.metrics.GrantSucceeded()
+		p.metrics.BindingsChanged(1)
+		for _, event := range events {
+			p.events.Emit(event)
+		}
 
 		// Track successfully granted roles and their binding IDs
 		p.grantedRoles = append(p.grantedRoles, GrantedRole{
 			Role:      formattedRole,
 			BindingID: binding.Condition.Title,
+			Expiry:    expiry,
+			Target:    target,
+			AddedFor:  grant.AddedFor,
 		})
 	}
 
-	if len(grantErrors) > 0 {
-		if len(p.grantedRoles) == 0 {
-			// If no roles were granted, return an error
-			return fmt.Errorf("failed to grant any roles: %s", strings.Join(grantErrors, "; "))
+	p.grantErrors = grantErrors
+	return p.partial.check(p.log, "grant", grantErrors, len(grants)-len(p.notAttempted))
+}
+
+// bindingWritten reports whether binding is in the policy of project, read
+// with a fresh context after an update was interrupted in flight and may
+// still have been applied. A binding that cannot be looked up is assumed to
+// be written, so that it is revoked rather than left behind.
+func (p *GCPProvider) bindingWritten(project string, binding *resourcemanager.Binding) bool {
+	interrupted := p.ctx
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(interrupted), interruptedLookupTimeout)
+	defer cancel()
+	p.ctx = ctx
+	defer func() { p.ctx = interrupted }()
+
+	policy, err := p.getIAMPolicy(project)
+	if err != nil {
+		p.log.Warn("Cannot tell whether role %s was granted before the interruption, assuming it was: %v", binding.Role, err)
+		return true
+	}
+	for _, b := range policy.Bindings {
+		if b.Role == binding.Role && b.Condition != nil && b.Condition.Title == binding.Condition.Title {
+			return true
+		}
+	}
+	return false
+}
+
+// addBinding reads the policy of target again and writes it with binding
+// added, unless an earlier write whose response was lost already added it
+func (p *GCPProvider) addBinding(opts *GCPOptions, target string, binding *resourcemanager.Binding) (*resourcemanager.Policy, error) {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range policy.Bindings {
+		if b.Role == binding.Role && b.Condition != nil && b.Condition.Title == binding.Condition.Title {
+			return policy, nil
 		}
-		// If some roles were granted, just log the errors
-		logger.Warn("Failed to grant some roles: %s", strings.Join(grantErrors, "; "))
 	}
+	policy.Bindings = append(policy.Bindings, binding)
+	compacted, err := p.fitPolicy(target, policy, opts.AutoCompact)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := p.setIAMPolicy(target, policy)
+	if err == nil {
+		p.reportLeftovers(opts, target, compacted)
+	}
+	return updated, err
+}
 
-	return nil
+// removeBinding reads the policy of target again and writes it with the
+// members in remove removed from the binding of role, matched by its title.
+// A binding gone meanwhile leaves the policy as it is.
+func (p *GCPProvider) removeBinding(target string, role GrantedRole, remove map[string]bool) (*resourcemanager.Policy, error) {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil, err
+	}
+	for i, binding := range policy.Bindings {
+		if binding.Role == role.Role && binding.Condition != nil && binding.Condition.Title == role.BindingID {
+			policy.Bindings = removeMembers(policy.Bindings, map[int]map[string]bool{i: remove})
+			return p.setIAMPolicy(target, policy)
+		}
+	}
+	return policy, nil
+}
+
+// SetContext replaces the context of the following API calls, e.g. with a
+// fresh one to revoke roles once the context they were granted with is
+// cancelled
+func (p *GCPProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+// emitGrantFailure emits grant events recording a failed role on target for
+// members
+func (p *GCPProvider) emitGrantFailure(opts *GCPOptions, target, role string, members []string, err error) {
+	for _, member := range members {
+		event := p.newEvent(audit.ActionGrant, opts)
+		event.Resource = eventResource(target)
+		event.Role = role
+		event.Member = member
+		event.Error = err.Error()
+		p.events.Emit(event)
+	}
+}
+
+// GrantedRoles returns the roles granted by this provider that have not been revoked yet
+func (p *GCPProvider) GrantedRoles() []GrantedRole {
+	return p.grantedRoles
+}
+
+// RoleOutcome is the outcome of a role of a grant: the binding created, or
+// nil when the role failed or was not attempted
+type RoleOutcome struct {
+	Role    string
+	Target  string
+	Granted *GrantedRole
+}
+
+// RoleOutcomes returns the outcome of each role of opts in the order they
+// were given, the roles of opts before those of its targets, from the roles
+// granted by this provider
+func (p *GCPProvider) RoleOutcomes(opts *GCPOptions) []RoleOutcome {
+	grants := opts.roleGrants()
+	outcomes := make([]RoleOutcome, len(grants))
+	for i, grant := range grants {
+		outcomes[i] = RoleOutcome{Role: grant.Role, Target: grant.Target}
+		for j := range p.grantedRoles {
+			if granted := p.grantedRoles[j]; granted.Role == grant.Role && granted.Target == grant.Target {
+				outcomes[i].Granted = &granted
+				break
+			}
+		}
+	}
+	return outcomes
+}
+
+// AdoptGrantedRoles makes the provider track roles granted by an earlier
+// process, so that Revoke removes them
+func (p *GCPProvider) AdoptGrantedRoles(roles []GrantedRole) {
+	p.grantedRoles = append(p.grantedRoles, roles...)
+}
+
+// NotAttemptedRoles returns the roles the last Grant did not attempt as it
+// stopped at a failure with FailFast
+func (p *GCPProvider) NotAttemptedRoles() []string {
+	return p.notAttempted
+}
+
+// GrantErrors returns the per-role failures of the last Grant, which may be
+// non-empty even when Grant succeeded under PartialFailureAllow
+func (p *GCPProvider) GrantErrors() RoleErrors {
+	return p.grantErrors
 }
 
 // Revoke revokes temporary access from the specified roles in the specified project
@@ -218,122 +1053,446 @@ func (p *GCPProvider) Revoke(opts Options) error {
 
 	// Use only the successfully granted roles for revocation
 	if len(p.grantedRoles) == 0 {
-		logger.Info("No roles to revoke")
+		p.log.Info("No roles to revoke")
 		return nil
 	}
 
-	var revokeErrors []string
-	member := formatMember(gcpOpts.User)
+	var revokeErrors RoleErrors
+	members := gcpOpts.members()
+	remove := make(map[string]bool, len(members))
+	for _, member := range members {
+		remove[member] = true
+	}
 
+	// Policies are reused as in Grant
+	policies := make(map[string]*resourcemanager.Policy)
+	revoked := make(map[string]map[string]map[string]bool)
+	releasedSQLUsers := false
 	for _, grantedRole := range p.grantedRoles {
-		logger.Info("Revoking role %s from %s in project %s", grantedRole.Role, gcpOpts.User, gcpOpts.Project)
+		target := grantedRole.Target
+		if target == "" {
+			target = gcpOpts.roleTarget(grantedRole.Role)
+			grantedRole.Target = target
+		}
+		p.log.Info("Revoking role %s from %s %s", grantedRole.Role, gcpOpts.memberNames(), scope(target))
 		if p.dryRun {
-			logger.Info("[DRY-RUN] Would revoke role %s from %s in project %s", grantedRole.Role, gcpOpts.User, gcpOpts.Project)
+			p.log.Info("[DRY-RUN] Would revoke role %s from %s %s", grantedRole.Role, gcpOpts.memberNames(), scope(target))
 			continue
 		}
 
-		policy, err := p.getIAMPolicy(gcpOpts.Project)
-		if err != nil {
-			logger.Warn("Failed to get IAM policy for role %s: %v", grantedRole.Role, err)
-			revokeErrors = append(revokeErrors, fmt.Sprintf("role %s: %v", grantedRole.Role, err))
-			continue
+		if !releasedSQLUsers && target == gcpOpts.Project {
+			releasedSQLUsers = true
+			if policy := p.releaseRevokedSQLUsers(target, remove); policy != nil {
+				policies[target] = policy
+			}
+		}
+		policy := policies[target]
+		if policy == nil {
+			var err error
+			if policy, err = p.getIAMPolicy(target); err != nil {
+				p.log.Warn("Failed to get IAM policy for role %s: %v", grantedRole.Role, err)
+				revokeErrors = append(revokeErrors, &RoleError{Action: "revoke", Role: grantedRole.Role, Project: gcpOpts.Project, Resource: describeTarget(target), BindingID: grantedRole.BindingID, Err: err})
+				p.metrics.RevokeFailed(errorClass(err))
+				p.emitRevoke(gcpOpts, grantedRole, members, err)
+				continue
+			}
+			policies[target] = policy
 		}
 
+		// Only remove bindings that match both the role and the binding ID
+		// from this execution, or failing that, confirmed fuzzy matches
+		strategy := MatchTitle
+		index := -1
 		for i, binding := range policy.Bindings {
-			// Only remove bindings that match both the role and the binding ID from this execution
 			if binding.Role == grantedRole.Role && binding.Condition != nil && binding.Condition.Title == grantedRole.BindingID {
-				newMembers := make([]string, 0)
-				for _, m := range binding.Members {
-					if m != member {
-						newMembers = append(newMembers, m)
-					}
-				}
-				if len(newMembers) == 0 {
-					// Remove the entire binding if there are no members left
-					policy.Bindings = append(policy.Bindings[:i], policy.Bindings[i+1:]...)
-				} else {
-					binding.Members = newMembers
-				}
+				index = i
 				break
 			}
 		}
+		if index < 0 {
+			var err error
+			if index, err = p.fuzzyIndex(target, policy, grantedRole, members); err != nil {
+				p.log.Warn("Failed to revoke role %s: %v", grantedRole.Role, err)
+				revokeErrors = append(revokeErrors, &RoleError{Action: "revoke", Role: grantedRole.Role, Project: gcpOpts.Project, Resource: describeTarget(target), BindingID: grantedRole.BindingID, Err: err})
+				p.metrics.RevokeFailed(errorClass(err))
+				p.emitRevoke(gcpOpts, grantedRole, members, err)
+				continue
+			}
+			strategy = MatchFuzzy
+		}
+		if index >= 0 {
+			p.log.Debug("Matched binding %s of role %s by %s", grantedRole.BindingID, grantedRole.Role, strategy)
+			policy.Bindings = removeMembers(policy.Bindings, map[int]map[string]bool{index: remove})
+		}
 
-		if err := p.setIAMPolicy(gcpOpts.Project, policy); err != nil {
-			logger.Warn("Failed to set IAM policy for role %s: %v", grantedRole.Role, err)
-			revokeErrors = append(revokeErrors, fmt.Sprintf("role %s: %v", grantedRole.Role, err))
+		updated, err := p.setIAMPolicy(target, policy)
+		// A fuzzy match was confirmed on the policy as read, and is not
+		// matched again
+		if isConflict(err) && strategy == MatchTitle {
+			err = p.retryConflict(p.log, target, err, func() error {
+				var err error
+				updated, err = p.removeBinding(target, grantedRole, remove)
+				return err
+			})
+		}
+		if err != nil {
+			p.log.Warn("Failed to set IAM policy for role %s: %v", grantedRole.Role, err)
+			revokeErrors = append(revokeErrors, &RoleError{Action: "revoke", Role: grantedRole.Role, Project: gcpOpts.Project, Resource: describeTarget(target), BindingID: grantedRole.BindingID, Err: err})
+			p.metrics.RevokeFailed(errorClass(err))
+			p.emitRevoke(gcpOpts, grantedRole, members, err)
+			delete(policies, target)
 			continue
 		}
+		policies[target] = updated
+		// A fuzzy match is not verified by its title, which may be shared
+		// with unrelated bindings
+		if strategy == MatchTitle {
+			if revoked[target] == nil {
+				revoked[target] = make(map[string]map[string]bool)
+			}
+			revoked[target][grantedRole.BindingID] = remove
+		}
+		p.metrics.RevokeSucceeded()
+		p.metrics.BindingsChanged(-1)
+		p.emitRevoke(gcpOpts, grantedRole, members, nil)
 	}
 
-	if len(revokeErrors) > 0 {
-		logger.Warn("Failed to revoke some roles: %s", strings.Join(revokeErrors, "; "))
+	// Roles left bound are reported whatever the partial failure policy, for
+	// the caller to revoke them again
+	if err := PartialFailureFail.check(p.log, "revoke", revokeErrors, len(p.grantedRoles)); err != nil {
+		return err
+	}
+	targets := make([]string, 0, len(revoked))
+	for target := range revoked {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		if err := p.verifyRevoked(target, revoked[target]); err != nil {
+			return err
+		}
 	}
-
 	return nil
 }
 
-// ListTemporaryBindings lists temporary bindings for the specified project
-func (p *GCPProvider) ListTemporaryBindings(opts Options) error {
+// emitRevoke emits revoke events for a granted role and its members,
+// recording err if it failed
+func (p *GCPProvider) emitRevoke(opts *GCPOptions, grantedRole GrantedRole, members []string, err error) {
+	for _, member := range members {
+		event := p.newEvent(audit.ActionRevoke, opts)
+		event.Resource = eventResource(grantedRole.Target)
+		event.Role = grantedRole.Role
+		event.Member = member
+		event.BindingID = grantedRole.BindingID
+		if err != nil {
+			event.Error = err.Error()
+		}
+		p.events.Emit(event)
+	}
+}
+
+// TemporaryBinding is a binding created by this tool found in a project policy
+type TemporaryBinding struct {
+	Role        string    `json:"role"`
+	Member      string    `json:"member"`
+	BindingID   string    `json:"binding_id"`
+	Expiry      time.Time `json:"expiry"`
+	Description string    `json:"description,omitempty"`
+	// CreatedBy, SessionID, and Reason are read from the description of
+	// bindings created by gta versions writing it in the structured format
+	CreatedBy string `json:"created_by,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	// Manifest is the hash of the gta apply manifest declaring the binding
+	Manifest string `json:"manifest,omitempty"`
+	// MirroredFrom is the principal whose bindings gta grant --mirror copied
+	MirroredFrom string `json:"mirrored_from,omitempty"`
+	// SQLInstance is the connection name of the Cloud SQL instance gta
+	// created the database user of the member on along with the binding
+	SQLInstance string `json:"sql_instance,omitempty"`
+	// Service is the service the condition confines the binding to, Tags
+	// the tags it requires, see condition.Tags, and Condition the clauses of
+	// the condition other than its expiry
+	Service   string   `json:"service,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Condition string   `json:"condition,omitempty"`
+	// Resource is the project or resource whose policy holds the binding,
+	// e.g. projects/my-project
+	Resource string `json:"resource,omitempty"`
+}
+
+// grantedOnBehalf returns the caller who created the binding when it is not
+// the member it grants to, empty otherwise or when unknown
+func (b TemporaryBinding) grantedOnBehalf() string {
+	if b.CreatedBy == "" {
+		return ""
+	}
+	if strings.EqualFold(memberEmail(b.Member), b.CreatedBy) {
+		return ""
+	}
+	return b.CreatedBy
+}
+
+// describeBinding describes a member of a temporary binding
+func (p *GCPProvider) describeBinding(binding *resourcemanager.Binding, member string) TemporaryBinding {
+	expiry, _ := p.bindingExpiry(binding.Condition)
+	described := TemporaryBinding{
+		Role:        binding.Role,
+		Member:      member,
+		BindingID:   binding.Condition.Title,
+		Expiry:      expiry,
+		Description: binding.Condition.Description,
+		Service:     condition.Service(binding.Condition.Expression),
+		Tags:        condition.Tags(binding.Condition.Expression),
+		Condition:   condition.Clauses(binding.Condition.Expression),
+	}
+	if metadata, ok := condition.DecodeMetadata(binding.Condition.Description); ok {
+		described.CreatedBy = metadata.By
+		described.SessionID = metadata.SessionID
+		described.Reason = metadata.Reason
+		described.Manifest = metadata.Manifest
+		described.MirroredFrom = metadata.MirroredFrom
+		described.SQLInstance = metadata.SQLInstance
+	}
+	return described
+}
+
+// TemporaryBindings returns the temporary bindings of the specified project,
+// or resource, filtered by user or members if set
+func (p *GCPProvider) TemporaryBindings(opts Options) ([]TemporaryBinding, error) {
 	gcpOpts, ok := opts.(*GCPOptions)
 	if !ok {
-		return fmt.Errorf("invalid options type")
+		return nil, fmt.Errorf("invalid options type")
 	}
 
-	policy, err := p.getIAMPolicy(gcpOpts.Project)
+	var bindings []TemporaryBinding
+	for _, target := range relatedTargets(gcpOpts.target()) {
+		policy, err := p.getIAMPolicy(target)
+		if err != nil {
+			if isResource(target) {
+				return nil, fmt.Errorf("%s: %w", target, err)
+			}
+			return nil, err
+		}
+
+		for _, binding := range policy.Bindings {
+			// Only include bindings with our condition title prefix
+			if binding.Condition == nil || !p.temporaryTitle(binding.Condition.Title, gcpOpts.AnyPrefix) {
+				continue
+			}
+
+			for _, member := range binding.Members {
+				if gcpOpts.matchMember(member) {
+					described := p.describeBinding(binding, member)
+					described.Resource = describeTarget(target)
+					bindings = append(bindings, described)
+				}
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// BindingIDs returns the IDs of the temporary bindings in the policy of
+// project, whatever their member
+func (p *GCPProvider) BindingIDs(project string) (map[string]bool, error) {
+	bindings, err := p.PolicyBindings(project)
 	if err != nil {
-		return fmt.Errorf("failed to get IAM policy: %v", err)
+		return nil, err
+	}
+	ids := make(map[string]bool, len(bindings))
+	for _, binding := range bindings {
+		ids[binding.BindingID] = true
 	}
+	return ids, nil
+}
 
-	found := false
-	for _, binding := range policy.Bindings {
-		// Only show bindings with our condition title prefix
-		if binding.Condition == nil || !strings.HasPrefix(binding.Condition.Title, gcpBindingTitlePrefix) {
-			continue
+// PolicyBindings returns every member of the temporary bindings in the
+// policy of target, a project ID or a resource name as in GCPOptions, along
+// with those of the IAP tunnel resource of an instance
+func (p *GCPProvider) PolicyBindings(target string) ([]TemporaryBinding, error) {
+	var bindings []TemporaryBinding
+	for _, related := range relatedTargets(target) {
+		policy, err := p.getIAMPolicy(related)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", describeTarget(related), err)
 		}
-
-		for _, member := range binding.Members {
-			if strings.HasPrefix(member, "user:") && (gcpOpts.User == "" || member == formatMember(gcpOpts.User)) {
-				found = true
-				logger.Info("Found temporary binding: Role=%s, Member=%s, Expires=%s, ID=%s",
-					binding.Role,
-					member,
-					strings.TrimPrefix(strings.TrimPrefix(binding.Condition.Expression, "request.time < timestamp('"), "')"),
-					binding.Condition.Title,
-				)
+		for _, binding := range policy.Bindings {
+			// Sessions are looked up by ID, which may have any known prefix
+			if binding.Condition == nil || !p.temporaryTitle(binding.Condition.Title, true) {
+				continue
 			}
+			for _, member := range binding.Members {
+				described := p.describeBinding(binding, member)
+				described.Resource = describeTarget(related)
+				bindings = append(bindings, described)
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// PolicyEtag returns the etag of the policy of target, a project ID or a
+// resource name as in GCPOptions, which changes with every write of the
+// policy
+func (p *GCPProvider) PolicyEtag(target string) (string, error) {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", describeTarget(target), err)
+	}
+	return policy.Etag, nil
+}
+
+// ListTemporaryBindings lists temporary bindings for the specified project
+func (p *GCPProvider) ListTemporaryBindings(opts Options) error {
+	bindings, err := p.TemporaryBindings(opts)
+	if err != nil {
+		return err
+	}
+
+	// Bindings on a resource may be in several policies, as are those of an
+	// instance and its IAP tunnel resource
+	gcpOpts, _ := opts.(*GCPOptions)
+	onResource := gcpOpts != nil && gcpOpts.Resource != ""
+	now := p.now()
+	sqlUsers := p.newSQLUserStates()
+	listed := 0
+	for _, binding := range bindings {
+		ownership, ok := gcpOpts.owned(binding)
+		if !ok {
+			continue
+		}
+		listed++
+		expires := binding.Expiry.Format(time.RFC3339)
+		if binding.Expiry.Before(now) {
+			expires += " (expired)"
+		}
+		var details string
+		if grantedBy := binding.grantedOnBehalf(); grantedBy != "" {
+			details += fmt.Sprintf(", granted by %s for %s", grantedBy, DescribeMember(binding.Member))
+		} else if binding.CreatedBy != "" {
+			details += ", By=" + binding.CreatedBy
+		}
+		if binding.Reason != "" {
+			details += fmt.Sprintf(", Reason=%q", binding.Reason)
+		}
+		if onResource {
+			details += ", Resource=" + binding.Resource
+		}
+		if binding.Service != "" {
+			details += ", Service=" + binding.Service
+		}
+		if len(binding.Tags) > 0 {
+			details += ", Tags=" + strings.Join(p.describeTags(binding.Tags), ",")
+		}
+		if binding.SQLInstance != "" {
+			details += fmt.Sprintf(", DatabaseUser=%s (%s)", binding.SQLInstance, sqlUsers.state(binding.SQLInstance, binding.Member))
+		}
+		if gcpOpts != nil && binding.SessionID != "" && gcpOpts.KeptSessions[binding.SessionID] {
+			details += ", unattended (expires by condition)"
 		}
+		if ownership != "" {
+			details += ", Ownership=" + string(ownership)
+		}
+		p.log.Info("Found temporary binding: Role=%s, Member=%s, Expires=%s, ID=%s%s",
+			binding.Role,
+			DescribeMember(binding.Member),
+			expires,
+			binding.BindingID,
+			details,
+		)
 	}
 
-	if !found {
-		logger.Info("No temporary bindings found")
+	if listed == 0 {
+		p.log.Info("No temporary bindings found")
 	}
 
 	return nil
 }
 
-// CleanTemporaryBindings lists and optionally removes temporary bindings for the specified project
-func (p *GCPProvider) CleanTemporaryBindings(opts Options) error {
+// CleanTemporaryBindings lists and optionally removes temporary bindings for
+// the specified project, returning the bindings removed, or that would be
+// removed in dry-run mode. A policy changed concurrently is read again and
+// the removal retried.
+func (p *GCPProvider) CleanTemporaryBindings(opts Options) ([]TemporaryBinding, error) {
 	gcpOpts, ok := opts.(*GCPOptions)
 	if !ok {
-		return fmt.Errorf("invalid options type")
+		return nil, fmt.Errorf("invalid options type")
+	}
+	log := p.log.With(slog.String("project", gcpOpts.Project))
+	if _, err := p.CheckCaller(); err != nil {
+		return nil, err
 	}
 
-	policy, err := p.getIAMPolicy(gcpOpts.Project)
-	if err != nil {
-		return fmt.Errorf("failed to get IAM policy: %v", err)
+	var removed []TemporaryBinding
+	for _, target := range relatedTargets(gcpOpts.target()) {
+		cleaned, err := p.cleanOnce(log, gcpOpts, target)
+		if isConflict(err) {
+			err = p.retryConflict(log, target, err, func() error {
+				var err error
+				cleaned, err = p.cleanOnce(log, gcpOpts, target)
+				return err
+			})
+		}
+		removed = append(removed, cleaned...)
+		if err != nil {
+			return removed, err
+		}
 	}
+	return removed, nil
+}
 
-	// First, find all temporary bindings
-	var bindings []temporaryBinding
+// CleanableBindings returns the temporary bindings CleanTemporaryBindings
+// would remove from the specified project, without removing them
+func (p *GCPProvider) CleanableBindings(opts Options) ([]TemporaryBinding, error) {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return nil, fmt.Errorf("invalid options type")
+	}
+	var bindings []TemporaryBinding
+	for _, target := range relatedTargets(gcpOpts.target()) {
+		policy, err := p.getIAMPolicy(target)
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", describeTarget(target), err)
+		}
+		bindings = append(bindings, p.exportBindings(target, policy, p.cleanable(policy, gcpOpts))...)
+	}
+	return bindings, nil
+}
 
+// cleanable returns the members of the temporary bindings of policy selected
+// by the clean options
+func (p *GCPProvider) cleanable(policy *resourcemanager.Policy, gcpOpts *GCPOptions) []temporaryBinding {
+	ids := make(map[string]bool, len(gcpOpts.BindingIDs))
+	for _, id := range gcpOpts.BindingIDs {
+		ids[id] = true
+	}
+
+	var bindings []temporaryBinding
+	now := p.now()
 	for i, binding := range policy.Bindings {
 		// Only process bindings with our condition title prefix
-		if binding.Condition == nil || !strings.HasPrefix(binding.Condition.Title, gcpBindingTitlePrefix) {
+		if binding.Condition == nil || !p.temporaryTitle(binding.Condition.Title, gcpOpts.AnyPrefix) {
+			continue
+		}
+		if len(ids) > 0 && !ids[binding.Condition.Title] {
 			continue
 		}
+		if gcpOpts.Expired {
+			if expiry, ok := p.bindingExpiry(binding.Condition); !ok || expiry.After(now) {
+				continue
+			}
+		}
+		if gcpOpts.CreatedBy != "" {
+			// Bindings whose creator is unknown are never selected
+			metadata, _ := condition.DecodeMetadata(binding.Condition.Description)
+			if !strings.EqualFold(metadata.By, gcpOpts.CreatedBy) {
+				continue
+			}
+		}
 
 		for _, member := range binding.Members {
-			if strings.HasPrefix(member, "user:") && (gcpOpts.User == "" || member == formatMember(gcpOpts.User)) {
+			if gcpOpts.matchMember(member) {
 				bindings = append(bindings, temporaryBinding{
 					Role:      binding.Role,
 					Member:    member,
@@ -343,62 +1502,118 @@ func (p *GCPProvider) CleanTemporaryBindings(opts Options) error {
 			}
 		}
 	}
+	return bindings
+}
+
+// exportBindings describes the members of the temporary bindings of policy,
+// the policy of target
+func (p *GCPProvider) exportBindings(target string, policy *resourcemanager.Policy, bindings []temporaryBinding) []TemporaryBinding {
+	exported := make([]TemporaryBinding, 0, len(bindings))
+	for _, binding := range bindings {
+		described := p.describeBinding(policy.Bindings[binding.Index], binding.Member)
+		described.Resource = describeTarget(target)
+		exported = append(exported, described)
+	}
+	return exported
+}
+
+// cleanOnce reads the policy of target and removes its temporary bindings
+func (p *GCPProvider) cleanOnce(log *logger.Logger, gcpOpts *GCPOptions, target string) ([]TemporaryBinding, error) {
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return nil, fmt.Errorf("clean %s: %w", describeTarget(target), err)
+	}
+
+	// First, find all temporary bindings
+	bindings := p.cleanable(policy, gcpOpts)
+	// Describe them before the policy is modified
+	removed := p.exportBindings(target, policy, bindings)
 
 	if len(bindings) == 0 {
-		logger.Info("No temporary bindings found")
-		return nil
+		log.Info("No temporary bindings found")
+		return nil, nil
 	}
 
 	// List all bindings that will be affected
 	for _, binding := range bindings {
 		if p.dryRun {
-			logger.Info("[DRY-RUN] Would remove binding: Role=%s, Member=%s, ID=%s",
+			log.Info("[DRY-RUN] Would remove binding: Role=%s, Member=%s, ID=%s",
 				binding.Role,
-				binding.Member,
+				DescribeMember(binding.Member),
 				binding.BindingID,
 			)
 		} else {
-			logger.Info("Found binding to remove: Role=%s, Member=%s, ID=%s",
+			log.Info("Found binding to remove: Role=%s, Member=%s, ID=%s",
 				binding.Role,
-				binding.Member,
+				DescribeMember(binding.Member),
 				binding.BindingID,
 			)
 		}
 	}
 
-	if p.dryRun {
-		return nil
+	var unreleasedErr error
+	// Delete the database users created along with the bindings first, as
+	// their marks in the bindings are what lets a failed deletion be retried
+	unreleased := p.releaseSQLUsers(policy, bindings)
+	if len(unreleased) > 0 {
+		bindings = withoutBindings(bindings, unreleased)
+		removed = p.exportBindings(target, policy, bindings)
+		for _, binding := range unreleased {
+			log.Warn("Keeping binding %s of %s until its database user is deleted, run gta clean again to retry", binding.BindingID, binding.Member)
+		}
+		unreleasedErr = fmt.Errorf("clean %s: kept %d binding(s) whose database user could not be deleted", describeTarget(target), len(unreleased))
+		if len(bindings) == 0 {
+			return nil, unreleasedErr
+		}
 	}
 
-	// Remove the bindings
-	// We need to process them in reverse order to avoid index shifting
-	for i := len(bindings) - 1; i >= 0; i-- {
-		binding := bindings[i]
-		logger.Info("Removing binding: Role=%s, Member=%s", binding.Role, binding.Member)
-
-		// Get the binding from the policy
-		policyBinding := policy.Bindings[binding.Index]
+	if p.dryRun {
+		return removed, unreleasedErr
+	}
 
-		// Remove the member from the binding
-		newMembers := make([]string, 0)
-		for _, m := range policyBinding.Members {
-			if m != binding.Member {
-				newMembers = append(newMembers, m)
-			}
+	// Remove the bindings in a single pass over the policy
+	remove := make(map[int]map[string]bool)
+	for _, binding := range bindings {
+		log.Info("Removing binding: Role=%s, Member=%s", binding.Role, DescribeMember(binding.Member))
+		if remove[binding.Index] == nil {
+			remove[binding.Index] = make(map[string]bool)
 		}
+		remove[binding.Index][binding.Member] = true
+	}
+	policy.Bindings = removeMembers(policy.Bindings, remove)
 
-		if len(newMembers) == 0 {
-			// Remove the entire binding if there are no members left
-			policy.Bindings = append(policy.Bindings[:binding.Index], policy.Bindings[binding.Index+1:]...)
-		} else {
-			policyBinding.Members = newMembers
+	if _, err := p.setIAMPolicy(target, policy); err != nil {
+		for _, binding := range bindings {
+			p.metrics.RevokeFailed(errorClass(err))
+			p.emitClean(gcpOpts, binding, err)
 		}
+		return nil, fmt.Errorf("clean %s: %w", describeTarget(target), err)
+	}
+	revoked := make(map[string]map[string]bool)
+	for _, binding := range bindings {
+		p.metrics.RevokeSucceeded()
+		p.emitClean(gcpOpts, binding, nil)
+		if revoked[binding.BindingID] == nil {
+			revoked[binding.BindingID] = make(map[string]bool)
+		}
+		revoked[binding.BindingID][binding.Member] = true
 	}
 
-	if err := p.setIAMPolicy(gcpOpts.Project, policy); err != nil {
-		return fmt.Errorf("failed to update IAM policy: %v", err)
+	log.Info("Successfully cleaned up %d temporary binding(s)", len(bindings))
+	if err := p.verifyRevoked(target, revoked); err != nil {
+		return removed, err
 	}
+	return removed, unreleasedErr
+}
 
-	logger.Info("Successfully cleaned up %d temporary binding(s)", len(bindings))
-	return nil
+// emitClean emits a clean event for a removed binding, recording err if it failed
+func (p *GCPProvider) emitClean(opts *GCPOptions, binding temporaryBinding, err error) {
+	event := p.newEvent(audit.ActionClean, opts)
+	event.Role = binding.Role
+	event.Member = binding.Member
+	event.BindingID = binding.BindingID
+	if err != nil {
+		event.Error = err.Error()
+	}
+	p.events.Emit(event)
 }
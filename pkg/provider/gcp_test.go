@@ -0,0 +1,543 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	"github.com/yckao/gta/pkg/httpclient"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/retry"
+	"github.com/yckao/gta/pkg/workerpool"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeGCP serves the Resource Manager and userinfo calls of a provider from
+// policies kept in memory, counting the calls by method
+type fakeGCP struct {
+	t   testing.TB
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	email    string
+	policies map[string]*resourcemanager.Policy
+	labels   map[string]string
+	calls    map[string]int
+	etag     int
+	// fail, when set, may answer a call instead of the fake, e.g. with an
+	// error; method is the last path segment, e.g. setIamPolicy
+	fail func(method string, r *http.Request, body []byte) (status int, response string, ok bool)
+	// fields holds, by resource, the policy fields other than the bindings,
+	// etag, and version, as raw JSON so that they can be compared byte for
+	// byte
+	fields map[string]map[string]json.RawMessage
+	// writes records every setIamPolicy call
+	writes []fakeWrite
+	// requests records the headers of every call
+	requests []http.Header
+}
+
+func newFakeGCP(t testing.TB) *fakeGCP {
+	f := &fakeGCP{
+		t:        t,
+		email:    "alice@example.com",
+		policies: make(map[string]*resourcemanager.Policy),
+		fields:   make(map[string]map[string]json.RawMessage),
+		calls:    make(map[string]int),
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// method names the API method of r, e.g. getIamPolicy or userinfo
+func method(r *http.Request) string {
+	path := r.URL.Path
+	if strings.Contains(path, "userinfo") {
+		return "userinfo"
+	}
+	if i := strings.LastIndex(path, ":"); i >= 0 {
+		return path[i+1:]
+	}
+	// Compute Engine separates its IAM methods with a slash
+	if m := path[strings.LastIndex(path, "/")+1:]; m == "getIamPolicy" || m == "setIamPolicy" {
+		return m
+	}
+	return r.Method + " " + path
+}
+
+func (f *fakeGCP) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := method(r)
+	f.calls[m]++
+	f.requests = append(f.requests, r.Header.Clone())
+	w.Header().Set("Content-Type", "application/json")
+	if f.fail != nil {
+		if status, response, ok := f.fail(m, r, body); ok {
+			w.WriteHeader(status)
+			io.WriteString(w, response)
+			return
+		}
+	}
+
+	// Policies are kept by project ID, or by the path of other resources
+	resource := strings.SplitN(r.URL.Path, ":", 2)[0]
+	resource = strings.TrimSuffix(strings.TrimSuffix(resource, "/getIamPolicy"), "/setIamPolicy")
+	project := strings.TrimPrefix(resource, "/v1/projects/")
+	switch m {
+	case "userinfo":
+		fmt.Fprintf(w, `{"email":%q,"verified_email":true}`, f.email)
+	case "getIamPolicy":
+		w.Write(f.encode(project, f.policy(project)))
+	case "setIamPolicy":
+		var req struct {
+			Policy     json.RawMessage `json:"policy"`
+			UpdateMask string          `json:"updateMask"`
+		}
+		var policy resourcemanager.Policy
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &req); err != nil {
+			f.t.Errorf("setIamPolicy: %v", err)
+		}
+		if err := json.Unmarshal(req.Policy, &policy); err != nil {
+			f.t.Errorf("setIamPolicy: %v", err)
+		}
+		json.Unmarshal(req.Policy, &fields)
+		f.writes = append(f.writes, fakeWrite{Resource: project, UpdateMask: req.UpdateMask, Etag: policy.Etag})
+		current := f.policy(project)
+		if policy.Etag != "" && policy.Etag != current.Etag {
+			w.WriteHeader(http.StatusConflict)
+			io.WriteString(w, `{"error":{"code":409,"message":"There were concurrent policy changes.","status":"ABORTED"}}`)
+			return
+		}
+		// Without an update mask the whole policy is replaced, otherwise
+		// only the fields in the mask
+		kept := f.fields[project]
+		if req.UpdateMask == "" {
+			kept = make(map[string]json.RawMessage)
+		}
+		for _, path := range strings.Split(req.UpdateMask, ",") {
+			delete(kept, path)
+		}
+		for name, value := range fields {
+			if !ownPolicyFields[name] && (req.UpdateMask == "" || strings.Contains(","+req.UpdateMask+",", ","+name+",")) {
+				kept[name] = value
+			}
+		}
+		f.fields[project] = kept
+		policy.AuditConfigs = nil
+		f.etag++
+		policy.Etag = fmt.Sprintf("etag-%d", f.etag)
+		f.policies[project] = &policy
+		w.Write(f.encode(project, &policy))
+	case "GET /v1/projects/" + project:
+		json.NewEncoder(w).Encode(&resourcemanager.Project{ProjectId: project, Labels: f.labels})
+	default:
+		io.WriteString(w, `{}`)
+	}
+}
+
+// encode encodes the policy of project with its other fields
+func (f *fakeGCP) encode(project string, p *resourcemanager.Policy) []byte {
+	data, _ := json.Marshal(p)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(data, &fields)
+	for name, value := range f.fields[project] {
+		fields[name] = value
+	}
+	data, _ = json.Marshal(fields)
+	return data
+}
+
+// policy returns the policy of project, an empty one when unset
+func (f *fakeGCP) policy(project string) *resourcemanager.Policy {
+	p, ok := f.policies[project]
+	if !ok {
+		p = &resourcemanager.Policy{Version: 1, Etag: "etag-0"}
+		f.policies[project] = p
+	}
+	return p
+}
+
+// Policy returns a copy of the policy of project
+func (f *fakeGCP) Policy(project string) *resourcemanager.Policy {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, _ := json.Marshal(f.policy(project))
+	var p resourcemanager.Policy
+	json.Unmarshal(data, &p)
+	return &p
+}
+
+// SetPolicy replaces the policy of project
+func (f *fakeGCP) SetPolicy(project string, p *resourcemanager.Policy) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p.Etag == "" {
+		p.Etag = "etag-0"
+	}
+	f.policies[project] = p
+}
+
+// SetFields sets the policy fields of project other than the bindings, etag,
+// and version, e.g. auditConfigs
+func (f *fakeGCP) SetFields(project string, fields map[string]json.RawMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fields[project] = fields
+}
+
+// Fields returns the policy fields of project other than the bindings,
+// etag, and version
+func (f *fakeGCP) Fields(project string) map[string]json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fields := make(map[string]json.RawMessage, len(f.fields[project]))
+	for name, value := range f.fields[project] {
+		fields[name] = value
+	}
+	return fields
+}
+
+// fakeWrite is a setIamPolicy call of the policy of Resource, with the
+// update mask and etag it was sent
+type fakeWrite struct {
+	Resource, UpdateMask, Etag string
+}
+
+// Writes returns the setIamPolicy calls so far
+func (f *fakeGCP) Writes() []fakeWrite {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeWrite(nil), f.writes...)
+}
+
+// Calls returns the number of calls of method
+func (f *fakeGCP) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// Requests returns the headers of the calls so far
+func (f *fakeGCP) Requests() []http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]http.Header(nil), f.requests...)
+}
+
+// RoundTrip sends the API calls of a provider to the fake
+func (f *fakeGCP) RoundTrip(r *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(f.srv.URL)
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestProvider creates a provider calling f without credentials
+func newTestProvider(t testing.TB, f *fakeGCP, opts ...GCPProviderOption) *GCPProvider {
+	t.Helper()
+	opts = append([]GCPProviderOption{
+		WithClientOptions(option.WithoutAuthentication()),
+		WithTransport(f),
+		WithClockCorrection(false),
+		WithLogger(logger.Default()),
+	}, opts...)
+	p, err := NewGCPProvider(context.Background(), false, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// noRetries disables every retry, so that failures surface at once
+func noRetries() RetryPolicies {
+	policies := make(RetryPolicies)
+	for _, category := range RetryCategories {
+		policies[category] = retry.Policy{}
+	}
+	return policies
+}
+
+// interruptWrite cancels the grant at the nth policy update, holding the
+// update until the client gives up on it; applied tells whether the fake
+// still applies it afterwards, as a server may
+func interruptWrite(n int, cancel context.CancelFunc, applied bool) func(string, *http.Request, []byte) (int, string, bool) {
+	writes := 0
+	return func(m string, r *http.Request, _ []byte) (int, string, bool) {
+		if m != "setIamPolicy" {
+			return 0, "", false
+		}
+		if writes++; writes != n {
+			return 0, "", false
+		}
+		cancel()
+		<-r.Context().Done()
+		return http.StatusServiceUnavailable, `{}`, !applied
+	}
+}
+
+func TestGrantInterrupted(t *testing.T) {
+	roles := []string{"roles/viewer", "roles/browser", "roles/logging.viewer", "roles/monitoring.viewer"}
+	for _, tc := range []struct {
+		name    string
+		applied bool
+		want    []string
+		wantErr string
+	}{
+		{"update aborted", false, roles[:1], "grant interrupted before role roles/logging.viewer"},
+		{"update applied before the interruption", true, roles[:2], "grant interrupted after role roles/browser"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			fake.fail = interruptWrite(2, cancel, tc.applied)
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			p.SetContext(ctx)
+			opts := &GCPOptions{Project: "p", Roles: roles, TTL: time.Hour, User: "alice@example.com"}
+
+			done := make(chan error, 1)
+			go func() { done <- p.Grant(opts) }()
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Grant did not return after the interruption")
+			}
+			if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("Grant = %v, want %q", err, tc.wantErr)
+			}
+			// No role is started after the interruption
+			if got := fake.Calls("setIamPolicy"); got != 2 {
+				t.Errorf("%d policy updates, want 2", got)
+			}
+
+			// The roles recorded are exactly those in the policy
+			var granted, bound []string
+			for _, role := range p.GrantedRoles() {
+				granted = append(granted, role.Role)
+			}
+			for _, binding := range fake.Policy("p").Bindings {
+				bound = append(bound, binding.Role)
+			}
+			if strings.Join(granted, ",") != strings.Join(tc.want, ",") || strings.Join(bound, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("granted %v and bound %v, want %v", granted, bound, tc.want)
+			}
+
+			// Revoking needs a context of its own
+			fake.fail = nil
+			if err := p.Revoke(opts); err == nil {
+				t.Errorf("Revoke with the cancelled context succeeded")
+			}
+			p.SetContext(context.Background())
+			if err := p.Revoke(opts); err != nil {
+				t.Fatal(err)
+			}
+			if bindings := fake.Policy("p").Bindings; len(bindings) != 0 {
+				t.Errorf("bindings left after revoking: %+v", bindings)
+			}
+		})
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{name: "reason", reason: "INC-42: restore\nthe backups", want: "INC-42: restore the backups"},
+		{name: "off", reason: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()), WithRequestReason(tc.reason))
+			opts := &GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"}
+			if err := p.Grant(opts); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Revoke(opts); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := fake.Requests()
+			if len(requests) == 0 {
+				t.Fatal("no requests")
+			}
+			for _, h := range requests {
+				if ua := h.Get("User-Agent"); !strings.HasPrefix(ua, UserAgent()+" ") {
+					t.Errorf("User-Agent = %q, want it to start with %s", ua, UserAgent())
+				}
+				if got := h.Get(httpclient.RequestReasonHeader); got != tc.want {
+					t.Errorf("%s = %q, want %q", httpclient.RequestReasonHeader, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+// countingTransport counts the calls of a provider in flight, holding each
+// for a moment so that concurrent calls overlap
+type countingTransport struct {
+	base    http.RoundTripper
+	running atomic.Int32
+	peak    atomic.Int32
+	mu      sync.Mutex
+	order   []string
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	n := c.running.Add(1)
+	defer c.running.Add(-1)
+	for {
+		p := c.peak.Load()
+		if n <= p || c.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	c.mu.Lock()
+	c.order = append(c.order, r.URL.Path)
+	c.mu.Unlock()
+	time.Sleep(2 * time.Millisecond)
+	return c.base.RoundTrip(r)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	projects := make([]string, 20)
+	for i := range projects {
+		projects[i] = fmt.Sprintf("p%02d", i)
+	}
+	for _, workers := range []int{1, 3, 8} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			fake := newFakeGCP(t)
+			counter := &countingTransport{base: fake}
+			// One provider per worker, as the commands fanning out make them
+			providers := make([]*GCPProvider, workers)
+			for i := range providers {
+				providers[i] = newTestProvider(t, fake, WithTransport(counter), WithRetryPolicies(noRetries()))
+			}
+			workerpool.Run(context.Background(), workers, projects, func(worker int, project string) {
+				if _, err := providers[worker].CleanableBindings(&GCPOptions{Project: project}); err != nil {
+					t.Errorf("%s: %v", project, err)
+				}
+			})
+
+			if got := fake.Calls("getIamPolicy"); got != len(projects) {
+				t.Errorf("%d policies read, want %d", got, len(projects))
+			}
+			if peak := counter.peak.Load(); peak > int32(workers) {
+				t.Errorf("%d calls at once, want at most %d", peak, workers)
+			}
+			if workers == 1 {
+				// A single worker goes through the projects in order
+				var read []string
+				for _, path := range counter.order {
+					if strings.HasSuffix(path, ":getIamPolicy") {
+						read = append(read, strings.TrimSuffix(strings.TrimPrefix(path, "/v1/projects/"), ":getIamPolicy"))
+					}
+				}
+				if strings.Join(read, ",") != strings.Join(projects, ",") {
+					t.Errorf("read %v, want %v", read, projects)
+				}
+			}
+		})
+	}
+}
+
+func TestBindingExpression(t *testing.T) {
+	expiry := time.Date(2024, 5, 14, 10, 30, 0, 0, time.UTC)
+	// prefix and hours stand for the resource prefix and business hours
+	// clauses given as conditions
+	prefix := "resource.name.startsWith('projects/_/buckets/logs') || resource.name.startsWith('projects/_/buckets/backups')"
+	hours := "request.time.getHours('Europe/Berlin') >= 9 && request.time.getHours('Europe/Berlin') < 17"
+	tag := func(key, value string) TagMatch { return TagMatch{KeyID: key, ValueID: value} }
+	for _, tc := range []struct {
+		name string
+		opts GCPOptions
+		// want is the expression of the grant, and wantExtended that of its
+		// extension by an hour, the same after any further extension
+		want         string
+		wantExtended string
+	}{
+		{
+			name:         "expiry",
+			want:         "request.time < timestamp('2024-05-14T10:30:00Z')",
+			wantExtended: "request.time < timestamp('2024-05-14T11:30:00Z')",
+		},
+		{
+			name:         "service",
+			opts:         GCPOptions{Service: "storage.googleapis.com"},
+			want:         "request.time < timestamp('2024-05-14T10:30:00Z') && (resource.service == 'storage.googleapis.com')",
+			wantExtended: "request.time < timestamp('2024-05-14T11:30:00Z') && ((resource.service == 'storage.googleapis.com'))",
+		},
+		{
+			name:         "resource prefix and service",
+			opts:         GCPOptions{Condition: prefix, Service: "storage.googleapis.com"},
+			want:         "request.time < timestamp('2024-05-14T10:30:00Z') && (resource.name.startsWith('projects/_/buckets/logs') || resource.name.startsWith('projects/_/buckets/backups')) && (resource.service == 'storage.googleapis.com')",
+			wantExtended: "request.time < timestamp('2024-05-14T11:30:00Z') && ((resource.name.startsWith('projects/_/buckets/logs') || resource.name.startsWith('projects/_/buckets/backups')) && (resource.service == 'storage.googleapis.com'))",
+		},
+		{
+			name:         "business hours and tag",
+			opts:         GCPOptions{Condition: hours, MatchTags: []TagMatch{tag("tagKeys/1", "tagValues/2")}},
+			want:         "request.time < timestamp('2024-05-14T10:30:00Z') && (request.time.getHours('Europe/Berlin') >= 9 && request.time.getHours('Europe/Berlin') < 17) && (resource.matchTagId('tagKeys/1', 'tagValues/2'))",
+			wantExtended: "request.time < timestamp('2024-05-14T11:30:00Z') && ((request.time.getHours('Europe/Berlin') >= 9) && (request.time.getHours('Europe/Berlin') < 17) && (resource.matchTagId('tagKeys/1', 'tagValues/2')))",
+		},
+		{
+			name: "everything",
+			opts: GCPOptions{
+				Condition: "(" + prefix + ") && (" + hours + ")",
+				Service:   "storage.googleapis.com",
+				MatchTags: []TagMatch{tag("tagKeys/1", "tagValues/2"), tag("tagKeys/3", "tagValues/4")},
+			},
+			want:         "request.time < timestamp('2024-05-14T10:30:00Z') && ((resource.name.startsWith('projects/_/buckets/logs') || resource.name.startsWith('projects/_/buckets/backups')) && (request.time.getHours('Europe/Berlin') >= 9 && request.time.getHours('Europe/Berlin') < 17)) && (resource.service == 'storage.googleapis.com') && (resource.matchTagId('tagKeys/1', 'tagValues/2')) && (resource.matchTagId('tagKeys/3', 'tagValues/4'))",
+			wantExtended: "request.time < timestamp('2024-05-14T11:30:00Z') && ((resource.name.startsWith('projects/_/buckets/logs') || resource.name.startsWith('projects/_/buckets/backups')) && (request.time.getHours('Europe/Berlin') >= 9) && (request.time.getHours('Europe/Berlin') < 17) && (resource.service == 'storage.googleapis.com') && (resource.matchTagId('tagKeys/1', 'tagValues/2')) && (resource.matchTagId('tagKeys/3', 'tagValues/4')))",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := bindingExpression(&tc.opts, expiry)
+			if got != tc.want {
+				t.Fatalf("expression\n%s\nwant\n%s", got, tc.want)
+			}
+			// The expiry bounds the whole expression, which confines the
+			// binding to the service and tags given
+			parsed, err := condition.Parse(got)
+			if err != nil || !parsed.Time.Equal(expiry) {
+				t.Fatalf("Parse = %+v, %v, want the expiry %s", parsed, err, expiry)
+			}
+			if service := condition.Service(got); service != tc.opts.Service {
+				t.Errorf("service %q, want %q", service, tc.opts.Service)
+			}
+			if tags := condition.Tags(got); len(tags) != len(tc.opts.MatchTags) {
+				t.Errorf("tags %q, want %d", tags, len(tc.opts.MatchTags))
+			}
+
+			// Extending keeps the clauses, as gta tui does
+			extended := bindingExpression(&GCPOptions{Condition: condition.Clauses(got)}, expiry.Add(time.Hour))
+			if extended != tc.wantExtended {
+				t.Fatalf("extended\n%s\nwant\n%s", extended, tc.wantExtended)
+			}
+			again := bindingExpression(&GCPOptions{Condition: condition.Clauses(extended)}, expiry.Add(time.Hour))
+			if again != extended {
+				t.Errorf("extended again\n%s\nwant\n%s", again, extended)
+			}
+			if service := condition.Service(extended); service != tc.opts.Service {
+				t.Errorf("extended service %q, want %q", service, tc.opts.Service)
+			}
+		})
+	}
+}
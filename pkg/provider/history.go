@@ -0,0 +1,228 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+)
+
+const (
+	// historyPageSize is the largest page size accepted by entries.list
+	historyPageSize = 1000
+	// historyQuotaWait is how long to wait when the read quota of Cloud
+	// Logging, counted per minute, is exhausted
+	historyQuotaWait = 30 * time.Second
+	// historyQuotaRetries is how many times a page is read again after the
+	// read quota was exhausted
+	historyQuotaRetries = 3
+)
+
+// HistoryEvent is a grant or revocation of a temporary binding, as recorded
+// in the Admin Activity audit logs of a project
+type HistoryEvent struct {
+	Time   time.Time    `json:"time"`
+	Action audit.Action `json:"action"`
+	// Caller is who called SetIamPolicy
+	Caller string `json:"caller"`
+	// Resource is the resource whose policy changed, e.g. projects/my-project
+	Resource  string    `json:"resource"`
+	Role      string    `json:"role"`
+	Member    string    `json:"member"`
+	BindingID string    `json:"binding_id"`
+	Expiry    time.Time `json:"expiry,omitempty"`
+	// SessionID is read from the structured metadata of the binding
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// auditLogPayload is the part of the AuditLog of a SetIamPolicy call that
+// is read. Resource Manager v1 calls record the policy delta in serviceData,
+// while v3 calls and other services may record it in metadata instead.
+type auditLogPayload struct {
+	MethodName         string `json:"methodName"`
+	ResourceName       string `json:"resourceName"`
+	AuthenticationInfo struct {
+		PrincipalEmail   string `json:"principalEmail"`
+		PrincipalSubject string `json:"principalSubject"`
+	} `json:"authenticationInfo"`
+	ServiceData *auditData `json:"serviceData"`
+	Metadata    *auditData `json:"metadata"`
+}
+
+// auditData holds the policy delta of an IAM audit log entry
+type auditData struct {
+	PolicyDelta *struct {
+		BindingDeltas []bindingDelta `json:"bindingDeltas"`
+	} `json:"policyDelta"`
+}
+
+// bindingDelta is a member added to or removed from a binding
+type bindingDelta struct {
+	// Action is ADD or REMOVE, or the number of the enum value in some
+	// payloads
+	Action    json.RawMessage       `json:"action"`
+	Role      string                `json:"role"`
+	Member    string                `json:"member"`
+	Condition *resourcemanager.Expr `json:"condition"`
+}
+
+// action maps the action of a delta to the action of the event, reporting
+// false for unknown actions
+func (d bindingDelta) action() (audit.Action, bool) {
+	var name string
+	if err := json.Unmarshal(d.Action, &name); err != nil {
+		var number int
+		if err := json.Unmarshal(d.Action, &number); err != nil {
+			return "", false
+		}
+		name = map[int]string{1: "ADD", 2: "REMOVE"}[number]
+	}
+	switch strings.ToUpper(name) {
+	case "ADD":
+		return audit.ActionGrant, true
+	case "REMOVE":
+		return audit.ActionRevoke, true
+	default:
+		return "", false
+	}
+}
+
+// BindingHistory reads the grants and revocations of temporary bindings in
+// project since the given time from its Admin Activity audit logs, oldest
+// first, filtered by the User or Members of opts
+func (p *GCPProvider) BindingHistory(opts Options, since time.Time) ([]HistoryEvent, error) {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return nil, fmt.Errorf("invalid options type")
+	}
+	service, err := logging.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Logging service: %w", err)
+	}
+
+	request := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + gcpOpts.Project},
+		Filter: fmt.Sprintf(`logName="projects/%s/logs/cloudaudit.googleapis.com%%2Factivity" AND `+
+			`(protoPayload.methodName:"SetIamPolicy" OR protoPayload.methodName="storage.setIamPermissions") AND `+
+			`timestamp>="%s"`, gcpOpts.Project, since.UTC().Format(time.RFC3339)),
+		OrderBy:  "timestamp asc",
+		PageSize: historyPageSize,
+	}
+
+	var events []HistoryEvent
+	entries, skipped := 0, 0
+	for {
+		resp, err := p.listLogEntries(service, request)
+		if err != nil {
+			if PermissionDenied(err) {
+				return nil, fmt.Errorf("reading the audit logs of projects/%s needs roles/logging.viewer: %w", gcpOpts.Project, err)
+			}
+			return nil, fmt.Errorf("projects/%s: %w", gcpOpts.Project, err)
+		}
+		for _, entry := range resp.Entries {
+			entries++
+			parsed, ok := p.historyEvents(entry, gcpOpts)
+			if !ok {
+				skipped++
+				continue
+			}
+			events = append(events, parsed...)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		request.PageToken = resp.NextPageToken
+	}
+	if skipped > 0 {
+		p.log.Warn("Skipped %d of %d SetIamPolicy audit log entries without a policy delta", skipped, entries)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+// listLogEntries reads a page of log entries, waiting for the read quota
+// when it is exhausted
+func (p *GCPProvider) listLogEntries(service *logging.Service, request *logging.ListLogEntriesRequest) (*logging.ListLogEntriesResponse, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := service.Entries.List(request).Context(p.ctx).Do()
+		if err == nil {
+			return resp, nil
+		}
+		err = fmt.Errorf("entries.list: %w", apiError(err))
+		if errorClass(err) != errorClassRateLimited || attempt == historyQuotaRetries {
+			return nil, err
+		}
+		p.log.Warn("Cloud Logging read quota exhausted, retrying in %v (attempt %d/%d)", historyQuotaWait, attempt+1, historyQuotaRetries)
+		select {
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		case <-time.After(historyQuotaWait):
+		}
+	}
+}
+
+// historyEvents extracts the deltas of temporary bindings from an audit log
+// entry, reporting false when the entry carries no policy delta
+func (p *GCPProvider) historyEvents(entry *logging.LogEntry, opts *GCPOptions) ([]HistoryEvent, bool) {
+	var payload auditLogPayload
+	if err := json.Unmarshal(entry.ProtoPayload, &payload); err != nil {
+		p.log.Debug("Ignoring audit log entry %s: %v", entry.InsertId, err)
+		return nil, false
+	}
+	data := payload.ServiceData
+	if data == nil || data.PolicyDelta == nil {
+		data = payload.Metadata
+	}
+	if data == nil || data.PolicyDelta == nil {
+		p.log.Debug("Ignoring audit log entry %s of %s without a policy delta", entry.InsertId, payload.MethodName)
+		return nil, false
+	}
+	at, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		p.log.Debug("Ignoring audit log entry %s: invalid timestamp %q", entry.InsertId, entry.Timestamp)
+		return nil, false
+	}
+	caller := payload.AuthenticationInfo.PrincipalEmail
+	if caller == "" {
+		caller = payload.AuthenticationInfo.PrincipalSubject
+	}
+	resource := strings.TrimPrefix(payload.ResourceName, "//cloudresourcemanager.googleapis.com/")
+	if resource == "" {
+		resource = "projects/" + opts.Project
+	}
+
+	var events []HistoryEvent
+	for _, delta := range data.PolicyDelta.BindingDeltas {
+		if delta.Condition == nil || !p.temporaryTitle(delta.Condition.Title, opts.AnyPrefix) || !opts.matchMember(delta.Member) {
+			continue
+		}
+		action, ok := delta.action()
+		if !ok {
+			continue
+		}
+		event := HistoryEvent{
+			Time:      at,
+			Action:    action,
+			Caller:    caller,
+			Resource:  resource,
+			Role:      delta.Role,
+			Member:    delta.Member,
+			BindingID: delta.Condition.Title,
+		}
+		event.Expiry, _ = p.bindingExpiry(delta.Condition)
+		if metadata, ok := condition.DecodeMetadata(delta.Condition.Description); ok {
+			event.SessionID = metadata.SessionID
+		}
+		events = append(events, event)
+	}
+	return events, true
+}
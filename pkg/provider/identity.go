@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"google.golang.org/api/oauth2/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+// ErrUnknownCaller is returned when no source knows the email of the caller
+var ErrUnknownCaller = errors.New("cannot determine the current user")
+
+// identitySource is one way of resolving the email of the caller
+type identitySource struct {
+	Name    string
+	Resolve func() (string, error)
+}
+
+// identitySources returns the ways of resolving the caller, in order: the
+// userinfo endpoint, the active gcloud account, and the email claim of the
+// access token. Userinfo fails for some workload identity and impersonated
+// credentials whose identity the other sources still know.
+func (p *GCPProvider) identitySources() []identitySource {
+	return []identitySource{
+		{Name: "userinfo", Resolve: p.userinfoEmail},
+		{Name: "gcloud", Resolve: gcloudAccount},
+		{Name: "tokeninfo", Resolve: p.tokeninfoEmail},
+	}
+}
+
+// resolveIdentity returns the email from the first source that knows it
+func (p *GCPProvider) resolveIdentity(sources []identitySource) (string, error) {
+	var failures []string
+	for _, source := range sources {
+		email, err := source.Resolve()
+		if err == nil && email == "" {
+			err = fmt.Errorf("no email found")
+		}
+		if err != nil {
+			p.log.Debug("Failed to resolve the current user from %s: %v", source.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", source.Name, err))
+			continue
+		}
+		p.log.Debug("Resolved the current user %s from %s", email, source.Name)
+		return email, nil
+	}
+	return "", fmt.Errorf("%w, pass --user explicitly (%s)", ErrUnknownCaller, strings.Join(failures, "; "))
+}
+
+// userinfoEmail gets the email of the caller from the OAuth2 userinfo endpoint
+func (p *GCPProvider) userinfoEmail() (string, error) {
+	oauth2Service, err := oauth2.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return "", fmt.Errorf("failed to create OAuth2 service: %w", err)
+	}
+	userInfo, err := oauth2Service.Userinfo.Get().Do()
+	if err != nil {
+		return "", fmt.Errorf("userinfo.get: %w", apiError(err))
+	}
+	return userInfo.Email, nil
+}
+
+// tokeninfoEmail gets the email claim of the access token of the credentials
+// from the OAuth2 tokeninfo endpoint
+func (p *GCPProvider) tokeninfoEmail() (string, error) {
+	opts := append([]option.ClientOption{option.WithScopes(userinfoEmailScope)}, p.clientOpts...)
+	creds, err := transport.Creds(p.tokenContext(), opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to find credentials: %w", err)
+	}
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to get an access token: %w", err)
+	}
+	oauth2Service, err := oauth2.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return "", fmt.Errorf("failed to create OAuth2 service: %w", err)
+	}
+	info, err := oauth2Service.Tokeninfo().AccessToken(token.AccessToken).Do()
+	if err != nil {
+		return "", fmt.Errorf("tokeninfo: %w", apiError(err))
+	}
+	return info.Email, nil
+}
+
+// gcloudAccount returns the account of the active gcloud configuration, as
+// gcloud config get-value account would, without running gcloud
+func gcloudAccount() (string, error) {
+	if account := os.Getenv("CLOUDSDK_CORE_ACCOUNT"); account != "" {
+		return account, nil
+	}
+	dir, err := gcloudConfigDir()
+	if err != nil {
+		return "", err
+	}
+	name := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if name == "" {
+		data, err := os.ReadFile(filepath.Join(dir, "active_config"))
+		switch {
+		case err == nil:
+			name = strings.TrimSpace(string(data))
+		case !errors.Is(err, os.ErrNotExist):
+			return "", err
+		}
+	}
+	if name == "" {
+		name = "default"
+	}
+	return iniValue(filepath.Join(dir, "configurations", "config_"+name), "core", "account")
+}
+
+// gcloudConfigDir returns the gcloud configuration directory
+func gcloudConfigDir() (string, error) {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return dir, nil
+	}
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "gcloud"), nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "gcloud"), nil
+}
+
+// iniValue reads the value of key in section of an INI file, empty when unset
+func iniValue(path, section, key string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = strings.TrimSpace(line[1 : len(line)-1])
+		case current == section:
+			k, v, ok := strings.Cut(line, "=")
+			if ok && strings.TrimSpace(k) == key {
+				return strings.TrimSpace(v), nil
+			}
+		}
+	}
+	return "", scanner.Err()
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubSource is an identity source answering email or err, counting its calls
+func stubSource(name, email string, err error, calls map[string]int) identitySource {
+	return identitySource{Name: name, Resolve: func() (string, error) {
+		calls[name]++
+		return email, err
+	}}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	failed := errors.New("unavailable")
+	for _, tc := range []struct {
+		name    string
+		emails  [3]string
+		errs    [3]error
+		want    string
+		asked   []string
+		wantErr string
+	}{
+		{
+			name:   "userinfo",
+			emails: [3]string{"a@example.com", "b@example.com", "c@example.com"},
+			want:   "a@example.com",
+			asked:  []string{"userinfo"},
+		},
+		{
+			name:   "gcloud after userinfo fails",
+			emails: [3]string{"", "b@example.com", "c@example.com"},
+			errs:   [3]error{failed},
+			want:   "b@example.com",
+			asked:  []string{"userinfo", "gcloud"},
+		},
+		{
+			name:   "tokeninfo after both fail",
+			emails: [3]string{"", "", "c@example.com"},
+			errs:   [3]error{failed, failed},
+			want:   "c@example.com",
+			asked:  []string{"userinfo", "gcloud", "tokeninfo"},
+		},
+		{
+			name:   "an empty email counts as a failure",
+			emails: [3]string{"", "", "c@example.com"},
+			want:   "c@example.com",
+			asked:  []string{"userinfo", "gcloud", "tokeninfo"},
+		},
+		{
+			name:    "every source fails",
+			errs:    [3]error{failed, failed, failed},
+			asked:   []string{"userinfo", "gcloud", "tokeninfo"},
+			wantErr: "pass --user explicitly (userinfo: unavailable; gcloud: unavailable; tokeninfo: unavailable)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProvider(t, newFakeGCP(t))
+			calls := make(map[string]int)
+			var sources []identitySource
+			for i, source := range p.identitySources() {
+				sources = append(sources, stubSource(source.Name, tc.emails[i], tc.errs[i], calls))
+			}
+
+			email, err := p.resolveIdentity(sources)
+			if tc.wantErr != "" {
+				if !errors.Is(err, ErrUnknownCaller) || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("err = %v, want ErrUnknownCaller with %q", err, tc.wantErr)
+				}
+			} else if err != nil || email != tc.want {
+				t.Fatalf("resolveIdentity = %q, %v, want %s", email, err, tc.want)
+			}
+			var asked []string
+			for _, source := range sources {
+				if calls[source.Name] > 0 {
+					asked = append(asked, source.Name)
+				}
+			}
+			if strings.Join(asked, ",") != strings.Join(tc.asked, ",") {
+				t.Errorf("asked %v, want %v", asked, tc.asked)
+			}
+		})
+	}
+}
+
+// writeGcloudConfig writes gcloud configurations, by name, with their
+// accounts and sets CLOUDSDK_CONFIG to them
+func writeGcloudConfig(t *testing.T, active string, accounts map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("CLOUDSDK_CONFIG", dir)
+	t.Setenv("CLOUDSDK_CORE_ACCOUNT", "")
+	t.Setenv("CLOUDSDK_ACTIVE_CONFIG_NAME", "")
+	if err := os.MkdirAll(filepath.Join(dir, "configurations"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if active != "" {
+		if err := os.WriteFile(filepath.Join(dir, "active_config"), []byte(active+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, account := range accounts {
+		data := "[compute]\nregion = europe-west1\naccount = not-this@example.com\n\n[core]\n# the active account\naccount = " + account + "\nproject = my-project\n"
+		if err := os.WriteFile(filepath.Join(dir, "configurations", "config_"+name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGcloudAccount(t *testing.T) {
+	accounts := map[string]string{"default": "default@example.com", "work": "work@example.com"}
+
+	writeGcloudConfig(t, "", accounts)
+	if got, err := gcloudAccount(); err != nil || got != "default@example.com" {
+		t.Errorf("without an active config = %q, %v", got, err)
+	}
+
+	writeGcloudConfig(t, "work", accounts)
+	if got, err := gcloudAccount(); err != nil || got != "work@example.com" {
+		t.Errorf("active config = %q, %v", got, err)
+	}
+	t.Setenv("CLOUDSDK_ACTIVE_CONFIG_NAME", "default")
+	if got, err := gcloudAccount(); err != nil || got != "default@example.com" {
+		t.Errorf("CLOUDSDK_ACTIVE_CONFIG_NAME = %q, %v", got, err)
+	}
+	t.Setenv("CLOUDSDK_CORE_ACCOUNT", "env@example.com")
+	if got, err := gcloudAccount(); err != nil || got != "env@example.com" {
+		t.Errorf("CLOUDSDK_CORE_ACCOUNT = %q, %v", got, err)
+	}
+
+	writeGcloudConfig(t, "missing", accounts)
+	if got, err := gcloudAccount(); err == nil {
+		t.Errorf("missing config = %q", got)
+	}
+}
+
+func TestCallerFallbacks(t *testing.T) {
+	unauthorized := `{"error":{"code":401,"message":"Request is missing required authentication credential.","status":"UNAUTHENTICATED"}}`
+
+	// userinfo fails, gcloud knows the account
+	fake := newFakeGCP(t)
+	fake.fail = failWith("userinfo", http.StatusUnauthorized, unauthorized)
+	writeGcloudConfig(t, "", map[string]string{"default": "gcloud@example.com"})
+	p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+	if got, err := p.Caller(); err != nil || got != "gcloud@example.com" {
+		t.Errorf("Caller = %q, %v, want the gcloud account", got, err)
+	}
+
+	// Without gcloud, the email claim of the token
+	fake = newFakeGCP(t)
+	fake.fail = func(m string, r *http.Request, body []byte) (int, string, bool) {
+		switch {
+		case m == "userinfo":
+			return http.StatusUnauthorized, unauthorized, true
+		case strings.HasSuffix(m, "/tokeninfo"):
+			return http.StatusOK, `{"email":"token@example.com","verified_email":true,"expires_in":3599}`, true
+		}
+		return answerTokens(m, r, body)
+	}
+	writeGcloudConfig(t, "", nil)
+	p = newImpersonatingProvider(t, fake)
+	if got, err := p.Caller(); err != nil || got != "token@example.com" {
+		t.Errorf("Caller = %q, %v, want the email of the token", got, err)
+	}
+	// The caller is resolved once
+	if _, err := p.Caller(); err != nil || fake.Calls("userinfo") != 1 {
+		t.Errorf("userinfo called %d times, want 1", fake.Calls("userinfo"))
+	}
+}
@@ -0,0 +1,210 @@
+//go:build integration
+
+package provider
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/condition"
+	"golang.org/x/oauth2/google"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// The integration tests grant, extend, revoke, and clean bindings of a
+// throwaway principal in a dedicated project, with the application default
+// credentials:
+//
+//	GTA_INTEGRATION_PROJECT=gta-it GTA_INTEGRATION_MEMBER=serviceAccount:it@gta-it.iam.gserviceaccount.com \
+//	  go test -tags integration ./pkg/provider -run Integration
+//
+// Every run uses its own session ID, so runs sharing the project only ever
+// touch their own bindings.
+
+// integrationRole is the role granted, harmless to the project
+const integrationRole = "roles/browser"
+
+// integrationEnv returns the project and member under test, skipping the
+// test when either or the credentials are missing
+func integrationEnv(t *testing.T) (project, member string) {
+	t.Helper()
+	project = os.Getenv("GTA_INTEGRATION_PROJECT")
+	member = os.Getenv("GTA_INTEGRATION_MEMBER")
+	if project == "" || member == "" {
+		t.Skip("GTA_INTEGRATION_PROJECT and GTA_INTEGRATION_MEMBER are not set")
+	}
+	if _, err := google.FindDefaultCredentials(context.Background()); err != nil {
+		t.Skipf("no application default credentials: %v", err)
+	}
+	return project, member
+}
+
+// newIntegrationProvider creates a provider with the application default
+// credentials, which are usually those of a CI service account
+func newIntegrationProvider(t *testing.T) *GCPProvider {
+	t.Helper()
+	p, err := NewGCPProvider(context.Background(), false, WithServiceAccountCaller(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// sessionBindings reads the policy of project afresh and returns the
+// bindings of member created by session
+func sessionBindings(t *testing.T, project, member, session string) []*resourcemanager.Binding {
+	t.Helper()
+	policy, err := newIntegrationProvider(t).getIAMPolicy(project)
+	if err != nil {
+		t.Fatalf("getIamPolicy: %v", err)
+	}
+	if policy.Version != 3 {
+		t.Errorf("policy version %d, want 3 to read conditions", policy.Version)
+	}
+	var found []*resourcemanager.Binding
+	for _, b := range policy.Bindings {
+		if b.Condition == nil {
+			continue
+		}
+		if metadata, _ := condition.DecodeMetadata(b.Condition.Description); metadata.SessionID != session {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == member {
+				found = append(found, b)
+			}
+		}
+	}
+	return found
+}
+
+// bindingTitles returns the condition titles of bindings
+func bindingTitles(bindings []*resourcemanager.Binding) []string {
+	titles := make([]string, len(bindings))
+	for i, b := range bindings {
+		titles[i] = b.Condition.Title
+	}
+	return titles
+}
+
+// grantSession grants integrationRole to member for ttl in session and
+// checks the binding created is the one reported
+func grantSession(t *testing.T, project, member, session string, ttl time.Duration) GrantedRole {
+	t.Helper()
+	p := newIntegrationProvider(t)
+	opts := &GCPOptions{
+		Project:   project,
+		Roles:     []string{integrationRole},
+		Members:   []string{member},
+		TTL:       ttl,
+		SessionID: session,
+		Reason:    "gta integration test",
+	}
+	if err := p.Grant(opts); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	granted := p.GrantedRoles()
+	if len(granted) != 1 || granted[0].Role != integrationRole {
+		t.Fatalf("granted %+v, want %s", granted, integrationRole)
+	}
+	if remaining := time.Until(granted[0].Expiry); remaining <= 0 || remaining > ttl+time.Minute {
+		t.Errorf("expiry in %v, want about %v", remaining, ttl)
+	}
+	return granted[0]
+}
+
+// revokeSession revokes the granted bindings of member
+func revokeSession(t *testing.T, project, member string, granted ...GrantedRole) {
+	t.Helper()
+	p := newIntegrationProvider(t)
+	p.AdoptGrantedRoles(granted)
+	if err := p.Revoke(&GCPOptions{Project: project, Members: []string{member}}); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+}
+
+func TestIntegrationLifecycle(t *testing.T) {
+	project, member := integrationEnv(t)
+	session := "it-" + audit.NewID()
+
+	// Whatever fails below, the bindings of the session are removed
+	t.Cleanup(func() {
+		var ids []string
+		for _, b := range sessionBindings(t, project, member, session) {
+			ids = append(ids, b.Condition.Title)
+		}
+		if len(ids) == 0 {
+			return
+		}
+		p := newIntegrationProvider(t)
+		if _, err := p.CleanTemporaryBindings(&GCPOptions{Project: project, Members: []string{member}, BindingIDs: ids}); err != nil {
+			t.Errorf("failed to clean up the bindings %v of session %s: %v", ids, session, err)
+		}
+	})
+
+	// grant
+	first := grantSession(t, project, member, session, 10*time.Minute)
+	bindings := sessionBindings(t, project, member, session)
+	if titles := bindingTitles(bindings); len(titles) != 1 || titles[0] != first.BindingID {
+		t.Fatalf("bindings after grant = %v, want [%s]", titles, first.BindingID)
+	}
+	if b := bindings[0]; b.Role != integrationRole || !strings.Contains(b.Condition.Expression, "request.time < timestamp(") {
+		t.Errorf("binding = %s %q", b.Role, b.Condition.Expression)
+	}
+
+	// list
+	listed, err := newIntegrationProvider(t).TemporaryBindings(&GCPOptions{Project: project, Members: []string{member}})
+	if err != nil {
+		t.Fatalf("TemporaryBindings: %v", err)
+	}
+	var found bool
+	for _, b := range listed {
+		if b.BindingID == first.BindingID {
+			found = true
+			// The condition keeps the expiry to the second
+			if b.SessionID != session || b.Role != integrationRole || b.Expiry.Sub(first.Expiry).Abs() >= time.Second {
+				t.Errorf("listed %+v, want session %s and expiry %s", b, session, first.Expiry)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("binding %s not listed", first.BindingID)
+	}
+
+	// extend: grant again until later, then revoke the first binding
+	extended := grantSession(t, project, member, session, 20*time.Minute)
+	if !extended.Expiry.After(first.Expiry) {
+		t.Errorf("extended expiry %s not after %s", extended.Expiry, first.Expiry)
+	}
+	revokeSession(t, project, member, first)
+	if titles := bindingTitles(sessionBindings(t, project, member, session)); len(titles) != 1 || titles[0] != extended.BindingID {
+		t.Fatalf("bindings after extend = %v, want [%s]", titles, extended.BindingID)
+	}
+
+	// revoke
+	revokeSession(t, project, member, extended)
+	if titles := bindingTitles(sessionBindings(t, project, member, session)); len(titles) != 0 {
+		t.Fatalf("bindings after revoke = %v, want none", titles)
+	}
+
+	// clean
+	leftover := grantSession(t, project, member, session, 10*time.Minute)
+	removed, err := newIntegrationProvider(t).CleanTemporaryBindings(&GCPOptions{
+		Project:    project,
+		Members:    []string{member},
+		BindingIDs: []string{leftover.BindingID},
+	})
+	if err != nil {
+		t.Fatalf("CleanTemporaryBindings: %v", err)
+	}
+	if len(removed) != 1 || removed[0].BindingID != leftover.BindingID {
+		t.Errorf("cleaned %+v, want %s", removed, leftover.BindingID)
+	}
+	if titles := bindingTitles(sessionBindings(t, project, member, session)); len(titles) != 0 {
+		t.Fatalf("bindings after clean = %v, want none", titles)
+	}
+}
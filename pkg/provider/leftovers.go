@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// leftovers are the temporary bindings of the members being granted that an
+// earlier session left in the policy of a target
+type leftovers struct {
+	expired []temporaryBinding
+	active  int
+}
+
+// findLeftovers returns the temporary bindings with the configured prefix in
+// policy that hold any of members, split into those expired at now and those
+// still active. A binding without a readable expiry counts as active.
+func (p *GCPProvider) findLeftovers(policy *resourcemanager.Policy, members []string, now time.Time) leftovers {
+	wanted := make(map[string]bool, len(members))
+	for _, m := range members {
+		wanted[m] = true
+	}
+	var found leftovers
+	for i, binding := range policy.Bindings {
+		if binding.Condition == nil || !p.temporaryTitle(binding.Condition.Title, false) {
+			continue
+		}
+		expiry, ok := p.bindingExpiry(binding.Condition)
+		for _, member := range binding.Members {
+			if !wanted[member] {
+				continue
+			}
+			if ok && expiry.Before(now) {
+				found.expired = append(found.expired, temporaryBinding{Role: binding.Role, Member: member, BindingID: binding.Condition.Title, Index: i})
+			} else {
+				found.active++
+			}
+		}
+	}
+	return found
+}
+
+// checkLeftovers reports the temporary bindings of members left in the
+// policies of the targets of grants and, with AutoCleanOwn, removes the
+// expired ones from the cached policies so that the first write of each
+// target also removes them. It returns the removed bindings by target, to be
+// reported once that write succeeds.
+func (p *GCPProvider) checkLeftovers(opts *GCPOptions, grants []roleGrant, policies map[string]*resourcemanager.Policy, members []string) map[string][]temporaryBinding {
+	cleaned := make(map[string][]temporaryBinding)
+	seen := make(map[string]bool)
+	for _, grant := range grants {
+		target := grant.Target
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		policy, err := p.getIAMPolicy(target)
+		if err != nil {
+			// Granting reads the policy again and reports the failure
+			p.log.Debug("Not checking for leftover bindings %s: %v", scope(target), err)
+			continue
+		}
+		found := p.findLeftovers(policy, members, p.now())
+		if len(found.expired) == 0 && found.active == 0 {
+			continue
+		}
+		p.log.Info("Found %d expired and %d active temporary binding(s) of %s %s", len(found.expired), found.active, opts.memberNames(), scope(target))
+		if len(found.expired) == 0 {
+			continue
+		}
+		switch {
+		case !opts.AutoCleanOwn:
+			p.log.Info("Remove the expired ones with gta clean --expired, or with --auto-clean-own along with the next grant")
+		case p.dryRun:
+			p.log.Info("[DRY-RUN] Would remove %d expired binding(s) %s along with the grant", len(found.expired), scope(target))
+		default:
+			remove := make(map[int]map[string]bool)
+			for _, b := range found.expired {
+				if remove[b.Index] == nil {
+					remove[b.Index] = make(map[string]bool)
+				}
+				remove[b.Index][b.Member] = true
+			}
+			policy.Bindings = removeMembers(policy.Bindings, remove)
+			policies[target] = policy
+			cleaned[target] = found.expired
+		}
+	}
+	return cleaned
+}
+
+// reportLeftovers logs and emits clean events for the expired bindings
+// removed from target along with a grant
+func (p *GCPProvider) reportLeftovers(opts *GCPOptions, target string, removed []temporaryBinding) {
+	for _, b := range removed {
+		p.log.Info("Removed expired binding %s of %s for role %s %s", b.BindingID, b.Member, b.Role, scope(target))
+		event := p.newEvent(audit.ActionClean, opts)
+		event.Resource = eventResource(target)
+		event.Role = b.Role
+		event.Member = b.Member
+		event.BindingID = b.BindingID
+		p.events.Emit(event)
+	}
+}
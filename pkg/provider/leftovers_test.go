@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// leftoverPolicy returns a policy holding, for alice, an expired and an
+// active binding of the default prefix and an expired one of another
+// prefix, along with an expired binding of bob and a permanent one
+func leftoverPolicy() *resourcemanager.Policy {
+	binding := func(title, member string, expiry time.Time) *resourcemanager.Binding {
+		return &resourcemanager.Binding{
+			Role:      "roles/viewer",
+			Members:   []string{member},
+			Condition: &resourcemanager.Expr{Title: title, Expression: condition.Expression(expiry)},
+		}
+	}
+	expired, active := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	return &resourcemanager.Policy{
+		Version: 3,
+		Bindings: []*resourcemanager.Binding{
+			binding(DefaultBindingPrefix+"_1715679000000000001", "user:alice@example.com", expired),
+			binding(DefaultBindingPrefix+"_1715679000000000002", "user:alice@example.com", active),
+			binding("team_b_1715679000000000003", "user:alice@example.com", expired),
+			binding(DefaultBindingPrefix+"_1715679000000000004", "user:bob@example.com", expired),
+			{Role: "roles/viewer", Members: []string{"user:alice@example.com"}},
+		},
+	}
+}
+
+func TestFindLeftovers(t *testing.T) {
+	p := newTestProvider(t, newFakeGCP(t))
+	found := p.findLeftovers(leftoverPolicy(), []string{"user:alice@example.com"}, time.Now())
+	if len(found.expired) != 1 || found.expired[0].BindingID != DefaultBindingPrefix+"_1715679000000000001" || found.active != 1 {
+		t.Errorf("findLeftovers = %+v, want one expired and one active binding", found)
+	}
+	if found := p.findLeftovers(leftoverPolicy(), []string{"user:carol@example.com"}, time.Now()); len(found.expired) != 0 || found.active != 0 {
+		t.Errorf("findLeftovers of another member = %+v", found)
+	}
+}
+
+func TestGrantAutoCleanOwn(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		autoClean bool
+		dryRun    bool
+		writes    int
+		// left are the members of the bindings left in the policy
+		left string
+	}{
+		{name: "suggested", writes: 1, left: "alice@example.com,alice@example.com,alice@example.com,alice@example.com,alice@example.com,bob@example.com"},
+		{name: "--auto-clean-own", autoClean: true, writes: 1, left: "alice@example.com,alice@example.com,alice@example.com,alice@example.com,bob@example.com"},
+		{name: "dry run", autoClean: true, dryRun: true, left: "alice@example.com,alice@example.com,alice@example.com,alice@example.com,bob@example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.SetPolicy("p", leftoverPolicy())
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			p.dryRun = tc.dryRun
+			opts := &GCPOptions{Project: "p", Roles: []string{"browser"}, TTL: time.Hour, User: "alice@example.com", AutoCleanOwn: tc.autoClean}
+			if err := p.Grant(opts); err != nil {
+				t.Fatal(err)
+			}
+
+			// The grant and the removal are the same write
+			if got := fake.Calls("setIamPolicy"); got != tc.writes {
+				t.Errorf("%d writes, want %d", got, tc.writes)
+			}
+			if got := policyMembers(fake, "p"); got != tc.left {
+				t.Errorf("left %s, want %s", got, tc.left)
+			}
+			policy := fake.Policy("p")
+			granted := false
+			for _, b := range policy.Bindings {
+				if b.Condition != nil && b.Condition.Title == DefaultBindingPrefix+"_1715679000000000001" && tc.autoClean && !tc.dryRun {
+					t.Errorf("expired binding of alice left: %+v", b)
+				}
+				granted = granted || b.Role == "roles/browser"
+			}
+			if granted == tc.dryRun {
+				t.Errorf("browser granted %v in dry run %v", granted, tc.dryRun)
+			}
+		})
+	}
+
+	// Only the first write of a target carries the removal
+	fake := newFakeGCP(t)
+	fake.SetPolicy("p", leftoverPolicy())
+	p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+	if err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"browser", "logging.viewer"}, TTL: time.Hour, User: "alice@example.com", AutoCleanOwn: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Calls("setIamPolicy"); got != 2 {
+		t.Errorf("%d writes, want one per role", got)
+	}
+	if got := policyMembers(fake, "p"); got != "alice@example.com,alice@example.com,alice@example.com,alice@example.com,alice@example.com,bob@example.com" {
+		t.Errorf("left %s", got)
+	}
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// memberTypes are the principal types accepted in the members of a binding
+var memberTypes = []string{"user", "serviceAccount", "group", "domain", "principal", "principalSet"}
+
+// forbiddenMembers are principals that are never granted temporary access
+var forbiddenMembers = []string{"allUsers", "allAuthenticatedUsers"}
+
+// deletedPrefix starts the members of policies whose principal was deleted
+// after it was granted, e.g. deleted:user:alice@example.com?uid=123456789
+const deletedPrefix = "deleted:"
+
+// ParseDeletedMember returns the principal a deleted member of a policy was,
+// e.g. user:alice@example.com, along with the uid telling it apart from a
+// principal of the same name created since. ok is false for other members.
+func ParseDeletedMember(member string) (principal, uid string, ok bool) {
+	rest, ok := strings.CutPrefix(member, deletedPrefix)
+	if !ok {
+		return "", "", false
+	}
+	principal = rest
+	// The uid follows the last ?uid=, as the principal never holds one
+	if i := strings.LastIndex(rest, "?uid="); i >= 0 {
+		principal, uid = rest[:i], rest[i+len("?uid="):]
+	}
+	if kind, id, found := strings.Cut(principal, ":"); !found || kind == "" || id == "" {
+		return "", "", false
+	}
+	return principal, uid, true
+}
+
+// DescribeMember names member in messages, telling a deleted principal from
+// an active one of the same name, e.g. user:alice@example.com (deleted, uid
+// 123456789)
+func DescribeMember(member string) string {
+	principal, uid, ok := ParseDeletedMember(member)
+	switch {
+	case !ok:
+		return member
+	case uid == "":
+		return principal + " (deleted)"
+	default:
+		return fmt.Sprintf("%s (deleted, uid %s)", principal, uid)
+	}
+}
+
+// ValidateMember checks that member is a principal as IAM policies spell it,
+// e.g. serviceAccount:ci@my-project.iam.gserviceaccount.com, of a known type
+// that may be granted temporary access
+func ValidateMember(member string) error {
+	for _, forbidden := range forbiddenMembers {
+		if member == forbidden {
+			return fmt.Errorf("%s cannot be granted temporary access", member)
+		}
+	}
+	kind, id, ok := strings.Cut(member, ":")
+	if !ok || id == "" {
+		return fmt.Errorf("invalid member %q, expected TYPE:ID with TYPE one of %s", member, strings.Join(memberTypes, ", "))
+	}
+	for _, known := range memberTypes {
+		if kind != known {
+			continue
+		}
+		if (kind == "principal" || kind == "principalSet") && !strings.HasPrefix(id, "//") {
+			return fmt.Errorf("invalid member %q, expected %s://iam.googleapis.com/...", member, kind)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid member %q: unknown type %q, expected one of %s", member, kind, strings.Join(memberTypes, ", "))
+}
+
+// members returns the principals the options act on
+func (o *GCPOptions) members() []string {
+	if len(o.Members) > 0 {
+		return o.Members
+	}
+	return []string{formatMember(o.User)}
+}
+
+// Principals returns the principals the options act on, e.g.
+// user:alice@example.com for User alice@example.com
+func (o *GCPOptions) Principals() []string {
+	return append([]string(nil), o.members()...)
+}
+
+// memberNames names the principals the options act on in messages
+func (o *GCPOptions) memberNames() string {
+	if len(o.Members) > 0 {
+		return strings.Join(o.Members, ", ")
+	}
+	return o.User
+}
+
+// matchMember reports whether a member of a temporary binding is selected by
+// the options: one of Members verbatim, or else a user matching User if set.
+// With IncludeDeleted, a deleted principal is also selected by the principal
+// it was, and never otherwise unless given verbatim.
+func (o *GCPOptions) matchMember(member string) bool {
+	if principal, _, ok := ParseDeletedMember(member); ok {
+		return o.matchPrincipal(member) || (o.IncludeDeleted && o.matchPrincipal(principal))
+	}
+	return o.matchPrincipal(member)
+}
+
+// matchPrincipal reports whether a principal is one of Members, or else a
+// user matching User if set
+func (o *GCPOptions) matchPrincipal(member string) bool {
+	if len(o.Members) > 0 {
+		for _, m := range o.Members {
+			if m == member {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.HasPrefix(member, "user:") && (o.User == "" || member == formatMember(o.User))
+}
@@ -0,0 +1,86 @@
+package provider
+
+import "strings"
+
+// Ownership tells whose a temporary binding is from the point of view of the
+// caller
+type Ownership string
+
+const (
+	// OwnedBySession marks the bindings of a session running now, exported
+	// in GTA_SESSION or attended by its gta grant
+	OwnedBySession Ownership = "session"
+	// OwnedByMe marks the other bindings created by the caller, such as the
+	// leftovers of previous sessions
+	OwnedByMe Ownership = "mine"
+	// OwnedByOthers marks the bindings created by anyone else
+	OwnedByOthers Ownership = "others"
+)
+
+// Owner tells the ownership of bindings from the local state of the caller,
+// when it can be read, and from the metadata of the bindings otherwise
+type Owner struct {
+	// Current are the IDs of the sessions running now
+	Current map[string]bool
+	// Sessions are the sessions recorded in the local state by the IDs of
+	// their bindings, nil when the state cannot be read
+	Sessions map[string]string
+	// Identities are the emails of the caller, see CallerIdentities
+	Identities []string
+}
+
+// Classify returns the ownership of binding. A binding recorded in the local
+// state, or whose metadata names the caller as its creator, is the caller's;
+// one without metadata is when it grants to the caller.
+func (o *Owner) Classify(binding TemporaryBinding) Ownership {
+	session := binding.SessionID
+	recorded, inState := o.Sessions[binding.BindingID]
+	if session == "" {
+		session = recorded
+	}
+	if session != "" && o.Current[session] {
+		return OwnedBySession
+	}
+	if inState {
+		return OwnedByMe
+	}
+	creator := binding.CreatedBy
+	if creator == "" {
+		// Bindings of older versions only tell whom they grant to
+		creator = memberEmail(binding.Member)
+	}
+	for _, identity := range o.Identities {
+		if strings.EqualFold(identity, creator) {
+			return OwnedByMe
+		}
+	}
+	return OwnedByOthers
+}
+
+// memberEmail returns the email or ID of the principal of member, e.g.
+// alice@example.com for user:alice@example.com
+func memberEmail(member string) string {
+	if principal, _, ok := ParseDeletedMember(member); ok {
+		member = principal
+	}
+	_, email, _ := strings.Cut(member, ":")
+	return email
+}
+
+// owned returns the ownership of binding along with whether the options
+// select it, always when they select no ownership
+func (o *GCPOptions) owned(binding TemporaryBinding) (Ownership, bool) {
+	if o == nil || o.Owner == nil {
+		return "", true
+	}
+	ownership := o.Owner.Classify(binding)
+	if len(o.Ownerships) == 0 {
+		return ownership, true
+	}
+	for _, selected := range o.Ownerships {
+		if ownership == selected {
+			return ownership, true
+		}
+	}
+	return ownership, false
+}
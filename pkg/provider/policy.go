@@ -0,0 +1,319 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+const (
+	// MaxPolicyMembers is the number of principals GCP allows in a policy,
+	// counting a principal once per binding it appears in
+	MaxPolicyMembers = 1500
+	// MaxPolicyBytes is the largest serialized policy GCP accepts
+	MaxPolicyBytes = 64 * 1024
+
+	// policyCacheTTL is how long a fetched policy is reused
+	policyCacheTTL = 10 * time.Second
+	// policyCacheSize bounds the number of cached policies
+	policyCacheSize = 32
+)
+
+// PolicyLimits are the sizes of a policy past which writing it is warned
+// about, below the GCP limits
+type PolicyLimits struct {
+	// Members is the number of principals, counted once per binding
+	Members int
+	// Bytes is the size of the serialized policy
+	Bytes int
+}
+
+// DefaultPolicyLimits warn once a policy is within 10% of a GCP limit
+var DefaultPolicyLimits = PolicyLimits{Members: MaxPolicyMembers * 9 / 10, Bytes: MaxPolicyBytes * 9 / 10}
+
+// WithPolicyLimits sets the sizes of a policy past which writing it is
+// warned about; zero fields keep those of DefaultPolicyLimits
+func WithPolicyLimits(limits PolicyLimits) GCPProviderOption {
+	return func(p *GCPProvider) {
+		if limits.Members > 0 {
+			p.policyLimits.Members = limits.Members
+		}
+		if limits.Bytes > 0 {
+			p.policyLimits.Bytes = limits.Bytes
+		}
+	}
+}
+
+// policySize is the size of a policy measured against the GCP limits
+type policySize struct {
+	Members int
+	Bytes   int
+}
+
+// overLimit describes the GCP limit size exceeds, or returns an empty string
+// when it fits
+func (size policySize) overLimit() string {
+	if size.Members > MaxPolicyMembers {
+		return fmt.Sprintf("%d principals, over the limit of %d", size.Members, MaxPolicyMembers)
+	}
+	if size.Bytes > MaxPolicyBytes {
+		return fmt.Sprintf("%d bytes, over the limit of %d", size.Bytes, MaxPolicyBytes)
+	}
+	return ""
+}
+
+// measurePolicy returns the size of policy as GCP counts it
+func measurePolicy(policy *resourcemanager.Policy) policySize {
+	var size policySize
+	for _, binding := range policy.Bindings {
+		size.Members += len(binding.Members)
+	}
+	if data, err := json.Marshal(policy); err == nil {
+		size.Bytes = len(data)
+	}
+	return size
+}
+
+// checkPolicySize fails when policy exceeds the GCP limits, which the API
+// would otherwise reject with an opaque error, and warns when it nears them
+func (p *GCPProvider) checkPolicySize(target string, policy *resourcemanager.Policy) error {
+	_, err := p.fitPolicy(target, policy, false)
+	return err
+}
+
+// fitPolicy checks policy against the GCP limits before it is written to
+// target. Over a limit, it fails with the number of expired temporary
+// bindings that could make room or, with compact, removes them from policy
+// when that is enough, returning them to be reported once policy is written.
+// Bindings marking a database user are left to gta clean, which deletes it.
+func (p *GCPProvider) fitPolicy(target string, policy *resourcemanager.Policy, compact bool) ([]temporaryBinding, error) {
+	size := measurePolicy(policy)
+	over := size.overLimit()
+	if over == "" {
+		p.warnPolicySize(target, size)
+		return nil, nil
+	}
+
+	expired, indexes := p.expiredBindings(policy)
+	if len(expired) == 0 {
+		return nil, fmt.Errorf("policy of %s would have %s, and holds no expired temporary binding to remove: remove unused bindings from it first", describeTarget(target), over)
+	}
+	kept := make([]*resourcemanager.Binding, 0, len(policy.Bindings)-len(indexes))
+	for i, binding := range policy.Bindings {
+		if !indexes[i] {
+			kept = append(kept, binding)
+		}
+	}
+	trial := *policy
+	trial.Bindings = kept
+	compacted := measurePolicy(&trial)
+	if still := compacted.overLimit(); still != "" {
+		return nil, fmt.Errorf("policy of %s would have %s, and still %s without its %d expired temporary binding(s): remove unused bindings from it first", describeTarget(target), over, still, len(indexes))
+	}
+	if !compact {
+		return nil, fmt.Errorf("policy of %s would have %s; it holds %d expired temporary binding(s) of %d principal(s) whose removal makes room: run gta clean --expired, or grant with --auto-compact to remove them in the same write", describeTarget(target), over, len(indexes), len(expired))
+	}
+	p.log.Info("Policy of %s would have %s, removing its %d expired temporary binding(s) in the same write to make room", describeTarget(target), over, len(indexes))
+	policy.Bindings = kept
+	p.warnPolicySize(target, compacted)
+	return expired, nil
+}
+
+// warnPolicySize warns when size, the size of the policy of target, is past
+// the limits of the provider
+func (p *GCPProvider) warnPolicySize(target string, size policySize) {
+	if size.Members > p.policyLimits.Members || size.Bytes > p.policyLimits.Bytes {
+		p.log.Warn("Policy of %s is near its size limits (%d/%d principals, %d/%d bytes)",
+			describeTarget(target), size.Members, MaxPolicyMembers, size.Bytes, MaxPolicyBytes)
+	}
+}
+
+// expiredBindings returns the members of the expired temporary bindings
+// with the configured prefix in policy, along with the indexes of those
+// bindings, leaving out those marking a database user
+func (p *GCPProvider) expiredBindings(policy *resourcemanager.Policy) ([]temporaryBinding, map[int]bool) {
+	now := p.now()
+	var expired []temporaryBinding
+	indexes := make(map[int]bool)
+	for i, binding := range policy.Bindings {
+		cond := binding.Condition
+		if cond == nil || !p.temporaryTitle(cond.Title, false) {
+			continue
+		}
+		if expiry, ok := p.bindingExpiry(cond); !ok || expiry.After(now) {
+			continue
+		}
+		if metadata, ok := condition.DecodeMetadata(cond.Description); ok && metadata.SQLInstance != "" {
+			continue
+		}
+		indexes[i] = true
+		for _, member := range binding.Members {
+			expired = append(expired, temporaryBinding{Role: binding.Role, Member: member, BindingID: cond.Title, Index: i})
+		}
+	}
+	return expired, indexes
+}
+
+// removeMembers returns bindings without the members listed for each binding
+// index, dropping bindings left without members. It builds the result in a
+// single pass so large policies are not copied once per removal.
+func removeMembers(bindings []*resourcemanager.Binding, remove map[int]map[string]bool) []*resourcemanager.Binding {
+	kept := make([]*resourcemanager.Binding, 0, len(bindings))
+	for i, binding := range bindings {
+		members, ok := remove[i]
+		if !ok {
+			kept = append(kept, binding)
+			continue
+		}
+		rest := make([]string, 0, len(binding.Members))
+		for _, m := range binding.Members {
+			if !members[m] {
+				rest = append(rest, m)
+			}
+		}
+		if len(rest) == 0 {
+			continue
+		}
+		binding.Members = rest
+		kept = append(kept, binding)
+	}
+	return kept
+}
+
+// policyCache holds recently fetched policies by resource, so that the
+// operations of a session do not read the same policy over and over. Policies
+// are kept encoded so that every read returns a copy the caller may modify.
+type policyCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]policyCacheEntry
+}
+
+// policyCacheEntry is a cached policy and when it was fetched
+type policyCacheEntry struct {
+	data    []byte
+	fetched time.Time
+}
+
+// newPolicyCache creates an empty cache
+func newPolicyCache() *policyCache {
+	return &policyCache{now: time.Now, entries: make(map[string]policyCacheEntry)}
+}
+
+// get returns a copy of the policy of resource if it was fetched recently
+func (c *policyCache) get(resource string) (*resourcemanager.Policy, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[resource]
+	if ok && c.now().Sub(entry.fetched) > policyCacheTTL {
+		delete(c.entries, resource)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	var policy resourcemanager.Policy
+	if err := json.Unmarshal(entry.data, &policy); err != nil {
+		return nil, false
+	}
+	return &policy, true
+}
+
+// put caches the policy of resource, evicting the oldest entry when full
+func (c *policyCache) put(resource string, policy *resourcemanager.Policy) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[resource]; !ok && len(c.entries) >= policyCacheSize {
+		var oldest string
+		for key, entry := range c.entries {
+			if oldest == "" || entry.fetched.Before(c.entries[oldest].fetched) {
+				oldest = key
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[resource] = policyCacheEntry{data: data, fetched: c.now()}
+}
+
+// invalidate drops the policy of resource
+func (c *policyCache) invalidate(resource string) {
+	c.mu.Lock()
+	delete(c.entries, resource)
+	c.mu.Unlock()
+}
+
+// policyFields holds, by resource, the fields of fetched policies other than
+// the bindings, etag, and version that gta sets, as they were read. APIs that
+// take no update mask replace the whole policy, so writing a policy to them
+// sends these fields back untouched, including audit configs and fields
+// resourcemanager.Policy does not know.
+type policyFields struct {
+	mu     sync.Mutex
+	fields map[string]map[string]json.RawMessage
+}
+
+// ownPolicyFields are the fields of a policy that gta writes
+var ownPolicyFields = map[string]bool{"bindings": true, "etag": true, "version": true}
+
+// newPolicyFields creates an empty set of fields
+func newPolicyFields() *policyFields {
+	return &policyFields{fields: make(map[string]map[string]json.RawMessage)}
+}
+
+// decode decodes a policy of resource read from its API, keeping the fields
+// gta does not write
+func (f *policyFields) decode(resource string, data []byte) (*resourcemanager.Policy, error) {
+	var policy resourcemanager.Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %v", err)
+	}
+	for name := range ownPolicyFields {
+		delete(fields, name)
+	}
+	f.mu.Lock()
+	f.fields[resource] = fields
+	f.mu.Unlock()
+	return &policy, nil
+}
+
+// encode encodes a policy to write to resource, with the fields gta does not
+// write as they were last read
+func (f *policyFields) encode(resource string, policy *resourcemanager.Policy) (json.RawMessage, error) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	kept := f.fields[resource]
+	f.mu.Unlock()
+	if len(kept) == 0 {
+		return data, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name := range fields {
+		if !ownPolicyFields[name] {
+			delete(fields, name)
+		}
+	}
+	for name, value := range kept {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,502 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	"github.com/yckao/gta/pkg/logger"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// policySizes are the numbers of bindings of the synthetic policies
+var policySizes = []int{100, 1000, 5000}
+
+// syntheticPolicy returns a policy of n bindings of two members each, every
+// other one a temporary binding that expired an hour ago
+func syntheticPolicy(n int) *resourcemanager.Policy {
+	expired := time.Now().Add(-time.Hour)
+	policy := &resourcemanager.Policy{Version: 3, Etag: "etag-0"}
+	for i := 0; i < n; i++ {
+		binding := &resourcemanager.Binding{
+			Role:    fmt.Sprintf("roles/role%d", i%50),
+			Members: []string{fmt.Sprintf("user:a%d@example.com", i), fmt.Sprintf("user:b%d@example.com", i)},
+		}
+		if i%2 == 0 {
+			binding.Condition = &resourcemanager.Expr{
+				Title:      fmt.Sprintf("%s_%d", DefaultBindingPrefix, i),
+				Expression: condition.Expression(expired),
+			}
+		}
+		policy.Bindings = append(policy.Bindings, binding)
+	}
+	return policy
+}
+
+// removals lists the first member of every other binding of n bindings,
+// and both members of every tenth, for removal
+func removals(n int) map[int]map[string]bool {
+	remove := make(map[int]map[string]bool)
+	for i := 0; i < n; i += 2 {
+		remove[i] = map[string]bool{fmt.Sprintf("user:a%d@example.com", i): true}
+		if i%10 == 0 {
+			remove[i][fmt.Sprintf("user:b%d@example.com", i)] = true
+		}
+	}
+	return remove
+}
+
+func TestRemoveMembers(t *testing.T) {
+	policy := syntheticPolicy(20)
+	kept := removeMembers(policy.Bindings, removals(20))
+	// Bindings 0 and 10 lose both members, the other even ones one
+	if len(kept) != 18 {
+		t.Fatalf("kept %d bindings, want 18", len(kept))
+	}
+	for _, binding := range kept {
+		for _, member := range binding.Members {
+			var i int
+			fmt.Sscanf(member[strings.IndexAny(member, "ab")+1:], "%d", &i)
+			if i%2 == 0 && strings.HasPrefix(member, "user:a") {
+				t.Errorf("member %s kept", member)
+			}
+		}
+	}
+	if got := removeMembers(syntheticPolicy(3).Bindings, nil); len(got) != 3 {
+		t.Errorf("removing nothing kept %d of 3 bindings", len(got))
+	}
+}
+
+func TestFitPolicy(t *testing.T) {
+	p := newTestProvider(t, newFakeGCP(t))
+
+	// Under the limits nothing changes
+	small := syntheticPolicy(100)
+	if removed, err := p.fitPolicy("p", small, true); err != nil || removed != nil || len(small.Bindings) != 100 {
+		t.Errorf("fitPolicy of 100 bindings = %v, %v, %d bindings left", removed, err, len(small.Bindings))
+	}
+
+	// 1,000 bindings of two members are over the limit of principals, and
+	// fit without the 500 expired ones
+	large := syntheticPolicy(1000)
+	if _, err := p.fitPolicy("p", large, false); err == nil || !strings.Contains(err.Error(), "--auto-compact") {
+		t.Errorf("fitPolicy without compact = %v, want the expired bindings suggested", err)
+	}
+	if len(large.Bindings) != 1000 {
+		t.Errorf("fitPolicy without compact changed the policy")
+	}
+	removed, err := p.fitPolicy("p", large, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1000 || len(large.Bindings) != 500 {
+		t.Errorf("compact removed %d members and left %d bindings, want 1000 and 500", len(removed), len(large.Bindings))
+	}
+	for _, binding := range large.Bindings {
+		if binding.Condition != nil {
+			t.Errorf("expired binding %s kept", binding.Condition.Title)
+		}
+	}
+
+	// Without expired bindings nothing makes room
+	permanent := syntheticPolicy(1000)
+	for _, binding := range permanent.Bindings {
+		binding.Condition = nil
+	}
+	if _, err := p.fitPolicy("p", permanent, true); err == nil || !strings.Contains(err.Error(), "holds no expired temporary binding") {
+		t.Errorf("fitPolicy without expired bindings = %v", err)
+	}
+
+	// Removing the expired bindings is not always enough
+	crowded := syntheticPolicy(2000)
+	if _, err := p.fitPolicy("p", crowded, true); err == nil || !strings.Contains(err.Error(), "and still") {
+		t.Errorf("fitPolicy still over the limit = %v", err)
+	}
+	if len(crowded.Bindings) != 2000 {
+		t.Errorf("fitPolicy changed a policy it could not fit")
+	}
+}
+
+// nearLimitPolicy returns a policy of n principals whose emails are padded
+// to width characters: the first expired hold a temporary binding each that
+// expired an hour ago, the others share permanent bindings of up to 100
+func nearLimitPolicy(n, expired, width int) *resourcemanager.Policy {
+	policy := &resourcemanager.Policy{Version: 3}
+	var shared *resourcemanager.Binding
+	for i := 0; i < n; i++ {
+		email := fmt.Sprintf("u%d@example.com", i)
+		member := "user:" + strings.Repeat("x", max(0, width-len(email))) + email
+		if i < expired {
+			policy.Bindings = append(policy.Bindings, &resourcemanager.Binding{
+				Role:    "roles/viewer",
+				Members: []string{member},
+				Condition: &resourcemanager.Expr{
+					Title:      fmt.Sprintf("%s_%d", DefaultBindingPrefix, i),
+					Expression: condition.Expression(time.Now().Add(-time.Hour)),
+				},
+			})
+			continue
+		}
+		if shared == nil || len(shared.Members) == 100 {
+			shared = &resourcemanager.Binding{Role: fmt.Sprintf("roles/role%d", len(policy.Bindings))}
+			policy.Bindings = append(policy.Bindings, shared)
+		}
+		shared.Members = append(shared.Members, member)
+	}
+	return policy
+}
+
+func TestFitPolicyNearLimits(t *testing.T) {
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	t.Cleanup(func() { logger.SetOutput(os.Stderr) })
+
+	// markSQL marks the expired bindings as those of database users
+	markSQL := func(policy *resourcemanager.Policy) {
+		for _, binding := range policy.Bindings {
+			if binding.Condition != nil {
+				binding.Condition.Description = condition.Metadata{SQLInstance: "p:region:db"}.Encode()
+			}
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		policy  *resourcemanager.Policy
+		limits  PolicyLimits
+		compact bool
+		// wantWarn and wantErr are parts of the warning and the error, if
+		// any, and wantLeft the number of bindings left
+		wantWarn string
+		wantErr  string
+		wantLeft int
+	}{
+		{name: "below the warning", policy: nearLimitPolicy(1350, 0, 0), wantLeft: 14},
+		{name: "past the warning", policy: nearLimitPolicy(1351, 0, 0), wantWarn: "near its size limits (1351/1500 principals", wantLeft: 14},
+		{name: "at the limit", policy: nearLimitPolicy(1500, 0, 0), wantWarn: "(1500/1500 principals", wantLeft: 15},
+		{name: "over without expired", policy: nearLimitPolicy(1501, 0, 0), wantErr: "would have 1501 principals, over the limit of 1500, and holds no expired temporary binding", wantLeft: 16},
+		{name: "over, suggested", policy: nearLimitPolicy(1501, 3, 0), wantErr: "holds 3 expired temporary binding(s) of 3 principal(s) whose removal makes room: run gta clean --expired", wantLeft: 18},
+		{name: "over, compacted", policy: nearLimitPolicy(1501, 3, 0), compact: true, wantWarn: "(1498/1500 principals", wantLeft: 15},
+		{name: "compacted below the warning", policy: nearLimitPolicy(1501, 200, 0), compact: true, wantLeft: 14},
+		{name: "database users", policy: nearLimitPolicy(1501, 3, 0), compact: true, wantErr: "holds no expired temporary binding", wantLeft: 18},
+		{name: "custom warning", policy: nearLimitPolicy(101, 0, 0), limits: PolicyLimits{Members: 100}, wantWarn: "(101/1500 principals", wantLeft: 2},
+		{name: "bytes past the warning", policy: nearLimitPolicy(550, 0, 100), wantWarn: "near its size limits (550/1500 principals", wantLeft: 6},
+		{name: "bytes over the limit", policy: nearLimitPolicy(620, 0, 100), wantErr: "bytes, over the limit of 65536", wantLeft: 7},
+		{name: "bytes compacted", policy: nearLimitPolicy(600, 40, 100), compact: true, wantWarn: "near its size limits (560/1500 principals", wantLeft: 6},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.name == "database users" {
+				markSQL(tc.policy)
+			}
+			logs.Reset()
+			p := newTestProvider(t, newFakeGCP(t), WithPolicyLimits(tc.limits))
+			_, err := p.fitPolicy("p", tc.policy, tc.compact)
+			if tc.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("fitPolicy = %v, want %q", err, tc.wantErr)
+			}
+			if got := len(tc.policy.Bindings); got != tc.wantLeft {
+				t.Errorf("%d bindings left, want %d", got, tc.wantLeft)
+			}
+			warned := strings.Contains(logs.String(), "near its size limits")
+			if tc.wantWarn == "" && warned || tc.wantWarn != "" && !strings.Contains(logs.String(), tc.wantWarn) {
+				t.Errorf("logged %q, want the warning %q", logs.String(), tc.wantWarn)
+			}
+		})
+	}
+}
+
+func TestGrantNearLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		compact bool
+		// wantWrites is the number of policy writes, wantPrincipals the
+		// principals left
+		wantWrites     int
+		wantPrincipals int
+		wantErr        string
+	}{
+		{name: "refused", wantPrincipals: 1500, wantErr: "grant with --auto-compact"},
+		{name: "--auto-compact", compact: true, wantWrites: 1, wantPrincipals: 1499},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The grant adds the 1,501st principal, and 2 expired bindings
+			// make room
+			fake := newFakeGCP(t)
+			fake.SetPolicy("p", nearLimitPolicy(1500, 2, 0))
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"browser"}, TTL: time.Hour, User: "alice@example.com", AutoCompact: tc.compact})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Grant = %v, want %q", err, tc.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := fake.Calls("setIamPolicy"); got != tc.wantWrites {
+				t.Errorf("%d writes, want %d", got, tc.wantWrites)
+			}
+			if got := measurePolicy(fake.Policy("p")).Members; got != tc.wantPrincipals {
+				t.Errorf("%d principals, want %d", got, tc.wantPrincipals)
+			}
+		})
+	}
+}
+
+func TestPolicyCache(t *testing.T) {
+	now := time.Date(2024, 5, 14, 10, 30, 0, 0, time.UTC)
+	c := newPolicyCache()
+	c.now = func() time.Time { return now }
+
+	c.put("p", syntheticPolicy(2))
+	got, ok := c.get("p")
+	if !ok || len(got.Bindings) != 2 {
+		t.Fatalf("get = %+v, %v", got, ok)
+	}
+	// Every read is a copy
+	got.Bindings = nil
+	if again, _ := c.get("p"); len(again.Bindings) != 2 {
+		t.Errorf("modifying a read changed the cache")
+	}
+
+	now = now.Add(policyCacheTTL + time.Second)
+	if _, ok := c.get("p"); ok {
+		t.Errorf("policy reused after %s", policyCacheTTL)
+	}
+
+	c.put("p", syntheticPolicy(2))
+	c.invalidate("p")
+	if _, ok := c.get("p"); ok {
+		t.Errorf("policy reused after invalidate")
+	}
+
+	// The oldest policy makes room for a new one
+	for i := 0; i <= policyCacheSize; i++ {
+		now = now.Add(time.Millisecond)
+		c.put(fmt.Sprint(i), syntheticPolicy(1))
+	}
+	if len(c.entries) != policyCacheSize {
+		t.Errorf("%d policies cached, want at most %d", len(c.entries), policyCacheSize)
+	}
+	if _, ok := c.get("0"); ok {
+		t.Errorf("oldest policy kept")
+	}
+	if _, ok := c.get(fmt.Sprint(policyCacheSize)); !ok {
+		t.Errorf("newest policy evicted")
+	}
+}
+
+func TestPolicyReads(t *testing.T) {
+	fake := newFakeGCP(t)
+	p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+
+	// Without the cache every read is a call
+	stale := time.Now()
+	p.policies.now = func() time.Time {
+		stale = stale.Add(policyCacheTTL + time.Second)
+		return stale
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.getIAMPolicy("p"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fake.Calls("getIamPolicy"); got != 3 {
+		t.Fatalf("%d reads without the cache, want 3", got)
+	}
+
+	p.policies = newPolicyCache()
+	before := fake.Calls("getIamPolicy")
+	for i := 0; i < 3; i++ {
+		if _, err := p.getIAMPolicy("p"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fake.Calls("getIamPolicy") - before; got != 1 {
+		t.Errorf("%d reads with the cache, want 1", got)
+	}
+
+	// The roles of one grant share a read
+	roles := []string{"viewer", "browser", "logging.viewer", "monitoring.viewer", "storage.objectViewer"}
+	before = fake.Calls("getIamPolicy")
+	if err := p.Grant(&GCPOptions{Project: "p", Roles: roles, TTL: time.Hour, User: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Calls("getIamPolicy") - before; got != 0 {
+		t.Errorf("grant of %d roles read the policy %d times, want the cached one", len(roles), got)
+	}
+	if got := fake.Calls("setIamPolicy"); got != len(roles) {
+		t.Errorf("%d writes, want %d", got, len(roles))
+	}
+
+	// A write invalidates the cache, so revoking reads the granted policy
+	// once, and once more to verify the revocation
+	before = fake.Calls("getIamPolicy")
+	if err := p.Revoke(&GCPOptions{Project: "p", Roles: roles, User: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.Calls("getIamPolicy") - before; got != 2 {
+		t.Errorf("revoke read the policy %d times, want 2", got)
+	}
+	if policy := fake.Policy("p"); len(policy.Bindings) != 0 {
+		t.Errorf("bindings left after revoke: %+v", policy.Bindings)
+	}
+}
+
+func TestPolicyReadAfterConflict(t *testing.T) {
+	fake := newFakeGCP(t)
+	conflicts := 1
+	fake.fail = func(m string, r *http.Request, _ []byte) (int, string, bool) {
+		if m != "setIamPolicy" || conflicts == 0 {
+			return 0, "", false
+		}
+		conflicts--
+		return http.StatusConflict, `{"error":{"code":409,"message":"There were concurrent policy changes.","status":"ABORTED"}}`, true
+	}
+	p := newTestProvider(t, fake, WithRetryPolicies(RetryPolicies{RetryConflicts: {MaxRetries: 1}}))
+	if err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"viewer"}, TTL: time.Hour, User: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// The retry reads the current policy instead of the cached one
+	if got := fake.Calls("getIamPolicy"); got != 2 {
+		t.Errorf("%d reads, want 2", got)
+	}
+	if got := fake.Calls("setIamPolicy"); got != 2 {
+		t.Errorf("%d writes, want 2", got)
+	}
+}
+
+// spliceMembers removes members as the code did before removeMembers, by
+// splicing each emptied binding out of the slice
+func spliceMembers(bindings []*resourcemanager.Binding, remove map[int]map[string]bool) []*resourcemanager.Binding {
+	for i := len(bindings) - 1; i >= 0; i-- {
+		members, ok := remove[i]
+		if !ok {
+			continue
+		}
+		for j := len(bindings[i].Members) - 1; j >= 0; j-- {
+			if members[bindings[i].Members[j]] {
+				bindings[i].Members = append(bindings[i].Members[:j], bindings[i].Members[j+1:]...)
+			}
+		}
+		if len(bindings[i].Members) == 0 {
+			bindings = append(bindings[:i], bindings[i+1:]...)
+		}
+	}
+	return bindings
+}
+
+func BenchmarkRemoveMembers(b *testing.B) {
+	for _, n := range policySizes {
+		remove := removals(n)
+		for name, fn := range map[string]func([]*resourcemanager.Binding, map[int]map[string]bool) []*resourcemanager.Binding{
+			"single-pass": removeMembers,
+			"splice":      spliceMembers,
+		} {
+			b.Run(fmt.Sprintf("%s/%d", name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					policy := syntheticPolicy(n)
+					b.StartTimer()
+					fn(policy.Bindings, remove)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFitPolicy(b *testing.B) {
+	p := newTestProvider(b, newFakeGCP(b))
+	// 100 bindings fit as they are, 1,000 once their expired half is
+	// removed, and 5,000 not even then
+	for _, n := range policySizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				policy := syntheticPolicy(n)
+				b.StartTimer()
+				p.fitPolicy("p", policy, true)
+			}
+		})
+	}
+}
+
+func TestPolicyFieldsKept(t *testing.T) {
+	// Fields gta does not manage, one of them from a future API version
+	fields := map[string]json.RawMessage{
+		"auditConfigs": json.RawMessage(`[{"service":"allServices","auditLogConfigs":[{"logType":"DATA_READ","exemptedMembers":["user:bot@example.com"]},{"logType":"ADMIN_READ"}]}]`),
+		"future":       json.RawMessage(`{"nested":[1,2,3],"flag":true}`),
+	}
+	for _, tc := range []struct {
+		name     string
+		resource string
+		key      string
+		role     string
+		mask     string
+	}{
+		{name: "project", key: "my-project", role: "roles/viewer", mask: policyUpdateMask},
+		{name: "secret", resource: "projects/my-project/secrets/api-key", key: "my-project/secrets/api-key", role: "roles/secretmanager.secretAccessor", mask: policyUpdateMask},
+		{name: "instance", resource: "projects/my-project/zones/europe-west1-b/instances/vm-1", key: "/compute/v1/projects/my-project/zones/europe-west1-b/instances/vm-1", role: "roles/compute.osLogin"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := newFakeGCP(t)
+			fake.SetFields(tc.key, fields)
+			p := newTestProvider(t, fake, WithRetryPolicies(noRetries()))
+			opts := func() *GCPOptions {
+				return &GCPOptions{Project: "my-project", Resource: tc.resource, Roles: []string{tc.role}, TTL: time.Hour, User: "alice@example.com"}
+			}
+
+			if err := p.Grant(opts()); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Revoke(opts()); err != nil {
+				t.Fatal(err)
+			}
+			// A binding left to expire, for clean to remove
+			expired := opts()
+			expired.TTL = time.Millisecond
+			if err := p.Grant(expired); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+			clean := opts()
+			clean.Expired = true
+			if removed, err := p.CleanTemporaryBindings(clean); err != nil || len(removed) != 1 {
+				t.Fatalf("CleanTemporaryBindings = %+v, %v", removed, err)
+			}
+
+			got := fake.Fields(tc.key)
+			for name, value := range fields {
+				if !bytes.Equal(got[name], value) {
+					t.Errorf("%s = %s, want %s", name, got[name], value)
+				}
+			}
+			if len(got) != len(fields) {
+				t.Errorf("fields %v, want %v", got, fields)
+			}
+			writes := 0
+			for _, w := range fake.Writes() {
+				if w.Resource != tc.key {
+					continue
+				}
+				writes++
+				if w.UpdateMask != tc.mask {
+					t.Errorf("update mask %q, want %q", w.UpdateMask, tc.mask)
+				}
+				if w.Etag == "" {
+					t.Errorf("policy written without its etag")
+				}
+			}
+			if writes != 4 {
+				t.Errorf("%d writes, want one each for the two grants, revoke, and clean", writes)
+			}
+		})
+	}
+}
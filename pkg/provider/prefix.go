@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultBindingPrefix prefixes the condition titles of the bindings created
+// by gta unless another prefix is configured
+const DefaultBindingPrefix = "gta_temporary_access"
+
+// bindingPrefixPattern matches the prefixes that keep binding IDs within the
+// 100 characters IAM allows in a condition title, with room for the suffix
+var bindingPrefixPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,79}$`)
+
+// ValidateBindingPrefix checks that prefix can start the condition title of
+// a binding
+func ValidateBindingPrefix(prefix string) error {
+	if !bindingPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("invalid binding prefix %q (expected a letter followed by at most 79 letters, digits, underscores, or hyphens)", prefix)
+	}
+	return nil
+}
+
+// WithBindingPrefix sets the prefix of the bindings the provider creates and
+// matches, and the prefixes of other teams matched only with AnyPrefix
+func WithBindingPrefix(prefix string, known ...string) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.bindingPrefix = prefix
+		p.knownPrefixes = known
+	}
+}
+
+// newBindingID returns the ID of a new binding, its condition title
+func (p *GCPProvider) newBindingID() string {
+	return fmt.Sprintf("%s_%d", p.bindingPrefix, time.Now().UnixNano())
+}
+
+// temporaryTitle reports whether a condition title is the ID of a binding
+// created with the prefix of the provider or, when anyPrefix is set, with
+// any known prefix
+func (p *GCPProvider) temporaryTitle(title string, anyPrefix bool) bool {
+	if hasBindingPrefix(title, p.bindingPrefix) {
+		return true
+	}
+	if !anyPrefix {
+		return false
+	}
+	if hasBindingPrefix(title, DefaultBindingPrefix) {
+		return true
+	}
+	for _, prefix := range p.knownPrefixes {
+		if hasBindingPrefix(title, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBindingPrefix reports whether title is a binding ID made of prefix and
+// a timestamp, so that a prefix extending another one, such as
+// gta_temporary_access_team, is not mistaken for it
+func hasBindingPrefix(title, prefix string) bool {
+	suffix, ok := strings.CutPrefix(title, prefix+"_")
+	if !ok || suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/condition"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+func TestValidateBindingPrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		DefaultBindingPrefix:          true,
+		"team-a":                      true,
+		"T":                           true,
+		"a" + strings.Repeat("b", 79): true,
+		"a" + strings.Repeat("b", 80): false,
+		"":                            false,
+		"1team":                       false,
+		"_team":                       false,
+		"team a":                      false,
+		"team.a":                      false,
+		"team/a":                      false,
+	} {
+		if err := ValidateBindingPrefix(prefix); (err == nil) != valid {
+			t.Errorf("ValidateBindingPrefix(%q) = %v, want valid %v", prefix, err, valid)
+		}
+	}
+}
+
+func TestHasBindingPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		title, prefix string
+		want          bool
+	}{
+		{"gta_temporary_access_1715679000000000000", DefaultBindingPrefix, true},
+		{"team_a_1715679000000000000", "team_a", true},
+		// A prefix extending another one is not that one
+		{"gta_temporary_access_team_1715679000000000000", DefaultBindingPrefix, false},
+		{"team_a_b_1715679000000000000", "team_a", false},
+		{"team_a_", "team_a", false},
+		{"team_a", "team_a", false},
+		{"team_a1715679000000000000", "team_a", false},
+		{"team_a_17156790000000000x", "team_a", false},
+		{"TEAM_A_1715679000000000000", "team_a", false},
+	} {
+		if got := hasBindingPrefix(tc.title, tc.prefix); got != tc.want {
+			t.Errorf("hasBindingPrefix(%q, %q) = %v", tc.title, tc.prefix, got)
+		}
+	}
+}
+
+// mixedPolicy returns a policy holding the expired bindings of team_a,
+// team_b, the default prefix, a prefix extending team_a, and another tool,
+// each for its own member, along with a permanent binding
+func mixedPolicy() *resourcemanager.Policy {
+	expired := condition.Expression(time.Now().Add(-time.Hour))
+	binding := func(title, member string) *resourcemanager.Binding {
+		return &resourcemanager.Binding{
+			Role:      "roles/viewer",
+			Members:   []string{member},
+			Condition: &resourcemanager.Expr{Title: title, Expression: expired},
+		}
+	}
+	return &resourcemanager.Policy{
+		Version: 3,
+		Bindings: []*resourcemanager.Binding{
+			binding("team_a_1715679000000000001", "user:a@example.com"),
+			binding("team_b_1715679000000000002", "user:b@example.com"),
+			binding(DefaultBindingPrefix+"_1715679000000000003", "user:default@example.com"),
+			binding("team_a_ext_1715679000000000004", "user:ext@example.com"),
+			binding("expires_2024", "user:other@example.com"),
+			{Role: "roles/owner", Members: []string{"user:owner@example.com"}},
+		},
+	}
+}
+
+// members returns the sorted members of bindings
+func members(bindings []TemporaryBinding) string {
+	var all []string
+	for _, b := range bindings {
+		all = append(all, strings.TrimPrefix(b.Member, "user:"))
+	}
+	sort.Strings(all)
+	return strings.Join(all, ",")
+}
+
+// policyMembers returns the sorted members left in the policy of project
+func policyMembers(f *fakeGCP, project string) string {
+	var all []string
+	for _, b := range f.Policy(project).Bindings {
+		for _, m := range b.Members {
+			all = append(all, strings.TrimPrefix(m, "user:"))
+		}
+	}
+	sort.Strings(all)
+	return strings.Join(all, ",")
+}
+
+func TestMixedPrefixes(t *testing.T) {
+	newProvider := func(t *testing.T) (*GCPProvider, *fakeGCP) {
+		fake := newFakeGCP(t)
+		fake.SetPolicy("p", mixedPolicy())
+		return newTestProvider(t, fake, WithBindingPrefix("team_a", "team_b"), WithRetryPolicies(noRetries())), fake
+	}
+
+	t.Run("list", func(t *testing.T) {
+		p, _ := newProvider(t)
+		own, err := p.TemporaryBindings(&GCPOptions{Project: "p"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := members(own); got != "a@example.com" {
+			t.Errorf("own bindings of %s", got)
+		}
+		all, err := p.TemporaryBindings(&GCPOptions{Project: "p", AnyPrefix: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := members(all); got != "a@example.com,b@example.com,default@example.com" {
+			t.Errorf("bindings of any prefix of %s", got)
+		}
+	})
+
+	t.Run("clean", func(t *testing.T) {
+		p, fake := newProvider(t)
+		if _, err := p.CleanTemporaryBindings(&GCPOptions{Project: "p", Expired: true}); err != nil {
+			t.Fatal(err)
+		}
+		if got := policyMembers(fake, "p"); got != "b@example.com,default@example.com,ext@example.com,other@example.com,owner@example.com" {
+			t.Errorf("clean left %s", got)
+		}
+	})
+
+	t.Run("clean any prefix", func(t *testing.T) {
+		p, fake := newProvider(t)
+		removed, err := p.CleanTemporaryBindings(&GCPOptions{Project: "p", Expired: true, AnyPrefix: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := members(removed); got != "a@example.com,b@example.com,default@example.com" {
+			t.Errorf("clean --any-prefix removed %s", got)
+		}
+		if got := policyMembers(fake, "p"); got != "ext@example.com,other@example.com,owner@example.com" {
+			t.Errorf("clean --any-prefix left %s", got)
+		}
+	})
+
+	t.Run("grant", func(t *testing.T) {
+		p, fake := newProvider(t)
+		if err := p.Grant(&GCPOptions{Project: "p", Roles: []string{"browser"}, TTL: time.Hour, User: "alice@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		granted := p.GrantedRoles()
+		if len(granted) != 1 || !hasBindingPrefix(granted[0].BindingID, "team_a") {
+			t.Fatalf("granted %+v, want a team_a binding", granted)
+		}
+		// The other teams' bindings are still there
+		if got := policyMembers(fake, "p"); !strings.Contains(got, "b@example.com,default@example.com") {
+			t.Errorf("grant left %s", got)
+		}
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		p, _ := newProvider(t)
+		policy := mixedPolicy()
+		removed, _ := p.expiredBindings(policy)
+		if len(removed) != 1 || removed[0].BindingID != "team_a_1715679000000000001" {
+			t.Errorf("compaction would remove %+v, want the expired team_a binding only", removed)
+		}
+	})
+}
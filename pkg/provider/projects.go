@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	folders "google.golang.org/api/cloudresourcemanager/v2"
+	crmv3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+)
+
+// ProjectScope selects the projects of a bulk operation
+type ProjectScope struct {
+	// Organization is the numeric ID of an organization whose projects, in
+	// any folder, are selected
+	Organization string
+	// Folder is the numeric ID of a folder whose projects, in any subfolder,
+	// are selected
+	Folder string
+	// Filter is a Resource Manager project filter, e.g. labels.env:prod
+	Filter string
+}
+
+// String describes the scope, identifying it in checkpoints and logs
+func (s ProjectScope) String() string {
+	var parts []string
+	if s.Organization != "" {
+		parts = append(parts, "organizations/"+s.Organization)
+	}
+	if s.Folder != "" {
+		parts = append(parts, "folders/"+s.Folder)
+	}
+	if s.Filter != "" {
+		parts = append(parts, "filter="+s.Filter)
+	}
+	if len(parts) == 0 {
+		return "all projects"
+	}
+	return strings.Join(parts, " ")
+}
+
+// setIamPolicyPermission is the permission gta needs on a project to grant
+// roles in it
+const setIamPolicyPermission = "resourcemanager.projects.setIamPolicy"
+
+// ProjectInfo describes a project the caller can see
+type ProjectInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Number string `json:"number"`
+	// Parent is the folder or organization of the project, e.g. folders/123
+	Parent string `json:"parent,omitempty"`
+}
+
+// SearchProjects returns the active projects the caller can see that match
+// query, a Resource Manager search query such as labels.team=payments,
+// sorted by ID
+func (p *GCPProvider) SearchProjects(query string) ([]ProjectInfo, error) {
+	service, err := crmv3.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Resource Manager service: %w", err)
+	}
+
+	query = strings.TrimSpace("state:ACTIVE " + query)
+	var projects []ProjectInfo
+	err = service.Projects.Search().Query(query).Pages(p.ctx, func(page *crmv3.SearchProjectsResponse) error {
+		for _, project := range page.Projects {
+			projects = append(projects, ProjectInfo{
+				ID:     project.ProjectId,
+				Name:   project.DisplayName,
+				Number: strings.TrimPrefix(project.Name, "projects/"),
+				Parent: project.Parent,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("projects.search: %w", apiError(err))
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+	return projects, nil
+}
+
+// CanSetIamPolicy reports whether the caller may change the IAM policy of
+// project, and so grant roles in it with gta
+func (p *GCPProvider) CanSetIamPolicy(project string) (bool, error) {
+	req := &resourcemanager.TestIamPermissionsRequest{Permissions: []string{setIamPolicyPermission}}
+	resp, err := p.service.Projects.TestIamPermissions(project, req).Context(p.ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("projects.testIamPermissions %s: %w", project, apiError(err))
+	}
+	for _, permission := range resp.Permissions {
+		if permission == setIamPolicyPermission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListProjects returns the IDs of the active projects in scope that the
+// caller can see, sorted
+func (p *GCPProvider) ListProjects(scope ProjectScope) ([]string, error) {
+	if scope.Organization != "" && scope.Folder != "" {
+		return nil, fmt.Errorf("a project scope takes an organization or a folder, not both")
+	}
+
+	var parents []string
+	switch {
+	case scope.Organization != "":
+		var err error
+		if parents, err = p.listFolders("organizations/" + scope.Organization); err != nil {
+			return nil, err
+		}
+	case scope.Folder != "":
+		var err error
+		if parents, err = p.listFolders("folders/" + scope.Folder); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(parents) == 0 {
+		return p.listProjects(scope.Filter)
+	}
+	seen := make(map[string]bool)
+	var projects []string
+	for _, parent := range parents {
+		kind, id, _ := strings.Cut(parent, "/")
+		filter := fmt.Sprintf("parent.type:%s parent.id:%s", strings.TrimSuffix(kind, "s"), id)
+		if scope.Filter != "" {
+			filter += " " + scope.Filter
+		}
+		ids, err := p.listProjects(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				projects = append(projects, id)
+			}
+		}
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
+// listProjects returns the IDs of the active projects matching filter
+func (p *GCPProvider) listProjects(filter string) ([]string, error) {
+	filter = strings.TrimSpace("lifecycleState:ACTIVE " + filter)
+	var projects []string
+	err := p.service.Projects.List().Filter(filter).Pages(p.ctx, func(page *resourcemanager.ListProjectsResponse) error {
+		for _, project := range page.Projects {
+			projects = append(projects, project.ProjectId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("projects.list: %w", apiError(err))
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
+
+// listFolders returns parent followed by every active folder below it
+func (p *GCPProvider) listFolders(parent string) ([]string, error) {
+	service, err := folders.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Resource Manager folders service: %w", err)
+	}
+
+	parents := []string{parent}
+	for i := 0; i < len(parents); i++ {
+		err := service.Folders.List().Parent(parents[i]).Pages(p.ctx, func(page *folders.ListFoldersResponse) error {
+			for _, folder := range page.Folders {
+				if folder.LifecycleState == "ACTIVE" {
+					parents = append(parents, folder.Name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("folders.list %s: %w", parents[i], apiError(err))
+		}
+	}
+	return parents, nil
+}
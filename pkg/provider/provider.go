@@ -16,6 +16,7 @@ type Provider interface {
 	// ListTemporaryBindings lists temporary bindings with the given options
 	ListTemporaryBindings(opts Options) error
 
-	// CleanTemporaryBindings lists and optionally removes temporary bindings with the given options
-	CleanTemporaryBindings(opts Options) error
+	// CleanTemporaryBindings lists and optionally removes temporary bindings with the given options,
+	// returning the bindings removed
+	CleanTemporaryBindings(opts Options) ([]TemporaryBinding, error)
 }
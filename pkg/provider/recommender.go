@@ -0,0 +1,211 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	recommender "google.golang.org/api/recommender/v1"
+)
+
+const (
+	// iamRecommender is the recommender suggesting narrower IAM roles
+	iamRecommender = "google.iam.policy.Recommender"
+	// Path filters identifying the role and member of an IAM policy operation
+	rolePathFilter   = "/iamPolicy/bindings/*/role"
+	memberPathFilter = "/iamPolicy/bindings/*/members/*"
+)
+
+// RoleRecommendation suggests narrower roles replacing a role of a member
+type RoleRecommendation struct {
+	Role         string
+	Replacements []string
+	Description  string
+}
+
+// Recommender lists role recommendations for a member of a project
+type Recommender interface {
+	RoleRecommendations(ctx context.Context, project, member string) ([]RoleRecommendation, error)
+}
+
+// BroadRoleError is returned when broad roles have narrower alternatives that
+// were not accepted
+type BroadRoleError struct {
+	Recommendations []RoleRecommendation
+}
+
+func (e *BroadRoleError) Error() string {
+	var roles []string
+	for _, r := range e.Recommendations {
+		roles = append(roles, r.Role)
+	}
+	return fmt.Sprintf("narrower alternatives are recommended for %s", strings.Join(roles, ", "))
+}
+
+// ConfirmFunc asks the user a yes/no question
+type ConfirmFunc func(question string) (bool, error)
+
+// WithRecommender replaces the IAM Recommender client, e.g. with a fake in tests
+func WithRecommender(r Recommender) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.recommender = r
+	}
+}
+
+// WithBroadRoles enables looking up narrower alternatives before granting roles
+// matched by isBroad
+func WithBroadRoles(isBroad func(role string) bool) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.isBroad = isBroad
+	}
+}
+
+// WithConfirm sets how the user confirms granting a broad role with narrower
+// alternatives; without it such grants fail unless accepted up front
+func WithConfirm(confirm ConfirmFunc) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.confirm = confirm
+	}
+}
+
+// gcpRecommender lists recommendations from the IAM Recommender API
+type gcpRecommender struct {
+	service *recommender.Service
+}
+
+// newGCPRecommender creates a Recommender using the provider's HTTP client
+func newGCPRecommender(ctx context.Context, client *http.Client) (*gcpRecommender, error) {
+	service, err := recommender.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Recommender service: %w", err)
+	}
+	return &gcpRecommender{service: service}, nil
+}
+
+// RoleRecommendations implements Recommender
+func (r *gcpRecommender) RoleRecommendations(ctx context.Context, project, member string) ([]RoleRecommendation, error) {
+	parent := fmt.Sprintf("projects/%s/locations/global/recommenders/%s", project, iamRecommender)
+	var recommendations []RoleRecommendation
+	err := r.service.Projects.Locations.Recommenders.Recommendations.List(parent).
+		Filter("stateInfo.state = ACTIVE").
+		Pages(ctx, func(resp *recommender.GoogleCloudRecommenderV1ListRecommendationsResponse) error {
+			for _, rec := range resp.Recommendations {
+				recommendations = append(recommendations, roleRecommendations(rec, member)...)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("recommendations.list %s: %w", parent, apiError(err))
+	}
+	return recommendations, nil
+}
+
+// roleRecommendations maps the operations of a recommendation affecting member
+// to the roles it removes and the roles it adds instead
+func roleRecommendations(rec *recommender.GoogleCloudRecommenderV1Recommendation, member string) []RoleRecommendation {
+	if rec.Content == nil {
+		return nil
+	}
+	var removed []string
+	added := make(map[string]bool)
+	for _, group := range rec.Content.OperationGroups {
+		for _, op := range group.Operations {
+			var filters map[string]interface{}
+			if len(op.PathFilters) > 0 {
+				if err := json.Unmarshal(op.PathFilters, &filters); err != nil {
+					continue
+				}
+			}
+			role, _ := filters[rolePathFilter].(string)
+			switch op.Action {
+			case "remove":
+				if filters[memberPathFilter] == member && role != "" {
+					removed = append(removed, role)
+				}
+			case "add":
+				if op.Value == member && role != "" {
+					added[role] = true
+				}
+			}
+		}
+	}
+	// Recommendations only removing a role suggest no replacement to grant
+	if len(removed) == 0 || len(added) == 0 {
+		return nil
+	}
+
+	replacements := make([]string, 0, len(added))
+	for role := range added {
+		replacements = append(replacements, role)
+	}
+	sort.Strings(replacements)
+	result := make([]RoleRecommendation, 0, len(removed))
+	for _, role := range removed {
+		result = append(result, RoleRecommendation{Role: role, Replacements: replacements, Description: rec.Description})
+	}
+	return result
+}
+
+// checkBroadRoles looks up narrower alternatives to the broad roles in opts and
+// requires them to be accepted, either up front or through confirm. Lookup
+// failures, e.g. when the Recommender API is disabled, never block a grant.
+func (p *GCPProvider) checkBroadRoles(opts *GCPOptions, member string) error {
+	if p.isBroad == nil || p.recommender == nil {
+		return nil
+	}
+	var broad []string
+	for _, role := range opts.Roles {
+		if role = FormatRole(role); p.isBroad(role) {
+			broad = append(broad, role)
+		}
+	}
+	if len(broad) == 0 {
+		return nil
+	}
+
+	all, err := p.recommender.RoleRecommendations(p.ctx, opts.Project, member)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && (apiErr.Code == http.StatusForbidden || apiErr.Code == http.StatusNotFound) {
+			p.log.Debug("Role recommendations unavailable: %v", err)
+		} else {
+			p.log.Warn("Skipping role recommendations: %v", err)
+		}
+		return nil
+	}
+	var found []RoleRecommendation
+	for _, rec := range all {
+		for _, role := range broad {
+			if rec.Role == role {
+				found = append(found, rec)
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	for _, rec := range found {
+		p.log.Warn("IAM Recommender suggests %s instead of %s for %s: %s",
+			strings.Join(rec.Replacements, ", "), rec.Role, member, rec.Description)
+	}
+	if opts.AcceptBroad || p.dryRun {
+		return nil
+	}
+	if p.confirm != nil {
+		ok, err := p.confirm("Grant the broad roles anyway?")
+		if err != nil {
+			return fmt.Errorf("failed to confirm broad roles: %v", err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return &BroadRoleError{Recommendations: found}
+}
@@ -0,0 +1,211 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/yckao/gta/pkg/logger"
+	"google.golang.org/api/googleapi"
+	recommender "google.golang.org/api/recommender/v1"
+)
+
+// fakeRecommender returns fixed recommendations, or err, counting its calls
+type fakeRecommender struct {
+	recommendations []RoleRecommendation
+	err             error
+	calls           int
+}
+
+func (r *fakeRecommender) RoleRecommendations(ctx context.Context, project, member string) ([]RoleRecommendation, error) {
+	r.calls++
+	return r.recommendations, r.err
+}
+
+// broadProvider is a provider treating roles/editor and roles/owner as broad
+func broadProvider(r Recommender, confirm ConfirmFunc) *GCPProvider {
+	return &GCPProvider{
+		ctx:         context.Background(),
+		log:         logger.Default(),
+		recommender: r,
+		confirm:     confirm,
+		isBroad: func(role string) bool {
+			return role == "roles/editor" || role == "roles/owner"
+		},
+	}
+}
+
+var editorToSQLClient = RoleRecommendation{
+	Role:         "roles/editor",
+	Replacements: []string{"roles/cloudsql.client"},
+	Description:  "Replace a role with a more restrictive role",
+}
+
+func TestCheckBroadRoles(t *testing.T) {
+	confirmed := func(answer bool) ConfirmFunc {
+		return func(question string) (bool, error) { return answer, nil }
+	}
+	for _, tc := range []struct {
+		name        string
+		roles       []string
+		rec         *fakeRecommender
+		acceptBroad bool
+		confirm     ConfirmFunc
+		wantErr     bool
+		wantCalls   int
+	}{
+		{
+			name:      "narrow roles skip the lookup",
+			roles:     []string{"viewer"},
+			rec:       &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}},
+			wantCalls: 0,
+		},
+		{
+			name:      "broad role without recommendations",
+			roles:     []string{"editor"},
+			rec:       &fakeRecommender{},
+			wantCalls: 1,
+		},
+		{
+			name:      "recommendations for other roles",
+			roles:     []string{"owner"},
+			rec:       &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}},
+			wantCalls: 1,
+		},
+		{
+			name:      "broad role with a narrower alternative",
+			roles:     []string{"editor"},
+			rec:       &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}},
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:        "accepted up front",
+			roles:       []string{"editor"},
+			rec:         &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}},
+			acceptBroad: true,
+			wantCalls:   1,
+		},
+		{
+			name:      "confirmed interactively",
+			roles:     []string{"editor"},
+			rec:       &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}},
+			confirm:   confirmed(true),
+			wantCalls: 1,
+		},
+		{
+			name:      "declined interactively",
+			roles:     []string{"editor"},
+			rec:       &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}},
+			confirm:   confirmed(false),
+			wantErr:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "Recommender API disabled",
+			roles:     []string{"editor"},
+			rec:       &fakeRecommender{err: &googleapi.Error{Code: http.StatusForbidden, Message: "Recommender API has not been used"}},
+			wantCalls: 1,
+		},
+		{
+			name:      "Recommender API failure",
+			roles:     []string{"editor"},
+			rec:       &fakeRecommender{err: errors.New("connection reset")},
+			wantCalls: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := broadProvider(tc.rec, tc.confirm)
+			opts := &GCPOptions{Project: "p", Roles: tc.roles, AcceptBroad: tc.acceptBroad}
+			err := p.checkBroadRoles(opts, "user:alice@example.com")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				var broad *BroadRoleError
+				if !errors.As(err, &broad) || !reflect.DeepEqual(broad.Recommendations, []RoleRecommendation{editorToSQLClient}) {
+					t.Errorf("err = %#v, want a BroadRoleError with the recommendation", err)
+				}
+			}
+			if tc.rec.calls != tc.wantCalls {
+				t.Errorf("recommender called %d times, want %d", tc.rec.calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCheckBroadRolesDisabled(t *testing.T) {
+	rec := &fakeRecommender{recommendations: []RoleRecommendation{editorToSQLClient}}
+	p := broadProvider(rec, nil)
+	p.isBroad = nil
+	if err := p.checkBroadRoles(&GCPOptions{Project: "p", Roles: []string{"editor"}}, "user:alice@example.com"); err != nil {
+		t.Errorf("err = %v without broad roles configured", err)
+	}
+	if rec.calls != 0 {
+		t.Errorf("recommender called without broad roles configured")
+	}
+}
+
+func TestRoleRecommendations(t *testing.T) {
+	op := func(action, role, member string) *recommender.GoogleCloudRecommenderV1Operation {
+		o := &recommender.GoogleCloudRecommenderV1Operation{Action: action, Path: "/iamPolicy/bindings/*/members/-"}
+		if action == "remove" {
+			o.PathFilters = googleapi.RawMessage(`{"/iamPolicy/bindings/*/condition/expression":"","/iamPolicy/bindings/*/members/*":"` + member + `","/iamPolicy/bindings/*/role":"` + role + `"}`)
+		} else {
+			o.PathFilters = googleapi.RawMessage(`{"/iamPolicy/bindings/*/condition/expression":"","/iamPolicy/bindings/*/role":"` + role + `"}`)
+			o.Value = member
+		}
+		return o
+	}
+	rec := func(ops ...*recommender.GoogleCloudRecommenderV1Operation) *recommender.GoogleCloudRecommenderV1Recommendation {
+		return &recommender.GoogleCloudRecommenderV1Recommendation{
+			Description: "Replace a role with a more restrictive role",
+			Content: &recommender.GoogleCloudRecommenderV1RecommendationContent{
+				OperationGroups: []*recommender.GoogleCloudRecommenderV1OperationGroup{{Operations: ops}},
+			},
+		}
+	}
+	const alice = "user:alice@example.com"
+	for _, tc := range []struct {
+		name string
+		rec  *recommender.GoogleCloudRecommenderV1Recommendation
+		want []RoleRecommendation
+	}{
+		{
+			name: "replacement",
+			rec: rec(
+				op("add", "roles/cloudsql.client", alice),
+				op("add", "roles/cloudsql.viewer", alice),
+				op("remove", "roles/editor", alice),
+			),
+			want: []RoleRecommendation{{
+				Role:         "roles/editor",
+				Replacements: []string{"roles/cloudsql.client", "roles/cloudsql.viewer"},
+				Description:  "Replace a role with a more restrictive role",
+			}},
+		},
+		{
+			name: "removal only",
+			rec:  rec(op("remove", "roles/editor", alice)),
+		},
+		{
+			name: "another member",
+			rec: rec(
+				op("add", "roles/cloudsql.client", "user:bob@example.com"),
+				op("remove", "roles/editor", "user:bob@example.com"),
+			),
+		},
+		{
+			name: "no content",
+			rec:  &recommender.GoogleCloudRecommenderV1Recommendation{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := roleRecommendations(tc.rec, alice); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("roleRecommendations = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// Resource kinds that can be granted on instead of a project
+const (
+	ResourceBucket    = "bucket"
+	ResourceDataset   = "dataset"
+	ResourceSecret    = "secret"
+	ResourceInstance  = "instance"
+	ResourceIAPTunnel = "iap-tunnel"
+	ResourceSubnet    = "subnet"
+)
+
+// IAPTunnelRole allows TCP forwarding to an instance through IAP
+const IAPTunnelRole = "roles/iap.tunnelResourceAccessor"
+
+// instanceRoles are the roles that can be granted on an instance, mapped to
+// the kind of resource they are granted on: the IAP tunnel resource of the
+// instance, or the instance itself
+var instanceRoles = map[string]string{
+	IAPTunnelRole:                ResourceIAPTunnel,
+	"roles/compute.osLogin":      ResourceInstance,
+	"roles/compute.osAdminLogin": ResourceInstance,
+}
+
+// setPolicyRequest is the body of a setIamPolicy call. Only the APIs that
+// take an update mask are sent one.
+type setPolicyRequest struct {
+	Policy     json.RawMessage `json:"policy"`
+	UpdateMask string          `json:"updateMask,omitempty"`
+}
+
+// resourceKind describes how the IAM policy of a kind of resource is read
+// and written through its REST API
+type resourceKind struct {
+	name    string
+	pattern *regexp.Regexp
+	// getRequest and setRequest build the requests of the resource named by
+	// the submatches of pattern, setRequest with the encoded policy
+	getRequest func(m []string) (string, string, interface{})
+	setRequest func(m []string, policy json.RawMessage) (string, string, interface{})
+	// deniedHint explains a permission denied error, if set
+	deniedHint func(m []string) string
+}
+
+var resourceKinds = []resourceKind{
+	{
+		name:    ResourceBucket,
+		pattern: regexp.MustCompile(`^buckets/([a-z0-9][a-z0-9._-]{1,220}[a-z0-9])$`),
+		getRequest: func(m []string) (string, string, interface{}) {
+			return http.MethodGet, fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/iam?optionsRequestedPolicyVersion=%d", url.PathEscape(m[1]), policyVersion), nil
+		},
+		setRequest: func(m []string, policy json.RawMessage) (string, string, interface{}) {
+			return http.MethodPut, fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/iam", url.PathEscape(m[1])), policy
+		},
+	},
+	{
+		name:    ResourceDataset,
+		pattern: regexp.MustCompile(`^projects/([a-z][a-z0-9:.-]{4,61}[a-z0-9])/datasets/([A-Za-z0-9_]{1,1000})$`),
+		getRequest: func(m []string) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s:getIamPolicy", m[1], m[2]),
+				&resourcemanager.GetIamPolicyRequest{Options: &resourcemanager.GetPolicyOptions{RequestedPolicyVersion: policyVersion}}
+		},
+		setRequest: func(m []string, policy json.RawMessage) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://bigquery.googleapis.com/bigquery/v2/projects/%s/datasets/%s:setIamPolicy", m[1], m[2]),
+				setPolicyRequest{Policy: policy, UpdateMask: policyUpdateMask}
+		},
+	},
+	{
+		name:    ResourceSecret,
+		pattern: regexp.MustCompile(`^projects/([a-z][a-z0-9-]{4,28}[a-z0-9])/secrets/([A-Za-z0-9_-]{1,255})$`),
+		getRequest: func(m []string) (string, string, interface{}) {
+			return http.MethodGet, fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s:getIamPolicy?options.requestedPolicyVersion=%d", m[1], m[2], policyVersion), nil
+		},
+		setRequest: func(m []string, policy json.RawMessage) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s:setIamPolicy", m[1], m[2]),
+				setPolicyRequest{Policy: policy, UpdateMask: policyUpdateMask}
+		},
+	},
+	{
+		name:    ResourceInstance,
+		pattern: regexp.MustCompile(`^projects/([a-z][a-z0-9-]{4,28}[a-z0-9])/zones/([a-z]+-[a-z]+[0-9]+-[a-z])/instances/([a-z](?:[a-z0-9-]{0,61}[a-z0-9])?)$`),
+		getRequest: func(m []string) (string, string, interface{}) {
+			return http.MethodGet, fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s/getIamPolicy?optionsRequestedPolicyVersion=%d", m[1], m[2], m[3], policyVersion), nil
+		},
+		setRequest: func(m []string, policy json.RawMessage) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s/setIamPolicy", m[1], m[2], m[3]),
+				setPolicyRequest{Policy: policy}
+		},
+	},
+	{
+		name:    ResourceIAPTunnel,
+		pattern: regexp.MustCompile(`^projects/([a-z][a-z0-9-]{4,28}[a-z0-9])/iap_tunnel/zones/([a-z]+-[a-z]+[0-9]+-[a-z])/instances/([a-z](?:[a-z0-9-]{0,61}[a-z0-9])?)$`),
+		getRequest: func(m []string) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://iap.googleapis.com/v1/projects/%s/iap_tunnel/zones/%s/instances/%s:getIamPolicy", m[1], m[2], m[3]),
+				&resourcemanager.GetIamPolicyRequest{Options: &resourcemanager.GetPolicyOptions{RequestedPolicyVersion: policyVersion}}
+		},
+		setRequest: func(m []string, policy json.RawMessage) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://iap.googleapis.com/v1/projects/%s/iap_tunnel/zones/%s/instances/%s:setIamPolicy", m[1], m[2], m[3]),
+				setPolicyRequest{Policy: policy}
+		},
+	},
+	{
+		name:    ResourceSubnet,
+		pattern: regexp.MustCompile(`^projects/([a-z][a-z0-9-]{4,28}[a-z0-9])/regions/([a-z]+-[a-z]+[0-9]+)/subnetworks/([a-z](?:[a-z0-9-]{0,61}[a-z0-9])?)$`),
+		getRequest: func(m []string) (string, string, interface{}) {
+			return http.MethodGet, fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/regions/%s/subnetworks/%s/getIamPolicy?optionsRequestedPolicyVersion=%d", m[1], m[2], m[3], policyVersion), nil
+		},
+		setRequest: func(m []string, policy json.RawMessage) (string, string, interface{}) {
+			return http.MethodPost, fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/regions/%s/subnetworks/%s/setIamPolicy", m[1], m[2], m[3]),
+				setPolicyRequest{Policy: policy}
+		},
+		deniedHint: func(m []string) string {
+			return fmt.Sprintf("subnetwork %s is in the Shared VPC host project %s, where editing its policy needs compute.subnetworks.getIamPolicy and compute.subnetworks.setIamPolicy, e.g. through roles/compute.networkAdmin on projects/%s", m[3], m[1], m[1])
+		},
+	},
+}
+
+// ParseResource validates the name of a resource to grant on, e.g.
+// buckets/my-bucket, projects/my-project/datasets/sales,
+// projects/my-project/secrets/api-key, or
+// projects/my-project/zones/europe-west1-b/instances/bastion, or
+// projects/host/regions/us-central1/subnetworks/app, and returns its kind
+func ParseResource(name string) (string, error) {
+	if kind, _, ok := lookupResource(name); ok {
+		return kind.name, nil
+	}
+	return "", fmt.Errorf("unsupported resource %q (expected buckets/BUCKET, projects/PROJECT/datasets/DATASET, projects/PROJECT/secrets/SECRET, projects/PROJECT/zones/ZONE/instances/INSTANCE, or projects/PROJECT/regions/REGION/subnetworks/SUBNET)", name)
+}
+
+// ResourceKinds returns the kinds of resources that can be granted on
+func ResourceKinds() []string {
+	kinds := make([]string, 0, len(resourceKinds))
+	for _, kind := range resourceKinds {
+		kinds = append(kinds, kind.name)
+	}
+	return kinds
+}
+
+// ResourceProject returns the project in the name of a resource, empty when
+// it names none, as with buckets
+func ResourceProject(name string) string {
+	rest, ok := strings.CutPrefix(name, "projects/")
+	if !ok {
+		return ""
+	}
+	project, _, _ := strings.Cut(rest, "/")
+	return project
+}
+
+// InstanceResource returns the resource name of a Compute Engine instance
+func InstanceResource(project, zone, instance string) string {
+	return fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, instance)
+}
+
+// ParseInstance splits the resource name of a Compute Engine instance into
+// its project, zone, and name
+func ParseInstance(name string) (project, zone, instance string, ok bool) {
+	kind, m, found := lookupResource(name)
+	if !found || kind.name != ResourceInstance {
+		return "", "", "", false
+	}
+	return m[1], m[2], m[3], true
+}
+
+// CheckResourceRoles fails when a role cannot be granted on resource, as is
+// the case of roles outside the IAP and OS Login family on an instance
+func CheckResourceRoles(resource string, roles []string) error {
+	if _, _, _, ok := ParseInstance(resource); !ok {
+		return nil
+	}
+	for _, role := range roles {
+		if _, ok := instanceRoles[FormatRole(role)]; !ok {
+			return fmt.Errorf("role %s cannot be granted on instance %s, only %s, roles/compute.osLogin, and roles/compute.osAdminLogin can", FormatRole(role), resource, IAPTunnelRole)
+		}
+	}
+	return nil
+}
+
+// relatedTargets returns the targets whose policies hold the bindings of a
+// grant on target: an instance and its IAP tunnel resource, or target alone
+func relatedTargets(target string) []string {
+	if project, zone, instance, ok := ParseInstance(target); ok {
+		return []string{target, fmt.Sprintf("projects/%s/iap_tunnel/zones/%s/instances/%s", project, zone, instance)}
+	}
+	return []string{target}
+}
+
+// RoleResource names the project or resource whose policy holds role once
+// granted on target, e.g. the IAP tunnel resource of an instance for
+// roles/iap.tunnelResourceAccessor, as in TemporaryBinding.Resource
+func RoleResource(target, role string) string {
+	return describeTarget(roleTarget(target, FormatRole(role)))
+}
+
+// lookupResource finds the kind of a resource name along with the
+// submatches its requests are built from
+func lookupResource(name string) (*resourceKind, []string, bool) {
+	for i := range resourceKinds {
+		if m := resourceKinds[i].pattern.FindStringSubmatch(name); m != nil {
+			return &resourceKinds[i], m, true
+		}
+	}
+	return nil, nil, false
+}
+
+// isResource reports whether target, the key policies are read and written
+// with, names a resource rather than a project
+func isResource(target string) bool {
+	return strings.Contains(target, "/")
+}
+
+// describeTarget names the project or resource target for messages
+func describeTarget(target string) string {
+	if isResource(target) {
+		return target
+	}
+	return "projects/" + target
+}
+
+// getResourcePolicy reads the IAM policy of a resource
+func (p *GCPProvider) getResourcePolicy(name string) (*resourcemanager.Policy, error) {
+	kind, m, ok := lookupResource(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource %q", name)
+	}
+	method, endpoint, body := kind.getRequest(m)
+	var data json.RawMessage
+	if err := p.callResource(method, endpoint, body, &data); err != nil {
+		return nil, fmt.Errorf("getIamPolicy: %w", kind.explain(m, err))
+	}
+	return p.fields.decode(name, data)
+}
+
+// setResourcePolicy writes the IAM policy of a resource
+func (p *GCPProvider) setResourcePolicy(name string, policy *resourcemanager.Policy) (*resourcemanager.Policy, error) {
+	kind, m, ok := lookupResource(name)
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource %q", name)
+	}
+	encoded, err := p.fields.encode(name, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode policy: %v", err)
+	}
+	method, endpoint, body := kind.setRequest(m, encoded)
+	var data json.RawMessage
+	if err := p.callResource(method, endpoint, body, &data); err != nil {
+		return nil, fmt.Errorf("setIamPolicy: %w", kind.explain(m, err))
+	}
+	return p.fields.decode(name, data)
+}
+
+// explain adds the hint of the kind to a permission denied error
+func (k *resourceKind) explain(m []string, err error) error {
+	if k.deniedHint == nil || !PermissionDenied(err) {
+		return err
+	}
+	return fmt.Errorf("%w (%s)", err, k.deniedHint(m))
+}
+
+// callResource sends a JSON request to the API of a resource and decodes
+// the response into out
+func (p *GCPProvider) callResource(method, endpoint string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(p.ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return apiError(err)
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return apiError(err)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
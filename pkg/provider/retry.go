@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/retry"
+)
+
+// Retry categories, the kinds of failures retried by their own policy
+const (
+	// RetryConflicts retries writes of a policy changed concurrently, after
+	// reading it again
+	RetryConflicts = "conflicts"
+	// RetryTransient retries API calls failing with 429, a 5xx status, or a
+	// network error
+	RetryTransient = "transient"
+	// RetryPropagation polls a policy again while revoked bindings are still
+	// found in it
+	RetryPropagation = "propagation"
+)
+
+// RetryCategories lists the retry categories in the order they are shown
+var RetryCategories = []string{RetryConflicts, RetryTransient, RetryPropagation}
+
+// RetryPolicies are the retry policies of a provider by category
+type RetryPolicies map[string]retry.Policy
+
+// DefaultRetryPolicies returns the retry policies of a provider not given
+// any
+func DefaultRetryPolicies() RetryPolicies {
+	return RetryPolicies{
+		RetryConflicts:   {MaxRetries: 2, Backoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second},
+		RetryTransient:   {MaxRetries: 3, MaxElapsed: 30 * time.Second, Backoff: time.Second, MaxBackoff: 10 * time.Second},
+		RetryPropagation: {MaxRetries: 1, Backoff: 2 * time.Second, MaxBackoff: 10 * time.Second},
+	}
+}
+
+// WithRetryPolicies sets the retry policies of the categories in policies,
+// leaving the others at their default
+func WithRetryPolicies(policies RetryPolicies) GCPProviderOption {
+	return func(p *GCPProvider) {
+		for category, policy := range policies {
+			p.retry[category] = policy
+		}
+	}
+}
+
+// retryConflict retries write, whose first attempt failed with err, while
+// it fails as the policy of target changed concurrently. write reads the
+// policy again.
+func (p *GCPProvider) retryConflict(log *logger.Logger, target string, err error, write func() error) error {
+	policy := p.retry[RetryConflicts]
+	return retry.Do(p.ctx, policy, isConflict, func(attempt int) error {
+		if attempt == 1 {
+			return err
+		}
+		log.Warn("Policy of %s changed concurrently, retrying (attempt %d/%d)", describeTarget(target), attempt, policy.MaxRetries+1)
+		return write()
+	})
+}
+
+// isConflict reports whether err is an etag conflict
+func isConflict(err error) bool {
+	return errorClass(err) == errorClassConflict
+}
+
+// retryTransport is an http.RoundTripper retrying the API calls failing
+// with a transient error, for the calls safe to send again
+type retryTransport struct {
+	Base   http.RoundTripper
+	Policy retry.Policy
+}
+
+// statusError is a response with a transient status, retried by
+// retryTransport
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return http.StatusText(e.code)
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Policy.MaxRetries == 0 || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return t.Base.RoundTrip(req)
+	}
+	ctx := req.Context()
+	idempotent := idempotentCall(req)
+	var resp *http.Response
+	err := retry.Do(ctx, t.Policy, func(err error) bool {
+		return transientFailure(ctx, err, idempotent)
+	}, func(attempt int) error {
+		attemptReq := req
+		if attempt > 1 {
+			if resp != nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			attemptReq = req.WithContext(logger.WithAttempt(ctx, attempt))
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+				attemptReq.Body = body
+			}
+		}
+		var err error
+		resp, err = t.Base.RoundTrip(attemptReq)
+		if err != nil {
+			resp = nil
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return &statusError{code: resp.StatusCode}
+		}
+		return nil
+	})
+	var status *statusError
+	if errors.As(err, &status) {
+		return resp, nil
+	}
+	return resp, err
+}
+
+// transientFailure reports whether a call that failed with err is worth
+// retrying. Calls that are not idempotent are only retried when the server
+// tells that it did not process them.
+func transientFailure(ctx context.Context, err error, idempotent bool) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return idempotent || status.code == http.StatusTooManyRequests || status.code == http.StatusServiceUnavailable
+	}
+	return idempotent
+}
+
+// idempotentCall reports whether req can be sent twice without effect:
+// reads, and policy writes, which the etag they carry guards
+func idempotentCall(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	for _, method := range []string{":getIamPolicy", ":setIamPolicy", ":testIamPermissions"} {
+		if strings.HasSuffix(req.URL.Path, method) {
+			return true
+		}
+	}
+	return false
+}
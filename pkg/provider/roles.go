@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	iam "google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+)
+
+// rolesPageSize is the largest page size accepted by the roles API
+const rolesPageSize = 1000
+
+// Launch stages of roles
+const (
+	StageAlpha      = "ALPHA"
+	StageBeta       = "BETA"
+	StageGA         = "GA"
+	StageDeprecated = "DEPRECATED"
+	StageDisabled   = "DISABLED"
+	StageEAP        = "EAP"
+)
+
+// RoleStages are the launch stages of roles, from the earliest to the last
+var RoleStages = []string{StageEAP, StageAlpha, StageBeta, StageGA, StageDeprecated, StageDisabled}
+
+// roleNamePattern matches the predefined roles named in a description
+var roleNamePattern = regexp.MustCompile(`roles/[a-zA-Z0-9_.]+[a-zA-Z0-9_]`)
+
+// PredefinedRole is a predefined IAM role and the permissions it includes.
+// Custom roles are listed in the same shape.
+type PredefinedRole struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Stage       string   `json:"stage"`
+	Permissions []string `json:"permissions"`
+}
+
+// Retired reports whether the role is deprecated or disabled
+func (r PredefinedRole) Retired() bool {
+	return r.Stage == StageDeprecated || r.Stage == StageDisabled
+}
+
+// Replacement returns the role a deprecated or disabled role suggests
+// instead, the first other predefined role its description names, empty
+// when it names none. The roles API has no field of its own for it.
+func (r PredefinedRole) Replacement() string {
+	if !r.Retired() {
+		return ""
+	}
+	for _, name := range roleNamePattern.FindAllString(r.Description, -1) {
+		if name != r.Name {
+			return name
+		}
+	}
+	return ""
+}
+
+// Role returns a predefined role, roles/ROLE or ROLE, or a custom role,
+// projects/PROJECT/roles/ROLE or organizations/ORG/roles/ROLE, with its
+// launch stage and permissions
+func (p *GCPProvider) Role(name string) (PredefinedRole, error) {
+	service, err := iam.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return PredefinedRole{}, fmt.Errorf("failed to create IAM service: %w", err)
+	}
+
+	var role *iam.Role
+	switch {
+	case strings.HasPrefix(name, "projects/"):
+		role, err = service.Projects.Roles.Get(name).Context(p.ctx).Do()
+	case strings.HasPrefix(name, "organizations/"):
+		role, err = service.Organizations.Roles.Get(name).Context(p.ctx).Do()
+	default:
+		name = FormatRole(name)
+		role, err = service.Roles.Get(name).Context(p.ctx).Do()
+	}
+	if err != nil {
+		return PredefinedRole{}, fmt.Errorf("roles.get %s: %w", name, apiError(err))
+	}
+	found := appendRoles(nil, []*iam.Role{role})
+	if len(found) == 0 {
+		return PredefinedRole{}, fmt.Errorf("role %s is deleted", name)
+	}
+	return found[0], nil
+}
+
+// PredefinedRoles lists every predefined IAM role with its permissions
+func (p *GCPProvider) PredefinedRoles() ([]PredefinedRole, error) {
+	service, err := iam.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IAM service: %w", err)
+	}
+
+	var roles []PredefinedRole
+	err = service.Roles.List().View("FULL").PageSize(rolesPageSize).Pages(p.ctx, func(resp *iam.ListRolesResponse) error {
+		roles = appendRoles(roles, resp.Roles)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("roles.list: %w", apiError(err))
+	}
+	return roles, nil
+}
+
+// CustomRoles lists the custom roles of a project with their permissions
+func (p *GCPProvider) CustomRoles(project string) ([]PredefinedRole, error) {
+	service, err := iam.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IAM service: %w", err)
+	}
+
+	var roles []PredefinedRole
+	err = service.Projects.Roles.List("projects/"+project).View("FULL").PageSize(rolesPageSize).Pages(p.ctx, func(resp *iam.ListRolesResponse) error {
+		roles = appendRoles(roles, resp.Roles)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("projects.roles.list %s: %w", project, apiError(err))
+	}
+	return roles, nil
+}
+
+// appendRoles appends the roles of a page that are not deleted to roles
+func appendRoles(roles []PredefinedRole, page []*iam.Role) []PredefinedRole {
+	for _, role := range page {
+		if role.Deleted {
+			continue
+		}
+		roles = append(roles, PredefinedRole{
+			Name:        role.Name,
+			Title:       role.Title,
+			Description: role.Description,
+			Stage:       role.Stage,
+			Permissions: role.IncludedPermissions,
+		})
+	}
+	return roles
+}
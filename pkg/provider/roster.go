@@ -0,0 +1,228 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/fileutil"
+	cloudidentity "google.golang.org/api/cloudidentity/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// DefaultRosterLookupTimeout bounds a group membership lookup of the
+	// granter roster when no timeout is configured
+	DefaultRosterLookupTimeout = 5 * time.Second
+	// DefaultRosterCacheTTL is how long a group membership looked up is
+	// reused when no TTL is configured
+	DefaultRosterCacheTTL = 10 * time.Minute
+)
+
+// GranterRoster restricts modifying policies to the callers it lists, on top
+// of what IAM allows them
+type GranterRoster struct {
+	// Members are the emails of the callers allowed, and group:EMAIL the
+	// groups whose members, direct or not, are
+	Members []string
+	// Source names where the roster is configured in errors, e.g.
+	// granter_roster in /home/alice/.gta.yaml
+	Source string
+	// Timeout bounds each group membership lookup
+	Timeout time.Duration
+	// FailOpen allows a caller whose group memberships cannot be looked up,
+	// e.g. as the Cloud Identity API is unreachable, instead of refusing it
+	FailOpen bool
+	// CachePath is the file group memberships are cached in for CacheTTL,
+	// none when empty
+	CachePath string
+	CacheTTL  time.Duration
+}
+
+// WithGranterRoster restricts modifying policies to the callers of roster,
+// none when nil
+func WithGranterRoster(roster *GranterRoster) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.roster = roster
+	}
+}
+
+// GranterRosterError is returned when the caller is not on the granter roster
+type GranterRosterError struct {
+	Caller string
+	Source string
+	// Lookup is the failure of a group membership lookup that left the
+	// caller unconfirmed, nil when the caller is not a member
+	Lookup error
+}
+
+// Error implements error
+func (e *GranterRosterError) Error() string {
+	if e.Lookup != nil {
+		return fmt.Sprintf("%s could not be confirmed on the roster of %s, which restricts who may modify IAM policies with gta: %v", e.Caller, e.Source, e.Lookup)
+	}
+	return fmt.Sprintf("%s is not on the roster of %s, which restricts who may modify IAM policies with gta", e.Caller, e.Source)
+}
+
+// Unwrap returns the failed lookup
+func (e *GranterRosterError) Unwrap() error {
+	return e.Lookup
+}
+
+// checkRoster checks the caller against the granter roster, once per
+// provider: an identity of the caller, see CallerIdentities, must be listed
+// or belong to a group listed
+func (p *GCPProvider) checkRoster() error {
+	if p.roster == nil {
+		return nil
+	}
+	if p.rosterChecked {
+		return p.rosterErr
+	}
+	p.rosterErr = p.lookupRoster()
+	p.rosterChecked = true
+	return p.rosterErr
+}
+
+// lookupRoster looks up the caller in the granter roster
+func (p *GCPProvider) lookupRoster() error {
+	identities, err := p.CallerIdentities()
+	if err != nil {
+		return err
+	}
+	var groups []string
+	for _, member := range p.roster.Members {
+		if group, ok := strings.CutPrefix(member, "group:"); ok {
+			groups = append(groups, group)
+			continue
+		}
+		for _, identity := range identities {
+			if strings.EqualFold(identity, member) {
+				p.log.Debug("%s is on the roster of %s", identity, p.roster.Source)
+				return nil
+			}
+		}
+	}
+
+	cache := readMembershipCache(p.roster.CachePath)
+	var lookupErr error
+	for _, group := range groups {
+		for _, identity := range identities {
+			member, err := p.groupMember(cache, group, identity)
+			if err != nil {
+				lookupErr = err
+				continue
+			}
+			if member {
+				p.log.Debug("%s is on the roster of %s as a member of %s", identity, p.roster.Source, group)
+				return nil
+			}
+		}
+	}
+	if lookupErr != nil && p.roster.FailOpen {
+		p.log.Warn("Allowing %s, whose membership of the groups of %s could not be checked: %v", identities[0], p.roster.Source, lookupErr)
+		return nil
+	}
+	return &GranterRosterError{Caller: identities[0], Source: p.roster.Source, Lookup: lookupErr}
+}
+
+// groupMember reports whether email is a member of group, directly or
+// through other groups, from the cache when it holds a recent answer
+func (p *GCPProvider) groupMember(cache *membershipCache, group, email string) (bool, error) {
+	key := strings.ToLower(group + " " + email)
+	ttl := p.roster.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultRosterCacheTTL
+	}
+	if entry, ok := cache.Entries[key]; ok && time.Since(entry.CheckedAt) < ttl {
+		return entry.Member, nil
+	}
+
+	timeout := p.roster.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRosterLookupTimeout
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	member, err := p.lookupGroupMember(ctx, group, email)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, fmt.Errorf("looking up the members of %s timed out after %s: %w", group, timeout, err)
+		}
+		return false, err
+	}
+
+	cache.Entries[key] = membershipEntry{Member: member, CheckedAt: time.Now().UTC()}
+	if err := cache.write(p.roster.CachePath); err != nil {
+		p.log.Debug("Not caching the members of %s: %v", group, err)
+	}
+	return member, nil
+}
+
+// lookupGroupMember asks Cloud Identity whether email is a transitive member
+// of group. The credentials need the cloud-identity.groups.readonly scope.
+func (p *GCPProvider) lookupGroupMember(ctx context.Context, group, email string) (bool, error) {
+	client, err := p.newHTTPClient(cloudidentity.CloudIdentityGroupsReadonlyScope)
+	if err != nil {
+		return false, fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+	service, err := cloudidentity.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return false, fmt.Errorf("failed to create Cloud Identity service: %w", err)
+	}
+	found, err := service.Groups.Lookup().GroupKeyId(group).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("failed to look up group %s: %w", group, apiError(err))
+	}
+	query := fmt.Sprintf("member_key_id == '%s'", strings.ReplaceAll(email, "'", ""))
+	checked, err := service.Groups.Memberships.CheckTransitiveMembership(found.Name).Query(query).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("failed to check the members of %s: %w", group, apiError(err))
+	}
+	return checked.HasMembership, nil
+}
+
+// membershipCache is the on-disk cache of group memberships, by group and
+// member
+type membershipCache struct {
+	Entries map[string]membershipEntry `json:"entries"`
+}
+
+// membershipEntry is a cached group membership and when it was looked up
+type membershipEntry struct {
+	Member    bool      `json:"member"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// readMembershipCache reads the cache at path, empty when there is none
+func readMembershipCache(path string) *membershipCache {
+	cache := &membershipCache{}
+	if path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, cache)
+		}
+	}
+	if cache.Entries == nil {
+		cache.Entries = make(map[string]membershipEntry)
+	}
+	return cache
+}
+
+// write stores the cache at path, unless empty
+func (c *membershipCache) write(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode group membership cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return fileutil.WriteAtomic(path, data)
+}
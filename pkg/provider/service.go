@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// knownServices are the services whose resources grants are commonly
+// confined to with resource.service
+var knownServices = map[string]bool{
+	"aiplatform.googleapis.com":           true,
+	"artifactregistry.googleapis.com":     true,
+	"bigquery.googleapis.com":             true,
+	"bigtableadmin.googleapis.com":        true,
+	"cloudfunctions.googleapis.com":       true,
+	"cloudkms.googleapis.com":             true,
+	"cloudresourcemanager.googleapis.com": true,
+	"cloudscheduler.googleapis.com":       true,
+	"cloudsql.googleapis.com":             true,
+	"cloudtasks.googleapis.com":           true,
+	"compute.googleapis.com":              true,
+	"container.googleapis.com":            true,
+	"dataflow.googleapis.com":             true,
+	"dataproc.googleapis.com":             true,
+	"datastore.googleapis.com":            true,
+	"dns.googleapis.com":                  true,
+	"firestore.googleapis.com":            true,
+	"iam.googleapis.com":                  true,
+	"iap.googleapis.com":                  true,
+	"logging.googleapis.com":              true,
+	"monitoring.googleapis.com":           true,
+	"pubsub.googleapis.com":               true,
+	"redis.googleapis.com":                true,
+	"run.googleapis.com":                  true,
+	"secretmanager.googleapis.com":        true,
+	"spanner.googleapis.com":              true,
+	"sqladmin.googleapis.com":             true,
+	"storage.googleapis.com":              true,
+	"workflows.googleapis.com":            true,
+}
+
+// serviceNamePattern matches the DNS-style names of Google APIs
+var serviceNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*(\.[a-z][a-z0-9-]*)+$`)
+
+// CheckService checks that service is the name of an API, e.g.
+// storage.googleapis.com, and reports whether it is a known one. An unknown
+// name may be a service gta does not list, or a typo confining the grant to
+// nothing.
+func CheckService(service string) (bool, error) {
+	if !serviceNamePattern.MatchString(service) {
+		return false, fmt.Errorf("invalid service %q, expected the name of an API such as storage.googleapis.com", service)
+	}
+	return knownServices[service], nil
+}
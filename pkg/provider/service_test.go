@@ -0,0 +1,26 @@
+package provider
+
+import "testing"
+
+func TestCheckService(t *testing.T) {
+	for _, tc := range []struct {
+		service   string
+		wantKnown bool
+		wantErr   bool
+	}{
+		{service: "storage.googleapis.com", wantKnown: true},
+		{service: "bigquery.googleapis.com", wantKnown: true},
+		{service: "newapi.googleapis.com"},
+		{service: "storage-v2.example.com"},
+		{service: "storage", wantErr: true},
+		{service: "Storage.googleapis.com", wantErr: true},
+		{service: "storage.googleapis.com'", wantErr: true},
+		{service: "storage.googleapis.com' || true || '", wantErr: true},
+		{service: "", wantErr: true},
+	} {
+		known, err := CheckService(tc.service)
+		if (err != nil) != tc.wantErr || known != tc.wantKnown {
+			t.Errorf("CheckService(%q) = %v, %v, want %v and an error %v", tc.service, known, err, tc.wantKnown, tc.wantErr)
+		}
+	}
+}
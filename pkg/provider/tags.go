@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	crmv3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/option"
+)
+
+// TagMatch is a tag the resources a binding applies to must carry
+type TagMatch struct {
+	// KeyID and ValueID are the IDs conditions match, e.g. tagKeys/123 and
+	// tagValues/456
+	KeyID   string
+	ValueID string
+	// Name is the namespaced name of the tag value, e.g.
+	// 123456789012/env/prod
+	Name string
+}
+
+// String describes the tag along with its IDs, e.g. 123456789012/env=prod
+// (tagKeys/123=tagValues/456)
+func (t TagMatch) String() string {
+	return fmt.Sprintf("%s (%s=%s)", tagDisplayName(t.Name), t.KeyID, t.ValueID)
+}
+
+// tagDisplayName turns the namespaced name of a tag value into KEY=VALUE,
+// e.g. 123456789012/env=prod
+func tagDisplayName(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i] + "=" + name[i+1:]
+	}
+	return name
+}
+
+// tagsService returns the Resource Manager service of tag keys and values
+func (p *GCPProvider) tagsService() (*crmv3.Service, error) {
+	service, err := crmv3.NewService(p.ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Resource Manager service: %w", err)
+	}
+	return service, nil
+}
+
+// ResolveTagMatch resolves spec, KEY=VALUE, to the IDs of a tag for a grant
+// in project. The key and value are given as IDs, tagKeys/123=tagValues/456,
+// by namespaced name, 123456789012/env=prod with the organization ID or
+// project ID owning the key, or by short name, env=prod, in the namespace of
+// the organization of project, or of project when it has none.
+func (p *GCPProvider) ResolveTagMatch(project, spec string) (TagMatch, error) {
+	key, value, ok := strings.Cut(spec, "=")
+	if !ok || key == "" || value == "" {
+		return TagMatch{}, fmt.Errorf("invalid tag %q, expected KEY=VALUE such as env=prod or tagKeys/123=tagValues/456", spec)
+	}
+	service, err := p.tagsService()
+	if err != nil {
+		return TagMatch{}, err
+	}
+
+	if strings.HasPrefix(key, "tagKeys/") || strings.HasPrefix(value, "tagValues/") {
+		if !strings.HasPrefix(key, "tagKeys/") || !strings.HasPrefix(value, "tagValues/") {
+			return TagMatch{}, fmt.Errorf("invalid tag %q, give both the key and the value by ID, or both by name", spec)
+		}
+		tv, err := service.TagValues.Get(value).Context(p.ctx).Do()
+		if err != nil {
+			return TagMatch{}, fmt.Errorf("failed to look up tag value %s: %w", value, apiError(err))
+		}
+		if tv.Parent != key {
+			return TagMatch{}, fmt.Errorf("tag value %s (%s) belongs to key %s, not %s", value, tv.NamespacedName, tv.Parent, key)
+		}
+		p.cacheTagName(tv)
+		return TagMatch{KeyID: key, ValueID: value, Name: tv.NamespacedName}, nil
+	}
+
+	if !strings.Contains(key, "/") {
+		namespace, err := p.tagNamespace(project)
+		if err != nil {
+			return TagMatch{}, err
+		}
+		key = namespace + "/" + key
+	}
+	name := key + "/" + value
+	tv, err := service.TagValues.GetNamespaced().Name(name).Context(p.ctx).Do()
+	if err != nil {
+		return TagMatch{}, fmt.Errorf("failed to look up tag %s: %w", tagDisplayName(name), apiError(err))
+	}
+	p.cacheTagName(tv)
+	return TagMatch{KeyID: tv.Parent, ValueID: tv.Name, Name: tv.NamespacedName}, nil
+}
+
+// tagNamespace returns the namespace of the tag keys named without one for
+// project: the ID of its organization, or project itself when it has none
+func (p *GCPProvider) tagNamespace(project string) (string, error) {
+	ancestry, err := p.service.Projects.GetAncestry(project, &resourcemanager.GetAncestryRequest{}).Context(p.ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the organization of project %s for its tags: %w", project, apiError(err))
+	}
+	for _, ancestor := range ancestry.Ancestor {
+		if ancestor.ResourceId != nil && ancestor.ResourceId.Type == "organization" {
+			return ancestor.ResourceId.Id, nil
+		}
+	}
+	return project, nil
+}
+
+// cacheTagName records the namespaced name of tv for describeTags
+func (p *GCPProvider) cacheTagName(tv *crmv3.TagValue) {
+	if p.tagNames == nil {
+		p.tagNames = make(map[string]string)
+	}
+	p.tagNames[tv.Name] = tv.NamespacedName
+}
+
+// describeTags names the tags of condition.Tags, reading the names of tag
+// values given by ID once per provider. A tag whose name cannot be read is
+// described by its IDs.
+func (p *GCPProvider) describeTags(tags []string) []string {
+	described := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		_, value, _ := strings.Cut(tag, "=")
+		if !strings.HasPrefix(value, "tagValues/") {
+			described = append(described, tag)
+			continue
+		}
+		name, ok := p.tagNames[value]
+		if !ok {
+			if service, err := p.tagsService(); err != nil {
+				p.log.Debug("Not naming tag %s: %v", tag, err)
+			} else if tv, err := service.TagValues.Get(value).Context(p.ctx).Do(); err != nil {
+				p.log.Debug("Not naming tag %s: %v", tag, apiError(err))
+			} else {
+				name = tv.NamespacedName
+			}
+			// Failures are not retried for every binding of the value
+			if p.tagNames == nil {
+				p.tagNames = make(map[string]string)
+			}
+			p.tagNames[value] = name
+		}
+		if name == "" {
+			described = append(described, tag)
+			continue
+		}
+		described = append(described, tagDisplayName(name))
+	}
+	return described
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yckao/gta/pkg/retry"
+	resourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// UnrevokedError reports bindings that still grant access after they were
+// revoked and their removal was retried
+type UnrevokedError struct {
+	// Target is the project ID or the name of the resource granted on
+	Target     string
+	BindingIDs []string
+}
+
+// Error implements error
+func (e *UnrevokedError) Error() string {
+	return fmt.Sprintf("revocation not verified in %s, bindings still present: %s", describeTarget(e.Target), strings.Join(e.BindingIDs, ", "))
+}
+
+// WithRevocationCheck sets whether revocations are verified by reading the
+// policy again, which is the default
+func WithRevocationCheck(enabled bool) GCPProviderOption {
+	return func(p *GCPProvider) {
+		p.noRevocationCheck = !enabled
+	}
+}
+
+// verifyRevoked reads the policy of target again and confirms that none of
+// the revoked bindings, by binding ID, still holds the members revoked from
+// it, e.g. because a concurrent writer added them back. Remaining members are
+// removed again and the policy polled within the propagation retries before
+// the revocation is reported as not verified.
+func (p *GCPProvider) verifyRevoked(target string, revoked map[string]map[string]bool) error {
+	if p.noRevocationCheck || p.dryRun || len(revoked) == 0 {
+		return nil
+	}
+
+	policy := p.retry[RetryPropagation]
+	unrevoked := func(err error) bool {
+		var unrevokedErr *UnrevokedError
+		return errors.As(err, &unrevokedErr)
+	}
+	return retry.Do(p.ctx, policy, unrevoked, func(attempt int) error {
+		// Read what is stored now rather than what was last written
+		p.policies.invalidate(target)
+		current, err := p.getIAMPolicy(target)
+		if err != nil {
+			return fmt.Errorf("verify revocation in %s: %w", describeTarget(target), err)
+		}
+		remove, remaining := remainingBindings(current, revoked)
+		if len(remaining) == 0 {
+			p.log.Info("Revocation verified in %s", describeTarget(target))
+			return nil
+		}
+		unrevokedErr := &UnrevokedError{Target: target, BindingIDs: remaining}
+		if attempt > policy.MaxRetries {
+			return unrevokedErr
+		}
+
+		p.log.Warn("Bindings %s are still in %s after revocation, removing them again", strings.Join(remaining, ", "), describeTarget(target))
+		current.Bindings = removeMembers(current.Bindings, remove)
+		if _, err := p.setIAMPolicy(target, current); err != nil {
+			return fmt.Errorf("revoke again in %s: %w", describeTarget(target), err)
+		}
+		// Polled again once the backoff passed
+		return unrevokedErr
+	})
+}
+
+// remainingBindings finds the members of revoked bindings still in policy,
+// by binding index as removeMembers takes them, and their sorted binding IDs
+func remainingBindings(policy *resourcemanager.Policy, revoked map[string]map[string]bool) (map[int]map[string]bool, []string) {
+	remove := make(map[int]map[string]bool)
+	found := make(map[string]bool)
+	for i, binding := range policy.Bindings {
+		if binding.Condition == nil {
+			continue
+		}
+		members, ok := revoked[binding.Condition.Title]
+		if !ok {
+			continue
+		}
+		for _, member := range binding.Members {
+			if !members[member] {
+				continue
+			}
+			if remove[i] == nil {
+				remove[i] = make(map[string]bool)
+			}
+			remove[i][member] = true
+			found[binding.Condition.Title] = true
+		}
+	}
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return remove, ids
+}
@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+)
+
+// Kinds of BindingChange
+const (
+	ChangeRemoved   = "removed"
+	ChangeCondition = "condition changed"
+	ChangeMembers   = "members removed"
+)
+
+// BindingChange is a granted role whose binding another writer removed or
+// changed during the session
+type BindingChange struct {
+	Role GrantedRole
+	Kind string
+	// Detail tells how the binding changed, e.g. its new expression
+	Detail string
+}
+
+// String describes the change for logs
+func (c BindingChange) String() string {
+	s := fmt.Sprintf("role %s %s: binding %s %s", c.Role.Role, scope(c.Role.Target), c.Role.BindingID, c.Kind)
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// CheckGrantedRoles reads the policies holding the granted roles again and
+// returns the bindings that were removed, lost members, or had their
+// condition changed since they were granted with opts
+func (p *GCPProvider) CheckGrantedRoles(opts Options) ([]BindingChange, error) {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return nil, fmt.Errorf("invalid options type")
+	}
+	byTarget := make(map[string][]GrantedRole)
+	for _, role := range p.grantedRoles {
+		target := role.Target
+		if target == "" {
+			target = gcpOpts.roleTarget(role.Role)
+			role.Target = target
+		}
+		byTarget[target] = append(byTarget[target], role)
+	}
+	targets := make([]string, 0, len(byTarget))
+	for target := range byTarget {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	members := gcpOpts.members()
+	var changes []BindingChange
+	for _, target := range targets {
+		// Read what is stored now rather than what was last written
+		p.policies.invalidate(target)
+		policy, err := p.getIAMPolicy(target)
+		if err != nil {
+			return changes, fmt.Errorf("%s: %w", describeTarget(target), err)
+		}
+		for _, role := range byTarget[target] {
+			change := BindingChange{Role: role, Kind: ChangeRemoved}
+			for _, binding := range policy.Bindings {
+				if binding.Role != role.Role || binding.Condition == nil || binding.Condition.Title != role.BindingID {
+					continue
+				}
+				var missing []string
+				for _, member := range members {
+					if !holdsMembers(binding, []string{member}) {
+						missing = append(missing, member)
+					}
+				}
+				switch {
+				case binding.Condition.Expression != bindingExpression(gcpOpts, role.Expiry):
+					change.Kind, change.Detail = ChangeCondition, binding.Condition.Expression
+				case len(missing) > 0:
+					change.Kind, change.Detail = ChangeMembers, strings.Join(missing, ", ")
+				default:
+					change.Kind = ""
+				}
+				break
+			}
+			if change.Kind != "" {
+				changes = append(changes, change)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// ReportChanges emits a modified event per change and member of opts, e.g.
+// for the changes found for the first time by CheckGrantedRoles
+func (p *GCPProvider) ReportChanges(opts Options, changes []BindingChange) {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return
+	}
+	members := gcpOpts.members()
+	for _, change := range changes {
+		for _, member := range members {
+			event := p.newEvent(audit.ActionModified, gcpOpts)
+			event.Resource = eventResource(change.Role.Target)
+			event.Role = change.Role.Role
+			event.Member = member
+			event.BindingID = change.Role.BindingID
+			event.Expiry = change.Role.Expiry
+			event.Error = change.Kind
+			p.events.Emit(event)
+		}
+	}
+}
+
+// Regrant grants the roles of changes again with new bindings expiring when
+// the originals did. A removed binding is replaced in the granted roles,
+// while a changed one stays so that Revoke removes it along with its
+// replacement.
+func (p *GCPProvider) Regrant(opts Options, changes []BindingChange) error {
+	gcpOpts, ok := opts.(*GCPOptions)
+	if !ok {
+		return fmt.Errorf("invalid options type")
+	}
+	members := gcpOpts.members()
+	var errs RoleErrors
+	for _, change := range changes {
+		role, target := change.Role.Role, change.Role.Target
+		p.log.Info("Granting role %s to %s %s again until %s", role, gcpOpts.memberNames(), scope(target), change.Role.Expiry.Format(time.RFC3339))
+		if err := p.regrant(gcpOpts, change, members); err != nil {
+			p.log.Warn("Failed to grant role %s again: %v", role, err)
+			errs = append(errs, &RoleError{Action: "grant", Role: role, Project: gcpOpts.Project, Resource: describeTarget(target), Err: err})
+			p.metrics.GrantFailed(errorClass(err))
+			p.emitGrantFailure(gcpOpts, target, role, members, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// regrant adds a binding replacing that of change to the policy of its target
+func (p *GCPProvider) regrant(opts *GCPOptions, change BindingChange, members []string) error {
+	target := change.Role.Target
+	policy, err := p.getIAMPolicy(target)
+	if err != nil {
+		return err
+	}
+	binding := p.createBinding(opts, change.Role.Role, members, change.Role.Expiry)
+	policy.Bindings = append(policy.Bindings, binding)
+	if err := p.checkPolicySize(target, policy); err != nil {
+		return err
+	}
+
+	events := make([]audit.Event, 0, len(members))
+	for _, member := range members {
+		event := p.newEvent(audit.ActionGrant, opts)
+		event.Resource = eventResource(target)
+		event.Role = change.Role.Role
+		event.Member = member
+		event.BindingID = binding.Condition.Title
+		event.Expiry = change.Role.Expiry
+		event.RevokeAt = opts.revokeAt(change.Role.Expiry)
+		events = append(events, event)
+	}
+	if p.grantHook != nil {
+		for _, event := range events {
+			if err := p.grantHook(p.ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := p.setIAMPolicy(target, policy); err != nil {
+		return err
+	}
+	p.metrics.GrantSucceeded()
+	p.metrics.BindingsChanged(1)
+	for _, event := range events {
+		p.events.Emit(event)
+	}
+
+	granted := GrantedRole{Role: change.Role.Role, BindingID: binding.Condition.Title, Expiry: change.Role.Expiry, Target: target}
+	if change.Kind == ChangeRemoved {
+		for i, role := range p.grantedRoles {
+			if role.BindingID == change.Role.BindingID {
+				p.grantedRoles[i] = granted
+				return nil
+			}
+		}
+	}
+	p.grantedRoles = append(p.grantedRoles, granted)
+	return nil
+}
@@ -0,0 +1,133 @@
+// Package ratelimit implements a client-side token bucket for pacing Google API calls
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+)
+
+const (
+	// DefaultRate is the default number of requests per second, which keeps us
+	// within Resource Manager's default quota of 600 requests per minute
+	DefaultRate = 10.0
+	// DefaultBurst is the default number of requests that may be issued back to back
+	DefaultBurst = 5
+)
+
+// Clock abstracts time so that pacing can be verified with a fake clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Limiter is a token bucket limiter that is safe for concurrent use.
+// A single Limiter should be shared by every worker talking to the same API
+// so that concurrency times rate stays bounded.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  Clock
+}
+
+// New creates a limiter allowing rate requests per second with the given burst
+func New(rate float64, burst int) *Limiter {
+	return NewWithClock(rate, burst, realClock{})
+}
+
+// NewWithClock creates a limiter using the given clock
+func NewWithClock(rate float64, burst int, clock Clock) *Limiter {
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+		clock:  clock,
+	}
+}
+
+// Wait blocks until a request may proceed or the context is cancelled
+func (l *Limiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	logger.Debug("Rate limit reached, throttling request for %v", delay)
+	select {
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	case <-l.clock.After(delay):
+		return nil
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before using it
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns a reserved token when the caller gives up waiting
+func (l *Limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Transport is an http.RoundTripper that waits on a Limiter before each request
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *Limiter
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when advanced, recording the
+// delays waited on
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	waited []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After moves the clock by d at once, as if the caller had waited
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waited = append(c.waited, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// blockingClock never fires, for waits that must end by cancellation
+type blockingClock struct{ fakeClock }
+
+func (c *blockingClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+func TestLimiterBurstThenPaced(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(10, 3, clock)
+	start := clock.Now()
+	for i := 0; i < 7; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait %d: %v", i, err)
+		}
+	}
+	// The burst of 3 goes at once, then each call waits 100ms
+	if len(clock.waited) != 4 {
+		t.Fatalf("waited %d times, want 4: %v", len(clock.waited), clock.waited)
+	}
+	for i, d := range clock.waited {
+		if d != 100*time.Millisecond {
+			t.Errorf("wait %d = %v, want 100ms", i, d)
+		}
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 400*time.Millisecond {
+		t.Errorf("7 requests took %v, want 400ms", elapsed)
+	}
+}
+
+func TestLimiterRefillsUpToBurst(t *testing.T) {
+	clock := newFakeClock()
+	l := NewWithClock(10, 2, clock)
+	for i := 0; i < 2; i++ {
+		l.Wait(context.Background())
+	}
+	// An idle minute refills only the burst
+	clock.Advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		l.Wait(context.Background())
+	}
+	if len(clock.waited) != 1 || clock.waited[0] != 100*time.Millisecond {
+		t.Errorf("waited %v, want a single 100ms wait after the refilled burst", clock.waited)
+	}
+}
+
+func TestLimiterDefaults(t *testing.T) {
+	l := NewWithClock(0, 0, newFakeClock())
+	if l.rate != DefaultRate || l.burst != 1 {
+		t.Errorf("rate %v burst %v, want %v and 1", l.rate, l.burst, DefaultRate)
+	}
+}
+
+func TestLimiterCancelledWait(t *testing.T) {
+	clock := &blockingClock{}
+	l := NewWithClock(1, 1, clock)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Wait(ctx) }()
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait = %v, want context.Canceled", err)
+	}
+	// The token reserved by the cancelled wait is returned
+	if l.tokens != 0 {
+		t.Errorf("tokens = %v after the cancelled wait, want 0", l.tokens)
+	}
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait on a done context = %v, want context.Canceled", err)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper calling a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportPacesRequests(t *testing.T) {
+	clock := newFakeClock()
+	var sent []time.Time
+	transport := &Transport{
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			sent = append(sent, clock.Now())
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}),
+		Limiter: NewWithClock(5, 1, clock),
+	}
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip %d: %v", i, err)
+		}
+	}
+	for i := 1; i < len(sent); i++ {
+		if gap := sent[i].Sub(sent[i-1]); gap != 200*time.Millisecond {
+			t.Errorf("request %d sent %v after the previous one, want 200ms", i, gap)
+		}
+	}
+}
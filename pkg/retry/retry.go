@@ -0,0 +1,105 @@
+// Package retry runs operations again after failures, backing off
+// exponentially within a number of retries and a time budget
+package retry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/duration"
+)
+
+// Policy bounds the retries of an operation
+type Policy struct {
+	// MaxRetries is the number of attempts after the first, none when 0
+	MaxRetries int
+	// MaxElapsed bounds the time spent since the first attempt, after which
+	// no retry starts; unbounded when 0
+	MaxElapsed time.Duration
+	// Backoff is the delay before the first retry, doubled for each next one
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts, uncapped when 0
+	MaxBackoff time.Duration
+}
+
+// Validate rejects negative values and combinations that cannot take
+// effect, such as a time budget without retries
+func (p Policy) Validate() error {
+	switch {
+	case p.MaxRetries < 0:
+		return fmt.Errorf("max retries must not be negative")
+	case p.MaxElapsed < 0, p.Backoff < 0, p.MaxBackoff < 0:
+		return fmt.Errorf("durations must not be negative")
+	case p.MaxRetries == 0 && p.MaxElapsed > 0:
+		return fmt.Errorf("a time budget of %s has no effect without retries, set max retries or leave out the budget", duration.Format(p.MaxElapsed))
+	case p.MaxBackoff > 0 && p.MaxBackoff < p.Backoff:
+		return fmt.Errorf("max backoff %s is shorter than the backoff %s", duration.Format(p.MaxBackoff), duration.Format(p.Backoff))
+	case p.MaxElapsed > 0 && p.MaxElapsed < p.Backoff:
+		return fmt.Errorf("time budget %s is shorter than the first backoff %s, so no retry would start", duration.Format(p.MaxElapsed), duration.Format(p.Backoff))
+	}
+	return nil
+}
+
+// Delay returns the delay before retry n, counted from 1
+func (p Policy) Delay(n int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < n && (p.MaxBackoff == 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// String describes the policy, e.g. 3 retries, backoff 1s to 10s, within 30s
+func (p Policy) String() string {
+	if p.MaxRetries == 0 {
+		return "no retries"
+	}
+	parts := []string{fmt.Sprintf("%d retries", p.MaxRetries)}
+	if p.MaxRetries == 1 {
+		parts[0] = "1 retry"
+	}
+	switch {
+	case p.Backoff == 0:
+		parts = append(parts, "no backoff")
+	case p.MaxBackoff > 0 && p.MaxBackoff != p.Backoff:
+		parts = append(parts, fmt.Sprintf("backoff %s to %s", duration.Format(p.Backoff), duration.Format(p.MaxBackoff)))
+	default:
+		parts = append(parts, "backoff "+duration.Format(p.Backoff))
+	}
+	if p.MaxElapsed > 0 {
+		parts = append(parts, "within "+duration.Format(p.MaxElapsed))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Do calls fn, with the attempt number counted from 1, until it succeeds or
+// fails with an error retriable does not accept, or the retries of p run
+// out, and returns its last error. Waiting for the next attempt ends early
+// with the error of ctx once it is done.
+func Do(ctx context.Context, p Policy, retriable func(error) bool, fn func(attempt int) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || !retriable(err) || attempt > p.MaxRetries {
+			return err
+		}
+		delay := p.Delay(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return err
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
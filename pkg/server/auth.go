@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/idtoken"
+)
+
+// iapHeader carries the signed identity assertion added by Identity-Aware Proxy
+const iapHeader = "X-Goog-IAP-JWT-Assertion"
+
+// Authenticator resolves the email of the caller of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (string, error)
+}
+
+// IDTokenAuthenticator verifies Google-signed ID tokens, either an IAP
+// assertion or a bearer token in the Authorization header as forwarded by ESPv2
+type IDTokenAuthenticator struct {
+	validator *idtoken.Validator
+	audience  string
+}
+
+// NewIDTokenAuthenticator creates an authenticator accepting tokens issued for audience
+func NewIDTokenAuthenticator(ctx context.Context, audience string) (*IDTokenAuthenticator, error) {
+	validator, err := idtoken.NewValidator(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ID token validator: %v", err)
+	}
+	return &IDTokenAuthenticator{validator: validator, audience: audience}, nil
+}
+
+// Authenticate implements Authenticator
+func (a *IDTokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token := r.Header.Get(iapHeader)
+	if token == "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", fmt.Errorf("missing bearer token")
+		}
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	payload, err := a.validator.Validate(r.Context(), token, a.audience)
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %v", err)
+	}
+	email, _ := payload.Claims["email"].(string)
+	if email == "" {
+		return "", fmt.Errorf("token has no email claim")
+	}
+	// IAP assertions carry no email_verified claim as IAP verifies the identity
+	if verified, ok := payload.Claims["email_verified"].(bool); ok && !verified {
+		return "", fmt.Errorf("email %s is not verified", email)
+	}
+	return email, nil
+}
+
+// handlerFunc is a handler receiving the authenticated caller
+type handlerFunc func(w http.ResponseWriter, r *http.Request, caller string)
+
+// authenticated wraps a handler, rejecting unauthenticated requests
+func (s *Server) authenticated(next handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, err := s.cfg.Authenticator.Authenticate(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "%v", err)
+			return
+		}
+		if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+			info.caller = caller
+		}
+		next(w, r, caller)
+	}
+}
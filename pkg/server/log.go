@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+)
+
+// requestInfoKey is the context key of the requestInfo of a request
+type requestInfoKey struct{}
+
+// requestInfo collects details about a request for its log line
+type requestInfo struct {
+	caller string
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request with its status, duration, and caller
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		caller := info.caller
+		if caller == "" {
+			caller = "-"
+		}
+		log := logger.Info
+		if r.URL.Path == "/healthz" {
+			log = logger.Debug
+		}
+		log("%s %s %d %v caller=%s remote=%s", r.Method, r.URL.RequestURI(), rec.status, time.Since(start).Round(time.Millisecond), caller, r.RemoteAddr)
+	})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaRef returns a reference to a component schema
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// jsonContent describes a JSON body with the given schema
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// errorResponse describes an error response
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     jsonContent(schemaRef("ErrorResponse")),
+	}
+}
+
+// OpenAPI returns the OpenAPI 3 description of the API. Schemas are generated
+// from the request and response types so that they cannot drift apart.
+func OpenAPI() map[string]interface{} {
+	schemas := make(map[string]interface{})
+	for _, v := range []interface{}{GrantRequest{}, Grant{}, BindingList{}, ErrorResponse{}} {
+		addSchema(reflect.TypeOf(v), schemas)
+	}
+
+	unauthorized := errorResponse("Missing or invalid ID token")
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "gta",
+			"description": "Grant temporary IAM roles that are revoked automatically",
+			"version":     "1",
+		},
+		"security": []interface{}{map[string]interface{}{"idToken": []string{}}},
+		"paths": map[string]interface{}{
+			"/grants": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary":     "Grant temporary roles to the caller",
+					"operationId": "createGrant",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(schemaRef("GrantRequest")),
+					},
+					"responses": map[string]interface{}{
+						"201": map[string]interface{}{"description": "Roles granted", "content": jsonContent(schemaRef("Grant"))},
+						"400": errorResponse("Invalid request"),
+						"401": unauthorized,
+						"403": errorResponse("Rejected by policy"),
+						"409": errorResponse("Narrower alternatives are recommended for broad roles"),
+						"502": errorResponse("IAM update failed"),
+					},
+				},
+				"get": map[string]interface{}{
+					"summary":     "List the temporary bindings of the caller in a project",
+					"operationId": "listGrants",
+					"parameters": []interface{}{map[string]interface{}{
+						"name":     "project",
+						"in":       "query",
+						"required": true,
+						"schema":   map[string]interface{}{"type": "string"},
+					}},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{"description": "Temporary bindings", "content": jsonContent(schemaRef("BindingList"))},
+						"400": errorResponse("Missing project"),
+						"401": unauthorized,
+						"502": errorResponse("IAM policy could not be read"),
+					},
+				},
+			},
+			"/grants/{session}": map[string]interface{}{
+				"delete": map[string]interface{}{
+					"summary":     "Revoke a session early",
+					"operationId": "deleteGrant",
+					"parameters": []interface{}{map[string]interface{}{
+						"name":     "session",
+						"in":       "path",
+						"required": true,
+						"schema":   map[string]interface{}{"type": "string"},
+					}},
+					"responses": map[string]interface{}{
+						"204": map[string]interface{}{"description": "Roles revoked"},
+						"401": unauthorized,
+						"403": errorResponse("Session belongs to someone else"),
+						"404": errorResponse("Unknown session"),
+						"502": errorResponse("IAM update failed"),
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"idToken": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "Google-signed ID token, or an IAP assertion in X-Goog-IAP-JWT-Assertion",
+				},
+			},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// addSchema registers the schema of a struct type and the structs it refers to
+func addSchema(t reflect.Type, schemas map[string]interface{}) {
+	if _, ok := schemas[t.Name()]; ok {
+		return
+	}
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := schemaOf(field.Type, schemas)
+		if description := field.Tag.Get("description"); description != "" {
+			if _, isRef := schema["$ref"]; isRef {
+				schema = map[string]interface{}{"allOf": []interface{}{schema}}
+			}
+			schema["description"] = description
+		}
+		properties[name] = schema
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	schemas[t.Name()] = schema
+}
+
+// schemaOf returns the schema of a field type
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		addSchema(t, schemas)
+		return schemaRef(t.Name())
+	default:
+		return map[string]interface{}{}
+	}
+}
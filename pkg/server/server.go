@@ -0,0 +1,367 @@
+// Package server exposes temporary access as an authenticated REST API for
+// team self-service
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yckao/gta/pkg/audit"
+	"github.com/yckao/gta/pkg/duration"
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/metrics"
+	"github.com/yckao/gta/pkg/provider"
+	"github.com/yckao/gta/pkg/session"
+)
+
+const (
+	// maxBodySize bounds request bodies
+	maxBodySize = 64 * 1024
+	// shutdownTimeout bounds how long in-flight requests may finish on shutdown
+	shutdownTimeout = 10 * time.Second
+)
+
+// Provider is the part of the GCP provider used by the server, so that it can
+// be backed by a fake in tests
+type Provider interface {
+	Grant(opts provider.Options) error
+	Revoke(opts provider.Options) error
+	GrantedRoles() []provider.GrantedRole
+	GrantErrors() provider.RoleErrors
+	TemporaryBindings(opts provider.Options) ([]provider.TemporaryBinding, error)
+	ProjectLabels(project string) (map[string]string, error)
+	Role(name string) (provider.PredefinedRole, error)
+}
+
+// ProviderFactory creates the provider of a single session, logging through log
+type ProviderFactory func(ctx context.Context, log *logger.Logger) (Provider, error)
+
+// Policy checks a grant by caller before it is applied and may shorten its
+// TTL, or set the TTL of a grant requesting none
+type Policy func(ctx context.Context, p Provider, opts *provider.GCPOptions, caller string) error
+
+// Config configures a Server
+type Config struct {
+	NewProvider   ProviderFactory
+	Authenticator Authenticator
+	Policy        Policy
+	DefaultTTL    time.Duration
+	Metrics       metrics.Recorder
+	// Flush is called after every operation, e.g. to deliver notifications
+	Flush func()
+}
+
+// activeSession is a grant made through the API that has not been revoked yet
+type activeSession struct {
+	grant    Grant
+	owner    string
+	provider Provider
+	opts     *provider.GCPOptions
+	log      *logger.Logger
+	cancel   context.CancelFunc
+}
+
+// Server serves the grant API. Sessions are revoked when their TTL expires or
+// when deleted; bindings left by a stopped server expire through their IAM
+// condition.
+type Server struct {
+	cfg      Config
+	ctx      context.Context
+	mu       sync.Mutex
+	sessions map[string]*activeSession
+}
+
+// New creates a server
+func New(cfg Config) *Server {
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.Nop{}
+	}
+	if cfg.Policy == nil {
+		cfg.Policy = func(context.Context, Provider, *provider.GCPOptions, string) error { return nil }
+	}
+	if cfg.Flush == nil {
+		cfg.Flush = func() {}
+	}
+	if cfg.DefaultTTL <= 0 {
+		cfg.DefaultTTL = time.Hour
+	}
+	return &Server{
+		cfg:      cfg,
+		ctx:      context.Background(),
+		sessions: make(map[string]*activeSession),
+	}
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /grants", s.authenticated(s.createGrant))
+	mux.HandleFunc("GET /grants", s.authenticated(s.listGrants))
+	mux.HandleFunc("DELETE /grants/{session}", s.authenticated(s.deleteGrant))
+	mux.HandleFunc("GET /openapi.json", s.openAPI)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return logRequests(mux)
+}
+
+// Run serves the API on addr until ctx is done
+func (s *Server) Run(ctx context.Context, addr string) error {
+	s.ctx = ctx
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	logger.Info("Serving the grant API on %s", addr)
+
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to serve: %v", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down: %v", err)
+	}
+
+	s.mu.Lock()
+	active := len(s.sessions)
+	s.mu.Unlock()
+	if active > 0 {
+		logger.Info("Leaving %d active session(s) to expire through their IAM conditions", active)
+	}
+	return nil
+}
+
+func (s *Server) createGrant(w http.ResponseWriter, r *http.Request, caller string) {
+	var req GrantRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+
+	if req.Project == "" {
+		writeError(w, http.StatusBadRequest, "project is required")
+		return
+	}
+	if len(req.Roles) == 0 {
+		writeError(w, http.StatusBadRequest, "at least one role is required")
+		return
+	}
+	// Without a TTL in the request, the policy may set one, e.g. by role
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = duration.Parse(req.TTL); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl %q", req.TTL)
+			return
+		}
+	}
+	member := req.Member
+	if member == "" {
+		member = caller
+	}
+	if member != caller {
+		writeError(w, http.StatusForbidden, "roles can only be granted to the caller %s", caller)
+		return
+	}
+	roles := make([]string, len(req.Roles))
+	for i, role := range req.Roles {
+		roles[i] = provider.FormatRole(role)
+	}
+
+	sessionID := audit.NewID()
+	log := logger.With(
+		slog.String(logger.SessionKey, sessionID),
+		slog.String("project", req.Project),
+		slog.String("provider", "gcp"),
+		slog.String("caller", caller),
+	)
+
+	// The provider outlives the request to revoke the session later
+	p, err := s.cfg.NewProvider(s.ctx, log)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create provider: %v", err)
+		return
+	}
+	opts := &provider.GCPOptions{
+		Project:     req.Project,
+		Roles:       roles,
+		User:        member,
+		TTL:         ttl,
+		Reason:      req.Reason,
+		SessionID:   sessionID,
+		Requester:   caller,
+		AcceptBroad: req.AcceptBroad,
+	}
+	if err := s.cfg.Policy(r.Context(), p, opts, caller); err != nil {
+		writeError(w, http.StatusForbidden, "%v", err)
+		return
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = s.cfg.DefaultTTL
+	}
+
+	err = p.Grant(opts)
+	s.cfg.Flush()
+	if err != nil {
+		if len(p.GrantedRoles()) > 0 {
+			if revokeErr := p.Revoke(opts); revokeErr != nil {
+				log.Error("Failed to revoke roles of failed session: %v", revokeErr)
+			}
+			s.cfg.Flush()
+		}
+		var broadErr *provider.BroadRoleError
+		if errors.As(err, &broadErr) {
+			writeError(w, http.StatusConflict, "%v, set accept_broad to grant them anyway", err)
+			return
+		}
+		writeError(w, http.StatusBadGateway, "failed to grant roles: %v", err)
+		return
+	}
+
+	grant := Grant{
+		Session: opts.SessionID,
+		Project: opts.Project,
+		Member:  member,
+		Roles:   p.GrantedRoles(),
+		Reason:  opts.Reason,
+	}
+	for _, role := range grant.Roles {
+		if grant.Expiry.IsZero() || role.Expiry.Before(grant.Expiry) {
+			grant.Expiry = role.Expiry
+		}
+	}
+	// Under the allow policy some roles may have failed
+	for _, roleErr := range p.GrantErrors() {
+		if grant.Failed == nil {
+			grant.Failed = make(map[string]string)
+		}
+		grant.Failed[roleErr.Role] = roleErr.Err.Error()
+	}
+
+	s.startSession(&activeSession{grant: grant, owner: caller, provider: p, opts: opts, log: log})
+	writeJSON(w, http.StatusCreated, grant)
+}
+
+// startSession tracks a session and revokes it when its TTL expires
+func (s *Server) startSession(active *activeSession) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	active.cancel = cancel
+
+	s.mu.Lock()
+	s.sessions[active.grant.Session] = active
+	s.mu.Unlock()
+	s.cfg.Metrics.SessionStarted()
+
+	timer := session.NewTimer(active.grant.Expiry)
+	go func() {
+		if err := timer.Run(ctx); err != nil {
+			return
+		}
+		active.log.Info("Session expired")
+		if err := s.endSession(active.grant.Session); err != nil {
+			active.log.Error("Failed to revoke expired session: %v", err)
+		}
+	}()
+}
+
+// endSession stops tracking a session and revokes its roles
+func (s *Server) endSession(id string) error {
+	s.mu.Lock()
+	active, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	active.cancel()
+	s.cfg.Metrics.SessionEnded()
+	err := active.provider.Revoke(active.opts)
+	s.cfg.Flush()
+	return err
+}
+
+func (s *Server) deleteGrant(w http.ResponseWriter, r *http.Request, caller string) {
+	id := r.PathValue("session")
+
+	s.mu.Lock()
+	active, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "session %s not found", id)
+		return
+	}
+	if active.owner != caller {
+		writeError(w, http.StatusForbidden, "session %s belongs to %s", id, active.owner)
+		return
+	}
+
+	if err := s.endSession(id); err != nil {
+		writeError(w, http.StatusBadGateway, "failed to revoke roles: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listGrants(w http.ResponseWriter, r *http.Request, caller string) {
+	project := r.URL.Query().Get("project")
+	if project == "" {
+		writeError(w, http.StatusBadRequest, "project query parameter is required")
+		return
+	}
+
+	p, err := s.cfg.NewProvider(r.Context(), logger.With(slog.String("project", project), slog.String("provider", "gcp")))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create provider: %v", err)
+		return
+	}
+	found, err := p.TemporaryBindings(&provider.GCPOptions{Project: project, User: caller})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "failed to list temporary bindings: %v", err)
+		return
+	}
+	// Callers only see their own bindings, whatever the provider returns
+	bindings := []provider.TemporaryBinding{}
+	for _, binding := range found {
+		if binding.Member == "user:"+caller {
+			bindings = append(bindings, binding)
+		}
+	}
+	writeJSON(w, http.StatusOK, BindingList{Project: project, Bindings: bindings})
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, OpenAPI())
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("Failed to write response: %v", err)
+	}
+}
+
+// writeError writes an ErrorResponse
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, ErrorResponse{Error: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yckao/gta/pkg/logger"
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// callerHeader names the caller of a test request
+const callerHeader = "X-Test-Caller"
+
+// headerAuthenticator trusts the caller named in callerHeader
+type headerAuthenticator struct{}
+
+func (headerAuthenticator) Authenticate(r *http.Request) (string, error) {
+	caller := r.Header.Get(callerHeader)
+	if caller == "" {
+		return "", fmt.Errorf("missing bearer token")
+	}
+	return caller, nil
+}
+
+// fakeProvider records the grants and revocations of a session
+type fakeProvider struct {
+	mu       sync.Mutex
+	grantErr error
+	granted  []provider.GrantedRole
+	grants   int
+	revokes  int
+	bindings []provider.TemporaryBinding
+}
+
+func (p *fakeProvider) Grant(opts provider.Options) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grants++
+	if p.grantErr != nil {
+		return p.grantErr
+	}
+	gcpOpts := opts.(*provider.GCPOptions)
+	for i, role := range gcpOpts.Roles {
+		p.granted = append(p.granted, provider.GrantedRole{
+			Role:      role,
+			BindingID: fmt.Sprintf("b%d", i),
+			Expiry:    time.Now().Add(gcpOpts.TTL),
+			Target:    gcpOpts.Project,
+		})
+	}
+	return nil
+}
+
+func (p *fakeProvider) Revoke(opts provider.Options) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revokes++
+	return nil
+}
+
+func (p *fakeProvider) GrantedRoles() []provider.GrantedRole {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]provider.GrantedRole(nil), p.granted...)
+}
+
+func (p *fakeProvider) GrantErrors() provider.RoleErrors { return nil }
+
+func (p *fakeProvider) TemporaryBindings(opts provider.Options) ([]provider.TemporaryBinding, error) {
+	return p.bindings, nil
+}
+
+func (p *fakeProvider) ProjectLabels(project string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (p *fakeProvider) Role(name string) (provider.PredefinedRole, error) {
+	return provider.PredefinedRole{Name: provider.FormatRole(name), Stage: provider.StageGA}, nil
+}
+
+// testServer serves a Server whose sessions all use their own fakeProvider,
+// returning the providers created
+func testServer(t *testing.T, cfg Config) (*httptest.Server, func() []*fakeProvider) {
+	t.Helper()
+	var mu sync.Mutex
+	var created []*fakeProvider
+	if cfg.NewProvider == nil {
+		cfg.NewProvider = func(ctx context.Context, log *logger.Logger) (Provider, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			p := &fakeProvider{}
+			created = append(created, p)
+			return p, nil
+		}
+	}
+	cfg.Authenticator = headerAuthenticator{}
+	ts := httptest.NewServer(New(cfg).Handler())
+	t.Cleanup(ts.Close)
+	return ts, func() []*fakeProvider {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*fakeProvider(nil), created...)
+	}
+}
+
+// do sends a request as caller, none when empty, decoding the JSON response
+// into out when given
+func do(t *testing.T, ts *httptest.Server, method, path, caller string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, &reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caller != "" {
+		req.Header.Set(callerHeader, caller)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestUnauthenticatedRequestsAreRejected(t *testing.T) {
+	ts, providers := testServer(t, Config{})
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodPost, "/grants"},
+		{http.MethodGet, "/grants?project=p"},
+		{http.MethodDelete, "/grants/abc"},
+	} {
+		var resp ErrorResponse
+		status := do(t, ts, tc.method, tc.path, "", GrantRequest{Project: "p", Roles: []string{"viewer"}}, &resp)
+		if status != http.StatusUnauthorized {
+			t.Errorf("%s %s = %d, want 401", tc.method, tc.path, status)
+		}
+		if resp.Error == "" {
+			t.Errorf("%s %s: no error message", tc.method, tc.path)
+		}
+	}
+	if n := len(providers()); n != 0 {
+		t.Errorf("%d providers created for unauthenticated requests", n)
+	}
+}
+
+func TestGrantToAnotherMemberIsForbidden(t *testing.T) {
+	ts, providers := testServer(t, Config{})
+	status := do(t, ts, http.MethodPost, "/grants", "alice@example.com",
+		GrantRequest{Project: "p", Roles: []string{"viewer"}, Member: "bob@example.com"}, nil)
+	if status != http.StatusForbidden {
+		t.Fatalf("grant to another member = %d, want 403", status)
+	}
+	if n := len(providers()); n != 0 {
+		t.Errorf("%d providers created for a forbidden grant", n)
+	}
+}
+
+func TestGrantValidation(t *testing.T) {
+	ts, _ := testServer(t, Config{})
+	for name, body := range map[string]interface{}{
+		"no project":    GrantRequest{Roles: []string{"viewer"}},
+		"no roles":      GrantRequest{Project: "p"},
+		"bad ttl":       GrantRequest{Project: "p", Roles: []string{"viewer"}, TTL: "soon"},
+		"unknown field": map[string]string{"project": "p", "role": "viewer"},
+	} {
+		if status := do(t, ts, http.MethodPost, "/grants", "alice@example.com", body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, status)
+		}
+	}
+}
+
+func TestGrantAndDelete(t *testing.T) {
+	ts, providers := testServer(t, Config{})
+	var grant Grant
+	status := do(t, ts, http.MethodPost, "/grants", "alice@example.com",
+		GrantRequest{Project: "p", Roles: []string{"viewer"}, TTL: "30m", Reason: "debug"}, &grant)
+	if status != http.StatusCreated {
+		t.Fatalf("grant = %d, want 201", status)
+	}
+	if grant.Member != "alice@example.com" || grant.Project != "p" || grant.Reason != "debug" {
+		t.Errorf("grant = %+v", grant)
+	}
+	if len(grant.Roles) != 1 || grant.Roles[0].Role != "roles/viewer" {
+		t.Errorf("roles = %+v, want roles/viewer", grant.Roles)
+	}
+	if remaining := time.Until(grant.Expiry); remaining <= 29*time.Minute || remaining > 30*time.Minute {
+		t.Errorf("expiry in %v, want 30m", remaining)
+	}
+
+	// Only the owner may end the session
+	var resp ErrorResponse
+	if status := do(t, ts, http.MethodDelete, "/grants/"+grant.Session, "mallory@example.com", nil, &resp); status != http.StatusForbidden {
+		t.Fatalf("delete by another caller = %d, want 403", status)
+	}
+	p := providers()[0]
+	if p.revokes != 0 {
+		t.Fatalf("session revoked by another caller")
+	}
+
+	if status := do(t, ts, http.MethodDelete, "/grants/"+grant.Session, "alice@example.com", nil, nil); status != http.StatusNoContent {
+		t.Fatalf("delete by the owner = %d, want 204", status)
+	}
+	if p.revokes != 1 {
+		t.Errorf("revoked %d times, want 1", p.revokes)
+	}
+	if status := do(t, ts, http.MethodDelete, "/grants/"+grant.Session, "alice@example.com", nil, nil); status != http.StatusNotFound {
+		t.Errorf("second delete = %d, want 404", status)
+	}
+}
+
+func TestGrantDefaultTTL(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ttl  string
+		// policyTTL is the TTL the policy sets when the request has none
+		policyTTL time.Duration
+		want      time.Duration
+	}{
+		{name: "requested", ttl: "30m", policyTTL: 15 * time.Minute, want: 30 * time.Minute},
+		{name: "set by the policy", policyTTL: 15 * time.Minute, want: 15 * time.Minute},
+		{name: "server default", want: 2 * time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, _ := testServer(t, Config{
+				DefaultTTL: 2 * time.Hour,
+				Policy: func(ctx context.Context, p Provider, opts *provider.GCPOptions, caller string) error {
+					if opts.TTL <= 0 {
+						opts.TTL = tc.policyTTL
+					}
+					return nil
+				},
+			})
+			var grant Grant
+			if status := do(t, ts, http.MethodPost, "/grants", "alice@example.com", GrantRequest{Project: "p", Roles: []string{"viewer"}, TTL: tc.ttl}, &grant); status != http.StatusCreated {
+				t.Fatalf("grant = %d, want 201", status)
+			}
+			if remaining := time.Until(grant.Expiry); remaining <= tc.want-time.Minute || remaining > tc.want {
+				t.Errorf("expiry in %v, want %v", remaining, tc.want)
+			}
+		})
+	}
+}
+
+func TestPolicyDenies(t *testing.T) {
+	ts, providers := testServer(t, Config{
+		Policy: func(ctx context.Context, p Provider, opts *provider.GCPOptions, caller string) error {
+			return fmt.Errorf("project %s requires a reason", opts.Project)
+		},
+	})
+	var resp ErrorResponse
+	status := do(t, ts, http.MethodPost, "/grants", "alice@example.com", GrantRequest{Project: "prod", Roles: []string{"viewer"}}, &resp)
+	if status != http.StatusForbidden || resp.Error != "project prod requires a reason" {
+		t.Errorf("grant = %d %q, want 403 with the policy error", status, resp.Error)
+	}
+	if p := providers()[0]; p.grants != 0 {
+		t.Errorf("granted despite the policy")
+	}
+}
+
+func TestBroadRoleConflict(t *testing.T) {
+	p := &fakeProvider{grantErr: &provider.BroadRoleError{Recommendations: []provider.RoleRecommendation{{Role: "roles/editor"}}}}
+	ts, _ := testServer(t, Config{
+		NewProvider: func(ctx context.Context, log *logger.Logger) (Provider, error) { return p, nil },
+	})
+	if status := do(t, ts, http.MethodPost, "/grants", "alice@example.com", GrantRequest{Project: "p", Roles: []string{"editor"}}, nil); status != http.StatusConflict {
+		t.Errorf("broad grant = %d, want 409", status)
+	}
+}
+
+func TestGrantFailure(t *testing.T) {
+	p := &fakeProvider{grantErr: errors.New("setIamPolicy: denied")}
+	ts, _ := testServer(t, Config{
+		NewProvider: func(ctx context.Context, log *logger.Logger) (Provider, error) { return p, nil },
+	})
+	if status := do(t, ts, http.MethodPost, "/grants", "alice@example.com", GrantRequest{Project: "p", Roles: []string{"viewer"}}, nil); status != http.StatusBadGateway {
+		t.Errorf("failed grant = %d, want 502", status)
+	}
+}
+
+func TestListGrants(t *testing.T) {
+	p := &fakeProvider{bindings: []provider.TemporaryBinding{
+		{Role: "roles/viewer", Member: "user:alice@example.com", BindingID: "b0"},
+		{Role: "roles/editor", Member: "user:bob@example.com", BindingID: "b1"},
+	}}
+	ts, _ := testServer(t, Config{
+		NewProvider: func(ctx context.Context, log *logger.Logger) (Provider, error) { return p, nil },
+	})
+	if status := do(t, ts, http.MethodGet, "/grants", "alice@example.com", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("list without project = %d, want 400", status)
+	}
+	var list BindingList
+	if status := do(t, ts, http.MethodGet, "/grants?project=p", "alice@example.com", nil, &list); status != http.StatusOK {
+		t.Fatalf("list = %d, want 200", status)
+	}
+	if list.Project != "p" || len(list.Bindings) != 1 || list.Bindings[0].BindingID != "b0" {
+		t.Errorf("list = %+v", list)
+	}
+	// Another caller's bindings are not returned
+	if status := do(t, ts, http.MethodGet, "/grants?project=p", "carol@example.com", nil, &list); status != http.StatusOK {
+		t.Fatalf("list = %d, want 200", status)
+	}
+	if len(list.Bindings) != 0 {
+		t.Errorf("list of carol = %+v, want none", list)
+	}
+}
+
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	ts, _ := testServer(t, Config{})
+	var doc struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if status := do(t, ts, http.MethodGet, "/openapi.json", "", nil, &doc); status != http.StatusOK {
+		t.Fatalf("openapi = %d, want 200", status)
+	}
+	for path, methods := range map[string][]string{
+		"/grants":           {"get", "post"},
+		"/grants/{session}": {"delete"},
+	} {
+		for _, method := range methods {
+			if _, ok := doc.Paths[path][method]; !ok {
+				t.Errorf("openapi has no %s %s", method, path)
+			}
+		}
+	}
+}
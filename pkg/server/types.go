@@ -0,0 +1,39 @@
+package server
+
+import (
+	"time"
+
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// GrantRequest is the body of POST /grants
+type GrantRequest struct {
+	Project     string   `json:"project" description:"Project ID"`
+	Roles       []string `json:"roles" description:"Roles to grant, with or without the roles/ prefix"`
+	Member      string   `json:"member,omitempty" description:"Email to grant the roles to; must be the caller, which is the default"`
+	TTL         string   `json:"ttl,omitempty" description:"Time-to-live such as 30m or 1h; defaults to the default TTL of each role"`
+	Reason      string   `json:"reason,omitempty" description:"Reason for the access, recorded in the audit log"`
+	AcceptBroad bool     `json:"accept_broad,omitempty" description:"Grant broad roles even when narrower alternatives are recommended"`
+}
+
+// Grant is an active session created through the API
+type Grant struct {
+	Session string                 `json:"session" description:"Session ID, used to revoke the grant"`
+	Project string                 `json:"project" description:"Project ID"`
+	Member  string                 `json:"member" description:"Email the roles were granted to"`
+	Roles   []provider.GrantedRole `json:"roles" description:"Granted roles and their bindings"`
+	Expiry  time.Time              `json:"expiry" description:"Time the session is revoked"`
+	Reason  string                 `json:"reason,omitempty" description:"Reason for the access"`
+	Failed  map[string]string      `json:"failed,omitempty" description:"Roles that could not be granted, with their error"`
+}
+
+// BindingList is the response of GET /grants
+type BindingList struct {
+	Project  string                      `json:"project" description:"Project ID"`
+	Bindings []provider.TemporaryBinding `json:"bindings" description:"Temporary bindings in the project"`
+}
+
+// ErrorResponse is returned with every non-2xx status
+type ErrorResponse struct {
+	Error string `json:"error" description:"Error message"`
+}
@@ -0,0 +1,93 @@
+// Package session manages the lifecycle of a temporary access session
+package session
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Clock abstracts time so that the timer can be driven by a fake clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// hook is a callback scheduled at a fixed offset before expiry
+type hook struct {
+	before time.Duration
+	fn     func(remaining time.Duration)
+}
+
+// Timer is the single scheduling mechanism of a session: it waits for the
+// session to expire and runs hooks such as reminders at offsets before that
+type Timer struct {
+	expiry time.Time
+	hooks  []hook
+	clock  Clock
+}
+
+// NewTimer creates a timer for a session expiring at expiry
+func NewTimer(expiry time.Time) *Timer {
+	return NewTimerWithClock(expiry, realClock{})
+}
+
+// NewTimerWithClock creates a timer using the given clock
+func NewTimerWithClock(expiry time.Time, clock Clock) *Timer {
+	return &Timer{expiry: expiry, clock: clock}
+}
+
+// Expiry returns the time the session expires
+func (t *Timer) Expiry() time.Time {
+	return t.expiry
+}
+
+// Remaining returns the time left until expiry
+func (t *Timer) Remaining() time.Duration {
+	return t.expiry.Sub(t.clock.Now())
+}
+
+// Before schedules fn to run when the remaining time reaches d. Hooks whose
+// time has already passed when Run starts are skipped.
+func (t *Timer) Before(d time.Duration, fn func(remaining time.Duration)) {
+	t.hooks = append(t.hooks, hook{before: d, fn: fn})
+}
+
+// Run blocks until the session expires, running hooks as their time comes.
+// It returns nil on expiry and the context error if ctx is done first.
+func (t *Timer) Run(ctx context.Context) error {
+	hooks := make([]hook, 0, len(t.hooks))
+	for _, h := range t.hooks {
+		if t.Remaining() > h.before {
+			hooks = append(hooks, h)
+		}
+	}
+	// Fire the hook furthest from expiry first
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].before > hooks[j].before })
+
+	for _, h := range hooks {
+		if err := t.sleep(ctx, t.Remaining()-h.before); err != nil {
+			return err
+		}
+		h.fn(t.Remaining())
+	}
+	return t.sleep(ctx, t.Remaining())
+}
+
+// sleep waits for d or until ctx is done
+func (t *Timer) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.clock.After(d):
+		return nil
+	}
+}
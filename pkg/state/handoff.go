@@ -0,0 +1,102 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yckao/gta/pkg/fileutil"
+)
+
+// HandoffKind marks the files written by gta sessions export
+const HandoffKind = "gta-session-handoff"
+
+// Handoff is a session handed over to another machine, whose gta then
+// revokes its bindings. It shares the schema version of the state file, and
+// holds no credentials.
+type Handoff struct {
+	SchemaVersion int       `json:"schema_version"`
+	Kind          string    `json:"kind"`
+	ExportedAt    time.Time `json:"exported_at"`
+	Session       Session   `json:"session"`
+}
+
+// NewHandoff returns the handoff of s at now, without what only holds on
+// this machine: the process attending it and its pending revocation
+func NewHandoff(s Session, now time.Time) *Handoff {
+	s.PID = 0
+	s.Kept = true
+	s.PendingRevocation = nil
+	return &Handoff{SchemaVersion: SchemaVersion, Kind: HandoffKind, ExportedAt: now.UTC(), Session: s}
+}
+
+// Encode returns the handoff as indented JSON
+func (h *Handoff) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode handoff: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Write saves the handoff to path, readable by the current user only
+func (h *Handoff) Write(path string) error {
+	data, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write handoff: %v", err)
+	}
+	return nil
+}
+
+// LoadHandoff reads the handoff at path
+func LoadHandoff(path string) (*Handoff, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handoff: %v", err)
+	}
+	h, err := DecodeHandoff(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handoff %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// DecodeHandoff decodes a handoff of any supported schema version. Its
+// session is decoded as that of a state file of the same version, migrating
+// it to the current one.
+func DecodeHandoff(data []byte) (*Handoff, error) {
+	var doc struct {
+		SchemaVersion int             `json:"schema_version"`
+		Kind          string          `json:"kind"`
+		ExportedAt    time.Time       `json:"exported_at"`
+		Session       json.RawMessage `json:"session"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != HandoffKind {
+		return nil, fmt.Errorf("not a session handoff, kind %q instead of %q", doc.Kind, HandoffKind)
+	}
+	if doc.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("written by a newer gta with schema version %d, upgrade gta to import it", doc.SchemaVersion)
+	}
+	if len(doc.Session) == 0 {
+		return nil, fmt.Errorf("missing session")
+	}
+	file, err := json.Marshal(map[string]interface{}{
+		"schema_version": doc.SchemaVersion,
+		"sessions":       []json.RawMessage{doc.Session},
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Handoff{SchemaVersion: SchemaVersion, Kind: doc.Kind, ExportedAt: doc.ExportedAt, Session: f.Sessions[0]}, nil
+}
@@ -0,0 +1,457 @@
+// Package state persists the sessions started on this machine, so that their
+// bindings can be found and revoked after the process that granted them exits
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yckao/gta/pkg/encryption"
+	"github.com/yckao/gta/pkg/fileutil"
+)
+
+// SchemaVersion is the version of the state file written by this build
+const SchemaVersion = 2
+
+// FileName is the name of the state file in the data directory
+const FileName = "state.json"
+
+// migrations upgrade a decoded state file by one version: migrations[n]
+// turns a version n document into a version n+1 document. A new schema
+// version must come with the migration from the previous one.
+var migrations = map[int]func(doc map[string]interface{}) error{
+	// Version 2 adds schedules and the schedule of a session, both optional
+	1: func(doc map[string]interface{}) error { return nil },
+}
+
+// File is the content of the state file
+type File struct {
+	SchemaVersion int        `json:"schema_version"`
+	Sessions      []Session  `json:"sessions"`
+	Schedules     []Schedule `json:"schedules,omitempty"`
+}
+
+// Session is a grant session whose bindings may still exist
+type Session struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider"`
+	Project  string `json:"project"`
+	// Resource is the resource granted on within Project, empty for the
+	// project itself
+	Resource string `json:"resource,omitempty"`
+	Member   string `json:"member"`
+	Reason   string `json:"reason,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+	// Environment is the environment of Project, e.g. prod
+	Environment string    `json:"environment,omitempty"`
+	PID         int       `json:"pid"`
+	StartedAt   time.Time `json:"started_at"`
+	Bindings    []Binding `json:"bindings"`
+	// ScheduleID is the schedule whose window the session grants
+	ScheduleID string `json:"schedule_id,omitempty"`
+	// Manifest is the hash of the gta apply manifest whose entry the session
+	// grants
+	Manifest string `json:"manifest,omitempty"`
+	// Kept marks a session whose bindings were left in place on exit, to
+	// expire by their condition
+	Kept bool `json:"kept,omitempty"`
+	// PendingRevocation marks a session whose bindings could not be revoked,
+	// e.g. while offline or as the credentials of the caller expired, for
+	// later commands to retry
+	PendingRevocation *Revocation `json:"pending_revocation,omitempty"`
+}
+
+// MaxRevokeAttempts is the number of revocations of a session tried before
+// it is left to the user
+const MaxRevokeAttempts = 6
+
+// Revocation tracks the failed revocations of a session
+type Revocation struct {
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastAttempt time.Time `json:"last_attempt"`
+	// NextAttempt is when the revocation is retried next, backing off
+	// exponentially from a minute up to an hour
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// Claim counts an attempt at now and schedules the next one, so that
+// processes retrying at the same time do not try the same session twice
+func (r *Revocation) Claim(now time.Time) {
+	r.Attempts++
+	backoff := time.Minute << min(r.Attempts-1, 6)
+	r.NextAttempt = now.Add(min(backoff, time.Hour))
+}
+
+// Failed records the error of the attempt made at now
+func (r *Revocation) Failed(err error, now time.Time) {
+	r.LastError = err.Error()
+	r.LastAttempt = now
+}
+
+// Exhausted reports whether the revocation is no longer retried
+// automatically
+func (r *Revocation) Exhausted() bool {
+	return r.Attempts >= MaxRevokeAttempts
+}
+
+// Due reports whether the revocation is to be retried at now
+func (r *Revocation) Due(now time.Time) bool {
+	return !r.Exhausted() && !r.NextAttempt.After(now)
+}
+
+// Status describes whether a session is attended by the process that
+// granted it, left to expire by its condition, or expired at now
+func (s Session) Status(now time.Time) string {
+	switch {
+	case !s.LastExpiry().After(now):
+		return "expired"
+	case s.PendingRevocation != nil && s.PendingRevocation.Exhausted():
+		return fmt.Sprintf("revocation failed %d times (run gta revoke --from-state)", s.PendingRevocation.Attempts)
+	case s.PendingRevocation != nil:
+		return fmt.Sprintf("pending revocation (attempt %d of %d, next at %s)", s.PendingRevocation.Attempts, MaxRevokeAttempts, s.PendingRevocation.NextAttempt.Format(time.RFC3339))
+	case s.Kept:
+		return "unattended (expires by condition)"
+	default:
+		return fmt.Sprintf("attended (pid %d)", s.PID)
+	}
+}
+
+// Target returns the project ID, or the resource name, that the bindings of
+// the session are in
+func (s Session) Target() string {
+	if s.Resource != "" {
+		return s.Resource
+	}
+	return s.Project
+}
+
+// Targets returns the project IDs or resource names whose policies hold the
+// bindings of the session, the Target of the session first
+func (s Session) Targets() []string {
+	targets := []string{s.Target()}
+	seen := map[string]bool{s.Target(): true}
+	for _, b := range s.Bindings {
+		if b.Target != "" && !seen[b.Target] {
+			seen[b.Target] = true
+			targets = append(targets, b.Target)
+		}
+	}
+	return targets
+}
+
+// Schedule is a grant recurring in the windows of a cron expression, carried
+// out by gta schedule run
+type Schedule struct {
+	ID      string   `json:"id"`
+	Cron    string   `json:"cron"`
+	TTL     string   `json:"ttl"`
+	Project string   `json:"project"`
+	Roles   []string `json:"roles"`
+	User    string   `json:"user,omitempty"`
+	Members []string `json:"members,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Profile string   `json:"profile,omitempty"`
+	// CreatedAt is when the schedule was added
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Binding is a binding created by a session
+type Binding struct {
+	Role      string    `json:"role"`
+	BindingID string    `json:"binding_id"`
+	Expiry    time.Time `json:"expiry"`
+	// Target is the project ID or resource name whose policy holds the
+	// binding, empty for the Target of the session
+	Target string `json:"target,omitempty"`
+}
+
+// Expiry returns the earliest expiry of the session's bindings
+func (s Session) Expiry() time.Time {
+	var expiry time.Time
+	for _, b := range s.Bindings {
+		if expiry.IsZero() || b.Expiry.Before(expiry) {
+			expiry = b.Expiry
+		}
+	}
+	return expiry
+}
+
+// LastExpiry returns the latest expiry of the session's bindings, after which
+// none of them grants access
+func (s Session) LastExpiry() time.Time {
+	var expiry time.Time
+	for _, b := range s.Bindings {
+		if b.Expiry.After(expiry) {
+			expiry = b.Expiry
+		}
+	}
+	return expiry
+}
+
+// Session returns the session with the given ID
+func (f *File) Session(id string) (*Session, bool) {
+	for i := range f.Sessions {
+		if f.Sessions[i].ID == id {
+			return &f.Sessions[i], true
+		}
+	}
+	return nil, false
+}
+
+// Put adds s, replacing the session with the same ID
+func (f *File) Put(s Session) {
+	if existing, ok := f.Session(s.ID); ok {
+		*existing = s
+		return
+	}
+	f.Sessions = append(f.Sessions, s)
+}
+
+// Schedule returns the schedule with the given ID
+func (f *File) Schedule(id string) (*Schedule, bool) {
+	for i := range f.Schedules {
+		if f.Schedules[i].ID == id {
+			return &f.Schedules[i], true
+		}
+	}
+	return nil, false
+}
+
+// RemoveSchedule removes the schedule with the given ID, reporting whether it existed
+func (f *File) RemoveSchedule(id string) bool {
+	for i := range f.Schedules {
+		if f.Schedules[i].ID == id {
+			f.Schedules = append(f.Schedules[:i], f.Schedules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Stale returns the sessions whose bindings all expired more than retention
+// before now. Their bindings may still be in a policy, but grant nothing.
+func (f *File) Stale(now time.Time, retention time.Duration) []Session {
+	var stale []Session
+	for _, s := range f.Sessions {
+		if s.LastExpiry().Add(retention).Before(now) {
+			stale = append(stale, s)
+		}
+	}
+	return stale
+}
+
+// PendingRevocations returns the sessions whose revocation is to be retried
+// at now
+func (f *File) PendingRevocations(now time.Time) []Session {
+	var pending []Session
+	for _, s := range f.Sessions {
+		if s.PendingRevocation != nil && s.PendingRevocation.Due(now) {
+			pending = append(pending, s)
+		}
+	}
+	return pending
+}
+
+// Remove removes the session with the given ID, reporting whether it existed
+func (f *File) Remove(id string) bool {
+	for i := range f.Sessions {
+		if f.Sessions[i].ID == id {
+			f.Sessions = append(f.Sessions[:i], f.Sessions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Store reads and writes the state file. Updates hold an exclusive lock so
+// that concurrent gta processes do not overwrite each other's sessions.
+type Store struct {
+	path   string
+	cipher *encryption.Cipher
+}
+
+// NewStore creates a store for the state file at path, encrypted with cipher
+// unless it is nil. Plaintext files are read either way.
+func NewStore(path string, cipher *encryption.Cipher) *Store {
+	return &Store{path: path, cipher: cipher}
+}
+
+// Path returns the path of the state file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the state file, which is empty when it does not exist yet
+func (s *Store) Load() (*File, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.read()
+}
+
+// Snapshot reads the state file without taking the lock, for readers that
+// must not wait for a writer, such as a shell prompt. Writes replace the file
+// atomically, so the snapshot is the state before or after any of them.
+func (s *Store) Snapshot() (*File, error) {
+	return s.read()
+}
+
+// Update applies fn to the state file under the lock and writes the result
+// atomically. Nothing is written when fn fails.
+func (s *Store) Update(fn func(f *File) error) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		return err
+	}
+	return s.write(f)
+}
+
+// Convert rewrites the state file encrypted with cipher, or in plaintext if
+// it is nil, and uses cipher from then on
+func (s *Store) Convert(cipher *encryption.Cipher) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := s.read()
+	if err != nil {
+		return err
+	}
+	s.cipher = cipher
+	return s.write(f)
+}
+
+// lock takes the exclusive lock of the state file
+func (s *Store) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %v", err)
+	}
+	lock, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state lock: %v", err)
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to lock state file: %v", err)
+	}
+	return func() {
+		unlockFile(lock)
+		lock.Close()
+	}, nil
+}
+
+// read decodes the state file, migrating older schema versions
+func (s *Store) read() (*File, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &File{SchemaVersion: SchemaVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if data, err = s.cipher.Decrypt(data); err != nil {
+		return nil, fmt.Errorf("state file %s: %w; if its key is lost, move the file away and remove the bindings of its sessions with gta clean --project=PROJECT", s.path, err)
+	}
+	f, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("state file %s: %w", s.path, err)
+	}
+	return f, nil
+}
+
+// write replaces the state file with f through a temporary file, so that a
+// crash never leaves a truncated file behind
+func (s *Store) write(f *File) error {
+	f.SchemaVersion = SchemaVersion
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+	if s.cipher != nil {
+		data = s.cipher.Encrypt(data)
+	}
+
+	if err := fileutil.WriteAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// NewerVersionError is returned for a state file written by a newer gta
+type NewerVersionError struct {
+	Version int
+}
+
+// Error implements error
+func (e *NewerVersionError) Error() string {
+	return fmt.Sprintf("schema version %d is newer than version %d supported by this gta; upgrade gta, or move the file away to start over (its sessions will no longer be revoked by this gta)",
+		e.Version, SchemaVersion)
+}
+
+// Decode decodes a state file of any supported schema version, migrating it
+// to the current one. Unknown fields are rejected.
+func Decode(data []byte) (*File, error) {
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid state file: %v", err)
+	}
+	switch {
+	case header.SchemaVersion <= 0:
+		return nil, fmt.Errorf("invalid state file: missing schema_version")
+	case header.SchemaVersion > SchemaVersion:
+		return nil, &NewerVersionError{Version: header.SchemaVersion}
+	case header.SchemaVersion < SchemaVersion:
+		var err error
+		if data, err = migrate(data, header.SchemaVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var f File
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid state file: %v", err)
+	}
+	return &f, nil
+}
+
+// migrate upgrades a document from version to SchemaVersion
+func migrate(data []byte, version int) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid state file: %v", err)
+	}
+	for v := version; v < SchemaVersion; v++ {
+		step, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration from schema version %d", v)
+		}
+		if err := step(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate state from schema version %d: %v", v, err)
+		}
+		doc["schema_version"] = v + 1
+	}
+	return json.Marshal(doc)
+}
@@ -0,0 +1,177 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readFixture reads the state file written by schema version v
+func readFixture(t *testing.T, v int) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("v%d.json", v)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestEveryVersionHasAFixture makes a new schema version come with a
+// fixture file, so that its migration is tested
+func TestEveryVersionHasAFixture(t *testing.T) {
+	for v := 1; v <= SchemaVersion; v++ {
+		if _, err := os.Stat(filepath.Join("testdata", fmt.Sprintf("v%d.json", v))); err != nil {
+			t.Errorf("no fixture for schema version %d: %v", v, err)
+		}
+	}
+}
+
+func TestDecodeVersion1(t *testing.T) {
+	f, err := Decode(readFixture(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.SchemaVersion != SchemaVersion {
+		t.Errorf("schema version %d, want %d", f.SchemaVersion, SchemaVersion)
+	}
+	s, ok := f.Session("20240514T093000-ab12cd")
+	if !ok {
+		t.Fatalf("session lost in migration: %+v", f.Sessions)
+	}
+	if s.Project != "my-project" || s.Member != "user:alice@example.com" || s.Reason != "INC-42" || s.PID != 4242 {
+		t.Errorf("session = %+v", s)
+	}
+	// Every binding survives, so the session can still be revoked
+	if len(s.Bindings) != 2 || s.Bindings[1].BindingID != "gta_temporary_access_1715679000000000001" {
+		t.Errorf("bindings = %+v", s.Bindings)
+	}
+	if want := time.Date(2024, 5, 14, 10, 30, 0, 0, time.UTC); !s.Expiry().Equal(want) {
+		t.Errorf("expiry %s, want %s", s.Expiry(), want)
+	}
+	if len(f.Schedules) != 0 {
+		t.Errorf("schedules = %+v", f.Schedules)
+	}
+}
+
+func TestDecodeVersion2(t *testing.T) {
+	f, err := Decode(readFixture(t, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := f.Session("20240514T093000-ab12cd")
+	if !ok {
+		t.Fatal("session missing")
+	}
+	if s.ScheduleID != "weekday-oncall" {
+		t.Errorf("schedule ID = %q", s.ScheduleID)
+	}
+	// The pending revocation is kept for later commands to retry
+	due := time.Date(2024, 5, 14, 10, 33, 0, 0, time.UTC)
+	if pending := f.PendingRevocations(due); len(pending) != 1 || pending[0].PendingRevocation.Attempts != 2 {
+		t.Errorf("pending revocations = %+v", pending)
+	}
+	if len(f.PendingRevocations(due.Add(-time.Minute))) != 0 {
+		t.Errorf("revocation due before its next attempt")
+	}
+	if sched, ok := f.Schedule("weekday-oncall"); !ok || sched.Cron != "0 9 * * 1-5" {
+		t.Errorf("schedule = %+v", sched)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	for name, data := range map[string]string{
+		"missing version":  `{"sessions":[]}`,
+		"unknown field":    `{"schema_version":2,"sessions":[],"sesions":[]}`,
+		"unknown in v1":    `{"schema_version":1,"sessions":[{"id":"a","projects":["p"]}]}`,
+		"not JSON":         `schema_version: 2`,
+		"wrong field type": `{"schema_version":2,"sessions":{}}`,
+	} {
+		if f, err := Decode([]byte(data)); err == nil {
+			t.Errorf("%s: decoded %+v", name, f)
+		}
+	}
+}
+
+func TestDecodeNewerVersion(t *testing.T) {
+	data := strings.Replace(string(readFixture(t, SchemaVersion)), fmt.Sprintf(`"schema_version": %d`, SchemaVersion), fmt.Sprintf(`"schema_version": %d`, SchemaVersion+1), 1)
+	_, err := Decode([]byte(data))
+	var newer *NewerVersionError
+	if !errors.As(err, &newer) || newer.Version != SchemaVersion+1 {
+		t.Fatalf("err = %v, want a NewerVersionError", err)
+	}
+	if !strings.Contains(err.Error(), "upgrade gta") {
+		t.Errorf("no instructions in %q", err)
+	}
+}
+
+func TestStoreMigratesOnWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, readFixture(t, 1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(path, nil)
+	if err := store.Update(func(f *File) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), fmt.Sprintf(`"schema_version": %d`, SchemaVersion)) {
+		t.Errorf("state file not written at version %d:\n%s", SchemaVersion, data)
+	}
+	f, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := f.Session("20240514T093000-ab12cd"); !ok || len(s.Bindings) != 2 {
+		t.Errorf("session lost rewriting the state: %+v", f.Sessions)
+	}
+}
+
+func TestStoreRefusesNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	newer := fmt.Sprintf(`{"schema_version":%d,"sessions":[]}`, SchemaVersion+1)
+	if err := os.WriteFile(path, []byte(newer), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(path, nil)
+	if err := store.Update(func(f *File) error { return nil }); err == nil {
+		t.Fatal("updated a state file of a newer version")
+	}
+	// The file is left as it was for the newer gta
+	if data, _ := os.ReadFile(path); string(data) != newer {
+		t.Errorf("state file rewritten: %s", data)
+	}
+}
+
+func TestStoreConcurrentUpdates(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), FileName), nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.Update(func(f *File) error {
+				f.Put(Session{ID: fmt.Sprint(i), Provider: "gcp"})
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	f, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Sessions) != 20 {
+		t.Errorf("%d sessions after 20 concurrent updates", len(f.Sessions))
+	}
+}
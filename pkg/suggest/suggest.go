@@ -0,0 +1,361 @@
+// Package suggest finds the smallest predefined roles granting permissions
+package suggest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yckao/gta/pkg/provider"
+)
+
+// DefaultCacheMaxAge is how long the cached roles list is used before it is
+// refreshed, short enough for changes of launch stage to show within a day
+const DefaultCacheMaxAge = 24 * time.Hour
+
+// cacheVersion is the version of the roles cache format; caches of another
+// version are fetched again
+const cacheVersion = 2
+
+// permissionPattern matches candidate permissions such as storage.objects.get
+var permissionPattern = regexp.MustCompile(`\b[a-z][a-zA-Z0-9]*\.[a-zA-Z0-9]+\.[a-zA-Z0-9]+\b`)
+
+// Suggestion is a role granting all requested permissions
+type Suggestion struct {
+	Role  string
+	Title string
+	// Permissions is the number of permissions the role includes
+	Permissions int
+}
+
+// Catalog indexes predefined roles by permission
+type Catalog struct {
+	roles        []provider.PredefinedRole
+	byPermission map[string][]int
+}
+
+// NewCatalog indexes roles
+func NewCatalog(roles []provider.PredefinedRole) *Catalog {
+	c := &Catalog{
+		roles:        roles,
+		byPermission: make(map[string][]int),
+	}
+	for i, role := range roles {
+		for _, permission := range role.Permissions {
+			c.byPermission[permission] = append(c.byPermission[permission], i)
+		}
+	}
+	return c
+}
+
+// Roles returns the indexed roles
+func (c *Catalog) Roles() []provider.PredefinedRole {
+	return c.roles
+}
+
+// Role returns the role named name
+func (c *Catalog) Role(name string) (provider.PredefinedRole, bool) {
+	for _, role := range c.roles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+	return provider.PredefinedRole{}, false
+}
+
+// Known reports whether any role includes permission
+func (c *Catalog) Known(permission string) bool {
+	return len(c.byPermission[permission]) > 0
+}
+
+// ExtractPermissions returns the known permissions named in text, e.g. a
+// pasted PermissionDenied error, in order of appearance
+func (c *Catalog) ExtractPermissions(text string) []string {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, candidate := range permissionPattern.FindAllString(text, -1) {
+		if c.Known(candidate) && !seen[candidate] {
+			seen[candidate] = true
+			permissions = append(permissions, candidate)
+		}
+	}
+	return permissions
+}
+
+// Suggest returns the roles including every permission, smallest first.
+// Deprecated roles are left out.
+func (c *Catalog) Suggest(permissions []string) []Suggestion {
+	if len(permissions) == 0 {
+		return nil
+	}
+	counts := make(map[int]int)
+	for _, permission := range permissions {
+		for _, i := range c.byPermission[permission] {
+			counts[i]++
+		}
+	}
+
+	var suggestions []Suggestion
+	for i, count := range counts {
+		role := c.roles[i]
+		if count < len(permissions) || role.Stage == "DEPRECATED" {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			Role:        role.Name,
+			Title:       role.Title,
+			Permissions: len(role.Permissions),
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Permissions != suggestions[j].Permissions {
+			return suggestions[i].Permissions < suggestions[j].Permissions
+		}
+		return suggestions[i].Role < suggestions[j].Role
+	})
+	return suggestions
+}
+
+// Cover returns a small set of roles that together include every permission,
+// picking the role that includes the most missing permissions first and the
+// smaller role of equals, along with the permissions no role includes.
+// Deprecated roles are left out.
+func (c *Catalog) Cover(permissions []string) ([]Suggestion, []string) {
+	missing := make(map[string]bool)
+	var known, uncovered []string
+	for _, permission := range permissions {
+		if c.Known(permission) {
+			missing[permission] = true
+			known = append(known, permission)
+		} else {
+			uncovered = append(uncovered, permission)
+		}
+	}
+
+	var picked []int
+	for len(missing) > 0 {
+		counts := make(map[int]int)
+		for permission := range missing {
+			for _, i := range c.byPermission[permission] {
+				if c.roles[i].Stage != "DEPRECATED" {
+					counts[i]++
+				}
+			}
+		}
+		best := -1
+		for i, count := range counts {
+			if best < 0 || count > counts[best] || count == counts[best] && c.smaller(i, best) {
+				best = i
+			}
+		}
+		if best < 0 {
+			// Only deprecated roles include the rest
+			for permission := range missing {
+				uncovered = append(uncovered, permission)
+			}
+			break
+		}
+		picked = append(picked, best)
+		for _, permission := range c.roles[best].Permissions {
+			delete(missing, permission)
+		}
+	}
+	picked = c.dropRedundant(picked, known)
+
+	suggestions := make([]Suggestion, 0, len(picked))
+	for _, i := range picked {
+		role := c.roles[i]
+		suggestions = append(suggestions, Suggestion{Role: role.Name, Title: role.Title, Permissions: len(role.Permissions)})
+	}
+	sort.Strings(uncovered)
+	return suggestions, uncovered
+}
+
+// dropRedundant removes the roles whose share of permissions the other roles
+// include as well, largest first
+func (c *Catalog) dropRedundant(picked []int, permissions []string) []int {
+	sort.Slice(picked, func(a, b int) bool { return c.smaller(picked[b], picked[a]) })
+	for k := 0; k < len(picked); {
+		rest := append(append([]int{}, picked[:k]...), picked[k+1:]...)
+		if c.includesAll(rest, permissions, picked[k]) {
+			picked = rest
+			continue
+		}
+		k++
+	}
+	return picked
+}
+
+// includesAll reports whether the roles include every permission that role
+// includes
+func (c *Catalog) includesAll(roles []int, permissions []string, role int) bool {
+	included := make(map[string]bool)
+	for _, i := range roles {
+		for _, permission := range c.roles[i].Permissions {
+			included[permission] = true
+		}
+	}
+	own := make(map[string]bool)
+	for _, permission := range c.roles[role].Permissions {
+		own[permission] = true
+	}
+	for _, permission := range permissions {
+		if own[permission] && !included[permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// smaller reports whether role i includes fewer permissions than role j, or
+// as many and sorts first
+func (c *Catalog) smaller(i, j int) bool {
+	a, b := c.roles[i], c.roles[j]
+	if len(a.Permissions) != len(b.Permissions) {
+		return len(a.Permissions) < len(b.Permissions)
+	}
+	return a.Name < b.Name
+}
+
+// Search returns the roles matching query, best matches first: the role
+// named query, then roles whose name, title, or description contains it or
+// each of its words, and last roles whose name or title contains its
+// characters in order. Matching ignores case; an empty query matches every
+// role, sorted by name.
+func Search(roles []provider.PredefinedRole, query string) []provider.PredefinedRole {
+	query = strings.ToLower(strings.TrimSpace(query))
+	type match struct {
+		role provider.PredefinedRole
+		rank int
+	}
+	var matches []match
+	for _, role := range roles {
+		if rank, ok := searchRank(role, query); ok {
+			matches = append(matches, match{role: role, rank: rank})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].role.Name < matches[j].role.Name
+	})
+	found := make([]provider.PredefinedRole, len(matches))
+	for i, m := range matches {
+		found[i] = m.role
+	}
+	return found
+}
+
+// searchRank ranks how well role matches query, lower is better
+func searchRank(role provider.PredefinedRole, query string) (int, bool) {
+	name := strings.ToLower(role.Name)
+	short := name[strings.LastIndex(name, "/")+1:]
+	title := strings.ToLower(role.Title)
+	description := strings.ToLower(role.Description)
+	switch {
+	case query == "":
+		return 0, true
+	case name == query || short == query:
+		return 0, true
+	case strings.Contains(name, query):
+		return 1, true
+	case strings.Contains(title, query):
+		return 2, true
+	case strings.Contains(description, query):
+		return 3, true
+	case containsWords(name+" "+title+" "+description, query):
+		return 4, true
+	case subsequence(short, query) || subsequence(title, query):
+		return 5, true
+	}
+	return 0, false
+}
+
+// containsWords reports whether s contains every word of query
+func containsWords(s, query string) bool {
+	words := strings.Fields(query)
+	for _, word := range words {
+		if !strings.Contains(s, word) {
+			return false
+		}
+	}
+	return len(words) > 1
+}
+
+// subsequence reports whether s contains the characters of sub in order,
+// spaces in sub aside
+func subsequence(s, sub string) bool {
+	want := []rune(strings.ReplaceAll(sub, " ", ""))
+	i := 0
+	for _, r := range s {
+		if i == len(want) {
+			break
+		}
+		if r == want[i] {
+			i++
+		}
+	}
+	return i == len(want)
+}
+
+// cacheFile is the on-disk format of the roles cache
+type cacheFile struct {
+	Version   int                       `json:"version"`
+	FetchedAt time.Time                 `json:"fetched_at"`
+	Roles     []provider.PredefinedRole `json:"roles"`
+}
+
+// LoadCatalog returns the roles cached at path when they are younger than
+// maxAge, and otherwise fetches them and refreshes the cache
+func LoadCatalog(path string, maxAge time.Duration, fetch func() ([]provider.PredefinedRole, error)) (*Catalog, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		var cache cacheFile
+		if err := json.Unmarshal(data, &cache); err == nil && cache.Version == cacheVersion && time.Since(cache.FetchedAt) < maxAge && len(cache.Roles) > 0 {
+			return NewCatalog(cache.Roles), nil
+		}
+	}
+
+	roles, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCache(path, roles); err != nil {
+		return nil, err
+	}
+	return NewCatalog(roles), nil
+}
+
+// CachedRoles returns the roles cached at path however old they are, nil when
+// there is no cache, for callers that cannot wait for the roles to be fetched
+func CachedRoles(path string) []provider.PredefinedRole {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var cache cacheFile
+	if err := json.Unmarshal(data, &cache); err != nil || cache.Version != cacheVersion {
+		return nil
+	}
+	return cache.Roles
+}
+
+// writeCache stores roles at path
+func writeCache(path string, roles []provider.PredefinedRole) error {
+	data, err := json.Marshal(cacheFile{Version: cacheVersion, FetchedAt: time.Now().UTC(), Roles: roles})
+	if err != nil {
+		return fmt.Errorf("failed to encode roles cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write roles cache: %v", err)
+	}
+	return nil
+}
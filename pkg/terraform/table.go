@@ -0,0 +1,137 @@
+package terraform
+
+// crud returns the entry of a resource managed with the create, get, update,
+// and delete permissions of service.collection, e.g. pubsub.topics
+func crud(collection string) map[string][]string {
+	return map[string][]string{
+		ActionCreate: {collection + ".create"},
+		ActionRead:   {collection + ".get"},
+		ActionUpdate: {collection + ".update"},
+		ActionDelete: {collection + ".delete"},
+	}
+}
+
+// iamMember returns the entry of a resource editing the IAM policy of
+// service.collection, e.g. google_storage_bucket_iam_member
+func iamMember(collection string) map[string][]string {
+	set := []string{collection + ".getIamPolicy", collection + ".setIamPolicy"}
+	return map[string][]string{
+		ActionCreate: set,
+		ActionRead:   {collection + ".getIamPolicy"},
+		ActionUpdate: set,
+		ActionDelete: set,
+	}
+}
+
+// with adds permissions to an action of entry
+func with(entry map[string][]string, action string, permissions ...string) map[string][]string {
+	entry[action] = append(entry[action], permissions...)
+	return entry
+}
+
+// DefaultTable is the bundled permissions table of common resources of the
+// Google provider, extended with terraform.permissions in the configuration
+var DefaultTable = Table{
+	"google_artifact_registry_repository": crud("artifactregistry.repositories"),
+	"google_bigquery_dataset":             crud("bigquery.datasets"),
+	"google_bigquery_dataset_iam_member":  iamMember("bigquery.datasets"),
+	"google_bigquery_table":               crud("bigquery.tables"),
+	"google_cloud_run_service":            with(crud("run.services"), ActionCreate, "iam.serviceAccounts.actAs"),
+	"google_cloud_run_v2_service":         with(crud("run.services"), ActionCreate, "iam.serviceAccounts.actAs"),
+	"google_cloud_run_service_iam_member": iamMember("run.services"),
+	"google_cloudfunctions_function":      with(crud("cloudfunctions.functions"), ActionCreate, "iam.serviceAccounts.actAs"),
+	"google_compute_address": {
+		ActionCreate: {"compute.addresses.create"},
+		ActionRead:   {"compute.addresses.get"},
+		ActionUpdate: {"compute.addresses.setLabels"},
+		ActionDelete: {"compute.addresses.delete"},
+	},
+	"google_compute_disk": crud("compute.disks"),
+	"google_compute_firewall": {
+		ActionCreate: {"compute.firewalls.create", "compute.networks.updatePolicy"},
+		ActionRead:   {"compute.firewalls.get"},
+		ActionUpdate: {"compute.firewalls.update", "compute.networks.updatePolicy"},
+		ActionDelete: {"compute.firewalls.delete", "compute.networks.updatePolicy"},
+	},
+	"google_compute_instance": {
+		ActionCreate: {
+			"compute.instances.create", "compute.disks.create", "compute.subnetworks.use",
+			"compute.subnetworks.useExternalIp", "compute.instances.setMetadata",
+			"compute.instances.setServiceAccount", "iam.serviceAccounts.actAs",
+		},
+		ActionRead: {"compute.instances.get"},
+		ActionUpdate: {
+			"compute.instances.setMetadata", "compute.instances.setLabels", "compute.instances.setTags",
+			"compute.instances.stop", "compute.instances.start",
+		},
+		ActionDelete: {"compute.instances.delete"},
+	},
+	"google_compute_network": crud("compute.networks"),
+	"google_compute_subnetwork": {
+		ActionCreate: {"compute.subnetworks.create", "compute.networks.updatePolicy"},
+		ActionRead:   {"compute.subnetworks.get"},
+		ActionUpdate: {"compute.subnetworks.update"},
+		ActionDelete: {"compute.subnetworks.delete"},
+	},
+	"google_container_cluster": with(crud("container.clusters"), ActionCreate, "iam.serviceAccounts.actAs"),
+	"google_container_node_pool": {
+		ActionCreate: {"container.clusters.update", "iam.serviceAccounts.actAs"},
+		ActionRead:   {"container.clusters.get"},
+		ActionUpdate: {"container.clusters.update"},
+		ActionDelete: {"container.clusters.update"},
+	},
+	"google_dns_managed_zone": crud("dns.managedZones"),
+	"google_dns_record_set": {
+		ActionCreate: {"dns.changes.create", "dns.resourceRecordSets.create"},
+		ActionRead:   {"dns.resourceRecordSets.list"},
+		ActionUpdate: {"dns.changes.create", "dns.resourceRecordSets.update"},
+		ActionDelete: {"dns.changes.create", "dns.resourceRecordSets.delete"},
+	},
+	"google_kms_crypto_key": {
+		ActionCreate: {"cloudkms.cryptoKeys.create"},
+		ActionRead:   {"cloudkms.cryptoKeys.get"},
+		ActionUpdate: {"cloudkms.cryptoKeys.update"},
+		ActionDelete: {"cloudkms.cryptoKeyVersions.destroy"},
+	},
+	"google_kms_key_ring": {
+		ActionCreate: {"cloudkms.keyRings.create"},
+		ActionRead:   {"cloudkms.keyRings.get"},
+	},
+	"google_logging_project_sink": crud("logging.sinks"),
+	"google_project_iam_binding":  iamMember("resourcemanager.projects"),
+	"google_project_iam_member":   iamMember("resourcemanager.projects"),
+	"google_project_service": {
+		ActionCreate: {"serviceusage.services.enable"},
+		ActionRead:   {"serviceusage.services.get"},
+		ActionDelete: {"serviceusage.services.disable"},
+	},
+	"google_pubsub_subscription":     with(crud("pubsub.subscriptions"), ActionCreate, "pubsub.topics.attachSubscription"),
+	"google_pubsub_topic":            crud("pubsub.topics"),
+	"google_pubsub_topic_iam_member": iamMember("pubsub.topics"),
+	"google_secret_manager_secret":   crud("secretmanager.secrets"),
+	"google_secret_manager_secret_version": {
+		ActionCreate: {"secretmanager.versions.add"},
+		ActionRead:   {"secretmanager.versions.get", "secretmanager.versions.access"},
+		ActionUpdate: {"secretmanager.versions.enable", "secretmanager.versions.disable"},
+		ActionDelete: {"secretmanager.versions.destroy"},
+	},
+	"google_service_account":            crud("iam.serviceAccounts"),
+	"google_service_account_iam_member": iamMember("iam.serviceAccounts"),
+	"google_service_account_key": {
+		ActionCreate: {"iam.serviceAccountKeys.create"},
+		ActionRead:   {"iam.serviceAccountKeys.get"},
+		ActionDelete: {"iam.serviceAccountKeys.delete"},
+	},
+	"google_sql_database":          crud("cloudsql.databases"),
+	"google_sql_database_instance": crud("cloudsql.instances"),
+	"google_sql_user": {
+		ActionCreate: {"cloudsql.users.create"},
+		ActionRead:   {"cloudsql.users.list"},
+		ActionUpdate: {"cloudsql.users.update"},
+		ActionDelete: {"cloudsql.users.delete"},
+	},
+	"google_storage_bucket":             crud("storage.buckets"),
+	"google_storage_bucket_iam_binding": iamMember("storage.buckets"),
+	"google_storage_bucket_iam_member":  iamMember("storage.buckets"),
+	"google_storage_bucket_object":      crud("storage.objects"),
+}
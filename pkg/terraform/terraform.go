@@ -0,0 +1,149 @@
+// Package terraform derives the IAM permissions a Terraform plan needs
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Actions of a resource change, as used in the permissions table
+const (
+	ActionCreate = "create"
+	ActionRead   = "read"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// googleProviders are the Terraform providers whose resources are mapped,
+// resources of other providers need no GCP permission
+var googleProviders = []string{"hashicorp/google", "hashicorp/google-beta"}
+
+// Table maps a resource type and action to the permissions it needs
+type Table map[string]map[string][]string
+
+// Plan is the part of a Terraform JSON plan, as printed by
+// terraform show -json, that gta reads
+type Plan struct {
+	FormatVersion   string           `json:"format_version"`
+	ResourceChanges []ResourceChange `json:"resource_changes"`
+}
+
+// ResourceChange is a planned change of a resource or data source
+type ResourceChange struct {
+	Address      string `json:"address"`
+	Mode         string `json:"mode"`
+	Type         string `json:"type"`
+	ProviderName string `json:"provider_name"`
+	Change       struct {
+		Actions []string `json:"actions"`
+	} `json:"change"`
+}
+
+// Requirements are the permissions a plan needs
+type Requirements struct {
+	// Permissions are the permissions needed, sorted
+	Permissions []string
+	// Unknown are the resource types of a Google provider that the table
+	// does not map, sorted
+	Unknown []string
+	// Resources is the number of resources of the plan that the table maps
+	Resources int
+}
+
+// LoadPlan reads a Terraform JSON plan
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Terraform plan: %v", err)
+	}
+	return ParsePlan(data)
+}
+
+// ParsePlan parses a Terraform JSON plan
+func ParsePlan(data []byte) (*Plan, error) {
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil || plan.FormatVersion == "" {
+		return nil, fmt.Errorf("not a Terraform JSON plan, convert the plan with terraform show -json plan.out > plan.json")
+	}
+	return &plan, nil
+}
+
+// Merge returns the table with the entries of extra added, replacing the
+// permissions of the actions extra maps
+func (t Table) Merge(extra Table) Table {
+	merged := make(Table, len(t)+len(extra))
+	for resourceType, actions := range t {
+		merged[resourceType] = make(map[string][]string, len(actions))
+		for action, permissions := range actions {
+			merged[resourceType][action] = permissions
+		}
+	}
+	for resourceType, actions := range extra {
+		if merged[resourceType] == nil {
+			merged[resourceType] = make(map[string][]string, len(actions))
+		}
+		for action, permissions := range actions {
+			merged[resourceType][action] = permissions
+		}
+	}
+	return merged
+}
+
+// Requirements returns the permissions the changes of plan need. Terraform
+// refreshes every managed resource before changing it, so the read
+// permissions of a resource are needed whatever its actions.
+func (t Table) Requirements(plan *Plan) Requirements {
+	permissions := make(map[string]bool)
+	unknown := make(map[string]bool)
+	var r Requirements
+	for _, rc := range plan.ResourceChanges {
+		if !googleResource(rc.ProviderName) {
+			continue
+		}
+		actions, ok := t[rc.Type]
+		if !ok {
+			unknown[rc.Type] = true
+			continue
+		}
+		r.Resources++
+		needed := []string{ActionRead}
+		for _, action := range rc.Change.Actions {
+			switch action {
+			case ActionCreate, ActionUpdate, ActionDelete:
+				needed = append(needed, action)
+			}
+		}
+		for _, action := range needed {
+			for _, permission := range actions[action] {
+				permissions[permission] = true
+			}
+		}
+	}
+	r.Permissions = sortedKeys(permissions)
+	r.Unknown = sortedKeys(unknown)
+	return r
+}
+
+// googleResource reports whether a resource of the provider named
+// providerName, e.g. registry.terraform.io/hashicorp/google, is mapped
+func googleResource(providerName string) bool {
+	for _, name := range googleProviders {
+		if providerName == name || strings.HasSuffix(providerName, "/"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of set, sorted
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,253 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Keys of the picker, besides the arrow keys
+const (
+	keyEnter     = "\r"
+	keyNewline   = "\n"
+	keyEscape    = "\x1b"
+	keyBackspace = "\x7f"
+	keyCtrlH     = "\x08"
+	keyCtrlN     = "\x0e"
+	keyCtrlP     = "\x10"
+	keyCtrlU     = "\x15"
+)
+
+// ErrCancelled is returned by Pick when the choice is cancelled with Esc or
+// Ctrl+C
+var ErrCancelled = errors.New("cancelled")
+
+// Choice is an entry of Pick, shown as aligned columns and matched on all of
+// them
+type Choice struct {
+	Columns []string
+}
+
+// PickOptions configure Pick
+type PickOptions struct {
+	In  *os.File
+	Out *os.File
+	// Title is shown above the query
+	Title string
+	// Header names the columns of the choices
+	Header []string
+}
+
+// Pick shows choices full screen, narrowed as a query is typed, and returns
+// the index of the one selected with Enter. It is the picker of every list
+// gta asks to choose from, such as projects or roles.
+func Pick(choices []Choice, opts PickOptions) (int, error) {
+	if len(choices) == 0 {
+		return -1, fmt.Errorf("nothing to choose from")
+	}
+	restore, err := makeRaw(opts.In, opts.Out)
+	if err != nil {
+		return -1, err
+	}
+	defer restore()
+	fmt.Fprint(opts.Out, enterScreen)
+	defer fmt.Fprint(opts.Out, leaveScreen)
+
+	p := &picker{choices: choices, opts: opts}
+	p.filter()
+	// Keys are read here rather than by a goroutine, which would be left
+	// reading input meant for the prompts after the picker
+	buf := make([]byte, 16)
+	for {
+		p.draw()
+		n, err := opts.In.Read(buf)
+		if err != nil {
+			return -1, ErrCancelled
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			if done, index, err := p.handle(key); done {
+				return index, err
+			}
+		}
+	}
+}
+
+// picker is the state of Pick: the query typed, the choices matching it, and
+// the one selected
+type picker struct {
+	choices  []Choice
+	opts     PickOptions
+	query    string
+	matches  []int
+	selected int
+}
+
+// handle carries out a key, reporting whether the choice is done with the
+// index chosen or the error
+func (p *picker) handle(key string) (bool, int, error) {
+	switch key {
+	case keyCtrlC, keyEscape:
+		return true, -1, ErrCancelled
+	case keyEnter, keyNewline:
+		if len(p.matches) == 0 {
+			return false, 0, nil
+		}
+		return true, p.matches[p.selected], nil
+	case keyUp, keyCtrlP:
+		if p.selected > 0 {
+			p.selected--
+		}
+	case keyDown, keyCtrlN:
+		if p.selected < len(p.matches)-1 {
+			p.selected++
+		}
+	case keyBackspace, keyCtrlH:
+		if p.query != "" {
+			_, n := utf8.DecodeLastRuneInString(p.query)
+			p.query = p.query[:len(p.query)-n]
+			p.filter()
+		}
+	case keyCtrlU:
+		p.query = ""
+		p.filter()
+	default:
+		if r, _ := utf8.DecodeRuneInString(key); len(key) == 1 && unicode.IsPrint(r) {
+			p.query += key
+			p.filter()
+		}
+	}
+	return false, 0, nil
+}
+
+// filter matches the choices against the query, selecting the best match
+func (p *picker) filter() {
+	p.matches = Rank(p.choices, p.query)
+	p.selected = 0
+}
+
+// draw draws the choices matching the query for the current size of the
+// terminal
+func (p *picker) draw() {
+	width, height, err := size(p.opts.Out)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	fmt.Fprint(p.opts.Out, p.render(width, height))
+}
+
+// render returns the screen of the picker in a terminal of width columns and
+// height lines
+func (p *picker) render(width, height int) string {
+	var lines []string
+	if p.opts.Title != "" {
+		lines = append(lines, p.opts.Title, "")
+	}
+	lines = append(lines, "> "+p.query)
+
+	table := [][]string{p.opts.Header}
+	for _, i := range p.matches {
+		table = append(table, p.choices[i].Columns)
+	}
+	formatted := formatTable(table)
+	if len(p.opts.Header) > 0 {
+		lines = append(lines, "  "+formatted[0])
+	}
+	rows := formatted[1:]
+
+	// Keep the selected row in view, leaving room for the footer
+	visible := height - len(lines) - 2
+	if visible < 1 {
+		visible = 1
+	}
+	first := 0
+	if p.selected >= visible {
+		first = p.selected - visible + 1
+	}
+	if len(rows) == 0 {
+		lines = append(lines, "  No match")
+	}
+	for i := first; i < len(rows) && i < first+visible; i++ {
+		if i == p.selected {
+			lines = append(lines, reverse+fit("> "+rows[i], width)+reset)
+		} else {
+			lines = append(lines, "  "+rows[i])
+		}
+	}
+	lines = append(lines, "", fmt.Sprintf("%d/%d  type to search  ↑/↓ move  Enter choose  Esc cancel", len(p.matches), len(p.choices)))
+
+	var b strings.Builder
+	b.WriteString(home)
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		if !strings.HasPrefix(line, reverse) {
+			line = fit(line, width)
+		}
+		b.WriteString(line)
+		b.WriteString(clearLine)
+		if i < len(lines)-1 && i < height-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(clearBelow)
+	return b.String()
+}
+
+// Rank returns the indexes of the choices matching query, best first. A
+// choice matches when the characters of query appear in its columns in
+// order, ignoring case; consecutive characters and those starting a word
+// count more, and ties keep the order of choices. An empty query matches
+// every choice.
+func Rank(choices []Choice, query string) []int {
+	query = strings.ToLower(strings.TrimSpace(query))
+	type match struct {
+		index int
+		score int
+	}
+	var matches []match
+	for i, choice := range choices {
+		if score, ok := fuzzyScore(strings.ToLower(strings.Join(choice.Columns, " ")), query); ok {
+			matches = append(matches, match{index: i, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	ranked := make([]int, len(matches))
+	for i, m := range matches {
+		ranked[i] = m.index
+	}
+	return ranked
+}
+
+// fuzzyScore reports whether the runes of query appear in text in order, and
+// scores the match
+func fuzzyScore(text, query string) (int, bool) {
+	score := 0
+	previous := -2
+	q := []rune(query)
+	runes := []rune(text)
+	for i, j := 0, 0; j < len(q); i++ {
+		if i >= len(runes) {
+			return 0, false
+		}
+		if runes[i] != q[j] {
+			continue
+		}
+		score++
+		if i == previous+1 {
+			score += 2
+		}
+		if i == 0 || !unicode.IsLetter(runes[i-1]) && !unicode.IsDigit(runes[i-1]) {
+			score += 3
+		}
+		previous = i
+		j++
+	}
+	return score, true
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !windows
+
+package tui
+
+import (
+	"fmt"
+	"os"
+)
+
+// makeRaw is not supported on this platform
+func makeRaw(in, out *os.File) (func(), error) {
+	return nil, fmt.Errorf("full-screen mode is not supported on this platform")
+}
+
+// size is not supported on this platform
+func size(out *os.File) (int, int, error) {
+	return 0, 0, fmt.Errorf("terminal size is not supported on this platform")
+}
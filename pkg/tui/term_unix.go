@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package tui
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw puts the terminal of in into raw mode, delivering each key as it
+// is pressed without echoing it, and returns the function restoring it
+func makeRaw(in, out *os.File) (func(), error) {
+	fd := int(in.Fd())
+	state, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read terminal state: %v", err)
+	}
+	raw := *state
+	raw.Iflag &^= unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &raw); err != nil {
+		return nil, fmt.Errorf("failed to set raw mode: %v", err)
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlWriteTermios, state) }, nil
+}
+
+// size returns the width and height of the terminal of out
+func size(out *os.File) (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(int(out.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,46 @@
+//go:build windows
+
+package tui
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw puts the console of in into raw mode, delivering each key as it
+// is pressed without echoing it and arrow keys as escape sequences, turns on
+// escape sequences on out, and returns the function restoring both
+func makeRaw(in, out *os.File) (func(), error) {
+	inHandle := windows.Handle(in.Fd())
+	outHandle := windows.Handle(out.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(inHandle, &inMode); err != nil {
+		return nil, fmt.Errorf("failed to read console mode: %v", err)
+	}
+	if err := windows.GetConsoleMode(outHandle, &outMode); err != nil {
+		return nil, fmt.Errorf("failed to read console mode: %v", err)
+	}
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT|windows.ENABLE_PROCESSED_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(inHandle, raw); err != nil {
+		return nil, fmt.Errorf("failed to set raw mode: %v", err)
+	}
+	if err := windows.SetConsoleMode(outHandle, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		windows.SetConsoleMode(inHandle, inMode)
+		return nil, fmt.Errorf("failed to enable escape sequences: %v", err)
+	}
+	return func() {
+		windows.SetConsoleMode(inHandle, inMode)
+		windows.SetConsoleMode(outHandle, outMode)
+	}, nil
+}
+
+// size returns the width and height of the console window of out
+func size(out *os.File) (int, int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(out.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
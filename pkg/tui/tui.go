@@ -0,0 +1,404 @@
+// Package tui draws the dashboard of gta tui full screen and maps keys to
+// its actions, and the picker of the lists gta asks to choose from
+package tui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yckao/gta/pkg/dashboard"
+	"github.com/yckao/gta/pkg/duration"
+)
+
+// Escape sequences of the terminal
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	home        = "\x1b[H"
+	clearLine   = "\x1b[K"
+	clearBelow  = "\x1b[J"
+	reverse     = "\x1b[7m"
+	reset       = "\x1b[0m"
+)
+
+// Keys of the dashboard
+const (
+	keyUp      = "up"
+	keyDown    = "down"
+	keyQuit    = "q"
+	keyCtrlC   = "\x03"
+	keyExtend  = "e"
+	keyRevoke  = "r"
+	keyClean   = "c"
+	keyRefresh = "g"
+	keyYes     = "y"
+)
+
+// barWidth is the width of the remaining time bars
+const barWidth = 20
+
+// logLines is the number of log lines shown below the table
+const logLines = 5
+
+// Options configure Run
+type Options struct {
+	In  *os.File
+	Out *os.File
+	// Refresh is how often the rows are read again, never when 0
+	Refresh time.Duration
+	// Logs are shown below the table
+	Logs *LogBuffer
+}
+
+// Run shows m full screen until q or Ctrl+C is pressed or ctx is done. The
+// rows are read when it starts and then every opts.Refresh.
+func Run(ctx context.Context, m *dashboard.Model, opts Options) error {
+	restore, err := makeRaw(opts.In, opts.Out)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	fmt.Fprint(opts.Out, enterScreen)
+	defer fmt.Fprint(opts.Out, leaveScreen)
+
+	v := &view{m: m, out: opts.Out, logs: opts.Logs}
+	v.do(func(now time.Time) error { return m.Refresh(now) }, "Reading bindings...")
+
+	keys := make(chan string)
+	go readKeys(opts.In, keys)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var refresh <-chan time.Time
+	if opts.Refresh > 0 {
+		refreshTicker := time.NewTicker(opts.Refresh)
+		defer refreshTicker.Stop()
+		refresh = refreshTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			v.draw()
+		case <-refresh:
+			v.do(func(now time.Time) error { return m.Refresh(now) }, "")
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if quit := v.handle(key); quit {
+				return nil
+			}
+		}
+	}
+}
+
+// view draws the model and carries out the keys pressed
+type view struct {
+	m    *dashboard.Model
+	out  *os.File
+	logs *LogBuffer
+	// confirm is the action awaiting a y, with its question
+	confirm  func(now time.Time) error
+	question string
+}
+
+// handle carries out a key, reporting whether to quit
+func (v *view) handle(key string) bool {
+	if v.confirm != nil {
+		action := v.confirm
+		v.confirm, v.question = nil, ""
+		if key == keyYes {
+			v.do(action, "Working...")
+		} else {
+			v.m.Message = "Cancelled"
+			v.draw()
+		}
+		return false
+	}
+
+	switch key {
+	case keyQuit, keyCtrlC:
+		return true
+	case keyUp, "k":
+		v.m.Move(-1)
+	case keyDown, "j":
+		v.m.Move(1)
+	case keyRefresh:
+		v.do(func(now time.Time) error { return v.m.Refresh(now) }, "Reading bindings...")
+		return false
+	case keyExtend:
+		v.do(v.m.Extend, "Extending...")
+		return false
+	case keyRevoke:
+		row, ok := v.m.Current()
+		if !ok {
+			return false
+		}
+		v.ask(fmt.Sprintf("Revoke %s from %s on %s?", row.Role, row.Member, row.Target), v.m.Revoke)
+	case keyClean:
+		v.ask(fmt.Sprintf("Remove the expired bindings of %s?", strings.Join(v.m.Projects, ", ")), v.m.CleanExpired)
+	}
+	v.draw()
+	return false
+}
+
+// ask asks to confirm action with y
+func (v *view) ask(question string, action func(now time.Time) error) {
+	v.question = question + " [y/N]"
+	v.confirm = action
+}
+
+// do carries out action, showing progress first and its error after
+func (v *view) do(action func(now time.Time) error, progress string) {
+	if progress != "" {
+		v.m.Message = progress
+		v.draw()
+	}
+	previous := v.m.Message
+	if err := action(time.Now()); err != nil {
+		v.m.Message = "Error: " + err.Error()
+	} else if v.m.Message == previous && progress != "" {
+		v.m.Message = ""
+	}
+	v.draw()
+}
+
+// draw draws the model for the current size of the terminal
+func (v *view) draw() {
+	width, height, err := size(v.out)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	var logs []string
+	if v.logs != nil {
+		logs = v.logs.Lines()
+	}
+	prompt := v.question
+	fmt.Fprint(v.out, Render(v.m, time.Now(), width, height, prompt, logs))
+}
+
+// Render returns the screen showing m at now in a terminal of width columns
+// and height lines, with prompt, when set, in place of the key help
+func Render(m *dashboard.Model, now time.Time, width, height int, prompt string, logs []string) string {
+	var lines []string
+	header := "gta dashboard: " + strings.Join(m.Projects, ", ")
+	if m.DryRun {
+		header += " [DRY-RUN]"
+	}
+	if !m.Refreshed.IsZero() {
+		header += "  (read " + m.Refreshed.Local().Format("15:04:05") + ")"
+	}
+	lines = append(lines, header, "")
+
+	table := [][]string{{"TARGET", "ROLE", "MEMBER", "REMAINING", "", "STATUS", "SESSION"}}
+	scale := m.Scale(now)
+	for _, row := range m.Rows {
+		session := row.SessionID
+		if session == "" {
+			session = "-"
+		}
+		table = append(table, []string{
+			row.Target,
+			row.Role,
+			row.Member,
+			formatRemaining(row.Remaining(now)),
+			bar(row.Fraction(now, scale)),
+			row.Status(now),
+			session,
+		})
+	}
+	formatted := formatTable(table)
+	lines = append(lines, "  "+formatted[0])
+
+	// Keep the selected row in view, leaving room for the lines below
+	footer := 4 + len(m.Problems)
+	if len(logs) > 0 {
+		footer += 1 + len(logs)
+	}
+	visible := height - len(lines) - footer
+	if visible < 1 {
+		visible = 1
+	}
+	first := 0
+	if m.Selected >= visible {
+		first = m.Selected - visible + 1
+	}
+	if len(m.Rows) == 0 {
+		lines = append(lines, "  No temporary bindings")
+	}
+	for i := first; i < len(m.Rows) && i < first+visible; i++ {
+		line := formatted[i+1]
+		if i == m.Selected {
+			line = reverse + fit("> "+line, width) + reset
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+
+	lines = append(lines, "")
+	for _, problem := range m.Problems {
+		lines = append(lines, "Failed to list "+problem)
+	}
+	if m.Message != "" {
+		lines = append(lines, m.Message)
+	}
+	if len(logs) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, logs...)
+	}
+	lines = append(lines, "")
+	if prompt != "" {
+		lines = append(lines, prompt)
+	} else {
+		lines = append(lines, "↑/↓ move  e extend by "+duration.Format(m.ExtendBy)+"  r revoke  c clean expired  g refresh  q quit")
+	}
+
+	var b strings.Builder
+	b.WriteString(home)
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		if !strings.HasPrefix(line, reverse) {
+			line = fit(line, width)
+		}
+		b.WriteString(line)
+		b.WriteString(clearLine)
+		if i < len(lines)-1 && i < height-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(clearBelow)
+	return b.String()
+}
+
+// formatTable pads the cells of table into aligned columns
+func formatTable(table [][]string) []string {
+	var widths []int
+	for _, row := range table {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	lines := make([]string, len(table))
+	for r, row := range table {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+			}
+		}
+		lines[r] = b.String()
+	}
+	return lines
+}
+
+// fit truncates line to width columns
+func fit(line string, width int) string {
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	runes := []rune(line)
+	return string(runes[:width])
+}
+
+// bar draws a fraction between 0 and 1 as a bar of barWidth cells
+func bar(fraction float64) string {
+	filled := int(fraction*barWidth + 0.5)
+	return strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+}
+
+// formatRemaining formats a remaining time to the second, e.g. 1d2h3m4s
+func formatRemaining(d time.Duration) string {
+	if d < time.Second {
+		return "0s"
+	}
+	return duration.Format(d)
+}
+
+// readKeys sends the keys read from in to keys, arrow keys by name, and
+// closes keys once in is closed
+func readKeys(in *os.File, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 16)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// parseKeys splits the bytes of a read into keys, naming the arrow keys
+func parseKeys(data []byte) []string {
+	var keys []string
+	for len(data) > 0 {
+		if len(data) >= 3 && data[0] == 0x1b && (data[1] == '[' || data[1] == 'O') {
+			switch data[2] {
+			case 'A':
+				keys = append(keys, keyUp)
+			case 'B':
+				keys = append(keys, keyDown)
+			}
+			data = data[3:]
+			continue
+		}
+		keys = append(keys, strings.ToLower(string(data[0])))
+		data = data[1:]
+	}
+	return keys
+}
+
+// LogBuffer keeps the last lines written to it, e.g. by the logger while the
+// dashboard is shown. It is safe for concurrent use.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	partial string
+	max     int
+}
+
+// NewLogBuffer returns a LogBuffer keeping the last logLines lines
+func NewLogBuffer() *LogBuffer {
+	return &LogBuffer{max: logLines}
+}
+
+// Write implements io.Writer
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	text := b.partial + string(p)
+	parts := strings.Split(text, "\n")
+	b.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.max {
+		b.lines = b.lines[len(b.lines)-b.max:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the lines kept, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
@@ -0,0 +1,358 @@
+// Package upgrade finds the latest GitHub release of gta and replaces the
+// running executable with it
+package upgrade
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultAPIURL is the GitHub API releases are looked up with
+	DefaultAPIURL = "https://api.github.com"
+	// DefaultRepository is the GitHub repository gta is released from
+	DefaultRepository = "yckao/gta"
+	// checksumsName is the release asset listing the SHA-256 of the others
+	checksumsName = "checksums.txt"
+	// maxAssetSize bounds the download of an asset
+	maxAssetSize = 200 << 20
+)
+
+// Release is a GitHub release
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	URL    string  `json:"html_url"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Updater looks up and downloads the releases of Repository
+type Updater struct {
+	Client     *http.Client
+	APIURL     string
+	Repository string
+}
+
+// NetworkError is a release lookup or download that failed to reach GitHub
+// or got an unexpected response
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+// Error implements error
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("failed to reach %s: %v (check the network, proxy, and http settings)", e.URL, e.Err)
+}
+
+// Unwrap returns the cause of the failure
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Latest returns the latest release of the repository
+func (u *Updater) Latest(ctx context.Context) (*Release, error) {
+	apiURL := u.APIURL
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	repository := u.Repository
+	if repository == "" {
+		repository = DefaultRepository
+	}
+	url := strings.TrimSuffix(apiURL, "/") + "/repos/" + repository + "/releases/latest"
+	data, err := u.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	var release Release
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("failed to decode the latest release: %v", err)
+	}
+	if release.Tag == "" {
+		return nil, fmt.Errorf("the latest release of %s has no tag", repository)
+	}
+	return &release, nil
+}
+
+// Asset returns the archive or executable of release built for goos and
+// goarch, e.g. gta_1.2.3_linux_amd64.tar.gz
+func (r *Release) Asset(goos, goarch string) (*Asset, error) {
+	arches := []string{goarch}
+	switch goarch {
+	case "amd64":
+		arches = append(arches, "x86_64")
+	case "arm64":
+		arches = append(arches, "aarch64")
+	}
+	for i := range r.Assets {
+		name := strings.ToLower(r.Assets[i].Name)
+		if name == checksumsName || strings.HasSuffix(name, ".sig") || strings.HasSuffix(name, ".pem") {
+			continue
+		}
+		if !strings.Contains(name, "_"+goos+"_") && !strings.Contains(name, "-"+goos+"-") {
+			continue
+		}
+		for _, arch := range arches {
+			if strings.Contains(name, arch) {
+				return &r.Assets[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("release %s has no asset for %s/%s", r.Tag, goos, goarch)
+}
+
+// Download downloads asset of release, verifies it against the checksums
+// file of the release, and returns the gta executable it holds
+func (u *Updater) Download(ctx context.Context, release *Release, asset *Asset) ([]byte, error) {
+	var checksums *Asset
+	for i := range release.Assets {
+		if release.Assets[i].Name == checksumsName {
+			checksums = &release.Assets[i]
+		}
+	}
+	if checksums == nil {
+		return nil, fmt.Errorf("release %s has no %s to verify %s with", release.Tag, checksumsName, asset.Name)
+	}
+	list, err := u.get(ctx, checksums.URL, "")
+	if err != nil {
+		return nil, err
+	}
+	want, err := findChecksum(list, asset.Name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := u.get(ctx, asset.URL, "")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, expected %s", asset.Name, got, want)
+	}
+	return extract(asset.Name, data)
+}
+
+// get fetches url, failing on any status other than 200
+func (u *Updater) get(ctx context.Context, url, accept string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &NetworkError{URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &NetworkError{URL: url, Err: fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAssetSize+1))
+	if err != nil {
+		return nil, &NetworkError{URL: url, Err: err}
+	}
+	if len(data) > maxAssetSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", url, maxAssetSize)
+	}
+	return data, nil
+}
+
+// findChecksum returns the SHA-256 of name in a checksums file of lines
+// "HASH  NAME"
+func findChecksum(list []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s lists no checksum for %s", checksumsName, name)
+}
+
+// extract returns the gta executable in an asset named name: a .tar.gz or
+// .zip archive, or the executable itself
+func extract(name string, data []byte) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", name, err)
+			}
+			if header.Typeflag == tar.TypeReg && isExecutableName(header.Name) {
+				return io.ReadAll(io.LimitReader(tr, maxAssetSize))
+			}
+		}
+	case strings.HasSuffix(name, ".zip"):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		for _, f := range zr.File {
+			if !isExecutableName(f.Name) {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", name, err)
+			}
+			defer rc.Close()
+			return io.ReadAll(io.LimitReader(rc, maxAssetSize))
+		}
+	default:
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s holds no gta executable", name)
+}
+
+// isExecutableName reports whether an archive entry is the gta executable
+func isExecutableName(name string) bool {
+	base := path.Base(name)
+	return base == "gta" || base == "gta.exe"
+}
+
+// Replace atomically replaces the executable at exe with binary, written
+// next to it first. On Windows, where a running executable cannot be
+// replaced but can be renamed, the current one is moved aside to exe.old,
+// removed by RemoveOld on a later run.
+func Replace(exe string, binary []byte, windows bool) error {
+	dir := filepath.Dir(exe)
+	tmp, err := os.CreateTemp(dir, filepath.Base(exe)+".*.new")
+	if err != nil {
+		return installError(dir, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return installError(dir, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return installError(dir, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return installError(dir, err)
+	}
+
+	if !windows {
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			return installError(dir, err)
+		}
+		return nil
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return installError(dir, err)
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		// Put the current executable back rather than leave none
+		if restoreErr := os.Rename(old, exe); restoreErr != nil {
+			return fmt.Errorf("failed to install the new executable (%v) and to restore the current one from %s: %v", err, old, restoreErr)
+		}
+		return installError(dir, err)
+	}
+	return nil
+}
+
+// RemoveOld removes the executable a Windows upgrade moved aside, if any
+func RemoveOld(exe string) {
+	os.Remove(exe + ".old")
+}
+
+// installError explains a failure to write the executable into dir
+func installError(dir string, err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("no permission to replace gta in %s: %w; rerun with the permissions of its owner, e.g. with sudo, or reinstall gta to a directory you own", dir, err)
+	}
+	return fmt.Errorf("failed to replace gta in %s: %w", dir, err)
+}
+
+// PackageManager returns the package manager that installed the executable
+// at exe, along with the command upgrading it, or ok false when gta was not
+// installed by one
+func PackageManager(exe string) (name, command string, ok bool) {
+	slashed := filepath.ToSlash(exe)
+	switch {
+	case strings.Contains(slashed, "/Cellar/") || strings.Contains(slashed, "/homebrew/") || strings.Contains(slashed, "/linuxbrew/"):
+		return "Homebrew", "brew upgrade gta", true
+	case strings.HasPrefix(slashed, "/nix/store/"):
+		return "Nix", "nix profile upgrade gta", true
+	case strings.Contains(slashed, "/scoop/apps/"):
+		return "Scoop", "scoop update gta", true
+	case strings.HasPrefix(slashed, "/snap/"):
+		return "Snap", "snap refresh gta", true
+	}
+	return "", "", false
+}
+
+// Newer reports whether latest is a newer version than current, both tags
+// such as v1.2.3. ok is false when current is not a release version, as is
+// the case of development builds.
+func Newer(current, latest string) (newer, ok bool) {
+	c, okCurrent := parseVersion(current)
+	l, okLatest := parseVersion(latest)
+	if !okCurrent || !okLatest {
+		return false, false
+	}
+	for i := range c {
+		if l[i] != c[i] {
+			return l[i] > c[i], true
+		}
+	}
+	return false, true
+}
+
+// parseVersion splits a release tag such as v1.2.3 into its numbers,
+// refusing pre-release and pseudo-versions
+func parseVersion(tag string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(tag, "v"), ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
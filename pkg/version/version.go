@@ -0,0 +1,20 @@
+// Package version reports the version of gta
+package version
+
+import "runtime/debug"
+
+// Version is the version of gta, set at build time with
+// -ldflags "-X github.com/yckao/gta/pkg/version.Version=v1.2.3"
+var Version = ""
+
+// String returns the version of gta, falling back to the module version
+// recorded by go install and then to "dev"
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
@@ -0,0 +1,56 @@
+// Package workerpool runs tasks over many items with a bounded number of
+// concurrent workers
+package workerpool
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultWorkers is the number of workers used when none is configured
+const DefaultWorkers = 4
+
+// MaxWorkers is the largest number of workers that can be configured
+const MaxWorkers = 64
+
+// Run calls fn for every item from at most workers goroutines and returns
+// once all calls have returned. fn receives the index of the calling worker,
+// from 0 to workers-1, so that callers can keep per-worker state such as API
+// clients. With a single worker, items are handled one at a time in order.
+// No further items are started once ctx is done; fn is expected to pass ctx
+// to the calls it makes so that the items in progress are aborted.
+func Run[T any](ctx context.Context, workers int, items []T, fn func(worker int, item T)) {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	queue := make(chan T)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for item := range queue {
+				// An item may be handed out as ctx is done
+				if ctx.Err() != nil {
+					continue
+				}
+				fn(worker, item)
+			}
+		}(w)
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
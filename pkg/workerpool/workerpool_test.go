@@ -0,0 +1,95 @@
+package workerpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	var mu sync.Mutex
+	seen := make(map[int]int)
+	var running, peak int32
+	Run(context.Background(), 8, items, func(worker, item int) {
+		if worker < 0 || worker >= 8 {
+			t.Errorf("worker %d out of range", worker)
+		}
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		seen[item]++
+		mu.Unlock()
+	})
+	if len(seen) != len(items) {
+		t.Errorf("%d of %d items handled", len(seen), len(items))
+	}
+	for item, n := range seen {
+		if n != 1 {
+			t.Errorf("item %d handled %d times", item, n)
+		}
+	}
+	if peak > 8 {
+		t.Errorf("%d items at once, want at most 8", peak)
+	}
+}
+
+func TestRunOneWorkerInOrder(t *testing.T) {
+	var order []int
+	Run(context.Background(), 1, []int{3, 1, 2}, func(_ int, item int) {
+		order = append(order, item)
+	})
+	if len(order) != 3 || order[0] != 3 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("order = %v, want [3 1 2]", order)
+	}
+	Run(context.Background(), 4, []int(nil), func(int, int) { t.Error("called without items") })
+}
+
+func TestRunCancelled(t *testing.T) {
+	items := make([]int, 50)
+	ctx, cancel := context.WithCancel(context.Background())
+	var started, aborted int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(ctx, 4, items, func(_ int, _ int) {
+			// Every worker is busy with an item when the last one cancels
+			if atomic.AddInt32(&started, 1) == 4 {
+				cancel()
+			}
+			// An item in progress sees the cancellation through ctx
+			select {
+			case <-ctx.Done():
+				atomic.AddInt32(&aborted, 1)
+			case <-time.After(10 * time.Second):
+			}
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the cancellation")
+	}
+	if started != 4 {
+		t.Errorf("%d items started, want no further items after the cancellation", started)
+	}
+	if aborted != started {
+		t.Errorf("%d of %d items aborted", aborted, started)
+	}
+
+	// A context done already starts nothing
+	Run(ctx, 4, items, func(int, int) { t.Error("item started after the cancellation") })
+}